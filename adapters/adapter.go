@@ -21,8 +21,9 @@ type Adapter interface {
 	// Determines whether this adapter should get callouts if there is not a synched user ID
 	SkipNoCookies() bool
 	// GetUsersyncInfo returns the parameters which are needed to do sync users with this bidder.
+	// The privacy signals are substituted into the bidder's usersync URL template.
 	// For more information, see http://clearcode.cc/2015/12/cookie-syncing/
-	GetUsersyncInfo() *pbs.UsersyncInfo
+	GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo
 	// Call produces bids which should be considered, given the auction params.
 	//
 	// In practice, implementations almost always make one call to an external server here.
@@ -38,6 +39,8 @@ type HTTPAdapterConfig struct {
 	MaxConns int
 	// See MaxIdleConnsPerHost on https://golang.org/pkg/net/http/#Transport
 	MaxConnsPerHost int
+	// Failover is optional. If set, requests to its primary endpoint can be moved to its secondary.
+	Failover *EndpointFailover
 }
 
 type HTTPAdapter struct {
@@ -62,10 +65,15 @@ func NewHTTPAdapter(c *HTTPAdapterConfig) *HTTPAdapter {
 		TLSClientConfig:     &tls.Config{RootCAs: ssl.GetRootCAPool()},
 	}
 
+	var next http.RoundTripper = ts
+	if c.Failover != nil {
+		next = &failoverTransport{failover: c.Failover, next: ts}
+	}
+
 	return &HTTPAdapter{
 		Transport: ts,
 		Client: &http.Client{
-			Transport: ts,
+			Transport: &dryRunTransport{next: next},
 		},
 	}
 }
@@ -76,4 +84,5 @@ type CallOneResult struct {
 	ResponseBody string
 	Bid          *pbs.PBSBid
 	Error        error
+	Timing       *CallTiming
 }
@@ -8,21 +8,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
 
 	"github.com/prebid/prebid-server/pbs"
 
-	"golang.org/x/net/context/ctxhttp"
-
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 )
 
 type AppNexusAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
 }
 
 /* Name - export adapter name */
@@ -35,8 +33,8 @@ func (a *AppNexusAdapter) FamilyName() string {
 	return "adnxs"
 }
 
-func (a *AppNexusAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *AppNexusAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *AppNexusAdapter) SkipNoCookies() bool {
@@ -69,7 +67,7 @@ type appnexusImpExt struct {
 }
 
 func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
-	supportedMediaTypes := []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_VIDEO}
+	supportedMediaTypes := []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_VIDEO, pbs.MEDIA_TYPE_NATIVE}
 	anReq, err := adapters.MakeOpenRTBGeneric(req, bidder, a.FamilyName(), supportedMediaTypes, true)
 
 	if err != nil {
@@ -95,8 +93,11 @@ func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 			}
 
 		}
-		if params.Reserve > 0 {
-			anReq.Imp[i].BidFloor = params.Reserve // TODO: we need to factor in currency here if non-USD
+		// The reserve is in USD. It only replaces the ad unit's floor if that is lower. Floors are converted to USD
+		// before the auction, so they're only in another currency if there were no rates to convert them with.
+		if params.Reserve > anReq.Imp[i].BidFloor && (anReq.Imp[i].BidFloorCur == "" || anReq.Imp[i].BidFloorCur == "USD") {
+			anReq.Imp[i].BidFloor = params.Reserve
+			anReq.Imp[i].BidFloorCur = "USD"
 		}
 		if anReq.Imp[i].Banner != nil && params.Position != "" {
 			if params.Position == "above" {
@@ -125,7 +126,7 @@ func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 			TrafficSourceCode: params.TrafficSourceCode,
 			Keywords:          keywordStr,
 		}}
-		anReq.Imp[i].Ext, err = json.Marshal(&impExt)
+		err = adapters.SetImpExt(&anReq.Imp[i], &impExt)
 	}
 
 	reqJSON, err := json.Marshal(anReq)
@@ -146,7 +147,8 @@ func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
 	httpReq.Header.Add("Accept", "application/json")
 
-	anResp, err := ctxhttp.Do(ctx, a.http.Client, httpReq)
+	anResp, timing, err := a.http.DoRequest(ctx, httpReq)
+	debug.Timing = timing.Debug()
 	if err != nil {
 		return nil, err
 	}
@@ -175,7 +177,10 @@ func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 	var bidResp openrtb.BidResponse
 	err = json.Unmarshal(body, &bidResp)
 	if err != nil {
-		return nil, err
+		return nil, &errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: body,
+		}
 	}
 
 	bids := make(pbs.PBSBidSlice, 0)
@@ -191,20 +196,25 @@ func (a *AppNexusAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 			}
 
 			pbid := pbs.PBSBid{
-				BidID:       bidID,
-				AdUnitCode:  bid.ImpID,
-				BidderCode:  bidder.BidderCode,
-				Price:       bid.Price,
-				Adm:         bid.AdM,
-				Creative_id: bid.CrID,
-				Width:       bid.W,
-				Height:      bid.H,
-				DealId:      bid.DealID,
-				NURL:        bid.NURL,
+				BidID:         bidID,
+				AdUnitCode:    bid.ImpID,
+				BidderCode:    bidder.BidderCode,
+				Price:         bid.Price,
+				Adm:           bid.AdM,
+				BURL:          bid.BURL,
+				BidResponseID: bidResp.BidID,
+				Creative_id:   bid.CrID,
+				Width:         bid.W,
+				Height:        bid.H,
+				DealId:        bid.DealID,
+				NURL:          bid.NURL,
 			}
 
 			mediaType := getMediaTypeForImp(bid.ImpID, anReq.Imp)
 			pbid.CreativeMediaType = mediaType
+			pbid.Exp = bid.Exp
+			pbid.Meta = adapters.MakeBidMeta(&bid, mediaType)
+			pbid.Ext = adapters.MakeBidExt(&bid, bidResp.Cur)
 			bids = append(bids, &pbid)
 		}
 	}
@@ -217,6 +227,8 @@ func getMediaTypeForImp(impId string, imps []openrtb.Imp) string {
 		if imp.ID == impId {
 			if imp.Video != nil {
 				mediaType = "video"
+			} else if imp.Native != nil {
+				mediaType = "native"
 			}
 			return mediaType
 		}
@@ -224,21 +236,19 @@ func getMediaTypeForImp(impId string, imps []openrtb.Imp) string {
 	return mediaType
 }
 
-func NewAppNexusAdapter(config *adapters.HTTPAdapterConfig, externalURL string) *AppNexusAdapter {
+func NewAppNexusAdapter(config *adapters.HTTPAdapterConfig, uri string, usersyncURL string, externalURL string) *AppNexusAdapter {
 	a := adapters.NewHTTPAdapter(config)
 
-	redirect_uri := fmt.Sprintf("%s/setuid?bidder=adnxs&uid=$UID", externalURL)
-	usersyncURL := "//ib.adnxs.com/getuid?"
-
-	info := &pbs.UsersyncInfo{
-		URL:         fmt.Sprintf("%s%s", usersyncURL, url.QueryEscape(redirect_uri)),
+	info := &pbs.UsersyncTemplate{
+		URL:         usersyncURL,
+		RedirectURL: externalURL + "/setuid?bidder=adnxs&uid=$UID",
 		Type:        "redirect",
 		SupportCORS: false,
 	}
 
 	return &AppNexusAdapter{
-		http:         a,
-		URI:          "http://ib.adnxs.com/openrtb2",
-		usersyncInfo: info,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
 	}
 }
@@ -264,7 +264,7 @@ func TestAppNexusBasicResponse(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewAppNexusAdapter(&conf, server.URL)
+	an := NewAppNexusAdapter(&conf, server.URL, "//ib.adnxs.com/getuid?{{redirect_url}}", server.URL)
 	an.URI = server.URL
 
 	pbin := pbs.PBSRequest{
@@ -327,7 +327,7 @@ func TestAppNexusBasicResponse(t *testing.T) {
 	req.Header.Add("User-Agent", andata.deviceUA)
 	req.Header.Add("X-Real-IP", andata.deviceIP)
 
-	pc := pbs.ParsePBSCookieFromRequest(req)
+	pc := pbs.ParsePBSCookieFromRequest(req, nil)
 	pc.TrySync("adnxs", andata.buyerUID)
 	fakewriter := httptest.NewRecorder()
 	pc.SetCookieOnResponse(fakewriter, "")
@@ -391,14 +391,15 @@ func TestAppNexusBasicResponse(t *testing.T) {
 }
 
 func TestAppNexusUserSyncInfo(t *testing.T) {
-	an := NewAppNexusAdapter(adapters.DefaultHTTPAdapterConfig, "localhost")
-	if an.usersyncInfo.URL != "//ib.adnxs.com/getuid?localhost%2Fsetuid%3Fbidder%3Dadnxs%26uid%3D%24UID" {
+	an := NewAppNexusAdapter(adapters.DefaultHTTPAdapterConfig, "http://ib.adnxs.com/openrtb2", "//ib.adnxs.com/getuid?{{redirect_url}}", "localhost")
+	usersyncInfo := an.GetUsersyncInfo(pbs.UsersyncPrivacy{})
+	if usersyncInfo.URL != "//ib.adnxs.com/getuid?localhost%2Fsetuid%3Fbidder%3Dadnxs%26uid%3D%24UID" {
 		t.Fatalf("should have matched")
 	}
-	if an.usersyncInfo.Type != "redirect" {
+	if usersyncInfo.Type != "redirect" {
 		t.Fatalf("should be redirect")
 	}
-	if an.usersyncInfo.SupportCORS != false {
+	if usersyncInfo.SupportCORS != false {
 		t.Fatalf("should have been false")
 	}
 
@@ -0,0 +1,109 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// MultiImpAdapter is implemented by adapters which send one request per ad unit from Call,
+// but whose bidder also accepts requests with several imps.
+type MultiImpAdapter interface {
+	Adapter
+	// CallMultiImp sends a single request with an imp for each of the bidder's ad units.
+	CallMultiImp(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error)
+}
+
+// CallBatched groups the bidder's ad units by their params. Each group of two or more ad units
+// with identical params is sent in one CallMultiImp request, and any other ad units go through Call.
+//
+// This cuts down the number of requests when a publisher puts the same bidder config on many ad units.
+func CallBatched(ctx context.Context, ex MultiImpAdapter, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	groups := groupAdUnits(bidder.AdUnits)
+	if len(groups) == len(bidder.AdUnits) {
+		return ex.Call(ctx, req, bidder)
+	}
+
+	var singles []pbs.PBSAdUnit
+	var batches []*pbs.PBSBidder
+	for _, group := range groups {
+		if len(group) == 1 {
+			singles = append(singles, group[0])
+		} else {
+			batches = append(batches, subBidder(bidder, group))
+		}
+	}
+
+	type result struct {
+		bidder *pbs.PBSBidder
+		bids   pbs.PBSBidSlice
+		err    error
+	}
+	ch := make(chan result)
+	for _, batch := range batches {
+		go func(batch *pbs.PBSBidder) {
+			defer RecoverPanic(batch.BidderCode, func(err error) {
+				ch <- result{batch, nil, err}
+			})
+			bids, err := ex.CallMultiImp(ctx, req, batch)
+			ch <- result{batch, bids, err}
+		}(batch)
+	}
+	calls := len(batches)
+	if len(singles) > 0 {
+		calls++
+		go func(single *pbs.PBSBidder) {
+			defer RecoverPanic(single.BidderCode, func(err error) {
+				ch <- result{single, nil, err}
+			})
+			bids, err := ex.Call(ctx, req, single)
+			ch <- result{single, bids, err}
+		}(subBidder(bidder, singles))
+	}
+
+	var err error
+	bids := make(pbs.PBSBidSlice, 0)
+	for i := 0; i < calls; i++ {
+		r := <-ch
+		bids = append(bids, r.bids...)
+		bidder.Debug = append(bidder.Debug, r.bidder.Debug...)
+		if r.err != nil {
+			err = r.err
+		}
+	}
+
+	if len(bids) == 0 {
+		return nil, err
+	}
+	return bids, nil
+}
+
+// groupAdUnits splits the ad units into groups with identical params, keeping the order in which they first appear.
+func groupAdUnits(units []pbs.PBSAdUnit) [][]pbs.PBSAdUnit {
+	var groups [][]pbs.PBSAdUnit
+	index := make(map[string]int, len(units))
+	for _, unit := range units {
+		var key bytes.Buffer
+		if err := json.Compact(&key, unit.Params); err != nil {
+			key.Reset()
+			key.Write(unit.Params)
+		}
+		if i, ok := index[key.String()]; ok {
+			groups[i] = append(groups[i], unit)
+			continue
+		}
+		index[key.String()] = len(groups)
+		groups = append(groups, []pbs.PBSAdUnit{unit})
+	}
+	return groups
+}
+
+// subBidder copies the bidder, but with only the given ad units and no debug info.
+func subBidder(bidder *pbs.PBSBidder, units []pbs.PBSAdUnit) *pbs.PBSBidder {
+	sub := *bidder
+	sub.AdUnits = units
+	sub.Debug = nil
+	return &sub
+}
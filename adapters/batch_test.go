@@ -0,0 +1,136 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// batchingAdapter bids once for every ad unit, and remembers how each was requested.
+type batchingAdapter struct {
+	lock    sync.Mutex
+	single  [][]string
+	batched [][]string
+}
+
+func (a *batchingAdapter) Name() string        { return "batching" }
+func (a *batchingAdapter) FamilyName() string  { return "batching" }
+func (a *batchingAdapter) SkipNoCookies() bool { return false }
+func (a *batchingAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return nil
+}
+
+func (a *batchingAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	a.lock.Lock()
+	a.single = append(a.single, codes(bidder))
+	a.lock.Unlock()
+	return bids(bidder), nil
+}
+
+func (a *batchingAdapter) CallMultiImp(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	a.lock.Lock()
+	a.batched = append(a.batched, codes(bidder))
+	a.lock.Unlock()
+	bidder.Debug = append(bidder.Debug, &pbs.BidderDebug{RequestURI: "batch"})
+	return bids(bidder), nil
+}
+
+func codes(bidder *pbs.PBSBidder) []string {
+	codes := make([]string, len(bidder.AdUnits))
+	for i, unit := range bidder.AdUnits {
+		codes[i] = unit.Code
+	}
+	return codes
+}
+
+func bids(bidder *pbs.PBSBidder) pbs.PBSBidSlice {
+	bids := make(pbs.PBSBidSlice, len(bidder.AdUnits))
+	for i, unit := range bidder.AdUnits {
+		bids[i] = &pbs.PBSBid{AdUnitCode: unit.Code, BidID: bidder.LookupBidID(unit.Code)}
+	}
+	return bids
+}
+
+func TestCallBatched(t *testing.T) {
+	bidder := &pbs.PBSBidder{
+		BidderCode: "batching",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "a", BidID: "1", Params: json.RawMessage(`{"tag": "x"}`)},
+			{Code: "b", BidID: "2", Params: json.RawMessage(`{"tag":"y"}`)},
+			{Code: "c", BidID: "3", Params: json.RawMessage(`{"tag":"x"}`)},
+		},
+	}
+	adapter := &batchingAdapter{}
+	bids, err := CallBatched(context.Background(), adapter, &pbs.PBSRequest{}, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bids) != 3 {
+		t.Errorf("Expected a bid for every ad unit. Got %d", len(bids))
+	}
+	if len(adapter.batched) != 1 || len(adapter.batched[0]) != 2 || adapter.batched[0][0] != "a" || adapter.batched[0][1] != "c" {
+		t.Errorf("Ad units a and c should be batched. Got %v", adapter.batched)
+	}
+	if len(adapter.single) != 1 || len(adapter.single[0]) != 1 || adapter.single[0][0] != "b" {
+		t.Errorf("Ad unit b should go through Call. Got %v", adapter.single)
+	}
+	if len(bidder.Debug) != 1 {
+		t.Errorf("Debug info from the batch should be kept. Got %d", len(bidder.Debug))
+	}
+	if len(bidder.AdUnits) != 3 {
+		t.Errorf("The bidder's ad units shouldn't change")
+	}
+}
+
+func TestCallBatchedNothingToBatch(t *testing.T) {
+	bidder := &pbs.PBSBidder{
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "a", Params: json.RawMessage(`{"tag":"x"}`)},
+			{Code: "b", Params: json.RawMessage(`{"tag":"y"}`)},
+		},
+	}
+	adapter := &batchingAdapter{}
+	if _, err := CallBatched(context.Background(), adapter, &pbs.PBSRequest{}, bidder); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(adapter.batched) != 0 || len(adapter.single) != 1 || len(adapter.single[0]) != 2 {
+		t.Errorf("Ad units with different params should all go through one Call. Got %v and %v", adapter.single, adapter.batched)
+	}
+}
+
+// panickingBatchAdapter panics on batched requests.
+type panickingBatchAdapter struct {
+	batchingAdapter
+}
+
+func (a *panickingBatchAdapter) CallMultiImp(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	var bids pbs.PBSBidSlice
+	return pbs.PBSBidSlice{bids[0]}, nil
+}
+
+func TestCallBatchedRecoversPanics(t *testing.T) {
+	bidder := &pbs.PBSBidder{
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "a", BidID: "1", Params: json.RawMessage(`{"tag":"x"}`)},
+			{Code: "b", BidID: "2", Params: json.RawMessage(`{"tag":"y"}`)},
+			{Code: "c", BidID: "3", Params: json.RawMessage(`{"tag":"x"}`)},
+		},
+	}
+	bids, err := CallBatched(context.Background(), &panickingBatchAdapter{}, &pbs.PBSRequest{}, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bids) != 1 || bids[0].AdUnitCode != "b" {
+		t.Errorf("The ad unit which wasn't batched should still get its bid. Got %v", bids)
+	}
+
+	bidder.AdUnits = []pbs.PBSAdUnit{bidder.AdUnits[0], bidder.AdUnits[2]}
+	if _, err := CallBatched(context.Background(), &panickingBatchAdapter{}, &pbs.PBSRequest{}, bidder); err == nil {
+		t.Errorf("Expected the panic's error")
+	} else if _, ok := err.(*PanicError); !ok {
+		t.Errorf("Expected a PanicError. Got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// Bidder is a simpler contract for demand partners than Adapter. Bidders only translate between OpenRTB
+// and their exchange's format. The core sends their HTTP requests, so timeouts, retries, dry runs and
+// debug output work the same way for all of them.
+type Bidder interface {
+	// MakeRequests builds the HTTP calls for the request. Each imp's ext is {"bidder": params}, with the
+	// params from the ad unit.
+	//
+	// Errors are returned alongside any requests which could still be made.
+	MakeRequests(request *openrtb.BidRequest) ([]*RequestData, []error)
+	// MakeBids unpacks the response to one of the calls from MakeRequests. It's only called for 200 responses.
+	// 204s are treated as no bid, and any other status as an error.
+	MakeBids(request *openrtb.BidRequest, response *ResponseData) (*BidderResponse, []error)
+}
+
+// ResponseData is a bidder's answer to one RequestData.
+type ResponseData struct {
+	StatusCode int
+	Body       []byte
+	Headers    http.Header
+}
+
+// BidderResponse holds the bids from one response.
+type BidderResponse struct {
+	// ID is the bidder's ID for the response, if any.
+	ID string
+	// Currency defaults to USD.
+	Currency string
+	Bids     []*TypedBid
+}
+
+// TypedBid is a bid, and the type of creative it's for.
+type TypedBid struct {
+	Bid     *openrtb.Bid
+	BidType pbs.MediaType
+	// BidVideo is optional. Video bidders should set it, since OpenRTB 2.5 bids don't have a duration.
+	BidVideo *BidVideo
+}
+
+// BidVideo has the details of a video bid which the /openrtb2/video endpoint needs for ad pods.
+type BidVideo struct {
+	// Duration is the length of the creative, in seconds.
+	Duration int
+	// PrimaryCategory is the creative's main IAB category. If it's empty, the first of the bid's cat is used.
+	PrimaryCategory string
+}
+
+// impExt is the imp.ext which Bidders get.
+type impExt struct {
+	Bidder json.RawMessage `json:"bidder"`
+}
+
+// CallBidder runs a Bidder for the legacy auction. The request should come from MakeOpenRTBGeneric, with the
+// Bidder's media types.
+//
+// If any bids come back, errors from the bidder's other calls are logged and dropped. Otherwise, the first
+// error is returned.
+func CallBidder(ctx context.Context, client *HTTPAdapter, b Bidder, req *pbs.PBSRequest, bidder *pbs.PBSBidder, ortbReq *openrtb.BidRequest) (pbs.PBSBidSlice, error) {
+	if err := addBidderParams(ortbReq, bidder); err != nil {
+		return nil, err
+	}
+	responses, debug, errs := RunBidder(ctx, client, b, ortbReq)
+	if req.IsDebug {
+		bidder.Debug = append(bidder.Debug, debug...)
+	}
+
+	bids := make(pbs.PBSBidSlice, 0)
+	for _, response := range responses {
+		for _, typedBid := range response.Bids {
+			bid, err := toPBSBid(bidder, response, typedBid)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			bids = append(bids, bid)
+		}
+	}
+
+	if len(errs) == 0 {
+		return bids, nil
+	}
+	if len(bids) == 0 {
+		return nil, errs[0]
+	}
+	if glog.V(2) {
+		glog.Infof("Kept %d bids from %s despite errors: %v", len(bids), bidder.BidderCode, errs)
+	}
+	return bids, nil
+}
+
+// RunBidder sends the Bidder's calls for the request in parallel, and unpacks the responses. Calls which
+// got no bid have no response. The debug info has an entry for every call which was made.
+func RunBidder(ctx context.Context, client *HTTPAdapter, b Bidder, ortbReq *openrtb.BidRequest) ([]*BidderResponse, []*pbs.BidderDebug, []error) {
+	calls, errs := b.MakeRequests(ortbReq)
+
+	type callResult struct {
+		debug    *pbs.BidderDebug
+		response *BidderResponse
+		errs     []error
+	}
+	results := make(chan callResult, len(calls))
+	for _, call := range calls {
+		go func(call *RequestData) {
+			debug := &pbs.BidderDebug{
+				RequestURI:  call.URI,
+				RequestBody: string(call.Body),
+			}
+			// MakeBids runs in this goroutine, so its panics have to be recovered here.
+			defer RecoverPanic(fmt.Sprintf("%T", b), func(err error) {
+				results <- callResult{debug: debug, errs: []error{err}}
+			})
+			response, callErrs := doCall(ctx, client, b, ortbReq, call, debug)
+			results <- callResult{debug: debug, response: response, errs: callErrs}
+		}(call)
+	}
+
+	var responses []*BidderResponse
+	debug := make([]*pbs.BidderDebug, 0, len(calls))
+	for range calls {
+		result := <-results
+		debug = append(debug, result.debug)
+		errs = append(errs, result.errs...)
+		if result.response != nil {
+			responses = append(responses, result.response)
+		}
+	}
+	return responses, debug, errs
+}
+
+// doCall sends one request, and fills in its debug info. It returns a nil response for no bid.
+func doCall(ctx context.Context, client *HTTPAdapter, b Bidder, ortbReq *openrtb.BidRequest, call *RequestData, debug *pbs.BidderDebug) (*BidderResponse, []error) {
+	httpResp, timing, err := client.Do(ctx, call)
+	debug.Timing = timing.Debug()
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, []error{err}
+	}
+	debug.StatusCode = httpResp.StatusCode
+	debug.ResponseBody = string(body)
+
+	if httpResp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, []error{fmt.Errorf("HTTP status: %d", httpResp.StatusCode)}
+	}
+	return b.MakeBids(ortbReq, &ResponseData{
+		StatusCode: httpResp.StatusCode,
+		Body:       body,
+		Headers:    httpResp.Header,
+	})
+}
+
+// addBidderParams sets each imp's ext to the params from its ad unit, alongside the ad unit's gpid and pbadslot.
+func addBidderParams(ortbReq *openrtb.BidRequest, bidder *pbs.PBSBidder) error {
+	params := make(map[string]json.RawMessage, len(bidder.AdUnits))
+	for _, unit := range bidder.AdUnits {
+		params[unit.Code] = unit.Params
+	}
+	for i := range ortbReq.Imp {
+		if err := SetImpExt(&ortbReq.Imp[i], impExt{Bidder: params[ortbReq.Imp[i].ID]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toPBSBid(bidder *pbs.PBSBidder, response *BidderResponse, typedBid *TypedBid) (*pbs.PBSBid, error) {
+	bid := typedBid.Bid
+	bidID := bidder.LookupBidID(bid.ImpID)
+	if bidID == "" {
+		return nil, fmt.Errorf("Unknown ad unit code '%s'", bid.ImpID)
+	}
+	mediaType := "banner"
+	switch typedBid.BidType {
+	case pbs.MEDIA_TYPE_VIDEO:
+		mediaType = "video"
+	case pbs.MEDIA_TYPE_NATIVE:
+		mediaType = "native"
+	}
+	return &pbs.PBSBid{
+		BidID:             bidID,
+		AdUnitCode:        bid.ImpID,
+		BidderCode:        bidder.BidderCode,
+		Price:             bid.Price,
+		Adm:               bid.AdM,
+		NURL:              bid.NURL,
+		BURL:              bid.BURL,
+		BidResponseID:     response.ID,
+		Creative_id:       bid.CrID,
+		CreativeMediaType: mediaType,
+		Width:             bid.W,
+		Height:            bid.H,
+		DealId:            bid.DealID,
+		Exp:               bid.Exp,
+		Meta:              MakeBidMeta(bid, mediaType),
+		Ext:               MakeBidExt(bid, response.Currency),
+	}, nil
+}
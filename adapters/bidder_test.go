@@ -0,0 +1,141 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// getBidder sends one GET per imp, with the imp's "tag" param in the query string, and bids 1.0 on each.
+type getBidder struct {
+	uri string
+}
+
+func (b *getBidder) MakeRequests(request *openrtb.BidRequest) ([]*RequestData, []error) {
+	var calls []*RequestData
+	var errs []error
+	for _, imp := range request.Imp {
+		var ext struct {
+			Bidder struct {
+				Tag string `json:"tag"`
+			} `json:"bidder"`
+		}
+		if err := json.Unmarshal(imp.Ext, &ext); err != nil || ext.Bidder.Tag == "" {
+			errs = append(errs, errors.New("Missing tag"))
+			continue
+		}
+		calls = append(calls, &RequestData{
+			Method: "GET",
+			URI:    b.uri,
+			Query:  url.Values{"tag": []string{ext.Bidder.Tag}, "imp": []string{imp.ID}},
+		})
+	}
+	return calls, errs
+}
+
+func (b *getBidder) MakeBids(request *openrtb.BidRequest, response *ResponseData) (*BidderResponse, []error) {
+	var bid openrtb.Bid
+	if err := json.Unmarshal(response.Body, &bid); err != nil {
+		return nil, []error{err}
+	}
+	return &BidderResponse{Currency: "EUR", Bids: []*TypedBid{{Bid: &bid, BidType: pbs.MEDIA_TYPE_VIDEO}}}, nil
+}
+
+func TestCallBidder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("tag") == "passback" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(&openrtb.Bid{ImpID: r.URL.Query().Get("imp"), Price: 1, W: 640, H: 480})
+	}))
+	defer server.Close()
+
+	bidder := &pbs.PBSBidder{
+		BidderCode: "getter",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "first", BidID: "1", Params: json.RawMessage(`{"tag":"abc"}`)},
+			{Code: "second", BidID: "2", Params: json.RawMessage(`{"tag":"passback"}`)},
+			{Code: "third", BidID: "3", Params: json.RawMessage(`{}`)},
+		},
+	}
+	ortbReq := &openrtb.BidRequest{Imp: []openrtb.Imp{{ID: "first"}, {ID: "second"}, {ID: "third"}}}
+	bids, err := CallBidder(context.Background(), NewHTTPAdapter(DefaultHTTPAdapterConfig), &getBidder{uri: server.URL}, &pbs.PBSRequest{IsDebug: true}, bidder, ortbReq)
+	if err != nil {
+		t.Fatalf("Errors shouldn't be returned if there are bids. Got %v", err)
+	}
+	if len(bids) != 1 {
+		t.Fatalf("Expected 1 bid. Got %d", len(bids))
+	}
+	bid := bids[0]
+	if bid.BidID != "1" || bid.AdUnitCode != "first" || bid.BidderCode != "getter" || bid.CreativeMediaType != "video" {
+		t.Errorf("Bad bid: %#v", bid)
+	}
+	if bid.Ext == nil || bid.Ext.OrigBidCur != "EUR" {
+		t.Errorf("The bid should keep its currency. Got %#v", bid.Ext)
+	}
+	if len(bidder.Debug) != 2 {
+		t.Fatalf("Expected debug info for both calls. Got %d", len(bidder.Debug))
+	}
+	for _, debug := range bidder.Debug {
+		if debug.StatusCode == 0 || debug.Timing == nil {
+			t.Errorf("Bad debug info: %#v", debug)
+		}
+	}
+}
+
+func TestCallBidderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	bidder := &pbs.PBSBidder{
+		BidderCode: "getter",
+		AdUnits:    []pbs.PBSAdUnit{{Code: "first", BidID: "1", Params: json.RawMessage(`{"tag":"abc"}`)}},
+	}
+	ortbReq := &openrtb.BidRequest{Imp: []openrtb.Imp{{ID: "first"}}}
+	bids, err := CallBidder(context.Background(), NewHTTPAdapter(DefaultHTTPAdapterConfig), &getBidder{uri: server.URL}, &pbs.PBSRequest{}, bidder, ortbReq)
+	if err == nil || err.Error() != "HTTP status: 400" {
+		t.Errorf("Expected the HTTP status error. Got %v", err)
+	}
+	if len(bids) != 0 || len(bidder.Debug) != 0 {
+		t.Errorf("Expected no bids or debug info. Got %d bids and %d debug entries", len(bids), len(bidder.Debug))
+	}
+}
+
+// panickingBidder panics while unpacking its response.
+type panickingBidder struct {
+	getBidder
+}
+
+func (b *panickingBidder) MakeBids(request *openrtb.BidRequest, response *ResponseData) (*BidderResponse, []error) {
+	var bids []*TypedBid
+	return &BidderResponse{Bids: []*TypedBid{bids[0]}}, nil
+}
+
+func TestRunBidderRecoversPanics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	ortbReq := &openrtb.BidRequest{Imp: []openrtb.Imp{{ID: "first", Ext: json.RawMessage(`{"bidder":{"tag":"abc"}}`)}}}
+	responses, _, errs := RunBidder(context.Background(), NewHTTPAdapter(DefaultHTTPAdapterConfig), &panickingBidder{getBidder{uri: server.URL}}, ortbReq)
+	if len(responses) != 0 {
+		t.Errorf("Expected no responses. Got %d", len(responses))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected the panic's error. Got %v", errs)
+	}
+	if _, ok := errs[0].(*PanicError); !ok {
+		t.Errorf("Expected a PanicError. Got %v", errs[0])
+	}
+}
@@ -0,0 +1,255 @@
+package conversant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/pbs"
+	"golang.org/x/net/publicsuffix"
+)
+
+type ConversantAdapter struct {
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
+}
+
+// Name - export adapter name
+func (a *ConversantAdapter) Name() string {
+	return "Conversant"
+}
+
+// used for cookies and such
+func (a *ConversantAdapter) FamilyName() string {
+	return "conversant"
+}
+
+func (a *ConversantAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
+}
+
+func (a *ConversantAdapter) SkipNoCookies() bool {
+	return false
+}
+
+// conversantParams are the params for the Conversant adapter. Only site_id is required. The rest fill in
+// imp fields which the publisher's ad unit can't express.
+type conversantParams struct {
+	SiteID      string   `json:"site_id"`
+	Secure      *int8    `json:"secure"`
+	TagID       string   `json:"tag_id"`
+	Position    *int8    `json:"position"`
+	BidFloor    float64  `json:"bidfloor"`
+	Mobile      *int8    `json:"mobile"`
+	MIMEs       []string `json:"mimes"`
+	API         []int8   `json:"api"`
+	Protocols   []int8   `json:"protocols"`
+	MaxDuration *int64   `json:"maxduration"`
+	Skip        *int8    `json:"skip"`
+	SkipMin     *int64   `json:"skipmin"`
+	SkipAfter   *int64   `json:"skipafter"`
+	// Domain replaces the site's domain, for publishers whose Conversant site ID is registered under a
+	// different domain than the one their pages are served from.
+	Domain string `json:"domain"`
+}
+
+func (a *ConversantAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	mediaTypes := []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_VIDEO}
+	cnvrReq, err := adapters.MakeOpenRTBGeneric(req, bidder, a.FamilyName(), mediaTypes, true)
+	if err != nil {
+		return nil, err
+	}
+	return adapters.CallBidder(ctx, a.http, a, req, bidder, &cnvrReq)
+}
+
+// MakeRequests sends every imp in one request. The site or app ID comes from the params, so an ad unit with
+// bad params fails all of them.
+func (a *ConversantAdapter) MakeRequests(cnvrReq *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	// The site and app may be shared with other bidders' requests, so they're copied before they're changed.
+	if cnvrReq.Site != nil {
+		siteCopy := *cnvrReq.Site
+		cnvrReq.Site = &siteCopy
+	}
+	if cnvrReq.App != nil {
+		appCopy := *cnvrReq.App
+		cnvrReq.App = &appCopy
+	}
+
+	for i := range cnvrReq.Imp {
+		imp := &cnvrReq.Imp[i]
+		var ext struct {
+			Bidder conversantParams `json:"bidder"`
+		}
+		if err := json.Unmarshal(imp.Ext, &ext); err != nil {
+			return nil, []error{&errortypes.BadInput{Message: fmt.Sprintf("Invalid params for ad unit %s: %v", imp.ID, err)}}
+		}
+		params := ext.Bidder
+
+		if params.SiteID != "" {
+			if cnvrReq.Site != nil {
+				cnvrReq.Site.ID = params.SiteID
+			}
+			if cnvrReq.App != nil {
+				cnvrReq.App.ID = params.SiteID
+			}
+		}
+		if params.Mobile != nil && cnvrReq.Site != nil {
+			cnvrReq.Site.Mobile = *params.Mobile
+		}
+		if params.Domain != "" && cnvrReq.Site != nil {
+			cnvrReq.Site.Domain = params.Domain
+		}
+
+		imp.DisplayManager = "prebid-s2s"
+		imp.DisplayManagerVer = "1.0.1"
+		imp.TagID = params.TagID
+		if params.BidFloor > 0 {
+			imp.BidFloor = params.BidFloor
+			imp.BidFloorCur = "USD"
+		}
+
+		var position *openrtb.AdPosition
+		if params.Position != nil {
+			position = openrtb.AdPosition(*params.Position).Ptr()
+		}
+		if imp.Banner != nil {
+			imp.Banner.Pos = position
+		} else if imp.Video != nil {
+			imp.Video.Pos = position
+			if len(params.API) > 0 {
+				imp.Video.API = make([]openrtb.APIFramework, len(params.API))
+				for j, api := range params.API {
+					imp.Video.API[j] = openrtb.APIFramework(api)
+				}
+			}
+			if len(params.MIMEs) > 0 {
+				imp.Video.MIMEs = params.MIMEs
+			}
+			if len(params.Protocols) > 0 {
+				imp.Video.Protocols = make([]openrtb.Protocol, len(params.Protocols))
+				for j, protocol := range params.Protocols {
+					imp.Video.Protocols[j] = openrtb.Protocol(protocol)
+				}
+			}
+			if params.MaxDuration != nil {
+				imp.Video.MaxDuration = *params.MaxDuration
+			}
+			if params.Skip != nil {
+				imp.Video.Skip = params.Skip
+			}
+			if params.SkipMin != nil {
+				imp.Video.SkipMin = *params.SkipMin
+			}
+			if params.SkipAfter != nil {
+				imp.Video.SkipAfter = *params.SkipAfter
+			}
+		}
+
+		// The request's own secure flag takes precedence.
+		if (imp.Secure == nil || *imp.Secure == 0) && params.Secure != nil {
+			imp.Secure = params.Secure
+		}
+		if err := adapters.SetImpExt(imp, nil); err != nil {
+			return nil, []error{err}
+		}
+	}
+
+	if cnvrReq.Site != nil && cnvrReq.Site.ID == "" {
+		return nil, []error{&errortypes.BadInput{Message: "Missing site id"}}
+	}
+	// Conversant rejects site IDs whose domain doesn't match, so the domain is filled in from the page if the
+	// request left it out.
+	if cnvrReq.Site != nil && cnvrReq.Site.Domain == "" {
+		cnvrReq.Site.Domain = pageDomain(cnvrReq.Site.Page)
+	}
+	if cnvrReq.App != nil && cnvrReq.App.ID == "" {
+		return nil, []error{&errortypes.BadInput{Message: "Missing app id"}}
+	}
+
+	reqJSON, err := json.Marshal(cnvrReq)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return []*adapters.RequestData{{
+		Method:  "POST",
+		URI:     a.URI,
+		Body:    reqJSON,
+		Headers: http.Header{"Accept": []string{"application/json"}},
+	}}, nil
+}
+
+// pageDomain returns the registrable domain of the page's URL, or "" if it has none.
+func pageDomain(page string) string {
+	pageURL, err := url.Parse(page)
+	if err != nil || pageURL.Hostname() == "" {
+		return ""
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(pageURL.Hostname())
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+func (a *ConversantAdapter) MakeBids(cnvrReq *openrtb.BidRequest, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	var bidResp openrtb.BidResponse
+	if err := json.Unmarshal(response.Body, &bidResp); err != nil {
+		return nil, []error{&errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: response.Body,
+		}}
+	}
+
+	videoImps := make(map[string]bool, len(cnvrReq.Imp))
+	for _, imp := range cnvrReq.Imp {
+		videoImps[imp.ID] = imp.Banner == nil && imp.Video != nil
+	}
+
+	bids := &adapters.BidderResponse{
+		ID:       bidResp.BidID,
+		Currency: bidResp.Cur,
+	}
+	var errs []error
+	for _, sb := range bidResp.SeatBid {
+		for i := range sb.Bid {
+			bid := &sb.Bid[i]
+			isVideo, ok := videoImps[bid.ImpID]
+			if !ok {
+				errs = append(errs, &errortypes.BadServerResponse{Message: fmt.Sprintf("Unknown ad unit code '%s'", bid.ImpID)})
+				continue
+			}
+			bidType := pbs.MEDIA_TYPE_BANNER
+			if isVideo {
+				bidType = pbs.MEDIA_TYPE_VIDEO
+			}
+			bids.Bids = append(bids.Bids, &adapters.TypedBid{
+				Bid:     bid,
+				BidType: bidType,
+			})
+		}
+	}
+	return bids, errs
+}
+
+func NewConversantAdapter(config *adapters.HTTPAdapterConfig, uri string, usersyncURL string, externalURL string) *ConversantAdapter {
+	a := adapters.NewHTTPAdapter(config)
+
+	info := &pbs.UsersyncTemplate{
+		URL:         usersyncURL,
+		RedirectURL: externalURL + "/setuid?bidder=conversant&uid=",
+		Type:        "redirect",
+		SupportCORS: false,
+	}
+
+	return &ConversantAdapter{
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
+	}
+}
@@ -0,0 +1,209 @@
+package conversant
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+func newTestAdapter(uri string) *ConversantAdapter {
+	return NewConversantAdapter(adapters.DefaultHTTPAdapterConfig, uri, "http://prebid-match.dotomi.com/prebid/match?rurl={{redirect_url}}", "http://localhost")
+}
+
+func impWithParams(id string, params string) openrtb.Imp {
+	return openrtb.Imp{
+		ID:     id,
+		Banner: &openrtb.Banner{W: openrtb.Uint64Ptr(300), H: openrtb.Uint64Ptr(250)},
+		Ext:    openrtb.RawJSON(`{"bidder":` + params + `}`),
+	}
+}
+
+func TestConversantUserSyncInfo(t *testing.T) {
+	info := newTestAdapter("http://localhost/bid").GetUsersyncInfo(pbs.UsersyncPrivacy{})
+	if info.Type != "redirect" {
+		t.Errorf("Expected a redirect sync. Got %s", info.Type)
+	}
+	if info.URL != "http://prebid-match.dotomi.com/prebid/match?rurl=http%3A%2F%2Flocalhost%2Fsetuid%3Fbidder%3Dconversant%26uid%3D" {
+		t.Errorf("Unexpected sync URL %s", info.URL)
+	}
+}
+
+func TestConversantMakeRequests(t *testing.T) {
+	site := &openrtb.Site{ID: "original", Page: "http://news.pub/topnews"}
+	req := &openrtb.BidRequest{
+		ID:   "req",
+		Site: site,
+		Imp: []openrtb.Imp{
+			impWithParams("unit-1", `{"site_id":"108060","tag_id":"top","position":1,"bidfloor":0.5,"mobile":1,"secure":1}`),
+		},
+	}
+	calls, errs := newTestAdapter("http://localhost/bid").MakeRequests(req)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if len(calls) != 1 || calls[0].URI != "http://localhost/bid" {
+		t.Fatalf("Expected one call to the endpoint. Got %v", calls)
+	}
+	if site.ID != "original" {
+		t.Errorf("The shared site was changed: %s", site.ID)
+	}
+
+	var sent openrtb.BidRequest
+	if err := json.Unmarshal(calls[0].Body, &sent); err != nil {
+		t.Fatalf("Bad request body: %v", err)
+	}
+	if sent.Site.ID != "108060" || sent.Site.Mobile != 1 {
+		t.Errorf("Expected site 108060 on mobile. Got %s, %d", sent.Site.ID, sent.Site.Mobile)
+	}
+	imp := sent.Imp[0]
+	if imp.TagID != "top" || imp.DisplayManager != "prebid-s2s" {
+		t.Errorf("Unexpected tag %s, display manager %s", imp.TagID, imp.DisplayManager)
+	}
+	if imp.BidFloor != 0.5 || imp.BidFloorCur != "USD" {
+		t.Errorf("Expected a 0.5 USD floor. Got %f %s", imp.BidFloor, imp.BidFloorCur)
+	}
+	if imp.Banner.Pos == nil || *imp.Banner.Pos != openrtb.AdPositionAboveTheFold {
+		t.Errorf("Expected the banner above the fold. Got %v", imp.Banner.Pos)
+	}
+	if imp.Secure == nil || *imp.Secure != 1 {
+		t.Errorf("Expected a secure imp. Got %v", imp.Secure)
+	}
+	if len(imp.Ext) != 0 {
+		t.Errorf("The params should be removed from the imp. Got %s", string(imp.Ext))
+	}
+}
+
+func TestConversantVideoParams(t *testing.T) {
+	imp := impWithParams("unit-1", `{"site_id":"108060","mimes":["video/mp4"],"api":[1,2],"protocols":[2,3],"maxduration":30,"skip":1,"skipmin":15,"skipafter":5}`)
+	imp.Banner = nil
+	imp.Video = &openrtb.Video{MIMEs: []string{"video/flv"}}
+	req := &openrtb.BidRequest{ID: "req", App: &openrtb.App{}, Imp: []openrtb.Imp{imp}}
+	calls, errs := newTestAdapter("http://localhost/bid").MakeRequests(req)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	var sent openrtb.BidRequest
+	if err := json.Unmarshal(calls[0].Body, &sent); err != nil {
+		t.Fatalf("Bad request body: %v", err)
+	}
+	if sent.App.ID != "108060" {
+		t.Errorf("Expected app 108060. Got %s", sent.App.ID)
+	}
+	video := sent.Imp[0].Video
+	if len(video.MIMEs) != 1 || video.MIMEs[0] != "video/mp4" {
+		t.Errorf("Unexpected mimes %v", video.MIMEs)
+	}
+	if len(video.API) != 2 || len(video.Protocols) != 2 || video.MaxDuration != 30 {
+		t.Errorf("Unexpected video %+v", video)
+	}
+	if video.Skip == nil || *video.Skip != 1 || video.SkipMin != 15 || video.SkipAfter != 5 {
+		t.Errorf("Unexpected skip settings %v, %d, %d", video.Skip, video.SkipMin, video.SkipAfter)
+	}
+}
+
+func TestConversantSiteDomain(t *testing.T) {
+	sentSite := func(site *openrtb.Site, params string) *openrtb.Site {
+		req := &openrtb.BidRequest{ID: "req", Site: site, Imp: []openrtb.Imp{impWithParams("unit-1", params)}}
+		calls, errs := newTestAdapter("http://localhost/bid").MakeRequests(req)
+		if len(errs) != 0 {
+			t.Fatalf("Unexpected errors: %v", errs)
+		}
+		var sent openrtb.BidRequest
+		if err := json.Unmarshal(calls[0].Body, &sent); err != nil {
+			t.Fatalf("Bad request body: %v", err)
+		}
+		return sent.Site
+	}
+
+	site := sentSite(&openrtb.Site{Page: "https://www.news.co.uk/topnews", Ref: "https://search.example.com/"}, `{"site_id":"108060"}`)
+	if site.Domain != "news.co.uk" || site.Page != "https://www.news.co.uk/topnews" || site.Ref != "https://search.example.com/" {
+		t.Errorf("Expected the domain from the page, and the page and ref kept. Got %+v", site)
+	}
+	site = sentSite(&openrtb.Site{Page: "https://m.news.pub/", Domain: "m.news.pub"}, `{"site_id":"108060","domain":"news.pub"}`)
+	if site.Domain != "news.pub" {
+		t.Errorf("Expected the canonical domain from the params. Got %s", site.Domain)
+	}
+}
+
+func TestConversantMissingSiteID(t *testing.T) {
+	req := &openrtb.BidRequest{
+		ID:   "req",
+		Site: &openrtb.Site{},
+		Imp:  []openrtb.Imp{impWithParams("unit-1", `{}`)},
+	}
+	_, errs := newTestAdapter("http://localhost/bid").MakeRequests(req)
+	if len(errs) != 1 || errs[0].Error() != "Missing site id" {
+		t.Errorf("Expected a missing site id. Got %v", errs)
+	}
+}
+
+func TestConversantMakeBids(t *testing.T) {
+	video := impWithParams("unit-2", `{}`)
+	video.Banner = nil
+	video.Video = &openrtb.Video{}
+	req := &openrtb.BidRequest{Imp: []openrtb.Imp{impWithParams("unit-1", `{}`), video}}
+	body, _ := json.Marshal(openrtb.BidResponse{
+		BidID: "resp",
+		SeatBid: []openrtb.SeatBid{{Bid: []openrtb.Bid{
+			{ID: "a", ImpID: "unit-1", Price: 1},
+			{ID: "b", ImpID: "unit-2", Price: 2},
+			{ID: "c", ImpID: "unknown", Price: 3},
+		}}},
+	})
+	resp, errs := newTestAdapter("").MakeBids(req, &adapters.ResponseData{StatusCode: 200, Body: body})
+	if len(errs) != 1 {
+		t.Errorf("Expected an error for the unknown imp. Got %v", errs)
+	}
+	if len(resp.Bids) != 2 {
+		t.Fatalf("Expected 2 bids. Got %d", len(resp.Bids))
+	}
+	if resp.Bids[0].BidType != pbs.MEDIA_TYPE_BANNER || resp.Bids[1].BidType != pbs.MEDIA_TYPE_VIDEO {
+		t.Errorf("Unexpected bid types %v, %v", resp.Bids[0].BidType, resp.Bids[1].BidType)
+	}
+}
+
+func TestConversantCall(t *testing.T) {
+	var sent openrtb.BidRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &sent)
+		resp, _ := json.Marshal(openrtb.BidResponse{
+			SeatBid: []openrtb.SeatBid{{Bid: []openrtb.Bid{{ID: "a", ImpID: "unit-1", Price: 1.5, AdM: "<div/>", W: 300, H: 250}}}},
+		})
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	req := &pbs.PBSRequest{Tid: "tid", Url: "http://news.pub/topnews", Domain: "news.pub", Ref: "http://search.example.com/"}
+	bidder := &pbs.PBSBidder{
+		BidderCode: "conversant",
+		AdUnits: []pbs.PBSAdUnit{{
+			Code:       "unit-1",
+			BidID:      "bid-1",
+			Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+			Params:     json.RawMessage(`{"site_id":"108060"}`),
+		}},
+	}
+	bids, err := newTestAdapter(server.URL).Call(context.Background(), req, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sent.Site == nil || sent.Site.ID != "108060" {
+		t.Fatalf("Expected site 108060. Got %+v", sent.Site)
+	}
+	if sent.Site.Page != "http://news.pub/topnews" || sent.Site.Domain != "news.pub" || sent.Site.Ref != "http://search.example.com/" {
+		t.Errorf("Expected the page, domain and ref from the request. Got %+v", sent.Site)
+	}
+	if len(bids) != 1 || bids[0].BidID != "bid-1" || bids[0].BidderCode != "conversant" || bids[0].Price != 1.5 {
+		t.Errorf("Unexpected bids %v", bids)
+	}
+}
@@ -0,0 +1,109 @@
+package debugbidder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// DebugBidderAdapter makes deterministic fake bids without calling anyone. Publishers can use it to
+// check their ad server setup end to end before they have real demand.
+//
+// Every ad unit gets one bid at the host's configured price, unless its params override it.
+type DebugBidderAdapter struct {
+	price      float64
+	mediaTypes []pbs.MediaType
+}
+
+func (a *DebugBidderAdapter) Name() string {
+	return "debug"
+}
+
+// There are no cookies to sync, but this must still be unique.
+func (a *DebugBidderAdapter) FamilyName() string {
+	return "debug"
+}
+
+// GetUsersyncInfo returns nil, since there are no users to sync.
+func (a *DebugBidderAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return nil
+}
+
+func (a *DebugBidderAdapter) SkipNoCookies() bool {
+	return false
+}
+
+// debugParams are the optional params for the debug bidder.
+type debugParams struct {
+	// Price overrides the host's price for this ad unit.
+	Price *float64 `json:"price"`
+}
+
+func (a *DebugBidderAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	bids := make(pbs.PBSBidSlice, 0, len(bidder.AdUnits))
+	for _, unit := range bidder.AdUnits {
+		var params debugParams
+		if len(unit.Params) > 0 {
+			if err := json.Unmarshal(unit.Params, &params); err != nil {
+				return nil, err
+			}
+		}
+		price := a.price
+		if params.Price != nil {
+			price = *params.Price
+		}
+
+		mediaType, ok := a.pickMediaType(unit.MediaTypes)
+		if !ok {
+			continue
+		}
+		bid := &pbs.PBSBid{
+			BidID:       unit.BidID,
+			AdUnitCode:  unit.Code,
+			BidderCode:  bidder.BidderCode,
+			Price:       price,
+			Creative_id: "debug-" + unit.Code,
+			Meta:        &pbs.PBSBidMeta{AdvertiserDomains: []string{"prebid.org"}},
+		}
+		if len(unit.Sizes) > 0 {
+			bid.Width = unit.Sizes[0].W
+			bid.Height = unit.Sizes[0].H
+		}
+		switch mediaType {
+		case pbs.MEDIA_TYPE_VIDEO:
+			bid.CreativeMediaType = "video"
+			bid.Adm = fmt.Sprintf(`<VAST version="3.0"><Ad id="debug-%s"><InLine><AdSystem>Prebid Server</AdSystem><AdTitle>Debug bid: %.2f</AdTitle><Impression/><Creatives/></InLine></Ad></VAST>`, unit.Code, price)
+		default:
+			bid.CreativeMediaType = "banner"
+			bid.Adm = fmt.Sprintf(`<div style="width:%dpx;height:%dpx;border:1px solid #999;text-align:center">Prebid Server debug bid: %.2f for %s</div>`, bid.Width, bid.Height, price, unit.Code)
+		}
+		bids = append(bids, bid)
+	}
+	return bids, nil
+}
+
+// pickMediaType returns the first of the ad unit's media types which the host lets the debug bidder use.
+func (a *DebugBidderAdapter) pickMediaType(unitTypes []pbs.MediaType) (pbs.MediaType, bool) {
+	if len(unitTypes) == 0 {
+		unitTypes = []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}
+	}
+	for _, unitType := range unitTypes {
+		for _, allowed := range a.mediaTypes {
+			if unitType == allowed {
+				return unitType, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// NewDebugBidderAdapter makes a debug bidder which bids the given price. It only bids on the given media types,
+// which are "banner" and/or "video". If there are none, it bids on banners.
+func NewDebugBidderAdapter(price float64, mediaTypes []string) *DebugBidderAdapter {
+	return &DebugBidderAdapter{
+		price:      price,
+		mediaTypes: pbs.ParseMediaTypes(mediaTypes),
+	}
+}
@@ -0,0 +1,67 @@
+package debugbidder
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+func TestDebugBids(t *testing.T) {
+	adapter := NewDebugBidderAdapter(1.5, []string{"banner"})
+	bidder := &pbs.PBSBidder{
+		BidderCode: "debug",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "top", BidID: "1", Sizes: []openrtb.Format{{W: 728, H: 90}}},
+			{Code: "side", BidID: "2", Sizes: []openrtb.Format{{W: 300, H: 250}}, Params: json.RawMessage(`{"price":3}`)},
+			{Code: "preroll", BidID: "3", MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO}},
+		},
+	}
+
+	bids, err := adapter.Call(context.Background(), &pbs.PBSRequest{}, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bids) != 2 {
+		t.Fatalf("Video isn't allowed, so expected 2 bids. Got %d", len(bids))
+	}
+	if bids[0].BidID != "1" || bids[0].Price != 1.5 || bids[0].Width != 728 || bids[0].CreativeMediaType != "banner" {
+		t.Errorf("Bad bid for the top ad unit: %#v", bids[0])
+	}
+	if bids[1].Price != 3 {
+		t.Errorf("The price param should override the host's price. Got %f", bids[1].Price)
+	}
+}
+
+func TestDebugVideoBids(t *testing.T) {
+	adapter := NewDebugBidderAdapter(2, []string{"banner", "video"})
+	bidder := &pbs.PBSBidder{
+		BidderCode: "debug",
+		AdUnits:    []pbs.PBSAdUnit{{Code: "preroll", BidID: "3", MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO}}},
+	}
+
+	bids, err := adapter.Call(context.Background(), &pbs.PBSRequest{}, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bids) != 1 || bids[0].CreativeMediaType != "video" || !strings.HasPrefix(bids[0].Adm, "<VAST") {
+		t.Errorf("Expected a VAST bid. Got %#v", bids)
+	}
+}
+
+func TestDebugBadParams(t *testing.T) {
+	adapter := NewDebugBidderAdapter(1, nil)
+	bidder := &pbs.PBSBidder{AdUnits: []pbs.PBSAdUnit{{Code: "top", Params: json.RawMessage(`{"price":"high"}`)}}}
+	if _, err := adapter.Call(context.Background(), &pbs.PBSRequest{}, bidder); err == nil {
+		t.Errorf("A non-numeric price should be an error")
+	}
+}
+
+func TestDebugNoUsersync(t *testing.T) {
+	if NewDebugBidderAdapter(1, nil).GetUsersyncInfo(pbs.UsersyncPrivacy{}) != nil {
+		t.Errorf("The debug bidder has no users to sync")
+	}
+}
@@ -0,0 +1,85 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// DryRunRequest describes an HTTP request which an adapter tried to send during a dry run.
+type DryRunRequest struct {
+	Method  string      `json:"method"`
+	URI     string      `json:"uri"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// DryRun collects the requests made by adapters whose context came from WithDryRun.
+type DryRun struct {
+	lock     sync.Mutex
+	requests []DryRunRequest
+}
+
+// Requests returns the requests which have been captured so far.
+func (d *DryRun) Requests() []DryRunRequest {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]DryRunRequest(nil), d.requests...)
+}
+
+func (d *DryRun) record(req DryRunRequest) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.requests = append(d.requests, req)
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context which stops HTTPAdapter clients from sending any requests.
+// Instead, each request is captured in the returned DryRun and answered with a 204 No Content,
+// which adapters treat as "no bid".
+func WithDryRun(ctx context.Context) (context.Context, *DryRun) {
+	dryRun := &DryRun{}
+	return context.WithValue(ctx, dryRunKey{}, dryRun), dryRun
+}
+
+// dryRunTransport sends requests through the next RoundTripper, unless the request's context came from WithDryRun.
+type dryRunTransport struct {
+	next http.RoundTripper
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dryRun, ok := req.Context().Value(dryRunKey{}).(*DryRun)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	dryRun.record(DryRunRequest{
+		Method:  req.Method,
+		URI:     req.URL.String(),
+		Headers: req.Header,
+		Body:    string(body),
+	})
+
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
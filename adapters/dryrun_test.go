@@ -0,0 +1,59 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDryRunCapturesRequests(t *testing.T) {
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer server.Close()
+
+	adapter := NewHTTPAdapter(DefaultHTTPAdapterConfig)
+	ctx, dryRun := WithDryRun(context.Background())
+	req, _ := http.NewRequest("POST", server.URL+"/bid", bytes.NewBufferString(`{"id":"1"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := adapter.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected a 204. Got %d", resp.StatusCode)
+	}
+	if sent != 0 {
+		t.Errorf("A dry run shouldn't send anything to the bidder")
+	}
+
+	requests := dryRun.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 captured request. Got %d", len(requests))
+	}
+	if requests[0].URI != server.URL+"/bid" || requests[0].Body != `{"id":"1"}` || requests[0].Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Bad captured request: %v", requests[0])
+	}
+}
+
+func TestNoDryRunSendsRequests(t *testing.T) {
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer server.Close()
+
+	adapter := NewHTTPAdapter(DefaultHTTPAdapterConfig)
+	resp, err := adapter.Client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if sent != 1 {
+		t.Errorf("Expected the request to be sent. Got %d", sent)
+	}
+}
@@ -13,16 +13,16 @@ import (
 
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/pbs"
-	"golang.org/x/net/context/ctxhttp"
 )
 
 type FacebookAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	nonSecureUri string
-	usersyncInfo *pbs.UsersyncInfo
-	platformJSON openrtb.RawJSON
+	http             *adapters.HTTPAdapter
+	URI              string
+	nonSecureUri     string
+	usersyncTemplate *pbs.UsersyncTemplate
+	platformJSON     openrtb.RawJSON
 }
 
 var supportedHeight = map[uint64]bool{
@@ -46,8 +46,8 @@ func (a *FacebookAdapter) SplitAdUnits() bool {
 	return true
 }
 
-func (a *FacebookAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *FacebookAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *FacebookAdapter) SkipNoCookies() bool {
@@ -72,7 +72,8 @@ func (a *FacebookAdapter) callOne(ctx context.Context, reqJSON bytes.Buffer) (re
 	httpReq.Header.Add("Content-Type", "application/json")
 	httpReq.Header.Add("Accept", "application/json")
 
-	anResp, e := ctxhttp.Do(ctx, a.http.Client, httpReq)
+	anResp, timing, e := a.http.DoRequest(ctx, httpReq)
+	result.Timing = timing
 	if e != nil {
 		err = e
 		return
@@ -92,6 +93,10 @@ func (a *FacebookAdapter) callOne(ctx context.Context, reqJSON bytes.Buffer) (re
 	var bidResp openrtb.BidResponse
 	err = json.Unmarshal(body, &bidResp)
 	if err != nil {
+		err = &errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: body,
+		}
 		return
 	}
 	if len(bidResp.SeatBid) == 0 {
@@ -103,9 +108,14 @@ func (a *FacebookAdapter) callOne(ctx context.Context, reqJSON bytes.Buffer) (re
 	bid := bidResp.SeatBid[0].Bid[0]
 
 	result.Bid = &pbs.PBSBid{
-		AdUnitCode: bid.ImpID,
-		Price:      bid.Price,
-		Adm:        bid.AdM,
+		AdUnitCode:    bid.ImpID,
+		Price:         bid.Price,
+		Adm:           bid.AdM,
+		BURL:          bid.BURL,
+		BidResponseID: bidResp.BidID,
+		Exp:           bid.Exp,
+		Meta:          adapters.MakeBidMeta(&bid, ""),
+		Ext:           adapters.MakeBidExt(&bid, bidResp.Cur),
 	}
 	return
 }
@@ -213,6 +223,9 @@ func (a *FacebookAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 
 	for i, _ := range requests {
 		go func(bidder *pbs.PBSBidder, reqJSON bytes.Buffer) {
+			defer adapters.RecoverPanic(bidder.BidderCode, func(err error) {
+				ch <- adapters.CallOneResult{Error: err}
+			})
 			result, err := a.callOne(ctx, reqJSON)
 			result.Error = err
 			if result.Bid != nil {
@@ -246,6 +259,7 @@ func (a *FacebookAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 				RequestBody:  requests[i].String(),
 				StatusCode:   result.StatusCode,
 				ResponseBody: result.ResponseBody,
+				Timing:       result.Timing.Debug(),
 			}
 			bidder.Debug = append(bidder.Debug, debug)
 		}
@@ -260,10 +274,12 @@ func (a *FacebookAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 	return bids, nil
 }
 
-func NewFacebookAdapter(config *adapters.HTTPAdapterConfig, partnerID string, usersyncURL string) *FacebookAdapter {
+// NewFacebookAdapter makes an adapter which bids against the uri. Half of the traffic goes to its http:// version,
+// for an AB test.
+func NewFacebookAdapter(config *adapters.HTTPAdapterConfig, uri string, partnerID string, usersyncURL string) *FacebookAdapter {
 	a := adapters.NewHTTPAdapter(config)
 
-	info := &pbs.UsersyncInfo{
+	info := &pbs.UsersyncTemplate{
 		URL:         usersyncURL,
 		Type:        "redirect",
 		SupportCORS: false,
@@ -271,10 +287,10 @@ func NewFacebookAdapter(config *adapters.HTTPAdapterConfig, partnerID string, us
 
 	return &FacebookAdapter{
 		http: a,
-		URI:  "https://an.facebook.com/placementbid.ortb",
+		URI:  uri,
 		//for AB test
-		nonSecureUri: "http://an.facebook.com/placementbid.ortb",
-		usersyncInfo: info,
-		platformJSON: openrtb.RawJSON(fmt.Sprintf("{\"platformid\": %s}", partnerID)),
+		nonSecureUri:     strings.Replace(uri, "https://", "http://", 1),
+		usersyncTemplate: info,
+		platformJSON:     openrtb.RawJSON(fmt.Sprintf("{\"platformid\": %s}", partnerID)),
 	}
 }
@@ -204,7 +204,7 @@ func GenerateBidRequestForTestData(fbdata bidInfo, url string) (*pbs.PBSRequest,
 	req.Header.Add("User-Agent", fbdata.deviceUA)
 	req.Header.Add("X-Real-IP", fbdata.deviceIP)
 
-	pc := pbs.ParsePBSCookieFromRequest(req)
+	pc := pbs.ParsePBSCookieFromRequest(req, nil)
 	pc.TrySync("audienceNetwork", fbdata.buyerUID)
 	fakewriter := httptest.NewRecorder()
 	pc.SetCookieOnResponse(fakewriter, "")
@@ -247,7 +247,7 @@ func TestFacebookBasicResponse(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewFacebookAdapter(&conf, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
+	an := NewFacebookAdapter(&conf, server.URL, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
 	an.URI = server.URL
 	an.nonSecureUri = server.URL
 
@@ -339,7 +339,7 @@ func TestFacebookInterstitialResponse(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewFacebookAdapter(&conf, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
+	an := NewFacebookAdapter(&conf, server.URL, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
 	an.URI = server.URL
 	an.nonSecureUri = server.URL
 
@@ -421,7 +421,7 @@ func TestFacebookBannerRequestWithSupportedSizes(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewFacebookAdapter(&conf, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
+	an := NewFacebookAdapter(&conf, server.URL, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
 	an.URI = server.URL
 	an.nonSecureUri = server.URL
 
@@ -506,7 +506,7 @@ func TestGenerateRequestsForFacebook(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewFacebookAdapter(&conf, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
+	an := NewFacebookAdapter(&conf, server.URL, fmt.Sprintf("%d", fbdata.partnerID), "localhost")
 	an.URI = server.URL
 	an.nonSecureUri = server.URL
 
@@ -546,14 +546,15 @@ func TestGenerateRequestsForFacebook(t *testing.T) {
 func TestFacebookUserSyncInfo(t *testing.T) {
 	url := "https://www.facebook.com/audiencenetwork/idsync/?partner=partnerId&callback=localhost%2Fsetuid%3Fbidder%3DaudienceNetwork%26uid%3D%24UID"
 
-	an := NewFacebookAdapter(adapters.DefaultHTTPAdapterConfig, "partnerId", url)
-	if an.usersyncInfo.URL != url {
+	an := NewFacebookAdapter(adapters.DefaultHTTPAdapterConfig, "https://an.facebook.com/placementbid.ortb", "partnerId", url)
+	usersyncInfo := an.GetUsersyncInfo(pbs.UsersyncPrivacy{})
+	if usersyncInfo.URL != url {
 		t.Fatalf("should have matched")
 	}
-	if an.usersyncInfo.Type != "redirect" {
+	if usersyncInfo.Type != "redirect" {
 		t.Fatalf("should be redirect")
 	}
-	if an.usersyncInfo.SupportCORS != false {
+	if usersyncInfo.SupportCORS != false {
 		t.Fatalf("should have been false")
 	}
 }
@@ -0,0 +1,103 @@
+package adapters
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// failoverThreshold is the number of connection failures in a row which make the primary endpoint count as down.
+const failoverThreshold = 3
+
+// failoverProbeInterval is how often a request is sent to a failed primary, to see whether it has recovered.
+const failoverProbeInterval = 30 * time.Second
+
+// EndpointFailover moves a bidder's requests from its primary endpoint to a secondary one
+// if the primary keeps failing to connect. While failed over, one request is sent to the primary
+// every failoverProbeInterval, and the first one which succeeds moves traffic back.
+//
+// Requests match the primary if their URL starts with it. That prefix is replaced by the secondary.
+type EndpointFailover struct {
+	Primary   string
+	Secondary string
+	// OnChange is called whenever traffic moves between endpoints. failedOver is true if it's moving to the secondary.
+	OnChange func(failedOver bool)
+
+	lock       sync.Mutex
+	failures   int
+	failedOver bool
+	nextProbe  time.Time
+}
+
+// useSecondary decides where the next request to the primary should go.
+func (f *EndpointFailover) useSecondary(now time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.failedOver {
+		return false
+	}
+	if now.Before(f.nextProbe) {
+		return true
+	}
+	f.nextProbe = now.Add(failoverProbeInterval)
+	return false
+}
+
+// record tracks the outcome of a request which was sent to the primary.
+func (f *EndpointFailover) record(ok bool, now time.Time) {
+	f.lock.Lock()
+	changed := false
+	if ok {
+		f.failures = 0
+		changed = f.failedOver
+		f.failedOver = false
+	} else {
+		f.failures++
+		if !f.failedOver && f.failures >= failoverThreshold {
+			f.failedOver = true
+			f.nextProbe = now.Add(failoverProbeInterval)
+			changed = true
+		}
+	}
+	failedOver := f.failedOver
+	f.lock.Unlock()
+
+	if changed && f.OnChange != nil {
+		f.OnChange(failedOver)
+	}
+}
+
+// failoverTransport applies an EndpointFailover to requests before they go to the next RoundTripper.
+type failoverTransport struct {
+	failover *EndpointFailover
+	next     http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := t.failover
+	uri := req.URL.String()
+	if !strings.HasPrefix(uri, f.Primary) {
+		return t.next.RoundTrip(req)
+	}
+
+	if f.useSecondary(time.Now()) {
+		secondary, err := url.Parse(f.Secondary + strings.TrimPrefix(uri, f.Primary))
+		if err != nil {
+			return nil, err
+		}
+		// RoundTrippers mustn't modify the request, so this sends a shallow copy.
+		rerouted := req.WithContext(req.Context())
+		rerouted.URL = secondary
+		rerouted.Host = secondary.Host
+		return t.next.RoundTrip(rerouted)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	// Timeouts and cancellations say nothing about whether the endpoint is up.
+	if err == nil || req.Context().Err() == nil {
+		f.record(err == nil, time.Now())
+	}
+	return resp, err
+}
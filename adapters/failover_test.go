@@ -0,0 +1,108 @@
+package adapters
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hostTransport fails to connect to any host in down, and records every URL it was asked for.
+type hostTransport struct {
+	down map[string]bool
+	urls []string
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, req.URL.String())
+	if t.down[req.URL.Host] {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusNoContent, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (t *hostTransport) last() string {
+	return t.urls[len(t.urls)-1]
+}
+
+func TestEndpointFailover(t *testing.T) {
+	var changes []bool
+	failover := &EndpointFailover{
+		Primary:   "http://primary.com/bid",
+		Secondary: "http://secondary.com/bid",
+		OnChange:  func(failedOver bool) { changes = append(changes, failedOver) },
+	}
+	next := &hostTransport{down: map[string]bool{"primary.com": true}}
+	transport := &failoverTransport{failover: failover, next: next}
+	send := func() {
+		req, _ := http.NewRequest("POST", "http://primary.com/bid?src=pbs", nil)
+		transport.RoundTrip(req)
+	}
+
+	for i := 0; i < failoverThreshold; i++ {
+		send()
+		if next.last() != "http://primary.com/bid?src=pbs" {
+			t.Fatalf("Request %d should go to the primary. Got %s", i, next.last())
+		}
+	}
+	if len(changes) != 1 || !changes[0] {
+		t.Fatalf("Expected one failover. Got %v", changes)
+	}
+
+	send()
+	if next.last() != "http://secondary.com/bid?src=pbs" {
+		t.Errorf("Requests should go to the secondary after a failover. Got %s", next.last())
+	}
+
+	// Once the probe interval is up, the next request checks the primary.
+	next.down["primary.com"] = false
+	failover.nextProbe = time.Now().Add(-time.Second)
+	send()
+	if next.last() != "http://primary.com/bid?src=pbs" {
+		t.Errorf("Expected a probe of the primary. Got %s", next.last())
+	}
+	if len(changes) != 2 || changes[1] {
+		t.Errorf("A successful probe should move traffic back. Got %v", changes)
+	}
+	send()
+	if next.last() != "http://primary.com/bid?src=pbs" {
+		t.Errorf("Requests should go to the primary after it recovers. Got %s", next.last())
+	}
+}
+
+func TestEndpointFailoverFailedProbe(t *testing.T) {
+	failover := &EndpointFailover{Primary: "http://primary.com", Secondary: "http://secondary.com"}
+	next := &hostTransport{down: map[string]bool{"primary.com": true}}
+	transport := &failoverTransport{failover: failover, next: next}
+	for i := 0; i < failoverThreshold; i++ {
+		req, _ := http.NewRequest("GET", "http://primary.com/bid", nil)
+		transport.RoundTrip(req)
+	}
+
+	failover.nextProbe = time.Now().Add(-time.Second)
+	req, _ := http.NewRequest("GET", "http://primary.com/bid", nil)
+	transport.RoundTrip(req)
+	if next.last() != "http://primary.com/bid" {
+		t.Fatalf("Expected a probe of the primary. Got %s", next.last())
+	}
+	req, _ = http.NewRequest("GET", "http://primary.com/bid", nil)
+	transport.RoundTrip(req)
+	if next.last() != "http://secondary.com/bid" {
+		t.Errorf("A failed probe should keep traffic on the secondary. Got %s", next.last())
+	}
+}
+
+func TestEndpointFailoverOtherHosts(t *testing.T) {
+	failover := &EndpointFailover{Primary: "http://primary.com", Secondary: "http://secondary.com"}
+	next := &hostTransport{down: map[string]bool{"other.com": true}}
+	transport := &failoverTransport{failover: failover, next: next}
+	for i := 0; i < failoverThreshold; i++ {
+		req, _ := http.NewRequest("GET", "http://other.com/usersync", nil)
+		transport.RoundTrip(req)
+	}
+	if failover.failedOver || failover.failures != 0 {
+		t.Errorf("Failures on other hosts shouldn't count against the primary")
+	}
+}
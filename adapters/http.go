@@ -0,0 +1,238 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prebid/prebid-server/pbs"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// CallTiming breaks down how long one HTTP call to a bidder took.
+// Phases which didn't happen (for example, DNS and Connect on a reused connection) are zero.
+type CallTiming struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from sending the request to receiving the first byte of the response.
+	TTFB       time.Duration
+	Total      time.Duration
+	ReusedConn bool
+	// Retried is true if the first attempt failed before the request was sent, and it was tried again.
+	Retried bool
+}
+
+// Debug converts the timing into the format used by the auction's debug output. It's safe to call on nil.
+func (t *CallTiming) Debug() *pbs.BidderTiming {
+	if t == nil {
+		return nil
+	}
+	return &pbs.BidderTiming{
+		DNSMillis:     toMillis(t.DNS),
+		ConnectMillis: toMillis(t.Connect),
+		TLSMillis:     toMillis(t.TLS),
+		TTFBMillis:    toMillis(t.TTFB),
+		TotalMillis:   toMillis(t.Total),
+		ReusedConn:    t.ReusedConn,
+		Retried:       t.Retried,
+	}
+}
+
+func toMillis(d time.Duration) int {
+	return int(d / time.Millisecond)
+}
+
+// CallHook is notified after every call made through HTTPAdapter.DoRequest.
+// The error is the one returned to the adapter, if any.
+type CallHook func(timing *CallTiming, err error)
+
+type callHookKey struct{}
+
+// WithCallHook returns a context which makes DoRequest report each call's timing to the hook.
+// This is how the auction records per-bidder metrics without each adapter knowing about them.
+func WithCallHook(ctx context.Context, hook CallHook) context.Context {
+	return context.WithValue(ctx, callHookKey{}, hook)
+}
+
+// RequestData describes a call to a bidder. Most bidders take a POSTed JSON body, but some exchanges
+// only take GET requests, with the bid request encoded in the query string.
+type RequestData struct {
+	// Method defaults to POST.
+	Method string
+	URI    string
+	// Query is added to the URI's query string.
+	Query url.Values
+	// Body must be empty for GET requests.
+	Body    []byte
+	Headers http.Header
+}
+
+// NewRequest builds the HTTP request. Requests with a body are sent as JSON, unless the headers set another
+// Content-Type.
+func (r *RequestData) NewRequest() (*http.Request, error) {
+	method := r.Method
+	if method == "" {
+		method = "POST"
+	}
+	if method == "GET" && len(r.Body) > 0 {
+		return nil, fmt.Errorf("GET requests to %s can't have a body", r.URI)
+	}
+	uri, err := url.Parse(r.URI)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Query) > 0 {
+		query := uri.Query()
+		for key, values := range r.Query {
+			for _, value := range values {
+				query.Add(key, value)
+			}
+		}
+		uri.RawQuery = query.Encode()
+	}
+
+	var req *http.Request
+	if len(r.Body) > 0 {
+		req, err = http.NewRequest(method, uri.String(), bytes.NewReader(r.Body))
+	} else {
+		req, err = http.NewRequest(method, uri.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range r.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if len(r.Body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	}
+	return req, nil
+}
+
+// Do builds the request and sends it with DoRequest.
+func (a *HTTPAdapter) Do(ctx context.Context, data *RequestData) (*http.Response, *CallTiming, error) {
+	req, err := data.NewRequest()
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.DoRequest(ctx, req)
+}
+
+// DoRequest sends the request with the adapter's client and records how long each phase took.
+//
+// If the first attempt fails before any of the request was written (for example, because an idle
+// connection was closed by the bidder), it is retried once. Requests which were (even partly) sent
+// are never retried, so bidders won't see duplicate bid requests.
+func (a *HTTPAdapter) DoRequest(ctx context.Context, req *http.Request) (*http.Response, *CallTiming, error) {
+	timing := &CallTiming{}
+	start := time.Now()
+	resp, wrote, err := a.doOnce(ctx, req, timing)
+	if err != nil && !wrote && ctx.Err() == nil && rewind(req) {
+		timing.Retried = true
+		resp, _, err = a.doOnce(ctx, req, timing)
+	}
+	timing.Total = time.Since(start)
+
+	if hook, ok := ctx.Value(callHookKey{}).(CallHook); ok {
+		hook(timing, err)
+	}
+	return resp, timing, err
+}
+
+func (a *HTTPAdapter) doOnce(ctx context.Context, req *http.Request, timing *CallTiming) (*http.Response, bool, error) {
+	// The trace callbacks can run on the transport's goroutines, even after a timeout has returned control to
+	// the caller. So they only touch these locals, under the lock, and the phases are copied into the timing
+	// once the call is over.
+	var lock sync.Mutex
+	var phases CallTiming
+	var dnsStart, connectStart, tlsStart, wroteAt time.Time
+	wrote := false
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock.Lock()
+			dnsStart = time.Now()
+			lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock.Lock()
+			phases.DNS = time.Since(dnsStart)
+			lock.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			lock.Lock()
+			connectStart = time.Now()
+			lock.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			lock.Lock()
+			phases.Connect = time.Since(connectStart)
+			lock.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			lock.Lock()
+			tlsStart = time.Now()
+			lock.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock.Lock()
+			phases.TLS = time.Since(tlsStart)
+			lock.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock.Lock()
+			phases.ReusedConn = info.Reused
+			lock.Unlock()
+		},
+		WroteHeaders: func() {
+			lock.Lock()
+			wrote = true
+			lock.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			lock.Lock()
+			wrote = true
+			wroteAt = time.Now()
+			lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			lock.Lock()
+			phases.TTFB = time.Since(wroteAt)
+			lock.Unlock()
+		},
+	}
+
+	resp, err := ctxhttp.Do(httptrace.WithClientTrace(ctx, trace), a.Client, req)
+	lock.Lock()
+	defer lock.Unlock()
+	timing.DNS = phases.DNS
+	timing.Connect = phases.Connect
+	timing.TLS = phases.TLS
+	timing.TTFB = phases.TTFB
+	timing.ReusedConn = phases.ReusedConn
+	return resp, wrote, err
+}
+
+// rewind resets the request body so that it can be sent again. It returns false if that's impossible.
+func rewind(req *http.Request) bool {
+	if req.Body == nil {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
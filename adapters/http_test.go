@@ -0,0 +1,174 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDoRequestReportsTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var hooked *CallTiming
+	ctx := WithCallHook(context.Background(), func(timing *CallTiming, err error) {
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		hooked = timing
+	})
+
+	adapter := NewHTTPAdapter(DefaultHTTPAdapterConfig)
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString(`{"id":"1"}`))
+	resp, timing, err := adapter.DoRequest(ctx, req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected a 204. Got %d", resp.StatusCode)
+	}
+	if hooked != timing {
+		t.Errorf("The hook should get the same timing which was returned")
+	}
+	if timing.Total <= 0 || timing.ReusedConn || timing.Retried {
+		t.Errorf("Bad timing for the first call: %#v", timing)
+	}
+	if debug := timing.Debug(); debug.ReusedConn || debug.Retried {
+		t.Errorf("Bad debug timing: %#v", debug)
+	}
+}
+
+func TestDoRequestDoesNotRetrySentRequests(t *testing.T) {
+	// The listener accepts the request and closes the connection without answering.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			conn.Read(buf)
+			conn.Close()
+		}
+	}()
+
+	adapter := NewHTTPAdapter(DefaultHTTPAdapterConfig)
+	req, _ := http.NewRequest("POST", "http://"+listener.Addr().String(), bytes.NewBufferString(`{"id":"1"}`))
+	_, timing, err := adapter.DoRequest(context.Background(), req)
+	if err == nil {
+		t.Fatalf("Expected an error when the bidder hangs up")
+	}
+	if timing.Retried {
+		t.Errorf("A request which was sent shouldn't be retried")
+	}
+}
+
+// lateTraceTransport gives up when the request's context is done, but keeps reporting to its trace once
+// it's released, like a transport whose dial outlives the request.
+type lateTraceTransport struct {
+	release  chan struct{}
+	finished chan struct{}
+}
+
+func (tr *lateTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	<-req.Context().Done()
+	go func() {
+		defer close(tr.finished)
+		<-tr.release
+		trace.DNSDone(httptrace.DNSDoneInfo{})
+		trace.GotConn(httptrace.GotConnInfo{Reused: true})
+		trace.GotFirstResponseByte()
+	}()
+	return nil, req.Context().Err()
+}
+
+func TestDoRequestTimingAfterTimeout(t *testing.T) {
+	transport := &lateTraceTransport{release: make(chan struct{}), finished: make(chan struct{})}
+	adapter := &HTTPAdapter{Client: &http.Client{Transport: transport}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://bidder.example.com", nil)
+	_, timing, err := adapter.DoRequest(ctx, req)
+	if err == nil {
+		t.Fatalf("Expected the call to time out")
+	}
+	close(transport.release)
+	<-transport.finished
+	if timing.DNS != 0 || timing.ReusedConn || timing.TTFB != 0 {
+		t.Errorf("Trace events after the call returned shouldn't change its timing: %#v", timing)
+	}
+}
+
+func TestCallTimingDebugNil(t *testing.T) {
+	var timing *CallTiming
+	if timing.Debug() != nil {
+		t.Errorf("A nil timing should have no debug output")
+	}
+}
+
+func TestDoGetRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	adapter := NewHTTPAdapter(DefaultHTTPAdapterConfig)
+	resp, _, err := adapter.Do(context.Background(), &RequestData{
+		Method:  "GET",
+		URI:     server.URL + "/bid?v=2",
+		Query:   url.Values{"ad_unit": []string{"top", "side bar"}},
+		Headers: http.Header{"Accept": []string{"application/json"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got.Method != "GET" || len(gotBody) != 0 {
+		t.Errorf("Expected a GET with no body. Got %s with %q", got.Method, gotBody)
+	}
+	if got.URL.Query().Get("v") != "2" || len(got.URL.Query()["ad_unit"]) != 2 || got.URL.Query()["ad_unit"][1] != "side bar" {
+		t.Errorf("Bad query string: %s", got.URL.RawQuery)
+	}
+	if got.Header.Get("Accept") != "application/json" || got.Header.Get("Content-Type") != "" {
+		t.Errorf("Bad headers: %v", got.Header)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	req, err := (&RequestData{URI: "http://bidder.com", Body: []byte(`{"id":"1"}`)}).NewRequest()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Method != "POST" || req.Header.Get("Content-Type") != "application/json;charset=utf-8" {
+		t.Errorf("Requests should default to a JSON POST. Got %s with %v", req.Method, req.Header)
+	}
+
+	req, err = (&RequestData{URI: "http://bidder.com", Body: []byte(`a=1`), Headers: http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}}).NewRequest()
+	if err != nil || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Errorf("The headers should override the default Content-Type")
+	}
+
+	if _, err := (&RequestData{Method: "GET", URI: "http://bidder.com", Body: []byte(`{}`)}).NewRequest(); err == nil {
+		t.Errorf("GET requests with a body should be rejected")
+	}
+}
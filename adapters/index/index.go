@@ -11,16 +11,15 @@ import (
 
 	"github.com/prebid/prebid-server/pbs"
 
-	"golang.org/x/net/context/ctxhttp"
-
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 )
 
 type IndexAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
 }
 
 /* Name - export adapter name */
@@ -33,8 +32,8 @@ func (a *IndexAdapter) FamilyName() string {
 	return "indexExchange"
 }
 
-func (a *IndexAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *IndexAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *IndexAdapter) SkipNoCookies() bool {
@@ -92,7 +91,8 @@ func (a *IndexAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pb
 	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
 	httpReq.Header.Add("Accept", "application/json")
 
-	ixResp, err := ctxhttp.Do(ctx, a.http.Client, httpReq)
+	ixResp, timing, err := a.http.DoRequest(ctx, httpReq)
+	debug.Timing = timing.Debug()
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +120,10 @@ func (a *IndexAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pb
 	var bidResp openrtb.BidResponse
 	err = json.Unmarshal(body, &bidResp)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing response: %v", err)
+		return nil, &errortypes.BadServerResponse{
+			Message: fmt.Sprintf("Error parsing response: %v", err),
+			Payload: body,
+		}
 	}
 
 	bids := make(pbs.PBSBidSlice, 0)
@@ -136,15 +139,20 @@ func (a *IndexAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pb
 			}
 
 			pbid := pbs.PBSBid{
-				BidID:       bidID,
-				AdUnitCode:  bidder.AdUnits[i].Code, // todo: check this
-				BidderCode:  bidder.BidderCode,
-				Price:       bid.Price,
-				Adm:         bid.AdM,
-				Creative_id: bid.CrID,
-				Width:       bid.W,
-				Height:      bid.H,
-				DealId:      bid.DealID,
+				BidID:         bidID,
+				AdUnitCode:    bidder.AdUnits[i].Code, // todo: check this
+				BidderCode:    bidder.BidderCode,
+				Price:         bid.Price,
+				Adm:           bid.AdM,
+				BURL:          bid.BURL,
+				BidResponseID: bidResp.BidID,
+				Creative_id:   bid.CrID,
+				Width:         bid.W,
+				Height:        bid.H,
+				DealId:        bid.DealID,
+				Exp:           bid.Exp,
+				Meta:          adapters.MakeBidMeta(&bid, ""),
+				Ext:           adapters.MakeBidExt(&bid, bidResp.Cur),
 			}
 			bids = append(bids, &pbid)
 		}
@@ -155,15 +163,15 @@ func (a *IndexAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pb
 func NewIndexAdapter(config *adapters.HTTPAdapterConfig, uri string, userSyncURL string) *IndexAdapter {
 	a := adapters.NewHTTPAdapter(config)
 
-	info := &pbs.UsersyncInfo{
+	info := &pbs.UsersyncTemplate{
 		URL:         userSyncURL,
 		Type:        "redirect",
 		SupportCORS: false,
 	}
 
 	return &IndexAdapter{
-		http:         a,
-		URI:          uri,
-		usersyncInfo: info,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
 	}
 }
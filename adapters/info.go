@@ -0,0 +1,143 @@
+package adapters
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BidderInfo is the metadata about a bidder from its static/bidder-info/{bidder}.yaml file, which integrators
+// can use to check what the bidder supports.
+type BidderInfo struct {
+	Maintainer   *MaintainerInfo   `yaml:"maintainer" json:"maintainer"`
+	Capabilities *CapabilitiesInfo `yaml:"capabilities" json:"capabilities"`
+	// EndpointCompression is "gzip" if the bidder's endpoint accepts gzipped requests, or empty if it doesn't.
+	EndpointCompression string `yaml:"endpointCompression" json:"endpointCompression,omitempty"`
+	// GVLVendorID is the bidder's ID in the IAB's Global Vendor List, which the GDPR consent string refers to.
+	// It's 0 if the bidder isn't registered.
+	GVLVendorID uint16 `yaml:"gvlVendorID" json:"gvlVendorID,omitempty"`
+	// ASI is the canonical domain of the bidder's advertising system, which identifies it in demand chains.
+	// Bidders without one are left out of them.
+	ASI string `yaml:"asi" json:"asi,omitempty"`
+	// GPP is true if the bidder reads the Global Privacy Platform string in regs.ext.gpp and regs.ext.gpp_sid.
+	// Other bidders get its sections as the older regs.ext.gdpr, user.ext.consent and regs.ext.us_privacy.
+	GPP bool `yaml:"gpp" json:"gpp,omitempty"`
+}
+
+// MaintainerInfo says who to contact about the bidder's adapter.
+type MaintainerInfo struct {
+	Email string `yaml:"email" json:"email"`
+}
+
+// CapabilitiesInfo lists the media types which the bidder supports on each platform.
+// A nil platform means that the bidder doesn't bid on it at all.
+type CapabilitiesInfo struct {
+	App  *PlatformInfo `yaml:"app" json:"app,omitempty"`
+	Site *PlatformInfo `yaml:"site" json:"site,omitempty"`
+}
+
+// PlatformInfo lists the media types which the bidder supports on one platform.
+type PlatformInfo struct {
+	MediaTypes []string `yaml:"mediaTypes" json:"mediaTypes"`
+	// SKAdN is true if the bidder accepts imp.ext.skadn, and can return SKAdNetwork signatures. It's only valid for app.
+	SKAdN bool `yaml:"skadn" json:"skadn,omitempty"`
+}
+
+// BidderInfos holds the metadata for each bidder, keyed by bidder code.
+type BidderInfos map[string]BidderInfo
+
+var validInfoMediaTypes = map[string]bool{
+	"banner": true,
+	"video":  true,
+	"audio":  true,
+	"native": true,
+}
+
+// ParseBidderInfos loads the metadata for the bidders from the {bidder}.yaml files in the directory.
+// It fails if any of the bidders has no file, or if a file is invalid.
+func ParseBidderInfos(infoDirectory string, bidderCodes []string) (BidderInfos, error) {
+	infos := make(BidderInfos, len(bidderCodes))
+	for _, code := range bidderCodes {
+		fileName := adapterFileName(code) + ".yaml"
+		bytes, err := ioutil.ReadFile(filepath.Join(infoDirectory, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the info for bidder %s: %v", code, err)
+		}
+		var info BidderInfo
+		if err := yaml.Unmarshal(bytes, &info); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", fileName, err)
+		}
+		if err := info.validate(); err != nil {
+			return nil, fmt.Errorf("%s is invalid: %v", fileName, err)
+		}
+		infos[code] = info
+	}
+	return infos, nil
+}
+
+func (info BidderInfo) validate() error {
+	if info.Maintainer == nil || info.Maintainer.Email == "" {
+		return fmt.Errorf("maintainer.email is required")
+	}
+	if info.Capabilities == nil || (info.Capabilities.App == nil && info.Capabilities.Site == nil) {
+		return fmt.Errorf("capabilities must include app, site or both")
+	}
+	for platform, platformInfo := range map[string]*PlatformInfo{"app": info.Capabilities.App, "site": info.Capabilities.Site} {
+		if platformInfo == nil {
+			continue
+		}
+		if len(platformInfo.MediaTypes) == 0 {
+			return fmt.Errorf("capabilities.%s.mediaTypes must not be empty", platform)
+		}
+		for _, mediaType := range platformInfo.MediaTypes {
+			if !validInfoMediaTypes[mediaType] {
+				return fmt.Errorf("capabilities.%s.mediaTypes has an unknown media type %q", platform, mediaType)
+			}
+		}
+	}
+	if info.Capabilities.Site != nil && info.Capabilities.Site.SKAdN {
+		return fmt.Errorf("capabilities.site.skadn isn't allowed, since SKAdNetwork is only for apps")
+	}
+	if info.EndpointCompression != "" && info.EndpointCompression != "gzip" {
+		return fmt.Errorf("endpointCompression must be gzip or empty. Got %q", info.EndpointCompression)
+	}
+	return nil
+}
+
+// SupportsSKAdN returns true if the bidder should get imp.ext.skadn on iOS app requests.
+func (info BidderInfo) SupportsSKAdN() bool {
+	return info.Capabilities != nil && info.Capabilities.App != nil && info.Capabilities.App.SKAdN
+}
+
+// VendorIDs returns the Global Vendor List IDs of the bidders which have one, keyed by bidder code.
+func (infos BidderInfos) VendorIDs() map[string]uint16 {
+	vendorIDs := make(map[string]uint16, len(infos))
+	for code, info := range infos {
+		if info.GVLVendorID != 0 {
+			vendorIDs[code] = info.GVLVendorID
+		}
+	}
+	return vendorIDs
+}
+
+// Supports returns true if the bidder bids on the media type on the platform, which is "app" or "site".
+func (info BidderInfo) Supports(platform string, mediaType string) bool {
+	if info.Capabilities == nil {
+		return false
+	}
+	platformInfo := info.Capabilities.Site
+	if platform == "app" {
+		platformInfo = info.Capabilities.App
+	}
+	if platformInfo == nil {
+		return false
+	}
+	for _, supported := range platformInfo.MediaTypes {
+		if supported == mediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBidderInfoFiles(t *testing.T) {
+	codes := []string{"appnexus", "audienceNetwork", "debug", "districtm", "indexExchange", "lifestreet", "pubmatic", "pulsepoint", "rubicon"}
+	infos, err := ParseBidderInfos("../static/bidder-info", codes)
+	if err != nil {
+		t.Fatalf("Failed to load the bidder info: %v", err)
+	}
+	if len(infos) != len(codes) {
+		t.Errorf("Expected info for %d bidders. Got %d", len(codes), len(infos))
+	}
+	if infos["districtm"].Maintainer.Email != infos["appnexus"].Maintainer.Email {
+		t.Error("districtm should share the appnexus info")
+	}
+	vendorIDs := infos.VendorIDs()
+	if vendorIDs["appnexus"] != 32 || vendorIDs["rubicon"] != 52 {
+		t.Errorf("Expected the GVL vendor IDs from the files. Got %v", vendorIDs)
+	}
+	if _, ok := vendorIDs["audienceNetwork"]; ok {
+		t.Error("Bidders without a GVL vendor ID should be left out")
+	}
+}
+
+func TestParseBidderInfosErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bidder-info")
+	if err != nil {
+		t.Fatalf("Failed to make a temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"good":         "maintainer:\n  email: a@b.com\ncapabilities:\n  site:\n    mediaTypes: [banner]\n",
+		"nomaintainer": "capabilities:\n  site:\n    mediaTypes: [banner]\n",
+		"noplatforms":  "maintainer:\n  email: a@b.com\ncapabilities: {}\n",
+		"badtype":      "maintainer:\n  email: a@b.com\ncapabilities:\n  app:\n    mediaTypes: [popup]\n",
+		"badcompress":  "maintainer:\n  email: a@b.com\ncapabilities:\n  site:\n    mediaTypes: [banner]\nendpointCompression: brotli\n",
+		"badyaml":      "maintainer: [",
+		"siteskadn":    "maintainer:\n  email: a@b.com\ncapabilities:\n  site:\n    mediaTypes: [banner]\n    skadn: true\n",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if _, err := ParseBidderInfos(dir, []string{"good"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, code := range []string{"nomaintainer", "noplatforms", "badtype", "badcompress", "badyaml", "siteskadn", "missing"} {
+		_, err := ParseBidderInfos(dir, []string{"good", code})
+		if err == nil {
+			t.Errorf("Expected an error for %s", code)
+		} else if !strings.Contains(err.Error(), code) {
+			t.Errorf("The error should name %s. Got %v", code, err)
+		}
+	}
+}
+
+func TestBidderInfoSupports(t *testing.T) {
+	info := BidderInfo{
+		Capabilities: &CapabilitiesInfo{
+			App: &PlatformInfo{MediaTypes: []string{"banner", "video"}},
+		},
+	}
+	if !info.Supports("app", "video") {
+		t.Error("Expected app video to be supported")
+	}
+	if info.Supports("app", "native") || info.Supports("site", "banner") {
+		t.Error("Only the listed platforms and media types should be supported")
+	}
+	if (BidderInfo{}).Supports("site", "banner") {
+		t.Error("Bidders without capabilities support nothing")
+	}
+	if info.SupportsSKAdN() || (BidderInfo{}).SupportsSKAdN() {
+		t.Error("SKAdNetwork should only be supported if the bidder declares it")
+	}
+	info.Capabilities.App.SKAdN = true
+	if !info.SupportsSKAdN() {
+		t.Error("Expected SKAdNetwork to be supported")
+	}
+}
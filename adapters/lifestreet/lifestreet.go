@@ -8,19 +8,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
 
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/pbs"
-	"golang.org/x/net/context/ctxhttp"
 )
 
 type LifestreetAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
 }
 
 /* Name - export adapter name */
@@ -33,8 +32,8 @@ func (a *LifestreetAdapter) FamilyName() string {
 	return "lifestreet"
 }
 
-func (a *LifestreetAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *LifestreetAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *LifestreetAdapter) SkipNoCookies() bool {
@@ -47,52 +46,76 @@ type lifestreetParams struct {
 }
 
 func (a *LifestreetAdapter) callOne(ctx context.Context, req *pbs.PBSRequest, reqJSON bytes.Buffer) (result adapters.CallOneResult, err error) {
-	httpReq, err := http.NewRequest("POST", a.URI, &reqJSON)
-	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
-	httpReq.Header.Add("Accept", "application/json")
-
-	lsmResp, e := ctxhttp.Do(ctx, a.http.Client, httpReq)
-	if e != nil {
-		err = e
+	status, body, timing, err := a.post(ctx, &reqJSON)
+	result.Timing = timing
+	result.StatusCode = status
+	result.ResponseBody = string(body)
+	if err != nil || status == 204 {
 		return
 	}
 
-	defer lsmResp.Body.Close()
-	body, _ := ioutil.ReadAll(lsmResp.Body)
-	result.ResponseBody = string(body)
+	bids, err := parseResponse(status, body)
+	if len(bids) > 0 {
+		result.Bid = bids[0]
+	}
+	return
+}
 
-	result.StatusCode = lsmResp.StatusCode
+// post sends the request to Lifestreet, and returns the response's status code and body.
+func (a *LifestreetAdapter) post(ctx context.Context, reqJSON *bytes.Buffer) (int, []byte, *adapters.CallTiming, error) {
+	httpReq, err := http.NewRequest("POST", a.URI, reqJSON)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
+	httpReq.Header.Add("Accept", "application/json")
 
-	if lsmResp.StatusCode == 204 {
-		return
+	lsmResp, timing, err := a.http.DoRequest(ctx, httpReq)
+	if err != nil {
+		return 0, nil, timing, err
 	}
+	defer lsmResp.Body.Close()
+	body, err := ioutil.ReadAll(lsmResp.Body)
+	return lsmResp.StatusCode, body, timing, err
+}
 
-	if lsmResp.StatusCode != 200 {
-		err = fmt.Errorf("HTTP status %d; body: %s", lsmResp.StatusCode, result.ResponseBody)
-		return
+// parseResponse reads the bids from a response which isn't a 204. Their bidder codes and bid IDs are left
+// for the caller to fill in.
+func parseResponse(status int, body []byte) (pbs.PBSBidSlice, error) {
+	if status != 200 {
+		return nil, fmt.Errorf("HTTP status %d; body: %s", status, string(body))
 	}
 
 	var bidResp openrtb.BidResponse
-	err = json.Unmarshal(body, &bidResp)
-	if err != nil {
-		return
-	}
-	if len(bidResp.SeatBid) == 0 || len(bidResp.SeatBid[0].Bid) == 0 {
-		return
+	if err := json.Unmarshal(body, &bidResp); err != nil {
+		return nil, &errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: body,
+		}
 	}
-	bid := bidResp.SeatBid[0].Bid[0]
 
-	result.Bid = &pbs.PBSBid{
-		AdUnitCode:  bid.ImpID,
-		Price:       bid.Price,
-		Adm:         bid.AdM,
-		Creative_id: bid.CrID,
-		Width:       bid.W,
-		Height:      bid.H,
-		DealId:      bid.DealID,
-		NURL:        bid.NURL,
+	bids := make(pbs.PBSBidSlice, 0)
+	for _, sb := range bidResp.SeatBid {
+		for i := range sb.Bid {
+			bid := &sb.Bid[i]
+			bids = append(bids, &pbs.PBSBid{
+				AdUnitCode:    bid.ImpID,
+				Price:         bid.Price,
+				Adm:           bid.AdM,
+				BURL:          bid.BURL,
+				BidResponseID: bidResp.BidID,
+				Creative_id:   bid.CrID,
+				Width:         bid.W,
+				Height:        bid.H,
+				DealId:        bid.DealID,
+				NURL:          bid.NURL,
+				Exp:           bid.Exp,
+				Meta:          adapters.MakeBidMeta(bid, ""),
+				Ext:           adapters.MakeBidExt(bid, bidResp.Cur),
+			})
+		}
 	}
-	return
+	return bids, nil
 }
 
 func (a *LifestreetAdapter) MakeOpenRtbBidRequest(req *pbs.PBSRequest, bidder *pbs.PBSBidder, slotTag string, mtype pbs.MediaType, unitInd int) (openrtb.BidRequest, error) {
@@ -157,6 +180,9 @@ func (a *LifestreetAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidde
 	ch := make(chan adapters.CallOneResult)
 	for i, _ := range bidder.AdUnits {
 		go func(bidder *pbs.PBSBidder, reqJSON bytes.Buffer) {
+			defer adapters.RecoverPanic(bidder.BidderCode, func(err error) {
+				ch <- adapters.CallOneResult{Error: err}
+			})
 			result, err := a.callOne(ctx, req, reqJSON)
 			result.Error = err
 			if result.Bid != nil {
@@ -185,6 +211,7 @@ func (a *LifestreetAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidde
 				RequestBody:  requests[i].String(),
 				StatusCode:   result.StatusCode,
 				ResponseBody: result.ResponseBody,
+				Timing:       result.Timing.Debug(),
 			}
 			bidder.Debug = append(bidder.Debug, debug)
 		}
@@ -199,21 +226,79 @@ func (a *LifestreetAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidde
 	return bids, nil
 }
 
-func NewLifestreetAdapter(config *adapters.HTTPAdapterConfig, externalURL string) *LifestreetAdapter {
-	a := adapters.NewHTTPAdapter(config)
+// CallMultiImp sends one request with a banner or video imp for each ad unit. Each imp is tagged with the unit's slot_tag.
+func (a *LifestreetAdapter) CallMultiImp(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	lsReq, err := adapters.MakeOpenRTBGeneric(req, bidder, a.FamilyName(), []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_VIDEO}, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lsReq.Imp {
+		unit := bidder.LookupAdUnit(lsReq.Imp[i].ID)
+		if unit == nil {
+			return nil, fmt.Errorf("Unknown ad unit code '%s'", lsReq.Imp[i].ID)
+		}
+		var params lifestreetParams
+		if err := json.Unmarshal(unit.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(strings.Split(params.SlotTag, ".")) != 2 {
+			return nil, fmt.Errorf("Invalid slot_tag param '%s'", params.SlotTag)
+		}
+		if lsReq.Imp[i].Banner != nil {
+			lsReq.Imp[i].Banner.Format = nil
+		}
+		lsReq.Imp[i].TagID = params.SlotTag
+	}
+
+	var reqJSON bytes.Buffer
+	if err := json.NewEncoder(&reqJSON).Encode(lsReq); err != nil {
+		return nil, err
+	}
+	debug := &pbs.BidderDebug{
+		RequestURI: a.URI,
+	}
+	if req.IsDebug {
+		debug.RequestBody = reqJSON.String()
+		bidder.Debug = append(bidder.Debug, debug)
+	}
+
+	status, body, timing, err := a.post(ctx, &reqJSON)
+	debug.Timing = timing.Debug()
+	debug.StatusCode = status
+	if req.IsDebug {
+		debug.ResponseBody = string(body)
+	}
+	if err != nil || status == 204 {
+		return nil, err
+	}
+
+	bids, err := parseResponse(status, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, bid := range bids {
+		bid.BidID = bidder.LookupBidID(bid.AdUnitCode)
+		if bid.BidID == "" {
+			return nil, fmt.Errorf("Unknown ad unit code '%s'", bid.AdUnitCode)
+		}
+		bid.BidderCode = bidder.BidderCode
+	}
+	return bids, nil
+}
 
-	redirect_uri := fmt.Sprintf("%s/setuid?bidder=lifestreet&uid=$$visitor_cookie$$", externalURL)
-	usersyncURL := "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl="
+func NewLifestreetAdapter(config *adapters.HTTPAdapterConfig, uri string, usersyncURL string, externalURL string) *LifestreetAdapter {
+	a := adapters.NewHTTPAdapter(config)
 
-	info := &pbs.UsersyncInfo{
-		URL:         fmt.Sprintf("%s%s", usersyncURL, url.QueryEscape(redirect_uri)),
+	info := &pbs.UsersyncTemplate{
+		URL:         usersyncURL,
+		RedirectURL: externalURL + "/setuid?bidder=lifestreet&uid=$$visitor_cookie$$",
 		Type:        "redirect",
 		SupportCORS: false,
 	}
 
 	return &LifestreetAdapter{
-		http:         a,
-		URI:          "https://prebid.s2s.lfstmedia.com/adrequest",
-		usersyncInfo: info,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
 	}
 }
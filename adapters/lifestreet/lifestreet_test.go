@@ -174,7 +174,7 @@ func TestLifestreetBasicResponse(t *testing.T) {
 	}
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewLifestreetAdapter(&conf, server.URL)
+	an := NewLifestreetAdapter(&conf, server.URL, "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl={{redirect_url}}", server.URL)
 	an.URI = server.URL
 
 	pbin := pbs.PBSRequest{
@@ -223,7 +223,7 @@ func TestLifestreetBasicResponse(t *testing.T) {
 	req.Header.Add("Referer", lsdata.referrer)
 	req.Header.Add("X-Real-IP", lsdata.deviceIP)
 
-	pc := pbs.ParsePBSCookieFromRequest(req)
+	pc := pbs.ParsePBSCookieFromRequest(req, nil)
 	fakewriter := httptest.NewRecorder()
 	pc.SetCookieOnResponse(fakewriter, "")
 	req.Header.Add("Cookie", fakewriter.Header().Get("Set-Cookie"))
@@ -284,17 +284,72 @@ func TestLifestreetBasicResponse(t *testing.T) {
 	}
 }
 
+func TestLifestreetMultiImp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var breq openrtb.BidRequest
+		if err := json.NewDecoder(r.Body).Decode(&breq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(breq.Imp) != 2 {
+			http.Error(w, fmt.Sprintf("Expected 2 imps. Got %d", len(breq.Imp)), http.StatusBadRequest)
+			return
+		}
+		resp := openrtb.BidResponse{ID: breq.ID, Cur: "USD", SeatBid: []openrtb.SeatBid{{Seat: "LSM"}}}
+		for _, imp := range breq.Imp {
+			if imp.TagID != "slot123.123" || imp.Banner == nil || imp.Banner.Format != nil {
+				http.Error(w, fmt.Sprintf("Bad imp: %v", imp), http.StatusBadRequest)
+				return
+			}
+			resp.SeatBid[0].Bid = append(resp.SeatBid[0].Bid, openrtb.Bid{ID: "bid-" + imp.ID, ImpID: imp.ID, Price: 1.5, W: 300, H: 250})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	an := NewLifestreetAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl={{redirect_url}}", server.URL)
+	an.URI = server.URL
+	pbReq := &pbs.PBSRequest{App: &openrtb.App{Bundle: "com.example.app"}, IsDebug: true}
+	bidder := &pbs.PBSBidder{BidderCode: "lifestreet"}
+	for _, code := range []string{"first-tag", "second-tag"} {
+		bidder.AdUnits = append(bidder.AdUnits, pbs.PBSAdUnit{
+			Code:       code,
+			BidID:      "bid-id-" + code,
+			Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+			Params:     json.RawMessage(`{"slot_tag":"slot123.123"}`),
+		})
+	}
+
+	bids, err := an.CallMultiImp(context.Background(), pbReq, bidder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(bids) != 2 {
+		t.Fatalf("Expected 2 bids. Got %d", len(bids))
+	}
+	for _, bid := range bids {
+		if bid.BidID != "bid-id-"+bid.AdUnitCode || bid.BidderCode != "lifestreet" {
+			t.Errorf("Bad bid: %v", bid)
+		}
+	}
+	if len(bidder.Debug) != 1 || bidder.Debug[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected debug info for the one request")
+	}
+}
+
 func TestLifestreetUserSyncInfo(t *testing.T) {
 	url := "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl=localhost%2Fsetuid%3Fbidder%3Dlifestreet%26uid%3D%24%24visitor_cookie%24%24"
 
-	an := NewLifestreetAdapter(adapters.DefaultHTTPAdapterConfig, "localhost")
-	if an.usersyncInfo.URL != url {
-		t.Fatalf("User Sync Info URL '%s' doesn't match '%s'", an.usersyncInfo.URL, url)
+	an := NewLifestreetAdapter(adapters.DefaultHTTPAdapterConfig, "https://prebid.s2s.lfstmedia.com/adrequest", "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl={{redirect_url}}", "localhost")
+	usersyncInfo := an.GetUsersyncInfo(pbs.UsersyncPrivacy{})
+	if usersyncInfo.URL != url {
+		t.Fatalf("User Sync Info URL '%s' doesn't match '%s'", usersyncInfo.URL, url)
 	}
-	if an.usersyncInfo.Type != "redirect" {
+	if usersyncInfo.Type != "redirect" {
 		t.Fatalf("should be redirect")
 	}
-	if an.usersyncInfo.SupportCORS != false {
+	if usersyncInfo.SupportCORS != false {
 		t.Fatalf("should have been false")
 	}
 }
@@ -1,9 +1,14 @@
 package adapters
 
 import (
+	"github.com/prebid/prebid-server/gdpr"
 	"github.com/prebid/prebid-server/pbs"
 
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/mxmCherry/openrtb"
 )
@@ -72,17 +77,61 @@ func makeVideo(unit pbs.PBSAdUnit) *openrtb.Video {
 	}
 }
 
+func makeNative(unit pbs.PBSAdUnit) (*openrtb.Native, error) {
+	if len(unit.Native) == 0 {
+		return nil, errors.New("Invalid AdUnit: NATIVE media type with no native request")
+	}
+	request, _, err := unit.NativeRequest()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid AdUnit: bad native request: %v", err)
+	}
+	return &openrtb.Native{
+		Request: request,
+		Ver:     pbs.NativeVersion,
+	}, nil
+}
+
+// sizedMediaTypes drops the media types which need sizes, for ad units which don't have any.
+func sizedMediaTypes(unit pbs.PBSAdUnit, mediaTypes []pbs.MediaType) []pbs.MediaType {
+	if len(unit.Sizes) > 0 {
+		return mediaTypes
+	}
+	res := make([]pbs.MediaType, 0, len(mediaTypes))
+	for _, mType := range mediaTypes {
+		if mType == pbs.MEDIA_TYPE_NATIVE {
+			res = append(res, mType)
+		}
+	}
+	return res
+}
+
 // adapters.MakeOpenRTBGeneric makes an openRTB request from the PBS-specific structs.
 //
 // Any objects pointed to by the returned BidRequest *must not be mutated*, or we will get race conditions.
 // The only exception is the Imp property, whose objects will be created new by this method and can be mutated freely.
+//
+// The personal data which the bidder's GDPR restrictions cover, and the fields in its StripFields, are removed
+// from the request. Bidders which don't read GPP get its sections as regs.ext.gdpr, user.ext.consent and
+// regs.ext.us_privacy instead.
 func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily string, allowedMediatypes []pbs.MediaType, singleMediaTypeImp bool) (openrtb.BidRequest, error) {
+	request, err := makeOpenRTBGeneric(req, bidder, bidderFamily, allowedMediatypes, singleMediaTypeImp)
+	if err != nil {
+		return request, err
+	}
+	if !bidder.GPP {
+		gdpr.DowngradeGPP(&request)
+	}
+	bidder.GDPR.Apply(&request)
+	if err := StripFields(&request, bidder.StripFields); err != nil {
+		return openrtb.BidRequest{}, err
+	}
+	return request, nil
+}
+
+func makeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily string, allowedMediatypes []pbs.MediaType, singleMediaTypeImp bool) (openrtb.BidRequest, error) {
 	imps := make([]openrtb.Imp, 0, len(bidder.AdUnits)*len(allowedMediatypes))
 	for _, unit := range bidder.AdUnits {
-		if len(unit.Sizes) <= 0 {
-			continue
-		}
-		unitMediaTypes := commonMediaTypes(unit.MediaTypes, allowedMediatypes)
+		unitMediaTypes := sizedMediaTypes(unit, commonMediaTypes(unit.MediaTypes, allowedMediatypes))
 		if len(unitMediaTypes) == 0 {
 			continue
 		}
@@ -93,6 +142,7 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 					ID:     unit.Code,
 					Secure: &req.Secure,
 					Instl:  unit.Instl,
+					Ext:    makeImpExt(unit),
 				}
 				switch mType {
 				case pbs.MEDIA_TYPE_BANNER:
@@ -103,10 +153,17 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 						return openrtb.BidRequest{}, errors.New("Invalid AdUnit: VIDEO media type with no video data")
 					}
 					newImp.Video = video
+				case pbs.MEDIA_TYPE_NATIVE:
+					native, err := makeNative(unit)
+					if err != nil {
+						return openrtb.BidRequest{}, err
+					}
+					newImp.Native = native
 				default:
 					// Error - unknown media type
 					continue
 				}
+				newImp.BidFloor, newImp.BidFloorCur = unit.Floor.Resolve([]pbs.MediaType{mType})
 				imps = append(imps, newImp)
 			}
 		} else {
@@ -114,6 +171,7 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 				ID:     unit.Code,
 				Secure: &req.Secure,
 				Instl:  unit.Instl,
+				Ext:    makeImpExt(unit),
 			}
 			for _, mType := range unitMediaTypes {
 				switch mType {
@@ -121,11 +179,18 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 					newImp.Banner = makeBanner(unit)
 				case pbs.MEDIA_TYPE_VIDEO:
 					newImp.Video = makeVideo(unit)
+				case pbs.MEDIA_TYPE_NATIVE:
+					native, err := makeNative(unit)
+					if err != nil {
+						return openrtb.BidRequest{}, err
+					}
+					newImp.Native = native
 				default:
 					// Error - unknown media type
 					continue
 				}
 			}
+			newImp.BidFloor, newImp.BidFloorCur = unit.Floor.Resolve(unitMediaTypes)
 			imps = append(imps, newImp)
 		}
 	}
@@ -140,17 +205,27 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 			Imp:    imps,
 			App:    req.App,
 			Device: req.Device,
-			User:   req.User,
+			User:   makeAppUser(req.User, req.BidderBuyerUID(bidder, bidderFamily), bidder.EIDLimits),
 			Source: &openrtb.Source{
 				TID: req.Tid,
+				Ext: makeSourceExt(req.SupplyChain()),
 			},
 			AT:   1,
 			TMax: req.TimeoutMillis,
+			Test: testFlag(bidder),
+			Regs: req.Regs,
+			BApp: bidder.BlockedAppBundles,
+			BAdv: bidder.BlockedDomains,
+			Ext:  makeRequestExt(req),
 		}, nil
 	}
 
-	buyerUID, _, _ := req.Cookie.GetUID(bidderFamily)
+	buyerUID := req.BidderBuyerUID(bidder, bidderFamily)
 	id, _, _ := req.Cookie.GetUID("adnxs")
+	var userData []openrtb.Data
+	if req.User != nil {
+		userData = req.User.Data
+	}
 
 	return openrtb.BidRequest{
 		ID:  req.Tid,
@@ -158,21 +233,266 @@ func MakeOpenRTBGeneric(req *pbs.PBSRequest, bidder *pbs.PBSBidder, bidderFamily
 		Site: &openrtb.Site{
 			Domain: req.Domain,
 			Page:   req.Url,
+			Ref:    req.Ref,
 		},
 		Device: req.Device,
 		User: &openrtb.User{
 			BuyerUID: buyerUID,
 			ID:       id,
+			Data:     userData,
+			Ext:      makeUserExt(req.User, bidder.EIDLimits),
 		},
 		Source: &openrtb.Source{
 			FD:  1, // upstream, aka header
 			TID: req.Tid,
+			Ext: makeSourceExt(req.SupplyChain()),
 		},
 		AT:   1,
 		TMax: req.TimeoutMillis,
+		Test: testFlag(bidder),
+		Regs: req.Regs,
+		BApp: bidder.BlockedAppBundles,
+		BAdv: bidder.BlockedDomains,
+		Ext:  makeRequestExt(req),
 	}, nil
 }
 
+// impAdSlot is the part of imp.ext which every bidder gets, whatever its own params.
+type impAdSlot struct {
+	GPID string         `json:"gpid,omitempty"`
+	Data *impAdSlotData `json:"data,omitempty"`
+}
+
+type impAdSlotData struct {
+	PBAdSlot string `json:"pbadslot"`
+}
+
+// makeImpExt returns the ad unit's gpid and data.pbadslot, or nil if it has neither.
+func makeImpExt(unit pbs.PBSAdUnit) openrtb.RawJSON {
+	slot := impAdSlot{GPID: unit.GPID}
+	if unit.PBAdSlot != "" {
+		slot.Data = &impAdSlotData{PBAdSlot: unit.PBAdSlot}
+	}
+	if slot.GPID == "" && slot.Data == nil {
+		return nil
+	}
+	ext, err := json.Marshal(&slot)
+	if err != nil {
+		return nil
+	}
+	return ext
+}
+
+// SetImpExt replaces the imp's ext with the bidder's own, but keeps the gpid and data.pbadslot which
+// MakeOpenRTBGeneric gave every bidder. A nil ext leaves only those.
+func SetImpExt(imp *openrtb.Imp, ext interface{}) error {
+	var slot impAdSlot
+	if len(imp.Ext) > 0 {
+		json.Unmarshal(imp.Ext, &slot)
+	}
+	fields := make(map[string]json.RawMessage)
+	if ext != nil {
+		raw, err := json.Marshal(ext)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+	}
+	if slot.GPID != "" {
+		fields["gpid"], _ = json.Marshal(slot.GPID)
+	}
+	if slot.Data != nil {
+		fields["data"], _ = json.Marshal(slot.Data)
+	}
+	if len(fields) == 0 {
+		imp.Ext = nil
+		return nil
+	}
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	imp.Ext = merged
+	return nil
+}
+
+// makeAppUser copies the app's user for a single bidder, so that it can carry that bidder's ID and EIDs.
+func makeAppUser(user *openrtb.User, buyerUID string, limits *pbs.EIDLimits) *openrtb.User {
+	ext := limitUserExtEIDs(user, limits)
+	if buyerUID == "" && ext == nil {
+		return user
+	}
+	userCopy := openrtb.User{}
+	if user != nil {
+		userCopy = *user
+	}
+	if buyerUID != "" {
+		userCopy.BuyerUID = buyerUID
+	}
+	if ext != nil {
+		userCopy.Ext = ext
+	}
+	return &userCopy
+}
+
+// limitUserExtEIDs returns a copy of user.ext with the EIDs cut down to the bidder's limits, or nil if
+// the ext doesn't need to change.
+func limitUserExtEIDs(user *openrtb.User, limits *pbs.EIDLimits) openrtb.RawJSON {
+	if user == nil || len(user.Ext) == 0 || limits == nil {
+		return nil
+	}
+	var ext map[string]json.RawMessage
+	if err := json.Unmarshal(user.Ext, &ext); err != nil || len(ext["eids"]) == 0 {
+		return nil
+	}
+	eids, changed := limitEIDs(ext["eids"], limits)
+	if !changed {
+		return nil
+	}
+	ext["eids"] = eids
+	b, err := json.Marshal(ext)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+type eidSource struct {
+	Source string `json:"source"`
+}
+
+// limitEIDs keeps the bidder's highest priority EIDs, within its limits. An EID which would take the
+// total over MaxBytes is skipped, so smaller ones after it may still fit. It returns false if nothing
+// was dropped.
+func limitEIDs(eids json.RawMessage, limits *pbs.EIDLimits) (json.RawMessage, bool) {
+	if limits == nil || (limits.MaxSources <= 0 && limits.MaxBytes <= 0) {
+		return eids, false
+	}
+	var all []json.RawMessage
+	if err := json.Unmarshal(eids, &all); err != nil {
+		return eids, false
+	}
+
+	ranks := make([]int, len(all))
+	ordered := make([]int, len(all))
+	for i, eid := range all {
+		ordered[i] = i
+		ranks[i] = len(limits.Priority)
+		var source eidSource
+		json.Unmarshal(eid, &source)
+		for rank, prioritized := range limits.Priority {
+			if strings.EqualFold(prioritized, source.Source) {
+				ranks[i] = rank
+				break
+			}
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ranks[ordered[i]] < ranks[ordered[j]]
+	})
+
+	kept := make([]json.RawMessage, 0, len(all))
+	size := 0
+	for _, i := range ordered {
+		eid := all[i]
+		if limits.MaxSources > 0 && len(kept) == limits.MaxSources {
+			break
+		}
+		if limits.MaxBytes > 0 && size+len(eid) > limits.MaxBytes {
+			continue
+		}
+		kept = append(kept, eid)
+		size += len(eid)
+	}
+	if len(kept) == len(all) {
+		return eids, false
+	}
+	b, err := json.Marshal(kept)
+	if err != nil {
+		return eids, false
+	}
+	return b, true
+}
+
+type sourceExt struct {
+	SChain *pbs.SupplyChain `json:"schain"`
+}
+
+// makeSourceExt passes the request's supply chain on to bidders.
+func makeSourceExt(chain *pbs.SupplyChain) openrtb.RawJSON {
+	if chain == nil {
+		return nil
+	}
+	ext, err := json.Marshal(&sourceExt{SChain: chain})
+	if err != nil {
+		return nil
+	}
+	return ext
+}
+
+// testFlag returns the OpenRTB test value for the bidder's request.
+func testFlag(bidder *pbs.PBSBidder) int8 {
+	if bidder.Test {
+		return 1
+	}
+	return 0
+}
+
+type userExt struct {
+	Consent string          `json:"consent,omitempty"`
+	EIDs    json.RawMessage `json:"eids,omitempty"`
+}
+
+// makeUserExt passes the user's GDPR consent string and EIDs on to bidders, with the EIDs within their limits,
+// but nothing else from user.ext.
+func makeUserExt(user *openrtb.User, limits *pbs.EIDLimits) openrtb.RawJSON {
+	if user == nil || len(user.Ext) == 0 {
+		return nil
+	}
+	var ext userExt
+	if err := json.Unmarshal(user.Ext, &ext); err != nil || (len(ext.EIDs) == 0 && ext.Consent == "") {
+		return nil
+	}
+	ext.EIDs, _ = limitEIDs(ext.EIDs, limits)
+	b, err := json.Marshal(&ext)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+type requestExtSDK struct {
+	Renderers []pbs.SDKRenderer `json:"renderers"`
+}
+
+type requestExtPrebid struct {
+	SDK     *requestExtSDK `json:"sdk,omitempty"`
+	Channel *pbs.Channel   `json:"channel,omitempty"`
+}
+
+type requestExt struct {
+	Prebid requestExtPrebid `json:"prebid"`
+}
+
+// makeRequestExt tells bidders which integration sent the request, in ext.prebid.channel, and which
+// renderers the mobile SDK supports, in ext.prebid.sdk.renderers.
+func makeRequestExt(req *pbs.PBSRequest) openrtb.RawJSON {
+	prebid := requestExtPrebid{Channel: req.Channel()}
+	if req.SDK != nil && len(req.SDK.Renderers) > 0 {
+		prebid.SDK = &requestExtSDK{Renderers: req.SDK.Renderers}
+	}
+	if prebid.SDK == nil && prebid.Channel == nil {
+		return nil
+	}
+	ext, err := json.Marshal(requestExt{Prebid: prebid})
+	if err != nil {
+		return nil
+	}
+	return ext
+}
+
 func copyFormats(sizes []openrtb.Format) []openrtb.Format {
 	sizesCopy := make([]openrtb.Format, len(sizes))
 	for i := 0; i < len(sizes); i++ {
@@ -181,3 +501,45 @@ func copyFormats(sizes []openrtb.Format) []openrtb.Format {
 	}
 	return sizesCopy
 }
+
+type bidExtPrebid struct {
+	Meta *pbs.PBSBidMeta `json:"meta"`
+}
+
+type bidExt struct {
+	Prebid bidExtPrebid `json:"prebid"`
+}
+
+// MakeBidExt records the price and currency which the bidder originally returned for this bid.
+// OpenRTB defaults the response currency to USD if the bidder doesn't send one.
+func MakeBidExt(bid *openrtb.Bid, cur string) *pbs.PBSBidExt {
+	if cur == "" {
+		cur = "USD"
+	}
+	return &pbs.PBSBidExt{
+		OrigBidCPM: bid.Price,
+		OrigBidCur: cur,
+	}
+}
+
+// MakeBidMeta builds the transparency data for a bid which came back from an OpenRTB bidder.
+//
+// Bidders can send their own values in bid.ext.prebid.meta. If they don't list any advertiser domains
+// there, the standard OpenRTB adomain field will be used instead.
+func MakeBidMeta(bid *openrtb.Bid, mediaType string) *pbs.PBSBidMeta {
+	meta := &pbs.PBSBidMeta{}
+	if len(bid.Ext) > 0 {
+		var ext bidExt
+		if err := json.Unmarshal(bid.Ext, &ext); err == nil && ext.Prebid.Meta != nil {
+			meta = ext.Prebid.Meta
+		}
+	}
+	if len(meta.AdvertiserDomains) == 0 && len(bid.ADomain) > 0 {
+		meta.AdvertiserDomains = make([]string, len(bid.ADomain))
+		copy(meta.AdvertiserDomains, bid.ADomain)
+	}
+	if meta.MediaType == "" {
+		meta.MediaType = mediaType
+	}
+	return meta
+}
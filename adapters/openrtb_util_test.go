@@ -1,7 +1,10 @@
 package adapters
 
 import (
+	"encoding/json"
+
 	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/gdpr"
 	"github.com/prebid/prebid-server/pbs"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -269,6 +272,49 @@ func TestOpenRTBSingleMediaImp(t *testing.T) {
 	assert.EqualValues(t, *resp.Imp[1].Banner.W, 10)
 }
 
+func TestOpenRTBMediaTypeFloors(t *testing.T) {
+
+	pbReq := pbs.PBSRequest{}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO, pbs.MEDIA_TYPE_BANNER},
+				Sizes: []openrtb.Format{
+					{
+						W: 10,
+						H: 12,
+					},
+				},
+				Video: pbs.PBSVideo{
+					Mimes: []string{"video/mp4"},
+				},
+				Floor: &pbs.PBSFloor{
+					Value:      0.5,
+					MediaTypes: map[string]float64{"video": 2},
+					Currency:   "EUR",
+				},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO, pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(resp.Imp), 2)
+	assert.EqualValues(t, resp.Imp[0].BidFloor, 2)
+	assert.EqualValues(t, resp.Imp[1].BidFloor, 0.5)
+	assert.Equal(t, resp.Imp[1].BidFloorCur, "EUR")
+
+	resp, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO, pbs.MEDIA_TYPE_BANNER}, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(resp.Imp), 1)
+	assert.EqualValues(t, resp.Imp[0].BidFloor, 0.5)
+
+	resp, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_VIDEO}, false)
+	assert.Equal(t, err, nil)
+	assert.EqualValues(t, resp.Imp[0].BidFloor, 2)
+}
+
 func TestOpenRTBNoSize(t *testing.T) {
 
 	pbReq := pbs.PBSRequest{}
@@ -287,6 +333,37 @@ func TestOpenRTBNoSize(t *testing.T) {
 	}
 }
 
+func TestOpenRTBNative(t *testing.T) {
+	nativeRequest := `{"assets":[{"id":1,"required":1,"title":{"len":90}}]}`
+	encoded, _ := json.Marshal(nativeRequest)
+	pbReq := pbs.PBSRequest{}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "nativeCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_NATIVE},
+				Native:     encoded,
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER, pbs.MEDIA_TYPE_NATIVE}, true)
+	assert.Equal(t, err, nil)
+	// Native ad units don't need sizes, but their banners do.
+	assert.Equal(t, len(resp.Imp), 1)
+	assert.Equal(t, resp.Imp[0].Banner == nil, true)
+	assert.Equal(t, resp.Imp[0].Native.Request, nativeRequest)
+	assert.Equal(t, resp.Imp[0].Native.Ver, "1.2")
+
+	pbBidder.AdUnits[0].Native = json.RawMessage(`{"assets":[]}`)
+	_, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_NATIVE}, false)
+	assert.NotEqual(t, err, nil)
+
+	pbBidder.AdUnits[0].Native = nil
+	_, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_NATIVE}, true)
+	assert.NotEqual(t, err, nil)
+}
+
 func TestOpenRTBMobile(t *testing.T) {
 	pbReq := pbs.PBSRequest{
 		AccountID:     "test_account_id",
@@ -345,6 +422,55 @@ func TestOpenRTBMobile(t *testing.T) {
 	assert.EqualValues(t, resp.Device.IFA, "test_ifa")
 }
 
+func TestOpenRTBMobileRenderers(t *testing.T) {
+	pbReq := pbs.PBSRequest{
+		App: &openrtb.App{
+			Bundle: "AppNexus.PrebidMobileDemo",
+		},
+		SDK: &pbs.SDK{
+			Renderers: []pbs.SDKRenderer{{Name: "MraidRenderer", Version: "1.0"}},
+		},
+	}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes: []openrtb.Format{
+					{
+						W: 300,
+						H: 250,
+					},
+				},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.Ext), `{"prebid":{"sdk":{"renderers":[{"name":"MraidRenderer","version":"1.0"}]}}}`)
+}
+
+func TestOpenRTBChannel(t *testing.T) {
+	pbReq := pbs.PBSRequest{
+		Cookie: pbs.NewPBSCookie(),
+		Ext:    &pbs.PBSRequestExt{Prebid: pbs.PBSRequestExtPrebid{Channel: &pbs.Channel{Name: "pbjs"}}},
+	}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.Ext), `{"prebid":{"channel":{"name":"pbjs"}}}`)
+}
+
 func TestOpenRTBEmptyUser(t *testing.T) {
 	pbReq := pbs.PBSRequest{
 		User: &openrtb.User{},
@@ -396,6 +522,291 @@ func TestOpenRTBUserWithCookie(t *testing.T) {
 	assert.EqualValues(t, resp.User.BuyerUID, "abcde")
 }
 
+func TestOpenRTBUserData(t *testing.T) {
+	pbReq := pbs.PBSRequest{
+		User: &openrtb.User{
+			Data: []openrtb.Data{{Name: "Sec-Browsing-Topics", Segment: []openrtb.Segment{{ID: "1"}}}},
+		},
+		Cookie: pbs.NewPBSCookie(),
+	}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes: []openrtb.Format{
+					{
+						W: 300,
+						H: 250,
+					},
+				},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(resp.User.Data), 1)
+	assert.Equal(t, resp.User.Data[0].Segment[0].ID, "1")
+}
+
+func TestOpenRTBUserEIDs(t *testing.T) {
+	pbReq := pbs.PBSRequest{
+		User: &openrtb.User{
+			Ext: openrtb.RawJSON(`{"eids":[{"source":"liveramp.com","uids":[{"id":"rampid"}]}],"consent":"abc"}`),
+		},
+		Cookie: pbs.NewPBSCookie(),
+	}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.User.Ext), `{"consent":"abc","eids":[{"source":"liveramp.com","uids":[{"id":"rampid"}]}]}`)
+}
+
+func TestLimitEIDs(t *testing.T) {
+	eids := json.RawMessage(`[{"source":"a.com","uids":[{"id":"1"}]},{"source":"b.com","uids":[{"id":"2"}]},{"source":"c.com","uids":[{"id":"3"}]}]`)
+
+	limited, changed := limitEIDs(eids, &pbs.EIDLimits{MaxSources: 2, Priority: []string{"c.com"}})
+	assert.Equal(t, changed, true)
+	assert.Equal(t, string(limited), `[{"source":"c.com","uids":[{"id":"3"}]},{"source":"a.com","uids":[{"id":"1"}]}]`)
+
+	// Each EID is 36 bytes, so only two fit.
+	limited, changed = limitEIDs(eids, &pbs.EIDLimits{MaxBytes: 80})
+	assert.Equal(t, changed, true)
+	assert.Equal(t, string(limited), `[{"source":"a.com","uids":[{"id":"1"}]},{"source":"b.com","uids":[{"id":"2"}]}]`)
+
+	limited, changed = limitEIDs(eids, &pbs.EIDLimits{MaxSources: 3})
+	assert.Equal(t, changed, false)
+	assert.Equal(t, string(limited), string(eids))
+
+	limited, changed = limitEIDs(eids, nil)
+	assert.Equal(t, changed, false)
+}
+
+func TestOpenRTBLimitedEIDs(t *testing.T) {
+	ext := `{"eids":[{"source":"a.com","uids":[{"id":"1"}]},{"source":"b.com","uids":[{"id":"2"}]}]}`
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+		EIDLimits: &pbs.EIDLimits{MaxSources: 1, Priority: []string{"b.com"}},
+	}
+
+	webReq := pbs.PBSRequest{User: &openrtb.User{Ext: openrtb.RawJSON(ext)}, Cookie: pbs.NewPBSCookie()}
+	resp, err := MakeOpenRTBGeneric(&webReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.User.Ext), `{"eids":[{"source":"b.com","uids":[{"id":"2"}]}]}`)
+
+	appUser := &openrtb.User{Ext: openrtb.RawJSON(ext)}
+	appReq := pbs.PBSRequest{App: &openrtb.App{ID: "com.app"}, User: appUser}
+	resp, err = MakeOpenRTBGeneric(&appReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.User.Ext), `{"eids":[{"source":"b.com","uids":[{"id":"2"}]}]}`)
+	assert.Equal(t, string(appUser.Ext), ext)
+}
+
+func TestOpenRTBStripFields(t *testing.T) {
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+		StripFields: []string{"user.geo", "device.ip"},
+	}
+
+	appUser := &openrtb.User{ID: "u1", Geo: &openrtb.Geo{Country: "USA"}}
+	appReq := pbs.PBSRequest{App: &openrtb.App{ID: "com.app"}, User: appUser, Device: &openrtb.Device{IP: "1.2.3.4", UA: "ua"}}
+	resp, err := MakeOpenRTBGeneric(&appReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.User.ID, "u1")
+	assert.Nil(t, resp.User.Geo)
+	assert.Equal(t, resp.Device.IP, "")
+	assert.Equal(t, resp.Device.UA, "ua")
+	assert.Equal(t, resp.Imp[0].ID, "unitCode")
+	assert.Equal(t, appUser.Geo.Country, "USA")
+	assert.Equal(t, appReq.Device.IP, "1.2.3.4")
+}
+
+func TestOpenRTBGDPR(t *testing.T) {
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+		GDPR: gdpr.Restrictions{UserIDs: true, PreciseGeo: true},
+	}
+
+	regs := &openrtb.Regs{Ext: openrtb.RawJSON(`{"gdpr":1}`)}
+	appReq := pbs.PBSRequest{
+		App:    &openrtb.App{ID: "com.app"},
+		User:   &openrtb.User{ID: "u1", Ext: openrtb.RawJSON(`{"consent":"abc"}`)},
+		Device: &openrtb.Device{IP: "192.0.2.55", IFA: "ifa"},
+		Regs:   regs,
+	}
+	resp, err := MakeOpenRTBGeneric(&appReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.Regs, regs)
+	assert.Equal(t, resp.User.ID, "")
+	assert.Equal(t, string(resp.User.Ext), `{"consent":"abc"}`)
+	assert.Equal(t, resp.Device.IP, "192.0.2.0")
+	assert.Equal(t, resp.Device.IFA, "")
+	assert.Equal(t, appReq.User.ID, "u1")
+	assert.Equal(t, appReq.Device.IP, "192.0.2.55")
+}
+
+func TestOpenRTBBlocked(t *testing.T) {
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+		BlockedAppBundles: []string{"com.example.game"},
+		BlockedDomains:    []string{"bad.com"},
+	}
+
+	appReq := pbs.PBSRequest{App: &openrtb.App{ID: "com.app"}}
+	resp, err := MakeOpenRTBGeneric(&appReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.BApp, []string{"com.example.game"})
+	assert.Equal(t, resp.BAdv, []string{"bad.com"})
+
+	siteReq := pbs.PBSRequest{Cookie: pbs.NewPBSCookie()}
+	resp, err = MakeOpenRTBGeneric(&siteReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.BAdv, []string{"bad.com"})
+}
+
+func TestOpenRTBGPID(t *testing.T) {
+	pbReq := pbs.PBSRequest{Cookie: pbs.NewPBSCookie()}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "slotted",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+				GPID:       "/1111/home#top",
+				PBAdSlot:   "/1111/home",
+			},
+			{
+				Code:       "plain",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.Imp[0].Ext), `{"gpid":"/1111/home#top","data":{"pbadslot":"/1111/home"}}`)
+	assert.Equal(t, len(resp.Imp[1].Ext), 0)
+
+	params := map[string]int{"zone": 5}
+	assert.Equal(t, SetImpExt(&resp.Imp[0], params), nil)
+	assert.Equal(t, string(resp.Imp[0].Ext), `{"data":{"pbadslot":"/1111/home"},"gpid":"/1111/home#top","zone":5}`)
+	assert.Equal(t, SetImpExt(&resp.Imp[0], nil), nil)
+	assert.Equal(t, string(resp.Imp[0].Ext), `{"data":{"pbadslot":"/1111/home"},"gpid":"/1111/home#top"}`)
+	assert.Equal(t, SetImpExt(&resp.Imp[1], params), nil)
+	assert.Equal(t, string(resp.Imp[1].Ext), `{"zone":5}`)
+}
+
+func TestOpenRTBTestFlag(t *testing.T) {
+	pbReq := pbs.PBSRequest{Cookie: pbs.NewPBSCookie()}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.Test, int8(0))
+
+	pbBidder.Test = true
+	resp, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.Test, int8(1))
+}
+
+func TestOpenRTBSupplyChain(t *testing.T) {
+	pbReq := pbs.PBSRequest{Cookie: pbs.NewPBSCookie()}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(resp.Source.Ext), 0)
+
+	pbReq.SetSupplyChain(pbs.NewHostSupplyChain(pbs.SupplyChainNode{ASI: "pbshost.com", SID: "1001"}))
+	resp, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(resp.Source.Ext), `{"schain":{"complete":0,"ver":"1.0","nodes":[{"asi":"pbshost.com","sid":"1001","hp":1}]}}`)
+}
+
+func TestOpenRTBAppBuyerUIDs(t *testing.T) {
+	user := &openrtb.User{BuyerUID: "shared"}
+	pbReq := pbs.PBSRequest{
+		App:       &openrtb.App{ID: "com.app"},
+		User:      user,
+		BuyerUIDs: map[string]string{"bannerCode": "body-id"},
+	}
+	pbBidder := pbs.PBSBidder{
+		BidderCode: "bannerCode",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:       "unitCode",
+				MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+				Sizes:      []openrtb.Format{{W: 300, H: 250}},
+			},
+		},
+	}
+	resp, err := MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.User.BuyerUID, "body-id")
+	assert.Equal(t, user.BuyerUID, "shared")
+
+	pbBidder.BidderCode = "otherCode"
+	resp, err = MakeOpenRTBGeneric(&pbReq, &pbBidder, "test", []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.User.BuyerUID, "shared")
+}
+
 func TestSizesCopy(t *testing.T) {
 	formats := []openrtb.Format{
 		{
@@ -454,3 +865,36 @@ func TestMakeVideo(t *testing.T) {
 	assert.EqualValues(t, len(video.PlaybackMethod), 1)
 	assert.EqualValues(t, len(video.Protocols), 4)
 }
+
+func TestMakeBidMeta(t *testing.T) {
+	bid := openrtb.Bid{
+		ID:      "bid",
+		ADomain: []string{"advertiser.com"},
+	}
+	meta := MakeBidMeta(&bid, "banner")
+	assert.Equal(t, []string{"advertiser.com"}, meta.AdvertiserDomains)
+	assert.Equal(t, "banner", meta.MediaType)
+
+	bid.Ext = openrtb.RawJSON(`{"prebid":{"meta":{"networkId":5,"mediaType":"video"}}}`)
+	meta = MakeBidMeta(&bid, "banner")
+	assert.Equal(t, []string{"advertiser.com"}, meta.AdvertiserDomains)
+	assert.Equal(t, 5, meta.NetworkID)
+	assert.Equal(t, "video", meta.MediaType)
+
+	bid.Ext = openrtb.RawJSON(`{"prebid":{"meta":{"advertiserDomains":["other.com"]}}}`)
+	meta = MakeBidMeta(&bid, "")
+	assert.Equal(t, []string{"other.com"}, meta.AdvertiserDomains)
+}
+
+func TestMakeBidExt(t *testing.T) {
+	bid := openrtb.Bid{
+		ID:    "bid",
+		Price: 1.25,
+	}
+	ext := MakeBidExt(&bid, "")
+	assert.Equal(t, 1.25, ext.OrigBidCPM)
+	assert.Equal(t, "USD", ext.OrigBidCur)
+
+	ext = MakeBidExt(&bid, "EUR")
+	assert.Equal(t, "EUR", ext.OrigBidCur)
+}
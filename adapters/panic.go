@@ -0,0 +1,27 @@
+package adapters
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/golang/glog"
+)
+
+// PanicError is returned for a bidder call which panicked.
+type PanicError struct {
+	Value interface{}
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("Adapter panicked: %v", err.Value)
+}
+
+// RecoverPanic turns a panic into a *PanicError for the bidder, and passes it to onPanic. It must be deferred
+// by every goroutine which calls a bidder, since a panic can only be recovered in its own goroutine. Goroutines
+// whose results are waited for should send the error as their result from onPanic.
+func RecoverPanic(bidder string, onPanic func(err error)) {
+	if r := recover(); r != nil {
+		glog.Errorf("Panic from bidder %s: %v\n%s", bidder, r, debug.Stack())
+		onPanic(&PanicError{Value: r})
+	}
+}
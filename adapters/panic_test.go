@@ -0,0 +1,20 @@
+package adapters
+
+import "testing"
+
+func TestRecoverPanic(t *testing.T) {
+	results := make(chan error, 1)
+	go func() {
+		defer RecoverPanic("panicking", func(err error) {
+			results <- err
+		})
+		var bids []int
+		results <- nil
+		_ = bids[0]
+	}()
+	<-results
+	err := <-results
+	if _, ok := err.(*PanicError); !ok {
+		t.Errorf("Expected a PanicError. Got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// adapterFileNames maps bidder codes onto the names of their files in static/bidder-params and static/bidder-info,
+// for the bidders which share an adapter's files, or whose adapter is named differently.
+var adapterFileNames = map[string]string{
+	"audienceNetwork": "facebook",
+	"debug":           "debugbidder",
+	"districtm":       "appnexus",
+	"indexExchange":   "index",
+}
+
+// ParamsValidator checks bidder params against the bidders' JSON schemas, so that bad params get a clear
+// error before the adapter is called.
+type ParamsValidator struct {
+	schemas map[string]*gojsonschema.Schema
+	raw     map[string]json.RawMessage
+}
+
+// NewParamsValidator loads the {bidder}.json schemas in the directory. It fails if any of them can't be loaded.
+func NewParamsValidator(schemaDirectory string) (*ParamsValidator, error) {
+	files, err := ioutil.ReadDir(schemaDirectory)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*gojsonschema.Schema, len(files))
+	raw := make(map[string]json.RawMessage, len(files))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		bytes, err := ioutil.ReadFile(filepath.Join(schemaDirectory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(bytes))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the schema %s: %v", file.Name(), err)
+		}
+		name := strings.TrimSuffix(file.Name(), ".json")
+		schemas[name] = schema
+		raw[name] = json.RawMessage(bytes)
+	}
+	return &ParamsValidator{schemas: schemas, raw: raw}, nil
+}
+
+// AddAliases lets the aliases, keyed by alias code, use the schemas of the bidders they alias.
+// It must be called before the validator is shared.
+func (v *ParamsValidator) AddAliases(aliases map[string]string) {
+	for alias, core := range aliases {
+		name := adapterFileName(core)
+		if schema, ok := v.schemas[name]; ok {
+			v.schemas[alias] = schema
+			v.raw[alias] = v.raw[name]
+		}
+	}
+}
+
+// Schema returns the JSON schema which the bidder's params are checked against, or nil if the bidder has none.
+func (v *ParamsValidator) Schema(bidderCode string) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	return v.raw[adapterFileName(bidderCode)]
+}
+
+func adapterFileName(bidderCode string) string {
+	if name, ok := adapterFileNames[bidderCode]; ok {
+		return name
+	}
+	return bidderCode
+}
+
+// Validate returns an errortypes.BadInput if the params don't match the bidder's schema. Bidders
+// without a schema accept anything, and so does a nil ParamsValidator.
+func (v *ParamsValidator) Validate(bidderCode string, params json.RawMessage) error {
+	if v == nil {
+		return nil
+	}
+	schema, ok := v.schemas[adapterFileName(bidderCode)]
+	if !ok {
+		return nil
+	}
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(params))
+	if err != nil {
+		return &errortypes.BadInput{Message: fmt.Sprintf("%s params are not valid JSON: %v", bidderCode, err)}
+	}
+	if result.Valid() {
+		return nil
+	}
+	problems := make([]string, len(result.Errors()))
+	for i, resultErr := range result.Errors() {
+		problems[i] = resultErr.String()
+	}
+	return &errortypes.BadInput{Message: fmt.Sprintf("%s params are invalid: %s", bidderCode, strings.Join(problems, "; "))}
+}
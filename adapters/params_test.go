@@ -0,0 +1,63 @@
+package adapters
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prebid/prebid-server/errortypes"
+)
+
+func TestParamsSchemasLoad(t *testing.T) {
+	validator, err := NewParamsValidator("../static/bidder-params")
+	if err != nil {
+		t.Fatalf("Failed to load the bidder params schemas: %v", err)
+	}
+	for code, name := range adapterFileNames {
+		if _, ok := validator.schemas[name]; !ok {
+			t.Errorf("Bidder %s uses the schema %s, which doesn't exist", code, name)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	validator, err := NewParamsValidator("../static/bidder-params")
+	if err != nil {
+		t.Fatalf("Failed to load the bidder params schemas: %v", err)
+	}
+	valid := map[string]string{
+		"appnexus":      `{"placementId":10433394}`,
+		"districtm":     `{"invCode":"abc","member":"123"}`,
+		"indexExchange": `{"siteID":12345}`,
+		"pulsepoint":    `{"cp":512379,"ct":486653,"cf":"300x250"}`,
+		"unknown":       `{"anything":true}`,
+	}
+	for bidder, params := range valid {
+		if err := validator.Validate(bidder, json.RawMessage(params)); err != nil {
+			t.Errorf("Unexpected error for %s params %s: %v", bidder, params, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"appnexus":        `{}`,
+		"indexExchange":   `{"siteID":"12345"}`,
+		"pulsepoint":      `{"cp":512379,"ct":486653,"cf":"big"}`,
+		"audienceNetwork": `{"placementId":123}`,
+		"rubicon":         `[]`,
+	}
+	for bidder, params := range invalid {
+		err := validator.Validate(bidder, json.RawMessage(params))
+		if err == nil {
+			t.Errorf("Expected an error for %s params %s", bidder, params)
+			continue
+		}
+		if errortypes.ReadCode(err) != errortypes.BadInputErrorCode || !strings.HasPrefix(err.Error(), bidder+" params are invalid") {
+			t.Errorf("Expected a BadInput naming %s. Got %v", bidder, err)
+		}
+	}
+
+	var nilValidator *ParamsValidator
+	if err := nilValidator.Validate("appnexus", json.RawMessage(`{}`)); err != nil {
+		t.Errorf("A nil validator should accept anything. Got %v", err)
+	}
+}
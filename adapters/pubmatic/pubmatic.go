@@ -8,23 +8,22 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/pbs"
-	"golang.org/x/net/context/ctxhttp"
 )
 
 const MAX_IMPRESSIONS_PUBMATIC = 30
 
 type PubmaticAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
 }
 
 /* Name - export adapter name */
@@ -37,8 +36,8 @@ func (a *PubmaticAdapter) FamilyName() string {
 	return "pubmatic"
 }
 
-func (a *PubmaticAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *PubmaticAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *PubmaticAdapter) SkipNoCookies() bool {
@@ -158,16 +157,20 @@ func (a *PubmaticAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 		bidder.Debug = append(bidder.Debug, debug)
 	}
 
-	userId, _, _ := req.Cookie.GetUID(a.FamilyName())
+	// The user's ID goes in a cookie, outside of the OpenRTB request, so it's only sent if the bidder may have it.
+	userId := req.BidderBuyerUID(bidder, a.FamilyName())
 	httpReq, err := http.NewRequest("POST", a.URI, bytes.NewBuffer(reqJSON))
 	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
 	httpReq.Header.Add("Accept", "application/json")
-	httpReq.AddCookie(&http.Cookie{
-		Name:  "KADUSERCOOKIE",
-		Value: userId,
-	})
+	if userId != "" {
+		httpReq.AddCookie(&http.Cookie{
+			Name:  "KADUSERCOOKIE",
+			Value: userId,
+		})
+	}
 
-	pbResp, err := ctxhttp.Do(ctx, a.http.Client, httpReq)
+	pbResp, timing, err := a.http.DoRequest(ctx, httpReq)
+	debug.Timing = timing.Debug()
 	if err != nil {
 		return nil, err
 	}
@@ -195,7 +198,10 @@ func (a *PubmaticAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 	var bidResp openrtb.BidResponse
 	err = json.Unmarshal(body, &bidResp)
 	if err != nil {
-		return nil, err
+		return nil, &errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: body,
+		}
 	}
 
 	bids := make(pbs.PBSBidSlice, 0)
@@ -211,15 +217,20 @@ func (a *PubmaticAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 			}
 
 			pbid := pbs.PBSBid{
-				BidID:       bidID,
-				AdUnitCode:  bid.ImpID,
-				BidderCode:  bidder.BidderCode,
-				Price:       bid.Price,
-				Adm:         bid.AdM,
-				Creative_id: bid.CrID,
-				Width:       bid.W,
-				Height:      bid.H,
-				DealId:      bid.DealID,
+				BidID:         bidID,
+				AdUnitCode:    bid.ImpID,
+				BidderCode:    bidder.BidderCode,
+				Price:         bid.Price,
+				Adm:           bid.AdM,
+				BURL:          bid.BURL,
+				BidResponseID: bidResp.BidID,
+				Creative_id:   bid.CrID,
+				Width:         bid.W,
+				Height:        bid.H,
+				DealId:        bid.DealID,
+				Exp:           bid.Exp,
+				Meta:          adapters.MakeBidMeta(&bid, ""),
+				Ext:           adapters.MakeBidExt(&bid, bidResp.Cur),
 			}
 
 			bids = append(bids, &pbid)
@@ -233,20 +244,18 @@ func (a *PubmaticAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder
 	return bids, nil
 }
 
-func NewPubmaticAdapter(config *adapters.HTTPAdapterConfig, uri string, externalURL string) *PubmaticAdapter {
+func NewPubmaticAdapter(config *adapters.HTTPAdapterConfig, uri string, usersyncURL string, externalURL string) *PubmaticAdapter {
 	a := adapters.NewHTTPAdapter(config)
-	redirect_uri := fmt.Sprintf("%s/setuid?bidder=pubmatic&uid=", externalURL)
-	usersyncURL := "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect="
-
-	info := &pbs.UsersyncInfo{
-		URL:         fmt.Sprintf("%s%s", usersyncURL, url.QueryEscape(redirect_uri)),
+	info := &pbs.UsersyncTemplate{
+		URL:         usersyncURL,
+		RedirectURL: externalURL + "/setuid?bidder=pubmatic&uid=",
 		Type:        "iframe",
 		SupportCORS: false,
 	}
 
 	return &PubmaticAdapter{
-		http:         a,
-		URI:          uri,
-		usersyncInfo: info,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
 	}
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
 	"github.com/prebid/prebid-server/cache/dummycache"
+	"github.com/prebid/prebid-server/gdpr"
 	"github.com/prebid/prebid-server/pbs"
 )
 
@@ -79,7 +80,7 @@ func DummyPubMaticServer(w http.ResponseWriter, r *http.Request) {
 
 func TestPubmaticInvalidCall(t *testing.T) {
 
-	an := NewPubmaticAdapter(adapters.DefaultHTTPAdapterConfig, "blah", "localhost")
+	an := NewPubmaticAdapter(adapters.DefaultHTTPAdapterConfig, "blah", "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	s := an.Name()
 	if s == "" {
@@ -105,7 +106,7 @@ func TestPubmaticTimeout(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 	ctx, cancel := context.WithTimeout(context.Background(), 0)
 	defer cancel()
 
@@ -142,7 +143,7 @@ func TestPubmaticInvalidJson(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
 	pbBidder := pbs.PBSBidder{
@@ -178,7 +179,7 @@ func TestPubmaticInvalidStatusCode(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
 	pbBidder := pbs.PBSBidder{
@@ -206,7 +207,7 @@ func TestPubmaticInvalidStatusCode(t *testing.T) {
 func TestPubmaticInvalidInputParameters(t *testing.T) {
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, "http://localhost/test", "localhost")
+	an := NewPubmaticAdapter(&conf, "http://localhost/test", "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
 	pbBidder := pbs.PBSBidder{
@@ -308,7 +309,7 @@ func TestPubmaticBasicResponse(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
 	pbBidder := pbs.PBSBidder{
@@ -344,7 +345,7 @@ func TestPubmaticMultiImpressionResponse(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
@@ -392,7 +393,7 @@ func TestPubmaticMultiAdUnitResponse(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
@@ -441,7 +442,7 @@ func TestPubmaticMobileResponse(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
@@ -479,14 +480,15 @@ func TestPubmaticMobileResponse(t *testing.T) {
 
 func TestPubmaticUserSyncInfo(t *testing.T) {
 
-	an := NewPubmaticAdapter(adapters.DefaultHTTPAdapterConfig, "pubmaticUrl", "localhost")
-	if an.usersyncInfo.URL != "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect=localhost%2Fsetuid%3Fbidder%3Dpubmatic%26uid%3D" {
+	an := NewPubmaticAdapter(adapters.DefaultHTTPAdapterConfig, "pubmaticUrl", "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
+	usersyncInfo := an.GetUsersyncInfo(pbs.UsersyncPrivacy{})
+	if usersyncInfo.URL != "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect=localhost%2Fsetuid%3Fbidder%3Dpubmatic%26uid%3D" {
 		t.Fatalf("should have matched")
 	}
-	if an.usersyncInfo.Type != "iframe" {
+	if usersyncInfo.Type != "iframe" {
 		t.Fatalf("should be iframe")
 	}
-	if an.usersyncInfo.SupportCORS != false {
+	if usersyncInfo.SupportCORS != false {
 		t.Fatalf("should have been false")
 	}
 }
@@ -497,7 +499,7 @@ func TestPubmaticInvalidLookupBidIDParameter(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
@@ -528,7 +530,7 @@ func TestPubmaticAdSlotParams(t *testing.T) {
 	defer server.Close()
 
 	conf := *adapters.DefaultHTTPAdapterConfig
-	an := NewPubmaticAdapter(&conf, server.URL, "localhost")
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
 
 	ctx := context.Background()
 	pbReq := pbs.PBSRequest{}
@@ -644,7 +646,7 @@ func TestPubmaticSampleRequest(t *testing.T) {
 
 	httpReq := httptest.NewRequest("POST", server.URL, body)
 	httpReq.Header.Add("Referer", "http://test.com/sports")
-	pc := pbs.ParsePBSCookieFromRequest(httpReq)
+	pc := pbs.ParsePBSCookieFromRequest(httpReq, nil)
 	pc.TrySync("pubmatic", "12345")
 	fakewriter := httptest.NewRecorder()
 	pc.SetCookieOnResponse(fakewriter, "")
@@ -658,3 +660,58 @@ func TestPubmaticSampleRequest(t *testing.T) {
 		t.Fatalf("Error when parsing request: %v", err)
 	}
 }
+
+// callWithBuyerUID runs an auction for a bidder whose user ID is in the request, and returns the
+// KADUSERCOOKIE which pubmatic got, if any.
+func callWithBuyerUID(t *testing.T, regs *openrtb.Regs, pbBidder *pbs.PBSBidder) *http.Cookie {
+	var cookie *http.Cookie
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ = r.Cookie("KADUSERCOOKIE")
+		DummyPubMaticServer(w, r)
+	}))
+	defer server.Close()
+
+	conf := *adapters.DefaultHTTPAdapterConfig
+	an := NewPubmaticAdapter(&conf, server.URL, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}", "localhost")
+	pbReq := pbs.PBSRequest{
+		BuyerUIDs: map[string]string{"pubmatic": "12345"},
+		Regs:      regs,
+	}
+	pbBidder.BidderCode = "pubmatic"
+	pbBidder.AdUnits = []pbs.PBSAdUnit{
+		{
+			Code:       "unitCode",
+			BidID:      "bidid",
+			MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER},
+			Sizes:      []openrtb.Format{{W: 336, H: 280}},
+			Params:     json.RawMessage("{\"publisherId\": \"640\", \"adSlot\": \"slot1@336x280\"}"),
+		},
+	}
+	if _, err := an.Call(context.Background(), &pbReq, pbBidder); err != nil {
+		t.Fatalf("Should not have gotten an error: %v", err)
+	}
+	return cookie
+}
+
+func TestPubmaticUserCookie(t *testing.T) {
+	cookie := callWithBuyerUID(t, nil, &pbs.PBSBidder{})
+	if cookie == nil || cookie.Value != "12345" {
+		t.Errorf("pubmatic should get the user's ID in KADUSERCOOKIE. Got %v", cookie)
+	}
+}
+
+func TestPubmaticUserCookieRestricted(t *testing.T) {
+	cookie := callWithBuyerUID(t, nil, &pbs.PBSBidder{GDPR: gdpr.Restrictions{UserIDs: true}})
+	if cookie != nil {
+		t.Errorf("pubmatic shouldn't get the user's ID if its user IDs are restricted. Got %v", cookie)
+	}
+}
+
+func TestPubmaticUserCookieCOPPA(t *testing.T) {
+	regs := &openrtb.Regs{COPPA: 1}
+	var enforcer *gdpr.Enforcer
+	cookie := callWithBuyerUID(t, regs, &pbs.PBSBidder{GDPR: enforcer.Restrictions(regs, nil, "pubmatic")})
+	if cookie != nil {
+		t.Errorf("pubmatic shouldn't get the user's ID on COPPA requests. Got %v", cookie)
+	}
+}
@@ -1,27 +1,23 @@
 package pulsepoint
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/pbs"
-	"golang.org/x/net/context/ctxhttp"
 )
 
 type PulsePointAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
 }
 
 // adapter name
@@ -34,8 +30,8 @@ func (a *PulsePointAdapter) FamilyName() string {
 	return "pulsepoint"
 }
 
-func (a *PulsePointAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *PulsePointAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *PulsePointAdapter) SkipNoCookies() bool {
@@ -56,23 +52,33 @@ func (a *PulsePointAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidde
 	if err != nil {
 		return nil, err
 	}
+	return adapters.CallBidder(ctx, a.http, a, req, bidder, &ppReq)
+}
 
-	for i, unit := range bidder.AdUnits {
-		var params PulsepointParams
-		err := json.Unmarshal(unit.Params, &params)
-		if err != nil {
-			return nil, err
+// MakeRequests sends every imp in one request. PulsePoint's params apply to the whole request,
+// so a single bad ad unit fails all of them.
+func (a *PulsePointAdapter) MakeRequests(ppReq *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	for i := range ppReq.Imp {
+		var ext struct {
+			Bidder PulsepointParams `json:"bidder"`
+		}
+		if err := json.Unmarshal(ppReq.Imp[i].Ext, &ext); err != nil {
+			return nil, []error{err}
 		}
+		params := ext.Bidder
 		if params.PublisherId == 0 {
-			return nil, fmt.Errorf("Missing PublisherId param cp")
+			return nil, []error{fmt.Errorf("Missing PublisherId param cp")}
 		}
 		if params.TagId == 0 {
-			return nil, fmt.Errorf("Missing TagId param ct")
+			return nil, []error{fmt.Errorf("Missing TagId param ct")}
 		}
 		if params.AdSize == "" {
-			return nil, fmt.Errorf("Missing AdSize param cf")
+			return nil, []error{fmt.Errorf("Missing AdSize param cf")}
 		}
 		ppReq.Imp[i].TagID = strconv.Itoa(params.TagId)
+		if err := adapters.SetImpExt(&ppReq.Imp[i], nil); err != nil {
+			return nil, []error{err}
+		}
 		publisher := &openrtb.Publisher{ID: strconv.Itoa(params.PublisherId)}
 		if ppReq.Site != nil {
 			siteCopy := *ppReq.Site
@@ -90,104 +96,83 @@ func (a *PulsePointAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidde
 				if err == nil {
 					ppReq.Imp[i].Banner.W = openrtb.Uint64Ptr(uint64(width))
 				} else {
-					return nil, fmt.Errorf("Invalid Width param %s", size[0])
+					return nil, []error{fmt.Errorf("Invalid Width param %s", size[0])}
 				}
 				height, err := strconv.Atoi(size[1])
 				if err == nil {
 					ppReq.Imp[i].Banner.H = openrtb.Uint64Ptr(uint64(height))
 				} else {
-					return nil, fmt.Errorf("Invalid Height param %s", size[1])
+					return nil, []error{fmt.Errorf("Invalid Height param %s", size[1])}
 				}
 			} else {
-				return nil, fmt.Errorf("Invalid AdSize param %s", params.AdSize)
+				return nil, []error{fmt.Errorf("Invalid AdSize param %s", params.AdSize)}
 			}
 		}
 	}
 	reqJSON, err := json.Marshal(ppReq)
-	debug := &pbs.BidderDebug{
-		RequestURI: a.URI,
-	}
-
-	if req.IsDebug {
-		debug.RequestBody = string(reqJSON)
-		bidder.Debug = append(bidder.Debug, debug)
-	}
-
-	httpReq, err := http.NewRequest("POST", a.URI, bytes.NewBuffer(reqJSON))
-	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
-	httpReq.Header.Add("Accept", "application/json")
-
-	ppResp, err := ctxhttp.Do(ctx, a.http.Client, httpReq)
 	if err != nil {
-		return nil, err
-	}
-
-	debug.StatusCode = ppResp.StatusCode
-
-	if ppResp.StatusCode == 204 {
-		return nil, nil
+		return nil, []error{err}
 	}
 
-	if ppResp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP status: %d", ppResp.StatusCode)
-	}
+	return []*adapters.RequestData{{
+		Method:  "POST",
+		URI:     a.URI,
+		Body:    reqJSON,
+		Headers: http.Header{"Accept": []string{"application/json"}},
+	}}, nil
+}
 
-	defer ppResp.Body.Close()
-	body, err := ioutil.ReadAll(ppResp.Body)
-	if err != nil {
-		return nil, err
+func (a *PulsePointAdapter) MakeBids(ppReq *openrtb.BidRequest, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	var bidResp openrtb.BidResponse
+	if err := json.Unmarshal(response.Body, &bidResp); err != nil {
+		return nil, []error{&errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: response.Body,
+		}}
 	}
 
-	if req.IsDebug {
-		debug.ResponseBody = string(body)
+	// Video and banner imps share ad unit codes, so a bid is only known to be for video if its ad unit had no banner.
+	videoOnly := make(map[string]bool, len(ppReq.Imp))
+	for _, imp := range ppReq.Imp {
+		if imp.Banner != nil {
+			videoOnly[imp.ID] = false
+		} else if _, ok := videoOnly[imp.ID]; !ok {
+			videoOnly[imp.ID] = imp.Video != nil
+		}
 	}
 
-	var bidResp openrtb.BidResponse
-	err = json.Unmarshal(body, &bidResp)
-	if err != nil {
-		return nil, err
+	bids := &adapters.BidderResponse{
+		ID:       bidResp.BidID,
+		Currency: bidResp.Cur,
 	}
-
-	bids := make(pbs.PBSBidSlice, 0)
-
 	for _, sb := range bidResp.SeatBid {
-		for _, bid := range sb.Bid {
-			bidID := bidder.LookupBidID(bid.ImpID)
-			if bidID == "" {
-				return nil, errors.New(fmt.Sprintf("Unknown ad unit code '%s'", bid.ImpID))
+		for i := range sb.Bid {
+			bidType := pbs.MEDIA_TYPE_BANNER
+			if videoOnly[sb.Bid[i].ImpID] {
+				bidType = pbs.MEDIA_TYPE_VIDEO
 			}
-
-			pbid := pbs.PBSBid{
-				BidID:       bidID,
-				AdUnitCode:  bid.ImpID,
-				BidderCode:  bidder.BidderCode,
-				Price:       bid.Price,
-				Adm:         bid.AdM,
-				Creative_id: bid.CrID,
-				Width:       bid.W,
-				Height:      bid.H,
-			}
-			bids = append(bids, &pbid)
+			bids.Bids = append(bids.Bids, &adapters.TypedBid{
+				Bid:     &sb.Bid[i],
+				BidType: bidType,
+			})
 		}
 	}
-
 	return bids, nil
 }
 
-func NewPulsePointAdapter(config *adapters.HTTPAdapterConfig, uri string, externalURL string) *PulsePointAdapter {
+func NewPulsePointAdapter(config *adapters.HTTPAdapterConfig, uri string, usersyncURL string, externalURL string) *PulsePointAdapter {
 	a := adapters.NewHTTPAdapter(config)
-	redirect_uri := fmt.Sprintf("%s/setuid?bidder=pulsepoint&uid=%s", externalURL, "%%VGUID%%")
-	usersyncURL := "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl="
 
-	info := &pbs.UsersyncInfo{
-		URL:         fmt.Sprintf("%s%s", usersyncURL, url.QueryEscape(redirect_uri)),
+	info := &pbs.UsersyncTemplate{
+		URL:         usersyncURL,
+		RedirectURL: externalURL + "/setuid?bidder=pulsepoint&uid=%%VGUID%%",
 		Type:        "redirect",
 		SupportCORS: false,
 	}
 
 	return &PulsePointAdapter{
-		http:         a,
-		URI:          uri,
-		usersyncInfo: info,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
 	}
 }
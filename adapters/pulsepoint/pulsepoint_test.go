@@ -29,7 +29,7 @@ type PulsePointOrtbMockService struct {
  * Verify adapter names are setup correctly.
  */
 func TestPulsePointAdapterNames(t *testing.T) {
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	VerifyStringValue(adapter.Name(), "pulsepoint", t)
 	VerifyStringValue(adapter.FamilyName(), "pulsepoint", t)
 }
@@ -38,16 +38,16 @@ func TestPulsePointAdapterNames(t *testing.T) {
  * Verifies the user sync parameters.
  */
 func TestPulsePointUserSyncInfo(t *testing.T) {
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "http://localhost")
-	VerifyStringValue(adapter.GetUsersyncInfo().Type, "redirect", t)
-	VerifyStringValue(adapter.GetUsersyncInfo().URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl=http%3A%2F%2Flocalhost%2Fsetuid%3Fbidder%3Dpulsepoint%26uid%3D%25%25VGUID%25%25", t)
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
+	VerifyStringValue(adapter.GetUsersyncInfo(pbs.UsersyncPrivacy{}).Type, "redirect", t)
+	VerifyStringValue(adapter.GetUsersyncInfo(pbs.UsersyncPrivacy{}).URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl=http%3A%2F%2Flocalhost%2Fsetuid%3Fbidder%3Dpulsepoint%26uid%3D%25%25VGUID%25%25", t)
 }
 
 /**
  * Test required parameters not sent
  */
 func TestPulsePointRequiredBidParameters(t *testing.T) {
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, "http://localhost/bid", "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	ctx := context.TODO()
 	req := SampleRequest(1, t)
 	bidder := req.Bidders[0]
@@ -87,7 +87,7 @@ func TestPulsePointOpenRTBRequest(t *testing.T) {
 	ctx := context.TODO()
 	req := SampleRequest(1, t)
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	adapter.Call(ctx, req, bidder)
 	fmt.Println(service.LastBidRequest)
 	VerifyIntValue(len(service.LastBidRequest.Imp), 1, t)
@@ -106,7 +106,7 @@ func TestPulsePointBiddingBehavior(t *testing.T) {
 	ctx := context.TODO()
 	req := SampleRequest(1, t)
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	bids, _ := adapter.Call(ctx, req, bidder)
 	// number of bids should be 1
 	VerifyIntValue(len(bids), 1, t)
@@ -129,7 +129,7 @@ func TestPulsePointMultiImpPartialBidding(t *testing.T) {
 	ctx := context.TODO()
 	req := SampleRequest(2, t)
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	bids, _ := adapter.Call(ctx, req, bidder)
 	// two impressions sent.
 	// number of bids should be 1
@@ -148,7 +148,7 @@ func TestPulsePointMultiImpPassback(t *testing.T) {
 	ctx := context.TODO()
 	req := SampleRequest(2, t)
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	bids, _ := adapter.Call(ctx, req, bidder)
 	// two impressions sent.
 	// number of bids should be 1
@@ -166,7 +166,7 @@ func TestPulsePointMultiImpAllBid(t *testing.T) {
 	ctx := context.TODO()
 	req := SampleRequest(2, t)
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	bids, _ := adapter.Call(ctx, req, bidder)
 	// two impressions sent.
 	// number of bids should be 1
@@ -190,7 +190,7 @@ func TestMobileAppRequest(t *testing.T) {
 		Name: "facebook",
 	}
 	bidder := req.Bidders[0]
-	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "http://localhost")
+	adapter := NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, server.URL, "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}", "http://localhost")
 	bids, _ := adapter.Call(ctx, req, bidder)
 	// one mobile app impression sent.
 	// verify appropriate fields are sent to pulsepoint endpoint.
@@ -265,7 +265,7 @@ func SampleRequest(numberOfImpressions int, t *testing.T) *pbs.PBSRequest {
 	// setup a http request
 	httpReq := httptest.NewRequest("POST", CreateService(BidOnTags("")).Server.URL, body)
 	httpReq.Header.Add("Referer", "http://news.pub/topnews")
-	pc := pbs.ParsePBSCookieFromRequest(httpReq)
+	pc := pbs.ParsePBSCookieFromRequest(httpReq, nil)
 	pc.TrySync("pulsepoint", "pulsepointUser123")
 	fakewriter := httptest.NewRecorder()
 	pc.SetCookieOnResponse(fakewriter, "")
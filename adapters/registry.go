@@ -0,0 +1,142 @@
+package adapters
+
+import (
+	"sort"
+	"strings"
+)
+
+// RegisteredBidder is everything which the auctions need to know about one bidder code, resolved at startup.
+type RegisteredBidder struct {
+	Code string `json:"code"`
+	// Core is the bidder whose adapter and bidder info the code uses. It's the same as Code, unless Code is a
+	// host alias.
+	Core string `json:"core"`
+	// MediaTypes lists the media types which the bidder supports on each platform, "app" and "site".
+	// A platform which the bidder doesn't bid on is left out.
+	MediaTypes  map[string][]string `json:"media_types"`
+	SKAdN       bool                `json:"skadn"`
+	GVLVendorID uint16              `json:"gvl_vendor_id,omitempty"`
+	GPP         bool                `json:"gpp"`
+	ASI         string              `json:"asi,omitempty"`
+
+	// supported holds "platform/mediaType" for each media type which the bidder supports.
+	supported map[string]bool
+}
+
+// BidderRegistry resolves the host's bidder codes, including its aliases, into RegisteredBidders.
+//
+// It's built once, when the config is loaded, and never changes afterwards, so auctions can share it without
+// locking. Lookups are case-insensitive. A nil *BidderRegistry knows no bidders.
+type BidderRegistry struct {
+	bidders map[string]*RegisteredBidder
+}
+
+// NewBidderRegistry makes a BidderRegistry from the bidders' infos, and the host's aliases, which map alias
+// codes onto the bidders they alias. Aliases without infos of their own share their core bidder's.
+func NewBidderRegistry(infos BidderInfos, aliases map[string]string) *BidderRegistry {
+	registry := &BidderRegistry{bidders: make(map[string]*RegisteredBidder, len(infos)+len(aliases))}
+	for code, info := range infos {
+		core := code
+		if aliasOf, ok := aliases[code]; ok {
+			core = aliasOf
+		}
+		registry.bidders[strings.ToLower(code)] = newRegisteredBidder(code, core, info)
+	}
+	for alias, core := range aliases {
+		if _, ok := registry.bidders[strings.ToLower(alias)]; !ok {
+			registry.bidders[strings.ToLower(alias)] = newRegisteredBidder(alias, core, infos[core])
+		}
+	}
+	return registry
+}
+
+func newRegisteredBidder(code string, core string, info BidderInfo) *RegisteredBidder {
+	bidder := &RegisteredBidder{
+		Code:        code,
+		Core:        core,
+		MediaTypes:  make(map[string][]string),
+		SKAdN:       info.SupportsSKAdN(),
+		GVLVendorID: info.GVLVendorID,
+		GPP:         info.GPP,
+		ASI:         info.ASI,
+		supported:   make(map[string]bool),
+	}
+	if info.Capabilities == nil {
+		return bidder
+	}
+	for platform, platformInfo := range map[string]*PlatformInfo{"app": info.Capabilities.App, "site": info.Capabilities.Site} {
+		if platformInfo == nil {
+			continue
+		}
+		bidder.MediaTypes[platform] = platformInfo.MediaTypes
+		for _, mediaType := range platformInfo.MediaTypes {
+			bidder.supported[platform+"/"+mediaType] = true
+		}
+	}
+	return bidder
+}
+
+// Lookup returns the bidder with the code, or false if the host doesn't serve it.
+func (r *BidderRegistry) Lookup(code string) (RegisteredBidder, bool) {
+	if r == nil {
+		return RegisteredBidder{}, false
+	}
+	bidder, ok := r.bidders[strings.ToLower(code)]
+	if !ok {
+		return RegisteredBidder{}, false
+	}
+	return *bidder, true
+}
+
+// CoreBidder returns the bidder which the host alias uses, or the code itself if it isn't a host alias.
+func (r *BidderRegistry) CoreBidder(code string) string {
+	if bidder, ok := r.Lookup(code); ok {
+		return bidder.Core
+	}
+	return code
+}
+
+// Supports returns true if the bidder bids on the media type on the platform, which is "app" or "site".
+// Bidders which the registry doesn't know are assumed to support everything, since only their bidder info
+// can rule a media type out.
+func (r *BidderRegistry) Supports(code string, platform string, mediaType string) bool {
+	if r == nil {
+		return true
+	}
+	bidder, ok := r.bidders[strings.ToLower(code)]
+	if !ok {
+		return true
+	}
+	return bidder.supported[platform+"/"+mediaType]
+}
+
+// SupportsSKAdN returns true if the bidder should get imp.ext.skadn on iOS app requests.
+func (r *BidderRegistry) SupportsSKAdN(code string) bool {
+	bidder, ok := r.Lookup(code)
+	return ok && bidder.SKAdN
+}
+
+// SupportsGPP returns true if the bidder should get the raw GPP string, rather than the signals in its sections.
+func (r *BidderRegistry) SupportsGPP(code string) bool {
+	bidder, ok := r.Lookup(code)
+	return ok && bidder.GPP
+}
+
+// ASI returns the domain which identifies the bidder in demand chains, or "" if it has none.
+func (r *BidderRegistry) ASI(code string) string {
+	bidder, _ := r.Lookup(code)
+	return bidder.ASI
+}
+
+// Bidders returns every registered bidder, sorted by code.
+func (r *BidderRegistry) Bidders() []RegisteredBidder {
+	if r == nil {
+		return []RegisteredBidder{}
+	}
+	bidders := make([]RegisteredBidder, 0, len(r.bidders))
+	for _, bidder := range r.bidders {
+		bidders = append(bidders, *bidder)
+	}
+	sort.Slice(bidders, func(i, j int) bool { return bidders[i].Code < bidders[j].Code })
+	return bidders
+}
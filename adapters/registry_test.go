@@ -0,0 +1,62 @@
+package adapters
+
+import (
+	"testing"
+)
+
+func TestBidderRegistry(t *testing.T) {
+	registry := NewBidderRegistry(BidderInfos{
+		"appnexus": {
+			Capabilities: &CapabilitiesInfo{
+				App:  &PlatformInfo{MediaTypes: []string{"banner", "native"}, SKAdN: true},
+				Site: &PlatformInfo{MediaTypes: []string{"banner", "video"}},
+			},
+			GVLVendorID: 32,
+			GPP:         true,
+			ASI:         "appnexus.com",
+		},
+		"indexExchange": {Capabilities: &CapabilitiesInfo{Site: &PlatformInfo{MediaTypes: []string{"banner"}}}},
+	}, map[string]string{"appnexus2": "appnexus"})
+
+	if !registry.Supports("appnexus", "site", "video") || registry.Supports("appnexus", "app", "video") {
+		t.Error("appnexus should support video on sites only")
+	}
+	if !registry.Supports("IndexExchange", "site", "banner") || registry.Supports("indexexchange", "app", "banner") {
+		t.Error("Lookups should be case-insensitive, and platforms without info unsupported")
+	}
+	if !registry.Supports("unknown", "app", "audio") {
+		t.Error("Bidders without info should be assumed to support everything")
+	}
+	if !registry.SupportsSKAdN("appnexus") || registry.SupportsSKAdN("indexExchange") {
+		t.Error("Only appnexus supports SKAdNetwork")
+	}
+	if !registry.SupportsGPP("appnexus2") || registry.SupportsGPP("indexExchange") {
+		t.Error("Only appnexus and its alias support GPP")
+	}
+	if registry.ASI("appnexus2") != "appnexus.com" || registry.ASI("indexExchange") != "" || registry.ASI("unknown") != "" {
+		t.Error("Only appnexus and its alias have an asi")
+	}
+
+	alias, ok := registry.Lookup("appnexus2")
+	if !ok || alias.Core != "appnexus" || alias.GVLVendorID != 32 || !registry.Supports("appnexus2", "app", "native") {
+		t.Errorf("Aliases should share their core bidder's info. Got %#v", alias)
+	}
+	if registry.CoreBidder("appnexus2") != "appnexus" || registry.CoreBidder("appnexus") != "appnexus" || registry.CoreBidder("unknown") != "unknown" {
+		t.Error("CoreBidder should resolve host aliases only")
+	}
+
+	bidders := registry.Bidders()
+	if len(bidders) != 3 || bidders[0].Code != "appnexus" || bidders[1].Code != "appnexus2" || bidders[2].Code != "indexExchange" {
+		t.Errorf("Expected every bidder, sorted by code. Got %#v", bidders)
+	}
+}
+
+func TestNilBidderRegistry(t *testing.T) {
+	var registry *BidderRegistry
+	if _, ok := registry.Lookup("appnexus"); ok || registry.SupportsSKAdN("appnexus") || registry.SupportsGPP("appnexus") || !registry.Supports("appnexus", "site", "banner") {
+		t.Error("A nil registry should know no bidders, and not rule out any media types")
+	}
+	if bidders := registry.Bidders(); len(bidders) != 0 {
+		t.Errorf("A nil registry should have no bidders. Got %v", bidders)
+	}
+}
@@ -12,18 +12,17 @@ import (
 
 	"github.com/prebid/prebid-server/pbs"
 
-	"golang.org/x/net/context/ctxhttp"
-
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/errortypes"
 )
 
 type RubiconAdapter struct {
-	http         *adapters.HTTPAdapter
-	URI          string
-	usersyncInfo *pbs.UsersyncInfo
-	XAPIUsername string
-	XAPIPassword string
+	http             *adapters.HTTPAdapter
+	URI              string
+	usersyncTemplate *pbs.UsersyncTemplate
+	XAPIUsername     string
+	XAPIPassword     string
 }
 
 /* Name - export adapter name */
@@ -36,8 +35,8 @@ func (a *RubiconAdapter) FamilyName() string {
 	return "rubicon"
 }
 
-func (a *RubiconAdapter) GetUsersyncInfo() *pbs.UsersyncInfo {
-	return a.usersyncInfo
+func (a *RubiconAdapter) GetUsersyncInfo(privacy pbs.UsersyncPrivacy) *pbs.UsersyncInfo {
+	return a.usersyncTemplate.Resolve(privacy)
 }
 
 func (a *RubiconAdapter) SkipNoCookies() bool {
@@ -210,7 +209,8 @@ func (a *RubiconAdapter) callOne(ctx context.Context, req *pbs.PBSRequest, reqJS
 	httpReq.Header.Add("User-Agent", "prebid-server/1.0")
 	httpReq.SetBasicAuth(a.XAPIUsername, a.XAPIPassword)
 
-	rubiResp, e := ctxhttp.Do(ctx, a.http.Client, httpReq)
+	rubiResp, timing, e := a.http.DoRequest(ctx, httpReq)
+	result.Timing = timing
 	if e != nil {
 		err = e
 		return
@@ -234,6 +234,10 @@ func (a *RubiconAdapter) callOne(ctx context.Context, req *pbs.PBSRequest, reqJS
 	var bidResp openrtb.BidResponse
 	err = json.Unmarshal(body, &bidResp)
 	if err != nil {
+		err = &errortypes.BadServerResponse{
+			Message: err.Error(),
+			Payload: body,
+		}
 		return
 	}
 	if len(bidResp.SeatBid) == 0 {
@@ -245,13 +249,18 @@ func (a *RubiconAdapter) callOne(ctx context.Context, req *pbs.PBSRequest, reqJS
 	bid := bidResp.SeatBid[0].Bid[0]
 
 	result.Bid = &pbs.PBSBid{
-		AdUnitCode:  bid.ImpID,
-		Price:       bid.Price,
-		Adm:         bid.AdM,
-		Creative_id: bid.CrID,
-		Width:       bid.W,
-		Height:      bid.H,
-		DealId:      bid.DealID,
+		AdUnitCode:    bid.ImpID,
+		Price:         bid.Price,
+		Adm:           bid.AdM,
+		BURL:          bid.BURL,
+		BidResponseID: bidResp.BidID,
+		Creative_id:   bid.CrID,
+		Width:         bid.W,
+		Height:        bid.H,
+		DealId:        bid.DealID,
+		Exp:           bid.Exp,
+		Meta:          adapters.MakeBidMeta(&bid, "banner"),
+		Ext:           adapters.MakeBidExt(&bid, bidResp.Cur),
 	}
 
 	// Pull out any server-side determined targeting
@@ -310,7 +319,7 @@ func (a *RubiconAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *
 			Target: params.Inventory,
 			Track:  track,
 		}}
-		rubiReq.Imp[0].Ext, err = json.Marshal(&impExt)
+		err = adapters.SetImpExt(&rubiReq.Imp[0], &impExt)
 
 		// Copy the $.user object and amend with $.user.ext.rp.target
 		// Copy avoids race condition since it points to ref & shared with other adapters
@@ -367,6 +376,9 @@ func (a *RubiconAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *
 	ch := make(chan adapters.CallOneResult)
 	for i, _ := range bidder.AdUnits {
 		go func(bidder *pbs.PBSBidder, reqJSON bytes.Buffer) {
+			defer adapters.RecoverPanic(bidder.BidderCode, func(err error) {
+				ch <- adapters.CallOneResult{Error: err}
+			})
 			result, err := a.callOne(ctx, req, reqJSON)
 			result.Error = err
 			if result.Bid != nil {
@@ -395,6 +407,7 @@ func (a *RubiconAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *
 				RequestBody:  requests[i].String(),
 				StatusCode:   result.StatusCode,
 				ResponseBody: result.ResponseBody,
+				Timing:       result.Timing.Debug(),
 			}
 			bidder.Debug = append(bidder.Debug, debug)
 		}
@@ -430,17 +443,17 @@ func NewRubiconAdapter(config *adapters.HTTPAdapterConfig, uri string, xuser str
 
 	uri = appendTrackerToUrl(uri, tracker)
 
-	info := &pbs.UsersyncInfo{
+	info := &pbs.UsersyncTemplate{
 		URL:         usersyncURL,
 		Type:        "redirect",
 		SupportCORS: false,
 	}
 
 	return &RubiconAdapter{
-		http:         a,
-		URI:          uri,
-		usersyncInfo: info,
-		XAPIUsername: xuser,
-		XAPIPassword: xpass,
+		http:             a,
+		URI:              uri,
+		usersyncTemplate: info,
+		XAPIUsername:     xuser,
+		XAPIPassword:     xpass,
 	}
 }
@@ -0,0 +1,60 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// StripFields removes the fields at the dotted paths, like "user.geo" or "site.ext.data", from the request.
+// Paths which go through an array apply to each of its elements, so "imp.ext.context" strips it from every imp.
+// Fields which the request doesn't have are ignored.
+//
+// The request is rebuilt from JSON, so the objects which it shares with other bidders' requests aren't changed.
+func StripFields(request *openrtb.BidRequest, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	// UseNumber keeps big IDs from losing precision as float64s.
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		stripField(fields, strings.Split(path, "."))
+	}
+	if raw, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	var stripped openrtb.BidRequest
+	if err := json.Unmarshal(raw, &stripped); err != nil {
+		return err
+	}
+	*request = stripped
+	return nil
+}
+
+func stripField(value interface{}, path []string) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(typed, path[0])
+			return
+		}
+		if child, ok := typed[path[0]]; ok {
+			stripField(child, path[1:])
+		}
+	case []interface{}:
+		for _, element := range typed {
+			stripField(element, path)
+		}
+	}
+}
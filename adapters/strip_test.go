@@ -0,0 +1,45 @@
+package adapters
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripFields(t *testing.T) {
+	geo := &openrtb.Geo{Country: "USA"}
+	request := &openrtb.BidRequest{
+		ID:     "req",
+		Imp:    []openrtb.Imp{{ID: "1", TagID: "top"}, {ID: "2", TagID: "bottom"}},
+		Site:   &openrtb.Site{Page: "http://example.com", Keywords: "cars"},
+		User:   &openrtb.User{ID: "u1", Geo: geo, Ext: openrtb.RawJSON(`{"consent":"abc","data":{"segments":[1]}}`)},
+		Device: &openrtb.Device{IFA: "ifa"},
+		Ext:    openrtb.RawJSON(`{"id":12345678901234567890}`),
+	}
+	user := request.User
+
+	err := StripFields(request, []string{"user.geo", "user.ext.data", "site.keywords", "imp.tagid", "device.ifa", "app.bundle", "user.geo.country"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, request.ID, "req")
+	assert.Equal(t, request.Imp[0].TagID, "")
+	assert.Equal(t, request.Imp[1].TagID, "")
+	assert.Equal(t, request.Imp[1].ID, "2")
+	assert.Equal(t, request.Site.Page, "http://example.com")
+	assert.Equal(t, request.Site.Keywords, "")
+	assert.Equal(t, request.User.ID, "u1")
+	assert.Nil(t, request.User.Geo)
+	assert.Equal(t, string(request.User.Ext), `{"consent":"abc"}`)
+	assert.Equal(t, request.Device.IFA, "")
+	assert.Nil(t, request.App)
+	// Big numbers keep their precision
+	assert.Equal(t, string(request.Ext), `{"id":12345678901234567890}`)
+
+	// Objects shared with other requests aren't changed
+	assert.Equal(t, user.Geo, geo)
+	assert.Equal(t, geo.Country, "USA")
+
+	unchanged := &openrtb.BidRequest{ID: "req", User: user}
+	assert.Equal(t, StripFields(unchanged, nil), nil)
+	assert.Equal(t, unchanged.User == user, true)
+}
@@ -0,0 +1,112 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prebid/prebid-server/errortypes"
+)
+
+// DefaultMaxXMLBytes is a sensible limit for XML responses, like VAST documents.
+const DefaultMaxXMLBytes = 1 << 20
+
+// DecodeXML unmarshals an XML response, for Bidders whose endpoints don't speak OpenRTB.
+//
+// Responses larger than maxBytes are rejected without being parsed. The charset comes from the XML
+// declaration or, if that doesn't name one, the Content-Type header. UTF-8, US-ASCII, ISO-8859-1 and
+// windows-1252 are supported. Errors are BadServerResponses, so that bad payloads can be quarantined.
+//
+// encoding/xml never loads external entities or DTDs, so it's safe to use on untrusted responses.
+func DecodeXML(response *ResponseData, v interface{}, maxBytes int) error {
+	body := response.Body
+	if len(body) > maxBytes {
+		return &errortypes.BadServerResponse{
+			Message: fmt.Sprintf("XML response of %d bytes is over the %d byte limit", len(body), maxBytes),
+		}
+	}
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+
+	if !declaresEncoding(body) {
+		if charset := headerCharset(response); charset != "" {
+			reader, err := charsetReader(charset, bytes.NewReader(body))
+			if err != nil {
+				return &errortypes.BadServerResponse{Message: err.Error(), Payload: response.Body}
+			}
+			if body, err = ioutil.ReadAll(reader); err != nil {
+				return &errortypes.BadServerResponse{Message: err.Error(), Payload: response.Body}
+			}
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(v); err != nil {
+		return &errortypes.BadServerResponse{Message: err.Error(), Payload: response.Body}
+	}
+	return nil
+}
+
+// declaresEncoding returns true if the body starts with an XML declaration which names its encoding.
+func declaresEncoding(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	if !bytes.HasPrefix(body, []byte("<?xml")) {
+		return false
+	}
+	end := bytes.Index(body, []byte("?>"))
+	return end > 0 && bytes.Contains(body[:end], []byte("encoding"))
+}
+
+func headerCharset(response *ResponseData) string {
+	if response.Headers == nil {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(response.Headers.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
+
+// charsetReader converts the input to UTF-8. It's used as the xml.Decoder's CharsetReader.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	var table *[32]rune
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso_8859-1", "latin1", "l1":
+	case "windows-1252", "cp1252":
+		table = &windows1252
+	default:
+		return nil, fmt.Errorf("Unsupported XML charset %q", charset)
+	}
+	raw, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	decoded := make([]byte, 0, len(raw))
+	var buf [utf8.UTFMax]byte
+	for _, b := range raw {
+		r := rune(b)
+		if table != nil && b >= 0x80 && b < 0xa0 {
+			r = table[b-0x80]
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		decoded = append(decoded, buf[:n]...)
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+// windows1252 holds the characters which windows-1252 puts in place of ISO-8859-1's C1 controls (0x80 to 0x9f).
+// Unassigned bytes decode to the replacement character.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
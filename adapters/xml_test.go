@@ -0,0 +1,64 @@
+package adapters
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prebid/prebid-server/errortypes"
+)
+
+type testVAST struct {
+	Version string `xml:"version,attr"`
+	Title   string `xml:"Ad>InLine>AdTitle"`
+}
+
+func TestDecodeXML(t *testing.T) {
+	var vast testVAST
+	body := "\xef\xbb\xbf<VAST version=\"3.0\"><Ad><InLine><AdTitle>Caf\xc3\xa9</AdTitle></InLine></Ad></VAST>"
+	if err := DecodeXML(&ResponseData{Body: []byte(body)}, &vast, DefaultMaxXMLBytes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vast.Version != "3.0" || vast.Title != "Café" {
+		t.Errorf("Bad decoding: %#v", vast)
+	}
+}
+
+func TestDecodeXMLCharsets(t *testing.T) {
+	declared := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><VAST><Ad><InLine><AdTitle>Caf\xe9</AdTitle></InLine></Ad></VAST>"
+	var vast testVAST
+	if err := DecodeXML(&ResponseData{Body: []byte(declared)}, &vast, DefaultMaxXMLBytes); err != nil || vast.Title != "Café" {
+		t.Errorf("The declared charset should be used. Got %q, %v", vast.Title, err)
+	}
+
+	fromHeader := &ResponseData{
+		Body:    []byte("<VAST><Ad><InLine><AdTitle>\x80 off</AdTitle></InLine></Ad></VAST>"),
+		Headers: http.Header{"Content-Type": []string{"application/xml; charset=windows-1252"}},
+	}
+	vast = testVAST{}
+	if err := DecodeXML(fromHeader, &vast, DefaultMaxXMLBytes); err != nil || vast.Title != "€ off" {
+		t.Errorf("The header's charset should be used without a declaration. Got %q, %v", vast.Title, err)
+	}
+
+	unsupported := &ResponseData{
+		Body:    []byte("<VAST></VAST>"),
+		Headers: http.Header{"Content-Type": []string{"text/xml; charset=shift_jis"}},
+	}
+	if err := DecodeXML(unsupported, &vast, DefaultMaxXMLBytes); err == nil {
+		t.Errorf("Unsupported charsets should be rejected")
+	}
+}
+
+func TestDecodeXMLErrors(t *testing.T) {
+	var vast testVAST
+	err := DecodeXML(&ResponseData{Body: []byte("<VAST>" + strings.Repeat(" ", 100) + "</VAST>")}, &vast, 50)
+	if _, ok := err.(*errortypes.BadServerResponse); !ok {
+		t.Errorf("Oversized responses should be bad server responses. Got %v", err)
+	}
+
+	body := []byte("<VAST><Ad>")
+	err = DecodeXML(&ResponseData{Body: body}, &vast, DefaultMaxXMLBytes)
+	if badResponse, ok := err.(*errortypes.BadServerResponse); !ok || string(badResponse.Payload) != string(body) {
+		t.Errorf("Malformed XML should be a bad server response with the payload. Got %v", err)
+	}
+}
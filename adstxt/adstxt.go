@@ -0,0 +1,248 @@
+package adstxt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// fetchTimeout caps how long each ads.txt or sellers.json download may take.
+const fetchTimeout = 10 * time.Second
+
+// maxBodyBytes caps the size of each ads.txt or sellers.json file. The largest exchanges' sellers.json files
+// are a few megabytes.
+const maxBodyBytes = 20 << 20
+
+// ValidDomain returns true if the domain is a bare hostname, like www.publisher.com. Ports, credentials, paths
+// and IP addresses aren't allowed, since they'd let callers point the Checker at any server the host can reach.
+func ValidDomain(domain string) bool {
+	if len(domain) > 253 || net.ParseIP(domain) != nil {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Record is a single line from an ads.txt file.
+type Record struct {
+	// Domain is the ad system which sells the publisher's inventory, lowercased.
+	Domain string
+	// AccountID is the publisher's account with that ad system.
+	AccountID string
+	// Relationship is DIRECT or RESELLER.
+	Relationship string
+	CertID       string
+}
+
+// Parse reads the records from an ads.txt file. Comments, variables (like CONTACT=...) and lines
+// which don't have at least the three required fields are skipped.
+func Parse(body []byte) []Record {
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.Contains(line, "=") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		record := Record{
+			Domain:       strings.ToLower(strings.TrimSpace(fields[0])),
+			AccountID:    strings.TrimSpace(fields[1]),
+			Relationship: strings.ToUpper(strings.TrimSpace(fields[2])),
+		}
+		if len(fields) > 3 {
+			record.CertID = strings.TrimSpace(fields[3])
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// SellersJSON holds the parts of an IAB sellers.json file which the Checker needs.
+type SellersJSON struct {
+	Sellers []Seller `json:"sellers"`
+}
+
+type Seller struct {
+	SellerID   string `json:"seller_id"`
+	Name       string `json:"name"`
+	Domain     string `json:"domain"`
+	SellerType string `json:"seller_type"`
+}
+
+// Host is this server's place in the supply chain.
+type Host struct {
+	// ASI and SID are the ads.txt line which the publisher needs for the host.
+	// If ASI is empty, the publisher's ads.txt isn't checked for the host.
+	ASI string
+	SID string
+	// Domain is the host's business domain, which bidders should list in their sellers.json.
+	// If it's empty, bidders' sellers.json files aren't checked.
+	Domain string
+}
+
+// Bidder describes where a bidder's authorizations can be found.
+type Bidder struct {
+	// AdSystemDomain is the domain which publishers list for the bidder in their ads.txt.
+	AdSystemDomain string
+	SellersJSONURL string
+}
+
+// Report lists the authorization gaps which were found for a publisher.
+type Report struct {
+	Domain  string            `json:"domain"`
+	Host    *Check            `json:"host,omitempty"`
+	Bidders map[string]*Check `json:"bidders"`
+}
+
+// Check is the result for the host or a single bidder. Each gap is a human-readable explanation
+// of something which would stop bids from being authorized.
+type Check struct {
+	OK   bool     `json:"ok"`
+	Gaps []string `json:"gaps,omitempty"`
+}
+
+func (c *Check) addGap(format string, args ...interface{}) {
+	c.OK = false
+	c.Gaps = append(c.Gaps, fmt.Sprintf(format, args...))
+}
+
+// Checker downloads ads.txt and sellers.json files, and compares them with what the host and
+// bidders need.
+type Checker struct {
+	Client *http.Client
+}
+
+// Check reports the gaps in the publisher's ads.txt, and in each bidder's sellers.json.
+func (c *Checker) Check(ctx context.Context, domain string, host Host, bidders map[string]Bidder) *Report {
+	report := &Report{
+		Domain:  domain,
+		Bidders: make(map[string]*Check, len(bidders)),
+	}
+	records, adsTxtErr := c.fetchAdsTxt(ctx, domain)
+	hasRecord := func(adSystem string, accountID string) bool {
+		for _, record := range records {
+			if record.Domain == strings.ToLower(adSystem) && (accountID == "" || record.AccountID == accountID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if host.ASI != "" {
+		report.Host = &Check{OK: true}
+		if adsTxtErr != nil {
+			report.Host.addGap("couldn't fetch ads.txt: %v", adsTxtErr)
+		} else if !hasRecord(host.ASI, host.SID) {
+			report.Host.addGap("ads.txt has no line for %s, %s", host.ASI, host.SID)
+		}
+	}
+
+	for name, bidder := range bidders {
+		check := &Check{OK: true}
+		report.Bidders[name] = check
+		if bidder.AdSystemDomain == "" {
+			check.addGap("%s has no ad_system_domain configured", name)
+		} else if adsTxtErr != nil {
+			check.addGap("couldn't fetch ads.txt: %v", adsTxtErr)
+		} else if !hasRecord(bidder.AdSystemDomain, "") {
+			check.addGap("ads.txt has no line for %s", bidder.AdSystemDomain)
+		}
+
+		if bidder.SellersJSONURL == "" || host.Domain == "" {
+			continue
+		}
+		sellers, err := c.fetchSellersJSON(ctx, bidder.SellersJSONURL)
+		if err != nil {
+			check.addGap("couldn't fetch %s's sellers.json: %v", name, err)
+		} else if !sellers.lists(host.Domain) {
+			check.addGap("%s's sellers.json doesn't list %s", name, host.Domain)
+		}
+	}
+	return report
+}
+
+func (s *SellersJSON) lists(domain string) bool {
+	for _, seller := range s.Sellers {
+		if strings.EqualFold(seller.Domain, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchAdsTxt downloads the publisher's ads.txt. It tries HTTPS first, like crawlers do.
+func (c *Checker) fetchAdsTxt(ctx context.Context, domain string) ([]Record, error) {
+	if !ValidDomain(domain) {
+		return nil, fmt.Errorf("%q isn't a valid domain", domain)
+	}
+	body, err := c.fetch(ctx, "https://"+domain+"/ads.txt")
+	if err != nil {
+		if body, err = c.fetch(ctx, "http://"+domain+"/ads.txt"); err != nil {
+			return nil, err
+		}
+	}
+	return Parse(body), nil
+}
+
+func (c *Checker) fetchSellersJSON(ctx context.Context, url string) (*SellersJSON, error) {
+	body, err := c.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var sellers SellersJSON
+	if err := json.Unmarshal(body, &sellers); err != nil {
+		return nil, fmt.Errorf("bad sellers.json: %v", err)
+	}
+	return &sellers, nil
+}
+
+func (c *Checker) fetch(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	resp, err := ctxhttp.Get(ctx, c.Client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP status %d", url, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodyBytes {
+		return nil, fmt.Errorf("%s is over %d bytes", url, maxBodyBytes)
+	}
+	return body, nil
+}
@@ -0,0 +1,142 @@
+package adstxt
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const adsTxt = `# ads.txt for publisher.com
+CONTACT=ads@publisher.com
+pbshost.com, pub-1001, DIRECT
+RubiconProject.com, 12345, RESELLER, 0bfd66d529a55807 # Rubicon
+appnexus.com, 678
+`
+
+func TestParse(t *testing.T) {
+	records := Parse([]byte(adsTxt))
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records. Got %v", records)
+	}
+	expected := Record{Domain: "rubiconproject.com", AccountID: "12345", Relationship: "RESELLER", CertID: "0bfd66d529a55807"}
+	if records[1] != expected {
+		t.Errorf("Expected %#v. Got %#v", expected, records[1])
+	}
+}
+
+// publisherClient sends every request to the server, whatever its host, since publishers' domains can't have ports.
+func publisherClient(server *httptest.Server) *http.Client {
+	addr := server.Listener.Addr().String()
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ads.txt":
+			w.Write([]byte(adsTxt))
+		case "/rubicon/sellers.json":
+			w.Write([]byte(`{"sellers":[{"seller_id":"1","domain":"PBSHost.com","seller_type":"INTERMEDIARY"}]}`))
+		case "/pubmatic/sellers.json":
+			w.Write([]byte(`{"sellers":[{"seller_id":"2","domain":"other.com"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checker := &Checker{Client: publisherClient(server)}
+	report := checker.Check(context.Background(), "publisher.com", Host{ASI: "pbshost.com", SID: "pub-1001", Domain: "pbshost.com"}, map[string]Bidder{
+		"rubicon":  {AdSystemDomain: "rubiconproject.com", SellersJSONURL: server.URL + "/rubicon/sellers.json"},
+		"pubmatic": {AdSystemDomain: "pubmatic.com", SellersJSONURL: server.URL + "/pubmatic/sellers.json"},
+		"appnexus": {},
+	})
+
+	if !report.Host.OK {
+		t.Errorf("The host should be authorized. Got %v", report.Host.Gaps)
+	}
+	if !report.Bidders["rubicon"].OK {
+		t.Errorf("Rubicon should be authorized. Got %v", report.Bidders["rubicon"].Gaps)
+	}
+	if pubmatic := report.Bidders["pubmatic"]; pubmatic.OK || len(pubmatic.Gaps) != 2 {
+		t.Errorf("Pubmatic should be missing from ads.txt, and its sellers.json shouldn't list the host. Got %v", pubmatic.Gaps)
+	}
+	if appnexus := report.Bidders["appnexus"]; appnexus.OK || len(appnexus.Gaps) != 1 {
+		t.Errorf("Bidders without an ad system domain can't be checked. Got %v", appnexus.Gaps)
+	}
+}
+
+func TestCheckWrongSellerID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(adsTxt))
+	}))
+	defer server.Close()
+
+	checker := &Checker{Client: publisherClient(server)}
+	report := checker.Check(context.Background(), "publisher.com", Host{ASI: "pbshost.com", SID: "pub-2002"}, nil)
+	if report.Host.OK {
+		t.Errorf("The host's line has the wrong seller ID")
+	}
+}
+
+func TestCheckMissingAdsTxt(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	checker := &Checker{Client: publisherClient(server)}
+	report := checker.Check(context.Background(), "publisher.com", Host{ASI: "pbshost.com"}, map[string]Bidder{
+		"rubicon": {AdSystemDomain: "rubiconproject.com"},
+	})
+	if report.Host.OK || report.Bidders["rubicon"].OK {
+		t.Errorf("Nothing is authorized without an ads.txt")
+	}
+}
+
+func TestValidDomain(t *testing.T) {
+	for _, domain := range []string{"publisher.com", "www.Publisher.co.uk", "my-site.example"} {
+		if !ValidDomain(domain) {
+			t.Errorf("%s should be valid", domain)
+		}
+	}
+	for _, domain := range []string{"", "localhost", "publisher.com:8080", "user@internal.host", "publisher.com/admin", "10.0.0.1", "-bad.com", "a..com"} {
+		if ValidDomain(domain) {
+			t.Errorf("%s should be invalid", domain)
+		}
+	}
+}
+
+func TestCheckInvalidDomain(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(adsTxt))
+	}))
+	defer server.Close()
+
+	checker := &Checker{Client: server.Client()}
+	report := checker.Check(context.Background(), strings.TrimPrefix(server.URL, "http://"), Host{ASI: "pbshost.com", SID: "pub-1001"}, nil)
+	if report.Host.OK || requests != 0 {
+		t.Errorf("Domains with ports shouldn't be fetched. Got %d requests", requests)
+	}
+}
+
+func TestCheckLargeAdsTxt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(adsTxt))
+		w.Write(make([]byte, maxBodyBytes))
+	}))
+	defer server.Close()
+
+	checker := &Checker{Client: publisherClient(server)}
+	report := checker.Check(context.Background(), "publisher.com", Host{ASI: "pbshost.com", SID: "pub-1001"}, nil)
+	if report.Host.OK || !strings.Contains(report.Host.Gaps[0], "over") {
+		t.Errorf("Oversized files should be rejected. Got %v", report.Host.Gaps)
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"io"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/analytics/filesystem"
+	"github.com/prebid/prebid-server/config"
+)
+
+// NewPBSAnalytics returns a module which sends each auction to every analytics module enabled in the host config.
+// If none are enabled, the returned module does nothing.
+func NewPBSAnalytics(cfg *config.Analytics) analytics.PBSAnalyticsModule {
+	modules := make(enabledAnalytics, 0)
+	if cfg.File.Filename != "" {
+		if mod, err := filesystem.NewFileLogger(cfg.File); err == nil {
+			modules = append(modules, mod)
+		} else {
+			glog.Errorf("Could not initialize the file analytics module: %v", err)
+		}
+	}
+	return modules
+}
+
+type enabledAnalytics []analytics.PBSAnalyticsModule
+
+func (ea enabledAnalytics) LogAuctionObject(ao *analytics.AuctionObject) {
+	for _, module := range ea {
+		module.LogAuctionObject(ao)
+	}
+}
+
+// Close closes the modules which hold resources, like open files, so that nothing buffered is lost on shutdown.
+func (ea enabledAnalytics) Close() error {
+	var firstErr error
+	for _, module := range ea {
+		if closer, ok := module.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
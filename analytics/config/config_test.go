@@ -0,0 +1,46 @@
+package config
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestNoModulesEnabled(t *testing.T) {
+	modules := NewPBSAnalytics(&config.Analytics{})
+	if len(modules.(enabledAnalytics)) != 0 {
+		t.Errorf("No modules should be enabled by default.")
+	}
+	// This shouldn't panic
+	modules.LogAuctionObject(&analytics.AuctionObject{})
+}
+
+func TestFileModuleEnabled(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	cfg := &config.Analytics{}
+	cfg.File.Filename = tmpfile.Name()
+	cfg.File.BatchSize = 10
+	modules := NewPBSAnalytics(cfg)
+	modules.LogAuctionObject(&analytics.AuctionObject{Status: 200})
+	if err := modules.(io.Closer).Close(); err != nil {
+		t.Fatalf("Unexpected error closing the modules: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		t.Errorf("The file module should have logged the auction by the time it's closed.")
+	}
+}
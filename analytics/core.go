@@ -0,0 +1,69 @@
+package analytics
+
+import "github.com/prebid/prebid-server/pbs"
+
+// PBSAnalyticsModule is implemented by anything which wants to observe the auctions run by this server.
+//
+// Modules are called synchronously at the end of each request, so implementations should be quick
+// and must be safe for concurrent use. If late bids are being captured, auctions with slow bidders
+// are logged once the grace period is over instead.
+type PBSAnalyticsModule interface {
+	LogAuctionObject(*AuctionObject)
+}
+
+// AuctionObject describes a single call to the /auction endpoint.
+//
+// Bids in the Response carry their original price and currency in Ext, so that analytics
+// can reconcile them against the bidders' own reports.
+type AuctionObject struct {
+	Status   int
+	Errors   []error
+	Request  *pbs.PBSRequest
+	Response *pbs.PBSResponse
+	// Experiments maps each experiment which this auction took part in to the name of its bucket.
+	Experiments map[string]string
+	// LateBids arrived after the auction had responded, but within the host's late_bid_grace_ms.
+	// They never compete in the auction. Operators can use them to see how much revenue is lost to timeouts.
+	LateBids pbs.PBSBidSlice
+	// ShadowBids come from bidders in shadow mode. Like late bids, they never compete in the auction.
+	ShadowBids pbs.PBSBidSlice
+	// BidLandscape holds every bid which the bidders returned, including the ones which were rejected or
+	// left out of a targeting-only response. It's only set for the fraction of auctions which the host samples,
+	// for yield analysis like bid shading and floor optimization.
+	BidLandscape pbs.PBSBidSlice
+	// RejectedBids were returned by the bidders, but thrown out before the auction, or because they won below
+	// the account's minimum CPM.
+	RejectedBids []RejectedBid
+}
+
+// These are the reasons why a bid can be rejected after the bidder returns it.
+const (
+	// RejectedMissingSize bids are banners without a size, for ad units with more than one.
+	RejectedMissingSize = "missing_size"
+	// RejectedCurrency bids are in a currency which the bidder may not use, or which can't be converted.
+	RejectedCurrency = "invalid_currency"
+	// RejectedAdvertiserDomains bids don't declare their advertiser domains, but the account requires them.
+	RejectedAdvertiserDomains = "missing_adomain"
+	// RejectedInvalidNative bids are native, but their markup doesn't fit the ad unit's native request.
+	RejectedInvalidNative = "invalid_native"
+	// RejectedBlockedDomain bids are from an advertiser whose domain is blocked for the bidder or account.
+	RejectedBlockedDomain = "blocked_adomain"
+	// RejectedBelowMinCPM bids are on an ad unit whose top bid is below the account's min_winning_cpm.
+	RejectedBelowMinCPM = "below_min_cpm"
+)
+
+// RejectionReasons lists every reason a bid can be rejected for.
+var RejectionReasons = []string{
+	RejectedMissingSize,
+	RejectedCurrency,
+	RejectedAdvertiserDomains,
+	RejectedInvalidNative,
+	RejectedBlockedDomain,
+	RejectedBelowMinCPM,
+}
+
+// RejectedBid is a bid which was thrown out, along with the reason why.
+type RejectedBid struct {
+	Bid    *pbs.PBSBid `json:"bid"`
+	Reason string      `json:"reason"`
+}
@@ -0,0 +1,194 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// defaultGzipBatchSize is used if gzip is enabled without a batch size. Compressing single auctions saves very little.
+const defaultGzipBatchSize = 100
+
+// flushInterval bounds how long a partial batch can sit in memory.
+const flushInterval = time.Second
+
+// FileLogger writes each auction to a file, as a line of JSON or a length-delimited protobuf message.
+//
+// If batching is enabled, auctions are buffered and written in groups. With gzip, each group is
+// written as its own gzip member, and the file can be read with any gzip reader.
+type FileLogger struct {
+	mutex sync.Mutex
+	out   io.Writer
+
+	// encode defaults to encodeJSON.
+	encode    func(*analytics.AuctionObject) ([]byte, error)
+	gzip      *gzip.Writer
+	batchSize int
+	batch     bytes.Buffer
+	pending   int
+
+	// stop ends the periodic flushes. It's nil if there are none.
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type auctionEntry struct {
+	Status       int                     `json:"status"`
+	Errors       []string                `json:"errors,omitempty"`
+	Request      *pbs.PBSRequest         `json:"request,omitempty"`
+	Response     *pbs.PBSResponse        `json:"response,omitempty"`
+	Experiments  map[string]string       `json:"experiments,omitempty"`
+	LateBids     pbs.PBSBidSlice         `json:"late_bids,omitempty"`
+	ShadowBids   pbs.PBSBidSlice         `json:"shadow_bids,omitempty"`
+	BidLandscape pbs.PBSBidSlice         `json:"bid_landscape,omitempty"`
+	RejectedBids []analytics.RejectedBid `json:"rejected_bids,omitempty"`
+}
+
+// NewFileLogger opens (or creates) the configured file, and appends all future logs to it.
+func NewFileLogger(cfg config.FileLogs) (*FileLogger, error) {
+	logger := &FileLogger{batchSize: cfg.BatchSize}
+	switch cfg.Format {
+	case "", "json":
+		logger.encode = encodeJSON
+	case "protobuf":
+		logger.encode = encodeProtobuf
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+	if cfg.Gzip {
+		logger.gzip = gzip.NewWriter(nil)
+		if logger.batchSize <= 0 {
+			logger.batchSize = defaultGzipBatchSize
+		}
+	}
+
+	file, err := os.OpenFile(cfg.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logger.out = file
+	if logger.batchSize > 1 {
+		logger.stop = make(chan struct{})
+		go logger.flushEvery(flushInterval)
+	}
+	return logger, nil
+}
+
+func (l *FileLogger) LogAuctionObject(ao *analytics.AuctionObject) {
+	encode := l.encode
+	if encode == nil {
+		encode = encodeJSON
+	}
+	b, err := encode(ao)
+	if err != nil {
+		glog.Errorf("Failed to marshal auction analytics: %v", err)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.batch.Write(b)
+	l.pending++
+	if l.pending >= l.batchSize {
+		l.flush()
+	}
+}
+
+// Flush writes any buffered auctions to the file.
+func (l *FileLogger) Flush() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.flush()
+}
+
+// Close stops the periodic flushes, writes any buffered auctions, and closes the file. Auctions logged
+// afterwards are lost.
+func (l *FileLogger) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		if l.stop != nil {
+			close(l.stop)
+		}
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.flush()
+		if closer, ok := l.out.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+func (l *FileLogger) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// flush must be called with the mutex held.
+func (l *FileLogger) flush() {
+	if l.pending == 0 {
+		return
+	}
+	defer func() {
+		l.batch.Reset()
+		l.pending = 0
+	}()
+
+	data := l.batch.Bytes()
+	if l.gzip != nil {
+		var compressed bytes.Buffer
+		l.gzip.Reset(&compressed)
+		if _, err := l.gzip.Write(data); err != nil {
+			glog.Errorf("Failed to compress auction analytics: %v", err)
+			return
+		}
+		if err := l.gzip.Close(); err != nil {
+			glog.Errorf("Failed to compress auction analytics: %v", err)
+			return
+		}
+		data = compressed.Bytes()
+	}
+	if _, err := l.out.Write(data); err != nil {
+		glog.Errorf("Failed to write auction analytics: %v", err)
+	}
+}
+
+func encodeJSON(ao *analytics.AuctionObject) ([]byte, error) {
+	entry := auctionEntry{
+		Status:       ao.Status,
+		Request:      ao.Request,
+		Response:     ao.Response,
+		Experiments:  ao.Experiments,
+		LateBids:     ao.LateBids,
+		ShadowBids:   ao.ShadowBids,
+		BidLandscape: ao.BidLandscape,
+		RejectedBids: ao.RejectedBids,
+	}
+	for _, err := range ao.Errors {
+		entry.Errors = append(entry.Errors, err.Error())
+	}
+
+	b, err := json.Marshal(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,135 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+func TestLogAuctionObject(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &FileLogger{out: &buf}
+
+	logger.LogAuctionObject(&analytics.AuctionObject{
+		Status: 200,
+		Errors: []error{errors.New("bidder timed out")},
+		Response: &pbs.PBSResponse{
+			Bids: pbs.PBSBidSlice{
+				{
+					BidderCode: "appnexus",
+					Price:      1.5,
+					Ext:        &pbs.PBSBidExt{OrigBidCPM: 1.5, OrigBidCur: "USD"},
+				},
+			},
+		},
+		LateBids: pbs.PBSBidSlice{{BidderCode: "rubicon", Price: 2, ResponseTime: 320}},
+	})
+
+	var entry auctionEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to unmarshal the logged auction: %v", err)
+	}
+	if entry.Status != 200 {
+		t.Errorf("Expected status 200. Got %d", entry.Status)
+	}
+	if len(entry.Errors) != 1 || entry.Errors[0] != "bidder timed out" {
+		t.Errorf("Unexpected errors: %v", entry.Errors)
+	}
+	if len(entry.Response.Bids) != 1 || entry.Response.Bids[0].Ext.OrigBidCur != "USD" {
+		t.Errorf("The original bid currency should be logged.")
+	}
+	if len(entry.LateBids) != 1 || entry.LateBids[0].ResponseTime != 320 {
+		t.Errorf("Late bids should be logged with their response time. Got %v", entry.LateBids)
+	}
+}
+
+func TestLogAuctionObjectBatchesWithGzip(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &FileLogger{out: &buf, encode: encodeJSON, gzip: gzip.NewWriter(nil), batchSize: 2}
+
+	logger.LogAuctionObject(&analytics.AuctionObject{Status: 200})
+	if buf.Len() != 0 {
+		t.Fatalf("The first auction should be buffered")
+	}
+	logger.LogAuctionObject(&analytics.AuctionObject{Status: 400})
+	logger.LogAuctionObject(&analytics.AuctionObject{Status: 500})
+	logger.Flush()
+
+	reader, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("The output isn't gzipped: %v", err)
+	}
+	lines, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if count := bytes.Count(lines, []byte("\n")); count != 3 {
+		t.Errorf("Expected 3 auctions across both gzip members. Got %d", count)
+	}
+}
+
+func TestClose(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	logger, err := NewFileLogger(config.FileLogs{Filename: tmpfile.Name(), BatchSize: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger.LogAuctionObject(&analytics.AuctionObject{Status: 200})
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Unexpected error closing the logger: %v", err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Errorf("Closing twice should be harmless. Got %v", err)
+	}
+	b, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := bytes.Count(b, []byte("\n")); count != 1 {
+		t.Errorf("The buffered auction should be written on close. Got %d lines", count)
+	}
+}
+
+func TestEncodeProtobuf(t *testing.T) {
+	b, err := encodeProtobuf(&analytics.AuctionObject{
+		Status: 200,
+		Response: &pbs.PBSResponse{
+			Bids: pbs.PBSBidSlice{{BidderCode: "appnexus", Price: 1.5}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A 24 byte message: status=200, then bids={bidder="appnexus", price=1.5}
+	expected := []byte{
+		24,
+		0x08, 0xc8, 0x01,
+		0x52, 19,
+		0x1a, 8, 'a', 'p', 'p', 'n', 'e', 'x', 'u', 's',
+		0x21, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Bad encoding. Expected %v, got %v", expected, b)
+	}
+}
+
+func TestNewFileLoggerBadFormat(t *testing.T) {
+	if _, err := NewFileLogger(config.FileLogs{Filename: "unused", Format: "xml"}); err == nil {
+		t.Errorf("Unknown formats should be an error")
+	}
+}
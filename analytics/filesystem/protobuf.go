@@ -0,0 +1,173 @@
+package filesystem
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// encodeProtobuf writes the auction as a length-delimited AuctionEvent. See auction.proto for the schema.
+//
+// The wire format is written by hand, since the event only uses a handful of field types,
+// and this avoids generated code and reflection on the hot path.
+func encodeProtobuf(ao *analytics.AuctionObject) ([]byte, error) {
+	var e protoEncoder
+	e.int(1, int64(ao.Status))
+	for _, err := range ao.Errors {
+		e.repeatedString(2, err.Error())
+	}
+	experiments := make([]string, 0, len(ao.Experiments))
+	for experiment := range ao.Experiments {
+		experiments = append(experiments, experiment)
+	}
+	sort.Strings(experiments)
+	for _, experiment := range experiments {
+		var entry protoEncoder
+		entry.string(1, experiment)
+		entry.string(2, ao.Experiments[experiment])
+		e.message(3, entry.buf)
+	}
+
+	if req := ao.Request; req != nil {
+		e.string(4, req.AccountID)
+		e.string(5, req.Tid)
+		e.string(6, req.Domain)
+		if req.App != nil {
+			e.string(7, req.App.Bundle)
+		}
+		e.int(8, req.TimeoutMillis)
+		e.string(15, req.ChannelName())
+	}
+
+	if resp := ao.Response; resp != nil {
+		for _, bidder := range resp.BidderStatus {
+			var b protoEncoder
+			b.string(1, bidder.BidderCode)
+			b.int(2, int64(bidder.ResponseTime))
+			b.int(3, int64(bidder.NumBids))
+			b.string(4, bidder.Error)
+			b.bool(5, bidder.NoCookie)
+			b.bool(6, bidder.NoBid)
+			e.message(9, b.buf)
+		}
+		for _, bid := range resp.Bids {
+			e.message(10, encodeBid(bid))
+		}
+	}
+	for _, bid := range ao.LateBids {
+		e.message(11, encodeBid(bid))
+	}
+	for _, bid := range ao.ShadowBids {
+		e.message(12, encodeBid(bid))
+	}
+	for _, bid := range ao.BidLandscape {
+		e.message(13, encodeBid(bid))
+	}
+	for _, rejected := range ao.RejectedBids {
+		var r protoEncoder
+		r.message(1, encodeBid(rejected.Bid))
+		r.string(2, rejected.Reason)
+		e.message(14, r.buf)
+	}
+
+	framed := make([]byte, 0, len(e.buf)+binary.MaxVarintLen64)
+	framed = appendVarint(framed, uint64(len(e.buf)))
+	return append(framed, e.buf...), nil
+}
+
+// encodeBid writes a Bid message. See auction.proto.
+func encodeBid(bid *pbs.PBSBid) []byte {
+	var b protoEncoder
+	b.string(1, bid.BidID)
+	b.string(2, bid.AdUnitCode)
+	b.string(3, bid.BidderCode)
+	b.double(4, bid.Price)
+	b.uint(5, bid.Width)
+	b.uint(6, bid.Height)
+	b.string(7, bid.DealId)
+	b.string(8, bid.Creative_id)
+	b.int(9, int64(bid.ResponseTime))
+	if bid.Ext != nil {
+		b.double(10, bid.Ext.OrigBidCPM)
+		b.string(11, bid.Ext.OrigBidCur)
+	}
+	b.string(12, bid.CreativeMediaType)
+	if bid.Meta != nil {
+		for _, domain := range bid.Meta.AdvertiserDomains {
+			b.repeatedString(13, domain)
+		}
+	}
+	return b.buf
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protoEncoder appends proto3 fields to buf. Like proto3, it leaves out fields with zero values.
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) tag(field int, wireType int) {
+	e.buf = appendVarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *protoEncoder) int(field int, value int64) {
+	if value != 0 {
+		e.tag(field, wireVarint)
+		e.buf = appendVarint(e.buf, uint64(value))
+	}
+}
+
+func (e *protoEncoder) uint(field int, value uint64) {
+	if value != 0 {
+		e.tag(field, wireVarint)
+		e.buf = appendVarint(e.buf, value)
+	}
+}
+
+func (e *protoEncoder) bool(field int, value bool) {
+	if value {
+		e.int(field, 1)
+	}
+}
+
+func (e *protoEncoder) double(field int, value float64) {
+	if value != 0 {
+		e.tag(field, wireFixed64)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(value))
+		e.buf = append(e.buf, b[:]...)
+	}
+}
+
+func (e *protoEncoder) string(field int, value string) {
+	if value != "" {
+		e.repeatedString(field, value)
+	}
+}
+
+// repeatedString writes the value even if it's empty, so that it keeps its place in the list.
+func (e *protoEncoder) repeatedString(field int, value string) {
+	e.tag(field, wireBytes)
+	e.buf = appendVarint(e.buf, uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func (e *protoEncoder) message(field int, value []byte) {
+	e.tag(field, wireBytes)
+	e.buf = appendVarint(e.buf, uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func appendVarint(buf []byte, value uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], value)
+	return append(buf, b[:n]...)
+}
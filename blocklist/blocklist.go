@@ -0,0 +1,115 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prebid/prebid-server/refresher"
+)
+
+// Blocked are the app bundles and advertiser domains which mustn't be bought. They're sent to bidders
+// as bapp and badv.
+type Blocked struct {
+	AppBundles []string `json:"bapp"`
+	Domains    []string `json:"badv"`
+}
+
+// BlocksDomain returns true if any of the advertiser domains is blocked. Domains are compared case-insensitively.
+func (b Blocked) BlocksDomain(domains []string) bool {
+	for _, domain := range domains {
+		for _, blocked := range b.Domains {
+			if strings.EqualFold(domain, blocked) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lists are the blocked app bundles and domains for each bidder and account.
+type Lists struct {
+	// Bidders are keyed by lowercased bidder code.
+	Bidders  map[string]Blocked `json:"bidders"`
+	Accounts map[string]Blocked `json:"accounts"`
+}
+
+// ParseLists parses a JSON file like {"bidders": {"appnexus": {"badv": ["example.com"]}}, "accounts": {"1001": {"bapp": ["com.example.game"]}}}.
+// It's meant to be used with remotefile.Fetcher.FetchFunc.
+func ParseLists(body []byte) (interface{}, error) {
+	var lists Lists
+	if err := json.Unmarshal(body, &lists); err != nil {
+		return nil, fmt.Errorf("bad blocklist: %v", err)
+	}
+	bidders := make(map[string]Blocked, len(lists.Bidders))
+	for bidder, blocked := range lists.Bidders {
+		bidders[strings.ToLower(bidder)] = blocked
+	}
+	lists.Bidders = bidders
+	return &lists, nil
+}
+
+// For returns everything which is blocked for the bidder on the account's traffic.
+func (l *Lists) For(account string, bidder string) Blocked {
+	accountBlocked := l.Accounts[account]
+	bidderBlocked := l.Bidders[strings.ToLower(bidder)]
+	return Blocked{
+		AppBundles: union(accountBlocked.AppBundles, bidderBlocked.AppBundles),
+		Domains:    union(accountBlocked.Domains, bidderBlocked.Domains),
+	}
+}
+
+func union(first []string, second []string) []string {
+	if len(second) == 0 {
+		return first
+	}
+	if len(first) == 0 {
+		return second
+	}
+	seen := make(map[string]bool, len(first)+len(second))
+	merged := make([]string, 0, len(first)+len(second))
+	for _, value := range append(append([]string{}, first...), second...) {
+		if !seen[value] {
+			seen[value] = true
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+// Blocklist supplies the latest Lists.
+//
+// A nil *Blocklist is valid, and blocks nothing.
+type Blocklist struct {
+	lists func() (*Lists, error)
+}
+
+// NewStatic returns a Blocklist whose Lists never change.
+func NewStatic(lists *Lists) *Blocklist {
+	return &Blocklist{lists: func() (*Lists, error) { return lists, nil }}
+}
+
+// NewRefreshed returns a Blocklist for lists which a Refresher fetches with ParseLists.
+// Stale lists are still used, since an old blocklist is better than none.
+func NewRefreshed(lists *refresher.Refresher) *Blocklist {
+	return &Blocklist{lists: func() (*Lists, error) {
+		data, err := lists.Get()
+		if data == nil {
+			return nil, err
+		}
+		return data.(*Lists), nil
+	}}
+}
+
+// Blocked returns everything which is blocked for the bidder on the account's traffic. Nothing is blocked
+// if the lists haven't been fetched yet.
+func (b *Blocklist) Blocked(account string, bidder string) Blocked {
+	if b == nil {
+		return Blocked{}
+	}
+	lists, err := b.lists()
+	if err != nil || lists == nil {
+		return Blocked{}
+	}
+	return lists.For(account, bidder)
+}
@@ -0,0 +1,53 @@
+package blocklist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLists(t *testing.T) {
+	data, err := ParseLists([]byte(`{
+		"bidders": {"AppNexus": {"badv": ["bad.com", "shared.com"]}},
+		"accounts": {"1001": {"bapp": ["com.example.game"], "badv": ["shared.com", "other.com"]}}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lists := data.(*Lists)
+
+	blocked := lists.For("1001", "appnexus")
+	if !reflect.DeepEqual(blocked.AppBundles, []string{"com.example.game"}) {
+		t.Errorf("Expected the account's app bundles. Got %v", blocked.AppBundles)
+	}
+	if !reflect.DeepEqual(blocked.Domains, []string{"shared.com", "other.com", "bad.com"}) {
+		t.Errorf("Expected the account's and bidder's domains without duplicates. Got %v", blocked.Domains)
+	}
+	if blocked := lists.For("1002", "rubicon"); len(blocked.AppBundles) != 0 || len(blocked.Domains) != 0 {
+		t.Errorf("Nothing should be blocked for other accounts and bidders. Got %v", blocked)
+	}
+
+	if _, err := ParseLists([]byte(`{"bidders": []}`)); err == nil {
+		t.Error("Expected an error for a bad file")
+	}
+}
+
+func TestBlocksDomain(t *testing.T) {
+	blocked := Blocked{Domains: []string{"bad.com"}}
+	if !blocked.BlocksDomain([]string{"good.com", "BAD.com"}) {
+		t.Error("Domains should be compared case-insensitively")
+	}
+	if blocked.BlocksDomain([]string{"good.com"}) || blocked.BlocksDomain(nil) {
+		t.Error("Only blocked domains should be blocked")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	var nilBlocklist *Blocklist
+	if blocked := nilBlocklist.Blocked("1001", "appnexus"); len(blocked.Domains) != 0 {
+		t.Errorf("A nil Blocklist shouldn't block anything. Got %v", blocked)
+	}
+	static := NewStatic(&Lists{Bidders: map[string]Blocked{"appnexus": {Domains: []string{"bad.com"}}}})
+	if blocked := static.Blocked("1001", "AppNexus"); len(blocked.Domains) != 1 {
+		t.Errorf("Expected the bidder's domains. Got %v", blocked)
+	}
+}
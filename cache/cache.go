@@ -1,5 +1,11 @@
 package cache
 
+import "errors"
+
+// ErrNotFound is returned by services which don't have the requested ID, so that callers can
+// tell a missing ID from a failing backend.
+var ErrNotFound = errors.New("Not found")
+
 type Domain struct {
 	Domain string `json:"domain"`
 }
@@ -32,3 +38,40 @@ type ConfigService interface {
 	Get(string) (string, error)
 	Set(string, string) error
 }
+
+// Stats describe the accounts and configs which a Cache keeps in memory.
+type Stats struct {
+	Entries int64   `json:"entries"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// Inspector is implemented by Caches which keep accounts and configs in memory. It lets admins check
+// on the cache, and drop entries which were fixed in the backing store without a restart.
+type Inspector interface {
+	Stats() Stats
+	// Invalidate drops the account or config with the given ID, so that it's reloaded the next time
+	// it's used. It returns false if the ID wasn't in memory.
+	Invalidate(id string) bool
+}
+
+// ConfigLoader is implemented by ConfigServices which keep configs in memory. Load always reads
+// the config from the backing store, and replaces any copy in memory.
+type ConfigLoader interface {
+	Load(string) (string, error)
+}
+
+// WarmConfigs loads the stored configs into memory ahead of time, so that the first auctions which
+// use them don't have to wait on the backing store. It returns the error for each ID which failed.
+func WarmConfigs(configs ConfigService, ids []string) map[string]error {
+	load := configs.Get
+	if loader, ok := configs.(ConfigLoader); ok {
+		load = loader.Load
+	}
+	errs := make(map[string]error)
+	for _, id := range ids {
+		if _, err := load(id); err != nil {
+			errs[id] = err
+		}
+	}
+	return errs
+}
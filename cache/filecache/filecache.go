@@ -118,7 +118,7 @@ type configService struct {
 func (s *configService) Get(id string) (string, error) {
 	cfg, ok := s.shared.Configs[id]
 	if !ok {
-		return "", fmt.Errorf("Not found")
+		return "", cache.ErrNotFound
 	}
 	return cfg, nil
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/prebid/prebid-server/cache"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -73,7 +74,7 @@ func TestFileCache(t *testing.T) {
 	}
 
 	c, err = dataCache.Config().Get("abc123")
-	if err == nil {
-		t.Error("config should not exist in cache")
+	if err != cache.ErrNotFound {
+		t.Errorf("config should not exist in cache. Got %v", err)
 	}
 }
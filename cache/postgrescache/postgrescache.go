@@ -107,6 +107,20 @@ func (c *Cache) Close() error {
 	return c.shared.db.Close()
 }
 
+// Stats describe the lru cache, which holds both accounts and configs.
+func (c *Cache) Stats() cache.Stats {
+	return cache.Stats{
+		Entries: c.shared.lru.EntryCount(),
+		HitRate: c.shared.lru.HitRate(),
+	}
+}
+
+// Invalidate drops the ID from the lru cache. Accounts and configs share the cache, so an account and
+// a config with the same ID are both dropped.
+func (c *Cache) Invalidate(id string) bool {
+	return c.shared.lru.Del([]byte(id))
+}
+
 // AccountService handles the account information
 type accountService struct {
 	shared *shared
@@ -169,9 +183,17 @@ func (s *configService) Get(key string) (string, error) {
 	if b, err := s.shared.lru.Get([]byte(key)); err == nil {
 		return string(b), nil
 	}
+	return s.Load(key)
+}
+
+// Load reads the config from postgres, even if it's already in the lru cache.
+func (s *configService) Load(key string) (string, error) {
 	var config string
 	if err := s.shared.db.QueryRow("SELECT config FROM s2sconfig_config where uuid = $1 LIMIT 1", key).Scan(&config); err != nil {
 		/* TODO -- We should store failed attempts in the LRU as well to stop from hitting to DB */
+		if err == sql.ErrNoRows {
+			return "", cache.ErrNotFound
+		}
 		return "", err
 	}
 	s.shared.lru.Set([]byte(key), []byte(config), s.shared.ttlSeconds)
@@ -8,6 +8,7 @@ import (
 	"github.com/coocood/freecache"
 	"github.com/erikstmartin/go-testdb"
 	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/cache"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -124,3 +125,55 @@ func TestPostgresDbNullPriceGranularity(t *testing.T) {
 		t.Error("Expected null string")
 	}
 }
+
+func TestWarmConfigs(t *testing.T) {
+	defer testdb.Reset()
+
+	sql := "SELECT config FROM s2sconfig_config where uuid = $1 LIMIT 1"
+	testdb.StubQuery(sql, testdb.RowsFromCSVString([]string{"config"}, "stored-config"))
+
+	dataCache := StubNew(PostgresConfig{Size: 100})
+	if errs := cache.WarmConfigs(dataCache.Config(), []string{"amp-top"}); len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	// Once warm, the config is served from memory even if the db goes away.
+	testdb.Reset()
+	config, err := dataCache.Config().Get("amp-top")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config != "stored-config" {
+		t.Errorf("Expected stored-config. Got %s", config)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	defer testdb.Reset()
+
+	sql := "SELECT config FROM s2sconfig_config where uuid = $1 LIMIT 1"
+	testdb.StubQuery(sql, testdb.RowsFromCSVString([]string{"config"}, "old-config"))
+
+	dataCache := StubNew(PostgresConfig{Size: 100})
+	if _, err := dataCache.Config().Get("amp-top"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entries := dataCache.Stats().Entries; entries != 1 {
+		t.Errorf("Expected 1 entry in memory. Got %d", entries)
+	}
+
+	testdb.StubQuery(sql, testdb.RowsFromCSVString([]string{"config"}, "new-config"))
+	if !dataCache.Invalidate("amp-top") {
+		t.Errorf("amp-top should have been in memory")
+	}
+	if dataCache.Invalidate("amp-top") {
+		t.Errorf("amp-top shouldn't be in memory after it's invalidated")
+	}
+	config, err := dataCache.Config().Get("amp-top")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config != "new-config" {
+		t.Errorf("The config should be reloaded after it's invalidated. Got %s", config)
+	}
+}
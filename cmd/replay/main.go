@@ -0,0 +1,104 @@
+// Command replay sends a recording of auction requests to a Prebid Server at a controlled rate.
+//
+// If a -baseline server is given too, each request goes to both servers and the responses are
+// diffed. This can be used to compare two builds before a deploy:
+//
+//	replay -requests auctions.jsonl -qps 50 -baseline http://localhost:8000 -candidate http://localhost:9000
+//
+// The recording has one JSON object per line:
+//
+//	{"method": "POST", "path": "/auction", "headers": {"Referer": ["http://example.com"]}, "body": {...}}
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func main() {
+	requestsFile := flag.String("requests", "", "File of recorded requests, with one JSON object per line.")
+	candidate := flag.String("candidate", "http://localhost:8000", "The server being tested.")
+	baseline := flag.String("baseline", "", "Optional server whose responses are diffed against the candidate's.")
+	qps := flag.Int("qps", 10, "Requests per second sent to each server.")
+	timeout := flag.Duration("timeout", 2*time.Second, "Timeout for each request.")
+	ignore := flag.String("ignore", "response_time_ms,cache_id,cache_url", "Comma-separated JSON keys which are left out of the diff.")
+	maxDiffs := flag.Int("max_diffs", 20, "The maximum number of diffs to print.")
+	flag.Parse()
+
+	if *requestsFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*requestsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", *requestsFile, err)
+		os.Exit(1)
+	}
+	records, err := ReadRecords(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", *requestsFile, err)
+		os.Exit(1)
+	}
+
+	ignored := make(map[string]bool)
+	for _, key := range strings.Split(*ignore, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			ignored[key] = true
+		}
+	}
+
+	replayer, err := NewReplayer(&http.Client{}, *timeout, *qps, *baseline, *candidate, ignored)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad flags: %v\n", err)
+		os.Exit(2)
+	}
+	comparisons := replayer.Run(context.Background(), records)
+
+	baselineResults := make([]*Result, len(comparisons))
+	candidateResults := make([]*Result, len(comparisons))
+	diffs := 0
+	for i, c := range comparisons {
+		baselineResults[i] = c.Baseline
+		candidateResults[i] = c.Candidate
+		if c.Diff != "" {
+			diffs++
+			if diffs <= *maxDiffs {
+				fmt.Printf("request %d: %s\n", c.Index+1, c.Diff)
+			}
+		}
+	}
+
+	if *baseline != "" {
+		printSummary("baseline", *baseline, Summarize(baselineResults))
+	}
+	printSummary("candidate", *candidate, Summarize(candidateResults))
+	if *baseline != "" {
+		fmt.Printf("%d of %d responses differ\n", diffs, len(comparisons))
+		if diffs > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func printSummary(name string, target string, s Summary) {
+	statuses := make([]int, 0, len(s.Statuses))
+	for status := range s.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	counts := make([]string, len(statuses))
+	for i, status := range statuses {
+		counts[i] = fmt.Sprintf("%d=%d", status, s.Statuses[status])
+	}
+
+	fmt.Printf("%s (%s): %d requests, %d errors, statuses [%s], p50 %v, p90 %v, p99 %v\n",
+		name, target, s.Requests, s.Errors, strings.Join(counts, " "), s.P50, s.P90, s.P99)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Record is a single recorded request. Recordings are files with one JSON Record per line.
+type Record struct {
+	Method string          `json:"method,omitempty"`
+	Path   string          `json:"path"`
+	Header http.Header     `json:"headers,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// ReadRecords parses a recording. Blank lines are ignored.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Method == "" {
+			record.Method = "POST"
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Result is the response from one target to one Record.
+type Result struct {
+	Status  int
+	Body    []byte
+	Latency time.Duration
+	Err     error
+}
+
+// Replayer sends recorded requests to one or two servers at a fixed rate.
+type Replayer struct {
+	Client  *http.Client
+	Timeout time.Duration
+	QPS     int
+	// Baseline is optional. If set, every response from the Candidate is diffed against it.
+	Baseline  string
+	Candidate string
+	// Ignore lists JSON keys which are expected to differ from one response to the next.
+	Ignore map[string]bool
+}
+
+// NewReplayer makes a Replayer which sends qps requests per second to each server. Requests are
+// sent on a ticker, so the rate can't be more than one per nanosecond.
+func NewReplayer(client *http.Client, timeout time.Duration, qps int, baseline string, candidate string, ignore map[string]bool) (*Replayer, error) {
+	if qps <= 0 || time.Duration(qps) > time.Second {
+		return nil, fmt.Errorf("qps must be between 1 and %d. Got %d", int64(time.Second), qps)
+	}
+	return &Replayer{
+		Client:    client,
+		Timeout:   timeout,
+		QPS:       qps,
+		Baseline:  baseline,
+		Candidate: candidate,
+		Ignore:    ignore,
+	}, nil
+}
+
+// Comparison holds the outcome of replaying one Record.
+type Comparison struct {
+	Index     int
+	Baseline  *Result
+	Candidate *Result
+	// Diff describes the first difference between the two responses. It's empty if they match.
+	Diff string
+}
+
+// Run replays the records and returns a Comparison for each, in the same order as the records.
+func (r *Replayer) Run(ctx context.Context, records []Record) []Comparison {
+	comparisons := make([]Comparison, len(records))
+	ticker := time.NewTicker(time.Second / time.Duration(r.QPS))
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	for i := range records {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return comparisons[:i]
+		case <-ticker.C:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			comparisons[i] = r.replay(ctx, i, records[i])
+		}(i)
+	}
+	wg.Wait()
+	return comparisons
+}
+
+func (r *Replayer) replay(ctx context.Context, index int, record Record) Comparison {
+	c := Comparison{Index: index}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Candidate = r.send(ctx, r.Candidate, record)
+	}()
+	if r.Baseline != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Baseline = r.send(ctx, r.Baseline, record)
+		}()
+	}
+	wg.Wait()
+
+	if c.Baseline != nil {
+		c.Diff = diffResults(c.Baseline, c.Candidate, r.Ignore)
+	}
+	return c
+}
+
+func (r *Replayer) send(ctx context.Context, target string, record Record) *Result {
+	req, err := http.NewRequest(record.Method, strings.TrimRight(target, "/")+record.Path, bytes.NewReader(record.Body))
+	if err != nil {
+		return &Result{Err: err}
+	}
+	for key, values := range record.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := ctxhttp.Do(ctx, r.Client, req)
+	if err != nil {
+		return &Result{Err: err, Latency: time.Since(start)}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return &Result{
+		Status:  resp.StatusCode,
+		Body:    body,
+		Latency: time.Since(start),
+		Err:     err,
+	}
+}
+
+func diffResults(baseline *Result, candidate *Result, ignore map[string]bool) string {
+	if baseline.Err != nil || candidate.Err != nil {
+		if (baseline.Err == nil) != (candidate.Err == nil) {
+			return fmt.Sprintf("error: baseline %v, candidate %v", baseline.Err, candidate.Err)
+		}
+		return ""
+	}
+	if baseline.Status != candidate.Status {
+		return fmt.Sprintf("status: baseline %d, candidate %d", baseline.Status, candidate.Status)
+	}
+
+	var baselineJSON, candidateJSON interface{}
+	if json.Unmarshal(baseline.Body, &baselineJSON) != nil || json.Unmarshal(candidate.Body, &candidateJSON) != nil {
+		if !bytes.Equal(baseline.Body, candidate.Body) {
+			return "body: the responses differ"
+		}
+		return ""
+	}
+	return diffJSON("$", baselineJSON, candidateJSON, ignore)
+}
+
+// diffJSON returns a description of the first difference between two unmarshalled JSON values.
+// Object keys in ignore are skipped at any depth. Arrays of objects with IDs, like bids, are
+// matched up by ID, since servers don't have to return them in the same order.
+func diffJSON(path string, baseline interface{}, candidate interface{}, ignore map[string]bool) string {
+	switch b := baseline.(type) {
+	case map[string]interface{}:
+		c, ok := candidate.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(b)+len(c))
+		for key := range b {
+			keys = append(keys, key)
+		}
+		for key := range c {
+			if _, ok := b[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if ignore[key] {
+				continue
+			}
+			if diff := diffJSON(path+"."+key, b[key], c[key], ignore); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	case []interface{}:
+		c, ok := candidate.([]interface{})
+		if !ok {
+			break
+		}
+		if key := idKey(b, c); key != "" {
+			return diffByID(path, key, b, c, ignore)
+		}
+		if len(b) != len(c) {
+			break
+		}
+		for i := range b {
+			if diff := diffJSON(fmt.Sprintf("%s[%d]", path, i), b[i], c[i], ignore); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	}
+	if reflect.DeepEqual(baseline, candidate) {
+		return ""
+	}
+	return fmt.Sprintf("%s: baseline %s, candidate %s", path, describe(baseline), describe(candidate))
+}
+
+// idKeys are the keys which identify the objects in an array. "bid_id" is used by /auction bids.
+var idKeys = []string{"id", "bid_id"}
+
+// idKey returns the key which identifies every object in both arrays, or "" if there isn't one.
+func idKey(baseline []interface{}, candidate []interface{}) string {
+	if len(baseline) == 0 && len(candidate) == 0 {
+		return ""
+	}
+	for _, key := range idKeys {
+		if uniqueIDs(baseline, key) && uniqueIDs(candidate, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+func uniqueIDs(values []interface{}, key string) bool {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		id, ok := object[key].(string)
+		if !ok || seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// diffByID diffs the objects in two arrays which have the same ID. Objects in only one of them are differences too.
+func diffByID(path string, key string, baseline []interface{}, candidate []interface{}, ignore map[string]bool) string {
+	candidates := make(map[string]interface{}, len(candidate))
+	for _, value := range candidate {
+		candidates[value.(map[string]interface{})[key].(string)] = value
+	}
+	for _, value := range baseline {
+		id := value.(map[string]interface{})[key].(string)
+		if diff := diffJSON(fmt.Sprintf("%s[%s=%s]", path, key, id), value, candidates[id], ignore); diff != "" {
+			return diff
+		}
+		delete(candidates, id)
+	}
+	for _, value := range candidate {
+		id := value.(map[string]interface{})[key].(string)
+		if _, ok := candidates[id]; ok {
+			return fmt.Sprintf("%s[%s=%s]: baseline missing, candidate %s", path, key, id, describe(value))
+		}
+	}
+	return ""
+}
+
+func describe(value interface{}) string {
+	if value == nil {
+		return "missing"
+	}
+	b, _ := json.Marshal(value)
+	if len(b) > 80 {
+		return string(b[:77]) + "..."
+	}
+	return string(b)
+}
+
+// Summary aggregates the responses from one target.
+type Summary struct {
+	Requests int
+	Errors   int
+	Statuses map[int]int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+}
+
+// Summarize aggregates a set of results. Nil results are skipped.
+func Summarize(results []*Result) Summary {
+	s := Summary{Statuses: make(map[int]int)}
+	latencies := make([]time.Duration, 0, len(results))
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		s.Requests++
+		if result.Err != nil {
+			s.Errors++
+			continue
+		}
+		s.Statuses[result.Status]++
+		latencies = append(latencies, result.Latency)
+	}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Sort(durations(latencies))
+	s.P50 = percentile(latencies, 50)
+	s.P90 = percentile(latencies, 90)
+	s.P99 = percentile(latencies, 99)
+	return s
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRecords(t *testing.T) {
+	recording := `{"path":"/auction","headers":{"Referer":["http://example.com"]},"body":{"tid":"1"}}
+
+{"method":"GET","path":"/status"}
+`
+	records, err := ReadRecords(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records. Got %d", len(records))
+	}
+	if records[0].Method != "POST" || string(records[0].Body) != `{"tid":"1"}` || records[0].Header.Get("Referer") != "http://example.com" {
+		t.Errorf("Bad first record: %v", records[0])
+	}
+	if records[1].Method != "GET" {
+		t.Errorf("Expected a GET. Got %s", records[1].Method)
+	}
+
+	if _, err := ReadRecords(strings.NewReader("{bad json")); err == nil {
+		t.Errorf("Bad JSON should be an error")
+	}
+}
+
+func newServer(price string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"tid":` + string(body) + `,"response_time_ms":` + price + `,"bids":[{"price":` + price + `}]}`))
+	}))
+}
+
+func TestRunDiffsResponses(t *testing.T) {
+	baseline := newServer("1")
+	defer baseline.Close()
+	candidate := newServer("2")
+	defer candidate.Close()
+
+	replayer, err := NewReplayer(&http.Client{}, time.Second, 1000, baseline.URL, candidate.URL, map[string]bool{"response_time_ms": true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	comparisons := replayer.Run(context.Background(), []Record{{Method: "POST", Path: "/auction", Body: []byte(`"a"`)}})
+	if len(comparisons) != 1 {
+		t.Fatalf("Expected 1 comparison. Got %d", len(comparisons))
+	}
+	if comparisons[0].Diff != "$.bids[0].price: baseline 1, candidate 2" {
+		t.Errorf("Bad diff: %s", comparisons[0].Diff)
+	}
+}
+
+func TestRunSameResponses(t *testing.T) {
+	baseline := newServer("1")
+	defer baseline.Close()
+
+	replayer, err := NewReplayer(&http.Client{}, time.Second, 1000, baseline.URL, baseline.URL, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	comparisons := replayer.Run(context.Background(), []Record{{Method: "POST", Path: "/auction", Body: []byte(`"a"`)}, {Method: "POST", Path: "/auction", Body: []byte(`"b"`)}})
+	for _, c := range comparisons {
+		if c.Diff != "" {
+			t.Errorf("Identical responses shouldn't differ. Got %s", c.Diff)
+		}
+	}
+
+	summary := Summarize([]*Result{comparisons[0].Candidate, comparisons[1].Candidate, nil})
+	if summary.Requests != 2 || summary.Statuses[http.StatusOK] != 2 {
+		t.Errorf("Bad summary: %v", summary)
+	}
+}
+
+func TestDiffJSONMissingKey(t *testing.T) {
+	baseline := map[string]interface{}{"a": "x"}
+	candidate := map[string]interface{}{"a": "x", "b": "y"}
+	if diff := diffJSON("$", baseline, candidate, nil); diff != `$.b: baseline missing, candidate "y"` {
+		t.Errorf("Bad diff: %s", diff)
+	}
+}
+
+func TestNewReplayerQPS(t *testing.T) {
+	for _, qps := range []int{0, -1, int(time.Second) + 1} {
+		if _, err := NewReplayer(&http.Client{}, time.Second, qps, "", "http://localhost", nil); err == nil {
+			t.Errorf("Expected an error for %d qps", qps)
+		}
+	}
+	if _, err := NewReplayer(&http.Client{}, time.Second, int(time.Second), "", "http://localhost", nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDiffJSONMatchesIDs(t *testing.T) {
+	bid := func(id string, price float64) interface{} {
+		return map[string]interface{}{"bid_id": id, "price": price}
+	}
+	baseline := map[string]interface{}{"bids": []interface{}{bid("a", 1), bid("b", 2)}}
+	if diff := diffJSON("$", baseline, map[string]interface{}{"bids": []interface{}{bid("b", 2), bid("a", 1)}}, nil); diff != "" {
+		t.Errorf("Reordered bids shouldn't differ. Got %s", diff)
+	}
+	if diff := diffJSON("$", baseline, map[string]interface{}{"bids": []interface{}{bid("b", 3), bid("a", 1)}}, nil); diff != "$.bids[bid_id=b].price: baseline 2, candidate 3" {
+		t.Errorf("Bad diff: %s", diff)
+	}
+	if diff := diffJSON("$", baseline, map[string]interface{}{"bids": []interface{}{bid("a", 1)}}, nil); diff != "$.bids[bid_id=b]: baseline {\"bid_id\":\"b\",\"price\":2}, candidate missing" {
+		t.Errorf("Bad diff: %s", diff)
+	}
+	if diff := diffJSON("$", baseline, map[string]interface{}{"bids": []interface{}{bid("a", 1), bid("b", 2), bid("c", 3)}}, nil); diff != "$.bids[bid_id=c]: baseline missing, candidate {\"bid_id\":\"c\",\"price\":3}" {
+		t.Errorf("Bad diff: %s", diff)
+	}
+}
@@ -8,36 +8,568 @@ import (
 
 // Configuration
 type Configuration struct {
-	ExternalURL     string             `mapstructure:"external_url"`
-	Host            string             `mapstructure:"host"`
-	Port            int                `mapstructure:"port"`
-	AdminPort       int                `mapstructure:"admin_port"`
-	DefaultTimeout  uint64             `mapstructure:"default_timeout_ms"`
-	CacheURL        Cache              `mapstructure:"cache"`
-	RecaptchaSecret string             `mapstructure:"recaptcha_secret"`
-	HostCookie      HostCookie         `mapstructure:"host_cookie"`
-	Metrics         Metrics            `mapstructure:"metrics"`
-	DataCache       DataCache          `mapstructure:"datacache"`
-	Adapters        map[string]Adapter `mapstructure:"adapters"`
+	ExternalURL string `mapstructure:"external_url"`
+	Host        string `mapstructure:"host"`
+	Port        int    `mapstructure:"port"`
+	AdminPort   int    `mapstructure:"admin_port"`
+	// AdminToken must be sent as a bearer token to the admin endpoints which change the server's state,
+	// like the cache endpoints. Those endpoints aren't served if it's empty.
+	AdminToken         string                      `mapstructure:"admin_token"`
+	DefaultTimeout     uint64                      `mapstructure:"default_timeout_ms"`
+	LateBidGrace       uint64                      `mapstructure:"late_bid_grace_ms"` // 0 disables late bid capture
+	CacheURL           Cache                       `mapstructure:"cache"`
+	RecaptchaSecret    string                      `mapstructure:"recaptcha_secret"`
+	HostCookie         HostCookie                  `mapstructure:"host_cookie"`
+	Metrics            Metrics                     `mapstructure:"metrics"`
+	DataCache          DataCache                   `mapstructure:"datacache"`
+	Adapters           map[string]Adapter          `mapstructure:"adapters"`
+	Accounts           map[string]Account          `mapstructure:"accounts"`
+	DChain             DChain                      `mapstructure:"dchain"`
+	SChain             SChain                      `mapstructure:"schain"`
+	Analytics          Analytics                   `mapstructure:"analytics"`
+	Experiments        map[string]Experiment       `mapstructure:"experiments"`
+	BidderThrottles    map[string]Throttle         `mapstructure:"bidder_throttles"`
+	Quarantine         Quarantine                  `mapstructure:"bad_response_quarantine"`
+	BidderMacros       map[string]BidderMacros     `mapstructure:"bidder_macros"`
+	CurrencyConverter  CurrencyConverter           `mapstructure:"currency_converter"`
+	BidderValidations  map[string]BidderValidation `mapstructure:"bidder_validations"`
+	DebugBidder        DebugBidder                 `mapstructure:"debug_bidder"`
+	NoBidCache         NoBidCache                  `mapstructure:"no_bid_cache"`
+	IdentityResolution IdentityResolution          `mapstructure:"identity_resolution"`
+	CookieSync         CookieSync                  `mapstructure:"cookie_sync"`
+	Monitoring         Monitoring                  `mapstructure:"monitoring"`
+	IVTFilter          IVTFilter                   `mapstructure:"ivt_filter"`
+	Blocklist          Blocklist                   `mapstructure:"blocklist"`
+	GDPR               GDPR                        `mapstructure:"gdpr"`
+	DomainCheck        DomainCheck                 `mapstructure:"domain_check"`
+	Mirror             Mirror                      `mapstructure:"mirror"`
+	TieBreak           TieBreak                    `mapstructure:"tie_break"`
+	BidderExt          BidderExt                   `mapstructure:"bidder_ext"`
+	// DebugOverrideToken lets support engineers turn on debug output for a single request, even if the
+	// account disabled it, by sending the token in the x-pbs-debug-override header. It's off if empty.
+	DebugOverrideToken string `mapstructure:"debug_override_token"`
+}
+
+// BidderExt limits the bid.ext which bidders can get into /openrtb2 responses, in each bid's ext.bidder.
+// Some exchanges send large debugging payloads there, which only bloat the response.
+type BidderExt struct {
+	// MaxBytes drops a bid's ext.bidder if it's bigger than this, after any keys are removed. Zero means "no limit".
+	MaxBytes int `mapstructure:"max_bytes"`
+	// DisallowedKeys are removed from every ext.bidder, even if an account allows them.
+	DisallowedKeys []string `mapstructure:"disallowed_keys"`
+}
+
+// TieBreak decides which bid wins an ad unit when its top bids have the same price.
+type TieBreak struct {
+	// Mode is "earliest_response" (the default), "random", "deal_first" or "bidder_priority".
+	// Random tie breaks are seeded by the request in test mode, so that test auctions are repeatable.
+	Mode string `mapstructure:"mode"`
+	// BidderPriority lists bidder codes, most preferred first, for the "bidder_priority" mode.
+	BidderPriority []string `mapstructure:"bidder_priority"`
+}
+
+// DomainCheck looks for web requests whose page URL is spoofed, so that they don't get bids through the host's seat.
+// For accounts with domains, the page and the request's Origin header must both be on one of them.
+type DomainCheck struct {
+	// Mode is "flag", which only counts mismatches in the domain_check metrics, or "reject", which fails those
+	// auctions. The check is disabled if this is empty.
+	Mode string `mapstructure:"mode"`
+}
+
+// IVTFilter screens out requests from known datacenters and bad bots before any bidders are called.
+type IVTFilter struct {
+	// Mode is "flag", which only counts the suspicious requests in the invalid_traffic metrics, or "reject",
+	// which doesn't send them to any bidders. The filter is disabled if this is empty.
+	Mode string `mapstructure:"mode"`
+	// DatacenterRanges are CIDRs or single IPs.
+	DatacenterRanges []string `mapstructure:"datacenter_ranges"`
+	// BotUserAgents are case-insensitive substrings of bad bots' User-Agent headers.
+	BotUserAgents []string `mapstructure:"bot_user_agents"`
+	// ListsURL points at a JSON file with more lists, like {"datacenters": [...], "bot_user_agents": [...]}.
+	// It may be an http(s), s3:// or gs:// URL.
+	ListsURL             string `mapstructure:"lists_url"`
+	FetchIntervalSeconds int    `mapstructure:"fetch_interval_seconds"`
+}
+
+// Blocklist points at a JSON file with the app bundles and advertiser domains which are blocked for each
+// bidder and account, like {"bidders": {"appnexus": {"badv": [...]}}, "accounts": {"1001": {"bapp": [...]}}}.
+// They're sent to bidders as bapp and badv, and bids from blocked advertiser domains are rejected.
+// It may be an http(s), s3:// or gs:// URL. Nothing is blocked if URL is empty.
+type Blocklist struct {
+	URL                  string `mapstructure:"url"`
+	FetchIntervalSeconds int    `mapstructure:"fetch_interval_seconds"`
+}
+
+// GDPR controls the enforcement of the user's TCF2 consent, from user.ext.consent. Bidders which don't have
+// consent for every enforced purpose don't get the user's IDs or precise location.
+type GDPR struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DefaultValue is "1" if GDPR applies to requests which don't set regs.ext.gdpr, or "0" if it doesn't.
+	DefaultValue string `mapstructure:"default_value"`
+	// EnforcePurposes are the TCF2 purposes which bidders need consent or legitimate interest for,
+	// like 1 (store and access information on a device) and 2 (select basic ads).
+	EnforcePurposes []int `mapstructure:"enforce_purposes"`
+	// Purposes changes how the enforced purposes are checked, keyed like "purpose2".
+	Purposes map[string]GDPRPurpose `mapstructure:"purposes"`
+	// VendorList is where the IAB's Global Vendor List is fetched from. Bidders also need to have declared each
+	// purpose in the version of the list which the user consented with.
+	VendorList GVL `mapstructure:"vendor_list"`
+}
+
+// GDPRPurpose changes how one of the enforced purposes is checked.
+type GDPRPurpose struct {
+	// EnforceAlgo is "full" (the default), which needs the user's consent for both the purpose and the bidder,
+	// and the bidder's declaration in the vendor list. "basic" only needs the user's consent for the purpose.
+	EnforceAlgo string `mapstructure:"enforce_algo"`
+	// VendorExceptions are the bidder codes which don't need anything for the purpose.
+	VendorExceptions []string `mapstructure:"vendor_exceptions"`
+}
+
+// GVL configures the fetching of the Global Vendor List. It isn't checked if VersionURL is empty.
+type GVL struct {
+	// LatestURL is the current version of the list, which is refreshed every FetchIntervalSeconds.
+	LatestURL string `mapstructure:"latest_url"`
+	// VersionURL has a %d for the version number. Older versions are fetched from it when they're first needed.
+	VersionURL           string `mapstructure:"version_url"`
+	FetchIntervalSeconds int    `mapstructure:"fetch_interval_seconds"`
+	// OnFetchFailure is "allow" to check the consent string alone while the user's version of the list is
+	// unavailable, or "deny" to treat every bidder as unconsented until it's fetched.
+	OnFetchFailure string `mapstructure:"on_fetch_failure"`
+}
+
+// Mirror sends a sample of /auction requests to another server, like a staging cluster. The user object,
+// cookies and the device's IPs and advertising IDs are removed first. Mirroring is off if URL is empty.
+type Mirror struct {
+	URL string `mapstructure:"url"`
+	// SampleRate is the fraction (0 to 1) of requests which are mirrored.
+	SampleRate    float64 `mapstructure:"sample_rate"`
+	TimeoutMillis int     `mapstructure:"timeout_ms"`
+	// QueueSize is the number of mirrored requests which can wait to be sent. More are dropped. Defaults to 100.
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// Monitoring enables the admin /monitoring/auction endpoint, which runs a canned auction against the
+// debug bidder. The debug bidder must be enabled too.
+type Monitoring struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	AccountID string `mapstructure:"account_id"`
+	// ConfigID is a stored config for the canned ad unit. It should only list the debug bidder.
+	// If it's empty, the ad unit asks the debug bidder directly.
+	ConfigID      string `mapstructure:"config_id"`
+	TimeoutMillis int64  `mapstructure:"timeout_ms"`
+}
+
+// CookieSync decides which bidders /cookie_sync returns first, since a limit can stop them all from syncing.
+type CookieSync struct {
+	// CountryHeader names the request header with the user's ISO country code, like the one a CDN or GeoIP proxy sets.
+	CountryHeader string `mapstructure:"country_header"`
+	// PriorityGroups orders the bidders. Bidders in earlier groups are synced first, and unlisted ones go last.
+	PriorityGroups [][]string `mapstructure:"priority_groups"`
+	// Regions replace the PriorityGroups for users in their countries. Each country should be in one region at most.
+	Regions map[string]CookieSyncRegion `mapstructure:"regions"`
+}
+
+type CookieSyncRegion struct {
+	Countries      []string   `mapstructure:"countries"`
+	PriorityGroups [][]string `mapstructure:"priority_groups"`
+}
+
+// PriorityGroupsFor returns the priority groups for users in the given country.
+func (cfg *CookieSync) PriorityGroupsFor(country string) [][]string {
+	if country != "" {
+		for _, region := range cfg.Regions {
+			for _, regionCountry := range region.Countries {
+				if strings.EqualFold(regionCountry, country) {
+					return region.PriorityGroups
+				}
+			}
+		}
+	}
+	return cfg.PriorityGroups
+}
+
+// IdentityResolution configures the host's service for turning identity envelopes into EIDs.
+// It's disabled unless an Endpoint is given.
+type IdentityResolution struct {
+	Endpoint string `mapstructure:"endpoint"`
+	// TimeoutMillis bounds each call to the service, so slow lookups can't eat into the bidders' time.
+	TimeoutMillis int `mapstructure:"timeout_ms"`
+	// CacheTTLSeconds is how long resolved EIDs are reused. They're not cached if this is zero.
+	CacheTTLSeconds int `mapstructure:"cache_ttl_seconds"`
+}
+
+// NoBidCache remembers when a bidder declines to bid on a placement, so refreshes of that placement
+// skip the bidder for a while. It's disabled unless TTLSeconds is positive.
+type NoBidCache struct {
+	TTLSeconds int `mapstructure:"ttl_seconds"`
+	// DisabledBidders lists the bidders whose no-bids are never cached, because their demand is time-sensitive.
+	DisabledBidders []string `mapstructure:"disabled_bidders"`
+}
+
+// DebugBidder configures the built-in "debug" bidder, which returns fake bids for testing.
+// It's only available if Enabled, since its bids aren't backed by real demand.
+type DebugBidder struct {
+	Enabled bool    `mapstructure:"enabled"`
+	Price   float64 `mapstructure:"price"`
+	// MediaTypes can include "banner" and "video". If empty, the debug bidder only bids on banners.
+	MediaTypes []string `mapstructure:"media_types"`
 }
 
 type HostCookie struct {
-	Domain     string `mapstructure:"domain"`
-	Family     string `mapstructure:"family"`
-	CookieName string `mapstructure:"cookie_name"`
-	OptOutURL  string `mapstructure:"opt_out_url"`
-	OptInURL   string `mapstructure:"opt_in_url"`
+	Domain       string `mapstructure:"domain"`
+	Family       string `mapstructure:"family"`
+	CookieName   string `mapstructure:"cookie_name"`
+	OptOutURL    string `mapstructure:"opt_out_url"`
+	OptInURL     string `mapstructure:"opt_in_url"`
+	OptOutCookie Cookie `mapstructure:"optout_cookie"`
+	// TTLDays is how long the uids cookie lasts. If zero, it lasts 180 days.
+	TTLDays int `mapstructure:"ttl_days"`
+	// JavaCompatible writes the uids cookie in Prebid Server Java's format, so that Go and Java servers
+	// can run behind the same domain.
+	JavaCompatible bool `mapstructure:"java_compatible"`
+	// UIDs decides how long the bidders' IDs in the uids cookie are used before they're refreshed or dropped.
+	UIDs UIDPolicies `mapstructure:"uids"`
+}
+
+// UIDPolicies sets the UIDPolicy for every bidder family, with overrides for some.
+type UIDPolicies struct {
+	Default UIDPolicy `mapstructure:"default"`
+	// Families overrides the default for some bidder families, keyed by family name. Zero fields use the default's.
+	Families map[string]UIDPolicy `mapstructure:"families"`
+}
+
+// UIDPolicy keeps stale IDs from piling up in the uids cookie until it overflows.
+type UIDPolicy struct {
+	// RefreshDays is how long after a sync the ID gets synced again. If zero, it's 14 days.
+	RefreshDays int `mapstructure:"refresh_days"`
+	// MaxAgeDays is how long after a sync the ID is dropped from the cookie, if it hasn't been synced again.
+	// If zero, IDs are kept until they're replaced.
+	MaxAgeDays int `mapstructure:"max_age_days"`
+}
+
+// Cookie identifies a cookie which the host sets on its own domain.
+// If Value is empty, then the cookie matches no matter what its value is.
+type Cookie struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
 }
 
 type Adapter struct {
-	Endpoint    string `mapstructure:"endpoint"` // Required
-	UserSyncURL string `mapstructure:"usersync_url"`
-	PlatformID  string `mapstructure:"platform_id"` // needed for Facebook
-	XAPI        struct {
+	Endpoint string `mapstructure:"endpoint"` // Required
+	// SecondaryEndpoint takes over if the Endpoint keeps failing to connect.
+	SecondaryEndpoint string `mapstructure:"secondary_endpoint"`
+	UserSyncURL       string `mapstructure:"usersync_url"`
+	PlatformID        string `mapstructure:"platform_id"` // needed for Facebook
+	XAPI              struct {
 		Username string `mapstructure:"username"`
 		Password string `mapstructure:"password"`
 		Tracker  string `mapstructure:"tracker"`
 	} `mapstructure:"xapi"` // needed for Rubicon
+	// BatchImps lets ad units with identical params share one request, if the adapter supports multi-imp requests.
+	BatchImps bool `mapstructure:"batch_imps"`
+	// Shadow bidders get real requests, but their bids never compete in the auction. They're only
+	// recorded by the bidder's metrics and analytics, so that new integrations can be evaluated safely.
+	// Responses don't wait for them.
+	Shadow bool `mapstructure:"shadow"`
+	// ForwardTest passes test=1 on to the bidder for test auctions. Some bidders send test traffic to a
+	// sandbox, but others would bill it, so it's off by default.
+	ForwardTest bool `mapstructure:"forward_test"`
+	// AdSystemDomain and SellersJSONURL are used by the admin /authorization endpoint. The domain is the one which
+	// publishers list for the bidder in their ads.txt, and the bidder's sellers.json should list the host.
+	AdSystemDomain string `mapstructure:"ad_system_domain"`
+	SellersJSONURL string `mapstructure:"sellers_json_url"`
+	// EIDs limits the extended IDs in user.ext.eids, for bidders whose endpoints reject large user objects.
+	EIDs EIDLimits `mapstructure:"eids"`
+	// StripFields lists request fields which the bidder must not get, as dotted paths like "user.geo" or
+	// "site.keywords". They're removed from its copy of the request, to meet data-minimization agreements.
+	StripFields []string `mapstructure:"strip_fields"`
+	// AliasOf makes this bidder an alias of another, like appnexus2 for appnexus. Aliases use the other
+	// bidder's adapter, but have their own code in targeting keys, metrics and cookie syncs, and their own settings.
+	AliasOf string `mapstructure:"alias_of"`
+}
+
+// EIDLimits caps the extended IDs which a bidder gets. Zero means no limit.
+type EIDLimits struct {
+	MaxSources int `mapstructure:"max_sources"`
+	MaxBytes   int `mapstructure:"max_bytes"`
+	// Priority lists the EID sources which are kept first, like "liveramp.com".
+	Priority []string `mapstructure:"priority"`
+}
+
+// Account holds host-side rules which apply to a single publisher account.
+// Accounts which aren't listed in the config get the zero value.
+type Account struct {
+	// RequireAdvertiserDomains drops any bids which don't declare their advertiser domains in meta.
+	RequireAdvertiserDomains bool `mapstructure:"require_adomain"`
+	// EarlyReturn lets latency-sensitive accounts trade some price discovery for a faster response.
+	EarlyReturn EarlyReturn `mapstructure:"early_return"`
+	// CacheHost and CachePath replace the host's Prebid Cache location in this account's cache URLs and
+	// hb_cache_host/hb_cache_path targeting. Some publishers front the cache with their own domain.
+	CacheHost string `mapstructure:"cache_host"`
+	CachePath string `mapstructure:"cache_path"`
+	// MaxTargetingKeys caps the number of targeting keys for each ad unit. Requests can lower it
+	// with ext.prebid.targeting.maxkeys, but not raise it. Zero means no limit.
+	MaxTargetingKeys int `mapstructure:"max_targeting_keys"`
+	// SChain replaces the host's default supply chain node for this account, if it's enabled.
+	// This is usually needed for the sid, which identifies the publisher's seller account.
+	SChain SChain `mapstructure:"schain"`
+	// CacheTTLSeconds replaces the host's cache.default_ttl_seconds for this account.
+	CacheTTLSeconds int64 `mapstructure:"cache_ttl_seconds"`
+	// Domains are the sites which the account sells. Subdomains are included. If it's empty, any domain is allowed.
+	Domains []string `mapstructure:"domains"`
+	// Apps fill in what the account's mobile apps leave out of their requests.
+	Apps []AppDefaults `mapstructure:"apps"`
+	// DisableDebug ignores debug requests for this account, since debug output exposes what bidders were sent.
+	DisableDebug bool `mapstructure:"disable_debug"`
+	// DisabledChannels are the integrations, like "amp" or "app", which the account doesn't accept requests from.
+	DisabledChannels []string `mapstructure:"disabled_channels"`
+	// Events sends the account's tracking beacons to its own collectors.
+	Events AccountEvents `mapstructure:"events"`
+	// MinWinningCPM is the lowest USD price which may win one of the account's ad units. It's checked after the
+	// auction, unlike the floors which bidders get, and ad units whose top bid is below it get no bids.
+	MinWinningCPM float64 `mapstructure:"min_winning_cpm"`
+	// BidderExtKeys are the only keys of the bidders' bid.ext which get into the account's /openrtb2 responses,
+	// if it's set. See BidderExt for the host's limits.
+	BidderExtKeys []string `mapstructure:"bidder_ext_keys"`
+	// Activities allows or denies what bidders may do with the account's users, keyed by activity name.
+	// See the Activity constants. Activities which aren't listed are allowed.
+	Activities map[string]Activity `mapstructure:"activities"`
+}
+
+// These are the activities which an account can control.
+const (
+	// ActivityFetchBids is calling a bidder in the account's auctions.
+	ActivityFetchBids = "fetch_bids"
+	// ActivitySyncUser is syncing the user's ID with a bidder family, through /cookie_sync or /setuid.
+	ActivitySyncUser = "sync_user"
+	// ActivityTransmitUFPD is sending the user's IDs, EIDs and the device's advertising IDs to a bidder.
+	ActivityTransmitUFPD = "transmit_ufpd"
+	// ActivityTransmitPreciseGeo is sending the user's precise location and full IPs to a bidder.
+	ActivityTransmitPreciseGeo = "transmit_precise_geo"
+)
+
+// Activity decides whether an activity is allowed. The first rule whose condition matches wins. If none do,
+// Default decides.
+type Activity struct {
+	// Default is "allow" or "deny". If it's empty, the activity is allowed.
+	Default string         `mapstructure:"default"`
+	Rules   []ActivityRule `mapstructure:"rules"`
+}
+
+// ActivityRule allows or denies the activity if its condition matches.
+type ActivityRule struct {
+	Condition ActivityCondition `mapstructure:"condition"`
+	Allow     bool              `mapstructure:"allow"`
+}
+
+// ActivityCondition matches if every one of its lists which isn't empty matches. An empty condition matches
+// everything.
+type ActivityCondition struct {
+	// ComponentNames are bidder codes, or usersync family names.
+	ComponentNames []string `mapstructure:"component_names"`
+	// Channels are the integrations, like "pbjs" or "app", which the request came from. Usersyncs have no
+	// channel, so rules with channels never match them.
+	Channels []string `mapstructure:"channels"`
+}
+
+// ActivityAllowed returns true if the account lets the component do the activity on the channel. The components
+// are the names which the component may be known by, like a bidder's code and its usersync family.
+func (account Account) ActivityAllowed(activity string, channel string, components ...string) bool {
+	controls, ok := account.Activities[activity]
+	if !ok {
+		return true
+	}
+	for _, rule := range controls.Rules {
+		if rule.Condition.matches(channel, components) {
+			return rule.Allow
+		}
+	}
+	return !strings.EqualFold(controls.Default, "deny")
+}
+
+func (condition ActivityCondition) matches(channel string, components []string) bool {
+	if len(condition.Channels) > 0 && !containsFold(condition.Channels, channel) {
+		return false
+	}
+	if len(condition.ComponentNames) == 0 {
+		return true
+	}
+	for _, component := range components {
+		if containsFold(condition.ComponentNames, component) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if value != "" && strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountEvents are tracker URLs which get added to the markup of each bid in the account's responses.
+// They may use the {{account}}, {{bidder}} and {{timestamp}} macros.
+type AccountEvents struct {
+	// Enabled also counts the account's impressions at Prebid Server's own /event endpoint.
+	Enabled bool `mapstructure:"enabled"`
+	// ImpressionURL is the account's event collector. It's called when an ad renders.
+	ImpressionURL string `mapstructure:"impression_url"`
+	// AnalyticsURL is called at the same time, for accounts which collect analytics somewhere else.
+	AnalyticsURL string `mapstructure:"analytics_url"`
+}
+
+// TrackerURLs returns the account's tracker URLs which are set.
+func (events AccountEvents) TrackerURLs() []string {
+	var urls []string
+	for _, url := range []string{events.ImpressionURL, events.AnalyticsURL} {
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// ChannelEnabled returns false if the account doesn't accept requests from the channel.
+func (account Account) ChannelEnabled(channel string) bool {
+	for _, disabled := range account.DisabledChannels {
+		if strings.EqualFold(disabled, channel) {
+			return false
+		}
+	}
+	return true
+}
+
+// AppDefaults are the server-side settings for one of an account's apps.
+// They're a list rather than a map because viper would split bundle IDs on their dots.
+type AppDefaults struct {
+	// Bundle is the app's bundle ID, as sent in app.bundle.
+	Bundle string `mapstructure:"bundle"`
+	// ConfigID is the stored config for ad units which list no bidders and no config_id.
+	ConfigID string `mapstructure:"config_id"`
+}
+
+// EarlyReturn makes the auction respond as soon as every ad unit has a bid of at least MinCPM,
+// rather than waiting for the slowest bidders. Bids which arrive later are dropped.
+type EarlyReturn struct {
+	Enabled bool    `mapstructure:"enabled"`
+	MinCPM  float64 `mapstructure:"min_cpm"`
+}
+
+// DChain describes the node which this host adds to the demand chain of every winning bid.
+type DChain struct {
+	Enabled bool   `mapstructure:"enabled"`
+	ASI     string `mapstructure:"asi"`
+	Name    string `mapstructure:"name"`
+	Domain  string `mapstructure:"domain"`
+}
+
+// SChain describes the node which this host puts in the supply chain of requests which arrive without one.
+// Exchanges which require a supply chain would otherwise treat this server's traffic as unauthorized.
+type SChain struct {
+	Enabled bool   `mapstructure:"enabled"`
+	ASI     string `mapstructure:"asi"`
+	SID     string `mapstructure:"sid"`
+	Name    string `mapstructure:"name"`
+	Domain  string `mapstructure:"domain"`
+}
+
+// Analytics configures the modules which get notified about every auction.
+type Analytics struct {
+	File FileLogs `mapstructure:"file"`
+	// BidLandscapeSampleRate is the fraction (0 to 1) of auctions which log every bid, not just the ones in the response.
+	BidLandscapeSampleRate float64 `mapstructure:"bid_landscape_sample_rate"`
+}
+
+// FileLogs enables the filesystem analytics module, which logs each auction to Filename.
+type FileLogs struct {
+	Filename string `mapstructure:"filename"`
+	// Format is "json" (the default) or "protobuf". Protobuf is much cheaper to write at high QPS.
+	Format string `mapstructure:"format"`
+	// Gzip compresses the file in batches. It implies a BatchSize of 100, if none is set.
+	Gzip bool `mapstructure:"gzip"`
+	// BatchSize is the number of auctions which get buffered before being written.
+	BatchSize int `mapstructure:"batch_size"`
+}
+
+// Experiment splits traffic into buckets, each of which changes how the auction runs.
+// Traffic which doesn't fall into any bucket is left alone, and serves as the control group.
+type Experiment struct {
+	// HashBy is "request" (the default) or "user".
+	HashBy  string             `mapstructure:"hash_by"`
+	Buckets []ExperimentBucket `mapstructure:"buckets"`
+}
+
+type ExperimentBucket struct {
+	Name    string `mapstructure:"name"`
+	Percent int    `mapstructure:"percent"`
+	// TimeoutMillis overrides the request timeout, if set.
+	TimeoutMillis int64 `mapstructure:"timeout_ms"`
+	// Bidders limits the auction to these bidders, if set.
+	Bidders []string `mapstructure:"bidders"`
+	// PriceGranularity overrides the account's price granularity, if set.
+	PriceGranularity string `mapstructure:"price_granularity"`
+	// FloorStrategy is "request" (the default), which sends bidders the request's floors, or "none", which sends none.
+	FloorStrategy string `mapstructure:"floor_strategy"`
+}
+
+// Throttle limits the traffic sent to a bidder, so that new partners can be ramped up gradually.
+type Throttle struct {
+	// Percent is the percentage of auctions which the bidder takes part in, from 0 to 100. If it's
+	// missing, every auction is.
+	Percent *int `mapstructure:"percent"`
+	// MaxQPS is the most requests per second which will be sent to the bidder. Zero means "no limit".
+	MaxQPS int `mapstructure:"max_qps"`
+}
+
+// Quarantine configures where unparseable bidder responses get logged.
+// It's disabled unless a Filename is given.
+type Quarantine struct {
+	Filename string `mapstructure:"filename"`
+	// SampleRate is the fraction (0 to 1) of bad responses which get logged.
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// MaxBytes limits the size of each logged payload.
+	MaxBytes int `mapstructure:"max_bytes"`
+}
+
+// BidderMacros controls how the OpenRTB macros in a bidder's creatives are resolved.
+// The map keys are lowercase bidder codes.
+type BidderMacros struct {
+	// Enabled makes the server resolve ${AUCTION_PRICE}, ${AUCTION_ID} and ${AUCTION_BID_ID} in the
+	// bidder's adm, nurl and burl, for bidders which rely on the exchange to do so.
+	Enabled bool `mapstructure:"enabled"`
+	// PriceEncryption encrypts ${AUCTION_PRICE} in the bidder's nurl and burl, so that clear-text
+	// prices aren't exposed in pixels. It's disabled unless a Scheme is given.
+	PriceEncryption PriceEncryption `mapstructure:"price_encryption"`
+}
+
+// PriceEncryption configures a shared-key price encryption scheme.
+type PriceEncryption struct {
+	// Scheme is "hmac-sha1" for the DoubleClick-style scheme, or "aes-gcm".
+	Scheme string `mapstructure:"scheme"`
+	// EncryptionKey and IntegrityKey are web-safe base64 encoded. The aes-gcm scheme only uses the EncryptionKey.
+	EncryptionKey string `mapstructure:"encryption_key"`
+	IntegrityKey  string `mapstructure:"integrity_key"`
+}
+
+// CurrencyConverter configures the currency rates used to convert bids into USD.
+type CurrencyConverter struct {
+	// FetchURL points at a rates file in the format used by Prebid.js. It may be an http(s), s3:// or gs:// URL.
+	// Currency conversion is disabled if this is empty.
+	FetchURL             string `mapstructure:"fetch_url"`
+	FetchIntervalSeconds int    `mapstructure:"fetch_interval_seconds"`
+	// StaleRatesSeconds is the age after which rates are no longer trusted for conversions. 0 means never.
+	StaleRatesSeconds int `mapstructure:"stale_rates_seconds"`
+}
+
+// BidderValidation holds the checks which are applied to a bidder's bids. The map keys are lowercase bidder codes.
+type BidderValidation struct {
+	// Currencies lists the currencies which the bidder may bid in, and bids in any other currency are rejected.
+	// If empty, the bidder may bid in any currency. Either way, bids are converted into USD.
+	Currencies []string `mapstructure:"currencies"`
 }
 
 type Metrics struct {
@@ -56,12 +588,22 @@ type DataCache struct {
 	Password   string `mapstructure:"password"`
 	CacheSize  int    `mapstructure:"cache_size"`
 	TTLSeconds int    `mapstructure:"ttl_seconds"`
+	// PrewarmConfigIDs are stored ad unit configs which get loaded into the cache at startup.
+	// If PrewarmIntervalSeconds is set, they're reloaded that often, so they never expire.
+	PrewarmConfigIDs       []string `mapstructure:"prewarm_config_ids"`
+	PrewarmIntervalSeconds int      `mapstructure:"prewarm_interval_seconds"`
 }
 
 type Cache struct {
 	Scheme string `mapstructure:"scheme"`
 	Host   string `mapstructure:"host"`
 	Query  string `mapstructure:"query"`
+	// DefaultTTLSeconds is how long creatives are cached for, if the bidder didn't send a bid.exp.
+	// If zero, Prebid Cache uses its own default.
+	DefaultTTLSeconds int64 `mapstructure:"default_ttl_seconds"`
+	// RetryTimeoutMs is how long a retry of a failed put may take. Puts which get a 5xx are retried once,
+	// if the auction has time left. Zero turns retries off.
+	RetryTimeoutMs int `mapstructure:"retry_timeout_ms"`
 }
 
 // New uses viper to get our server configurations
@@ -88,3 +630,65 @@ func (cfg *Configuration) GetCacheBaseURL() string {
 func (cfg *Configuration) GetCachedAssetURL(uuid string) string {
 	return fmt.Sprintf("%s/cache?%s", cfg.GetCacheBaseURL(), strings.Replace(cfg.CacheURL.Query, "%PBS_CACHE_UUID%", uuid, 1))
 }
+
+// GetCacheHostAndPath returns where the account's cached creatives are served from.
+func (cfg *Configuration) GetCacheHostAndPath(accountID string) (string, string) {
+	host, path := cfg.CacheURL.Host, "/cache"
+	account := cfg.GetAccount(accountID)
+	if account.CacheHost != "" {
+		host = account.CacheHost
+	}
+	if account.CachePath != "" {
+		path = account.CachePath
+	}
+	return host, path
+}
+
+// GetAccountCachedAssetURL is like GetCachedAssetURL, but uses the account's cache host and path.
+func (cfg *Configuration) GetAccountCachedAssetURL(accountID string, uuid string) string {
+	host, path := cfg.GetCacheHostAndPath(accountID)
+	if host == cfg.CacheURL.Host && path == "/cache" {
+		return cfg.GetCachedAssetURL(uuid)
+	}
+	scheme := strings.TrimSuffix(cfg.GetCacheBaseURL(), cfg.CacheURL.Host)
+	return fmt.Sprintf("%s%s%s?%s", scheme, host, path, strings.Replace(cfg.CacheURL.Query, "%PBS_CACHE_UUID%", uuid, 1))
+}
+
+// GetSChain returns the default supply chain node for the account's requests.
+func (cfg *Configuration) GetSChain(accountID string) SChain {
+	if account := cfg.GetAccount(accountID); account.SChain.Enabled {
+		return account.SChain
+	}
+	return cfg.SChain
+}
+
+// GetCacheTTL returns how many seconds a bid should be cached for. The bidder's exp wins,
+// then the account's TTL, and then the host's.
+func (cfg *Configuration) GetCacheTTL(accountID string, exp int64) int64 {
+	if exp > 0 {
+		return exp
+	}
+	if ttl := cfg.GetAccount(accountID).CacheTTLSeconds; ttl > 0 {
+		return ttl
+	}
+	return cfg.CacheURL.DefaultTTLSeconds
+}
+
+// GetAppDefaults returns the account's settings for the app with the given bundle ID.
+func (cfg *Configuration) GetAppDefaults(accountID string, bundle string) AppDefaults {
+	if bundle == "" {
+		return AppDefaults{}
+	}
+	for _, app := range cfg.GetAccount(accountID).Apps {
+		if app.Bundle == bundle {
+			return app
+		}
+	}
+	return AppDefaults{}
+}
+
+// GetAccount returns the settings for the given account ID.
+// Viper lowercases all map keys, so the lookup is case insensitive.
+func (cfg *Configuration) GetAccount(id string) Account {
+	return cfg.Accounts[strings.ToLower(id)]
+}
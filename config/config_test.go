@@ -61,15 +61,36 @@ host_cookie:
   domain: cookies.prebid.org
   opt_out_url: http://prebid.org/optout
   opt_in_url: http://prebid.org/optin
+  ttl_days: 90
+  java_compatible: true
+  uids:
+    default:
+      refresh_days: 7
+      max_age_days: 30
+    families:
+      adnxs:
+        max_age_days: 60
+  optout_cookie:
+    name: trp_optout
+    value: "true"
 external_url: http://prebid-server.prebid.org/
 host: prebid-server.prebid.org
 port: 1234
 admin_port: 5678
+admin_token: secret
+debug_override_token: support-secret
 default_timeout_ms: 123
+late_bid_grace_ms: 200
+debug_bidder:
+  enabled: true
+  price: 1.25
+  media_types: ["banner", "video"]
 cache:
   scheme: http
   host: prebidcache.net
   query: uuid=%PBS_CACHE_UUID%
+  default_ttl_seconds: 300
+  retry_timeout_ms: 40
 recaptcha_secret: asdfasdfasdfasdf
 metrics:
   host: upstream:8232
@@ -85,11 +106,14 @@ datacache:
   password: db2342
   cache_size: 10000000
   ttl_seconds: 3600
+  prewarm_config_ids: ["amp-top", "amp-bottom"]
+  prewarm_interval_seconds: 1800
 adapters:
   indexExchange:
     endpoint: http://ixtest.com/api
   rubicon:
     endpoint: http://rubitest.com/api
+    secondary_endpoint: http://rubitest2.com/api
     usersync_url: http://pixel.rubiconproject.com/sync.php?p=prebid
     xapi:
       username: rubiuser
@@ -98,6 +122,164 @@ adapters:
     endpoint: http://facebook.com/pbs
     usersync_url: http://facebook.com/ortb/prebid-s2s
     platform_id: abcdefgh1234
+  lifestreet:
+    batch_imps: true
+    shadow: true
+    forward_test: true
+    ad_system_domain: lifestreet.com
+    sellers_json_url: https://lifestreet.com/sellers.json
+    eids:
+      max_sources: 3
+      max_bytes: 2048
+      priority:
+        - liveramp.com
+    strip_fields:
+      - user.geo
+      - site.keywords
+  appnexus2:
+    alias_of: appnexus
+    shadow: true
+accounts:
+  "1001":
+    require_adomain: true
+    min_winning_cpm: 0.05
+    early_return:
+      enabled: true
+      min_cpm: 0.5
+    cache_host: cache.publisher.com
+    max_targeting_keys: 20
+    bidder_ext_keys: ["dealpriority"]
+    cache_ttl_seconds: 600
+    domains:
+      - publisher.com
+    schain:
+      enabled: true
+      asi: pbshost.com
+      sid: pub-1001
+    disabled_channels:
+      - amp
+    disable_debug: true
+    apps:
+      - bundle: com.Publisher.App
+        config_id: app-defaults
+    events:
+      enabled: true
+      impression_url: https://events.publisher.com/imp?a={{account}}&b={{bidder}}&t={{timestamp}}
+      analytics_url: https://analytics.publisher.com/pixel
+    activities:
+      sync_user:
+        default: deny
+        rules:
+          - condition:
+              component_names:
+                - adnxs
+            allow: true
+      transmit_ufpd:
+        rules:
+          - condition:
+              component_names:
+                - appnexus
+              channels:
+                - app
+            allow: false
+domain_check:
+  mode: flag
+tie_break:
+  mode: bidder_priority
+  bidder_priority:
+    - rubicon
+    - appnexus
+bidder_ext:
+  max_bytes: 2048
+  disallowed_keys: ["debug"]
+mirror:
+  url: http://staging.example.com/auction
+  sample_rate: 0.01
+  timeout_ms: 500
+ivt_filter:
+  mode: reject
+  datacenter_ranges:
+    - 192.0.2.0/24
+  bot_user_agents:
+    - HeadlessChrome
+  lists_url: https://lists.example.com/ivt.json
+blocklist:
+  url: https://lists.example.com/blocklist.json
+  fetch_interval_seconds: 300
+gdpr:
+  enabled: true
+  default_value: "1"
+  enforce_purposes:
+    - 1
+    - 2
+    - 7
+  purposes:
+    purpose2:
+      enforce_algo: basic
+      vendor_exceptions:
+        - appnexus
+  vendor_list:
+    version_url: https://gvl.example.com/vendor-list-v%d.json
+    on_fetch_failure: deny
+schain:
+  enabled: true
+  asi: pbshost.com
+  sid: host-1
+analytics:
+  bid_landscape_sample_rate: 0.01
+  file:
+    filename: /var/log/pbs/auctions.log
+    format: protobuf
+    gzip: true
+experiments:
+  timeouts:
+    hash_by: user
+    buckets:
+      - name: short
+        percent: 10
+        timeout_ms: 150
+        bidders: ["appnexus", "rubicon"]
+        floor_strategy: none
+bidder_throttles:
+  pubmatic:
+    percent: 25
+    max_qps: 100
+monitoring:
+  enabled: true
+  account_id: synthetic
+  config_id: monitoring-unit
+  timeout_ms: 300
+cookie_sync:
+  country_header: CF-IPCountry
+  priority_groups: [["appnexus", "rubicon"], ["pubmatic"]]
+  regions:
+    eu:
+      countries: ["DE", "FR"]
+      priority_groups: [["pubmatic"]]
+identity_resolution:
+  endpoint: http://ids.prebid.org/resolve
+  timeout_ms: 20
+  cache_ttl_seconds: 300
+no_bid_cache:
+  ttl_seconds: 5
+  disabled_bidders: ["rubicon"]
+bad_response_quarantine:
+  filename: /var/log/pbs/quarantine.log
+  sample_rate: 0.05
+  max_bytes: 2048
+bidder_macros:
+  pulsepoint:
+    enabled: true
+    price_encryption:
+      scheme: hmac-sha1
+      encryption_key: ZW5jcnlwdGlvbg==
+      integrity_key: aW50ZWdyaXR5
+currency_converter:
+  fetch_url: https://cdn.jsdelivr.net/gh/prebid/currency-file@1/latest.json
+  fetch_interval_seconds: 600
+bidder_validations:
+  rubicon:
+    currencies: ["USD", "EUR"]
 `)
 
 func cmpStrings(t *testing.T, key string, a string, b string) {
@@ -123,17 +305,38 @@ func TestFullConfig(t *testing.T) {
 	cmpStrings(t, "cookie name", cfg.HostCookie.CookieName, "userid")
 	cmpStrings(t, "cookie family", cfg.HostCookie.Family, "prebid")
 	cmpStrings(t, "opt out", cfg.HostCookie.OptOutURL, "http://prebid.org/optout")
+	cmpInts(t, "host_cookie.ttl_days", cfg.HostCookie.TTLDays, 90)
+	if !cfg.HostCookie.JavaCompatible {
+		t.Errorf("host_cookie.java_compatible should be true")
+	}
+	cmpInts(t, "host_cookie.uids.default.refresh_days", cfg.HostCookie.UIDs.Default.RefreshDays, 7)
+	cmpInts(t, "host_cookie.uids.default.max_age_days", cfg.HostCookie.UIDs.Default.MaxAgeDays, 30)
+	cmpInts(t, "host_cookie.uids.families.adnxs.max_age_days", cfg.HostCookie.UIDs.Families["adnxs"].MaxAgeDays, 60)
 	cmpStrings(t, "opt in", cfg.HostCookie.OptInURL, "http://prebid.org/optin")
+	cmpStrings(t, "optout cookie name", cfg.HostCookie.OptOutCookie.Name, "trp_optout")
+	cmpStrings(t, "optout cookie value", cfg.HostCookie.OptOutCookie.Value, "true")
 	cmpStrings(t, "external url", cfg.ExternalURL, "http://prebid-server.prebid.org/")
 	cmpStrings(t, "host", cfg.Host, "prebid-server.prebid.org")
 	cmpInts(t, "port", cfg.Port, 1234)
 	cmpInts(t, "admin_port", cfg.AdminPort, 5678)
+	cmpStrings(t, "admin_token", cfg.AdminToken, "secret")
+	cmpStrings(t, "debug_override_token", cfg.DebugOverrideToken, "support-secret")
+	if !cfg.GetAccount("1001").DisableDebug {
+		t.Errorf("accounts.1001.disable_debug should be true")
+	}
 	if cfg.DefaultTimeout != 123 {
 		t.Errorf("DefaultTimeout was %d not 123", cfg.DefaultTimeout)
 	}
+	if cfg.LateBidGrace != 200 {
+		t.Errorf("LateBidGrace was %d not 200", cfg.LateBidGrace)
+	}
+	if !cfg.DebugBidder.Enabled || cfg.DebugBidder.Price != 1.25 || len(cfg.DebugBidder.MediaTypes) != 2 {
+		t.Errorf("Bad debug_bidder config: %#v", cfg.DebugBidder)
+	}
 	cmpStrings(t, "cache.scheme", cfg.CacheURL.Scheme, "http")
 	cmpStrings(t, "cache.host", cfg.CacheURL.Host, "prebidcache.net")
 	cmpStrings(t, "cache.query", cfg.CacheURL.Query, "uuid=%PBS_CACHE_UUID%")
+	cmpInts(t, "cache.retry_timeout_ms", cfg.CacheURL.RetryTimeoutMs, 40)
 	cmpStrings(t, "recaptcha_secret", cfg.RecaptchaSecret, "asdfasdfasdfasdf")
 	cmpStrings(t, "metrics.host", cfg.Metrics.Host, "upstream:8232")
 	cmpStrings(t, "metrics.database", cfg.Metrics.Database, "metricsdb")
@@ -147,15 +350,189 @@ func TestFullConfig(t *testing.T) {
 	cmpStrings(t, "datacache.password", cfg.DataCache.Password, "db2342")
 	cmpInts(t, "datacache.cache_size", cfg.DataCache.CacheSize, 10000000)
 	cmpInts(t, "datacache.ttl_seconds", cfg.DataCache.TTLSeconds, 3600)
+	if ids := cfg.DataCache.PrewarmConfigIDs; len(ids) != 2 || ids[0] != "amp-top" {
+		t.Errorf("datacache.prewarm_config_ids should be [amp-top amp-bottom]. Got %v", ids)
+	}
+	cmpInts(t, "datacache.prewarm_interval_seconds", cfg.DataCache.PrewarmIntervalSeconds, 1800)
 	cmpStrings(t, "", cfg.GetCacheBaseURL(), "http://prebidcache.net")
 	cmpStrings(t, "", cfg.GetCachedAssetURL("a0eebc99-9c0b-4ef8-bb00-6bb9bd380a11"), "http://prebidcache.net/cache?uuid=a0eebc99-9c0b-4ef8-bb00-6bb9bd380a11")
 	cmpStrings(t, "adapters.indexExchange.endpoint", cfg.Adapters["indexexchange"].Endpoint, "http://ixtest.com/api")
 	cmpStrings(t, "adapters.rubicon.endpoint", cfg.Adapters["rubicon"].Endpoint, "http://rubitest.com/api")
+	cmpStrings(t, "adapters.rubicon.secondary_endpoint", cfg.Adapters["rubicon"].SecondaryEndpoint, "http://rubitest2.com/api")
 	cmpStrings(t, "adapters.rubicon.usersync_url", cfg.Adapters["rubicon"].UserSyncURL, "http://pixel.rubiconproject.com/sync.php?p=prebid")
 	cmpStrings(t, "adapters.rubicon.xapi.username", cfg.Adapters["rubicon"].XAPI.Username, "rubiuser")
 	cmpStrings(t, "adapters.rubicon.xapi.password", cfg.Adapters["rubicon"].XAPI.Password, "rubipw23")
 	cmpStrings(t, "adapters.facebook.endpoint", cfg.Adapters["facebook"].Endpoint, "http://facebook.com/pbs")
+	if !cfg.Adapters["lifestreet"].BatchImps {
+		t.Errorf("adapters.lifestreet.batch_imps should be true")
+	}
+	if !cfg.Adapters["lifestreet"].Shadow {
+		t.Errorf("adapters.lifestreet.shadow should be true")
+	}
+	if !cfg.Adapters["lifestreet"].ForwardTest {
+		t.Errorf("adapters.lifestreet.forward_test should be true")
+	}
+	cmpStrings(t, "adapters.lifestreet.ad_system_domain", cfg.Adapters["lifestreet"].AdSystemDomain, "lifestreet.com")
+	cmpStrings(t, "adapters.lifestreet.sellers_json_url", cfg.Adapters["lifestreet"].SellersJSONURL, "https://lifestreet.com/sellers.json")
 	cmpStrings(t, "adapters.facebook.usersync_url", cfg.Adapters["facebook"].UserSyncURL, "http://facebook.com/ortb/prebid-s2s")
 	cmpStrings(t, "adapters.facebook.platform_id", cfg.Adapters["facebook"].PlatformID, "abcdefgh1234")
+	if !cfg.GetAccount("1001").RequireAdvertiserDomains {
+		t.Errorf("accounts.1001.require_adomain should be true")
+	}
+	if earlyReturn := cfg.GetAccount("1001").EarlyReturn; !earlyReturn.Enabled || earlyReturn.MinCPM != 0.5 {
+		t.Errorf("accounts.1001.early_return should be enabled with a min_cpm of 0.5. Got %#v", earlyReturn)
+	}
+	if minCPM := cfg.GetAccount("1001").MinWinningCPM; minCPM != 0.05 {
+		t.Errorf("accounts.1001.min_winning_cpm should be 0.05. Got %f", minCPM)
+	}
+	cmpStrings(t, "accounts.1001.cache_host", cfg.GetAccount("1001").CacheHost, "cache.publisher.com")
+	cmpStrings(t, "domain_check.mode", cfg.DomainCheck.Mode, "flag")
+	cmpStrings(t, "tie_break.mode", cfg.TieBreak.Mode, "bidder_priority")
+	cmpInts(t, "bidder_ext.max_bytes", cfg.BidderExt.MaxBytes, 2048)
+	if len(cfg.BidderExt.DisallowedKeys) != 1 || cfg.BidderExt.DisallowedKeys[0] != "debug" {
+		t.Errorf("bidder_ext.disallowed_keys should be [debug]. Got %v", cfg.BidderExt.DisallowedKeys)
+	}
+	if keys := cfg.GetAccount("1001").BidderExtKeys; len(keys) != 1 || keys[0] != "dealpriority" {
+		t.Errorf("accounts.1001.bidder_ext_keys should be [dealpriority]. Got %v", keys)
+	}
+	if priority := cfg.TieBreak.BidderPriority; len(priority) != 2 || priority[0] != "rubicon" || priority[1] != "appnexus" {
+		t.Errorf("tie_break.bidder_priority should be [rubicon appnexus]. Got %v", priority)
+	}
+	if domains := cfg.GetAccount("1001").Domains; len(domains) != 1 || domains[0] != "publisher.com" {
+		t.Errorf("accounts.1001.domains should be [publisher.com]. Got %v", domains)
+	}
+	cmpStrings(t, "accounts.1001.apps.config_id", cfg.GetAppDefaults("1001", "com.Publisher.App").ConfigID, "app-defaults")
+	cmpStrings(t, "accounts.1001.apps.config_id", cfg.GetAppDefaults("1001", "com.other.app").ConfigID, "")
+	if !cfg.GetAccount("1001").Events.Enabled {
+		t.Error("accounts.1001.events.enabled should be true")
+	}
+	cmpStrings(t, "accounts.1001.events.impression_url", cfg.GetAccount("1001").Events.ImpressionURL, "https://events.publisher.com/imp?a={{account}}&b={{bidder}}&t={{timestamp}}")
+	if urls := cfg.GetAccount("1001").Events.TrackerURLs(); len(urls) != 2 || urls[1] != "https://analytics.publisher.com/pixel" {
+		t.Errorf("accounts.1001.events should have 2 tracker URLs. Got %v", urls)
+	}
+	if cfg.GetAccount("1001").ChannelEnabled("AMP") || !cfg.GetAccount("1001").ChannelEnabled("app") {
+		t.Errorf("accounts.1001.disabled_channels should only disable amp. Got %v", cfg.GetAccount("1001").DisabledChannels)
+	}
+	if account := cfg.GetAccount("1001"); !account.ActivityAllowed(config.ActivitySyncUser, "", "appnexus", "adnxs") || account.ActivityAllowed(config.ActivitySyncUser, "", "rubicon") {
+		t.Errorf("accounts.1001.activities.sync_user should only allow adnxs. Got %+v", account.Activities[config.ActivitySyncUser])
+	}
+	if account := cfg.GetAccount("1001"); account.ActivityAllowed(config.ActivityTransmitUFPD, "app", "appnexus") || !account.ActivityAllowed(config.ActivityTransmitUFPD, "web", "appnexus") {
+		t.Errorf("accounts.1001.activities.transmit_ufpd should only deny appnexus on app. Got %+v", account.Activities[config.ActivityTransmitUFPD])
+	}
+	if !cfg.GetAccount("unknown").ActivityAllowed(config.ActivityFetchBids, "web", "appnexus") {
+		t.Errorf("Accounts without activity controls should allow every activity")
+	}
+	cmpInts(t, "adapters.lifestreet.eids.max_sources", cfg.Adapters["lifestreet"].EIDs.MaxSources, 3)
+	cmpInts(t, "adapters.lifestreet.eids.max_bytes", cfg.Adapters["lifestreet"].EIDs.MaxBytes, 2048)
+	if priority := cfg.Adapters["lifestreet"].EIDs.Priority; len(priority) != 1 || priority[0] != "liveramp.com" {
+		t.Errorf("adapters.lifestreet.eids.priority should be [liveramp.com]. Got %v", priority)
+	}
+	if fields := cfg.Adapters["lifestreet"].StripFields; len(fields) != 2 || fields[0] != "user.geo" || fields[1] != "site.keywords" {
+		t.Errorf("adapters.lifestreet.strip_fields should be [user.geo site.keywords]. Got %v", fields)
+	}
+	cmpStrings(t, "adapters.appnexus2.alias_of", cfg.Adapters["appnexus2"].AliasOf, "appnexus")
+	cmpStrings(t, "mirror.url", cfg.Mirror.URL, "http://staging.example.com/auction")
+	cmpInts(t, "mirror.timeout_ms", cfg.Mirror.TimeoutMillis, 500)
+	if cfg.Mirror.SampleRate != 0.01 {
+		t.Errorf("mirror.sample_rate should be 0.01. Got %f", cfg.Mirror.SampleRate)
+	}
+	cmpStrings(t, "ivt_filter.mode", cfg.IVTFilter.Mode, "reject")
+	if len(cfg.IVTFilter.DatacenterRanges) != 1 || len(cfg.IVTFilter.BotUserAgents) != 1 {
+		t.Errorf("ivt_filter should have one datacenter range and one bot. Got %#v", cfg.IVTFilter)
+	}
+	cmpStrings(t, "ivt_filter.lists_url", cfg.IVTFilter.ListsURL, "https://lists.example.com/ivt.json")
+	cmpStrings(t, "blocklist.url", cfg.Blocklist.URL, "https://lists.example.com/blocklist.json")
+	cmpInts(t, "blocklist.fetch_interval_seconds", cfg.Blocklist.FetchIntervalSeconds, 300)
+	cmpStrings(t, "gdpr.default_value", cfg.GDPR.DefaultValue, "1")
+	if !cfg.GDPR.Enabled || len(cfg.GDPR.EnforcePurposes) != 3 || cfg.GDPR.EnforcePurposes[2] != 7 {
+		t.Errorf("gdpr should be enabled for purposes 1, 2 and 7. Got %#v", cfg.GDPR)
+	}
+	cmpStrings(t, "gdpr.purposes.purpose2.enforce_algo", cfg.GDPR.Purposes["purpose2"].EnforceAlgo, "basic")
+	if exceptions := cfg.GDPR.Purposes["purpose2"].VendorExceptions; len(exceptions) != 1 || exceptions[0] != "appnexus" {
+		t.Errorf("gdpr.purposes.purpose2.vendor_exceptions should be [appnexus]. Got %v", exceptions)
+	}
+	cmpStrings(t, "gdpr.vendor_list.version_url", cfg.GDPR.VendorList.VersionURL, "https://gvl.example.com/vendor-list-v%d.json")
+	cmpStrings(t, "gdpr.vendor_list.on_fetch_failure", cfg.GDPR.VendorList.OnFetchFailure, "deny")
+	cmpStrings(t, "accounts.1001.schain.sid", cfg.GetSChain("1001").SID, "pub-1001")
+	cmpStrings(t, "schain.sid", cfg.GetSChain("unknown").SID, "host-1")
+	if ttl := cfg.GetCacheTTL("1001", 0); ttl != 600 {
+		t.Errorf("accounts.1001.cache_ttl_seconds should replace the host's TTL. Got %d", ttl)
+	}
+	if ttl := cfg.GetCacheTTL("1001", 30); ttl != 30 {
+		t.Errorf("The bid's exp should win. Got %d", ttl)
+	}
+	if ttl := cfg.GetCacheTTL("unknown", 0); ttl != 300 {
+		t.Errorf("cache.default_ttl_seconds should be used by default. Got %d", ttl)
+	}
+	if cfg.GetAccount("1001").MaxTargetingKeys != 20 {
+		t.Errorf("accounts.1001.max_targeting_keys was %d not 20", cfg.GetAccount("1001").MaxTargetingKeys)
+	}
+	cmpStrings(t, "", cfg.GetAccountCachedAssetURL("1001", "abc"), "http://cache.publisher.com/cache?uuid=abc")
+	cmpStrings(t, "", cfg.GetAccountCachedAssetURL("unknown", "abc"), "http://prebidcache.net/cache?uuid=abc")
+	if cfg.GetAccount("unknown").RequireAdvertiserDomains {
+		t.Errorf("Unknown accounts should not require advertiser domains")
+	}
+	if cfg.Analytics.BidLandscapeSampleRate != 0.01 {
+		t.Errorf("analytics.bid_landscape_sample_rate was %f not 0.01", cfg.Analytics.BidLandscapeSampleRate)
+	}
+	cmpStrings(t, "analytics.file.filename", cfg.Analytics.File.Filename, "/var/log/pbs/auctions.log")
+	cmpStrings(t, "analytics.file.format", cfg.Analytics.File.Format, "protobuf")
+	if !cfg.Analytics.File.Gzip {
+		t.Errorf("analytics.file.gzip should be true")
+	}
+	cmpStrings(t, "experiments.timeouts.hash_by", cfg.Experiments["timeouts"].HashBy, "user")
+	if len(cfg.Experiments["timeouts"].Buckets) != 1 {
+		t.Fatalf("experiments.timeouts should have 1 bucket. Got %d", len(cfg.Experiments["timeouts"].Buckets))
+	}
+	bucket := cfg.Experiments["timeouts"].Buckets[0]
+	cmpStrings(t, "experiments.timeouts.buckets.name", bucket.Name, "short")
+	cmpInts(t, "experiments.timeouts.buckets.percent", bucket.Percent, 10)
+	cmpInts(t, "experiments.timeouts.buckets.timeout_ms", int(bucket.TimeoutMillis), 150)
+	cmpStrings(t, "experiments.timeouts.buckets.floor_strategy", bucket.FloorStrategy, "none")
+	if len(bucket.Bidders) != 2 {
+		t.Errorf("experiments.timeouts.buckets.bidders should have 2 bidders. Got %v", bucket.Bidders)
+	}
+	if percent := cfg.BidderThrottles["pubmatic"].Percent; percent == nil || *percent != 25 {
+		t.Errorf("bidder_throttles.pubmatic.percent should be 25. Got %v", percent)
+	}
+	cmpInts(t, "bidder_throttles.pubmatic.max_qps", cfg.BidderThrottles["pubmatic"].MaxQPS, 100)
+	if !cfg.Monitoring.Enabled {
+		t.Errorf("monitoring.enabled should be true")
+	}
+	cmpStrings(t, "monitoring.account_id", cfg.Monitoring.AccountID, "synthetic")
+	cmpStrings(t, "monitoring.config_id", cfg.Monitoring.ConfigID, "monitoring-unit")
+	if cfg.Monitoring.TimeoutMillis != 300 {
+		t.Errorf("monitoring.timeout_ms was %d not 300", cfg.Monitoring.TimeoutMillis)
+	}
+	cmpStrings(t, "cookie_sync.country_header", cfg.CookieSync.CountryHeader, "CF-IPCountry")
+	if groups := cfg.CookieSync.PriorityGroupsFor("US"); len(groups) != 2 || len(groups[0]) != 2 || groups[1][0] != "pubmatic" {
+		t.Errorf("cookie_sync.priority_groups was %v", groups)
+	}
+	if groups := cfg.CookieSync.PriorityGroupsFor("fr"); len(groups) != 1 || groups[0][0] != "pubmatic" {
+		t.Errorf("cookie_sync.regions.eu.priority_groups was %v", groups)
+	}
+	cmpStrings(t, "identity_resolution.endpoint", cfg.IdentityResolution.Endpoint, "http://ids.prebid.org/resolve")
+	cmpInts(t, "identity_resolution.timeout_ms", cfg.IdentityResolution.TimeoutMillis, 20)
+	cmpInts(t, "identity_resolution.cache_ttl_seconds", cfg.IdentityResolution.CacheTTLSeconds, 300)
+	cmpInts(t, "no_bid_cache.ttl_seconds", cfg.NoBidCache.TTLSeconds, 5)
+	if len(cfg.NoBidCache.DisabledBidders) != 1 || cfg.NoBidCache.DisabledBidders[0] != "rubicon" {
+		t.Errorf("no_bid_cache.disabled_bidders was %v", cfg.NoBidCache.DisabledBidders)
+	}
+	cmpStrings(t, "bad_response_quarantine.filename", cfg.Quarantine.Filename, "/var/log/pbs/quarantine.log")
+	cmpInts(t, "bad_response_quarantine.max_bytes", cfg.Quarantine.MaxBytes, 2048)
+	if cfg.Quarantine.SampleRate != 0.05 {
+		t.Errorf("bad_response_quarantine.sample_rate was %f not 0.05", cfg.Quarantine.SampleRate)
+	}
+	if !cfg.BidderMacros["pulsepoint"].Enabled {
+		t.Errorf("bidder_macros.pulsepoint.enabled should be true")
+	}
+	cmpStrings(t, "bidder_macros.pulsepoint.price_encryption.scheme", cfg.BidderMacros["pulsepoint"].PriceEncryption.Scheme, "hmac-sha1")
+	cmpStrings(t, "bidder_macros.pulsepoint.price_encryption.encryption_key", cfg.BidderMacros["pulsepoint"].PriceEncryption.EncryptionKey, "ZW5jcnlwdGlvbg==")
+	cmpStrings(t, "bidder_macros.pulsepoint.price_encryption.integrity_key", cfg.BidderMacros["pulsepoint"].PriceEncryption.IntegrityKey, "aW50ZWdyaXR5")
+	cmpStrings(t, "currency_converter.fetch_url", cfg.CurrencyConverter.FetchURL, "https://cdn.jsdelivr.net/gh/prebid/currency-file@1/latest.json")
+	cmpInts(t, "currency_converter.fetch_interval_seconds", cfg.CurrencyConverter.FetchIntervalSeconds, 600)
+	if currencies := cfg.BidderValidations["rubicon"].Currencies; len(currencies) != 2 || currencies[1] != "EUR" {
+		t.Errorf("bidder_validations.rubicon.currencies should be [USD EUR]. Got %v", currencies)
+	}
 }
 
@@ -0,0 +1,131 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/rcrowley/go-metrics"
+)
+
+// Rates holds currency conversion rates in the file format used by Prebid.js:
+//
+//	{"dataAsOf": "2018-03-15", "conversions": {"USD": {"EUR": 0.81, "GBP": 0.72}}}
+//
+// where conversions[from][to] is the number of "to" units in one "from" unit.
+type Rates struct {
+	DataAsOf    string                        `json:"dataAsOf"`
+	Conversions map[string]map[string]float64 `json:"conversions"`
+}
+
+// ParseRates parses a rates file. It's meant to be used with remotefile.Fetcher.FetchFunc.
+func ParseRates(body []byte) (interface{}, error) {
+	var rates Rates
+	if err := json.Unmarshal(body, &rates); err != nil {
+		return nil, err
+	}
+	if len(rates.Conversions) == 0 {
+		return nil, fmt.Errorf("the rates file has no conversions")
+	}
+	normalized := make(map[string]map[string]float64, len(rates.Conversions))
+	for from, conversions := range rates.Conversions {
+		to := make(map[string]float64, len(conversions))
+		for currency, rate := range conversions {
+			to[strings.ToUpper(currency)] = rate
+		}
+		normalized[strings.ToUpper(from)] = to
+	}
+	rates.Conversions = normalized
+	return &rates, nil
+}
+
+// GetRate returns the rate for converting from one currency into another.
+//
+// Files usually only list conversions from a few base currencies, so the inverse of a listed
+// rate is used if needed, and failing that, a conversion through one of the base currencies.
+func (r *Rates) GetRate(from string, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := r.direct(from, to); ok {
+		return rate, nil
+	}
+	for base := range r.Conversions {
+		toBase, ok := r.direct(from, base)
+		if !ok {
+			continue
+		}
+		if fromBase, ok := r.direct(base, to); ok {
+			return toBase * fromBase, nil
+		}
+	}
+	return 0, fmt.Errorf("no conversion rate from %s to %s", from, to)
+}
+
+func (r *Rates) direct(from string, to string) (float64, bool) {
+	if rate, ok := r.Conversions[from][to]; ok && rate > 0 {
+		return rate, true
+	}
+	if rate, ok := r.Conversions[to][from]; ok && rate > 0 {
+		return 1 / rate, true
+	}
+	return 0, false
+}
+
+// Converter converts prices using the latest rates from a refresher.Refresher.
+type Converter struct {
+	rates    *refresher.Refresher
+	registry metrics.Registry
+
+	staleMeter  metrics.Meter
+	failedMeter metrics.Meter
+}
+
+// NewConverter makes a Converter. The refresher's data must come from ParseRates.
+//
+// Each conversion is reported to the registry as currency.conversions.{from}.{to}. Conversions which used
+// rates older than the refresh interval are also reported as currency.stale_conversions, and conversions
+// which failed as currency.failed_conversions. Failed fetches are reported by the refresher.
+func NewConverter(rates *refresher.Refresher, registry metrics.Registry) *Converter {
+	return &Converter{
+		rates:       rates,
+		registry:    registry,
+		staleMeter:  metrics.GetOrRegisterMeter("currency.stale_conversions", registry),
+		failedMeter: metrics.GetOrRegisterMeter("currency.failed_conversions", registry),
+	}
+}
+
+// Convert converts a price from one currency into another. It fails if the rates are missing or too old.
+func (c *Converter) Convert(price float64, from string, to string) (float64, error) {
+	if strings.EqualFold(from, to) {
+		return price, nil
+	}
+	if c == nil {
+		return 0, fmt.Errorf("currency conversion isn't configured")
+	}
+	data, err := c.rates.Get()
+	if err != nil {
+		c.failedMeter.Mark(1)
+		return 0, fmt.Errorf("currency rates are unavailable: %v", err)
+	}
+	rate, err := data.(*Rates).GetRate(from, to)
+	if err != nil {
+		c.failedMeter.Mark(1)
+		return 0, err
+	}
+	if c.rates.Status().Stale {
+		c.staleMeter.Mark(1)
+	}
+	metrics.GetOrRegisterMeter(fmt.Sprintf("currency.conversions.%s.%s", strings.ToUpper(from), strings.ToUpper(to)), c.registry).Mark(1)
+	return price * rate, nil
+}
+
+// Rates returns the rates which conversions are using right now, and how fresh they are.
+// The rates are nil if none have been fetched.
+func (c *Converter) Rates() (*Rates, refresher.Status) {
+	data, _ := c.rates.Get()
+	rates, _ := data.(*Rates)
+	return rates, c.rates.Status()
+}
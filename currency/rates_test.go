@@ -0,0 +1,101 @@
+package currency
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/rcrowley/go-metrics"
+)
+
+const testRates = `{"dataAsOf":"2018-03-15","conversions":{"USD":{"EUR":0.8,"gbp":0.5}}}`
+
+func parseTestRates(t *testing.T) *Rates {
+	data, err := ParseRates([]byte(testRates))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return data.(*Rates)
+}
+
+func assertRate(t *testing.T, rates *Rates, from string, to string, expected float64) {
+	rate, err := rates.GetRate(from, to)
+	if err != nil {
+		t.Errorf("Unexpected error for %s to %s: %v", from, to, err)
+		return
+	}
+	if math.Abs(rate-expected) > 0.0001 {
+		t.Errorf("Expected %s to %s to be %f. Got %f", from, to, expected, rate)
+	}
+}
+
+func TestGetRate(t *testing.T) {
+	rates := parseTestRates(t)
+	assertRate(t, rates, "USD", "EUR", 0.8)
+	assertRate(t, rates, "eur", "usd", 1.25)
+	assertRate(t, rates, "EUR", "GBP", 0.625)
+	assertRate(t, rates, "JPY", "JPY", 1)
+
+	if _, err := rates.GetRate("USD", "JPY"); err == nil {
+		t.Errorf("Unknown currencies should be an error")
+	}
+}
+
+func TestParseRatesEmpty(t *testing.T) {
+	if _, err := ParseRates([]byte(`{"dataAsOf":"2018-03-15"}`)); err == nil {
+		t.Errorf("A file without conversions should be an error")
+	}
+}
+
+func TestConverter(t *testing.T) {
+	ref := refresher.New("currency", time.Hour, 0, func() (interface{}, error) {
+		return ParseRates([]byte(testRates))
+	}, metrics.NewRegistry())
+	ref.Start(nil)
+	converter := NewConverter(ref, metrics.NewRegistry())
+
+	price, err := converter.Convert(2, "EUR", "USD")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(price-2.5) > 0.0001 {
+		t.Errorf("Expected 2.5. Got %f", price)
+	}
+
+	var missing *Converter
+	if _, err := missing.Convert(2, "EUR", "USD"); err == nil {
+		t.Errorf("A missing converter should be an error")
+	}
+	if price, err := missing.Convert(2, "USD", "usd"); err != nil || price != 2 {
+		t.Errorf("Prices in the same currency don't need a converter")
+	}
+}
+
+func TestConverterMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	ref := refresher.New("currency", time.Hour, 0, func() (interface{}, error) {
+		return ParseRates([]byte(testRates))
+	}, registry)
+	ref.Start(nil)
+	converter := NewConverter(ref, registry)
+
+	converter.Convert(2, "eur", "USD")
+	converter.Convert(2, "EUR", "USD")
+	converter.Convert(2, "JPY", "USD")
+
+	if count := metrics.GetOrRegisterMeter("currency.conversions.EUR.USD", registry).Count(); count != 2 {
+		t.Errorf("Expected 2 EUR to USD conversions. Got %d", count)
+	}
+	if count := metrics.GetOrRegisterMeter("currency.failed_conversions", registry).Count(); count != 1 {
+		t.Errorf("Expected 1 failed conversion. Got %d", count)
+	}
+	if count := metrics.GetOrRegisterMeter("currency.stale_conversions", registry).Count(); count != 0 {
+		t.Errorf("Fresh rates shouldn't count as stale. Got %d", count)
+	}
+
+	rates, status := converter.Rates()
+	if rates == nil || rates.DataAsOf != "2018-03-15" || status.Stale {
+		t.Errorf("Expected the fresh rates. Got %#v, %#v", rates, status)
+	}
+}
@@ -0,0 +1,134 @@
+package errortypes
+
+// These codes are included in the response ext, so that callers can handle errors without parsing the messages.
+// Errors mean that a bidder couldn't take part in the auction. Warnings mean that something went wrong,
+// but the bidder's other bids were still used.
+const (
+	UnknownErrorCode = 999
+
+	TimeoutErrorCode           = 1
+	BadInputErrorCode          = 2
+	BadServerResponseErrorCode = 3
+)
+
+const (
+	UnknownWarningCode = 10999
+
+	InvalidBidWarningCode    = 10001
+	BidCurrencyWarningCode   = 10002
+	TargetingKeysWarningCode = 10003
+)
+
+// Severity says whether an error stopped a bidder from taking part in the auction.
+type Severity int
+
+const (
+	SeverityFatal Severity = iota
+	SeverityWarning
+)
+
+// Coder is implemented by the errors in this package.
+type Coder interface {
+	Code() int
+	Severity() Severity
+}
+
+// ReadCode returns the code of an error from this package, or UnknownErrorCode for any other error.
+func ReadCode(err error) int {
+	if coder, ok := err.(Coder); ok {
+		return coder.Code()
+	}
+	return UnknownErrorCode
+}
+
+// ReadSeverity returns the severity of an error from this package, or SeverityFatal for any other error.
+func ReadSeverity(err error) Severity {
+	if coder, ok := err.(Coder); ok {
+		return coder.Severity()
+	}
+	return SeverityFatal
+}
+
+// Timeout should be used when a bidder didn't respond before the auction's deadline.
+type Timeout struct {
+	Message string
+}
+
+func (err *Timeout) Error() string {
+	return err.Message
+}
+
+func (err *Timeout) Code() int {
+	return TimeoutErrorCode
+}
+
+func (err *Timeout) Severity() Severity {
+	return SeverityFatal
+}
+
+// BadInput should be used when the request can't be sent to a bidder, because of something in the request.
+// For example, missing or invalid bidder params.
+type BadInput struct {
+	Message string
+}
+
+func (err *BadInput) Error() string {
+	return err.Message
+}
+
+func (err *BadInput) Code() int {
+	return BadInputErrorCode
+}
+
+func (err *BadInput) Severity() Severity {
+	return SeverityFatal
+}
+
+// BadServerResponse should be used when returning errors which are caused by bad/unexpected behavior on the remote server.
+//
+// For example:
+//
+//   - The external server responded with a 500
+//   - The external server gave a malformed or unexpected response.
+//
+// These should not be used to log _connection_ errors (e.g. "couldn't find host"),
+// which may indicate config issues for the PBS host company
+type BadServerResponse struct {
+	Message string
+	// Payload is the raw response body, if there was one. It may contain user data,
+	// so it must be scrubbed before being logged anywhere.
+	Payload []byte
+}
+
+func (err *BadServerResponse) Error() string {
+	return err.Message
+}
+
+func (err *BadServerResponse) Code() int {
+	return BadServerResponseErrorCode
+}
+
+func (err *BadServerResponse) Severity() Severity {
+	return SeverityFatal
+}
+
+// Warning should be used for problems which didn't stop a bidder from taking part in the auction.
+type Warning struct {
+	Message     string
+	WarningCode int
+}
+
+func (err *Warning) Error() string {
+	return err.Message
+}
+
+func (err *Warning) Code() int {
+	if err.WarningCode == 0 {
+		return UnknownWarningCode
+	}
+	return err.WarningCode
+}
+
+func (err *Warning) Severity() Severity {
+	return SeverityWarning
+}
@@ -0,0 +1,165 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+)
+
+// These benchmark the /openrtb2/auction hot path with a realistic request: 5 imps, each with params for 10 bidders.
+// Run them with `make bench` to get CPU and memory profiles.
+
+const benchmarkRequestFile = "testdata/auction-5imps-10bidders.json"
+
+const benchmarkBidders = 10
+
+// newBenchmarkExchange returns an Exchange whose bidders bid on every imp, like the bidder0 ... bidder9 in the
+// benchmark request. Their calls go over HTTP to a local server, so the fan-out includes real round trips.
+func newBenchmarkExchange() (*Exchange, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	bidders := make(map[string]adapters.Bidder, benchmarkBidders)
+	for i := 0; i < benchmarkBidders; i++ {
+		bidders[fmt.Sprintf("bidder%d", i)] = &priceBidder{uri: server.URL}
+	}
+	return New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), bidders, nil, nil, nil, nil, nil), server.Close
+}
+
+func readBenchmarkRequest(tb testing.TB) []byte {
+	body, err := ioutil.ReadFile(benchmarkRequestFile)
+	if err != nil {
+		tb.Fatalf("Failed to read %s: %v", benchmarkRequestFile, err)
+	}
+	return body
+}
+
+// BenchmarkAuction covers a whole auction: parsing the request, validating it, fanning out to the bidders,
+// and encoding the response.
+func BenchmarkAuction(b *testing.B) {
+	e, closeServer := newBenchmarkExchange()
+	defer closeServer()
+	body := readBenchmarkRequest(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var request openrtb.BidRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			b.Fatalf("Bad benchmark request: %v", err)
+		}
+		if err := e.Validate(&request); err != nil {
+			b.Fatalf("Invalid benchmark request: %v", err)
+		}
+		response, err := e.HoldAuction(context.Background(), &request, Activities{})
+		if err != nil {
+			b.Fatalf("Auction failed: %v", err)
+		}
+		if _, err := json.Marshal(response); err != nil {
+			b.Fatalf("Failed to encode the response: %v", err)
+		}
+	}
+}
+
+func BenchmarkParseRequest(b *testing.B) {
+	body := readBenchmarkRequest(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var request openrtb.BidRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			b.Fatalf("Bad benchmark request: %v", err)
+		}
+	}
+}
+
+func BenchmarkValidate(b *testing.B) {
+	e, closeServer := newBenchmarkExchange()
+	defer closeServer()
+	var request openrtb.BidRequest
+	if err := json.Unmarshal(readBenchmarkRequest(b), &request); err != nil {
+		b.Fatalf("Bad benchmark request: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := e.Validate(&request); err != nil {
+			b.Fatalf("Invalid benchmark request: %v", err)
+		}
+	}
+}
+
+// BenchmarkHoldAuction is the fan-out alone. HoldAuction doesn't change the request, so it's parsed once.
+func BenchmarkHoldAuction(b *testing.B) {
+	e, closeServer := newBenchmarkExchange()
+	defer closeServer()
+	var request openrtb.BidRequest
+	if err := json.Unmarshal(readBenchmarkRequest(b), &request); err != nil {
+		b.Fatalf("Bad benchmark request: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.HoldAuction(context.Background(), &request, Activities{}); err != nil {
+			b.Fatalf("Auction failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeResponse(b *testing.B) {
+	e, closeServer := newBenchmarkExchange()
+	defer closeServer()
+	var request openrtb.BidRequest
+	if err := json.Unmarshal(readBenchmarkRequest(b), &request); err != nil {
+		b.Fatalf("Bad benchmark request: %v", err)
+	}
+	response, err := e.HoldAuction(context.Background(), &request, Activities{})
+	if err != nil {
+		b.Fatalf("Auction failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(response); err != nil {
+			b.Fatalf("Failed to encode the response: %v", err)
+		}
+	}
+}
+
+// TestBenchmarkRequest makes sure the benchmark request stays valid, and that every bidder bids on every imp,
+// so that the benchmarks measure a full auction.
+func TestBenchmarkRequest(t *testing.T) {
+	e, closeServer := newBenchmarkExchange()
+	defer closeServer()
+	var request openrtb.BidRequest
+	if err := json.Unmarshal(readBenchmarkRequest(t), &request); err != nil {
+		t.Fatalf("Bad benchmark request: %v", err)
+	}
+	if err := e.Validate(&request); err != nil {
+		t.Fatalf("Invalid benchmark request: %v", err)
+	}
+	response, err := e.HoldAuction(context.Background(), &request, Activities{})
+	if err != nil {
+		t.Fatalf("Auction failed: %v", err)
+	}
+	if len(request.Imp) != 5 || len(response.SeatBid) != benchmarkBidders {
+		t.Fatalf("Expected 5 imps and %d seats. Got %d and %d", benchmarkBidders, len(request.Imp), len(response.SeatBid))
+	}
+	for _, seatBid := range response.SeatBid {
+		if len(seatBid.Bid) != len(request.Imp) {
+			t.Errorf("%s should bid on every imp. Got %d bids", seatBid.Seat, len(seatBid.Bid))
+		}
+	}
+}
@@ -0,0 +1,440 @@
+// Package exchange runs OpenRTB auctions for the /openrtb2 endpoints.
+//
+// Unlike the legacy /auction endpoint, requests and responses are plain OpenRTB 2.5. Bidder params
+// are in each imp's ext, keyed by bidder code, and Prebid's own options are in request.ext.prebid.
+// Only adapters which implement adapters.Bidder can take part.
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/gdpr"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// Exchange holds auctions across its bidders.
+type Exchange struct {
+	client          *adapters.HTTPAdapter
+	bidders         map[string]adapters.Bidder
+	tieBreaker      *pbs.TieBreaker
+	paramsValidator *adapters.ParamsValidator
+	stripFields     map[string][]string
+	gdpr            *gdpr.Enforcer
+	registry        *adapters.BidderRegistry
+}
+
+// New returns an Exchange for the bidders, keyed by bidder code. Their calls are made with the client.
+// The tieBreaker picks the winning bid when an imp's top bids have the same price, and the paramsValidator
+// checks each bidder's params before it's called. Either may be nil. stripFields lists the request fields which
+// each bidder must not get, keyed by bidder code. The gdprEnforcer removes the personal data which bidders don't
+// have consent for, and may also be nil. The registry says which media types each bidder supports, and
+// whether it gets imp.ext.skadn. If it's nil, bidders get every imp, but never the skadn.
+func New(client *adapters.HTTPAdapter, bidders map[string]adapters.Bidder, tieBreaker *pbs.TieBreaker, paramsValidator *adapters.ParamsValidator, stripFields map[string][]string, gdprEnforcer *gdpr.Enforcer, registry *adapters.BidderRegistry) *Exchange {
+	return &Exchange{
+		client:          client,
+		bidders:         bidders,
+		tieBreaker:      tieBreaker,
+		paramsValidator: paramsValidator,
+		stripFields:     stripFields,
+		gdpr:            gdprEnforcer,
+		registry:        registry,
+	}
+}
+
+// Activities are the activity controls of the account which the request is for, and the channel which they're
+// checked against. The channel must be worked out by the endpoint, since clients could send whichever one
+// their account allows. The zero value allows everything.
+type Activities struct {
+	Account config.Account
+	Channel string
+}
+
+// allowed returns true if the account lets the bidder do the activity. The bidder may be an alias of the core bidder.
+func (a Activities) allowed(activity string, bidderCode string, coreCode string) bool {
+	return a.Account.ActivityAllowed(activity, a.Channel, bidderCode, coreCode)
+}
+
+// seatResult is what came back from one bidder.
+type seatResult struct {
+	bidder   string
+	bids     []openrtb.Bid
+	errs     []error
+	duration time.Duration
+}
+
+// HoldAuction sends the request to each bidder with params in its imps, and returns all of their bids.
+// The request must have passed Validate. Bidders which haven't answered when the context ends are left out.
+//
+// Each bidder gets a copy of the request with only its own imps, and {"bidder": params} as their ext.
+// Imps whose media types the bidder doesn't support on the request's platform are left out.
+// Bidders which support SKAdNetwork also get the imps' ext.skadn, and may sign their bids in bid.ext.skadn.
+// The personal data which it doesn't have GDPR consent for, and the fields in its stripFields, are removed from its copy.
+// Bids which aren't in USD are rejected, since there's no currency conversion here yet.
+// Bidders which didn't bid because they timed out or failed are listed in the response's ext.prebid.server.
+//
+// The activities decide which bidders are called at all, and which of them get the user's IDs and precise location.
+func (e *Exchange) HoldAuction(ctx context.Context, request *openrtb.BidRequest, activities Activities) (*openrtb.BidResponse, error) {
+	var requestExt ExtRequest
+	if len(request.Ext) > 0 {
+		if err := json.Unmarshal(request.Ext, &requestExt); err != nil {
+			return nil, err
+		}
+	}
+	aliases := requestExt.Prebid.Aliases
+	platform := "site"
+	if request.App != nil {
+		platform = "app"
+	}
+	bidderImps, passthroughs, err := e.splitImps(request.Imp, platform, aliases)
+	if err != nil {
+		return nil, err
+	}
+	deniedErrs := make(map[string][]error)
+	for bidderCode := range bidderImps {
+		if !activities.allowed(config.ActivityFetchBids, bidderCode, coreBidder(aliases, bidderCode)) {
+			delete(bidderImps, bidderCode)
+			deniedErrs[bidderCode] = []error{&errortypes.Warning{Message: "Denied by the account's activity controls"}}
+		}
+	}
+	paramsErrs := e.validateParams(bidderImps, aliases)
+
+	results := make(chan *seatResult, len(bidderImps))
+	for bidderCode, imps := range bidderImps {
+		bidderRequest := *request
+		bidderRequest.Imp = imps
+		bidderRequest.Ext = nil
+		go func(bidderCode string, bidderRequest *openrtb.BidRequest) {
+			defer adapters.RecoverPanic(bidderCode, func(err error) {
+				results <- &seatResult{bidder: bidderCode, errs: []error{err}}
+			})
+			results <- e.callBidder(ctx, bidderCode, coreBidder(aliases, bidderCode), bidderRequest, activities)
+		}(bidderCode, &bidderRequest)
+	}
+
+	responseExt := ExtBidResponse{ResponseTimeMillis: make(map[string]int, len(bidderImps))}
+	var server ExtResponseServer
+	for bidder, errs := range deniedErrs {
+		responseExt.addErrors(bidder, errs)
+	}
+	for bidder, errs := range paramsErrs {
+		responseExt.addErrors(bidder, errs)
+		if _, called := bidderImps[bidder]; !called {
+			server.add(bidder, errs)
+		}
+	}
+	var seatBids []openrtb.SeatBid
+	for range bidderImps {
+		result := <-results
+		responseExt.ResponseTimeMillis[result.bidder] = int(result.duration / time.Millisecond)
+		responseExt.addErrors(result.bidder, result.errs)
+		if len(result.bids) > 0 {
+			seatBids = append(seatBids, openrtb.SeatBid{Seat: result.bidder, Bid: result.bids})
+		} else {
+			server.add(result.bidder, result.errs)
+		}
+	}
+	// Bidders answer in any order, so seats are sorted to keep responses stable.
+	sort.Slice(seatBids, func(i, j int) bool { return seatBids[i].Seat < seatBids[j].Seat })
+
+	if requestExt.Prebid.Targeting != nil {
+		seed := ""
+		if request.Test == 1 {
+			seed = request.ID
+		}
+		if err := e.addTargeting(seatBids, requestExt.Prebid.Targeting, responseExt.ResponseTimeMillis, seed); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(passthroughs) > 0 {
+		if err := addPassthroughs(seatBids, passthroughs); err != nil {
+			return nil, err
+		}
+	}
+	if len(requestExt.Prebid.Passthrough) > 0 {
+		responseExt.Prebid = &ExtResponsePrebid{Passthrough: requestExt.Prebid.Passthrough}
+	}
+	responseExt.addServerHints(server)
+
+	rawExt, err := json.Marshal(&responseExt)
+	if err != nil {
+		return nil, err
+	}
+	response := &openrtb.BidResponse{
+		ID:      request.ID,
+		SeatBid: seatBids,
+		Ext:     rawExt,
+	}
+	if len(seatBids) > 0 {
+		response.Cur = "USD"
+	}
+	return response, nil
+}
+
+// callBidder runs the core bidder's adapter for the bidder code, which is different if the code is an alias.
+func (e *Exchange) callBidder(ctx context.Context, bidderCode string, coreCode string, request *openrtb.BidRequest, activities Activities) *seatResult {
+	start := time.Now()
+	if !e.registry.SupportsGPP(coreCode) {
+		gdpr.DowngradeGPP(request)
+	}
+	restrictions := e.gdpr.Restrictions(request.Regs, request.User, coreCode)
+	if !activities.allowed(config.ActivityTransmitUFPD, bidderCode, coreCode) {
+		restrictions.UserIDs = true
+	}
+	if !activities.allowed(config.ActivityTransmitPreciseGeo, bidderCode, coreCode) {
+		restrictions.PreciseGeo = true
+	}
+	restrictions.Apply(request)
+	if err := adapters.StripFields(request, e.stripFields[coreCode]); err != nil {
+		return &seatResult{bidder: bidderCode, errs: []error{err}, duration: time.Since(start)}
+	}
+	responses, _, errs := adapters.RunBidder(ctx, e.client, e.bidders[coreCode], request)
+	// Calls which the auction's deadline cut off fail with the context's error, which is reported as a timeout.
+	if ctx.Err() == context.DeadlineExceeded {
+		for i, err := range errs {
+			if _, ok := err.(errortypes.Coder); !ok {
+				errs[i] = &errortypes.Timeout{Message: err.Error()}
+			}
+		}
+	}
+	result := &seatResult{
+		bidder:   bidderCode,
+		errs:     errs,
+		duration: time.Since(start),
+	}
+
+	// imps maps the ID of each imp to what its bids are checked against.
+	// The imps were validated along with the BidRequest.
+	imps := make(map[string]bidderImp, len(request.Imp))
+	for _, imp := range request.Imp {
+		var checks bidderImp
+		if imp.Native != nil {
+			checks.native, _ = pbs.ParseNativeRequest(imp.Native.Request)
+		}
+		var ext bidderImpExt
+		if err := json.Unmarshal(imp.Ext, &ext); err == nil {
+			checks.skadn = ext.SKAdN
+		}
+		imps[imp.ID] = checks
+	}
+	for _, response := range responses {
+		if response.Currency != "" && response.Currency != "USD" {
+			result.errs = append(result.errs, &errortypes.BadServerResponse{
+				Message: fmt.Sprintf("Bids in %s were rejected. Only USD is supported", response.Currency),
+			})
+			continue
+		}
+		for _, typedBid := range response.Bids {
+			bid, err := makeBid(typedBid, imps)
+			if err != nil {
+				result.errs = append(result.errs, err)
+				continue
+			}
+			result.bids = append(result.bids, bid)
+		}
+	}
+	return result
+}
+
+// bidderImp is what a bidder's bids on an imp are checked against. Either field is nil if the imp didn't have one.
+type bidderImp struct {
+	native *pbs.NativeRequest
+	skadn  *ExtImpSKAdN
+}
+
+// bidderImpExt is the ext of the imps which a bidder gets.
+type bidderImpExt struct {
+	Bidder json.RawMessage `json:"bidder"`
+	GPID   string          `json:"gpid,omitempty"`
+	Data   *ExtImpData     `json:"data,omitempty"`
+	SKAdN  *ExtImpSKAdN    `json:"skadn,omitempty"`
+}
+
+// makeBid copies the bidder's bid, and moves its ext into ext.bidder. Native bids must have markup for their imp's native request.
+// A bid.ext.skadn must be valid for its imp, and it's copied into the response's ext.skadn.
+func makeBid(typedBid *adapters.TypedBid, imps map[string]bidderImp) (openrtb.Bid, error) {
+	bid := *typedBid.Bid
+	imp, ok := imps[bid.ImpID]
+	if !ok {
+		return bid, &errortypes.BadServerResponse{Message: fmt.Sprintf("Bid %q is for unknown imp %q", bid.ID, bid.ImpID)}
+	}
+	native := imp.native
+	if typedBid.BidType == pbs.MEDIA_TYPE_NATIVE {
+		if native == nil {
+			return bid, &errortypes.BadServerResponse{Message: fmt.Sprintf("Bid %q is native, but imp %q didn't ask for native", bid.ID, bid.ImpID)}
+		}
+		if err := pbs.ValidateNativeResponse(bid.AdM, native); err != nil {
+			return bid, &errortypes.BadServerResponse{Message: fmt.Sprintf("Bid %q has invalid native markup: %v", bid.ID, err)}
+		}
+	}
+	skadn, err := parseBidSKAdN(json.RawMessage(bid.Ext), imp.skadn)
+	if err != nil {
+		return bid, &errortypes.BadServerResponse{Message: fmt.Sprintf("Bid %q has an invalid ext.skadn: %v", bid.ID, err)}
+	}
+	ext := ExtBid{
+		Bidder: json.RawMessage(bid.Ext),
+		Prebid: ExtBidPrebid{Type: mediaTypeName(typedBid.BidType)},
+		SKAdN:  skadn,
+	}
+	if bidVideo := typedBid.BidVideo; bidVideo != nil {
+		ext.Prebid.Video = &ExtBidPrebidVideo{
+			Duration:        bidVideo.Duration,
+			PrimaryCategory: bidVideo.PrimaryCategory,
+		}
+	}
+	rawExt, err := json.Marshal(&ext)
+	if err != nil {
+		return bid, err
+	}
+	bid.Ext = rawExt
+	return bid, nil
+}
+
+func mediaTypeName(mediaType pbs.MediaType) string {
+	switch mediaType {
+	case pbs.MEDIA_TYPE_VIDEO:
+		return "video"
+	case pbs.MEDIA_TYPE_NATIVE:
+		return "native"
+	}
+	return "banner"
+}
+
+// splitImps groups copies of the imps by bidder. Each copy's ext is {"bidder": params}, with the imp's gpid and
+// data.pbadslot, plus the imp's skadn if the bidder supports SKAdNetwork.
+// It also returns the ext.prebid.passthrough of each imp which has one, keyed by imp ID.
+func (e *Exchange) splitImps(imps []openrtb.Imp, platform string, aliases map[string]string) (map[string][]openrtb.Imp, map[string]json.RawMessage, error) {
+	bidderImps := make(map[string][]openrtb.Imp)
+	passthroughs := make(map[string]json.RawMessage)
+	for _, imp := range imps {
+		var ext ExtImp
+		if err := json.Unmarshal(imp.Ext, &ext); err != nil {
+			return nil, nil, err
+		}
+		var skadn *ExtImpSKAdN
+		if rawSKAdN, ok := ext["skadn"]; ok {
+			if err := json.Unmarshal(rawSKAdN, &skadn); err != nil {
+				return nil, nil, err
+			}
+		}
+		gpid, data, err := parseAdSlot(&imp, ext)
+		if err != nil {
+			return nil, nil, err
+		}
+		for bidder, params := range ext {
+			if bidder == "prebid" {
+				var prebid ExtImpPrebid
+				if err := json.Unmarshal(params, &prebid); err != nil {
+					return nil, nil, err
+				}
+				if len(prebid.Passthrough) > 0 {
+					passthroughs[imp.ID] = prebid.Passthrough
+				}
+				continue
+			}
+			if isReservedImpExtKey(bidder) {
+				continue
+			}
+			core := coreBidder(aliases, bidder)
+			if !e.supportsImp(core, platform, &imp) {
+				continue
+			}
+			impExt := bidderImpExt{Bidder: params, GPID: gpid, Data: data}
+			if e.registry.SupportsSKAdN(core) {
+				impExt.SKAdN = skadn
+			}
+			bidderExt, err := json.Marshal(&impExt)
+			if err != nil {
+				return nil, nil, err
+			}
+			bidderImp := imp
+			bidderImp.Ext = bidderExt
+			bidderImps[bidder] = append(bidderImps[bidder], bidderImp)
+		}
+	}
+	return bidderImps, passthroughs, nil
+}
+
+// supportsImp returns true if the bidder supports at least one of the imp's media types on the platform.
+func (e *Exchange) supportsImp(bidder string, platform string, imp *openrtb.Imp) bool {
+	offered := map[string]bool{
+		"banner": imp.Banner != nil,
+		"video":  imp.Video != nil,
+		"audio":  imp.Audio != nil,
+		"native": imp.Native != nil,
+	}
+	for mediaType, ok := range offered {
+		if ok && e.registry.Supports(bidder, platform, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateParams drops the imps whose params don't match their bidder's schema, and returns an error
+// for each of them, keyed by bidder code. Bidders which are left without any imps aren't called.
+// Aliases are checked against the schema of the bidder they alias.
+func (e *Exchange) validateParams(bidderImps map[string][]openrtb.Imp, aliases map[string]string) map[string][]error {
+	errs := make(map[string][]error)
+	for bidder, imps := range bidderImps {
+		validImps := imps[:0]
+		for _, imp := range imps {
+			var ext struct {
+				Bidder json.RawMessage `json:"bidder"`
+			}
+			json.Unmarshal(imp.Ext, &ext)
+			if err := e.paramsValidator.Validate(coreBidder(aliases, bidder), ext.Bidder); err != nil {
+				errs[bidder] = append(errs[bidder], &errortypes.BadInput{Message: fmt.Sprintf("imp %s: %v", imp.ID, err)})
+				continue
+			}
+			validImps = append(validImps, imp)
+		}
+		if len(validImps) == 0 {
+			delete(bidderImps, bidder)
+		} else {
+			bidderImps[bidder] = validImps
+		}
+	}
+	return errs
+}
+
+// coreBidder returns the code of the bidder whose adapter runs for the bidder code, which may be one of the
+// request's aliases.
+func coreBidder(aliases map[string]string, bidderCode string) string {
+	if core, ok := aliases[bidderCode]; ok {
+		return core
+	}
+	return bidderCode
+}
+
+// addPassthroughs copies each imp's passthrough into the ext.prebid of its bids.
+func addPassthroughs(seatBids []openrtb.SeatBid, passthroughs map[string]json.RawMessage) error {
+	for i := range seatBids {
+		for j := range seatBids[i].Bid {
+			bid := &seatBids[i].Bid[j]
+			passthrough, ok := passthroughs[bid.ImpID]
+			if !ok {
+				continue
+			}
+			var ext ExtBid
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				return err
+			}
+			ext.Prebid.Passthrough = passthrough
+			rawExt, err := json.Marshal(&ext)
+			if err != nil {
+				return err
+			}
+			bid.Ext = rawExt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,722 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/gdpr"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// priceBidder bids the "price" from its params on every imp, in its own currency.
+type priceBidder struct {
+	uri      string
+	currency string
+}
+
+func (b *priceBidder) MakeRequests(request *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return []*adapters.RequestData{{URI: b.uri, Body: body}}, nil
+}
+
+func (b *priceBidder) MakeBids(request *openrtb.BidRequest, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	bidderResponse := &adapters.BidderResponse{Currency: b.currency}
+	for _, imp := range request.Imp {
+		var ext struct {
+			Bidder struct {
+				Price float64 `json:"price"`
+			} `json:"bidder"`
+		}
+		if err := json.Unmarshal(imp.Ext, &ext); err != nil {
+			return nil, []error{err}
+		}
+		bidType := pbs.MEDIA_TYPE_BANNER
+		if imp.Video != nil {
+			bidType = pbs.MEDIA_TYPE_VIDEO
+		}
+		bidderResponse.Bids = append(bidderResponse.Bids, &adapters.TypedBid{
+			Bid:     &openrtb.Bid{ID: imp.ID + "-bid", ImpID: imp.ID, Price: ext.Bidder.Price, W: 300, H: 250, Ext: openrtb.RawJSON(`{"seat":"x"}`)},
+			BidType: bidType,
+		})
+	}
+	return bidderResponse, nil
+}
+
+func newTestExchange(t *testing.T) (*Exchange, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{
+		"alpha": &priceBidder{uri: server.URL},
+		"beta":  &priceBidder{uri: server.URL, currency: "USD"},
+		"euro":  &priceBidder{uri: server.URL, currency: "EUR"},
+	}, nil, nil, nil, nil, nil)
+	return e, server.Close
+}
+
+func parseRequest(t *testing.T, body string) *openrtb.BidRequest {
+	var request openrtb.BidRequest
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("Bad test request: %v", err)
+	}
+	return &request
+}
+
+func TestValidate(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	valid := `{"id":"req","site":{"page":"http://example.com"},"imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"alpha":{}}}]}`
+	if err := e.Validate(parseRequest(t, valid)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	aliased := `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha2":{}}}],"ext":{"prebid":{"aliases":{"alpha2":"alpha"}}}}`
+	if err := e.Validate(parseRequest(t, aliased)); err != nil {
+		t.Errorf("Unexpected error for an aliased bidder: %v", err)
+	}
+	skadn := `{"id":"req","app":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"skadn":{"sourceapp":"880047117","skadnetids":["cdkw7geqsh.skadnetwork"]}}}]}`
+	if err := e.Validate(parseRequest(t, skadn)); err != nil {
+		t.Errorf("Unexpected error for an imp with skadn: %v", err)
+	}
+
+	invalid := map[string]string{
+		"no id":           `{"site":{},"imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"alpha":{}}}]}`,
+		"no site or app":  `{"id":"req","imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"alpha":{}}}]}`,
+		"no imps":         `{"id":"req","site":{},"imp":[]}`,
+		"duplicate imps":  `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}},{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}]}`,
+		"no media type":   `{"id":"req","site":{},"imp":[{"id":"1","ext":{"alpha":{}}}]}`,
+		"no banner size":  `{"id":"req","site":{},"imp":[{"id":"1","banner":{},"ext":{"alpha":{}}}]}`,
+		"no video mimes":  `{"id":"req","site":{},"imp":[{"id":"1","video":{"mimes":[]},"ext":{"alpha":{}}}]}`,
+		"bad imp prebid":  `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"prebid":[]}}]}`,
+		"bad native":      `{"id":"req","site":{},"imp":[{"id":"1","native":{"request":"{\"assets\":[]}"},"ext":{"alpha":{}}}]}`,
+		"no bidders":      `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"prebid":{}}}]}`,
+		"unknown bidder":  `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"gamma":{}}}]}`,
+		"bad ranges":      `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"targeting":{"mediatypepricegranularity":{"video":{"ranges":[{"min":5,"max":1,"increment":1}]}}}}}}`,
+		"unknown alias":   `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha2":{}}}],"ext":{"prebid":{"aliases":{"alpha2":"gamma"}}}}`,
+		"bidder alias":    `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"beta":{}}}],"ext":{"prebid":{"aliases":{"beta":"alpha"}}}}`,
+		"bad granularity": `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"targeting":{"pricegranularity":"huge"}}}}`,
+		"skadn on a site": `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"skadn":{"sourceapp":"880047117","skadnetids":["a.skadnetwork"]}}}]}`,
+		"no skadnetids":   `{"id":"req","app":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"skadn":{"sourceapp":"880047117","skadnetids":[]}}}]}`,
+		"no sourceapp":    `{"id":"req","app":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"skadn":{"skadnetids":["a.skadnetwork"]}}}]}`,
+		"bad gpid":        `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"gpid":5}}]}`,
+		"bad pbadslot":    `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"data":{"pbadslot":[]}}}]}`,
+		"gpid alias":      `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"aliases":{"gpid":"alpha"}}}}`,
+		"skadn alias":     `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"aliases":{"skadn":"alpha"}}}}`,
+	}
+	for name, body := range invalid {
+		if err := e.Validate(parseRequest(t, body)); err == nil {
+			t.Errorf("Expected an error for a request with %s", name)
+		}
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	paths := map[string]string{
+		`{"site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}]}`:                                                                                               "/id",
+		`{"id":"req","site":{},"app":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}]}`:                                                                           "/app",
+		`{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}},{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}]}`:                           "/imp/1/id",
+		`{"id":"req","site":{},"imp":[{"id":"1","video":{"mimes":[]},"ext":{"alpha":{}}}]}`:                                                                                          "/imp/0/video/mimes",
+		`{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"gamma":{}}}]}`:                                                                                    "/imp/0/ext/gamma",
+		`{"id":"req","app":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"skadn":{"skadnetids":["a.skadnetwork"]}}}]}`:                                            "/imp/0/ext/skadn/sourceapp",
+		`{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{},"gpid":5}}]}`:                                                                           "/imp/0/ext/gpid",
+		`{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"aliases":{"a/b":"gamma"}}}}`:                                       "/ext/prebid/aliases/a~1b",
+		`{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{}}}],"ext":{"prebid":{"targeting":{"pricegranularity":{"ranges":[{"min":5,"max":1}]}}}}}`: "/ext/prebid/targeting/pricegranularity/ranges/0",
+	}
+	for body, path := range paths {
+		err := e.Validate(parseRequest(t, body))
+		if validationErr, ok := err.(*ValidationError); !ok || validationErr.Path != path {
+			t.Errorf("Expected an error at %s for %s. Got %v", path, body, err)
+		}
+	}
+}
+
+func TestValidateDOOH(t *testing.T) {
+	if err := ValidateDOOH([]byte(`{"id":"req","site":{},"dooh":null}`)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := ValidateDOOH([]byte(`{"id":"req","dooh":{"id":"screen"}}`)); err == nil || err.Error() != "/dooh: isn't supported. The request must have a site or an app" {
+		t.Errorf("DOOH requests should be rejected. Got %v", err)
+	}
+	if err := ValidateDOOH([]byte(`{"id":"req","app":{},"dooh":{"id":"screen"}}`)); err == nil || err.Error() != "/dooh: can't be sent with a site or an app" {
+		t.Errorf("DOOH should be exclusive with apps. Got %v", err)
+	}
+}
+
+func TestMakeNativeBid(t *testing.T) {
+	native, err := pbs.ParseNativeRequest(`{"assets":[{"id":1,"required":1,"title":{"len":90}}]}`)
+	if err != nil {
+		t.Fatalf("Bad test request: %v", err)
+	}
+	imps := map[string]bidderImp{"native": {native: native}, "banner": {}}
+	nativeBid := func(impID string, adm string) *adapters.TypedBid {
+		return &adapters.TypedBid{
+			Bid:     &openrtb.Bid{ID: "bid", ImpID: impID, Price: 1, AdM: adm},
+			BidType: pbs.MEDIA_TYPE_NATIVE,
+		}
+	}
+
+	bid, err := makeBid(nativeBid("native", `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}}]}`), imps)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ext ExtBid
+	if err := json.Unmarshal(bid.Ext, &ext); err != nil || ext.Prebid.Type != "native" {
+		t.Errorf("Expected ext.prebid.type native. Got %s", bid.Ext)
+	}
+
+	if _, err := makeBid(nativeBid("native", `{"link":{"url":"http://example.com"}}`), imps); err == nil {
+		t.Error("Native bids without the required assets should be rejected")
+	}
+	if _, err := makeBid(nativeBid("banner", `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}}]}`), imps); err == nil {
+		t.Error("Native bids on imps without a native request should be rejected")
+	}
+}
+
+func TestHoldAuction(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1.23},"beta":{"price":2.5},"euro":{"price":9}}},
+		{"id":"2","banner":{"w":300,"h":250},"ext":{"alpha":{"price":0.5}}}
+	],"ext":{"prebid":{"targeting":{}}}}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.ID != "req" || response.Cur != "USD" {
+		t.Errorf("Bad response: %#v", response)
+	}
+	if len(response.SeatBid) != 2 || response.SeatBid[0].Seat != "alpha" || response.SeatBid[1].Seat != "beta" {
+		t.Fatalf("Expected seats for alpha and beta. Got %#v", response.SeatBid)
+	}
+	if len(response.SeatBid[0].Bid) != 2 {
+		t.Fatalf("Expected 2 bids from alpha. Got %d", len(response.SeatBid[0].Bid))
+	}
+
+	targeting := func(bid openrtb.Bid) map[string]string {
+		var ext ExtBid
+		if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+			t.Fatalf("Bad bid ext: %v", err)
+		}
+		if ext.Prebid.Type != "banner" || string(ext.Bidder) != `{"seat":"x"}` {
+			t.Errorf("Bad bid ext: %s", bid.Ext)
+		}
+		return ext.Prebid.Targeting
+	}
+	for _, bid := range response.SeatBid[0].Bid {
+		keys := targeting(bid)
+		if bid.ImpID == "1" {
+			if keys["hb_pb_alpha"] != "1.20" || keys["hb_bidder"] != "" {
+				t.Errorf("alpha lost imp 1, and should only get its own keys. Got %v", keys)
+			}
+		} else if keys["hb_pb"] != "0.50" || keys["hb_bidder"] != "alpha" || keys["hb_size"] != "300x250" {
+			t.Errorf("alpha won imp 2. Got %v", keys)
+		}
+	}
+	if keys := targeting(response.SeatBid[1].Bid[0]); keys["hb_pb"] != "2.50" || keys["hb_bidder"] != "beta" || keys["hb_pb_beta"] != "2.50" {
+		t.Errorf("beta won imp 1. Got %v", keys)
+	}
+
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if len(ext.ResponseTimeMillis) != 3 {
+		t.Errorf("Expected response times for all 3 bidders. Got %v", ext.ResponseTimeMillis)
+	}
+	if len(ext.Errors["euro"]) != 1 || !strings.Contains(ext.Errors["euro"][0].Message, "EUR") {
+		t.Errorf("Bids in EUR should be rejected. Got %v", ext.Errors)
+	}
+}
+
+func TestHoldAuctionWithoutTargeting(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1}}}]}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ext ExtBid
+	if err := json.Unmarshal(response.SeatBid[0].Bid[0].Ext, &ext); err != nil {
+		t.Fatalf("Bad bid ext: %v", err)
+	}
+	if ext.Prebid.Targeting != nil {
+		t.Errorf("Bids shouldn't get targeting unless it was asked for. Got %v", ext.Prebid.Targeting)
+	}
+}
+
+func TestHoldAuctionInvalidParams(t *testing.T) {
+	schemaDirectory, err := ioutil.TempDir("", "bidder-params")
+	if err != nil {
+		t.Fatalf("Failed to make a schema directory: %v", err)
+	}
+	defer os.RemoveAll(schemaDirectory)
+	schema := `{"type":"object","properties":{"price":{"type":"number"}},"required":["price"]}`
+	if err := ioutil.WriteFile(filepath.Join(schemaDirectory, "alpha.json"), []byte(schema), 0644); err != nil {
+		t.Fatalf("Failed to write a schema: %v", err)
+	}
+	validator, err := adapters.NewParamsValidator(schemaDirectory)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+	e.paramsValidator = validator
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":"high"},"beta":{"price":2}}},
+		{"id":"2","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1}}}
+	]}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.SeatBid) != 2 || len(response.SeatBid[0].Bid) != 1 || response.SeatBid[0].Bid[0].ImpID != "2" {
+		t.Errorf("alpha should only bid on the imp with valid params. Got %#v", response.SeatBid)
+	}
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if errs := ext.Errors["alpha"]; len(errs) != 1 || errs[0].Code != errortypes.BadInputErrorCode || !strings.Contains(errs[0].Message, "imp 1") {
+		t.Errorf("Expected a bad input error for alpha's params on imp 1. Got %v", ext.Errors)
+	}
+}
+
+func TestHoldAuctionServerHints(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer slowServer.Close()
+	e.bidders["slow"] = &priceBidder{uri: slowServer.URL}
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"euro":{"price":2},"slow":{"price":3}}}
+	]}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	response, err := e.HoldAuction(ctx, request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if ext.Prebid == nil || ext.Prebid.Server == nil {
+		t.Fatalf("Expected ext.prebid.server. Got %s", response.Ext)
+	}
+	if server := ext.Prebid.Server; len(server.TimedOut) != 1 || server.TimedOut[0] != "slow" || len(server.Errored) != 1 || server.Errored[0] != "euro" {
+		t.Errorf("Expected slow to time out, and euro to fail. Got %#v", server)
+	}
+	if errs := ext.Errors["slow"]; len(errs) != 1 || errs[0].Code != errortypes.TimeoutErrorCode {
+		t.Errorf("Expected a timeout error for slow. Got %v", ext.Errors)
+	}
+
+	response, err = e.HoldAuction(context.Background(), parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1}}}
+	]}`), Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(string(response.Ext), `"prebid"`) {
+		t.Errorf("Auctions where every bidder answered shouldn't have ext.prebid.server. Got %s", response.Ext)
+	}
+}
+
+// recordingBidder keeps the requests which it was called with.
+type recordingBidder struct {
+	priceBidder
+	lock     sync.Mutex
+	requests []*openrtb.BidRequest
+}
+
+func (b *recordingBidder) MakeRequests(request *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	b.lock.Lock()
+	b.requests = append(b.requests, request)
+	b.lock.Unlock()
+	return b.priceBidder.MakeRequests(request)
+}
+
+func TestHoldAuctionStripFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil,
+		map[string][]string{"alpha": {"user.geo", "site.keywords", "imp.tagid"}}, nil, nil)
+
+	request := parseRequest(t, `{"id":"req","site":{"page":"http://example.com","keywords":"cars"},"user":{"id":"u1","geo":{"country":"USA"}},"imp":[
+		{"id":"1","tagid":"top","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}}
+	]}`)
+	if _, err := e.HoldAuction(context.Background(), request, Activities{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(alpha.requests) != 1 || len(beta.requests) != 1 {
+		t.Fatalf("Expected one request to each bidder. Got %d and %d", len(alpha.requests), len(beta.requests))
+	}
+	stripped := alpha.requests[0]
+	if stripped.User == nil || stripped.User.ID != "u1" || stripped.User.Geo != nil {
+		t.Errorf("alpha should get the user without its geo. Got %#v", stripped.User)
+	}
+	if stripped.Site == nil || stripped.Site.Page != "http://example.com" || stripped.Site.Keywords != "" {
+		t.Errorf("alpha should get the site without its keywords. Got %#v", stripped.Site)
+	}
+	if stripped.Imp[0].TagID != "" || stripped.Imp[0].Banner == nil {
+		t.Errorf("alpha should get its imps without their tagid. Got %#v", stripped.Imp[0])
+	}
+	if full := beta.requests[0]; full.User.Geo == nil || full.Site.Keywords != "cars" || full.Imp[0].TagID != "top" {
+		t.Errorf("beta should get every field. Got %#v and %#v", full.User, full.Site)
+	}
+	if request.User.Geo == nil || request.Site.Keywords != "cars" {
+		t.Error("The original request shouldn't be changed")
+	}
+}
+
+func TestHoldAuctionMediaTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	registry := adapters.NewBidderRegistry(adapters.BidderInfos{
+		"alpha": {Capabilities: &adapters.CapabilitiesInfo{Site: &adapters.PlatformInfo{MediaTypes: []string{"banner"}}}},
+		"beta":  {Capabilities: &adapters.CapabilitiesInfo{Site: &adapters.PlatformInfo{MediaTypes: []string{"banner", "video"}}}},
+	}, nil)
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, nil, registry)
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}},
+		{"id":"2","video":{"mimes":["video/mp4"]},"ext":{"alpha":{"price":1},"beta":{"price":2}}},
+		{"id":"3","banner":{"w":300,"h":250},"video":{"mimes":["video/mp4"]},"ext":{"alpha":{"price":1}}}
+	]}`)
+	if _, err := e.HoldAuction(context.Background(), request, Activities{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(alpha.requests) != 1 || len(alpha.requests[0].Imp) != 2 || alpha.requests[0].Imp[0].ID != "1" || alpha.requests[0].Imp[1].ID != "3" {
+		t.Errorf("alpha should only get the imps with a banner. Got %#v", alpha.requests)
+	}
+	if len(beta.requests) != 1 || len(beta.requests[0].Imp) != 2 {
+		t.Errorf("beta should get both of its imps. Got %#v", beta.requests)
+	}
+}
+
+func TestHoldAuctionGPID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, nil, nil)
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2},"gpid":"/1111/home#top","data":{"pbadslot":"/1111/home","keywords":"cars"}}},
+		{"id":"2","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2},"data":{"pbadslot":"/1111/side"}}},
+		{"id":"div-3","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}}
+	]}`)
+	if err := e.Validate(request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := e.HoldAuction(context.Background(), request, Activities{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"1":     `{"bidder":%s,"gpid":"/1111/home#top","data":{"pbadslot":"/1111/home"}}`,
+		"2":     `{"bidder":%s,"gpid":"/1111/side","data":{"pbadslot":"/1111/side"}}`,
+		"div-3": `{"bidder":%s,"gpid":"div-3"}`,
+	}
+	for bidder, recorded := range map[string]*recordingBidder{"alpha": alpha, "beta": beta} {
+		if len(recorded.requests) != 1 || len(recorded.requests[0].Imp) != 3 {
+			t.Fatalf("Expected one request with every imp for %s. Got %#v", bidder, recorded.requests)
+		}
+		for _, imp := range recorded.requests[0].Imp {
+			var params struct {
+				Bidder json.RawMessage `json:"bidder"`
+			}
+			json.Unmarshal(imp.Ext, &params)
+			if want := fmt.Sprintf(expected[imp.ID], params.Bidder); string(imp.Ext) != want {
+				t.Errorf("%s should get imp %s's gpid and pbadslot. Expected %s. Got %s", bidder, imp.ID, want, imp.Ext)
+			}
+		}
+	}
+}
+
+func TestHoldAuctionGDPR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	enforcer, _ := gdpr.NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1, 2}}, map[string]uint16{"alpha": 32, "beta": 52}, nil)
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, enforcer, nil)
+
+	// The consent string allows purposes 1 and 2 for vendor 32 only, without precise geolocation.
+	request := parseRequest(t, `{"id":"req","site":{"page":"http://example.com"},"device":{"ip":"192.0.2.55"},
+		"user":{"id":"u1","ext":{"consent":"CAAAAAAAAAAAAABABBAAABCAAMAAAAAAAAAAAQAAAAAEAAA"}},"regs":{"ext":{"gdpr":1}},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}}
+	]}`)
+	if _, err := e.HoldAuction(context.Background(), request, Activities{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(alpha.requests) != 1 || len(beta.requests) != 1 {
+		t.Fatalf("Expected one request to each bidder. Got %d and %d", len(alpha.requests), len(beta.requests))
+	}
+	if consented := alpha.requests[0]; consented.User.ID != "u1" || consented.Device.IP != "192.0.2.0" {
+		t.Errorf("alpha should get the user's ID, but not the precise IP. Got %#v and %#v", consented.User, consented.Device)
+	}
+	if restricted := beta.requests[0]; restricted.User.ID != "" || restricted.Device.IP != "192.0.2.0" {
+		t.Errorf("beta shouldn't get the user's ID or precise IP. Got %#v and %#v", restricted.User, restricted.Device)
+	}
+	if request.User.ID != "u1" || request.Device.IP != "192.0.2.55" {
+		t.Error("The original request shouldn't be changed")
+	}
+}
+
+func TestHoldAuctionActivities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, nil, nil)
+
+	deny := func(channels ...string) config.Activity {
+		return config.Activity{Rules: []config.ActivityRule{{Condition: config.ActivityCondition{ComponentNames: []string{"beta"}, Channels: channels}}}}
+	}
+	account := config.Account{Activities: map[string]config.Activity{
+		config.ActivityFetchBids:          deny("amp"),
+		config.ActivityTransmitUFPD:       deny(),
+		config.ActivityTransmitPreciseGeo: deny(),
+	}}
+	run := func(channel string) *openrtb.BidResponse {
+		request := parseRequest(t, `{"id":"req","site":{"page":"http://example.com"},"device":{"ip":"192.0.2.55"},"user":{"id":"u1"},"imp":[
+			{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}}
+		]}`)
+		response, err := e.HoldAuction(context.Background(), request, Activities{Account: account, Channel: channel})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return response
+	}
+
+	run("pbjs")
+	if len(alpha.requests) != 1 || len(beta.requests) != 1 {
+		t.Fatalf("Expected one request to each bidder. Got %d and %d", len(alpha.requests), len(beta.requests))
+	}
+	if allowed := alpha.requests[0]; allowed.User.ID != "u1" || allowed.Device.IP != "192.0.2.55" {
+		t.Errorf("alpha should get the user's ID and precise IP. Got %#v and %#v", allowed.User, allowed.Device)
+	}
+	if denied := beta.requests[0]; denied.User.ID != "" || denied.Device.IP != "192.0.2.0" {
+		t.Errorf("beta shouldn't get the user's ID or precise IP. Got %#v and %#v", denied.User, denied.Device)
+	}
+
+	response := run("amp")
+	if len(alpha.requests) != 2 || len(beta.requests) != 1 {
+		t.Errorf("beta shouldn't be called on AMP. Got %d requests to alpha and %d to beta", len(alpha.requests), len(beta.requests))
+	}
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if errs := ext.Errors["beta"]; len(errs) != 1 || errs[0].Message != "Denied by the account's activity controls" {
+		t.Errorf("Expected beta to be reported as denied. Got %v", ext.Errors)
+	}
+}
+
+func TestHoldAuctionGPP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	beta := &recordingBidder{priceBidder: priceBidder{uri: server.URL}}
+	enforcer, _ := gdpr.NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1, 2}}, map[string]uint16{"alpha": 32, "beta": 52}, nil)
+	site := &adapters.CapabilitiesInfo{Site: &adapters.PlatformInfo{MediaTypes: []string{"banner"}}}
+	registry := adapters.NewBidderRegistry(adapters.BidderInfos{"alpha": {Capabilities: site, GPP: true}, "beta": {Capabilities: site}}, nil)
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, enforcer, registry)
+
+	// The GPP string's TCF EU v2 section is the consent string from TestHoldAuctionGDPR.
+	gpp := "DBACNY~CAAAAAAAAAAAAABABBAAABCAAMAAAAAAAAAAAQAAAAAEAAA~1YN-"
+	request := parseRequest(t, `{"id":"req","site":{"page":"http://example.com"},"user":{"id":"u1"},
+		"regs":{"ext":{"gpp":"`+gpp+`","gpp_sid":[2,6]}},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2}}}
+	]}`)
+	if _, err := e.HoldAuction(context.Background(), request, Activities{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(alpha.requests) != 1 || len(beta.requests) != 1 {
+		t.Fatalf("Expected one request to each bidder. Got %d and %d", len(alpha.requests), len(beta.requests))
+	}
+	if raw := alpha.requests[0]; string(raw.Regs.Ext) != `{"gpp":"`+gpp+`","gpp_sid":[2,6]}` || raw.User.ID != "u1" {
+		t.Errorf("alpha should get the raw GPP string, and the user's ID. Got %s and %#v", raw.Regs.Ext, raw.User)
+	}
+	downgraded := beta.requests[0]
+	if string(downgraded.Regs.Ext) != `{"gdpr":1,"us_privacy":"1YN-"}` || string(downgraded.User.Ext) != `{"consent":"CAAAAAAAAAAAAABABBAAABCAAMAAAAAAAAAAAQAAAAAEAAA"}` {
+		t.Errorf("beta should get the GPP sections as the older signals. Got %s and %s", downgraded.Regs.Ext, downgraded.User.Ext)
+	}
+	if downgraded.User.ID != "" {
+		t.Errorf("beta shouldn't get the user's ID. Got %#v", downgraded.User)
+	}
+	if len(request.User.Ext) != 0 {
+		t.Error("The original request shouldn't be changed")
+	}
+}
+
+func TestHoldAuctionAliases(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"alpha2":{"price":3}}}
+	],"ext":{"prebid":{"aliases":{"alpha2":"alpha"}}}}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.SeatBid) != 2 || response.SeatBid[0].Seat != "alpha" || response.SeatBid[1].Seat != "alpha2" {
+		t.Fatalf("Expected seats for alpha and its alias. Got %#v", response.SeatBid)
+	}
+	if price := response.SeatBid[1].Bid[0].Price; price != 3 {
+		t.Errorf("The alias should bid with its own params. Got %f", price)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2},"prebid":{"passthrough":{"slot":"top","n":[1,2]}}}},
+		{"id":"2","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1}}}
+	],"ext":{"prebid":{"passthrough":{"wrapper":"abc"}}}}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, seatBid := range response.SeatBid {
+		for _, bid := range seatBid.Bid {
+			var ext ExtBid
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				t.Fatalf("Bad bid ext: %v", err)
+			}
+			expected := ""
+			if bid.ImpID == "1" {
+				expected = `{"slot":"top","n":[1,2]}`
+			}
+			if string(ext.Prebid.Passthrough) != expected {
+				t.Errorf("Bad passthrough on %s's bid for imp %s: %s", seatBid.Seat, bid.ImpID, ext.Prebid.Passthrough)
+			}
+		}
+	}
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if ext.Prebid == nil || string(ext.Prebid.Passthrough) != `{"wrapper":"abc"}` {
+		t.Errorf("Expected the request's passthrough in the response ext. Got %s", response.Ext)
+	}
+}
+
+func TestMediaTypePriceGranularity(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	request := parseRequest(t, `{"id":"req","site":{},"imp":[
+		{"id":"display","banner":{"w":300,"h":250},"ext":{"alpha":{"price":13.37}}},
+		{"id":"preroll","video":{"mimes":["video/mp4"]},"ext":{"alpha":{"price":13.37}}}
+	],"ext":{"prebid":{"targeting":{
+		"pricegranularity":"dense",
+		"mediatypepricegranularity":{"video":{"precision":1,"ranges":[{"min":0,"max":50,"increment":5}]}}
+	}}}}`)
+	if err := e.Validate(request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"display": "13.00", "preroll": "10.0"}
+	for _, bid := range response.SeatBid[0].Bid {
+		var ext ExtBid
+		if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+			t.Fatalf("Bad bid ext: %v", err)
+		}
+		if ext.Prebid.Targeting["hb_pb"] != expected[bid.ImpID] {
+			t.Errorf("Expected hb_pb %s for %s. Got %s", expected[bid.ImpID], bid.ImpID, ext.Prebid.Targeting["hb_pb"])
+		}
+	}
+}
+
+func TestTargetingTieBreak(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	winner := func(request *openrtb.BidRequest) string {
+		response, err := e.HoldAuction(context.Background(), request, Activities{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, seatBid := range response.SeatBid {
+			var ext ExtBid
+			if err := json.Unmarshal(seatBid.Bid[0].Ext, &ext); err != nil {
+				t.Fatalf("Bad bid ext: %v", err)
+			}
+			if bidder := ext.Prebid.Targeting["hb_bidder"]; bidder != "" {
+				return bidder
+			}
+		}
+		t.Fatal("Expected one of the bids to win")
+		return ""
+	}
+	const tied = `{"id":"req","test":1,"site":{},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":2},"beta":{"price":2}}}],"ext":{"prebid":{"targeting":{}}}}`
+
+	e.tieBreaker, _ = pbs.NewTieBreaker(pbs.TieBreakBidderPriority, []string{"beta", "alpha"})
+	if bidder := winner(parseRequest(t, tied)); bidder != "beta" {
+		t.Errorf("beta has priority, and should win the tie. Got %s", bidder)
+	}
+
+	e.tieBreaker, _ = pbs.NewTieBreaker(pbs.TieBreakRandom, nil)
+	first := winner(parseRequest(t, tied))
+	for i := 0; i < 10; i++ {
+		if bidder := winner(parseRequest(t, tied)); bidder != first {
+			t.Fatalf("Random tie breaks should be repeatable in test mode. Got %s and then %s", first, bidder)
+		}
+	}
+}
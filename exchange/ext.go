@@ -0,0 +1,185 @@
+package exchange
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// ExtRequest is the request.ext which Prebid Server reads. Bidders don't get it.
+type ExtRequest struct {
+	Prebid ExtRequestPrebid `json:"prebid"`
+}
+
+type ExtRequestPrebid struct {
+	// Targeting asks for ad server targeting keys on the bids. Without it, bids have none.
+	Targeting *ExtRequestTargeting `json:"targeting,omitempty"`
+	// Passthrough is echoed untouched in response.ext.prebid.passthrough.
+	Passthrough json.RawMessage `json:"passthrough,omitempty"`
+	// Aliases maps alias bidder codes onto the bidders whose adapters they use, like {"appnexus2": "appnexus"}.
+	// Imps can then have params for both, and the alias bids in its own seat.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+type ExtRequestTargeting struct {
+	// PriceGranularity defaults to "med".
+	PriceGranularity *PriceGranularity `json:"pricegranularity,omitempty"`
+	// MediaTypePriceGranularity overrides PriceGranularity for some media types.
+	MediaTypePriceGranularity *ExtMediaTypePriceGranularity `json:"mediatypepricegranularity,omitempty"`
+}
+
+// ExtMediaTypePriceGranularity sets the price granularity for each media type. Video usually needs
+// bigger buckets, with a higher max, than banner.
+type ExtMediaTypePriceGranularity struct {
+	Banner *PriceGranularity `json:"banner,omitempty"`
+	Video  *PriceGranularity `json:"video,omitempty"`
+	Native *PriceGranularity `json:"native,omitempty"`
+}
+
+// PriceGranularity is either the name of one of the standard granularities ("low", "med", "high",
+// "auto" or "dense"), or an object with custom ranges.
+type PriceGranularity struct {
+	Name   string
+	Custom *pbs.PriceGranularity
+}
+
+func (g *PriceGranularity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &g.Name)
+	}
+	g.Custom = &pbs.PriceGranularity{}
+	return json.Unmarshal(data, g.Custom)
+}
+
+func (g *PriceGranularity) MarshalJSON() ([]byte, error) {
+	if g.Custom != nil {
+		return json.Marshal(g.Custom)
+	}
+	return json.Marshal(g.Name)
+}
+
+// ExtImp is an imp.ext. Each key is a bidder's code, and its value is that bidder's params for the imp.
+// The "prebid", "skadn", "gpid" and "data" keys are reserved for an ExtImpPrebid, an ExtImpSKAdN, the Global
+// Placement ID and an ExtImpData.
+type ExtImp map[string]json.RawMessage
+
+type ExtImpPrebid struct {
+	// Passthrough is echoed untouched in the ext.prebid.passthrough of each bid on the imp.
+	Passthrough json.RawMessage `json:"passthrough,omitempty"`
+}
+
+// ExtImpSKAdN is Apple's SKAdNetwork extension. iOS apps send it so that bidders can sign their ads for
+// install attribution. Only bidders which declare SKAdNetwork support in their bidder info get it.
+type ExtImpSKAdN struct {
+	Version  string   `json:"version,omitempty"`
+	Versions []string `json:"versions,omitempty"`
+	// SourceApp is the App Store ID of the app which is showing the ad.
+	SourceApp string `json:"sourceapp"`
+	// SKAdNetIDs are the ad networks which the app lists in its Info.plist.
+	SKAdNetIDs []string `json:"skadnetids"`
+}
+
+// ExtBidSKAdN is the signed SKAdNetwork payload which a bidder returns in bid.ext.skadn.
+// SKAdNetwork 4.0 replaced the campaign with a sourceidentifier.
+type ExtBidSKAdN struct {
+	Version          string `json:"version"`
+	Network          string `json:"network"`
+	Campaign         string `json:"campaign,omitempty"`
+	SourceIdentifier string `json:"sourceidentifier,omitempty"`
+	ITunesItem       string `json:"itunesitem"`
+	Nonce            string `json:"nonce"`
+	SourceApp        string `json:"sourceapp"`
+	Timestamp        string `json:"timestamp"`
+	Signature        string `json:"signature"`
+}
+
+// ExtBid is the ext of each bid in the response.
+type ExtBid struct {
+	// Bidder is the ext which the bidder sent with the bid, if any.
+	Bidder json.RawMessage `json:"bidder,omitempty"`
+	Prebid ExtBidPrebid    `json:"prebid"`
+	// SKAdN is the bidder's bid.ext.skadn, copied as-is so that its signature still matches.
+	SKAdN json.RawMessage `json:"skadn,omitempty"`
+}
+
+type ExtBidPrebid struct {
+	// Type is "banner", "video" or "native".
+	Type        string             `json:"type"`
+	Targeting   map[string]string  `json:"targeting,omitempty"`
+	Video       *ExtBidPrebidVideo `json:"video,omitempty"`
+	Passthrough json.RawMessage    `json:"passthrough,omitempty"`
+}
+
+// ExtBidPrebidVideo is set on video bids whose bidders sent an adapters.BidVideo.
+type ExtBidPrebidVideo struct {
+	Duration        int    `json:"duration"`
+	PrimaryCategory string `json:"primary_category"`
+}
+
+// ExtBidResponse is the response.ext.
+type ExtBidResponse struct {
+	// Errors is keyed by bidder code, or "prebid" for problems which aren't any one bidder's.
+	Errors map[string][]pbs.ExtResponseMessage `json:"errors,omitempty"`
+	// ResponseTimeMillis is how long each bidder took to answer.
+	ResponseTimeMillis map[string]int     `json:"responsetimemillis"`
+	Prebid             *ExtResponsePrebid `json:"prebid,omitempty"`
+}
+
+// addServerHints sets ext.prebid.server, unless it would be empty.
+func (ext *ExtBidResponse) addServerHints(server ExtResponseServer) {
+	if len(server.TimedOut) == 0 && len(server.Errored) == 0 {
+		return
+	}
+	sort.Strings(server.TimedOut)
+	sort.Strings(server.Errored)
+	if ext.Prebid == nil {
+		ext.Prebid = &ExtResponsePrebid{}
+	}
+	ext.Prebid.Server = &server
+}
+
+func (ext *ExtBidResponse) addErrors(bidder string, errs []error) {
+	for _, err := range errs {
+		if ext.Errors == nil {
+			ext.Errors = make(map[string][]pbs.ExtResponseMessage)
+		}
+		ext.Errors[bidder] = append(ext.Errors[bidder], pbs.ExtResponseMessage{
+			Code:    errortypes.ReadCode(err),
+			Message: err.Error(),
+		})
+	}
+}
+
+type ExtResponsePrebid struct {
+	// Passthrough is the request's ext.prebid.passthrough.
+	Passthrough json.RawMessage `json:"passthrough,omitempty"`
+	// Server is only set if some bidders didn't bid because they timed out or failed.
+	Server *ExtResponseServer `json:"server,omitempty"`
+}
+
+// ExtResponseServer tells hybrid wrappers, like Prebid.js with both client and server bidders, which bidders
+// couldn't take part in the server-side auction. The wrapper may call them client-side instead. Bidders which
+// bid despite some errors aren't listed. Both lists are sorted.
+type ExtResponseServer struct {
+	// TimedOut lists the bidders which didn't answer before the auction's deadline.
+	TimedOut []string `json:"timedout,omitempty"`
+	// Errored lists the bidders which failed for any other reason, like invalid params or a bad response.
+	Errored []string `json:"errored,omitempty"`
+}
+
+// add lists the bidder if any of the errs, which kept it from bidding, is fatal. Timeouts take precedence.
+func (server *ExtResponseServer) add(bidder string, errs []error) {
+	fatal := false
+	for _, err := range errs {
+		if errortypes.ReadCode(err) == errortypes.TimeoutErrorCode {
+			server.TimedOut = append(server.TimedOut, bidder)
+			return
+		}
+		fatal = fatal || errortypes.ReadSeverity(err) == errortypes.SeverityFatal
+	}
+	if fatal {
+		server.Errored = append(server.Errored, bidder)
+	}
+}
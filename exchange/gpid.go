@@ -0,0 +1,49 @@
+package exchange
+
+import (
+	"encoding/json"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// ExtImpData is the imp's first party data. Only the pbadslot is passed on to bidders.
+type ExtImpData struct {
+	// PBAdSlot is the ad slot's path in the publisher's ad server, like /1111/homepage/top-banner.
+	PBAdSlot string `json:"pbadslot,omitempty"`
+}
+
+// isReservedImpExtKey returns true if the key in imp.ext isn't a bidder code.
+func isReservedImpExtKey(key string) bool {
+	switch key {
+	case "prebid", "skadn", "gpid", "data":
+		return true
+	}
+	return false
+}
+
+// parseAdSlot returns the imp's Global Placement ID and its ext.data.pbadslot. The GPID defaults to the
+// pbadslot, and then to the imp ID, which Prebid.js sets to the ad unit code.
+func parseAdSlot(imp *openrtb.Imp, ext ExtImp) (string, *ExtImpData, error) {
+	var gpid string
+	if rawGPID, ok := ext["gpid"]; ok {
+		if err := json.Unmarshal(rawGPID, &gpid); err != nil {
+			return "", nil, invalidField("/gpid", "must be a string: %v", err)
+		}
+	}
+	var data *ExtImpData
+	if rawData, ok := ext["data"]; ok {
+		if err := json.Unmarshal(rawData, &data); err != nil {
+			return "", nil, invalidField("/data", "is invalid: %v", err)
+		}
+		if data != nil && data.PBAdSlot == "" {
+			data = nil
+		}
+	}
+	if gpid == "" && data != nil {
+		gpid = data.PBAdSlot
+	}
+	if gpid == "" {
+		gpid = imp.ID
+	}
+	return gpid, data, nil
+}
@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (skadn *ExtImpSKAdN) validate() error {
+	if skadn.SourceApp == "" {
+		return invalidField("/sourceapp", "must be a non-empty string")
+	}
+	if len(skadn.SKAdNetIDs) == 0 {
+		return invalidField("/skadnetids", "must contain at least one element")
+	}
+	return nil
+}
+
+// parseBidSKAdN returns the bid.ext.skadn from the bidder's ext, or nil if it doesn't have one.
+// The payload must have every field which the app needs to verify it, and be for one of the ad networks
+// in the imp's skadnetids.
+func parseBidSKAdN(bidExt json.RawMessage, impSKAdN *ExtImpSKAdN) (json.RawMessage, error) {
+	if len(bidExt) == 0 {
+		return nil, nil
+	}
+	var ext struct {
+		SKAdN json.RawMessage `json:"skadn"`
+	}
+	if err := json.Unmarshal(bidExt, &ext); err != nil || len(ext.SKAdN) == 0 || string(ext.SKAdN) == "null" {
+		return nil, nil
+	}
+	if impSKAdN == nil {
+		return nil, errors.New("its imp didn't ask for SKAdNetwork")
+	}
+	var skadn ExtBidSKAdN
+	if err := json.Unmarshal(ext.SKAdN, &skadn); err != nil {
+		return nil, err
+	}
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"version", skadn.Version},
+		{"network", skadn.Network},
+		{"itunesitem", skadn.ITunesItem},
+		{"nonce", skadn.Nonce},
+		{"sourceapp", skadn.SourceApp},
+		{"timestamp", skadn.Timestamp},
+		{"signature", skadn.Signature},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			return nil, fmt.Errorf("%s must be a non-empty string", field.name)
+		}
+	}
+	if skadn.Campaign == "" && skadn.SourceIdentifier == "" {
+		return nil, errors.New("campaign or sourceidentifier must be a non-empty string")
+	}
+	if skadn.SourceApp != impSKAdN.SourceApp {
+		return nil, fmt.Errorf("sourceapp %s doesn't match the imp's %s", skadn.SourceApp, impSKAdN.SourceApp)
+	}
+	for _, network := range impSKAdN.SKAdNetIDs {
+		if strings.EqualFold(network, skadn.Network) {
+			return ext.SKAdN, nil
+		}
+	}
+	return nil, fmt.Errorf("network %s isn't one of the imp's skadnetids", skadn.Network)
+}
@@ -0,0 +1,116 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/adapters"
+)
+
+const signedSKAdN = `{"version":"2.0","network":"cDkw7geqsh.skadnetwork","campaign":"45","itunesitem":"123456789","nonce":"473b1a16","sourceapp":"880047117","timestamp":"1594406341","signature":"MEQCIEQ"}`
+
+func TestParseBidSKAdN(t *testing.T) {
+	imp := &ExtImpSKAdN{SourceApp: "880047117", SKAdNetIDs: []string{"cdkw7geqsh.skadnetwork"}}
+
+	skadn, err := parseBidSKAdN(json.RawMessage(`{"skadn":`+signedSKAdN+`}`), imp)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(skadn) != signedSKAdN {
+		t.Errorf("The payload should be copied as-is. Got %s", skadn)
+	}
+	if skadn, err := parseBidSKAdN(json.RawMessage(`{"seat":"x"}`), nil); skadn != nil || err != nil {
+		t.Errorf("Bids without skadn should be allowed. Got %s and %v", skadn, err)
+	}
+	sourceIdentifier := strings.Replace(signedSKAdN, `"campaign"`, `"sourceidentifier"`, 1)
+	if _, err := parseBidSKAdN(json.RawMessage(`{"skadn":`+sourceIdentifier+`}`), imp); err != nil {
+		t.Errorf("A sourceidentifier should replace the campaign. Got %v", err)
+	}
+
+	invalid := map[string]string{
+		"no skadn on the imp": signedSKAdN,
+		"no signature":        strings.Replace(signedSKAdN, `"signature":"MEQCIEQ"`, `"signature":""`, 1),
+		"no campaign":         strings.Replace(signedSKAdN, `"campaign":"45",`, ``, 1),
+		"another sourceapp":   strings.Replace(signedSKAdN, `"sourceapp":"880047117"`, `"sourceapp":"1"`, 1),
+		"another network":     strings.Replace(signedSKAdN, `cDkw7geqsh`, `other`, 1),
+		"bad json":            `[]`,
+	}
+	for name, payload := range invalid {
+		impSKAdN := imp
+		if name == "no skadn on the imp" {
+			impSKAdN = nil
+		}
+		if _, err := parseBidSKAdN(json.RawMessage(`{"skadn":`+payload+`}`), impSKAdN); err == nil {
+			t.Errorf("Expected an error for a bid with %s", name)
+		}
+	}
+}
+
+// skadnBidder puts its payload in the bid.ext.skadn of every bid.
+type skadnBidder struct {
+	recordingBidder
+	payload string
+}
+
+func (b *skadnBidder) MakeBids(request *openrtb.BidRequest, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	bidderResponse, errs := b.recordingBidder.MakeBids(request, response)
+	for _, bid := range bidderResponse.Bids {
+		bid.Bid.Ext = openrtb.RawJSON(`{"skadn":` + b.payload + `}`)
+	}
+	return bidderResponse, errs
+}
+
+func TestHoldAuctionSKAdN(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	alpha := &skadnBidder{recordingBidder: recordingBidder{priceBidder: priceBidder{uri: server.URL}}, payload: signedSKAdN}
+	beta := &skadnBidder{recordingBidder: recordingBidder{priceBidder: priceBidder{uri: server.URL}}, payload: signedSKAdN}
+	registry := adapters.NewBidderRegistry(adapters.BidderInfos{
+		"alpha": {Capabilities: &adapters.CapabilitiesInfo{App: &adapters.PlatformInfo{MediaTypes: []string{"banner"}, SKAdN: true}}},
+		"beta":  {Capabilities: &adapters.CapabilitiesInfo{App: &adapters.PlatformInfo{MediaTypes: []string{"banner"}}}},
+	}, nil)
+	e := New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"alpha": alpha, "beta": beta}, nil, nil, nil, nil, registry)
+
+	request := parseRequest(t, `{"id":"req","app":{"bundle":"880047117"},"imp":[
+		{"id":"1","banner":{"w":300,"h":250},"ext":{"alpha":{"price":1},"beta":{"price":2},"skadn":{"version":"2.0","sourceapp":"880047117","skadnetids":["cdkw7geqsh.skadnetwork"]}}}
+	]}`)
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(alpha.requests) != 1 || len(beta.requests) != 1 {
+		t.Fatalf("Expected one request to each bidder. Got %d and %d", len(alpha.requests), len(beta.requests))
+	}
+	if ext := string(alpha.requests[0].Imp[0].Ext); !strings.Contains(ext, `"skadn"`) {
+		t.Errorf("alpha supports SKAdNetwork, so it should get the imp's skadn. Got %s", ext)
+	}
+	if ext := string(beta.requests[0].Imp[0].Ext); ext != `{"bidder":{"price":2},"gpid":"1"}` {
+		t.Errorf("beta doesn't support SKAdNetwork, so it shouldn't get the skadn. Got %s", ext)
+	}
+
+	if len(response.SeatBid) != 1 || response.SeatBid[0].Seat != "alpha" {
+		t.Fatalf("Only alpha's signed bid should be in the response. Got %#v", response.SeatBid)
+	}
+	var bidExt ExtBid
+	if err := json.Unmarshal(response.SeatBid[0].Bid[0].Ext, &bidExt); err != nil {
+		t.Fatalf("Bad bid ext: %v", err)
+	}
+	if string(bidExt.SKAdN) != signedSKAdN {
+		t.Errorf("Expected the signed payload in ext.skadn. Got %s", bidExt.SKAdN)
+	}
+	var ext ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &ext); err != nil {
+		t.Fatalf("Bad response ext: %v", err)
+	}
+	if errs := ext.Errors["beta"]; len(errs) != 1 || !strings.Contains(errs[0].Message, "skadn") {
+		t.Errorf("Expected an error for beta's bid on an imp which it didn't get the skadn for. Got %v", ext.Errors)
+	}
+}
@@ -0,0 +1,167 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+const defaultPriceGranularity = "med"
+
+// Targeting keys. Each bid gets the keys suffixed with its bidder's code, and the top bid for each imp
+// gets the plain keys too.
+const (
+	hbpbKey     = "hb_pb"
+	hbBidderKey = "hb_bidder"
+	hbSizeKey   = "hb_size"
+	hbDealKey   = "hb_deal"
+)
+
+// validate returns an error if any of the granularities are unknown or have bad ranges.
+func (t *ExtRequestTargeting) validate() error {
+	if err := t.PriceGranularity.validate(); err != nil {
+		return underPath("/pricegranularity", err)
+	}
+	if mediaTypes := t.MediaTypePriceGranularity; mediaTypes != nil {
+		for _, mediaType := range []string{"banner", "video", "native"} {
+			if err := t.granularity(mediaType).validate(); err != nil {
+				return underPath("/mediatypepricegranularity/"+mediaType, err)
+			}
+		}
+	}
+	return nil
+}
+
+// granularity returns the price granularity for bids of the media type.
+func (t *ExtRequestTargeting) granularity(mediaType string) *PriceGranularity {
+	if mediaTypes := t.MediaTypePriceGranularity; mediaTypes != nil {
+		var granularity *PriceGranularity
+		switch mediaType {
+		case "banner":
+			granularity = mediaTypes.Banner
+		case "video":
+			granularity = mediaTypes.Video
+		case "native":
+			granularity = mediaTypes.Native
+		}
+		if granularity != nil {
+			return granularity
+		}
+	}
+	return t.PriceGranularity
+}
+
+func (g *PriceGranularity) validate() error {
+	if g == nil {
+		return nil
+	}
+	if g.Custom == nil {
+		if _, ok := pbs.GetPriceBucketString(0)[g.Name]; !ok && g.Name != "" {
+			return invalidField("", "%q is not supported", g.Name)
+		}
+		return nil
+	}
+	if len(g.Custom.Ranges) == 0 {
+		return invalidField("/ranges", "must have at least one range")
+	}
+	if g.Custom.Precision < 0 {
+		return invalidField("/precision", "can't be negative")
+	}
+	for i, priceRange := range g.Custom.Ranges {
+		if priceRange.Max <= priceRange.Min || priceRange.Increment <= 0 {
+			return invalidField(fmt.Sprintf("/ranges/%d", i), "needs a max above its min, and a positive increment")
+		}
+	}
+	return nil
+}
+
+// bucket returns the price bucket string for the cpm. A nil granularity is the default.
+func (g *PriceGranularity) bucket(cpm float64) string {
+	if g == nil || (g.Custom == nil && g.Name == "") {
+		return pbs.GetPriceBucketString(cpm)[defaultPriceGranularity]
+	}
+	if g.Custom != nil {
+		return pbs.GetCustomPriceBucket(cpm, *g.Custom)
+	}
+	return pbs.GetPriceBucketString(cpm)[g.Name]
+}
+
+// addTargeting sets ext.prebid.targeting on every bid. Ties for the top bid on each imp are broken by the
+// exchange's tieBreaker, using the bidders' response times. If the seed isn't empty, it makes random tie
+// breaks repeatable.
+func (e *Exchange) addTargeting(seatBids []openrtb.SeatBid, targeting *ExtRequestTargeting, responseTimes map[string]int, seed string) error {
+	impBids := make(map[string][]*openrtb.Bid)
+	impCandidates := make(map[string][]pbs.TieBreakBid)
+	for i := range seatBids {
+		seat := seatBids[i].Seat
+		for j := range seatBids[i].Bid {
+			bid := &seatBids[i].Bid[j]
+			impBids[bid.ImpID] = append(impBids[bid.ImpID], bid)
+			impCandidates[bid.ImpID] = append(impCandidates[bid.ImpID], pbs.TieBreakBid{
+				Price:        bid.Price,
+				Bidder:       seat,
+				DealID:       bid.DealID,
+				ResponseTime: responseTimes[seat],
+			})
+		}
+	}
+	winners := make(map[string]*openrtb.Bid, len(impBids))
+	for impID, bids := range impBids {
+		impSeed := seed
+		if seed != "" {
+			impSeed = seed + ":" + impID
+		}
+		winners[impID] = bids[e.tieBreaker.Winner(impCandidates[impID], impSeed)]
+	}
+
+	for i := range seatBids {
+		seat := seatBids[i].Seat
+		for j := range seatBids[i].Bid {
+			bid := &seatBids[i].Bid[j]
+			var ext ExtBid
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				return err
+			}
+			priceBucket := targeting.granularity(ext.Prebid.Type).bucket(bid.Price)
+			ext.Prebid.Targeting = bidTargeting(bid, seat, priceBucket, winners[bid.ImpID] == bid)
+			rawExt, err := json.Marshal(&ext)
+			if err != nil {
+				return err
+			}
+			bid.Ext = rawExt
+		}
+	}
+	return nil
+}
+
+func bidTargeting(bid *openrtb.Bid, bidder string, priceBucket string, isWinner bool) map[string]string {
+	size := ""
+	if bid.W != 0 && bid.H != 0 {
+		size = strconv.FormatUint(bid.W, 10) + "x" + strconv.FormatUint(bid.H, 10)
+	}
+
+	keys := map[string]string{
+		hbpbKey + "_" + bidder:     priceBucket,
+		hbBidderKey + "_" + bidder: bidder,
+	}
+	if size != "" {
+		keys[hbSizeKey+"_"+bidder] = size
+	}
+	if bid.DealID != "" {
+		keys[hbDealKey+"_"+bidder] = bid.DealID
+	}
+	if isWinner {
+		keys[hbpbKey] = priceBucket
+		keys[hbBidderKey] = bidder
+		if size != "" {
+			keys[hbSizeKey] = size
+		}
+		if bid.DealID != "" {
+			keys[hbDealKey] = bid.DealID
+		}
+	}
+	return keys
+}
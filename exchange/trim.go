@@ -0,0 +1,81 @@
+package exchange
+
+import (
+	"encoding/json"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+)
+
+// TrimBidderExt removes what the host and account don't allow from each bid's ext.bidder. If allowedKeys
+// isn't empty, only those keys are kept. The host's disallowed keys are always removed, and ext.bidder is
+// dropped if it's still bigger than the host's limit. It returns the number of bids which were trimmed.
+func TrimBidderExt(response *openrtb.BidResponse, host config.BidderExt, allowedKeys []string) (int, error) {
+	if response == nil || (host.MaxBytes <= 0 && len(host.DisallowedKeys) == 0 && len(allowedKeys) == 0) {
+		return 0, nil
+	}
+	trimmed := 0
+	for i := range response.SeatBid {
+		for j := range response.SeatBid[i].Bid {
+			bid := &response.SeatBid[i].Bid[j]
+			var ext map[string]json.RawMessage
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				return trimmed, err
+			}
+			bidderExt, ok := ext["bidder"]
+			if !ok {
+				continue
+			}
+			trimmedExt := trimExt(bidderExt, host, allowedKeys)
+			if string(trimmedExt) == string(bidderExt) {
+				continue
+			}
+			if len(trimmedExt) == 0 {
+				delete(ext, "bidder")
+			} else {
+				ext["bidder"] = trimmedExt
+			}
+			rawExt, err := json.Marshal(ext)
+			if err != nil {
+				return trimmed, err
+			}
+			bid.Ext = rawExt
+			trimmed++
+		}
+	}
+	return trimmed, nil
+}
+
+// trimExt returns what's left of a bidder's ext, or nil if none of it may be passed on.
+func trimExt(bidderExt json.RawMessage, host config.BidderExt, allowedKeys []string) json.RawMessage {
+	if len(allowedKeys) > 0 || len(host.DisallowedKeys) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(bidderExt, &fields); err != nil {
+			// Keys can't be checked if the ext isn't an object.
+			return nil
+		}
+		allowed := make(map[string]bool, len(allowedKeys))
+		for _, key := range allowedKeys {
+			allowed[key] = true
+		}
+		kept := make(map[string]json.RawMessage, len(fields))
+		for key, value := range fields {
+			if len(allowed) == 0 || allowed[key] {
+				kept[key] = value
+			}
+		}
+		for _, key := range host.DisallowedKeys {
+			delete(kept, key)
+		}
+		if len(kept) != len(fields) {
+			if len(kept) == 0 {
+				return nil
+			}
+			bidderExt, _ = json.Marshal(kept)
+		}
+	}
+	if host.MaxBytes > 0 && len(bidderExt) > host.MaxBytes {
+		return nil
+	}
+	return bidderExt
+}
@@ -0,0 +1,49 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+)
+
+func trimResponse(t *testing.T, bidderExt string, host config.BidderExt, allowedKeys []string) (string, int) {
+	response := &openrtb.BidResponse{SeatBid: []openrtb.SeatBid{{Bid: []openrtb.Bid{
+		{ID: "bid", Ext: openrtb.RawJSON(`{"bidder":` + bidderExt + `,"prebid":{"type":"banner"}}`)},
+		{ID: "plain", Ext: openrtb.RawJSON(`{"prebid":{"type":"banner"}}`)},
+	}}}}
+	trimmed, err := TrimBidderExt(response, host, allowedKeys)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(response.SeatBid[0].Bid[1].Ext) != `{"prebid":{"type":"banner"}}` {
+		t.Errorf("Bids without an ext.bidder shouldn't change. Got %s", response.SeatBid[0].Bid[1].Ext)
+	}
+	return string(response.SeatBid[0].Bid[0].Ext), trimmed
+}
+
+func TestTrimBidderExt(t *testing.T) {
+	tests := []struct {
+		name        string
+		bidderExt   string
+		host        config.BidderExt
+		allowedKeys []string
+		expected    string
+		trimmed     int
+	}{
+		{"no limits", `{"debug":"x"}`, config.BidderExt{}, nil, `{"bidder":{"debug":"x"},"prebid":{"type":"banner"}}`, 0},
+		{"under the size limit", `{"a":1}`, config.BidderExt{MaxBytes: 100}, nil, `{"bidder":{"a":1},"prebid":{"type":"banner"}}`, 0},
+		{"over the size limit", `{"debug":"0123456789"}`, config.BidderExt{MaxBytes: 10}, nil, `{"prebid":{"type":"banner"}}`, 1},
+		{"disallowed key", `{"a":1,"debug":"x"}`, config.BidderExt{DisallowedKeys: []string{"debug"}}, nil, `{"bidder":{"a":1},"prebid":{"type":"banner"}}`, 1},
+		{"allowed keys", `{"a":1,"b":2}`, config.BidderExt{}, []string{"b"}, `{"bidder":{"b":2},"prebid":{"type":"banner"}}`, 1},
+		{"host overrides account", `{"a":1,"b":2}`, config.BidderExt{DisallowedKeys: []string{"b"}}, []string{"a", "b"}, `{"bidder":{"a":1},"prebid":{"type":"banner"}}`, 1},
+		{"nothing allowed", `{"a":1}`, config.BidderExt{}, []string{"b"}, `{"prebid":{"type":"banner"}}`, 1},
+		{"not an object", `"text"`, config.BidderExt{}, []string{"a"}, `{"prebid":{"type":"banner"}}`, 1},
+	}
+	for _, test := range tests {
+		ext, trimmed := trimResponse(t, test.bidderExt, test.host, test.allowedKeys)
+		if ext != test.expected || trimmed != test.trimmed {
+			t.Errorf("%s: expected %s with %d trimmed. Got %s with %d", test.name, test.expected, test.trimmed, ext, trimmed)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// ValidationError is a problem with one field of a request. Path is a JSON pointer to the field, like
+// "/imp/0/id". It's empty if the problem is with the request as a whole.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	if err.Path == "" {
+		return err.Message
+	}
+	return err.Path + ": " + err.Message
+}
+
+func invalidField(path string, format string, args ...interface{}) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// pointerToken escapes a key for use in a JSON pointer.
+func pointerToken(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// underPath prefixes the path of an error for a nested object with the object's path.
+func underPath(prefix string, err error) error {
+	if validationErr, ok := err.(*ValidationError); ok {
+		return &ValidationError{Path: prefix + validationErr.Path, Message: validationErr.Message}
+	}
+	return &ValidationError{Path: prefix, Message: err.Error()}
+}
+
+// Validate returns a *ValidationError if the request can't be auctioned. Every imp must have a unique ID, a banner,
+// video or valid native request, and params for at least one of the exchange's bidders.
+func (e *Exchange) Validate(request *openrtb.BidRequest) error {
+	if request.ID == "" {
+		return invalidField("/id", "must be a non-empty string")
+	}
+	if request.Site != nil && request.App != nil {
+		return invalidField("/app", "can't be sent with a site")
+	}
+	if request.Site == nil && request.App == nil {
+		return invalidField("", "request must have a site or an app")
+	}
+	if len(request.Imp) == 0 {
+		return invalidField("/imp", "must contain at least one element")
+	}
+	var ext ExtRequest
+	if len(request.Ext) > 0 {
+		if err := json.Unmarshal(request.Ext, &ext); err != nil {
+			return invalidField("/ext", "is invalid: %v", err)
+		}
+		if targeting := ext.Prebid.Targeting; targeting != nil {
+			if err := targeting.validate(); err != nil {
+				return underPath("/ext/prebid/targeting", err)
+			}
+		}
+		if err := e.validateAliases(ext.Prebid.Aliases); err != nil {
+			return err
+		}
+	}
+
+	ids := make(map[string]bool, len(request.Imp))
+	for i, imp := range request.Imp {
+		path := fmt.Sprintf("/imp/%d", i)
+		if imp.ID == "" {
+			return invalidField(path+"/id", "must be a non-empty string")
+		}
+		if ids[imp.ID] {
+			return invalidField(path+"/id", "%q is not unique", imp.ID)
+		}
+		ids[imp.ID] = true
+		if err := validateImp(&imp); err != nil {
+			return underPath(path, err)
+		}
+		if err := e.validateImpExt(&imp, request.App != nil, ext.Prebid.Aliases); err != nil {
+			return underPath(path+"/ext", err)
+		}
+	}
+	return nil
+}
+
+// ValidateDOOH rejects requests for digital out-of-home screens. The request's dooh object has no field in the
+// OpenRTB types, so Validate can't see it, and this checks the raw request instead.
+func ValidateDOOH(body []byte) error {
+	var request struct {
+		DOOH json.RawMessage `json:"dooh"`
+		Site json.RawMessage `json:"site"`
+		App  json.RawMessage `json:"app"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || isNull(request.DOOH) {
+		return nil
+	}
+	if !isNull(request.Site) || !isNull(request.App) {
+		return invalidField("/dooh", "can't be sent with a site or an app")
+	}
+	return invalidField("/dooh", "isn't supported. The request must have a site or an app")
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+func validateImp(imp *openrtb.Imp) error {
+	if imp.Banner == nil && imp.Video == nil && imp.Native == nil {
+		return invalidField("", "must have a banner, video or native")
+	}
+	if banner := imp.Banner; banner != nil && len(banner.Format) == 0 && (banner.W == nil || banner.H == nil || *banner.W == 0 || *banner.H == 0) {
+		return invalidField("/banner", "must have a format, or a w and h")
+	}
+	if video := imp.Video; video != nil && len(video.MIMEs) == 0 {
+		return invalidField("/video/mimes", "must contain at least one element")
+	}
+	if native := imp.Native; native != nil {
+		if _, err := pbs.ParseNativeRequest(native.Request); err != nil {
+			return invalidField("/native/request", "is invalid: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateAliases checks that each alias has a code of its own, and aliases one of the exchange's bidders.
+// Otherwise an alias could take over a bidder's params.
+func (e *Exchange) validateAliases(aliases map[string]string) error {
+	for alias, core := range aliases {
+		if _, ok := e.bidders[alias]; ok || isReservedImpExtKey(alias) {
+			return invalidField("/ext/prebid/aliases/"+pointerToken(alias), "can't reuse a bidder's code")
+		}
+		if _, ok := e.bidders[core]; !ok {
+			return invalidField("/ext/prebid/aliases/"+pointerToken(alias), "is for the unknown bidder %q", core)
+		}
+	}
+	return nil
+}
+
+// validateImpExt checks the imp's ext. Its errors' paths are relative to the ext.
+func (e *Exchange) validateImpExt(imp *openrtb.Imp, isApp bool, aliases map[string]string) error {
+	var ext ExtImp
+	if err := json.Unmarshal(imp.Ext, &ext); err != nil {
+		return invalidField("", "is invalid: %v", err)
+	}
+	if rawSKAdN, ok := ext["skadn"]; ok {
+		if !isApp {
+			return invalidField("/skadn", "is only allowed in app requests")
+		}
+		var skadn ExtImpSKAdN
+		if err := json.Unmarshal(rawSKAdN, &skadn); err != nil {
+			return invalidField("/skadn", "is invalid: %v", err)
+		}
+		if err := skadn.validate(); err != nil {
+			return underPath("/skadn", err)
+		}
+	}
+	if _, _, err := parseAdSlot(imp, ext); err != nil {
+		return err
+	}
+	if rawPrebid, ok := ext["prebid"]; ok {
+		var prebid ExtImpPrebid
+		if err := json.Unmarshal(rawPrebid, &prebid); err != nil {
+			return invalidField("/prebid", "is invalid: %v", err)
+		}
+	}
+	bidders := 0
+	for bidder := range ext {
+		if isReservedImpExtKey(bidder) {
+			continue
+		}
+		if _, ok := e.bidders[coreBidder(aliases, bidder)]; !ok {
+			return invalidField("/"+pointerToken(bidder), "is an unknown bidder")
+		}
+		bidders++
+	}
+	if bidders == 0 {
+		return invalidField("", "must contain at least one bidder")
+	}
+	return nil
+}
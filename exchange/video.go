@@ -0,0 +1,345 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// VideoRequest is the body of an /openrtb2/video request. Long-form video players don't know which ads they'll
+// play ahead of time, only how long each ad break is, so the request describes pods instead of imps.
+type VideoRequest struct {
+	ID     string          `json:"id"`
+	Site   *openrtb.Site   `json:"site,omitempty"`
+	App    *openrtb.App    `json:"app,omitempty"`
+	Device *openrtb.Device `json:"device,omitempty"`
+	User   *openrtb.User   `json:"user,omitempty"`
+	// Content describes the show or stream which the pods play in. It's copied into site.content or app.content.
+	Content *openrtb.Content `json:"content,omitempty"`
+	// Video is the template for every imp's video. Its durations come from the pod config.
+	Video     *openrtb.Video `json:"video"`
+	PodConfig PodConfig      `json:"podconfig"`
+	// IncludeBrandCategory adds each bid's IAB category to its hb_pb_cat_dur key, and keeps two ads with the
+	// same category out of a pod.
+	IncludeBrandCategory bool              `json:"includebrandcategory,omitempty"`
+	PriceGranularity     *PriceGranularity `json:"pricegranularity,omitempty"`
+	TMax                 int64             `json:"tmax,omitempty"`
+	Test                 int8              `json:"test,omitempty"`
+}
+
+type PodConfig struct {
+	// DurationRangeSec lists the ad lengths which the player accepts, in seconds.
+	DurationRangeSec []int `json:"durationrangesec"`
+	// RequireExactDuration drops bids whose duration isn't one of the DurationRangeSec. Otherwise, they're
+	// rounded up to the next one.
+	RequireExactDuration bool  `json:"requireexactduration,omitempty"`
+	Pods                 []Pod `json:"pods"`
+}
+
+// Pod is an ad break.
+type Pod struct {
+	PodID int `json:"podid"`
+	// AdPodDurationSec is the length of the break. The ads which are picked for it never add up to more.
+	AdPodDurationSec int `json:"adpoddurationsec"`
+	// ConfigID names the stored imp with the bidders' params for the pod.
+	ConfigID string `json:"configid"`
+}
+
+// VideoResponse is the /openrtb2/video response.
+type VideoResponse struct {
+	AdPods []*AdPod `json:"adpods"`
+	// Ext is the auction's BidResponse.ext, with the bidders' errors and response times.
+	Ext json.RawMessage `json:"ext,omitempty"`
+}
+
+// AdPod has the targeting for each ad which was picked for a pod, most expensive first.
+type AdPod struct {
+	PodID     int              `json:"podid"`
+	Targeting []VideoTargeting `json:"targeting"`
+	// Errors explains why bids were left out of the pod.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// VideoTargeting is the ad server targeting for one ad in a pod. Ad servers usually line items up on
+// hb_pb_cat_dur, which is like "12.00_IAB1_30s", or "12.00_30s" without brand categories.
+type VideoTargeting struct {
+	HbPb       string `json:"hb_pb"`
+	HbPbCatDur string `json:"hb_pb_cat_dur"`
+	HbBidder   string `json:"hb_bidder"`
+	HbCacheID  string `json:"hb_cache_id,omitempty"`
+	HbDeal     string `json:"hb_deal,omitempty"`
+}
+
+// Validate returns an error if the pods can't be expanded into a BidRequest. The BidRequest still needs
+// to pass Exchange.Validate.
+func (r *VideoRequest) Validate() error {
+	if r.Video == nil || len(r.Video.MIMEs) == 0 {
+		return errors.New("request.video.mimes must contain at least one element")
+	}
+	if len(r.PodConfig.DurationRangeSec) == 0 {
+		return errors.New("request.podconfig.durationrangesec must contain at least one element")
+	}
+	for i, duration := range r.PodConfig.DurationRangeSec {
+		if duration <= 0 {
+			return fmt.Errorf("request.podconfig.durationrangesec[%d] must be positive", i)
+		}
+	}
+	if len(r.PodConfig.Pods) == 0 {
+		return errors.New("request.podconfig.pods must contain at least one element")
+	}
+	podIDs := make(map[int]bool, len(r.PodConfig.Pods))
+	for i, pod := range r.PodConfig.Pods {
+		if podIDs[pod.PodID] {
+			return fmt.Errorf("request.podconfig.pods[%d].podid %d is not unique", i, pod.PodID)
+		}
+		podIDs[pod.PodID] = true
+		if pod.AdPodDurationSec <= 0 {
+			return fmt.Errorf("request.podconfig.pods[%d].adpoddurationsec must be positive", i)
+		}
+		if pod.ConfigID == "" {
+			return fmt.Errorf("request.podconfig.pods[%d].configid must be a non-empty string", i)
+		}
+	}
+	return nil
+}
+
+// BidRequest expands the pods into imps, with targeting turned on. Each pod gets one imp for each of the
+// shortest ads which would fit in it, with IDs like "<podid>_<n>". The imps are copies of the stored imp
+// for the pod's configid, with the request's video. The storedImps are keyed by configid.
+func (r *VideoRequest) BidRequest(storedImps map[string]openrtb.Imp) (*openrtb.BidRequest, error) {
+	targeting := &ExtRequestTargeting{PriceGranularity: r.PriceGranularity}
+	ext, err := json.Marshal(&ExtRequest{Prebid: ExtRequestPrebid{Targeting: targeting}})
+	if err != nil {
+		return nil, err
+	}
+	request := &openrtb.BidRequest{
+		ID:     r.ID,
+		Device: r.Device,
+		User:   r.User,
+		TMax:   r.TMax,
+		Test:   r.Test,
+		Ext:    ext,
+	}
+	if r.Site != nil {
+		site := *r.Site
+		if r.Content != nil {
+			site.Content = r.Content
+		}
+		request.Site = &site
+	}
+	if r.App != nil {
+		app := *r.App
+		if r.Content != nil {
+			app.Content = r.Content
+		}
+		request.App = &app
+	}
+
+	minDuration, _ := r.durationRange()
+	for _, pod := range r.PodConfig.Pods {
+		storedImp, ok := storedImps[pod.ConfigID]
+		if !ok {
+			return nil, fmt.Errorf("no stored imp for configid %q", pod.ConfigID)
+		}
+		video := *r.Video
+		video.MaxDuration = int64(r.maxAdDuration(pod))
+		if r.PodConfig.RequireExactDuration {
+			video.MinDuration = int64(minDuration)
+		}
+		imps := pod.AdPodDurationSec / minDuration
+		if imps == 0 {
+			imps = 1
+		}
+		for i := 1; i <= imps; i++ {
+			imp := storedImp
+			imp.ID = fmt.Sprintf("%d_%d", pod.PodID, i)
+			imp.Banner = nil
+			imp.Video = &video
+			request.Imp = append(request.Imp, imp)
+		}
+	}
+	return request, nil
+}
+
+func (r *VideoRequest) durationRange() (int, int) {
+	min, max := r.PodConfig.DurationRangeSec[0], r.PodConfig.DurationRangeSec[0]
+	for _, duration := range r.PodConfig.DurationRangeSec {
+		if duration < min {
+			min = duration
+		}
+		if duration > max {
+			max = duration
+		}
+	}
+	return min, max
+}
+
+// maxAdDuration is the length of the longest ad which the pod can play.
+func (r *VideoRequest) maxAdDuration(pod Pod) int {
+	_, maxDuration := r.durationRange()
+	if pod.AdPodDurationSec < maxDuration {
+		return pod.AdPodDurationSec
+	}
+	return maxDuration
+}
+
+// bucketDuration rounds the duration up to the next one in the pod config. It returns false if it's too
+// long, or if exact durations are required and it isn't one of them.
+func (r *VideoRequest) bucketDuration(duration int) (int, bool) {
+	bucket := 0
+	for _, allowed := range r.PodConfig.DurationRangeSec {
+		if allowed >= duration && (bucket == 0 || allowed < bucket) {
+			bucket = allowed
+		}
+	}
+	if bucket == 0 || (r.PodConfig.RequireExactDuration && bucket != duration) {
+		return 0, false
+	}
+	return bucket, true
+}
+
+// podBid is a winning bid which might go in a pod.
+type podBid struct {
+	bid       *openrtb.Bid
+	bidder    string
+	category  string
+	duration  int
+	targeting VideoTargeting
+}
+
+// Response picks the ads for each pod from the auction of the expanded BidRequest. Only the winning bid for
+// each imp is considered. A pod takes them most expensive first, as long as they fit in what's left of its
+// duration, and (with IncludeBrandCategory) their category isn't in the pod yet. Bids without a duration
+// are assumed to be as long as the pod's imps allow.
+//
+// The cache func should store the picked bids' VAST, and return their cache IDs in the same order.
+func (r *VideoRequest) Response(response *openrtb.BidResponse, cache func(bids []*openrtb.Bid) ([]string, error)) (*VideoResponse, error) {
+	podIndexes := make(map[string]int, len(r.PodConfig.Pods))
+	adPods := make([]*AdPod, len(r.PodConfig.Pods))
+	candidates := make([][]*podBid, len(r.PodConfig.Pods))
+	for i, pod := range r.PodConfig.Pods {
+		podIndexes[strconv.Itoa(pod.PodID)] = i
+		adPods[i] = &AdPod{PodID: pod.PodID, Targeting: []VideoTargeting{}}
+	}
+
+	for _, seatBid := range response.SeatBid {
+		for j := range seatBid.Bid {
+			bid := &seatBid.Bid[j]
+			podIndex, ok := podIndexes[podIDOf(bid.ImpID)]
+			if !ok {
+				continue
+			}
+			var ext ExtBid
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				return nil, err
+			}
+			if ext.Prebid.Targeting[hbBidderKey] == "" {
+				continue
+			}
+			candidate, err := r.podBid(bid, seatBid.Seat, &ext, r.PodConfig.Pods[podIndex])
+			if err != nil {
+				adPods[podIndex].Errors = append(adPods[podIndex].Errors, err.Error())
+				continue
+			}
+			candidates[podIndex] = append(candidates[podIndex], candidate)
+		}
+	}
+
+	var picked []*podBid
+	var pickedPods []int
+	for i, pod := range r.PodConfig.Pods {
+		podBids := candidates[i]
+		sort.SliceStable(podBids, func(a, b int) bool {
+			if podBids[a].bid.Price != podBids[b].bid.Price {
+				return podBids[a].bid.Price > podBids[b].bid.Price
+			}
+			return podBids[a].bid.ImpID < podBids[b].bid.ImpID
+		})
+		remaining := pod.AdPodDurationSec
+		categories := make(map[string]bool)
+		for _, candidate := range podBids {
+			if candidate.duration > remaining {
+				adPods[i].Errors = append(adPods[i].Errors, fmt.Sprintf("Bid %s from %s doesn't fit in the rest of the pod", candidate.bid.ID, candidate.bidder))
+				continue
+			}
+			if r.IncludeBrandCategory && categories[candidate.category] {
+				adPods[i].Errors = append(adPods[i].Errors, fmt.Sprintf("Bid %s from %s has the same category as a better bid, %s", candidate.bid.ID, candidate.bidder, candidate.category))
+				continue
+			}
+			remaining -= candidate.duration
+			categories[candidate.category] = true
+			picked = append(picked, candidate)
+			pickedPods = append(pickedPods, i)
+		}
+	}
+
+	if len(picked) > 0 {
+		bids := make([]*openrtb.Bid, len(picked))
+		for i, candidate := range picked {
+			bids[i] = candidate.bid
+		}
+		cacheIDs, err := cache(bids)
+		if err != nil {
+			return nil, err
+		}
+		for i, candidate := range picked {
+			if i < len(cacheIDs) {
+				candidate.targeting.HbCacheID = cacheIDs[i]
+			}
+			adPods[pickedPods[i]].Targeting = append(adPods[pickedPods[i]].Targeting, candidate.targeting)
+		}
+	}
+	return &VideoResponse{AdPods: adPods, Ext: json.RawMessage(response.Ext)}, nil
+}
+
+// podBid returns the winning bid's duration, category and targeting, or an error if it can't go in the pod.
+func (r *VideoRequest) podBid(bid *openrtb.Bid, bidder string, ext *ExtBid, pod Pod) (*podBid, error) {
+	duration, category := r.maxAdDuration(pod), ""
+	if video := ext.Prebid.Video; video != nil {
+		if video.Duration > 0 {
+			duration = video.Duration
+		}
+		category = video.PrimaryCategory
+	}
+	if category == "" && len(bid.Cat) > 0 {
+		category = bid.Cat[0]
+	}
+	bucket, ok := r.bucketDuration(duration)
+	if !ok {
+		return nil, fmt.Errorf("Bid %s from %s has a duration of %ds, which the pod config doesn't allow", bid.ID, bidder, duration)
+	}
+	if r.IncludeBrandCategory && category == "" {
+		return nil, fmt.Errorf("Bid %s from %s has no category", bid.ID, bidder)
+	}
+
+	priceBucket := ext.Prebid.Targeting[hbpbKey]
+	priceCatDur := fmt.Sprintf("%s_%ds", priceBucket, bucket)
+	if r.IncludeBrandCategory {
+		priceCatDur = fmt.Sprintf("%s_%s_%ds", priceBucket, category, bucket)
+	}
+	return &podBid{
+		bid:      bid,
+		bidder:   bidder,
+		category: category,
+		duration: bucket,
+		targeting: VideoTargeting{
+			HbPb:       priceBucket,
+			HbPbCatDur: priceCatDur,
+			HbBidder:   bidder,
+			HbDeal:     bid.DealID,
+		},
+	}, nil
+}
+
+// podIDOf returns the pod ID part of an imp ID which BidRequest made.
+func podIDOf(impID string) string {
+	if i := strings.LastIndex(impID, "_"); i >= 0 {
+		return impID[:i]
+	}
+	return ""
+}
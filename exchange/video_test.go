@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func parseVideoRequest(t *testing.T, body string) *VideoRequest {
+	var request VideoRequest
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("Bad test request: %v", err)
+	}
+	return &request
+}
+
+func TestValidateVideoRequest(t *testing.T) {
+	valid := `{"id":"req","site":{},"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15,30],"pods":[{"podid":1,"adpoddurationsec":60,"configid":"c"}]}}`
+	if err := parseVideoRequest(t, valid).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := map[string]string{
+		"no video":        `{"podconfig":{"durationrangesec":[15],"pods":[{"podid":1,"adpoddurationsec":60,"configid":"c"}]}}`,
+		"no durations":    `{"video":{"mimes":["video/mp4"]},"podconfig":{"pods":[{"podid":1,"adpoddurationsec":60,"configid":"c"}]}}`,
+		"a zero duration": `{"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[0],"pods":[{"podid":1,"adpoddurationsec":60,"configid":"c"}]}}`,
+		"no pods":         `{"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15]}}`,
+		"duplicate pods":  `{"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15],"pods":[{"podid":1,"adpoddurationsec":60,"configid":"c"},{"podid":1,"adpoddurationsec":30,"configid":"c"}]}}`,
+		"an empty pod":    `{"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15],"pods":[{"podid":1,"configid":"c"}]}}`,
+		"no configid":     `{"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15],"pods":[{"podid":1,"adpoddurationsec":60}]}}`,
+	}
+	for name, body := range invalid {
+		if err := parseVideoRequest(t, body).Validate(); err == nil {
+			t.Errorf("Expected an error for a request with %s", name)
+		}
+	}
+}
+
+func TestExpandPods(t *testing.T) {
+	videoRequest := parseVideoRequest(t, `{"id":"req","site":{"page":"http://example.com"},"content":{"title":"Episode 1"},
+		"video":{"mimes":["video/mp4"],"w":640,"h":480},
+		"podconfig":{"durationrangesec":[15,30],"requireexactduration":true,"pods":[
+			{"podid":1,"adpoddurationsec":60,"configid":"preroll"},
+			{"podid":2,"adpoddurationsec":20,"configid":"midroll"}
+		]}}`)
+	storedImps := map[string]openrtb.Imp{
+		"preroll": {Ext: openrtb.RawJSON(`{"alpha":{"price":1}}`)},
+		"midroll": {Ext: openrtb.RawJSON(`{"beta":{"price":2}}`)},
+	}
+	request, err := videoRequest.BidRequest(storedImps)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if request.Site.Content == nil || request.Site.Content.Title != "Episode 1" {
+		t.Errorf("The content should be copied into the site. Got %#v", request.Site)
+	}
+	if string(request.Ext) != `{"prebid":{"targeting":{}}}` {
+		t.Errorf("Targeting should be turned on. Got %s", request.Ext)
+	}
+
+	expected := map[string]int64{"1_1": 30, "1_2": 30, "1_3": 30, "1_4": 30, "2_1": 20}
+	if len(request.Imp) != len(expected) {
+		t.Fatalf("Expected %d imps. Got %d", len(expected), len(request.Imp))
+	}
+	for _, imp := range request.Imp {
+		maxDuration, ok := expected[imp.ID]
+		if !ok || imp.Video.MaxDuration != maxDuration || imp.Video.MinDuration != 15 || imp.Video.W != 640 {
+			t.Errorf("Bad imp %s: %#v", imp.ID, imp.Video)
+		}
+	}
+
+	if _, err := videoRequest.BidRequest(map[string]openrtb.Imp{}); err == nil {
+		t.Error("Pods without a stored imp should be an error")
+	}
+}
+
+func TestVideoResponse(t *testing.T) {
+	e, closeServer := newTestExchange(t)
+	defer closeServer()
+
+	videoRequest := parseVideoRequest(t, `{"id":"req","site":{},"video":{"mimes":["video/mp4"]},"includebrandcategory":true,
+		"podconfig":{"durationrangesec":[15,30],"pods":[{"podid":1,"adpoddurationsec":45,"configid":"c"}]}}`)
+	request, err := videoRequest.BidRequest(map[string]openrtb.Imp{"c": {Ext: openrtb.RawJSON(`{"beta":{"price":2}}`)}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err := e.HoldAuction(context.Background(), request, Activities{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Make the bids different: a 30s bid and two 10s bids, one of which shares its category.
+	bids := response.SeatBid[0].Bid
+	if len(bids) != 3 {
+		t.Fatalf("Expected a bid on each of the 3 imps. Got %d", len(bids))
+	}
+	setVideo := func(bid *openrtb.Bid, price float64, duration int, category string) {
+		var ext ExtBid
+		json.Unmarshal(bid.Ext, &ext)
+		ext.Prebid.Video = &ExtBidPrebidVideo{Duration: duration, PrimaryCategory: category}
+		bid.Price = price
+		bid.Ext, _ = json.Marshal(&ext)
+	}
+	setVideo(&bids[0], 2, 30, "IAB1")
+	setVideo(&bids[1], 2, 10, "IAB1")
+	setVideo(&bids[2], 2, 10, "IAB2")
+
+	var cached []*openrtb.Bid
+	videoResponse, err := videoRequest.Response(response, func(bids []*openrtb.Bid) ([]string, error) {
+		cached = bids
+		return []string{"cache-1", "cache-2"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(videoResponse.AdPods) != 1 || videoResponse.AdPods[0].PodID != 1 {
+		t.Fatalf("Expected one pod. Got %#v", videoResponse.AdPods)
+	}
+	pod := videoResponse.AdPods[0]
+	if len(pod.Targeting) != 2 || len(cached) != 2 {
+		t.Fatalf("Expected 2 of the 3 bids to be picked and cached. Got %#v", pod.Targeting)
+	}
+	if first := pod.Targeting[0]; first.HbPbCatDur != "2.00_IAB1_30s" || first.HbBidder != "beta" || first.HbCacheID != "cache-1" {
+		t.Errorf("Bad targeting for the 30s ad: %#v", first)
+	}
+	if second := pod.Targeting[1]; second.HbPbCatDur != "2.00_IAB2_15s" || second.HbCacheID != "cache-2" {
+		t.Errorf("Bad targeting for the 10s ad, which should be rounded up to 15s: %#v", second)
+	}
+	if len(pod.Errors) != 1 || !strings.Contains(pod.Errors[0], "category") {
+		t.Errorf("Expected an error about the bid with a repeated category. Got %v", pod.Errors)
+	}
+}
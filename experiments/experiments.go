@@ -0,0 +1,186 @@
+package experiments
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// HashByRequest puts each auction into a bucket independently.
+	HashByRequest = "request"
+	// HashByUser keeps each user in the same bucket across auctions.
+	HashByUser = "user"
+)
+
+const (
+	// FloorStrategyRequest sends bidders the floors from the request. It's the default.
+	FloorStrategyRequest = "request"
+	// FloorStrategyNone sends bidders no floors at all.
+	FloorStrategyNone = "none"
+)
+
+// Experiments splits auctions between the buckets defined in the host config.
+type Experiments struct {
+	experiments []experiment
+	hostFamily  string
+}
+
+type experiment struct {
+	name    string
+	hashBy  string
+	buckets []config.ExperimentBucket
+	meters  []metrics.Meter
+}
+
+// Assignment is the bucket which an auction was put into for a single experiment.
+type Assignment struct {
+	Experiment string
+	Bucket     config.ExperimentBucket
+}
+
+// New validates the experiments from the host config. hostFamily is the family name of the host cookie,
+// which is used to identify web users when an experiment hashes by user. Each bucket counts its auctions
+// in the "experiments.<experiment>.<bucket>.requests" meter of the registry.
+func New(cfg map[string]config.Experiment, hostFamily string, registry metrics.Registry) (*Experiments, error) {
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e := &Experiments{
+		experiments: make([]experiment, 0, len(cfg)),
+		hostFamily:  hostFamily,
+	}
+	for _, name := range names {
+		exp := cfg[name]
+		hashBy := exp.HashBy
+		if hashBy == "" {
+			hashBy = HashByRequest
+		}
+		if hashBy != HashByRequest && hashBy != HashByUser {
+			return nil, fmt.Errorf("experiments.%s.hash_by must be \"%s\" or \"%s\". Got \"%s\"", name, HashByRequest, HashByUser, hashBy)
+		}
+		total := 0
+		meters := make([]metrics.Meter, 0, len(exp.Buckets))
+		for _, bucket := range exp.Buckets {
+			if bucket.Name == "" {
+				return nil, fmt.Errorf("experiments.%s has a bucket with no name", name)
+			}
+			if bucket.Percent < 0 {
+				return nil, fmt.Errorf("experiments.%s.%s has a negative percent", name, bucket.Name)
+			}
+			if bucket.TimeoutMillis < 0 {
+				return nil, fmt.Errorf("experiments.%s.%s has a negative timeout_ms", name, bucket.Name)
+			}
+			if _, ok := pbs.GetPriceBucketString(0)[bucket.PriceGranularity]; !ok && bucket.PriceGranularity != "" {
+				return nil, fmt.Errorf("experiments.%s.%s.price_granularity \"%s\" is not supported", name, bucket.Name, bucket.PriceGranularity)
+			}
+			if bucket.FloorStrategy != "" && bucket.FloorStrategy != FloorStrategyRequest && bucket.FloorStrategy != FloorStrategyNone {
+				return nil, fmt.Errorf("experiments.%s.%s.floor_strategy must be \"%s\" or \"%s\". Got \"%s\"", name, bucket.Name, FloorStrategyRequest, FloorStrategyNone, bucket.FloorStrategy)
+			}
+			total += bucket.Percent
+			meters = append(meters, metrics.GetOrRegisterMeter(fmt.Sprintf("experiments.%s.%s.requests", name, bucket.Name), registry))
+		}
+		if total > 100 {
+			return nil, fmt.Errorf("experiments.%s has buckets which add up to %d percent", name, total)
+		}
+		e.experiments = append(e.experiments, experiment{
+			name:    name,
+			hashBy:  hashBy,
+			buckets: exp.Buckets,
+			meters:  meters,
+		})
+	}
+	return e, nil
+}
+
+// Assign puts the request into a bucket for each experiment, and counts it in the bucket's meter.
+// Requests which fall outside of every bucket in an experiment are the control group, and get no
+// Assignment for it.
+func (e *Experiments) Assign(req *pbs.PBSRequest) []Assignment {
+	var assignments []Assignment
+	// The tid comes from the client, which could pick its own bucket with it, so requests
+	// are identified by an ID of our own.
+	requestID := strconv.FormatInt(rand.Int63(), 10)
+	for _, exp := range e.experiments {
+		slot := hash(exp.name, e.hashKey(req, exp.hashBy, requestID)) % 100
+		floor := uint32(0)
+		for i, bucket := range exp.buckets {
+			floor += uint32(bucket.Percent)
+			if slot < floor {
+				exp.meters[i].Mark(1)
+				assignments = append(assignments, Assignment{
+					Experiment: exp.name,
+					Bucket:     bucket,
+				})
+				break
+			}
+		}
+	}
+	return assignments
+}
+
+// Apply changes the request so that it runs the way this bucket wants it to.
+func (a Assignment) Apply(req *pbs.PBSRequest) {
+	if a.Bucket.TimeoutMillis > 0 {
+		req.TimeoutMillis = a.Bucket.TimeoutMillis
+	}
+	if len(a.Bucket.Bidders) > 0 {
+		allowed := make(map[string]bool, len(a.Bucket.Bidders))
+		for _, bidder := range a.Bucket.Bidders {
+			allowed[bidder] = true
+		}
+		bidders := make([]*pbs.PBSBidder, 0, len(req.Bidders))
+		for _, bidder := range req.Bidders {
+			if allowed[bidder.BidderCode] {
+				bidders = append(bidders, bidder)
+			}
+		}
+		req.Bidders = bidders
+	}
+	if a.Bucket.FloorStrategy == FloorStrategyNone {
+		for i := range req.AdUnits {
+			req.AdUnits[i].Floor = nil
+		}
+		for _, bidder := range req.Bidders {
+			for i := range bidder.AdUnits {
+				bidder.AdUnits[i].Floor = nil
+			}
+		}
+	}
+}
+
+// hashKey picks the value which decides the bucket. If we can't identify the user, then
+// the request is used instead so that traffic still gets split.
+func (e *Experiments) hashKey(req *pbs.PBSRequest, hashBy string, requestID string) string {
+	if hashBy == HashByUser {
+		if req.User != nil && req.User.ID != "" {
+			return req.User.ID
+		}
+		if req.Device != nil && req.Device.IFA != "" {
+			return req.Device.IFA
+		}
+		if req.Cookie != nil {
+			if uid, _, _ := req.Cookie.GetUID(e.hostFamily); uid != "" {
+				return uid
+			}
+		}
+	}
+	return requestID
+}
+
+func hash(name string, key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return h.Sum32()
+}
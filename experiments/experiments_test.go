@@ -0,0 +1,164 @@
+package experiments
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestInvalidConfigs(t *testing.T) {
+	invalid := []map[string]config.Experiment{
+		{"timeouts": {HashBy: "device"}},
+		{"timeouts": {Buckets: []config.ExperimentBucket{{Percent: 10}}}},
+		{"timeouts": {Buckets: []config.ExperimentBucket{{Name: "a", Percent: -1}}}},
+		{"timeouts": {Buckets: []config.ExperimentBucket{{Name: "a", Percent: 60}, {Name: "b", Percent: 60}}}},
+		{"timeouts": {Buckets: []config.ExperimentBucket{{Name: "a", Percent: 10, TimeoutMillis: -1}}}},
+		{"granularity": {Buckets: []config.ExperimentBucket{{Name: "a", Percent: 10, PriceGranularity: "fine"}}}},
+		{"floors": {Buckets: []config.ExperimentBucket{{Name: "a", Percent: 10, FloorStrategy: "dynamic"}}}},
+	}
+	for _, cfg := range invalid {
+		if _, err := New(cfg, "", metrics.NewRegistry()); err == nil {
+			t.Errorf("Expected an error for config %#v", cfg)
+		}
+	}
+}
+
+func TestAssignSplitsTraffic(t *testing.T) {
+	e, err := New(map[string]config.Experiment{
+		"timeouts": {
+			Buckets: []config.ExperimentBucket{
+				{Name: "short", Percent: 50, TimeoutMillis: 100},
+			},
+		},
+	}, "", metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	inBucket := 0
+	for i := 0; i < 1000; i++ {
+		assignments := e.Assign(&pbs.PBSRequest{Tid: fmt.Sprintf("tid-%d", i)})
+		if len(assignments) > 0 {
+			inBucket++
+		}
+	}
+	if inBucket < 400 || inBucket > 600 {
+		t.Errorf("Expected about half of the requests in the bucket. Got %d of 1000", inBucket)
+	}
+}
+
+func TestAssignIgnoresTid(t *testing.T) {
+	e, err := New(map[string]config.Experiment{
+		"timeouts": {
+			Buckets: []config.ExperimentBucket{
+				{Name: "short", Percent: 50, TimeoutMillis: 100},
+			},
+		},
+	}, "", metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	inBucket := 0
+	for i := 0; i < 1000; i++ {
+		if len(e.Assign(&pbs.PBSRequest{Tid: "chosen"})) > 0 {
+			inBucket++
+		}
+	}
+	if inBucket < 400 || inBucket > 600 {
+		t.Errorf("Clients shouldn't be able to pick their bucket with the tid. Got %d of 1000 in the bucket", inBucket)
+	}
+}
+
+func TestAssignMarksMeters(t *testing.T) {
+	registry := metrics.NewRegistry()
+	e, err := New(map[string]config.Experiment{
+		"timeouts": {
+			Buckets: []config.ExperimentBucket{
+				{Name: "short", Percent: 100, TimeoutMillis: 100},
+			},
+		},
+	}, "", registry)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	meter, ok := registry.Get("experiments.timeouts.short.requests").(metrics.Meter)
+	if !ok {
+		t.Fatalf("The bucket's meter should be registered up front")
+	}
+	e.Assign(&pbs.PBSRequest{})
+	e.Assign(&pbs.PBSRequest{})
+	if meter.Count() != 2 {
+		t.Errorf("Expected 2 requests in the bucket's meter. Got %d", meter.Count())
+	}
+}
+
+func TestAssignByUserIsSticky(t *testing.T) {
+	e, err := New(map[string]config.Experiment{
+		"bidders": {
+			HashBy: HashByUser,
+			Buckets: []config.ExperimentBucket{
+				{Name: "a", Percent: 50},
+				{Name: "b", Percent: 50},
+			},
+		},
+	}, "", metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	first := e.Assign(&pbs.PBSRequest{Tid: "1", User: &openrtb.User{ID: "user"}})
+	for i := 0; i < 10; i++ {
+		next := e.Assign(&pbs.PBSRequest{Tid: fmt.Sprintf("%d", i), User: &openrtb.User{ID: "user"}})
+		if len(next) != 1 || next[0].Bucket.Name != first[0].Bucket.Name {
+			t.Errorf("Users should stay in the same bucket across requests")
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	req := &pbs.PBSRequest{
+		TimeoutMillis: 500,
+		Bidders: []*pbs.PBSBidder{
+			{BidderCode: "appnexus"},
+			{BidderCode: "rubicon"},
+		},
+	}
+	Assignment{
+		Experiment: "test",
+		Bucket: config.ExperimentBucket{
+			Name:          "trial",
+			TimeoutMillis: 200,
+			Bidders:       []string{"rubicon"},
+		},
+	}.Apply(req)
+
+	if req.TimeoutMillis != 200 {
+		t.Errorf("Expected the bucket timeout of 200. Got %d", req.TimeoutMillis)
+	}
+	if len(req.Bidders) != 1 || req.Bidders[0].BidderCode != "rubicon" {
+		t.Errorf("Only the bucket's bidders should be left in the request")
+	}
+}
+
+func TestApplyFloorStrategy(t *testing.T) {
+	floor := &pbs.PBSFloor{Value: 1.5}
+	req := &pbs.PBSRequest{
+		AdUnits: []pbs.AdUnit{{Code: "top", Floor: floor}},
+		Bidders: []*pbs.PBSBidder{
+			{BidderCode: "appnexus", AdUnits: []pbs.PBSAdUnit{{Code: "top", Floor: floor}}},
+		},
+	}
+	Assignment{Bucket: config.ExperimentBucket{Name: "trial", FloorStrategy: FloorStrategyRequest}}.Apply(req)
+	if req.AdUnits[0].Floor == nil || req.Bidders[0].AdUnits[0].Floor == nil {
+		t.Errorf("The request strategy should keep the floors")
+	}
+	Assignment{Bucket: config.ExperimentBucket{Name: "trial", FloorStrategy: FloorStrategyNone}}.Apply(req)
+	if req.AdUnits[0].Floor != nil || req.Bidders[0].AdUnits[0].Floor != nil {
+		t.Errorf("The none strategy should remove the floors")
+	}
+}
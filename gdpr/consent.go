@@ -0,0 +1,166 @@
+package gdpr
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These are the bit offsets of the fields in a TCF2 core string which are needed for enforcement.
+// See https://github.com/InteractiveAdvertisingBureau/GDPR-Transparency-and-Consent-Framework.
+const (
+	versionOffset             = 0
+	vendorListVersionOffset   = 120
+	specialFeatureOptInOffset = 140
+	purposesConsentOffset     = 152
+	purposesLIOffset          = 176
+	vendorConsentOffset       = 213
+)
+
+// SpecialFeaturePreciseGeo is the TCF2 special feature which allows precise geolocation data to be used.
+const SpecialFeaturePreciseGeo = 1
+
+// Consent is a parsed TCF2 consent string. Only its core segment is read.
+type Consent struct {
+	vendorListVersion    uint16
+	specialFeatureOptIns uint16
+	purposesConsent      uint32
+	purposesLI           uint32
+	vendorConsent        vendorSet
+	vendorLI             vendorSet
+}
+
+// ParseConsent parses the TCF2 consent string from user.ext.consent.
+func ParseConsent(consent string) (*Consent, error) {
+	core := strings.SplitN(consent, ".", 2)[0]
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(core, "="))
+	if err != nil {
+		return nil, fmt.Errorf("consent string isn't base64url: %v", err)
+	}
+	bits := &bitReader{data: data}
+	if version := bits.read(versionOffset, 6); version != 2 {
+		return nil, fmt.Errorf("consent string must be TCF version 2. Got %d", version)
+	}
+	parsed := &Consent{
+		vendorListVersion:    uint16(bits.read(vendorListVersionOffset, 12)),
+		specialFeatureOptIns: uint16(bits.read(specialFeatureOptInOffset, 12)),
+		purposesConsent:      uint32(bits.read(purposesConsentOffset, 24)),
+		purposesLI:           uint32(bits.read(purposesLIOffset, 24)),
+	}
+	offset := vendorConsentOffset
+	if parsed.vendorConsent, offset = parseVendorSet(bits, offset); bits.err != nil {
+		return nil, bits.err
+	}
+	if parsed.vendorLI, _ = parseVendorSet(bits, offset); bits.err != nil {
+		return nil, bits.err
+	}
+	return parsed, nil
+}
+
+// VendorListVersion returns the version of the Global Vendor List which the user was shown.
+func (c *Consent) VendorListVersion() uint16 {
+	return c.vendorListVersion
+}
+
+// PurposeConsent returns true if the user consented to the purpose, which is numbered from 1 to 24.
+func (c *Consent) PurposeConsent(purpose int) bool {
+	return hasBit(c.purposesConsent, 24, purpose)
+}
+
+// PurposeLI returns true if the user didn't object to the purpose being based on legitimate interest.
+func (c *Consent) PurposeLI(purpose int) bool {
+	return hasBit(c.purposesLI, 24, purpose)
+}
+
+// SpecialFeatureOptIn returns true if the user opted into the special feature, which is numbered from 1 to 12.
+func (c *Consent) SpecialFeatureOptIn(feature int) bool {
+	return hasBit(uint32(c.specialFeatureOptIns), 12, feature)
+}
+
+// VendorConsent returns true if the user consented to the vendor with the Global Vendor List ID.
+func (c *Consent) VendorConsent(vendorID uint16) bool {
+	return c.vendorConsent.contains(vendorID)
+}
+
+// VendorLI returns true if the vendor may rely on legitimate interest.
+func (c *Consent) VendorLI(vendorID uint16) bool {
+	return c.vendorLI.contains(vendorID)
+}
+
+// hasBit returns true if the nth of the field's bits is set, counting from 1 at the most significant bit.
+func hasBit(field uint32, size int, n int) bool {
+	if n < 1 || n > size {
+		return false
+	}
+	return field&(1<<uint(size-n)) != 0
+}
+
+// vendorSet is a TCF2 vendor section, which is either a bitfield or a list of ranges.
+type vendorSet struct {
+	maxVendorID uint16
+	bitfield    []bool
+	ranges      [][2]uint16
+}
+
+func parseVendorSet(bits *bitReader, offset int) (vendorSet, int) {
+	set := vendorSet{maxVendorID: uint16(bits.read(offset, 16))}
+	isRange := bits.read(offset+16, 1) == 1
+	offset += 17
+	if !isRange {
+		set.bitfield = make([]bool, set.maxVendorID)
+		for i := range set.bitfield {
+			set.bitfield[i] = bits.read(offset+i, 1) == 1
+		}
+		return set, offset + int(set.maxVendorID)
+	}
+	entries := int(bits.read(offset, 12))
+	offset += 12
+	for i := 0; i < entries && bits.err == nil; i++ {
+		isARange := bits.read(offset, 1) == 1
+		start := uint16(bits.read(offset+1, 16))
+		end := start
+		offset += 17
+		if isARange {
+			end = uint16(bits.read(offset, 16))
+			offset += 16
+		}
+		set.ranges = append(set.ranges, [2]uint16{start, end})
+	}
+	return set, offset
+}
+
+func (set vendorSet) contains(vendorID uint16) bool {
+	if vendorID == 0 || vendorID > set.maxVendorID {
+		return false
+	}
+	if set.bitfield != nil {
+		return set.bitfield[vendorID-1]
+	}
+	for _, r := range set.ranges {
+		if vendorID >= r[0] && vendorID <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var errTooShort = errors.New("consent string is too short")
+
+// bitReader reads big-endian bit fields. Reads past the end of the data return 0, and set err.
+type bitReader struct {
+	data []byte
+	err  error
+}
+
+func (r *bitReader) read(offset int, length int) uint64 {
+	if offset+length > len(r.data)*8 {
+		r.err = errTooShort
+		return 0
+	}
+	var value uint64
+	for i := offset; i < offset+length; i++ {
+		value = value<<1 | uint64(r.data[i/8]>>uint(7-i%8)&1)
+	}
+	return value
+}
@@ -0,0 +1,151 @@
+package gdpr
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// tcf2 describes a consent string for the tests. Unlisted purposes, vendors and features aren't allowed.
+type tcf2 struct {
+	version       uint16
+	purposes      []int
+	purposesLI    []int
+	vendors       []uint16
+	vendorsLI     []uint16
+	features      []int
+	rangeEncoding bool
+}
+
+type bitWriter struct {
+	bits []bool
+}
+
+func (w *bitWriter) write(value uint64, length int) {
+	for i := length - 1; i >= 0; i-- {
+		w.bits = append(w.bits, value>>uint(i)&1 == 1)
+	}
+}
+
+func flags(set []int, size int) uint64 {
+	var field uint64
+	for _, n := range set {
+		field |= 1 << uint(size-n)
+	}
+	return field
+}
+
+func (w *bitWriter) writeVendors(vendors []uint16, rangeEncoding bool) {
+	var maxVendorID uint16
+	for _, vendor := range vendors {
+		if vendor > maxVendorID {
+			maxVendorID = vendor
+		}
+	}
+	w.write(uint64(maxVendorID), 16)
+	if rangeEncoding {
+		w.write(1, 1)
+		w.write(uint64(len(vendors)), 12)
+		for _, vendor := range vendors {
+			w.write(0, 1)
+			w.write(uint64(vendor), 16)
+		}
+		return
+	}
+	w.write(0, 1)
+	for id := uint16(1); id <= maxVendorID; id++ {
+		var consented uint64
+		for _, vendor := range vendors {
+			if vendor == id {
+				consented = 1
+			}
+		}
+		w.write(consented, 1)
+	}
+}
+
+func (c tcf2) String() string {
+	w := &bitWriter{}
+	w.write(2, 6)                  // Version
+	w.write(0, 36+36+12+12+6+12)   // Created through ConsentLanguage
+	w.write(uint64(c.version), 12) // VendorListVersion
+	w.write(2, 6)                  // TcfPolicyVersion
+	w.write(0, 2)                  // IsServiceSpecific and UseNonStandardTexts
+	w.write(flags(c.features, 12), 12)
+	w.write(flags(c.purposes, 24), 24)
+	w.write(flags(c.purposesLI, 24), 24)
+	w.write(0, 13) // PurposeOneTreatment and PublisherCC
+	w.writeVendors(c.vendors, c.rangeEncoding)
+	w.writeVendors(c.vendorsLI, c.rangeEncoding)
+	return w.String()
+}
+
+// String returns the bits in base64url, padded with zeros to a whole byte.
+func (w *bitWriter) String() string {
+	data := make([]byte, (len(w.bits)+7)/8)
+	for i, bit := range w.bits {
+		if bit {
+			data[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestParseConsent(t *testing.T) {
+	for _, rangeEncoding := range []bool{false, true} {
+		consent, err := ParseConsent(tcf2{
+			version:       48,
+			purposes:      []int{1, 2},
+			purposesLI:    []int{7},
+			vendors:       []uint16{32, 52},
+			vendorsLI:     []uint16{76},
+			features:      []int{SpecialFeaturePreciseGeo},
+			rangeEncoding: rangeEncoding,
+		}.String())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if consent.VendorListVersion() != 48 {
+			t.Errorf("Expected vendor list version 48. Got %d", consent.VendorListVersion())
+		}
+		if !consent.PurposeConsent(1) || !consent.PurposeConsent(2) || consent.PurposeConsent(3) || consent.PurposeConsent(25) {
+			t.Errorf("Expected consent for purposes 1 and 2 only")
+		}
+		if !consent.PurposeLI(7) || consent.PurposeLI(1) {
+			t.Errorf("Expected legitimate interest for purpose 7 only")
+		}
+		if !consent.VendorConsent(32) || !consent.VendorConsent(52) || consent.VendorConsent(33) || consent.VendorConsent(500) {
+			t.Errorf("Expected consent for vendors 32 and 52 only, with range encoding %t", rangeEncoding)
+		}
+		if !consent.VendorLI(76) || consent.VendorLI(32) {
+			t.Errorf("Expected legitimate interest for vendor 76 only, with range encoding %t", rangeEncoding)
+		}
+		if !consent.SpecialFeatureOptIn(SpecialFeaturePreciseGeo) || consent.SpecialFeatureOptIn(2) {
+			t.Errorf("Expected an opt-in to precise geolocation only")
+		}
+	}
+}
+
+func TestParseConsentSegments(t *testing.T) {
+	core := tcf2{purposes: []int{1}, vendors: []uint16{10}}.String()
+	consent, err := ParseConsent(core + ".YAAAAAAAAAAA")
+	if err != nil {
+		t.Fatalf("Segments after the core string should be ignored: %v", err)
+	}
+	if !consent.VendorConsent(10) {
+		t.Error("Expected consent for vendor 10")
+	}
+}
+
+func TestParseBadConsent(t *testing.T) {
+	bad := []string{
+		"",
+		"not base64!",
+		"BOEFEAyOEFEAyAHABDENAI4AAAB9vABAASA", // TCF version 1
+		tcf2{vendors: []uint16{32}}.String()[:30],
+	}
+	for _, consent := range bad {
+		if _, err := ParseConsent(consent); err == nil {
+			t.Errorf("Expected an error for %q", consent)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Package gdpr enforces the user's TCF2 consent. Bidders which the user hasn't consented to don't get
+// their IDs or precise location. The consent may also come from a Global Privacy Platform (GPP) string.
+// Requests which are covered by COPPA are anonymized for every bidder, whatever the consent.
+package gdpr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+)
+
+// Restrictions are the personal data which a bidder mustn't get. The zero value restricts nothing.
+type Restrictions struct {
+	// UserIDs removes user.id, user.buyeruid, user.ext.eids and the device's advertising IDs.
+	UserIDs bool
+	// PreciseGeo rounds the latitude and longitude to two decimals, and masks the device's IPs.
+	PreciseGeo bool
+}
+
+// Enforcer decides which personal data each bidder may get, from the request's GDPR signals.
+//
+// A nil *Enforcer is valid, and only restricts requests which are covered by COPPA.
+type Enforcer struct {
+	defaultApplies bool
+	purposes       []purposeRule
+	vendorIDs      map[string]uint16
+	vendorLists    *VendorLists
+	denyOnFailure  bool
+}
+
+// These are the algorithms which a purpose can be enforced with.
+const (
+	// EnforceAlgoFull needs the user's consent for the purpose and the vendor, and the vendor's declaration in the
+	// vendor list. Legitimate interest can stand in for consent, except for purpose 1.
+	EnforceAlgoFull = "full"
+	// EnforceAlgoBasic only needs the user's consent, or legitimate interest, for the purpose.
+	EnforceAlgoBasic = "basic"
+)
+
+// purposeRule is how one purpose is enforced.
+type purposeRule struct {
+	purpose    int
+	basic      bool
+	exceptions map[string]bool
+}
+
+// NewEnforcer returns an Enforcer for the config, or nil if enforcement is disabled. The vendorIDs are the
+// bidders' Global Vendor List IDs, keyed by bidder code. Bidders without one never get personal data if
+// GDPR applies, unless every purpose is enforced with the basic algorithm or excepts them.
+//
+// If there are vendorLists, bidders also need to have declared each purpose in the version of the list which
+// the user consented with. They may be nil, in which case only the consent string is checked.
+//
+// It returns an error if the config's purposes are invalid.
+func NewEnforcer(cfg config.GDPR, vendorIDs map[string]uint16, vendorLists *VendorLists) (*Enforcer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	lowercased := make(map[string]uint16, len(vendorIDs))
+	for bidder, vendorID := range vendorIDs {
+		lowercased[strings.ToLower(bidder)] = vendorID
+	}
+	purposes, err := purposeRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Enforcer{
+		defaultApplies: cfg.DefaultValue == "1",
+		purposes:       purposes,
+		vendorIDs:      lowercased,
+		vendorLists:    vendorLists,
+		denyOnFailure:  cfg.VendorList.OnFetchFailure == "deny",
+	}, nil
+}
+
+func purposeRules(cfg config.GDPR) ([]purposeRule, error) {
+	for key := range cfg.Purposes {
+		purpose, err := strconv.Atoi(strings.TrimPrefix(key, "purpose"))
+		if !strings.HasPrefix(key, "purpose") || err != nil || purpose < 1 || purpose > 10 {
+			return nil, fmt.Errorf("gdpr.purposes.%s must be named purpose1 to purpose10", key)
+		}
+	}
+	rules := make([]purposeRule, 0, len(cfg.EnforcePurposes))
+	for _, purpose := range cfg.EnforcePurposes {
+		key := fmt.Sprintf("purpose%d", purpose)
+		purposeCfg := cfg.Purposes[key]
+		if purposeCfg.EnforceAlgo != "" && purposeCfg.EnforceAlgo != EnforceAlgoFull && purposeCfg.EnforceAlgo != EnforceAlgoBasic {
+			return nil, fmt.Errorf("gdpr.purposes.%s.enforce_algo must be %s or %s. Got %q", key, EnforceAlgoFull, EnforceAlgoBasic, purposeCfg.EnforceAlgo)
+		}
+		exceptions := make(map[string]bool, len(purposeCfg.VendorExceptions))
+		for _, bidder := range purposeCfg.VendorExceptions {
+			exceptions[strings.ToLower(bidder)] = true
+		}
+		rules = append(rules, purposeRule{
+			purpose:    purpose,
+			basic:      purposeCfg.EnforceAlgo == EnforceAlgoBasic,
+			exceptions: exceptions,
+		})
+	}
+	return rules, nil
+}
+
+type regsExt struct {
+	GDPR *int8 `json:"gdpr"`
+}
+
+type userExt struct {
+	Consent string `json:"consent"`
+}
+
+// Restrictions returns what the bidder mustn't get, given the request's regs.ext.gdpr and user.ext.consent.
+//
+// A GPP string in regs.ext.gpp stands in for the dedicated fields which the request doesn't have. GDPR applies
+// if regs.ext.gpp_sid lists the TCF EU v2 section, and that section is the consent string.
+//
+// If regs.coppa is 1, every bidder is restricted, even if GDPR enforcement is disabled.
+func (e *Enforcer) Restrictions(regs *openrtb.Regs, user *openrtb.User, bidder string) Restrictions {
+	if COPPAApplies(regs) {
+		return Restrictions{UserIDs: true, PreciseGeo: true}
+	}
+	if e == nil {
+		return Restrictions{}
+	}
+	gpp, sids := readGPP(regs)
+	if !e.applies(regs, sids) {
+		return Restrictions{}
+	}
+	var ext userExt
+	if user != nil && len(user.Ext) > 0 {
+		json.Unmarshal(user.Ext, &ext)
+	}
+	if ext.Consent == "" && gpp != nil {
+		ext.Consent = gpp.Section(GPPSectionTCFEUv2)
+	}
+	consent, err := ParseConsent(ext.Consent)
+	if err != nil {
+		return Restrictions{UserIDs: true, PreciseGeo: true}
+	}
+	bidder = strings.ToLower(bidder)
+	if !e.allowed(consent, bidder, e.vendorIDs[bidder]) {
+		return Restrictions{UserIDs: true, PreciseGeo: true}
+	}
+	return Restrictions{PreciseGeo: !consent.SpecialFeatureOptIn(SpecialFeaturePreciseGeo)}
+}
+
+func (e *Enforcer) applies(regs *openrtb.Regs, gppSIDs []int) bool {
+	var ext regsExt
+	if regs != nil && len(regs.Ext) > 0 {
+		json.Unmarshal(regs.Ext, &ext)
+	}
+	if ext.GDPR == nil {
+		if gppSIDs != nil {
+			return containsSection(gppSIDs, GPPSectionTCFEUv2)
+		}
+		return e.defaultApplies
+	}
+	return *ext.GDPR == 1
+}
+
+// COPPAApplies returns true if the request is directed to children, and so covered by the Children's Online
+// Privacy Protection Act.
+func COPPAApplies(regs *openrtb.Regs) bool {
+	return regs != nil && regs.COPPA == 1
+}
+
+// allowed returns true if the bidder has consent, or legitimate interest, for every enforced purpose.
+// Purpose 1 can't be based on legitimate interest. The bidder's code must be lowercase.
+func (e *Enforcer) allowed(consent *Consent, bidder string, vendorID uint16) bool {
+	// The vendor list is only looked up once a purpose needs it.
+	var declared *Vendor
+	checkedList := false
+	for _, rule := range e.purposes {
+		purpose := rule.purpose
+		if rule.exceptions[bidder] {
+			continue
+		}
+		if rule.basic {
+			if consent.PurposeConsent(purpose) || (purpose != 1 && consent.PurposeLI(purpose)) {
+				continue
+			}
+			return false
+		}
+		if vendorID == 0 {
+			return false
+		}
+		if !checkedList {
+			var ok bool
+			if declared, ok = e.declared(consent.VendorListVersion(), vendorID); !ok {
+				return false
+			}
+			checkedList = true
+		}
+		if consent.PurposeConsent(purpose) && consent.VendorConsent(vendorID) && (declared == nil || declared.ConsentPurpose(purpose)) {
+			continue
+		}
+		if purpose != 1 && consent.PurposeLI(purpose) && consent.VendorLI(vendorID) && (declared == nil || declared.LIPurpose(purpose)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// declared returns what the vendor declared in the version of the vendor list, or nil if the purposes
+// shouldn't be checked against the list. It returns false if the vendor mustn't get personal data at all.
+func (e *Enforcer) declared(version uint16, vendorID uint16) (*Vendor, bool) {
+	if e.vendorLists == nil {
+		return nil, true
+	}
+	list, err := e.vendorLists.Get(version)
+	if err != nil {
+		return nil, !e.denyOnFailure
+	}
+	vendor, ok := list.Vendors[vendorID]
+	if !ok {
+		return nil, false
+	}
+	return &vendor, true
+}
+
+// Apply removes the restricted data from the request. The request's User and Device are copied before
+// they're changed, since they may be shared with other bidders' requests.
+func (r Restrictions) Apply(request *openrtb.BidRequest) {
+	if r.UserIDs {
+		if request.User != nil {
+			user := *request.User
+			user.ID = ""
+			user.BuyerUID = ""
+			user.Ext = withoutEIDs(user.Ext)
+			request.User = &user
+		}
+		if request.Device != nil {
+			device := *request.Device
+			device.IFA = ""
+			device.DIDSHA1 = ""
+			device.DIDMD5 = ""
+			device.DPIDSHA1 = ""
+			device.DPIDMD5 = ""
+			device.MACSHA1 = ""
+			device.MACMD5 = ""
+			request.Device = &device
+		}
+	}
+	if r.PreciseGeo {
+		if request.User != nil && request.User.Geo != nil {
+			user := *request.User
+			user.Geo = coarseGeo(user.Geo)
+			request.User = &user
+		}
+		if request.Device != nil {
+			device := *request.Device
+			device.IP = maskIP(device.IP, 24)
+			device.IPv6 = maskIP(device.IPv6, 56)
+			if device.Geo != nil {
+				device.Geo = coarseGeo(device.Geo)
+			}
+			request.Device = &device
+		}
+	}
+}
+
+// withoutEIDs removes the eids from user.ext, but keeps the rest, like the consent string.
+func withoutEIDs(ext openrtb.RawJSON) openrtb.RawJSON {
+	if len(ext) == 0 {
+		return ext
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(ext, &fields); err != nil {
+		return nil
+	}
+	if _, ok := fields["eids"]; !ok {
+		return ext
+	}
+	delete(fields, "eids")
+	if len(fields) == 0 {
+		return nil
+	}
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return stripped
+}
+
+func coarseGeo(geo *openrtb.Geo) *openrtb.Geo {
+	coarse := *geo
+	coarse.Lat = math.Floor(coarse.Lat*100+0.5) / 100
+	coarse.Lon = math.Floor(coarse.Lon*100+0.5) / 100
+	return &coarse
+}
+
+// maskIP zeroes all but the first bits of the IP. Invalid IPs are removed.
+func maskIP(ip string, bits int) string {
+	if ip == "" {
+		return ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(bits, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(bits, 128)).String()
+}
@@ -0,0 +1,295 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+)
+
+func userWithConsent(consent string) *openrtb.User {
+	ext, _ := json.Marshal(map[string]string{"consent": consent})
+	return &openrtb.User{ID: "u1", Ext: ext}
+}
+
+var gdprApplies = &openrtb.Regs{Ext: openrtb.RawJSON(`{"gdpr":1}`)}
+
+func TestRestrictions(t *testing.T) {
+	enforcer, _ := NewEnforcer(config.GDPR{Enabled: true, DefaultValue: "0", EnforcePurposes: []int{1, 2}}, map[string]uint16{
+		"appnexus":      32,
+		"rubicon":       52,
+		"indexExchange": 10,
+	}, nil)
+	consent := tcf2{
+		purposes:   []int{1, 2},
+		purposesLI: []int{2},
+		vendors:    []uint16{32, 10},
+		vendorsLI:  []uint16{52},
+		features:   []int{SpecialFeaturePreciseGeo},
+	}.String()
+	noGeo := tcf2{purposes: []int{1, 2}, vendors: []uint16{32}}.String()
+	noPurpose1 := tcf2{purposes: []int{2}, purposesLI: []int{1, 2}, vendors: []uint16{32}, vendorsLI: []uint16{32}}.String()
+
+	all := Restrictions{UserIDs: true, PreciseGeo: true}
+	tests := []struct {
+		description string
+		regs        *openrtb.Regs
+		user        *openrtb.User
+		bidder      string
+		expected    Restrictions
+	}{
+		{"consented vendor", gdprApplies, userWithConsent(consent), "appnexus", Restrictions{}},
+		{"bidder codes are case-insensitive", gdprApplies, userWithConsent(consent), "indexexchange", Restrictions{}},
+		{"purpose 1 needs consent", gdprApplies, userWithConsent(consent), "rubicon", all},
+		{"purpose 1 can't use legitimate interest", gdprApplies, userWithConsent(noPurpose1), "appnexus", all},
+		{"bidders without a vendor ID", gdprApplies, userWithConsent(consent), "pubmatic", all},
+		{"no precise geo opt-in", gdprApplies, userWithConsent(noGeo), "appnexus", Restrictions{PreciseGeo: true}},
+		{"missing consent", gdprApplies, &openrtb.User{ID: "u1"}, "appnexus", all},
+		{"invalid consent", gdprApplies, userWithConsent("invalid"), "appnexus", all},
+		{"GDPR doesn't apply", &openrtb.Regs{Ext: openrtb.RawJSON(`{"gdpr":0}`)}, nil, "pubmatic", Restrictions{}},
+		{"default value", nil, nil, "pubmatic", Restrictions{}},
+	}
+	for _, test := range tests {
+		if restrictions := enforcer.Restrictions(test.regs, test.user, test.bidder); restrictions != test.expected {
+			t.Errorf("%s: expected %+v. Got %+v", test.description, test.expected, restrictions)
+		}
+	}
+
+	strict, _ := NewEnforcer(config.GDPR{Enabled: true, DefaultValue: "1", EnforcePurposes: []int{1}}, nil, nil)
+	if restrictions := strict.Restrictions(nil, nil, "appnexus"); restrictions != all {
+		t.Errorf("GDPR should apply by default if the default value is 1. Got %+v", restrictions)
+	}
+}
+
+func TestRestrictionsGPP(t *testing.T) {
+	enforcer, _ := NewEnforcer(config.GDPR{Enabled: true, DefaultValue: "1", EnforcePurposes: []int{1}}, map[string]uint16{"appnexus": 32}, nil)
+	consent := tcf2{purposes: []int{1}, vendors: []uint16{32}, features: []int{SpecialFeaturePreciseGeo}}.String()
+	denied := tcf2{purposes: []int{1}}.String()
+	gppRegs := func(gpp string, sids string) *openrtb.Regs {
+		return &openrtb.Regs{Ext: openrtb.RawJSON(`{"gpp":"DBACNY~` + gpp + `~1YN-","gpp_sid":` + sids + `}`)}
+	}
+
+	all := Restrictions{UserIDs: true, PreciseGeo: true}
+	tests := []struct {
+		description string
+		regs        *openrtb.Regs
+		user        *openrtb.User
+		expected    Restrictions
+	}{
+		{"consent in the TCF section", gppRegs(consent, "[2,6]"), nil, Restrictions{}},
+		{"no consent in the TCF section", gppRegs(denied, "[2]"), nil, all},
+		{"TCF section doesn't apply", gppRegs(denied, "[6]"), nil, Restrictions{}},
+		{"user.ext.consent takes precedence", gppRegs(denied, "[2]"), userWithConsent(consent), Restrictions{}},
+		{"regs.ext.gdpr takes precedence", &openrtb.Regs{Ext: openrtb.RawJSON(`{"gdpr":0,"gpp_sid":[2]}`)}, nil, Restrictions{}},
+	}
+	for _, test := range tests {
+		if restrictions := enforcer.Restrictions(test.regs, test.user, "appnexus"); restrictions != test.expected {
+			t.Errorf("%s: expected %+v. Got %+v", test.description, test.expected, restrictions)
+		}
+	}
+}
+
+func TestRestrictionsVendorList(t *testing.T) {
+	lists := NewVendorLists(nil, func(version uint16) (*VendorList, error) {
+		if version != 48 {
+			return nil, errors.New("not found")
+		}
+		return &VendorList{Version: 48, Vendors: map[uint16]Vendor{
+			32: {Purposes: []int{1, 2}},
+			52: {Purposes: []int{1}},
+			76: {Purposes: []int{1}, FlexiblePurposes: []int{2}},
+		}}, nil
+	}, time.Hour)
+	vendorIDs := map[string]uint16{"appnexus": 32, "rubicon": 52, "pubmatic": 76, "lifestreet": 67}
+	allow, _ := NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1, 2}, VendorList: config.GVL{OnFetchFailure: "allow"}}, vendorIDs, lists)
+	deny, _ := NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1, 2}, VendorList: config.GVL{OnFetchFailure: "deny"}}, vendorIDs, lists)
+
+	all := Restrictions{UserIDs: true, PreciseGeo: true}
+	consent := func(version uint16) *openrtb.User {
+		return userWithConsent(tcf2{
+			version:    version,
+			purposes:   []int{1, 2},
+			purposesLI: []int{2},
+			vendors:    []uint16{32, 52, 67, 76},
+			vendorsLI:  []uint16{76},
+			features:   []int{SpecialFeaturePreciseGeo},
+		}.String())
+	}
+
+	// The versions are fetched in the background, so the first request for each one falls back.
+	if restrictions := allow.Restrictions(gdprApplies, consent(48), "rubicon"); restrictions != (Restrictions{}) {
+		t.Errorf("The consent string alone should be checked while the list is fetched. Got %+v", restrictions)
+	}
+	if restrictions := deny.Restrictions(gdprApplies, consent(49), "appnexus"); restrictions != all {
+		t.Errorf("Every bidder should be restricted while the list is fetched in deny mode. Got %+v", restrictions)
+	}
+	waitForVendorList(t, lists, 48)
+
+	tests := []struct {
+		description string
+		enforcer    *Enforcer
+		user        *openrtb.User
+		bidder      string
+		expected    Restrictions
+	}{
+		{"declared purposes", allow, consent(48), "appnexus", Restrictions{}},
+		{"undeclared purpose", allow, consent(48), "rubicon", all},
+		{"flexible purpose", allow, consent(48), "pubmatic", Restrictions{}},
+		{"vendor missing from the list", allow, consent(48), "lifestreet", all},
+		{"missing version in allow mode", allow, consent(49), "rubicon", Restrictions{}},
+		{"missing version in deny mode", deny, consent(49), "appnexus", all},
+	}
+	for _, test := range tests {
+		if restrictions := test.enforcer.Restrictions(gdprApplies, test.user, test.bidder); restrictions != test.expected {
+			t.Errorf("%s: expected %+v. Got %+v", test.description, test.expected, restrictions)
+		}
+	}
+}
+
+func TestRestrictionsPurposeConfig(t *testing.T) {
+	enforcer, err := NewEnforcer(config.GDPR{
+		Enabled:         true,
+		EnforcePurposes: []int{1, 2},
+		Purposes: map[string]config.GDPRPurpose{
+			"purpose1": {VendorExceptions: []string{"Rubicon"}},
+			"purpose2": {EnforceAlgo: EnforceAlgoBasic},
+		},
+	}, map[string]uint16{"appnexus": 32, "rubicon": 52}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	all := Restrictions{UserIDs: true, PreciseGeo: true}
+	tests := []struct {
+		description string
+		consent     tcf2
+		bidder      string
+		expected    Restrictions
+	}{
+		{"basic purpose without vendor consent", tcf2{purposes: []int{1, 2}, vendors: []uint16{32}}, "appnexus", Restrictions{PreciseGeo: true}},
+		{"basic purpose with legitimate interest", tcf2{purposes: []int{1}, purposesLI: []int{2}, vendors: []uint16{32}}, "appnexus", Restrictions{PreciseGeo: true}},
+		{"basic purpose without consent", tcf2{purposes: []int{1}, vendors: []uint16{32}}, "appnexus", all},
+		{"full purpose without vendor consent", tcf2{purposes: []int{1, 2}}, "appnexus", all},
+		{"vendor exception", tcf2{purposes: []int{2}}, "rubicon", Restrictions{PreciseGeo: true}},
+		{"vendor exception still needs the other purposes", tcf2{}, "rubicon", all},
+		{"bidders without a vendor ID on a full purpose", tcf2{purposes: []int{1, 2}}, "pubmatic", all},
+	}
+	for _, test := range tests {
+		if restrictions := enforcer.Restrictions(gdprApplies, userWithConsent(test.consent.String()), test.bidder); restrictions != test.expected {
+			t.Errorf("%s: expected %+v. Got %+v", test.description, test.expected, restrictions)
+		}
+	}
+
+	basic, _ := NewEnforcer(config.GDPR{
+		Enabled:         true,
+		EnforcePurposes: []int{1},
+		Purposes:        map[string]config.GDPRPurpose{"purpose1": {EnforceAlgo: EnforceAlgoBasic}},
+	}, nil, nil)
+	if restrictions := basic.Restrictions(gdprApplies, userWithConsent(tcf2{purposes: []int{1}}.String()), "pubmatic"); restrictions != (Restrictions{PreciseGeo: true}) {
+		t.Errorf("Basic enforcement shouldn't need a vendor ID. Got %+v", restrictions)
+	}
+	if restrictions := basic.Restrictions(gdprApplies, userWithConsent(tcf2{purposesLI: []int{1}}.String()), "pubmatic"); restrictions != all {
+		t.Errorf("Purpose 1 can't use legitimate interest with basic enforcement. Got %+v", restrictions)
+	}
+}
+
+func TestNewEnforcerErrors(t *testing.T) {
+	tests := []struct {
+		description string
+		purposes    map[string]config.GDPRPurpose
+		expected    string
+	}{
+		{"unknown algorithm", map[string]config.GDPRPurpose{"purpose2": {EnforceAlgo: "strict"}}, `gdpr.purposes.purpose2.enforce_algo must be full or basic. Got "strict"`},
+		{"bad purpose name", map[string]config.GDPRPurpose{"p2": {}}, "gdpr.purposes.p2 must be named purpose1 to purpose10"},
+		{"purpose out of range", map[string]config.GDPRPurpose{"purpose11": {}}, "gdpr.purposes.purpose11 must be named purpose1 to purpose10"},
+	}
+	for _, test := range tests {
+		_, err := NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1, 2}, Purposes: test.purposes}, nil, nil)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected %q. Got %v", test.description, test.expected, err)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	enforcer, _ := NewEnforcer(config.GDPR{Enabled: false, DefaultValue: "1"}, nil, nil)
+	if enforcer != nil {
+		t.Fatal("Disabled enforcement should return a nil Enforcer")
+	}
+	if restrictions := enforcer.Restrictions(gdprApplies, nil, "appnexus"); restrictions != (Restrictions{}) {
+		t.Errorf("A nil Enforcer shouldn't restrict anything. Got %+v", restrictions)
+	}
+}
+
+func TestCOPPA(t *testing.T) {
+	all := Restrictions{UserIDs: true, PreciseGeo: true}
+	coppa := &openrtb.Regs{COPPA: 1, Ext: openrtb.RawJSON(`{"gdpr":0}`)}
+	consented, _ := NewEnforcer(config.GDPR{Enabled: true, EnforcePurposes: []int{1}}, map[string]uint16{"appnexus": 32}, nil)
+	consent := userWithConsent(tcf2{purposes: []int{1}, vendors: []uint16{32}, features: []int{SpecialFeaturePreciseGeo}}.String())
+
+	var disabled *Enforcer
+	if restrictions := disabled.Restrictions(coppa, nil, "appnexus"); restrictions != all {
+		t.Errorf("COPPA should restrict every bidder, even without GDPR enforcement. Got %+v", restrictions)
+	}
+	if restrictions := consented.Restrictions(coppa, consent, "appnexus"); restrictions != all {
+		t.Errorf("COPPA should restrict bidders which the user consented to. Got %+v", restrictions)
+	}
+	if restrictions := consented.Restrictions(&openrtb.Regs{Ext: coppa.Ext}, consent, "appnexus"); restrictions != (Restrictions{}) {
+		t.Errorf("Requests without regs.coppa shouldn't be restricted. Got %+v", restrictions)
+	}
+	if COPPAApplies(nil) || COPPAApplies(&openrtb.Regs{}) || !COPPAApplies(coppa) {
+		t.Error("COPPA should only apply if regs.coppa is 1")
+	}
+}
+
+func TestApply(t *testing.T) {
+	user := &openrtb.User{
+		ID:       "u1",
+		BuyerUID: "b1",
+		Geo:      &openrtb.Geo{Lat: 51.507351, Lon: -0.127758, Country: "GBR"},
+		Ext:      openrtb.RawJSON(`{"consent":"abc","eids":[{"source":"example.com"}]}`),
+	}
+	device := &openrtb.Device{
+		IP:   "192.0.2.55",
+		IPv6: "2001:db8:85a3:1234:5678:8a2e:370:7334",
+		IFA:  "ifa",
+		UA:   "ua",
+		Geo:  &openrtb.Geo{Lat: 51.507351, Lon: -0.127758},
+	}
+	request := &openrtb.BidRequest{User: user, Device: device}
+	Restrictions{UserIDs: true, PreciseGeo: true}.Apply(request)
+
+	if request.User.ID != "" || request.User.BuyerUID != "" || string(request.User.Ext) != `{"consent":"abc"}` {
+		t.Errorf("The user's IDs should be removed. Got %#v with ext %s", request.User, request.User.Ext)
+	}
+	if request.Device.IFA != "" || request.Device.UA != "ua" {
+		t.Errorf("Only the device's IDs should be removed. Got %#v", request.Device)
+	}
+	if geo := request.User.Geo; geo.Lat != 51.51 || geo.Lon != -0.13 || geo.Country != "GBR" {
+		t.Errorf("The user's geo should be rounded. Got %#v", geo)
+	}
+	if geo := request.Device.Geo; geo.Lat != 51.51 || geo.Lon != -0.13 {
+		t.Errorf("The device's geo should be rounded. Got %#v", geo)
+	}
+	if request.Device.IP != "192.0.2.0" || request.Device.IPv6 != "2001:db8:85a3:1200::" {
+		t.Errorf("The device's IPs should be masked. Got %s and %s", request.Device.IP, request.Device.IPv6)
+	}
+	if user.ID != "u1" || user.Geo.Lat != 51.507351 || device.IP != "192.0.2.55" || device.IFA != "ifa" {
+		t.Error("The original user and device shouldn't be changed")
+	}
+
+	request = &openrtb.BidRequest{User: user, Device: device}
+	Restrictions{PreciseGeo: true}.Apply(request)
+	if request.User.ID != "u1" || request.Device.IFA != "ifa" || request.User.Geo.Lat != 51.51 {
+		t.Errorf("Only the geo should be changed. Got %#v and %#v", request.User, request.Device)
+	}
+
+	request = &openrtb.BidRequest{User: user, Device: device}
+	Restrictions{}.Apply(request)
+	if request.User != user || request.Device != device {
+		t.Error("Requests without restrictions shouldn't be changed")
+	}
+}
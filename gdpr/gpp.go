@@ -0,0 +1,240 @@
+package gdpr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// These are the Global Privacy Platform sections which Prebid Server understands.
+// See https://github.com/InteractiveAdvertisingBureau/Global-Privacy-Platform.
+const (
+	GPPSectionTCFEUv2 = 2
+	GPPSectionUSPv1   = 6
+)
+
+// GPP is a parsed Global Privacy Platform string. Only its header is decoded. The sections are kept as the
+// strings which their own specs define, so the TCF EU v2 section is a TCF2 consent string, and the US Privacy
+// section is a us_privacy string.
+type GPP struct {
+	sections map[int]string
+}
+
+// ParseGPP parses the GPP string from regs.ext.gpp.
+func ParseGPP(gpp string) (*GPP, error) {
+	parts := strings.Split(gpp, "~")
+	// The header's last characters may hold fewer than 8 bits, which base64 decoding would drop.
+	// Zeros are added so that every bit is decoded.
+	header := strings.TrimRight(parts[0], "=")
+	header += strings.Repeat("A", (4-len(header)%4)%4)
+	data, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("GPP header isn't base64url: %v", err)
+	}
+	bits := &bitReader{data: data}
+	if headerType := bits.read(0, 6); headerType != 3 {
+		return nil, fmt.Errorf("GPP header must have type 3. Got %d", headerType)
+	}
+	ids, err := parseFibonacciRange(bits, 12)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != len(parts)-1 {
+		return nil, fmt.Errorf("GPP header lists %d sections, but the string has %d", len(ids), len(parts)-1)
+	}
+	parsed := &GPP{sections: make(map[int]string, len(ids))}
+	for i, id := range ids {
+		parsed.sections[id] = parts[i+1]
+	}
+	return parsed, nil
+}
+
+// Section returns the section with the ID, or an empty string if the GPP string doesn't have it.
+func (g *GPP) Section(id int) string {
+	return g.sections[id]
+}
+
+// parseFibonacciRange reads the header's section IDs, which start at the offset. Each ID, or range of IDs, is
+// stored as the Fibonacci-encoded difference from the one before it.
+func parseFibonacciRange(bits *bitReader, offset int) ([]int, error) {
+	count := int(bits.read(offset, 12))
+	offset += 12
+	var ids []int
+	last := 0
+	for i := 0; i < count && bits.err == nil; i++ {
+		isRange := bits.read(offset, 1) == 1
+		offset++
+		var start, end int
+		start, offset = readFibonacci(bits, offset)
+		start += last
+		end = start
+		if isRange {
+			end, offset = readFibonacci(bits, offset)
+			end += start
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+		last = end
+	}
+	if bits.err != nil {
+		return nil, fmt.Errorf("GPP header is too short")
+	}
+	return ids, nil
+}
+
+// readFibonacci reads a Fibonacci-coded integer, which ends with two 1 bits in a row.
+func readFibonacci(bits *bitReader, offset int) (int, int) {
+	value := 0
+	previous, current := 1, 1
+	lastBit := uint64(0)
+	for bits.err == nil {
+		bit := bits.read(offset, 1)
+		offset++
+		if bit == 1 && lastBit == 1 {
+			return value, offset
+		}
+		if bit == 1 {
+			value += current
+		}
+		previous, current = current, previous+current
+		lastBit = bit
+	}
+	return 0, offset
+}
+
+// gppRegsExt holds the GPP signals in regs.ext. The OpenRTB 2.6 regs.gpp and regs.gpp_sid are moved there
+// by MoveGPPToExt.
+type gppRegsExt struct {
+	GPP    string `json:"gpp"`
+	GPPSID []int  `json:"gpp_sid"`
+}
+
+// readGPP returns the request's GPP string and the IDs of the sections which apply to it. The string is nil
+// if the request has none, or if it's invalid.
+func readGPP(regs *openrtb.Regs) (*GPP, []int) {
+	var ext gppRegsExt
+	if regs == nil || len(regs.Ext) == 0 || json.Unmarshal(regs.Ext, &ext) != nil {
+		return nil, nil
+	}
+	if ext.GPP == "" {
+		return nil, ext.GPPSID
+	}
+	gpp, err := ParseGPP(ext.GPP)
+	if err != nil {
+		return nil, ext.GPPSID
+	}
+	return gpp, ext.GPPSID
+}
+
+func containsSection(sids []int, id int) bool {
+	for _, sid := range sids {
+		if sid == id {
+			return true
+		}
+	}
+	return false
+}
+
+// MoveGPPToExt copies the OpenRTB 2.6 regs.gpp and regs.gpp_sid from the raw request into regs.ext, which is
+// where the rest of Prebid Server reads them from. The OpenRTB model has no fields of its own for them.
+// Values which are already in regs.ext take precedence. The regs are copied before they're changed.
+func MoveGPPToExt(body []byte, regs *openrtb.Regs) (*openrtb.Regs, error) {
+	var raw struct {
+		Regs *gppRegsExt `json:"regs"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil || raw.Regs == nil {
+		return regs, err
+	}
+	if raw.Regs.GPP == "" && raw.Regs.GPPSID == nil {
+		return regs, nil
+	}
+	moved := openrtb.Regs{}
+	if regs != nil {
+		moved = *regs
+	}
+	fields := make(map[string]json.RawMessage)
+	if len(moved.Ext) > 0 {
+		if err := json.Unmarshal(moved.Ext, &fields); err != nil {
+			return regs, fmt.Errorf("regs.ext must be an object: %v", err)
+		}
+	}
+	if _, ok := fields["gpp"]; !ok && raw.Regs.GPP != "" {
+		fields["gpp"], _ = json.Marshal(raw.Regs.GPP)
+	}
+	if _, ok := fields["gpp_sid"]; !ok && raw.Regs.GPPSID != nil {
+		fields["gpp_sid"], _ = json.Marshal(raw.Regs.GPPSID)
+	}
+	ext, err := json.Marshal(fields)
+	if err != nil {
+		return regs, err
+	}
+	moved.Ext = ext
+	return &moved, nil
+}
+
+// DowngradeGPP rewrites the request for a bidder which doesn't read GPP. The gpp and gpp_sid are removed from
+// regs.ext, and the sections which apply become the signals which predate GPP: the TCF EU v2 section becomes
+// regs.ext.gdpr and user.ext.consent, and the US Privacy section becomes regs.ext.us_privacy. Signals which the
+// request already has are kept. The request's Regs and User are copied before they're changed.
+func DowngradeGPP(request *openrtb.BidRequest) {
+	if request.Regs == nil || len(request.Regs.Ext) == 0 {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(request.Regs.Ext, &fields); err != nil {
+		return
+	}
+	_, hasGPP := fields["gpp"]
+	_, hasSID := fields["gpp_sid"]
+	if !hasGPP && !hasSID {
+		return
+	}
+	gpp, sids := readGPP(request.Regs)
+	delete(fields, "gpp")
+	delete(fields, "gpp_sid")
+
+	if _, ok := fields["gdpr"]; !ok && sids != nil {
+		if containsSection(sids, GPPSectionTCFEUv2) {
+			fields["gdpr"] = json.RawMessage("1")
+		} else {
+			fields["gdpr"] = json.RawMessage("0")
+		}
+	}
+	if gpp != nil && containsSection(sids, GPPSectionTCFEUv2) && gpp.Section(GPPSectionTCFEUv2) != "" {
+		request.User = withConsent(request.User, gpp.Section(GPPSectionTCFEUv2))
+	}
+	if _, ok := fields["us_privacy"]; !ok && gpp != nil && containsSection(sids, GPPSectionUSPv1) && gpp.Section(GPPSectionUSPv1) != "" {
+		fields["us_privacy"], _ = json.Marshal(gpp.Section(GPPSectionUSPv1))
+	}
+
+	regs := *request.Regs
+	regs.Ext = nil
+	if len(fields) > 0 {
+		regs.Ext, _ = json.Marshal(fields)
+	}
+	request.Regs = &regs
+}
+
+// withConsent returns a copy of the user with the consent string in user.ext.consent, unless it already has one.
+func withConsent(user *openrtb.User, consent string) *openrtb.User {
+	withConsent := openrtb.User{}
+	if user != nil {
+		withConsent = *user
+	}
+	fields := make(map[string]json.RawMessage)
+	if len(withConsent.Ext) > 0 {
+		if err := json.Unmarshal(withConsent.Ext, &fields); err != nil {
+			return user
+		}
+	}
+	if _, ok := fields["consent"]; ok {
+		return user
+	}
+	fields["consent"], _ = json.Marshal(consent)
+	withConsent.Ext, _ = json.Marshal(fields)
+	return &withConsent
+}
@@ -0,0 +1,168 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func TestParseGPP(t *testing.T) {
+	consent := tcf2{purposes: []int{1}, vendors: []uint16{32}}.String()
+	gpp, err := ParseGPP("DBACNY~" + consent + "~1YN-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gpp.Section(GPPSectionTCFEUv2) != consent {
+		t.Errorf("Expected the TCF EU v2 section to be %s. Got %s", consent, gpp.Section(GPPSectionTCFEUv2))
+	}
+	if gpp.Section(GPPSectionUSPv1) != "1YN-" {
+		t.Errorf("Expected the US Privacy section to be 1YN-. Got %s", gpp.Section(GPPSectionUSPv1))
+	}
+	if gpp.Section(7) != "" {
+		t.Errorf("Sections which aren't in the header should be empty. Got %s", gpp.Section(7))
+	}
+}
+
+func TestParseGPPRanges(t *testing.T) {
+	w := &bitWriter{}
+	w.write(3, 6)  // Type
+	w.write(1, 6)  // Version
+	w.write(2, 12) // NumEntries
+	w.write(0, 1)  // 2, as 0 + 2
+	w.write(3, 3)
+	w.write(1, 1) // 7 to 8, as 2 + 5 and 7 + 1
+	w.write(3, 5)
+	w.write(3, 2)
+	gpp, err := ParseGPP(w.String() + "~tcf~national~california")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[int]string{2: "tcf", 7: "national", 8: "california"}
+	if !reflect.DeepEqual(gpp.sections, expected) {
+		t.Errorf("Expected sections %v. Got %v", expected, gpp.sections)
+	}
+}
+
+func TestParseBadGPP(t *testing.T) {
+	for _, gpp := range []string{
+		"",
+		"!!!",
+		"BBACNY~a~b",   // The header's type isn't 3
+		"DBACNY~a",     // There are fewer sections than the header lists
+		"DBACNY~a~b~c", // There are more sections than the header lists
+		"DBAC",         // The header ends before its section IDs do
+	} {
+		if _, err := ParseGPP(gpp); err == nil {
+			t.Errorf("Expected an error for %q", gpp)
+		}
+	}
+}
+
+func TestMoveGPPToExt(t *testing.T) {
+	regs, err := MoveGPPToExt([]byte(`{"regs":{"gpp":"DBABMA~a","gpp_sid":[2]}}`), &openrtb.Regs{COPPA: 1, Ext: openrtb.RawJSON(`{"gdpr":1}`)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertJSON(t, regs.Ext, `{"gdpr":1,"gpp":"DBABMA~a","gpp_sid":[2]}`)
+	if regs.COPPA != 1 {
+		t.Errorf("The rest of regs should be kept")
+	}
+
+	original := &openrtb.Regs{Ext: openrtb.RawJSON(`{"gpp":"DBACNY~a~b"}`)}
+	regs, err = MoveGPPToExt([]byte(`{"regs":{"gpp":"DBABMA~a","gpp_sid":[2]}}`), original)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertJSON(t, regs.Ext, `{"gpp":"DBACNY~a~b","gpp_sid":[2]}`)
+	assertJSON(t, original.Ext, `{"gpp":"DBACNY~a~b"}`)
+
+	if regs, _ := MoveGPPToExt([]byte(`{"regs":{"coppa":1}}`), original); regs != original {
+		t.Errorf("Requests without GPP should keep their regs")
+	}
+	if regs, _ := MoveGPPToExt([]byte(`{"id":"1"}`), nil); regs != nil {
+		t.Errorf("Requests without regs shouldn't get any")
+	}
+}
+
+func TestDowngradeGPP(t *testing.T) {
+	consent := tcf2{purposes: []int{1}, vendors: []uint16{32}}.String()
+	gpp := "DBACNY~" + consent + "~1YN-"
+	tests := []struct {
+		description  string
+		regsExt      string
+		userExt      string
+		expectedRegs string
+		expectedUser string
+	}{
+		{
+			description:  "applicable sections",
+			regsExt:      `{"gpp":"` + gpp + `","gpp_sid":[2,6]}`,
+			expectedRegs: `{"gdpr":1,"us_privacy":"1YN-"}`,
+			expectedUser: `{"consent":"` + consent + `"}`,
+		},
+		{
+			description:  "sections which don't apply",
+			regsExt:      `{"gpp":"` + gpp + `","gpp_sid":[6]}`,
+			expectedRegs: `{"gdpr":0,"us_privacy":"1YN-"}`,
+		},
+		{
+			description:  "dedicated fields take precedence",
+			regsExt:      `{"gdpr":0,"us_privacy":"1NN-","gpp":"` + gpp + `","gpp_sid":[2,6]}`,
+			userExt:      `{"consent":"other","eids":[]}`,
+			expectedRegs: `{"gdpr":0,"us_privacy":"1NN-"}`,
+			expectedUser: `{"consent":"other","eids":[]}`,
+		},
+		{
+			description:  "invalid GPP",
+			regsExt:      `{"gpp":"invalid"}`,
+			expectedRegs: ``,
+		},
+	}
+	for _, test := range tests {
+		regs := &openrtb.Regs{Ext: openrtb.RawJSON(test.regsExt)}
+		request := &openrtb.BidRequest{Regs: regs}
+		if test.userExt != "" {
+			request.User = &openrtb.User{Ext: openrtb.RawJSON(test.userExt)}
+		}
+		DowngradeGPP(request)
+		if test.expectedRegs == "" {
+			if len(request.Regs.Ext) != 0 {
+				t.Errorf("%s: expected regs.ext to be removed. Got %s", test.description, request.Regs.Ext)
+			}
+		} else {
+			assertJSON(t, request.Regs.Ext, test.expectedRegs)
+		}
+		if test.expectedUser == "" {
+			if request.User != nil {
+				t.Errorf("%s: expected no user. Got %+v", test.description, request.User)
+			}
+		} else {
+			assertJSON(t, request.User.Ext, test.expectedUser)
+		}
+		if string(regs.Ext) != test.regsExt {
+			t.Errorf("%s: the original regs shouldn't change. Got %s", test.description, regs.Ext)
+		}
+	}
+
+	untouched := &openrtb.Regs{Ext: openrtb.RawJSON(`{"gdpr":1}`)}
+	request := &openrtb.BidRequest{Regs: untouched}
+	DowngradeGPP(request)
+	if request.Regs != untouched {
+		t.Errorf("Requests without GPP should keep their regs")
+	}
+}
+
+func assertJSON(t *testing.T, actual openrtb.RawJSON, expected string) {
+	t.Helper()
+	var actualValue, expectedValue interface{}
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Errorf("Invalid JSON %s: %v", actual, err)
+		return
+	}
+	json.Unmarshal([]byte(expected), &expectedValue)
+	if !reflect.DeepEqual(actualValue, expectedValue) {
+		t.Errorf("Expected %s. Got %s", expected, actual)
+	}
+}
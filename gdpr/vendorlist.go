@@ -0,0 +1,157 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/prebid/prebid-server/remotefile"
+)
+
+// VendorList is one version of the IAB's Global Vendor List. Only what enforcement needs is kept.
+type VendorList struct {
+	Version uint16            `json:"vendorListVersion"`
+	Vendors map[uint16]Vendor `json:"vendors"`
+}
+
+// Vendor is what a vendor declared in the Global Vendor List. Its flexible purposes can be based on either
+// consent or legitimate interest.
+type Vendor struct {
+	Purposes         []int `json:"purposes"`
+	LegIntPurposes   []int `json:"legIntPurposes"`
+	FlexiblePurposes []int `json:"flexiblePurposes"`
+}
+
+// ConsentPurpose returns true if the vendor may base the purpose on the user's consent.
+func (v Vendor) ConsentPurpose(purpose int) bool {
+	return hasPurpose(v.Purposes, purpose) || hasPurpose(v.FlexiblePurposes, purpose)
+}
+
+// LIPurpose returns true if the vendor may base the purpose on legitimate interest.
+func (v Vendor) LIPurpose(purpose int) bool {
+	return hasPurpose(v.LegIntPurposes, purpose) || hasPurpose(v.FlexiblePurposes, purpose)
+}
+
+func hasPurpose(purposes []int, purpose int) bool {
+	for _, declared := range purposes {
+		if declared == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseVendorList parses a vendor-list.json into a *VendorList.
+func ParseVendorList(body []byte) (interface{}, error) {
+	var list VendorList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	if list.Version == 0 {
+		return nil, errors.New("vendor list has no vendorListVersion")
+	}
+	return &list, nil
+}
+
+// FetchVersionFunc fetches one version of the vendor list.
+type FetchVersionFunc func(version uint16) (*VendorList, error)
+
+// NewVersionFetcher fetches the versions from the urlTemplate, which has a %d for the version number.
+func NewVersionFetcher(client *http.Client, urlTemplate string) FetchVersionFunc {
+	return func(version uint16) (*VendorList, error) {
+		fetcher, err := remotefile.New(client, fmt.Sprintf(urlTemplate, version))
+		if err != nil {
+			return nil, err
+		}
+		list, err := fetcher.FetchFunc(10*time.Second, ParseVendorList)()
+		if err != nil {
+			return nil, err
+		}
+		return list.(*VendorList), nil
+	}
+}
+
+// maxConcurrentFetches limits how many versions of the vendor list are fetched at once. Consent strings come
+// from clients, so they could otherwise start a fetch for every version.
+const maxConcurrentFetches = 4
+
+// VendorLists keeps the versions of the vendor list which consent strings were made with.
+//
+// The latest version is kept up to date by a Refresher. Older versions never change, so each is fetched once,
+// in the background, the first time a consent string needs it. Failed fetches are retried after retryAfter.
+// Versions past the latest are never fetched. It's safe for concurrent use.
+type VendorLists struct {
+	latest       *refresher.Refresher
+	fetchVersion FetchVersionFunc
+	retryAfter   time.Duration
+
+	mutex    sync.Mutex
+	lists    map[uint16]*VendorList
+	fetching map[uint16]bool
+	failed   map[uint16]time.Time
+}
+
+// NewVendorLists makes a VendorLists. The latest may be nil, and otherwise fetches with ParseVendorList.
+func NewVendorLists(latest *refresher.Refresher, fetchVersion FetchVersionFunc, retryAfter time.Duration) *VendorLists {
+	return &VendorLists{
+		latest:       latest,
+		fetchVersion: fetchVersion,
+		retryAfter:   retryAfter,
+		lists:        make(map[uint16]*VendorList),
+		fetching:     make(map[uint16]bool),
+		failed:       make(map[uint16]time.Time),
+	}
+}
+
+// Get returns the version of the vendor list. Callers are never blocked on a fetch: if the version isn't
+// here yet, it's fetched in the background, and Get returns an error.
+func (l *VendorLists) Get(version uint16) (*VendorList, error) {
+	if version == 0 {
+		return nil, errors.New("vendor list version 0 doesn't exist")
+	}
+	if l.latest != nil {
+		if data, _ := l.latest.Get(); data != nil {
+			latest := data.(*VendorList)
+			if latest.Version == version {
+				return latest, nil
+			}
+			if version > latest.Version {
+				return nil, fmt.Errorf("version %d of the vendor list is newer than the latest, %d", version, latest.Version)
+			}
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if list, ok := l.lists[version]; ok {
+		return list, nil
+	}
+	if !l.fetching[version] && len(l.fetching) < maxConcurrentFetches && time.Since(l.failed[version]) > l.retryAfter {
+		l.fetching[version] = true
+		go l.fetch(version)
+	}
+	return nil, fmt.Errorf("version %d of the vendor list hasn't been fetched yet", version)
+}
+
+func (l *VendorLists) fetch(version uint16) {
+	list, err := l.fetchVersion(version)
+	if err == nil && list.Version != version {
+		err = fmt.Errorf("got version %d instead", list.Version)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.fetching, version)
+	if err != nil {
+		l.failed[version] = time.Now()
+		glog.Warningf("Failed to fetch version %d of the vendor list: %v", version, err)
+		return
+	}
+	delete(l.failed, version)
+	l.lists[version] = list
+}
@@ -0,0 +1,160 @@
+package gdpr
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/rcrowley/go-metrics"
+)
+
+const vendorListJSON = `{"vendorListVersion":48,"vendors":{"32":{"id":32,"purposes":[1,2],"legIntPurposes":[7],"flexiblePurposes":[2,7]}}}`
+
+// waitForVendorList waits for a background fetch of the version to finish.
+func waitForVendorList(t *testing.T, lists *VendorLists, version uint16) *VendorList {
+	for i := 0; i < 100; i++ {
+		lists.mutex.Lock()
+		list, fetching := lists.lists[version], lists.fetching[version]
+		lists.mutex.Unlock()
+		if list != nil || !fetching {
+			return list
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Version %d of the vendor list is still being fetched", version)
+	return nil
+}
+
+func TestParseVendorList(t *testing.T) {
+	parsed, err := ParseVendorList([]byte(vendorListJSON))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list := parsed.(*VendorList)
+	vendor, ok := list.Vendors[32]
+	if list.Version != 48 || !ok {
+		t.Fatalf("Expected version 48 with vendor 32. Got %#v", list)
+	}
+	if !vendor.ConsentPurpose(1) || !vendor.ConsentPurpose(7) || vendor.ConsentPurpose(3) {
+		t.Errorf("Vendor 32 should be allowed consent for purposes 1, 2 and 7. Got %#v", vendor)
+	}
+	if !vendor.LIPurpose(2) || vendor.LIPurpose(1) {
+		t.Errorf("Vendor 32 should be allowed legitimate interest for purposes 2 and 7. Got %#v", vendor)
+	}
+
+	for _, bad := range []string{`[]`, `{"vendors":{}}`} {
+		if _, err := ParseVendorList([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
+
+func TestVendorListsOnDemand(t *testing.T) {
+	var calls int32
+	lists := NewVendorLists(nil, func(version uint16) (*VendorList, error) {
+		atomic.AddInt32(&calls, 1)
+		return &VendorList{Version: version}, nil
+	}, time.Hour)
+
+	if _, err := lists.Get(48); err == nil {
+		t.Error("The first Get should return an error while the version is fetched")
+	}
+	waitForVendorList(t, lists, 48)
+	if list, err := lists.Get(48); err != nil || list.Version != 48 {
+		t.Errorf("Expected version 48. Got %#v and %v", list, err)
+	}
+	lists.Get(48)
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Versions should only be fetched once. Got %d fetches", calls)
+	}
+	if _, err := lists.Get(0); err == nil {
+		t.Error("Version 0 should never be fetched")
+	}
+}
+
+func TestVendorListsRetry(t *testing.T) {
+	var calls int32
+	lists := NewVendorLists(nil, func(version uint16) (*VendorList, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("unreachable")
+	}, time.Hour)
+
+	lists.Get(48)
+	waitForVendorList(t, lists, 48)
+	lists.Get(48)
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Failed fetches shouldn't be retried before retryAfter. Got %d fetches", calls)
+	}
+
+	lists.mutex.Lock()
+	lists.failed[48] = time.Now().Add(-2 * time.Hour)
+	lists.mutex.Unlock()
+	lists.Get(48)
+	waitForVendorList(t, lists, 48)
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("Failed fetches should be retried after retryAfter. Got %d fetches", calls)
+	}
+}
+
+func TestVendorListsConcurrentFetches(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	lists := NewVendorLists(nil, func(version uint16) (*VendorList, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &VendorList{Version: version}, nil
+	}, time.Hour)
+
+	for version := uint16(1); version <= 2*maxConcurrentFetches; version++ {
+		lists.Get(version)
+	}
+	lists.mutex.Lock()
+	fetching := len(lists.fetching)
+	lists.mutex.Unlock()
+	if fetching != maxConcurrentFetches {
+		t.Errorf("Expected %d fetches at once. Got %d", maxConcurrentFetches, fetching)
+	}
+
+	close(release)
+	waitForVendorList(t, lists, 1)
+	lists.Get(2 * maxConcurrentFetches)
+	waitForVendorList(t, lists, 2*maxConcurrentFetches)
+	if list, err := lists.Get(2 * maxConcurrentFetches); err != nil || list == nil {
+		t.Errorf("Versions should be fetched once earlier fetches finish. Got %v", err)
+	}
+}
+
+func TestVendorListsLatest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(vendorListJSON))
+	}))
+	defer server.Close()
+
+	latest := refresher.New("gvl", time.Hour, 0, func() (interface{}, error) {
+		return ParseVendorList([]byte(vendorListJSON))
+	}, metrics.NewRegistry())
+	latest.Start(nil)
+	lists := NewVendorLists(latest, func(version uint16) (*VendorList, error) {
+		return nil, errors.New("the latest version shouldn't be fetched again")
+	}, time.Hour)
+	if list, err := lists.Get(48); err != nil || list.Vendors[32].Purposes[0] != 1 {
+		t.Errorf("The latest version should come from the Refresher. Got %#v and %v", list, err)
+	}
+	if _, err := lists.Get(49); err == nil {
+		t.Error("Versions past the latest shouldn't exist")
+	}
+	lists.mutex.Lock()
+	if lists.fetching[49] {
+		t.Error("Versions past the latest shouldn't be fetched")
+	}
+	lists.mutex.Unlock()
+
+	fetchVersion := NewVersionFetcher(server.Client(), server.URL+"/vendor-list-v%d.json")
+	if list, err := fetchVersion(48); err != nil || list.Version != 48 {
+		t.Errorf("Expected version 48 from the server. Got %#v and %v", list, err)
+	}
+}
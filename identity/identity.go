@@ -0,0 +1,226 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Envelope is an encrypted identity, like a LiveRamp ATS envelope, which only a host-side service can read.
+// Pages send them in user.ext.envelopes.
+type Envelope struct {
+	Source   string `json:"source"`
+	Envelope string `json:"envelope"`
+}
+
+// EID is an extended ID for bidders, in the OpenRTB user.ext.eids format.
+type EID struct {
+	Source string `json:"source"`
+	UIDs   []UID  `json:"uids"`
+}
+
+type UID struct {
+	ID    string `json:"id"`
+	AType int    `json:"atype,omitempty"`
+}
+
+// Resolver turns identity envelopes into EIDs. Hosts with their own identity services can plug in
+// an implementation of this. Resolvers should give up promptly once the context is done.
+type Resolver interface {
+	Resolve(ctx context.Context, envelopes []Envelope) ([]EID, error)
+}
+
+// New returns the Resolver described by the config, or nil if identity resolution is disabled.
+func New(cfg config.IdentityResolution) Resolver {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	var resolver Resolver = &httpResolver{
+		client:   http.DefaultClient,
+		endpoint: cfg.Endpoint,
+		timeout:  time.Duration(cfg.TimeoutMillis) * time.Millisecond,
+	}
+	if cfg.CacheTTLSeconds > 0 {
+		resolver = newCachingResolver(resolver, time.Duration(cfg.CacheTTLSeconds)*time.Second)
+	}
+	return resolver
+}
+
+// httpResolver POSTs {"envelopes": [...]} to the host's service, which answers with {"eids": [...]}.
+type httpResolver struct {
+	client   *http.Client
+	endpoint string
+	timeout  time.Duration
+}
+
+type resolveRequest struct {
+	Envelopes []Envelope `json:"envelopes"`
+}
+
+type resolveResponse struct {
+	EIDs []EID `json:"eids"`
+}
+
+func (r *httpResolver) Resolve(ctx context.Context, envelopes []Envelope) ([]EID, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	body, err := json.Marshal(&resolveRequest{Envelopes: envelopes})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ctxhttp.Do(ctx, r.client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity service returned HTTP status %d", resp.StatusCode)
+	}
+	var parsed resolveResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, fmt.Errorf("bad identity service response: %v", err)
+	}
+	return parsed.EIDs, nil
+}
+
+// cachingResolver remembers the EIDs for each set of envelopes, since the same users come back
+// page after page. Failures aren't cached.
+type cachingResolver struct {
+	next Resolver
+	ttl  time.Duration
+
+	mutex     sync.Mutex
+	entries   map[string]cachedEIDs
+	lastSweep time.Time
+}
+
+type cachedEIDs struct {
+	eids   []EID
+	expiry time.Time
+}
+
+func newCachingResolver(next Resolver, ttl time.Duration) *cachingResolver {
+	return &cachingResolver{
+		next:    next,
+		ttl:     ttl,
+		entries: make(map[string]cachedEIDs),
+	}
+}
+
+func cacheKey(envelopes []Envelope) string {
+	parts := make([]string, len(envelopes))
+	for i, envelope := range envelopes {
+		parts[i] = envelope.Source + "\x00" + envelope.Envelope
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x01")
+}
+
+func (c *cachingResolver) Resolve(ctx context.Context, envelopes []Envelope) ([]EID, error) {
+	key := cacheKey(envelopes)
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expiry) {
+		return entry.eids, nil
+	}
+
+	eids, err := c.next.Resolve(ctx, envelopes)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.entries[key] = cachedEIDs{eids: eids, expiry: now.Add(c.ttl)}
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.lastSweep = now
+		for k, e := range c.entries {
+			if !now.Before(e.expiry) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.mutex.Unlock()
+	return eids, nil
+}
+
+// ResolveUser replaces the envelopes in user.ext with the EIDs they resolve to, adding to any
+// EIDs which were already there. The envelopes are always removed, since bidders can't read them.
+// If they can't be resolved, the user is left without them, and the error is returned.
+func ResolveUser(ctx context.Context, resolver Resolver, user *openrtb.User) error {
+	if resolver == nil || user == nil || len(user.Ext) == 0 {
+		return nil
+	}
+	var ext map[string]json.RawMessage
+	if err := json.Unmarshal(user.Ext, &ext); err != nil {
+		return nil
+	}
+	rawEnvelopes, ok := ext["envelopes"]
+	if !ok {
+		return nil
+	}
+	delete(ext, "envelopes")
+
+	var envelopes []Envelope
+	err := json.Unmarshal(rawEnvelopes, &envelopes)
+	if err == nil && len(envelopes) > 0 {
+		var resolved []EID
+		if resolved, err = resolver.Resolve(ctx, envelopes); err == nil && len(resolved) > 0 {
+			err = appendEIDs(ext, resolved)
+		}
+	}
+
+	newExt, marshalErr := json.Marshal(ext)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	user.Ext = newExt
+	return err
+}
+
+// appendEIDs adds the resolved EIDs to user.ext.eids. The existing EIDs are kept as they were sent,
+// ext and all. If user.ext.eids isn't a list, then it's left alone and the resolved EIDs are dropped.
+func appendEIDs(ext map[string]json.RawMessage, resolved []EID) error {
+	var eids []json.RawMessage
+	if rawEIDs, ok := ext["eids"]; ok {
+		if err := json.Unmarshal(rawEIDs, &eids); err != nil {
+			return fmt.Errorf("resolved EIDs weren't added, since user.ext.eids is invalid: %v", err)
+		}
+	}
+	for _, eid := range resolved {
+		rawEID, err := json.Marshal(eid)
+		if err != nil {
+			return err
+		}
+		eids = append(eids, rawEID)
+	}
+	rawEIDs, err := json.Marshal(eids)
+	if err != nil {
+		return err
+	}
+	ext["eids"] = rawEIDs
+	return nil
+}
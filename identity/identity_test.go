@@ -0,0 +1,140 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+)
+
+type countingResolver struct {
+	calls int
+	err   error
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, envelopes []Envelope) ([]EID, error) {
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []EID{{Source: envelopes[0].Source, UIDs: []UID{{ID: "resolved-" + envelopes[0].Envelope, AType: 3}}}}, nil
+}
+
+func TestResolveUser(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"envelopes":[{"source":"liveramp.com","envelope":"abc"}],"eids":[{"source":"id5-sync.com","uids":[{"id":"xyz"}],"ext":{"stype":"ppuid"}}],"other":1}`)}
+	if err := ResolveUser(context.Background(), &countingResolver{}, user); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var ext struct {
+		Envelopes json.RawMessage `json:"envelopes"`
+		EIDs      []EID           `json:"eids"`
+		Other     int             `json:"other"`
+	}
+	if err := json.Unmarshal(user.Ext, &ext); err != nil {
+		t.Fatalf("Bad user.ext: %v", err)
+	}
+	if ext.Envelopes != nil {
+		t.Errorf("The envelopes should be removed. Got %s", user.Ext)
+	}
+	if len(ext.EIDs) != 2 || ext.EIDs[0].Source != "id5-sync.com" || ext.EIDs[1].UIDs[0].ID != "resolved-abc" {
+		t.Errorf("The resolved EID should be added to the existing ones. Got %s", user.Ext)
+	}
+	if !strings.Contains(string(user.Ext), `"ext":{"stype":"ppuid"}`) {
+		t.Errorf("The existing EIDs should be kept as they were sent. Got %s", user.Ext)
+	}
+	if ext.Other != 1 {
+		t.Errorf("Other user.ext fields should be kept. Got %s", user.Ext)
+	}
+}
+
+func TestResolveUserInvalidEIDs(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"envelopes":[{"source":"liveramp.com","envelope":"abc"}],"eids":{"source":"id5-sync.com"}}`)}
+	if err := ResolveUser(context.Background(), &countingResolver{}, user); err == nil {
+		t.Errorf("Expected an error when the existing EIDs aren't a list")
+	}
+	if string(user.Ext) != `{"eids":{"source":"id5-sync.com"}}` {
+		t.Errorf("Invalid EIDs should be left alone. Got %s", user.Ext)
+	}
+}
+
+func TestResolveUserError(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"envelopes":[{"source":"liveramp.com","envelope":"abc"}]}`)}
+	if err := ResolveUser(context.Background(), &countingResolver{err: errors.New("down")}, user); err == nil {
+		t.Errorf("Expected the resolver's error")
+	}
+	if string(user.Ext) != "{}" {
+		t.Errorf("Unresolved envelopes should still be removed. Got %s", user.Ext)
+	}
+}
+
+func TestResolveUserWithoutEnvelopes(t *testing.T) {
+	resolver := &countingResolver{}
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"eids":[]}`)}
+	if err := ResolveUser(context.Background(), resolver, user); err != nil || resolver.calls != 0 {
+		t.Errorf("Users without envelopes shouldn't be resolved")
+	}
+	if string(user.Ext) != `{"eids":[]}` {
+		t.Errorf("user.ext shouldn't change. Got %s", user.Ext)
+	}
+}
+
+func TestCachingResolver(t *testing.T) {
+	next := &countingResolver{}
+	resolver := newCachingResolver(next, time.Minute)
+	envelopes := []Envelope{{Source: "liveramp.com", Envelope: "abc"}}
+	resolver.Resolve(context.Background(), envelopes)
+	eids, _ := resolver.Resolve(context.Background(), envelopes)
+	if next.calls != 1 || len(eids) != 1 {
+		t.Errorf("The second lookup should be cached. Got %d calls", next.calls)
+	}
+	resolver.Resolve(context.Background(), []Envelope{{Source: "liveramp.com", Envelope: "def"}})
+	if next.calls != 2 {
+		t.Errorf("Different envelopes should be looked up")
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req resolveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Envelopes) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"eids":[{"source":"liveramp.com","uids":[{"id":"rampid","atype":3}]}]}`))
+	}))
+	defer server.Close()
+
+	resolver := New(config.IdentityResolution{Endpoint: server.URL, TimeoutMillis: 1000})
+	eids, err := resolver.Resolve(context.Background(), []Envelope{{Source: "liveramp.com", Envelope: "abc"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(eids) != 1 || eids[0].UIDs[0].ID != "rampid" {
+		t.Errorf("Bad EIDs: %v", eids)
+	}
+}
+
+func TestHTTPResolverTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	resolver := New(config.IdentityResolution{Endpoint: server.URL, TimeoutMillis: 10})
+	if _, err := resolver.Resolve(context.Background(), []Envelope{{Source: "liveramp.com", Envelope: "abc"}}); err == nil {
+		t.Errorf("Slow lookups should time out")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if New(config.IdentityResolution{}) != nil {
+		t.Errorf("Resolution should be disabled without an endpoint")
+	}
+}
@@ -0,0 +1,178 @@
+package ivt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/refresher"
+)
+
+// These are the reasons why a request can be flagged as invalid traffic.
+const (
+	ReasonDatacenter = "datacenter"
+	ReasonBot        = "bot"
+)
+
+// Lists are the known sources of invalid traffic.
+type Lists struct {
+	Datacenters []*net.IPNet
+	// BotUserAgents are lowercased substrings of the User-Agent headers which bad bots send.
+	BotUserAgents []string
+}
+
+// NewLists parses the datacenter ranges, which are CIDRs or single IPs, and the bot User-Agent substrings.
+func NewLists(datacenters []string, botUserAgents []string) (*Lists, error) {
+	lists := &Lists{
+		Datacenters:   make([]*net.IPNet, 0, len(datacenters)),
+		BotUserAgents: make([]string, 0, len(botUserAgents)),
+	}
+	for _, datacenter := range datacenters {
+		if !strings.Contains(datacenter, "/") {
+			if ip := net.ParseIP(datacenter); ip != nil && ip.To4() != nil {
+				datacenter += "/32"
+			} else {
+				datacenter += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(datacenter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid datacenter range %s: %v", datacenter, err)
+		}
+		lists.Datacenters = append(lists.Datacenters, ipNet)
+	}
+	for _, ua := range botUserAgents {
+		if ua != "" {
+			lists.BotUserAgents = append(lists.BotUserAgents, strings.ToLower(ua))
+		}
+	}
+	return lists, nil
+}
+
+type listsFile struct {
+	Datacenters   []string `json:"datacenters"`
+	BotUserAgents []string `json:"bot_user_agents"`
+}
+
+// ParseLists parses a JSON file like {"datacenters": ["192.0.2.0/24"], "bot_user_agents": ["headlesschrome"]}.
+// It's meant to be used with remotefile.Fetcher.FetchFunc.
+func ParseLists(body []byte) (interface{}, error) {
+	var file listsFile
+	if err := json.Unmarshal(body, &file); err != nil {
+		return nil, fmt.Errorf("bad invalid traffic lists: %v", err)
+	}
+	return NewLists(file.Datacenters, file.BotUserAgents)
+}
+
+// Check returns the reason why the request looks like invalid traffic, or "" if it doesn't.
+func (l *Lists) Check(ip net.IP, userAgent string) string {
+	if ip != nil {
+		for _, datacenter := range l.Datacenters {
+			if datacenter.Contains(ip) {
+				return ReasonDatacenter
+			}
+		}
+	}
+	if userAgent != "" {
+		userAgent = strings.ToLower(userAgent)
+		for _, bot := range l.BotUserAgents {
+			if strings.Contains(userAgent, bot) {
+				return ReasonBot
+			}
+		}
+	}
+	return ""
+}
+
+// Provider supplies the latest Lists. Hosts can plug in their own, like a feed from an IVT vendor.
+type Provider interface {
+	Lists() (*Lists, error)
+}
+
+type staticProvider struct {
+	lists *Lists
+}
+
+func (p *staticProvider) Lists() (*Lists, error) {
+	return p.lists, nil
+}
+
+// NewStaticProvider returns a Provider whose Lists never change.
+func NewStaticProvider(lists *Lists) Provider {
+	return &staticProvider{lists: lists}
+}
+
+type refreshedProvider struct {
+	lists *refresher.Refresher
+}
+
+// Lists still returns stale data, since an old list of datacenters is better than none.
+func (p *refreshedProvider) Lists() (*Lists, error) {
+	data, err := p.lists.Get()
+	if data == nil {
+		return nil, err
+	}
+	return data.(*Lists), nil
+}
+
+// NewRefreshedProvider returns a Provider for lists which a Refresher fetches with ParseLists.
+func NewRefreshedProvider(lists *refresher.Refresher) Provider {
+	return &refreshedProvider{lists: lists}
+}
+
+// Filter screens requests for invalid traffic before the auction, so that it doesn't use up bidders' QPS.
+//
+// A nil *Filter is valid, and lets everything through.
+type Filter struct {
+	reject    bool
+	providers []Provider
+}
+
+// New returns a Filter for the config's mode, or nil if the filter is disabled. The providers are
+// checked in order, after the lists from the config.
+func New(cfg config.IVTFilter, providers ...Provider) (*Filter, error) {
+	var reject bool
+	switch cfg.Mode {
+	case "":
+		return nil, nil
+	case "flag":
+	case "reject":
+		reject = true
+	default:
+		return nil, fmt.Errorf("invalid traffic filter mode must be flag or reject. Got %s", cfg.Mode)
+	}
+	lists, err := NewLists(cfg.DatacenterRanges, cfg.BotUserAgents)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		reject:    reject,
+		providers: append([]Provider{NewStaticProvider(lists)}, providers...),
+	}, nil
+}
+
+// Check returns the reason why the request looks like invalid traffic, or "" if it doesn't.
+// Providers which fail are skipped.
+func (f *Filter) Check(ip string, userAgent string) string {
+	if f == nil {
+		return ""
+	}
+	parsedIP := net.ParseIP(ip)
+	for _, provider := range f.providers {
+		lists, err := provider.Lists()
+		if err != nil || lists == nil {
+			continue
+		}
+		if reason := lists.Check(parsedIP, userAgent); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// Rejects returns true if flagged requests shouldn't be sent to any bidders.
+func (f *Filter) Rejects() bool {
+	return f != nil && f.reject
+}
@@ -0,0 +1,87 @@
+package ivt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestFilter(t *testing.T) {
+	filter, err := New(config.IVTFilter{
+		Mode:             "flag",
+		DatacenterRanges: []string{"192.0.2.0/24", "198.51.100.7", "2001:db8::/32"},
+		BotUserAgents:    []string{"HeadlessChrome"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tests := []struct {
+		ip       string
+		ua       string
+		expected string
+	}{
+		{"192.0.2.55", "Mozilla/5.0", ReasonDatacenter},
+		{"198.51.100.7", "Mozilla/5.0", ReasonDatacenter},
+		{"198.51.100.8", "Mozilla/5.0", ""},
+		{"2001:db8::1", "Mozilla/5.0", ReasonDatacenter},
+		{"203.0.113.1", "Mozilla/5.0 (X11; Linux x86_64) headlesschrome/70.0", ReasonBot},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		if reason := filter.Check(test.ip, test.ua); reason != test.expected {
+			t.Errorf("%s with %q: expected %q. Got %q", test.ip, test.ua, test.expected, reason)
+		}
+	}
+	if filter.Rejects() {
+		t.Errorf("Filters in flag mode shouldn't reject requests")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	filter, err := New(config.IVTFilter{DatacenterRanges: []string{"192.0.2.0/24"}})
+	if err != nil || filter != nil {
+		t.Fatalf("The filter should be disabled without a mode")
+	}
+	if filter.Check("192.0.2.1", "") != "" || filter.Rejects() {
+		t.Errorf("A nil filter should let everything through")
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	if _, err := New(config.IVTFilter{Mode: "block"}); err == nil {
+		t.Errorf("Unknown modes should be rejected")
+	}
+	if _, err := New(config.IVTFilter{Mode: "reject", DatacenterRanges: []string{"192.0.2.0/99"}}); err == nil {
+		t.Errorf("Bad ranges should be rejected")
+	}
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Lists() (*Lists, error) {
+	return nil, errors.New("feed is down")
+}
+
+func TestProviders(t *testing.T) {
+	parsed, err := ParseLists([]byte(`{"datacenters":["203.0.113.0/24"],"bot_user_agents":["BadBot"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	filter, err := New(config.IVTFilter{Mode: "reject"}, failingProvider{}, NewStaticProvider(parsed.(*Lists)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reason := filter.Check("203.0.113.9", ""); reason != ReasonDatacenter {
+		t.Errorf("Providers after a failing one should still be checked. Got %q", reason)
+	}
+	if reason := filter.Check("", "badbot/1.0"); reason != ReasonBot {
+		t.Errorf("The provider's bots should be flagged. Got %q", reason)
+	}
+	if !filter.Rejects() {
+		t.Errorf("Filters in reject mode should reject requests")
+	}
+	if _, err := ParseLists([]byte(`{"datacenters":"oops"}`)); err == nil {
+		t.Errorf("Bad lists files should fail to parse")
+	}
+}
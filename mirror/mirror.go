@@ -0,0 +1,181 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// workers is the number of requests which can be in flight to the mirror at once.
+const workers = 4
+
+// forwardedHeaders are the only headers which the mirror gets. Cookies and client IPs are left out.
+var forwardedHeaders = []string{"Content-Type", "User-Agent", "Referer", "Origin"}
+
+// Mirror sends a sample of auction requests to a secondary server, like a staging cluster, so that new
+// builds can be tried on real traffic. Mirrored requests are fire-and-forget: nobody waits on them,
+// and they're dropped if the mirror falls behind.
+//
+// A nil *Mirror is valid, and mirrors nothing.
+type Mirror struct {
+	client     *http.Client
+	url        string
+	sampleRate float64
+	timeout    time.Duration
+	queue      chan *http.Request
+
+	sentMeter    metrics.Meter
+	droppedMeter metrics.Meter
+	errorMeter   metrics.Meter
+}
+
+// New returns a Mirror for the config, or nil if mirroring is disabled. Its workers run until the process exits.
+//
+// Mirrored requests are reported to the registry as mirror.sent, mirror.dropped and mirror.errors.
+func New(cfg config.Mirror, client *http.Client, registry metrics.Registry) (*Mirror, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("mirror.sample_rate must be between 0 and 1. Got %f", cfg.SampleRate)
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	m := &Mirror{
+		client:       client,
+		url:          cfg.URL,
+		sampleRate:   cfg.SampleRate,
+		timeout:      time.Duration(cfg.TimeoutMillis) * time.Millisecond,
+		queue:        make(chan *http.Request, queueSize),
+		sentMeter:    metrics.GetOrRegisterMeter("mirror.sent", registry),
+		droppedMeter: metrics.GetOrRegisterMeter("mirror.dropped", registry),
+		errorMeter:   metrics.GetOrRegisterMeter("mirror.errors", registry),
+	}
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m, nil
+}
+
+// Sampled returns true if the next request should be mirrored. Callers should check it before reading
+// the body, so that unsampled requests don't pay for a copy.
+func (m *Mirror) Sampled() bool {
+	return m != nil && m.sampleRate > 0 && rand.Float64() < m.sampleRate
+}
+
+// Send queues a scrubbed copy of the request for the mirror, with the same query string. The body must
+// have been read already, since the original request's body can only be read once. It never blocks.
+func (m *Mirror) Send(r *http.Request, body []byte) {
+	if m == nil {
+		return
+	}
+	url := m.url
+	if r.URL != nil && r.URL.RawQuery != "" {
+		if strings.Contains(url, "?") {
+			url += "&" + r.URL.RawQuery
+		} else {
+			url += "?" + r.URL.RawQuery
+		}
+	}
+	mirrored, err := http.NewRequest("POST", url, bytes.NewReader(Scrub(body)))
+	if err != nil {
+		m.errorMeter.Mark(1)
+		return
+	}
+	for _, header := range forwardedHeaders {
+		if value := r.Header.Get(header); value != "" {
+			mirrored.Header.Set(header, value)
+		}
+	}
+	select {
+	case m.queue <- mirrored:
+	default:
+		m.droppedMeter.Mark(1)
+	}
+}
+
+func (m *Mirror) work() {
+	for req := range m.queue {
+		m.send(req)
+	}
+}
+
+func (m *Mirror) send(req *http.Request) {
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	resp, err := ctxhttp.Do(ctx, m.client, req)
+	if err != nil {
+		m.errorMeter.Mark(1)
+		if glog.V(2) {
+			glog.Infof("Failed to mirror a request: %v", err)
+		}
+		return
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	m.sentMeter.Mark(1)
+}
+
+// Scrub removes the user's identity from a /auction request body: the user object, the device's IP
+// addresses, advertising ID and ext, and its precise location. Bodies which aren't JSON objects are replaced
+// with an empty object.
+func Scrub(body []byte) []byte {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return []byte("{}")
+	}
+	delete(req, "user")
+	if rawDevice, ok := req["device"]; ok {
+		if device, ok := scrubObject(rawDevice, "ip", "ipv6", "ifa", "dpidsha1", "dpidmd5", "didsha1", "didmd5", "macsha1", "macmd5", "ext"); ok {
+			req["device"] = device
+		} else {
+			delete(req, "device")
+		}
+	}
+	scrubbed, err := json.Marshal(req)
+	if err != nil {
+		return []byte("{}")
+	}
+	return scrubbed
+}
+
+// scrubObject removes the fields from a JSON object, and the lat and lon from its geo. It returns false if
+// the object is invalid.
+func scrubObject(raw json.RawMessage, fields ...string) (json.RawMessage, bool) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, false
+	}
+	for _, field := range fields {
+		delete(object, field)
+	}
+	if rawGeo, ok := object["geo"]; ok {
+		if geo, ok := scrubObject(rawGeo, "lat", "lon"); ok {
+			object["geo"] = geo
+		} else {
+			delete(object, "geo")
+		}
+	}
+	scrubbed, err := json.Marshal(object)
+	if err != nil {
+		return nil, false
+	}
+	return scrubbed, true
+}
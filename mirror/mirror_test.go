@@ -0,0 +1,78 @@
+package mirror
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestScrub(t *testing.T) {
+	body := `{"account_id":"1","user":{"buyeruid":"abc"},"device":{"ip":"1.2.3.4","ifa":"id","ua":"Mozilla"}}`
+	if scrubbed := string(Scrub([]byte(body))); scrubbed != `{"account_id":"1","device":{"ua":"Mozilla"}}` {
+		t.Errorf("The user and device IDs should be removed. Got %s", scrubbed)
+	}
+	body = `{"device":{"ua":"Mozilla","geo":{"lat":51.5074,"lon":-0.1278,"country":"GBR"},"ext":{"atts":3,"ifv":"id"}}}`
+	if scrubbed := string(Scrub([]byte(body))); scrubbed != `{"device":{"geo":{"country":"GBR"},"ua":"Mozilla"}}` {
+		t.Errorf("The device's location and ext should be removed. Got %s", scrubbed)
+	}
+	if scrubbed := string(Scrub([]byte("not json"))); scrubbed != "{}" {
+		t.Errorf("Bad bodies should be emptied. Got %s", scrubbed)
+	}
+}
+
+func TestSend(t *testing.T) {
+	type received struct {
+		url    string
+		body   string
+		cookie string
+		ua     string
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- received{url: r.URL.String(), body: string(body), cookie: r.Header.Get("Cookie"), ua: r.Header.Get("User-Agent")}
+	}))
+	defer server.Close()
+
+	m, err := New(config.Mirror{URL: server.URL + "/auction", SampleRate: 1}, server.Client(), metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !m.Sampled() {
+		t.Fatalf("Every request should be sampled")
+	}
+	req := httptest.NewRequest("POST", "/auction?debug=1", nil)
+	req.Header.Set("Cookie", "uids=abc")
+	req.Header.Set("User-Agent", "Mozilla")
+	m.Send(req, []byte(`{"account_id":"1","user":{"buyeruid":"abc"}}`))
+
+	select {
+	case got := <-requests:
+		if got.url != "/auction?debug=1" || got.body != `{"account_id":"1"}` || got.cookie != "" || got.ua != "Mozilla" {
+			t.Errorf("Bad mirrored request: %#v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The request wasn't mirrored")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if m, err := New(config.Mirror{}, http.DefaultClient, metrics.NewRegistry()); m != nil || err != nil {
+		t.Errorf("Mirroring should be off without a URL")
+	}
+	if _, err := New(config.Mirror{URL: "http://staging", SampleRate: 2}, http.DefaultClient, metrics.NewRegistry()); err == nil || !strings.Contains(err.Error(), "sample_rate") {
+		t.Errorf("Sample rates over 1 should be an error. Got %v", err)
+	}
+
+	var m *Mirror
+	if m.Sampled() {
+		t.Errorf("A nil Mirror shouldn't sample anything")
+	}
+	m.Send(httptest.NewRequest("POST", "/auction", nil), []byte("{}"))
+}
@@ -0,0 +1,114 @@
+package nobidcache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// Cache remembers which bidders recently declined to bid on each placement. A placement is an
+// account's ad unit code. Refresh-heavy placements can then skip bidders which just said no.
+// Requests without an account aren't cached, since their ad unit codes could come from any site.
+// It's safe for concurrent use.
+//
+// A nil *Cache is valid, and caches nothing.
+type Cache struct {
+	ttl      time.Duration
+	disabled map[string]bool
+
+	mutex     sync.Mutex
+	expiries  map[string]time.Time
+	lastSweep time.Time
+}
+
+// New returns a Cache with the config's settings, or nil if the cache is disabled.
+func New(cfg config.NoBidCache) *Cache {
+	if cfg.TTLSeconds <= 0 {
+		return nil
+	}
+	disabled := make(map[string]bool, len(cfg.DisabledBidders))
+	for _, bidder := range cfg.DisabledBidders {
+		disabled[strings.ToLower(bidder)] = true
+	}
+	return &Cache{
+		ttl:      time.Duration(cfg.TTLSeconds) * time.Second,
+		disabled: disabled,
+		expiries: make(map[string]time.Time),
+	}
+}
+
+func key(account string, bidder string, adUnitCode string) string {
+	return account + "\x00" + strings.ToLower(bidder) + "\x00" + adUnitCode
+}
+
+// Filter returns the ad units which the bidder hasn't declined recently, and the number which it has.
+func (c *Cache) Filter(account string, bidder string, units []pbs.PBSAdUnit) ([]pbs.PBSAdUnit, int) {
+	if c == nil || account == "" || c.disabled[strings.ToLower(bidder)] {
+		return units, 0
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var kept []pbs.PBSAdUnit
+	skipped := 0
+	for i, unit := range units {
+		if expiry, ok := c.expiries[key(account, bidder, unit.Code)]; ok && now.Before(expiry) {
+			if kept == nil {
+				kept = append(make([]pbs.PBSAdUnit, 0, len(units)), units[:i]...)
+			}
+			skipped++
+		} else if kept != nil {
+			kept = append(kept, unit)
+		}
+	}
+	if skipped == 0 {
+		return units, 0
+	}
+	return kept, skipped
+}
+
+// Record remembers the ad units which the bidder was asked about, but didn't bid on.
+// It should only be called if the bidder answered without an error.
+//
+// Declines are only recorded if the bidder had no bids at all. Adapters which call the bidder once per ad unit
+// drop the errors for some units when others have bids, so the units without bids may have failed rather
+// than declined. Bids on a unit clear its decline either way.
+func (c *Cache) Record(account string, bidder string, units []pbs.PBSAdUnit, bids pbs.PBSBidSlice) {
+	if c == nil || account == "" || c.disabled[strings.ToLower(bidder)] {
+		return
+	}
+	withBids := make(map[string]bool, len(bids))
+	for _, bid := range bids {
+		withBids[bid.AdUnitCode] = true
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, unit := range units {
+		k := key(account, bidder, unit.Code)
+		if withBids[unit.Code] {
+			delete(c.expiries, k)
+		} else if len(bids) == 0 {
+			c.expiries[k] = now.Add(c.ttl)
+		}
+	}
+	c.sweep(now)
+}
+
+// sweep drops expired entries once per TTL, so the cache doesn't grow forever.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for k, expiry := range c.expiries {
+		if !now.Before(expiry) {
+			delete(c.expiries, k)
+		}
+	}
+}
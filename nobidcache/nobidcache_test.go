@@ -0,0 +1,87 @@
+package nobidcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+var units = []pbs.PBSAdUnit{{Code: "top"}, {Code: "side"}, {Code: "footer"}}
+
+func TestDisabled(t *testing.T) {
+	cache := New(config.NoBidCache{})
+	if cache != nil {
+		t.Fatalf("The cache should be nil without a TTL")
+	}
+	cache.Record("acct", "appnexus", units, nil)
+	if kept, skipped := cache.Filter("acct", "appnexus", units); len(kept) != 3 || skipped != 0 {
+		t.Errorf("A nil cache should keep every ad unit. Got %v", kept)
+	}
+}
+
+func TestNoBidsAreSkipped(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5})
+	cache.Record("acct", "appnexus", units, nil)
+
+	kept, skipped := cache.Filter("acct", "appnexus", units)
+	if skipped != 3 || len(kept) != 0 {
+		t.Errorf("Every ad unit should be skipped. Got %v", kept)
+	}
+	if kept, _ := cache.Filter("other", "appnexus", units); len(kept) != 3 {
+		t.Errorf("No-bids on one account shouldn't affect another")
+	}
+	if kept, _ := cache.Filter("acct", "rubicon", units); len(kept) != 3 {
+		t.Errorf("No-bids from one bidder shouldn't affect another")
+	}
+}
+
+func TestBidsClearNoBids(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5})
+	cache.Record("acct", "appnexus", units, nil)
+	cache.Record("acct", "appnexus", units[:1], pbs.PBSBidSlice{{AdUnitCode: "top"}})
+	if kept, skipped := cache.Filter("acct", "appnexus", units); skipped != 2 || kept[0].Code != "top" {
+		t.Errorf("A bid should clear the ad unit's no-bid. Got %v", kept)
+	}
+}
+
+func TestPartialBidsAreNotDeclines(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5})
+	cache.Record("acct", "appnexus", units, pbs.PBSBidSlice{{AdUnitCode: "side"}})
+	if kept, skipped := cache.Filter("acct", "appnexus", units); len(kept) != 3 || skipped != 0 {
+		t.Errorf("The ad units without bids may have failed, so they shouldn't be cached. Got %v", kept)
+	}
+}
+
+func TestNoAccount(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5})
+	cache.Record("", "appnexus", units, nil)
+	if kept, skipped := cache.Filter("", "appnexus", units); len(kept) != 3 || skipped != 0 {
+		t.Errorf("Requests without an account shouldn't be cached. Got %v", kept)
+	}
+}
+
+func TestNoBidsExpire(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5})
+	cache.Record("acct", "appnexus", units, nil)
+	for k := range cache.expiries {
+		cache.expiries[k] = time.Now().Add(-time.Second)
+	}
+	if kept, _ := cache.Filter("acct", "appnexus", units); len(kept) != 3 {
+		t.Errorf("Expired no-bids shouldn't skip anything. Got %v", kept)
+	}
+	cache.lastSweep = time.Time{}
+	cache.Record("acct", "rubicon", nil, nil)
+	if len(cache.expiries) != 0 {
+		t.Errorf("Expired entries should be swept. %d are left", len(cache.expiries))
+	}
+}
+
+func TestDisabledBidders(t *testing.T) {
+	cache := New(config.NoBidCache{TTLSeconds: 5, DisabledBidders: []string{"AppNexus"}})
+	cache.Record("acct", "appnexus", units, nil)
+	if kept, _ := cache.Filter("acct", "appnexus", units); len(kept) != 3 {
+		t.Errorf("No-bids shouldn't be cached for disabled bidders. Got %v", kept)
+	}
+}
@@ -0,0 +1,51 @@
+package pbs
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PriceEncrypter encrypts the ${AUCTION_PRICE} which gets sent back to a bidder in its notification URLs.
+type PriceEncrypter interface {
+	EncryptPrice(price float64) (string, error)
+}
+
+// ResolveBidMacros substitutes the standard OpenRTB auction macros in the bid's Adm, NURL and BURL.
+//
+// ${AUCTION_PRICE} resolves to the price in the bidder's own currency, since that's how the bidder
+// will read it. ${AUCTION_ID} resolves to the auctionID, and ${AUCTION_BID_ID} to the bidid from
+// the bidder's response. Values are query-escaped inside the notification URLs.
+//
+// If the encrypter isn't nil, the notification URLs get the encrypted price instead. Should the
+// encryption fail, the URLs are left untouched rather than leaking the clear-text price.
+func ResolveBidMacros(bid *PBSBid, auctionID string, encrypter PriceEncrypter) error {
+	price := bid.Price
+	if bid.Ext != nil && bid.Ext.OrigBidCur != "" {
+		price = bid.Ext.OrigBidCPM
+	}
+	values := []string{
+		"${AUCTION_PRICE}", strconv.FormatFloat(price, 'f', -1, 64),
+		"${AUCTION_ID}", auctionID,
+		"${AUCTION_BID_ID}", bid.BidResponseID,
+	}
+
+	bid.Adm = strings.NewReplacer(values...).Replace(bid.Adm)
+
+	if encrypter != nil {
+		encrypted, err := encrypter.EncryptPrice(price)
+		if err != nil {
+			return err
+		}
+		values[1] = encrypted
+	}
+	escaped := make([]string, len(values))
+	for i := 0; i < len(values); i += 2 {
+		escaped[i] = values[i]
+		escaped[i+1] = url.QueryEscape(values[i+1])
+	}
+	urlReplacer := strings.NewReplacer(escaped...)
+	bid.NURL = urlReplacer.Replace(bid.NURL)
+	bid.BURL = urlReplacer.Replace(bid.BURL)
+	return nil
+}
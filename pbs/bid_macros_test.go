@@ -0,0 +1,81 @@
+package pbs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveBidMacros(t *testing.T) {
+	bid := &PBSBid{
+		Price:         1.25,
+		Adm:           `<img src="https://dsp.test.com/imp?p=${AUCTION_PRICE}&a=${AUCTION_ID}&b=${AUCTION_BID_ID}">`,
+		NURL:          "https://dsp.test.com/win?p=${AUCTION_PRICE}&b=${AUCTION_BID_ID}",
+		BURL:          "https://dsp.test.com/bill?p=${AUCTION_PRICE}&a=${AUCTION_ID}",
+		BidResponseID: "resp 1",
+	}
+	ResolveBidMacros(bid, "auction-1", nil)
+
+	if bid.Adm != `<img src="https://dsp.test.com/imp?p=1.25&a=auction-1&b=resp 1">` {
+		t.Errorf("Bad adm: %s", bid.Adm)
+	}
+	if bid.NURL != "https://dsp.test.com/win?p=1.25&b=resp+1" {
+		t.Errorf("Bad nurl: %s", bid.NURL)
+	}
+	if bid.BURL != "https://dsp.test.com/bill?p=1.25&a=auction-1" {
+		t.Errorf("Bad burl: %s", bid.BURL)
+	}
+}
+
+func TestResolveBidMacrosOriginalCurrency(t *testing.T) {
+	bid := &PBSBid{
+		Price: 1.1,
+		NURL:  "https://dsp.test.com/win?p=${AUCTION_PRICE}",
+		Ext: &PBSBidExt{
+			OrigBidCPM: 1,
+			OrigBidCur: "EUR",
+		},
+	}
+	ResolveBidMacros(bid, "", nil)
+
+	if bid.NURL != "https://dsp.test.com/win?p=1" {
+		t.Errorf("The price should be in the bidder's currency. Got %s", bid.NURL)
+	}
+}
+
+type fakeEncrypter struct {
+	err error
+}
+
+func (e *fakeEncrypter) EncryptPrice(price float64) (string, error) {
+	return "encrypted", e.err
+}
+
+func TestResolveBidMacrosEncryptedPrice(t *testing.T) {
+	bid := &PBSBid{
+		Price: 2,
+		Adm:   "<div>${AUCTION_PRICE}</div>",
+		NURL:  "https://dsp.test.com/win?p=${AUCTION_PRICE}",
+	}
+	if err := ResolveBidMacros(bid, "", &fakeEncrypter{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bid.NURL != "https://dsp.test.com/win?p=encrypted" {
+		t.Errorf("The nurl should get the encrypted price. Got %s", bid.NURL)
+	}
+	if bid.Adm != "<div>2</div>" {
+		t.Errorf("The adm should get the clear-text price. Got %s", bid.Adm)
+	}
+}
+
+func TestResolveBidMacrosEncryptionFailure(t *testing.T) {
+	bid := &PBSBid{
+		Price: 2,
+		NURL:  "https://dsp.test.com/win?p=${AUCTION_PRICE}",
+	}
+	if err := ResolveBidMacros(bid, "", &fakeEncrypter{err: errors.New("no entropy")}); err == nil {
+		t.Errorf("The encryption error should be returned")
+	}
+	if bid.NURL != "https://dsp.test.com/win?p=${AUCTION_PRICE}" {
+		t.Errorf("The clear-text price should never reach the nurl. Got %s", bid.NURL)
+	}
+}
@@ -0,0 +1,81 @@
+package pbs
+
+import (
+	"encoding/json"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// readBuyerUIDs removes user.ext.prebid.buyeruids from the user, and returns them. These are the user's IDs
+// with each bidder, keyed by bidder code or family name. Apps send them because they have no uids cookie.
+// They're removed so that one bidder's ID is never sent to the others.
+func readBuyerUIDs(user *openrtb.User) (map[string]string, error) {
+	if len(user.Ext) == 0 {
+		return nil, nil
+	}
+	var ext map[string]json.RawMessage
+	if err := json.Unmarshal(user.Ext, &ext); err != nil {
+		return nil, err
+	}
+	rawPrebid, ok := ext["prebid"]
+	if !ok {
+		return nil, nil
+	}
+	var prebid map[string]json.RawMessage
+	if err := json.Unmarshal(rawPrebid, &prebid); err != nil {
+		return nil, err
+	}
+	rawUIDs, ok := prebid["buyeruids"]
+	if !ok {
+		return nil, nil
+	}
+	var uids map[string]string
+	if err := json.Unmarshal(rawUIDs, &uids); err != nil {
+		return nil, err
+	}
+
+	delete(prebid, "buyeruids")
+	if len(prebid) == 0 {
+		delete(ext, "prebid")
+	} else {
+		rawPrebid, err := json.Marshal(prebid)
+		if err != nil {
+			return nil, err
+		}
+		ext["prebid"] = rawPrebid
+	}
+	user.Ext = nil
+	if len(ext) > 0 {
+		rawExt, err := json.Marshal(ext)
+		if err != nil {
+			return nil, err
+		}
+		user.Ext = rawExt
+	}
+	return uids, nil
+}
+
+// BuyerUID returns the user's ID with a bidder. IDs from the request body take precedence over the
+// uids cookie, and are looked up by bidder code before family name. Users who opted out have no IDs.
+func (req *PBSRequest) BuyerUID(bidderCode string, familyName string) string {
+	if req.Cookie != nil && !req.Cookie.AllowSyncs() {
+		return ""
+	}
+	if uid := req.BuyerUIDs[bidderCode]; uid != "" {
+		return uid
+	}
+	if uid := req.BuyerUIDs[familyName]; uid != "" {
+		return uid
+	}
+	uid, _, _ := req.Cookie.GetUID(familyName)
+	return uid
+}
+
+// BidderBuyerUID returns the user's ID with the bidder, unless the bidder's GDPR restrictions cover user IDs.
+// Adapters which send the ID outside of the OpenRTB request, like in a cookie, must use this instead of BuyerUID.
+func (req *PBSRequest) BidderBuyerUID(bidder *PBSBidder, familyName string) string {
+	if bidder.GDPR.UserIDs {
+		return ""
+	}
+	return req.BuyerUID(bidder.BidderCode, familyName)
+}
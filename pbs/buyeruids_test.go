@@ -0,0 +1,81 @@
+package pbs
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func TestReadBuyerUIDs(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"prebid":{"buyeruids":{"appnexus":"123","rubicon":"abc"}},"eids":[]}`)}
+	uids, err := readBuyerUIDs(user)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(uids) != 2 || uids["appnexus"] != "123" || uids["rubicon"] != "abc" {
+		t.Errorf("Bad buyeruids: %v", uids)
+	}
+	if string(user.Ext) != `{"eids":[]}` {
+		t.Errorf("buyeruids should be removed from user.ext. Got %s", user.Ext)
+	}
+}
+
+func TestReadBuyerUIDsKeepsOtherPrebidFields(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"prebid":{"buyeruids":{"appnexus":"123"},"other":1}}`)}
+	if _, err := readBuyerUIDs(user); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(user.Ext) != `{"prebid":{"other":1}}` {
+		t.Errorf("Other user.ext.prebid fields should be kept. Got %s", user.Ext)
+	}
+}
+
+func TestReadBuyerUIDsInvalid(t *testing.T) {
+	user := &openrtb.User{Ext: openrtb.RawJSON(`{"prebid":{"buyeruids":["123"]}}`)}
+	if _, err := readBuyerUIDs(user); err == nil {
+		t.Error("Malformed buyeruids should be an error")
+	}
+}
+
+func TestBuyerUID(t *testing.T) {
+	cookie := NewPBSCookie()
+	cookie.TrySync("adnxs", "cookie-id")
+	cookie.TrySync("rubicon", "rubicon-cookie-id")
+	req := &PBSRequest{
+		Cookie:    cookie,
+		BuyerUIDs: map[string]string{"appnexus": "body-id"},
+	}
+	if uid := req.BuyerUID("appnexus", "adnxs"); uid != "body-id" {
+		t.Errorf("IDs from the request body should take precedence. Got %s", uid)
+	}
+	if uid := req.BuyerUID("rubicon", "rubicon"); uid != "rubicon-cookie-id" {
+		t.Errorf("The cookie should be used when the body has no ID. Got %s", uid)
+	}
+
+	cookie.SetPreference(false)
+	if uid := req.BuyerUID("appnexus", "adnxs"); uid != "" {
+		t.Errorf("Users who opted out shouldn't have IDs. Got %s", uid)
+	}
+}
+
+func TestBuyerUIDWithoutCookie(t *testing.T) {
+	req := &PBSRequest{BuyerUIDs: map[string]string{"adnxs": "family-id"}}
+	if uid := req.BuyerUID("appnexus", "adnxs"); uid != "family-id" {
+		t.Errorf("IDs should be found by family name. Got %s", uid)
+	}
+	if uid := req.BuyerUID("rubicon", "rubicon"); uid != "" {
+		t.Errorf("Unexpected ID %s", uid)
+	}
+}
+
+func TestBidderBuyerUID(t *testing.T) {
+	req := &PBSRequest{BuyerUIDs: map[string]string{"pubmatic": "body-id"}}
+	bidder := &PBSBidder{BidderCode: "pubmatic"}
+	if uid := req.BidderBuyerUID(bidder, "pubmatic"); uid != "body-id" {
+		t.Errorf("Unrestricted bidders should get their ID. Got %s", uid)
+	}
+	bidder.GDPR.UserIDs = true
+	if uid := req.BidderBuyerUID(bidder, "pubmatic"); uid != "" {
+		t.Errorf("Bidders whose user IDs are restricted shouldn't get an ID. Got %s", uid)
+	}
+}
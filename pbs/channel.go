@@ -0,0 +1,49 @@
+package pbs
+
+// These are the integrations which requests come from.
+const (
+	ChannelPrebidJS = "pbjs"
+	ChannelAMP      = "amp"
+	ChannelApp      = "app"
+	ChannelVideo    = "video"
+)
+
+// Channel names the integration which sent the request, so that bidders, account rules and reports can
+// treat them differently. It's always worked out from the request, since account rules rely on it, and
+// clients could otherwise pick whichever channel their account allows.
+type Channel struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// setChannel fills in ext.prebid.channel, replacing anything the client sent. App requests come from the
+// mobile SDK, and everything else from Prebid.js.
+func (req *PBSRequest) setChannel() {
+	if req.Ext == nil {
+		req.Ext = &PBSRequestExt{}
+	}
+	channel := &Channel{Name: ChannelPrebidJS}
+	if req.App != nil {
+		channel.Name = ChannelApp
+		if req.SDK != nil {
+			channel.Version = req.SDK.Version
+		}
+	}
+	req.Ext.Prebid.Channel = channel
+}
+
+// Channel returns the integration which sent the request, or nil if it hasn't been filled in.
+func (req *PBSRequest) Channel() *Channel {
+	if req.Ext == nil {
+		return nil
+	}
+	return req.Ext.Prebid.Channel
+}
+
+// ChannelName returns the name of the request's channel, or "" if it hasn't been filled in.
+func (req *PBSRequest) ChannelName() string {
+	if channel := req.Channel(); channel != nil {
+		return channel.Name
+	}
+	return ""
+}
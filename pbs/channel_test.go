@@ -0,0 +1,34 @@
+package pbs
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func TestSetChannel(t *testing.T) {
+	web := &PBSRequest{}
+	web.setChannel()
+	if channel := web.Channel(); channel == nil || channel.Name != ChannelPrebidJS {
+		t.Errorf("Web requests should come from Prebid.js. Got %#v", channel)
+	}
+
+	app := &PBSRequest{App: &openrtb.App{}, SDK: &SDK{Version: "1.2.0"}}
+	app.setChannel()
+	if channel := app.Channel(); channel == nil || channel.Name != ChannelApp || channel.Version != "1.2.0" {
+		t.Errorf("App requests should come from the SDK. Got %#v", channel)
+	}
+
+	sent := &PBSRequest{Ext: &PBSRequestExt{Prebid: PBSRequestExtPrebid{Channel: &Channel{Name: "app"}}}}
+	sent.setChannel()
+	if name := sent.ChannelName(); name != ChannelPrebidJS {
+		t.Errorf("The client's channel should be replaced by the request's. Got %s", name)
+	}
+}
+
+func TestChannelNameWithoutExt(t *testing.T) {
+	req := &PBSRequest{}
+	if name := req.ChannelName(); name != "" {
+		t.Errorf("Unexpected channel %s", name)
+	}
+}
@@ -0,0 +1,96 @@
+package pbs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// uidsCookieVersion is the version of the uids cookie format which this server writes.
+//
+// Version 0 cookies have no "v" field. They may hold legacy UIDs without expiration dates, which get
+// migrated when they're decoded. Bump this whenever the format changes, and teach the decoder to
+// read the old one, so that existing users don't lose their syncs.
+const uidsCookieVersion = 1
+
+// CookieEncoder turns a PBSCookie into the value which gets stored in the browser.
+type CookieEncoder interface {
+	Encode(cookie *PBSCookie) (string, error)
+}
+
+// CookieDecoder parses the value of a uids cookie. Values which can't be decoded
+// produce an empty cookie, since the user will just re-sync.
+type CookieDecoder interface {
+	Decode(value string) *PBSCookie
+}
+
+// Base64Encoder writes the cookie as base64-encoded JSON.
+type Base64Encoder struct{}
+
+func (Base64Encoder) Encode(cookie *PBSCookie) (string, error) {
+	j, err := json.Marshal(cookie)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(j), nil
+}
+
+// JavaCompatibleEncoder writes the cookie exactly as Prebid Server Java does, so that Go and Java servers can
+// share a domain without losing user matches. Java has no "v" field, and writes times in UTC to the millisecond.
+//
+// Base64Decoder reads these cookies too. Without a "v", they're treated as version 0.
+type JavaCompatibleEncoder struct{}
+
+// javaTimeFormat matches the ISO-8601 times which Java writes.
+const javaTimeFormat = "2006-01-02T15:04:05.000Z"
+
+type javaUID struct {
+	UID     string `json:"uid"`
+	Expires string `json:"expires"`
+}
+
+type javaCookieJson struct {
+	UIDs     map[string]javaUID `json:"tempUIDs,omitempty"`
+	OptOut   bool               `json:"optout,omitempty"`
+	Birthday string             `json:"bday,omitempty"`
+}
+
+func (JavaCompatibleEncoder) Encode(cookie *PBSCookie) (string, error) {
+	contract := javaCookieJson{
+		OptOut: cookie.optOut,
+	}
+	if len(cookie.uids) > 0 {
+		contract.UIDs = make(map[string]javaUID, len(cookie.uids))
+		for family, uid := range cookie.uids {
+			contract.UIDs[family] = javaUID{
+				UID:     uid.UID,
+				Expires: uid.Expires.UTC().Format(javaTimeFormat),
+			}
+		}
+	}
+	if cookie.birthday != nil {
+		contract.Birthday = cookie.birthday.UTC().Format(javaTimeFormat)
+	}
+	j, err := json.Marshal(&contract)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(j), nil
+}
+
+// Base64Decoder reads cookies which were written by a Base64Encoder, in any version of the format.
+type Base64Decoder struct{}
+
+func (Base64Decoder) Decode(value string) *PBSCookie {
+	pc := NewPBSCookie()
+
+	j, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		// corrupted cookie; we should reset
+		return pc
+	}
+
+	// The error on Unmarshal here isn't terribly important.
+	// If the cookie has been corrupted, we should reset to an empty one anyway.
+	json.Unmarshal(j, pc)
+	return pc
+}
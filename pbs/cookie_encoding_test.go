@@ -0,0 +1,93 @@
+package pbs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncodeWritesVersion(t *testing.T) {
+	cookie := NewPBSCookie()
+	cookie.TrySync("adnxs", "123")
+
+	value, err := Base64Encoder{}.Encode(cookie)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	j, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("Encoded cookies should be base64: %v", err)
+	}
+	var contract pbsCookieJson
+	if err := json.Unmarshal(j, &contract); err != nil {
+		t.Fatalf("Encoded cookies should be JSON: %v", err)
+	}
+	if contract.Version != uidsCookieVersion {
+		t.Errorf("Expected version %d. Got %d", uidsCookieVersion, contract.Version)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	cookie := NewPBSCookie()
+	cookie.TrySync("adnxs", "123")
+	cookie.TrySync("rubicon", "456")
+
+	value, _ := Base64Encoder{}.Encode(cookie)
+	decoded := Base64Decoder{}.Decode(value)
+	if decoded.LiveSyncCount() != 2 {
+		t.Errorf("Expected 2 live syncs. Got %d", decoded.LiveSyncCount())
+	}
+	if uid, _, _ := decoded.GetUID("rubicon"); uid != "456" {
+		t.Errorf("Expected rubicon UID 456. Got %s", uid)
+	}
+}
+
+func TestDecodeMigratesVersion0(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	v0 := `{"uids":{"adnxs":"123"},"tempUIDs":{"audienceNetwork":{"uid":"0","expires":"` + expires + `"},"rubicon":{"uid":"456","expires":"` + expires + `"}}}`
+	decoded := Base64Decoder{}.Decode(base64.URLEncoding.EncodeToString([]byte(v0)))
+
+	if uid, _, live := decoded.GetUID("adnxs"); uid != "123" || live {
+		t.Errorf("Legacy UIDs should be kept, but expired. Got %s, live=%t", uid, live)
+	}
+	if decoded.HasLiveSync("audienceNetwork") {
+		t.Errorf("Version 0 audienceNetwork UIDs of 0 should be dropped")
+	}
+	if !decoded.HasLiveSync("rubicon") {
+		t.Errorf("Version 0 UIDs with expiration dates should be kept")
+	}
+}
+
+func TestJavaCompatibleEncoder(t *testing.T) {
+	cookie := NewPBSCookie()
+	expires := time.Date(2019, 4, 5, 19, 39, 50, 123456789, time.FixedZone("EST", -5*60*60))
+	cookie.uids["adnxs"] = uidWithExpiry{UID: "123", Expires: expires}
+	cookie.birthday = nil
+
+	value, err := JavaCompatibleEncoder{}.Encode(cookie)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	j, _ := base64.URLEncoding.DecodeString(value)
+	if string(j) != `{"tempUIDs":{"adnxs":{"uid":"123","expires":"2019-04-06T00:39:50.123Z"}}}` {
+		t.Errorf("Bad Java compatible cookie: %s", j)
+	}
+}
+
+func TestJavaCompatibleEncoderOptOut(t *testing.T) {
+	cookie := NewPBSCookie()
+	cookie.SetPreference(false)
+	value, _ := JavaCompatibleEncoder{}.Encode(cookie)
+	if decoded := (Base64Decoder{}).Decode(value); decoded.AllowSyncs() {
+		t.Errorf("Opted out Java compatible cookies should stay opted out")
+	}
+}
+
+func TestDecodeJavaCookie(t *testing.T) {
+	java := `{"tempUIDs":{"rubicon":{"uid":"456","expires":"2099-01-01T00:00:00.000Z"}},"bday":"2019-01-01T00:00:00.000Z"}`
+	decoded := Base64Decoder{}.Decode(base64.URLEncoding.EncodeToString([]byte(java)))
+	if !decoded.HasLiveSync("rubicon") {
+		t.Errorf("Cookies written by Prebid Server Java should be readable")
+	}
+}
@@ -141,3 +141,31 @@ func GetPriceBucketString(cpm float64) map[string]string {
 		"dense": getCpmStringValue(cpm, getDensePriceConfig()),
 	}
 }
+
+// PriceRange is one bucket of a custom price granularity. Prices from Min to Max are rounded down to a multiple of Increment.
+type PriceRange struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Increment float64 `json:"increment"`
+}
+
+// PriceGranularity is a custom set of price buckets. Prices above the highest Max are capped there.
+type PriceGranularity struct {
+	// Precision is the number of decimal places in the bucket strings. Zero means the default of 2.
+	Precision int          `json:"precision,omitempty"`
+	Ranges    []PriceRange `json:"ranges"`
+}
+
+// GetCustomPriceBucket returns the price bucket string for the cpm, using custom ranges.
+func GetCustomPriceBucket(cpm float64, granularity PriceGranularity) string {
+	buckets := make([]map[string]float64, len(granularity.Ranges))
+	for i, priceRange := range granularity.Ranges {
+		buckets[i] = map[string]float64{
+			"min":       priceRange.Min,
+			"max":       priceRange.Max,
+			"increment": priceRange.Increment,
+			"precision": float64(granularity.Precision),
+		}
+	}
+	return getCpmStringValue(cpm, map[string][]map[string]float64{"buckets": buckets})
+}
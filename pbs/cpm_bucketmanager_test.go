@@ -44,3 +44,28 @@ func TestGetPriceBucketString(t *testing.T) {
 		t.Error("Expected 5.70")
 	}
 }
+
+func TestGetCustomPriceBucket(t *testing.T) {
+	video := PriceGranularity{
+		Ranges: []PriceRange{
+			{Min: 0, Max: 10, Increment: 1},
+			{Min: 10, Max: 50, Increment: 5},
+		},
+	}
+	tests := map[float64]string{
+		3.7:  "3.00",
+		12.5: "10.00",
+		49.9: "45.00",
+		80:   "50.00",
+	}
+	for cpm, expected := range tests {
+		if bucket := GetCustomPriceBucket(cpm, video); bucket != expected {
+			t.Errorf("Expected %s for %f. Got %s", expected, cpm, bucket)
+		}
+	}
+
+	video.Precision = 1
+	if bucket := GetCustomPriceBucket(3.7, video); bucket != "3.0" {
+		t.Errorf("Expected 1 decimal place. Got %s", bucket)
+	}
+}
@@ -0,0 +1,53 @@
+package pbs
+
+import "encoding/json"
+
+// DChainVersion is the version of the IAB demand chain spec which these objects implement.
+const DChainVersion = "1.0"
+
+// DemandChain describes every entity which a bid passed through on its way to the publisher.
+// For more information, see https://github.com/InteractiveAdvertisingBureau/openrtb/blob/master/supplychainobject.md
+type DemandChain struct {
+	// Complete is 1 if the chain contains every node back to the owner of the demand, and 0 otherwise.
+	Complete int `json:"complete"`
+	// Ver is the version of the demand chain spec in use.
+	Ver string `json:"ver"`
+	// Nodes are ordered from the original buyer to the entity which sent the bid response.
+	Nodes []DemandChainNode `json:"nodes"`
+	Ext   json.RawMessage   `json:"ext,omitempty"`
+}
+
+// DemandChainNode is a single entity in the DemandChain.
+type DemandChainNode struct {
+	ASI    string          `json:"asi,omitempty"`
+	BSID   string          `json:"bsid,omitempty"`
+	RID    string          `json:"rid,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Domain string          `json:"domain,omitempty"`
+	Ext    json.RawMessage `json:"ext,omitempty"`
+}
+
+// BuildDemandChain extends the chain which a bidder sent with the bidder's own node, followed by the host's node.
+// Every node needs an asi, so the bidder's node is left out if it doesn't have one.
+//
+// If the bidder didn't send a chain, then we don't know anything about the demand behind it,
+// so the resulting chain will be marked incomplete.
+func BuildDemandChain(bidderChain json.RawMessage, bidder DemandChainNode, host DemandChainNode) (json.RawMessage, error) {
+	chain := DemandChain{
+		Complete: 0,
+		Ver:      DChainVersion,
+	}
+	if len(bidderChain) > 0 {
+		if err := json.Unmarshal(bidderChain, &chain); err != nil {
+			return nil, err
+		}
+		if chain.Ver == "" {
+			chain.Ver = DChainVersion
+		}
+	}
+	if bidder.ASI != "" {
+		chain.Nodes = append(chain.Nodes, bidder)
+	}
+	chain.Nodes = append(chain.Nodes, host)
+	return json.Marshal(&chain)
+}
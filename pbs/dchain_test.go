@@ -0,0 +1,83 @@
+package pbs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testHostNode = DemandChainNode{
+	ASI:  "prebid-server.example.com",
+	Name: "Example Host",
+}
+
+func TestBuildDemandChainWithoutBidderChain(t *testing.T) {
+	raw, err := BuildDemandChain(nil, DemandChainNode{ASI: "appnexus.com", Name: "appnexus"}, testHostNode)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var chain DemandChain
+	if err := json.Unmarshal(raw, &chain); err != nil {
+		t.Fatalf("Failed to unmarshal the chain: %v", err)
+	}
+	if chain.Complete != 0 {
+		t.Errorf("Chains without bidder-provided nodes should be incomplete.")
+	}
+	if chain.Ver != DChainVersion {
+		t.Errorf("Expected version %s. Got %s", DChainVersion, chain.Ver)
+	}
+	if len(chain.Nodes) != 2 {
+		t.Fatalf("Expected 2 nodes. Got %d", len(chain.Nodes))
+	}
+	if chain.Nodes[0].ASI != "appnexus.com" || chain.Nodes[0].Name != "appnexus" {
+		t.Errorf("The first node should be the bidder. Got %s", chain.Nodes[0].Name)
+	}
+	if chain.Nodes[1].ASI != testHostNode.ASI {
+		t.Errorf("The last node should be the host. Got %s", chain.Nodes[1].ASI)
+	}
+}
+
+func TestBuildDemandChainWithBidderChain(t *testing.T) {
+	bidderChain := json.RawMessage(`{"complete":1,"nodes":[{"asi":"dsp.com","bsid":"123"}]}`)
+	raw, err := BuildDemandChain(bidderChain, DemandChainNode{ASI: "rubiconproject.com", Name: "rubicon"}, testHostNode)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var chain DemandChain
+	if err := json.Unmarshal(raw, &chain); err != nil {
+		t.Fatalf("Failed to unmarshal the chain: %v", err)
+	}
+	if chain.Complete != 1 {
+		t.Errorf("The bidder's complete flag should be kept.")
+	}
+	if len(chain.Nodes) != 3 {
+		t.Fatalf("Expected 3 nodes. Got %d", len(chain.Nodes))
+	}
+	if chain.Nodes[0].ASI != "dsp.com" || chain.Nodes[0].BSID != "123" {
+		t.Errorf("The bidder's nodes should come first. Got %#v", chain.Nodes[0])
+	}
+	if chain.Nodes[1].Name != "rubicon" {
+		t.Errorf("The bidder node should follow the bidder's chain. Got %#v", chain.Nodes[1])
+	}
+}
+
+func TestBuildDemandChainInvalidBidderChain(t *testing.T) {
+	if _, err := BuildDemandChain(json.RawMessage(`{"nodes":`), DemandChainNode{ASI: "rubiconproject.com"}, testHostNode); err == nil {
+		t.Errorf("Malformed bidder chains should produce an error.")
+	}
+}
+
+func TestBuildDemandChainWithoutBidderASI(t *testing.T) {
+	raw, err := BuildDemandChain(nil, DemandChainNode{Name: "debug"}, testHostNode)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var chain DemandChain
+	if err := json.Unmarshal(raw, &chain); err != nil {
+		t.Fatalf("Failed to unmarshal the chain: %v", err)
+	}
+	if len(chain.Nodes) != 1 || chain.Nodes[0].ASI != testHostNode.ASI {
+		t.Errorf("Bidders without an asi should be left out of the chain. Got %#v", chain.Nodes)
+	}
+}
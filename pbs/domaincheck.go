@@ -0,0 +1,79 @@
+package pbs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// These are the reasons why CheckDomain can decide that a request's inventory is spoofed.
+const (
+	// DomainOriginMismatch means the browser sent the request from a site which isn't one of the account's domains.
+	DomainOriginMismatch = "origin_mismatch"
+	// DomainUnregistered means the page isn't on any of the account's domains.
+	DomainUnregistered = "unregistered_domain"
+)
+
+// ValidateDomainCheckMode returns an error unless the mode is "flag", "reject" or empty.
+func ValidateDomainCheckMode(mode string) error {
+	switch mode {
+	case "", "flag", "reject":
+		return nil
+	}
+	return fmt.Errorf("domain check mode must be flag or reject. Got %s", mode)
+}
+
+// NormalizeDomain lowercases a host or URL, and reduces it to its registrable domain (eTLD+1),
+// so that www.example.com and news.example.com both become example.com. It returns "" if the
+// domain can't be parsed.
+func NormalizeDomain(hostOrURL string) string {
+	host := strings.ToLower(strings.TrimSpace(hostOrURL))
+	if strings.Contains(host, "://") {
+		parsed, err := url.Parse(host)
+		if err != nil {
+			return ""
+		}
+		host = parsed.Host
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+// CheckDomain looks for signs that a web request's page URL is spoofed, and returns the reason, or "" if
+// there are none. The account's registered domains are the only ones it trusts, so it can't check accounts
+// without any. The page must be on one of them, and so must the origin. That's the request's Origin header,
+// which browsers set on the cross-origin requests which Prebid.js makes, so it's harder to spoof than the page.
+func CheckDomain(pageDomain string, origin string, registered []string) string {
+	if len(registered) == 0 {
+		return ""
+	}
+	if origin != "" && origin != "null" && !isRegistered(NormalizeDomain(origin), registered) {
+		return DomainOriginMismatch
+	}
+	if !isRegistered(NormalizeDomain(pageDomain), registered) {
+		return DomainUnregistered
+	}
+	return ""
+}
+
+// isRegistered returns true if the normalized domain is one of the registered domains.
+func isRegistered(domain string, registered []string) bool {
+	if domain == "" {
+		return false
+	}
+	for _, registeredDomain := range registered {
+		if NormalizeDomain(registeredDomain) == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package pbs
+
+import "testing"
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"WWW.Example.com":                  "example.com",
+		"https://news.example.co.uk:8443/": "example.co.uk",
+		"example.com.":                     "example.com",
+		"localhost":                        "",
+	}
+	for input, expected := range tests {
+		if domain := NormalizeDomain(input); domain != expected {
+			t.Errorf("%s: expected %q. Got %q", input, expected, domain)
+		}
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	tests := []struct {
+		description string
+		page        string
+		origin      string
+		registered  []string
+		expected    string
+	}{
+		{"No registered domains", "example.com", "https://spoofer.net", nil, ""},
+		{"Registered domain", "example.com", "https://example.com", []string{"other.com", "www.Example.com"}, ""},
+		{"Origin on a subdomain", "example.com", "https://www.example.com", []string{"example.com"}, ""},
+		{"Sandboxed iframe origin", "example.com", "null", []string{"example.com"}, ""},
+		{"No origin", "example.com", "", []string{"example.com"}, ""},
+		{"Origin on another site", "example.com", "https://spoofer.net", []string{"example.com"}, DomainOriginMismatch},
+		{"Page and origin on another registered domain", "example.com", "https://other.com", []string{"example.com", "other.com"}, ""},
+		{"Unregistered domain", "example.com", "", []string{"other.com"}, DomainUnregistered},
+		{"Unparseable page", "", "https://example.com", []string{"example.com"}, DomainUnregistered},
+	}
+	for _, test := range tests {
+		if reason := CheckDomain(test.page, test.origin, test.registered); reason != test.expected {
+			t.Errorf("%s: expected %q. Got %q", test.description, test.expected, reason)
+		}
+	}
+}
+
+func TestValidateDomainCheckMode(t *testing.T) {
+	for _, mode := range []string{"", "flag", "reject"} {
+		if err := ValidateDomainCheckMode(mode); err != nil {
+			t.Errorf("%s: unexpected error %v", mode, err)
+		}
+	}
+	if err := ValidateDomainCheckMode("block"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
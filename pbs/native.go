@@ -0,0 +1,273 @@
+package pbs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// NativeVersion is the version of the OpenRTB Native spec which Prebid Server speaks.
+// See https://www.iab.com/wp-content/uploads/2018/03/OpenRTB-Native-Ads-Specification-Final-1.2.pdf
+const NativeVersion = "1.2"
+
+// NativeRequest is the request in imp.native.request. Only the fields which are validated are here;
+// bidders get the original JSON.
+type NativeRequest struct {
+	Ver           string                      `json:"ver,omitempty"`
+	Context       int                         `json:"context,omitempty"`
+	PlcmtType     int                         `json:"plcmttype,omitempty"`
+	Assets        []NativeRequestAsset        `json:"assets"`
+	EventTrackers []NativeEventTrackerRequest `json:"eventtrackers,omitempty"`
+}
+
+// NativeRequestAsset is one of the parts of the ad. It has exactly one of Title, Img, Video or Data.
+type NativeRequestAsset struct {
+	ID       int                 `json:"id"`
+	Required int8                `json:"required,omitempty"`
+	Title    *NativeTitleRequest `json:"title,omitempty"`
+	Img      *NativeImageRequest `json:"img,omitempty"`
+	Video    *NativeVideoRequest `json:"video,omitempty"`
+	Data     *NativeDataRequest  `json:"data,omitempty"`
+}
+
+type NativeTitleRequest struct {
+	Len int `json:"len"`
+}
+
+type NativeImageRequest struct {
+	// Type is 1 for an icon, or 3 for the main image.
+	Type int `json:"type,omitempty"`
+	W    int `json:"w,omitempty"`
+	H    int `json:"h,omitempty"`
+	WMin int `json:"wmin,omitempty"`
+	HMin int `json:"hmin,omitempty"`
+}
+
+type NativeVideoRequest struct {
+	MIMEs       []string `json:"mimes"`
+	MinDuration int      `json:"minduration"`
+	MaxDuration int      `json:"maxduration"`
+	Protocols   []int    `json:"protocols"`
+}
+
+type NativeDataRequest struct {
+	// Type is one of the data asset types, like 2 for the description or 12 for the call to action.
+	Type int `json:"type"`
+	Len  int `json:"len,omitempty"`
+}
+
+type NativeEventTrackerRequest struct {
+	// Event is 1 for impressions, or 2, 3 and 4 for viewable impressions.
+	Event int `json:"event"`
+	// Methods are 1 for image pixels, and 2 for JavaScript.
+	Methods []int `json:"methods"`
+}
+
+// NativeResponse is the markup of a native bid, in its adm.
+type NativeResponse struct {
+	Ver           string                       `json:"ver,omitempty"`
+	Assets        []NativeResponseAsset        `json:"assets,omitempty"`
+	AssetsURL     string                       `json:"assetsurl,omitempty"`
+	DCOURL        string                       `json:"dcourl,omitempty"`
+	Link          *NativeLink                  `json:"link"`
+	ImpTrackers   []string                     `json:"imptrackers,omitempty"`
+	JSTracker     string                       `json:"jstracker,omitempty"`
+	EventTrackers []NativeEventTrackerResponse `json:"eventtrackers,omitempty"`
+}
+
+type NativeResponseAsset struct {
+	ID    *int `json:"id"`
+	Title *struct {
+		Text string `json:"text"`
+	} `json:"title,omitempty"`
+	Img *struct {
+		URL string `json:"url"`
+	} `json:"img,omitempty"`
+	Video *struct {
+		VASTTag string `json:"vasttag"`
+	} `json:"video,omitempty"`
+	Data *struct {
+		Value string `json:"value"`
+	} `json:"data,omitempty"`
+	Link *NativeLink `json:"link,omitempty"`
+}
+
+// NativeLink is where the ad (or one of its assets) goes when it's clicked.
+type NativeLink struct {
+	URL           string   `json:"url"`
+	ClickTrackers []string `json:"clicktrackers,omitempty"`
+	FallbackURL   string   `json:"fallback,omitempty"`
+}
+
+type NativeEventTrackerResponse struct {
+	Event  int    `json:"event"`
+	Method int    `json:"method"`
+	URL    string `json:"url,omitempty"`
+}
+
+// unwrapNative strips the {"native": ...} wrapper which versions 1.0 and 1.1 of the spec put around
+// requests and responses. Many bidders still send it.
+func unwrapNative(data []byte) []byte {
+	var wrapper struct {
+		Native json.RawMessage `json:"native"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Native) > 0 && wrapper.Native[0] == '{' {
+		return wrapper.Native
+	}
+	return data
+}
+
+// ParseNativeRequest parses the string in imp.native.request, and validates it.
+func ParseNativeRequest(request string) (*NativeRequest, error) {
+	var native NativeRequest
+	if err := json.Unmarshal(unwrapNative([]byte(request)), &native); err != nil {
+		return nil, err
+	}
+	if err := native.validate(); err != nil {
+		return nil, err
+	}
+	return &native, nil
+}
+
+// NativeRequest returns the ad unit's native request, as it should go in imp.native.request. Ad units may
+// give it as a JSON string, like OpenRTB does, or as an object.
+func (unit *PBSAdUnit) NativeRequest() (string, *NativeRequest, error) {
+	if len(unit.Native) == 0 {
+		return "", nil, errors.New("no native request")
+	}
+	request := string(unit.Native)
+	var encoded string
+	if err := json.Unmarshal(unit.Native, &encoded); err == nil {
+		request = encoded
+	}
+	native, err := ParseNativeRequest(request)
+	return request, native, err
+}
+
+// isExchangeSpecific returns true for the values, 500 and up, which the spec leaves for exchanges to define.
+func isExchangeSpecific(value int) bool {
+	return value >= 500
+}
+
+func (r *NativeRequest) validate() error {
+	if r.Context != 0 && (r.Context < 1 || r.Context > 3) && !isExchangeSpecific(r.Context) {
+		return fmt.Errorf("context %d is not supported", r.Context)
+	}
+	if r.PlcmtType != 0 && (r.PlcmtType < 1 || r.PlcmtType > 4) && !isExchangeSpecific(r.PlcmtType) {
+		return fmt.Errorf("plcmttype %d is not supported", r.PlcmtType)
+	}
+	if len(r.Assets) == 0 {
+		return errors.New("assets must contain at least one element")
+	}
+	ids := make(map[int]bool, len(r.Assets))
+	for i, asset := range r.Assets {
+		if ids[asset.ID] {
+			return fmt.Errorf("assets[%d].id %d is not unique", i, asset.ID)
+		}
+		ids[asset.ID] = true
+		if err := asset.validate(); err != nil {
+			return fmt.Errorf("assets[%d].%v", i, err)
+		}
+	}
+	for i, tracker := range r.EventTrackers {
+		if (tracker.Event < 1 || tracker.Event > 4) && !isExchangeSpecific(tracker.Event) {
+			return fmt.Errorf("eventtrackers[%d].event %d is not supported", i, tracker.Event)
+		}
+		if len(tracker.Methods) == 0 {
+			return fmt.Errorf("eventtrackers[%d].methods must contain at least one element", i)
+		}
+		for _, method := range tracker.Methods {
+			if (method < 1 || method > 2) && !isExchangeSpecific(method) {
+				return fmt.Errorf("eventtrackers[%d].methods contains unsupported method %d", i, method)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *NativeRequestAsset) validate() error {
+	kinds := 0
+	for _, present := range []bool{a.Title != nil, a.Img != nil, a.Video != nil, a.Data != nil} {
+		if present {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return errors.New("must have exactly one of title, img, video or data")
+	}
+	switch {
+	case a.Title != nil && a.Title.Len <= 0:
+		return errors.New("title.len must be positive")
+	case a.Img != nil && a.Img.Type != 0 && a.Img.Type != 1 && a.Img.Type != 3 && !isExchangeSpecific(a.Img.Type):
+		return fmt.Errorf("img.type %d is not supported", a.Img.Type)
+	case a.Video != nil && len(a.Video.MIMEs) == 0:
+		return errors.New("video.mimes must contain at least one element")
+	case a.Video != nil && len(a.Video.Protocols) == 0:
+		return errors.New("video.protocols must contain at least one element")
+	case a.Data != nil && (a.Data.Type < 1 || a.Data.Type > 12) && !isExchangeSpecific(a.Data.Type):
+		return fmt.Errorf("data.type %d is not supported", a.Data.Type)
+	}
+	return nil
+}
+
+// ValidateNativeResponse returns an error if the adm isn't native markup for the request. The ad needs a link,
+// and so does any asset with one. Every asset must be for one of the request's assets, and all of its required
+// assets must be there, unless the markup points to them with an assetsurl or dcourl.
+func ValidateNativeResponse(adm string, request *NativeRequest) error {
+	var native NativeResponse
+	if err := json.Unmarshal(unwrapNative([]byte(adm)), &native); err != nil {
+		return err
+	}
+	if native.Link == nil || native.Link.URL == "" {
+		return errors.New("link.url must be a non-empty string")
+	}
+
+	requested := make(map[int]*NativeRequestAsset, len(request.Assets))
+	for i := range request.Assets {
+		requested[request.Assets[i].ID] = &request.Assets[i]
+	}
+	found := make(map[int]bool, len(native.Assets))
+	for i, asset := range native.Assets {
+		if asset.ID == nil {
+			return fmt.Errorf("assets[%d].id is required", i)
+		}
+		if requested[*asset.ID] == nil {
+			return fmt.Errorf("assets[%d].id %d wasn't requested", i, *asset.ID)
+		}
+		found[*asset.ID] = true
+		kinds := 0
+		for _, present := range []bool{asset.Title != nil, asset.Img != nil, asset.Video != nil, asset.Data != nil} {
+			if present {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return fmt.Errorf("assets[%d] must have exactly one of title, img, video or data", i)
+		}
+		switch {
+		case asset.Title != nil && asset.Title.Text == "":
+			return fmt.Errorf("assets[%d].title.text must be a non-empty string", i)
+		case asset.Img != nil && asset.Img.URL == "":
+			return fmt.Errorf("assets[%d].img.url must be a non-empty string", i)
+		case asset.Video != nil && asset.Video.VASTTag == "":
+			return fmt.Errorf("assets[%d].video.vasttag must be a non-empty string", i)
+		case asset.Data != nil && asset.Data.Value == "":
+			return fmt.Errorf("assets[%d].data.value must be a non-empty string", i)
+		case asset.Link != nil && asset.Link.URL == "":
+			return fmt.Errorf("assets[%d].link.url must be a non-empty string", i)
+		}
+	}
+	if native.AssetsURL == "" && native.DCOURL == "" {
+		for _, asset := range request.Assets {
+			if asset.Required == 1 && !found[asset.ID] {
+				return fmt.Errorf("required asset %d is missing", asset.ID)
+			}
+		}
+	}
+	for i, tracker := range native.EventTrackers {
+		if tracker.URL == "" {
+			return fmt.Errorf("eventtrackers[%d].url must be a non-empty string", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package pbs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testNativeRequest = `{"ver":"1.2","context":1,"plcmttype":1,"assets":[
+	{"id":1,"required":1,"title":{"len":90}},
+	{"id":2,"required":1,"img":{"type":3,"wmin":300,"hmin":250}},
+	{"id":3,"data":{"type":2,"len":140}}
+],"eventtrackers":[{"event":1,"methods":[1,2]}]}`
+
+func TestParseNativeRequest(t *testing.T) {
+	native, err := ParseNativeRequest(testNativeRequest)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(native.Assets) != 3 || native.Assets[1].Img.Type != 3 {
+		t.Errorf("Bad native request: %#v", native)
+	}
+	if _, err := ParseNativeRequest(`{"native":` + testNativeRequest + `}`); err != nil {
+		t.Errorf("Requests in the 1.1 wrapper should be unwrapped. Got %v", err)
+	}
+
+	invalid := map[string]string{
+		"no assets":                 `{"assets":[]}`,
+		"duplicate asset ids":       `{"assets":[{"id":1,"title":{"len":90}},{"id":1,"data":{"type":2}}]}`,
+		"an asset with no type":     `{"assets":[{"id":1}]}`,
+		"an asset with 2 types":     `{"assets":[{"id":1,"title":{"len":90},"data":{"type":2}}]}`,
+		"an empty title":            `{"assets":[{"id":1,"title":{}}]}`,
+		"a bad img type":            `{"assets":[{"id":1,"img":{"type":2}}]}`,
+		"a video with no mimes":     `{"assets":[{"id":1,"video":{"protocols":[2]}}]}`,
+		"a bad data type":           `{"assets":[{"id":1,"data":{"type":13}}]}`,
+		"a bad context":             `{"context":9,"assets":[{"id":1,"title":{"len":90}}]}`,
+		"a tracker with no methods": `{"assets":[{"id":1,"title":{"len":90}}],"eventtrackers":[{"event":1}]}`,
+		"malformed JSON":            `{"assets":`,
+	}
+	for name, request := range invalid {
+		if _, err := ParseNativeRequest(request); err == nil {
+			t.Errorf("Expected an error for a request with %s", name)
+		}
+	}
+	if _, err := ParseNativeRequest(`{"assets":[{"id":1,"img":{"type":501}},{"id":2,"data":{"type":500}}]}`); err != nil {
+		t.Errorf("Exchange specific types should be allowed. Got %v", err)
+	}
+}
+
+func TestAdUnitNativeRequest(t *testing.T) {
+	encoded, _ := json.Marshal(testNativeRequest)
+	for _, raw := range []json.RawMessage{json.RawMessage(testNativeRequest), json.RawMessage(encoded)} {
+		unit := PBSAdUnit{Native: raw}
+		request, native, err := unit.NativeRequest()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if request != testNativeRequest || len(native.Assets) != 3 {
+			t.Errorf("Bad native request from %s: %s", raw, request)
+		}
+	}
+	if _, _, err := (&PBSAdUnit{}).NativeRequest(); err == nil {
+		t.Error("Ad units without a native request should be an error")
+	}
+}
+
+func TestValidateNativeResponse(t *testing.T) {
+	request, err := ParseNativeRequest(testNativeRequest)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	valid := []string{
+		`{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}},{"id":2,"img":{"url":"http://example.com/a.png"}}]}`,
+		`{"native":{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}},{"id":2,"img":{"url":"http://example.com/a.png"}},{"id":3,"data":{"value":"Cheap"}}]}}`,
+		`{"link":{"url":"http://example.com"},"assetsurl":"http://example.com/assets"}`,
+	}
+	for _, adm := range valid {
+		if err := ValidateNativeResponse(adm, request); err != nil {
+			t.Errorf("Unexpected error for %s: %v", adm, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"no link":                   `{"assets":[{"id":1,"title":{"text":"Buy"}},{"id":2,"img":{"url":"http://example.com/a.png"}}]}`,
+		"a missing required asset":  `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}}]}`,
+		"an unrequested asset":      `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}},{"id":2,"img":{"url":"u"}},{"id":7,"data":{"value":"v"}}]}`,
+		"an empty title":            `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":""}},{"id":2,"img":{"url":"u"}}]}`,
+		"an asset with no id":       `{"link":{"url":"http://example.com"},"assets":[{"title":{"text":"Buy"}}]}`,
+		"an asset with no type":     `{"link":{"url":"http://example.com"},"assets":[{"id":1},{"id":2,"img":{"url":"u"}}]}`,
+		"an asset with no link url": `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"},"link":{}},{"id":2,"img":{"url":"u"}}]}`,
+		"HTML":                      `<div>Not native</div>`,
+	}
+	for name, adm := range invalid {
+		if err := ValidateNativeResponse(adm, request); err == nil {
+			t.Errorf("Expected an error for markup with %s", name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pbs
+
+import "encoding/json"
+
+// legacyParamNames maps the old names of bidder params onto the names which the adapters use now,
+// keyed by bidder code. When an adapter renames a param, the old name belongs here, so that
+// publishers' existing configs keep working. Bidders which run another bidder's adapter, like
+// districtm, get its entry in init, and host aliases get theirs from AddParamAliases.
+var legacyParamNames = map[string]map[string]string{
+	"appnexus": {
+		"placement_id":        "placementId",
+		"inv_code":            "invCode",
+		"traffic_source_code": "trafficSourceCode",
+	},
+	"indexExchange": {
+		"site_id": "siteID",
+	},
+	"lifestreet": {
+		"slotTag": "slot_tag",
+	},
+	"pubmatic": {
+		"publisher_id": "publisherId",
+		"ad_slot":      "adSlot",
+	},
+	"rubicon": {
+		"account_id": "accountId",
+		"site_id":    "siteId",
+		"zone_id":    "zoneId",
+	},
+}
+
+func init() {
+	legacyParamNames["districtm"] = legacyParamNames["appnexus"]
+}
+
+// AddParamAliases lets the aliases, keyed by alias code, use the legacy param names of the bidders they alias.
+// It must be called before any requests are parsed.
+func AddParamAliases(aliases map[string]string) {
+	for alias, core := range aliases {
+		if renames, ok := legacyParamNames[core]; ok {
+			legacyParamNames[alias] = renames
+		}
+	}
+}
+
+// NormalizeParams renames any legacy params for the bidder to their current names. Request aliases must be
+// resolved to the bidder they alias first. If a request
+// has both names for the same param, the current one wins. Params which aren't a JSON object are
+// returned unchanged, so that the adapter can report them.
+func NormalizeParams(bidderCode string, params json.RawMessage) json.RawMessage {
+	renames, ok := legacyParamNames[bidderCode]
+	if !ok || len(params) == 0 {
+		return params
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return params
+	}
+	renamed := false
+	for legacy, current := range renames {
+		value, ok := fields[legacy]
+		if !ok {
+			continue
+		}
+		delete(fields, legacy)
+		if _, ok := fields[current]; !ok {
+			fields[current] = value
+		}
+		renamed = true
+	}
+	if !renamed {
+		return params
+	}
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return params
+	}
+	return normalized
+}
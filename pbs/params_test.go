@@ -0,0 +1,56 @@
+package pbs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeParams(t *testing.T) {
+	params := NormalizeParams("rubicon", json.RawMessage(`{"account_id":1001,"site_id":113932,"zoneId":535510,"visitor":{"ucat":["new"]}}`))
+	var parsed map[string]json.RawMessage
+	if err := json.Unmarshal(params, &parsed); err != nil {
+		t.Fatalf("Bad params: %v", err)
+	}
+	if string(parsed["accountId"]) != "1001" || string(parsed["siteId"]) != "113932" || string(parsed["zoneId"]) != "535510" {
+		t.Errorf("Legacy params should be renamed. Got %s", params)
+	}
+	if _, ok := parsed["account_id"]; ok {
+		t.Errorf("Legacy names should be removed. Got %s", params)
+	}
+	if string(parsed["visitor"]) != `{"ucat":["new"]}` {
+		t.Errorf("Other params should be kept. Got %s", params)
+	}
+}
+
+func TestNormalizeParamsPrefersCurrentNames(t *testing.T) {
+	params := NormalizeParams("pubmatic", json.RawMessage(`{"publisher_id":"old","publisherId":"new"}`))
+	if string(params) != `{"publisherId":"new"}` {
+		t.Errorf("The current name should win. Got %s", params)
+	}
+}
+
+func TestNormalizeParamsUnchanged(t *testing.T) {
+	for bidder, params := range map[string]string{
+		"rubicon":  `{ "accountId": 1001 }`,
+		"facebook": `{"placement_id":"abc"}`,
+		"appnexus": `not json`,
+	} {
+		if normalized := NormalizeParams(bidder, json.RawMessage(params)); string(normalized) != params {
+			t.Errorf("%s params %s shouldn't change. Got %s", bidder, params, normalized)
+		}
+	}
+}
+
+func TestNormalizeParamsAliases(t *testing.T) {
+	AddParamAliases(map[string]string{"appnexus2": "appnexus", "unknown2": "unknown"})
+	defer delete(legacyParamNames, "appnexus2")
+
+	for _, bidder := range []string{"districtm", "appnexus2"} {
+		if params := NormalizeParams(bidder, json.RawMessage(`{"placement_id":10433394}`)); string(params) != `{"placementId":10433394}` {
+			t.Errorf("%s should use the appnexus param names. Got %s", bidder, params)
+		}
+	}
+	if _, ok := legacyParamNames["unknown2"]; ok {
+		t.Error("Aliases of bidders without legacy params shouldn't get an entry")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/blang/semver"
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/cache"
+	"github.com/prebid/prebid-server/gdpr"
 	"github.com/prebid/prebid-server/prebid"
 )
 
@@ -26,6 +27,7 @@ type MediaType byte
 const (
 	MEDIA_TYPE_BANNER MediaType = iota
 	MEDIA_TYPE_VIDEO
+	MEDIA_TYPE_NATIVE
 )
 
 type ConfigCache interface {
@@ -87,6 +89,89 @@ type AdUnit struct {
 	MediaTypes []string         `json:"media_types"`
 	Instl      int8             `json:"instl"`
 	Video      PBSVideo         `json:"video"`
+	// Native is the OpenRTB Native 1.2 request, for ad units with the "native" media type.
+	Native json.RawMessage `json:"native"`
+	Floor  *PBSFloor       `json:"floor"`
+	// GPID is the Global Placement ID. It defaults to the PBAdSlot, and then to the Code.
+	GPID string `json:"gpid"`
+	// PBAdSlot is the ad slot's path in the publisher's ad server, like /1111/homepage/top-banner.
+	PBAdSlot string `json:"pbadslot"`
+}
+
+// GlobalPlacementID returns the ad unit's GPID, which bidders key their frequency capping and reporting on.
+func (unit *AdUnit) GlobalPlacementID() string {
+	if unit.GPID != "" {
+		return unit.GPID
+	}
+	if unit.PBAdSlot != "" {
+		return unit.PBAdSlot
+	}
+	return unit.Code
+}
+
+// PBSFloor is the minimum price for an ad unit. Multiformat ad units may set a different floor for each media type.
+type PBSFloor struct {
+	// Value is the floor for media types which don't have their own.
+	Value float64 `json:"value"`
+	// MediaTypes holds the floors for individual media types, keyed by "banner", "video" or "native".
+	MediaTypes map[string]float64 `json:"media_types"`
+	// Currency defaults to USD.
+	Currency string `json:"currency"`
+}
+
+// Resolve returns the floor for an imp offering the given media types, and its currency.
+//
+// An imp offering several media types gets the lowest of their floors, so that no format
+// is priced out of the auction by another format's floor.
+func (f *PBSFloor) Resolve(mediaTypes []MediaType) (float64, string) {
+	if f == nil {
+		return 0, ""
+	}
+	currency := f.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	if len(mediaTypes) == 0 {
+		return f.Value, currency
+	}
+	floor := f.forMediaType(mediaTypes[0])
+	for _, mediaType := range mediaTypes[1:] {
+		if value := f.forMediaType(mediaType); value < floor {
+			floor = value
+		}
+	}
+	return floor, currency
+}
+
+// ConvertToUSD changes the floor's currency to USD, so that bidders can compare it with the prices in
+// their params. convert changes a price from one currency into another. The floor is left alone if it fails.
+func (f *PBSFloor) ConvertToUSD(convert func(price float64, from string, to string) (float64, error)) error {
+	if f == nil || f.Currency == "" || strings.EqualFold(f.Currency, "USD") {
+		return nil
+	}
+	value, err := convert(f.Value, f.Currency, "USD")
+	if err != nil {
+		return err
+	}
+	mediaTypes := make(map[string]float64, len(f.MediaTypes))
+	for name, floor := range f.MediaTypes {
+		if mediaTypes[name], err = convert(floor, f.Currency, "USD"); err != nil {
+			return err
+		}
+	}
+	f.Value = value
+	f.MediaTypes = mediaTypes
+	f.Currency = "USD"
+	return nil
+}
+
+func (f *PBSFloor) forMediaType(mediaType MediaType) float64 {
+	for name, value := range f.MediaTypes {
+		if t, err := ParseMediaType(name); err == nil && t == mediaType {
+			return value
+		}
+	}
+	return f.Value
 }
 
 type PBSAdUnit struct {
@@ -96,12 +181,16 @@ type PBSAdUnit struct {
 	BidID      string
 	Params     json.RawMessage
 	Video      PBSVideo
+	Native     json.RawMessage
 	MediaTypes []MediaType
 	Instl      int8
+	Floor      *PBSFloor
+	GPID       string
+	PBAdSlot   string
 }
 
 func ParseMediaType(s string) (MediaType, error) {
-	mediaTypes := map[string]MediaType{"BANNER": MEDIA_TYPE_BANNER, "VIDEO": MEDIA_TYPE_VIDEO}
+	mediaTypes := map[string]MediaType{"BANNER": MEDIA_TYPE_BANNER, "VIDEO": MEDIA_TYPE_VIDEO, "NATIVE": MEDIA_TYPE_NATIVE}
 	t, ok := mediaTypes[strings.ToUpper(s)]
 	if !ok {
 		return 0, fmt.Errorf("Invalid MediaType %s", s)
@@ -113,6 +202,8 @@ type SDK struct {
 	Version  string `json:"version"`
 	Source   string `json:"source"`
 	Platform string `json:"platform"`
+	// Renderers lists the ad renderers which the SDK can use. Bidders may pick one in bid.ext.prebid.meta.
+	Renderers []SDKRenderer `json:"renderers,omitempty"`
 }
 
 type PBSBidder struct {
@@ -127,6 +218,32 @@ type PBSBidder struct {
 	Debug        []*BidderDebug `json:"debug,omitempty"`
 
 	AdUnits []PBSAdUnit `json:"-"`
+	// Test is true if the bidder should get test=1 in its OpenRTB request.
+	Test bool `json:"-"`
+	// EIDLimits caps the extended IDs which the bidder gets in user.ext.eids. Nil means no limits.
+	EIDLimits *EIDLimits `json:"-"`
+	// StripFields lists the request fields which the bidder must not get, as dotted paths like "user.geo".
+	StripFields []string `json:"-"`
+	// BlockedAppBundles and BlockedDomains are sent to the bidder as bapp and badv.
+	BlockedAppBundles []string `json:"-"`
+	BlockedDomains    []string `json:"-"`
+	// GDPR is the personal data which the bidder mustn't get, because the user hasn't consented to it, or the
+	// account's activity controls deny it.
+	GDPR gdpr.Restrictions `json:"-"`
+	// GPP is true if the bidder gets the raw GPP string in regs.ext. Otherwise, its sections are downgraded
+	// to the signals which predate it.
+	GPP bool `json:"-"`
+}
+
+// EIDLimits keep user.ext.eids within what a bidder's endpoint accepts. Some reject requests whose
+// user object is too large. Zero means no limit.
+type EIDLimits struct {
+	// MaxSources is the most EIDs the bidder gets.
+	MaxSources int
+	// MaxBytes caps the total size of the EIDs' JSON.
+	MaxBytes int
+	// Priority lists the sources which are kept first, in order. The rest follow in the order they were sent.
+	Priority []string
 }
 
 func (bidder *PBSBidder) LookupBidID(Code string) string {
@@ -148,19 +265,26 @@ func (bidder *PBSBidder) LookupAdUnit(Code string) (unit *PBSAdUnit) {
 }
 
 type PBSRequest struct {
-	AccountID     string          `json:"account_id"`
-	Tid           string          `json:"tid"`
-	CacheMarkup   int8            `json:"cache_markup"`
-	SortBids      int8            `json:"sort_bids"`
-	MaxKeyLength  int8            `json:"max_key_length"`
-	Secure        int8            `json:"secure"`
-	TimeoutMillis int64           `json:"timeout_millis"`
-	AdUnits       []AdUnit        `json:"ad_units"`
-	IsDebug       bool            `json:"is_debug"`
-	App           *openrtb.App    `json:"app"`
-	Device        *openrtb.Device `json:"device"`
-	PBSUser       json.RawMessage `json:"user"`
-	SDK           *SDK            `json:"sdk"`
+	AccountID     string   `json:"account_id"`
+	Tid           string   `json:"tid"`
+	CacheMarkup   int8     `json:"cache_markup"`
+	SortBids      int8     `json:"sort_bids"`
+	MaxKeyLength  int8     `json:"max_key_length"`
+	Secure        int8     `json:"secure"`
+	TimeoutMillis int64    `json:"timeout_millis"`
+	AdUnits       []AdUnit `json:"ad_units"`
+	IsDebug       bool     `json:"is_debug"`
+	// Test marks the auction as a test, which shouldn't be billed. It's only passed on to bidders which are configured for it.
+	Test    int8            `json:"test"`
+	App     *openrtb.App    `json:"app"`
+	Device  *openrtb.Device `json:"device"`
+	PBSUser json.RawMessage `json:"user"`
+	SDK     *SDK            `json:"sdk"`
+	Ext     *PBSRequestExt  `json:"ext"`
+	// Regs holds the regulations which apply to the request, like GDPR in regs.ext.gdpr.
+	Regs *openrtb.Regs `json:"regs"`
+	// Ref is the referrer of the page which the ad units are on. Bidders get it in site.ref.
+	Ref string `json:"ref"`
 
 	// internal
 	Bidders []*PBSBidder  `json:"-"`
@@ -168,7 +292,152 @@ type PBSRequest struct {
 	Cookie  *PBSCookie    `json:"-"`
 	Url     string        `json:"-"`
 	Domain  string        `json:"-"`
-	Start   time.Time
+	// BuyerUIDs are the user's IDs from user.ext.prebid.buyeruids. Use BuyerUID to look them up.
+	BuyerUIDs map[string]string `json:"-"`
+	// CookieDeprecation is Chrome's cookie deprecation test label, also sent to bidders in device.ext.cdep.
+	CookieDeprecation string `json:"-"`
+	Start             time.Time
+}
+
+// ResolvedAdUnits returns the bids which each ad unit resolved to, in the order of the request's ad units.
+func (req *PBSRequest) ResolvedAdUnits() []ResolvedAdUnit {
+	units := make([]ResolvedAdUnit, 0, len(req.AdUnits))
+	for _, adUnit := range req.AdUnits {
+		unit := ResolvedAdUnit{Code: adUnit.Code, Bids: []ResolvedBid{}}
+		for _, bidder := range req.Bidders {
+			for _, bidderUnit := range bidder.AdUnits {
+				if bidderUnit.Code == adUnit.Code {
+					unit.Bids = append(unit.Bids, ResolvedBid{
+						Bidder: bidder.BidderCode,
+						BidID:  bidderUnit.BidID,
+						Params: bidderUnit.Params,
+					})
+				}
+			}
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// PBSRequestExt holds the Prebid-specific request options, using the same names as OpenRTB's ext.prebid.
+type PBSRequestExt struct {
+	Prebid PBSRequestExtPrebid `json:"prebid"`
+	// SChain is the supply chain of the request, which bidders get in source.ext.schain.
+	SChain *SupplyChain `json:"schain,omitempty"`
+}
+
+type PBSRequestExtPrebid struct {
+	Targeting      *PBSTargeting      `json:"targeting"`
+	BidderControls *PBSBidderControls `json:"biddercontrols"`
+	// Channel is filled in by ParsePBSRequest. Whatever the client sent is replaced.
+	Channel *Channel `json:"channel,omitempty"`
+	// Aliases maps alias bidder codes onto the bidders whose adapters they use, like {"appnexus2": "appnexus"}.
+	// This lets an adapter take part more than once, with different params.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// PBSTargeting controls which ad server targeting keys are added to the bids.
+// A nil PBSTargeting gives the default behavior: keys for both the winners and every bidder.
+type PBSTargeting struct {
+	IncludeWinners     *bool `json:"includewinners"`
+	IncludeBidderKeys  *bool `json:"includebidderkeys"`
+	AlwaysIncludeDeals bool  `json:"alwaysincludedeals"`
+	// TargetingOnly strips everything but the targeting keys out of the response. This is meant for
+	// AMP-like callers which use cache_markup, and only need key/values to send to their ad server.
+	TargetingOnly bool `json:"targetingonly"`
+	// MaxKeys caps the number of targeting keys for each ad unit, since ad servers limit the size of
+	// their key/value payloads. It can only lower the account's max_targeting_keys. Zero means no limit.
+	MaxKeys int `json:"maxkeys"`
+}
+
+// WinnerKeys returns true if the top bid of each ad unit should get the hb_pb, hb_bidder, ... keys.
+func (t *PBSTargeting) WinnerKeys() bool {
+	return t == nil || t.IncludeWinners == nil || *t.IncludeWinners
+}
+
+// BidderKeys returns true if bids should get the hb_pb_{bidder}, hb_bidder_{bidder}, ... keys.
+func (t *PBSTargeting) BidderKeys() bool {
+	return t == nil || t.IncludeBidderKeys == nil || *t.IncludeBidderKeys
+}
+
+// DealKeys returns true if deal bids should get bidder keys, whether or not BidderKeys is true.
+func (t *PBSTargeting) DealKeys() bool {
+	return t != nil && t.AlwaysIncludeDeals
+}
+
+// Targeting returns the targeting options from the request ext, or nil if none were sent.
+func (req *PBSRequest) Targeting() *PBSTargeting {
+	if req.Ext == nil {
+		return nil
+	}
+	return req.Ext.Prebid.Targeting
+}
+
+// PBSBidderControls lets the page choose which bidders take part in this auction, and how long each may take.
+// This is meant for publisher-side experiments, and for cutting out a misbehaving bidder during an incident.
+type PBSBidderControls struct {
+	// Skip lists bidders which shouldn't be called.
+	Skip []string `json:"skip"`
+	// Only lists the bidders which should be called. If empty, all the bidders in the request are called.
+	Only []string `json:"only"`
+	// TMax overrides the timeout in milliseconds for individual bidders. It can only make a bidder's
+	// timeout shorter than the auction's.
+	TMax map[string]int64 `json:"tmax"`
+}
+
+// Allow returns false if the bidder should be left out of the auction.
+func (c *PBSBidderControls) Allow(bidderCode string) bool {
+	if c == nil {
+		return true
+	}
+	for _, skipped := range c.Skip {
+		if skipped == bidderCode {
+			return false
+		}
+	}
+	if len(c.Only) == 0 {
+		return true
+	}
+	for _, allowed := range c.Only {
+		if allowed == bidderCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeout returns the bidder's timeout override, or 0 if it should use the auction's timeout.
+func (c *PBSBidderControls) Timeout(bidderCode string) time.Duration {
+	if c == nil || c.TMax[bidderCode] <= 0 {
+		return 0
+	}
+	return time.Duration(c.TMax[bidderCode]) * time.Millisecond
+}
+
+// BidderControls returns the bidder controls from the request ext, or nil if none were sent.
+func (req *PBSRequest) BidderControls() *PBSBidderControls {
+	if req.Ext == nil {
+		return nil
+	}
+	return req.Ext.Prebid.BidderControls
+}
+
+// Aliases returns the bidder aliases from the request ext, or nil if none were sent.
+func (req *PBSRequest) Aliases() map[string]string {
+	if req.Ext == nil {
+		return nil
+	}
+	return req.Ext.Prebid.Aliases
+}
+
+// CoreBidder returns the code of the bidder whose adapter runs for the bidder code, which may be one of the
+// request's aliases. Codes which aren't aliases are returned as they are.
+func (req *PBSRequest) CoreBidder(bidderCode string) string {
+	if core, ok := req.Aliases()[bidderCode]; ok {
+		return core
+	}
+	return bidderCode
 }
 
 func ConfigGet(cache cache.Cache, id string) ([]Bids, error) {
@@ -232,11 +501,13 @@ func ParsePBSRequest(r *http.Request, cache cache.Cache, hostCookieSettings *Hos
 	if pbsReq.Device == nil {
 		pbsReq.Device = &openrtb.Device{}
 	}
-	pbsReq.Device.IP = prebid.GetIP(r)
+	pbsReq.Device.IP, pbsReq.Device.IPv6 = prebid.GetIPs(r)
 
 	if pbsReq.SDK == nil {
 		pbsReq.SDK = &SDK{}
 	}
+	normalizeSDK(pbsReq.SDK, pbsReq.App)
+	pbsReq.setChannel()
 
 	// Early versions of prebid mobile are sending requests with gender indicated by numbers,
 	// those traffic can't be parsed by latest Prebid Server after the change of gender to use string so clients using early versions can't be monetized.
@@ -255,10 +526,18 @@ func ParsePBSRequest(r *http.Request, cache cache.Cache, hostCookieSettings *Hos
 	if pbsReq.User == nil {
 		pbsReq.User = &openrtb.User{}
 	}
+	pbsReq.BuyerUIDs, err = readBuyerUIDs(pbsReq.User)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user.ext: %v", err)
+	}
 
 	// use client-side data for web requests
 	if pbsReq.App == nil {
-		pbsReq.Cookie = ParsePBSCookieFromRequest(r)
+		pbsReq.Cookie = ParsePBSCookieFromRequest(r, hostCookieSettings)
+		if !pbsReq.Cookie.AllowSyncs() {
+			// Users who opted out shouldn't be identifiable to bidders.
+			pbsReq.User.BuyerUID = ""
+		}
 
 		// Host has right to leverage private cookie store for user ID
 		if uid, _, _ := pbsReq.Cookie.GetUID(hostCookieSettings.Family); uid == "" && hostCookieSettings.CookieName != "" {
@@ -269,6 +548,12 @@ func ParsePBSRequest(r *http.Request, cache cache.Cache, hostCookieSettings *Hos
 
 		pbsReq.Device.UA = r.Header.Get("User-Agent")
 
+		pbsReq.CookieDeprecation, err = readCookieDeprecation(r, pbsReq.Device)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid device.ext: %v", err)
+		}
+		readTopics(r, pbsReq.User, pbsReq.Cookie)
+
 		pbsReq.Url = r.Header.Get("Referer") // must be specified in the header
 		// TODO: this should explicitly put us in test mode
 		if r.FormValue("url_override") != "" {
@@ -304,58 +589,93 @@ func ParsePBSRequest(r *http.Request, cache cache.Cache, hostCookieSettings *Hos
 	pbsReq.Bidders = make([]*PBSBidder, 0, MAX_BIDDERS)
 
 	for _, unit := range pbsReq.AdUnits {
-		bidders := unit.Bids
-		if unit.ConfigID != "" {
-			bidders, err = ConfigGet(cache, unit.ConfigID)
-			if err != nil {
-				// proceed with other ad units
-				glog.Warningf("Failed to load config '%s' from cache: %v", unit.ConfigID, err)
-				continue
-			}
-		}
+		pbsReq.addAdUnit(cache, unit)
+	}
+
+	return pbsReq, nil
+}
 
-		if glog.V(2) {
-			glog.Infof("Ad unit %s has %d bidders for %d sizes", unit.Code, len(bidders), len(unit.Sizes))
+// addAdUnit adds the ad unit to each of its bidders, loading them from the stored config if it has one.
+func (req *PBSRequest) addAdUnit(cache cache.Cache, unit AdUnit) {
+	bidders := unit.Bids
+	if unit.ConfigID != "" {
+		var err error
+		bidders, err = ConfigGet(cache, unit.ConfigID)
+		if err != nil {
+			// proceed with other ad units
+			glog.Warningf("Failed to load config '%s' from cache: %v", unit.ConfigID, err)
+			return
 		}
+	}
 
-		mtypes := ParseMediaTypes(unit.MediaTypes)
-		for _, b := range bidders {
-			var bidder *PBSBidder
-			// index requires a different request for each ad unit
-			if b.BidderCode != "indexExchange" {
-				for _, pb := range pbsReq.Bidders {
-					if pb.BidderCode == b.BidderCode {
-						bidder = pb
-					}
-				}
-			}
-			if bidder == nil {
-				bidder = &PBSBidder{BidderCode: b.BidderCode}
-				if b.BidderCode == "indexExchange" {
-					bidder.AdUnitCode = unit.Code
+	if glog.V(2) {
+		glog.Infof("Ad unit %s has %d bidders for %d sizes", unit.Code, len(bidders), len(unit.Sizes))
+	}
+
+	mtypes := ParseMediaTypes(unit.MediaTypes)
+	for _, b := range bidders {
+		var bidder *PBSBidder
+		coreBidder := req.CoreBidder(b.BidderCode)
+		// index requires a different request for each ad unit
+		if coreBidder != "indexExchange" {
+			for _, pb := range req.Bidders {
+				if pb.BidderCode == b.BidderCode {
+					bidder = pb
 				}
-				pbsReq.Bidders = append(pbsReq.Bidders, bidder)
 			}
-			if b.BidID == "" {
-				b.BidID = fmt.Sprintf("%d", rand.Int63())
+		}
+		if bidder == nil {
+			bidder = &PBSBidder{BidderCode: b.BidderCode}
+			if coreBidder == "indexExchange" {
+				bidder.AdUnitCode = unit.Code
 			}
+			req.Bidders = append(req.Bidders, bidder)
+		}
+		if b.BidID == "" {
+			b.BidID = fmt.Sprintf("%d", rand.Int63())
+		}
 
-			pau := PBSAdUnit{
-				Sizes:      unit.Sizes,
-				TopFrame:   unit.TopFrame,
-				Code:       unit.Code,
-				Instl:      unit.Instl,
-				Params:     b.Params,
-				BidID:      b.BidID,
-				MediaTypes: mtypes,
-				Video:      unit.Video,
-			}
+		pau := PBSAdUnit{
+			Sizes:      unit.Sizes,
+			TopFrame:   unit.TopFrame,
+			Code:       unit.Code,
+			Instl:      unit.Instl,
+			Params:     NormalizeParams(coreBidder, b.Params),
+			BidID:      b.BidID,
+			MediaTypes: mtypes,
+			Video:      unit.Video,
+			Native:     unit.Native,
+			Floor:      unit.Floor,
+			GPID:       unit.GlobalPlacementID(),
+			PBAdSlot:   unit.PBAdSlot,
+		}
+
+		bidder.AdUnits = append(bidder.AdUnits, pau)
+	}
+}
 
-			bidder.AdUnits = append(bidder.AdUnits, pau)
+// ConvertFloors converts the ad units' floors to USD. The bidders' ad units share their floors, so they get
+// converted too. Floors which can't be converted are left in their own currency, and return an error.
+func (req *PBSRequest) ConvertFloors(convert func(price float64, from string, to string) (float64, error)) []error {
+	var errs []error
+	for _, unit := range req.AdUnits {
+		if err := unit.Floor.ConvertToUSD(convert); err != nil {
+			errs = append(errs, fmt.Errorf("The floor for ad unit %s couldn't be converted to USD: %v", unit.Code, err))
 		}
 	}
+	return errs
+}
 
-	return pbsReq, nil
+// UseDefaultConfig loads a stored config for the ad units which list no bidders and no config_id.
+// Accounts set these up for apps, so that SDK integrations can leave their placement settings to the server.
+func (req *PBSRequest) UseDefaultConfig(cache cache.Cache, configID string) {
+	for i := range req.AdUnits {
+		unit := &req.AdUnits[i]
+		if len(unit.Bids) == 0 && unit.ConfigID == "" {
+			unit.ConfigID = configID
+			req.addAdUnit(cache, *unit)
+		}
+	}
 }
 
 func (req PBSRequest) Elapsed() int {
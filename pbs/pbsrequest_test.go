@@ -2,9 +2,11 @@ package pbs
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
 	"github.com/prebid/prebid-server/cache/dummycache"
@@ -126,6 +128,33 @@ func TestParseSimpleRequest(t *testing.T) {
 
 }
 
+func TestResolvedAdUnits(t *testing.T) {
+	body := []byte(`{"tid":"abcd","ad_units":[
+		{"code":"first","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"rubicon","bid_id":"1","params":{"site_id":10}},{"bidder":"appnexus","bid_id":"2"}]},
+		{"code":"second","sizes":[{"w":728,"h":90}],"bids":[{"bidder":"rubicon","bid_id":"3","params":{"siteId":20}}]}
+	]}`)
+	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+	r.Header.Add("Referer", "http://nytimes.com/cool.html")
+	d, _ := dummycache.New()
+	pbs_req, err := ParsePBSRequest(r, d, &HostCookieSettings{})
+	if err != nil {
+		t.Fatalf("Parse request failed: %v", err)
+	}
+
+	units := pbs_req.ResolvedAdUnits()
+	if len(units) != 2 || units[0].Code != "first" || units[1].Code != "second" {
+		t.Fatalf("Expected both ad units in order. Got %v", units)
+	}
+	if len(units[0].Bids) != 2 || len(units[1].Bids) != 1 {
+		t.Fatalf("Each ad unit should have its own bids. Got %v", units)
+	}
+	for _, bid := range units[0].Bids {
+		if bid.Bidder == "rubicon" && (bid.BidID != "1" || string(bid.Params) != `{"siteId":10}`) {
+			t.Errorf("Rubicon's params should be resolved. Got %s", bid.Params)
+		}
+	}
+}
+
 func TestHeaderParsing(t *testing.T) {
 	body := []byte(`{
         "tid": "abcd",
@@ -169,6 +198,38 @@ func TestHeaderParsing(t *testing.T) {
 	}
 }
 
+func TestDeviceIPv6(t *testing.T) {
+	body := []byte(`{"tid": "abcd", "ad_units": [{"code": "first", "sizes": [{"w": 300, "h": 250}], "bidders": [{"bidder": "appnexus", "params": {"placementId": "1"}}]}]}`)
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expectedIPv4 string
+		expectedIPv6 string
+	}{
+		{"203.0.113.7:1234", "", "203.0.113.7", ""},
+		{"[2001:DB8::1]:1234", "", "", "2001:db8::1"},
+		{"192.0.2.1:1234", "2001:db8::2,192.0.2.1", "", "2001:db8::2"},
+		{"192.0.2.1:1234", "::ffff:198.51.100.4", "198.51.100.4", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+		r.Header.Add("Referer", "http://nytimes.com/cool.html")
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		d, _ := dummycache.New()
+		pbs_req, err := ParsePBSRequest(r, d, &HostCookieSettings{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if pbs_req.Device.IP != test.expectedIPv4 || pbs_req.Device.IPv6 != test.expectedIPv6 {
+			t.Errorf("%s (%s): expected ip %q and ipv6 %q. Got %q and %q", test.remoteAddr, test.forwardedFor,
+				test.expectedIPv4, test.expectedIPv6, pbs_req.Device.IP, pbs_req.Device.IPv6)
+		}
+	}
+}
+
 var dummyConfig = `
 [
 							{
@@ -205,6 +266,31 @@ var dummyConfig = `
 					]
 		`
 
+func TestUseDefaultConfig(t *testing.T) {
+	d, _ := dummycache.New()
+	d.Config().Set("dummy", dummyConfig)
+	req := &PBSRequest{
+		AdUnits: []AdUnit{
+			{Code: "first"},
+			{Code: "second", Bids: []Bids{{BidderCode: "rubicon", BidID: "1"}}},
+		},
+	}
+	req.addAdUnit(d, req.AdUnits[1])
+
+	req.UseDefaultConfig(d, "dummy")
+	if req.AdUnits[0].ConfigID != "dummy" || req.AdUnits[1].ConfigID != "" {
+		t.Errorf("Only ad units without bidders should get the default config. Got %#v", req.AdUnits)
+	}
+	if len(req.Bidders) != 5 {
+		t.Fatalf("The default config's four bidders should be added. Got %d bidders", len(req.Bidders))
+	}
+	for _, bidder := range req.Bidders {
+		if len(bidder.AdUnits) != 1 {
+			t.Errorf("%s should have one ad unit. Got %d", bidder.BidderCode, len(bidder.AdUnits))
+		}
+	}
+}
+
 func TestParseConfig(t *testing.T) {
 	body := []byte(`{
         "tid": "abcd",
@@ -673,3 +759,158 @@ func TestParsePBSRequestUsesHostCookie(t *testing.T) {
 		t.Errorf("Failed to leverage host cookie space for user identifier")
 	}
 }
+
+func TestTargetingDefaults(t *testing.T) {
+	var targeting *PBSTargeting
+	assert.Equal(t, targeting.WinnerKeys(), true)
+	assert.Equal(t, targeting.BidderKeys(), true)
+	assert.Equal(t, targeting.DealKeys(), false)
+
+	includeWinners := false
+	targeting = &PBSTargeting{IncludeWinners: &includeWinners}
+	assert.Equal(t, targeting.WinnerKeys(), false)
+	assert.Equal(t, targeting.BidderKeys(), true)
+}
+
+func TestBidderControls(t *testing.T) {
+	var controls *PBSBidderControls
+	assert.Equal(t, controls.Allow("appnexus"), true)
+	assert.Equal(t, controls.Timeout("appnexus"), time.Duration(0))
+
+	controls = &PBSBidderControls{
+		Skip: []string{"rubicon"},
+		TMax: map[string]int64{"appnexus": 150},
+	}
+	assert.Equal(t, controls.Allow("appnexus"), true)
+	assert.Equal(t, controls.Allow("rubicon"), false)
+	assert.Equal(t, controls.Timeout("appnexus"), 150*time.Millisecond)
+	assert.Equal(t, controls.Timeout("rubicon"), time.Duration(0))
+
+	controls = &PBSBidderControls{
+		Skip: []string{"appnexus"},
+		Only: []string{"appnexus", "pubmatic"},
+	}
+	assert.Equal(t, controls.Allow("pubmatic"), true)
+	assert.Equal(t, controls.Allow("appnexus"), false)
+	assert.Equal(t, controls.Allow("rubicon"), false)
+}
+
+func TestParseBidderControls(t *testing.T) {
+	body := []byte(`{"tid":"abcd","ad_units":[{"code":"first","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus"}]}],` +
+		`"ext":{"prebid":{"biddercontrols":{"only":["appnexus"],"tmax":{"appnexus":100}}}}}`)
+	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+	r.Header.Add("Referer", "http://nytimes.com/cool.html")
+	d, _ := dummycache.New()
+	hcs := HostCookieSettings{}
+
+	pbs_req, err := ParsePBSRequest(r, d, &hcs)
+	if err != nil {
+		t.Fatalf("Parse simple request failed: %v", err)
+	}
+	controls := pbs_req.BidderControls()
+	if controls == nil {
+		t.Fatalf("Expected the bidder controls to be parsed")
+	}
+	assert.Equal(t, controls.Only, []string{"appnexus"})
+	assert.Equal(t, controls.Timeout("appnexus"), 100*time.Millisecond)
+}
+
+func TestParseAliases(t *testing.T) {
+	body := []byte(`{"tid":"abcd","ad_units":[` +
+		`{"code":"first","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus","params":{"placementId":1}},{"bidder":"appnexus2","params":{"placement_id":2}},{"bidder":"ix2"}]},` +
+		`{"code":"second","sizes":[{"w":728,"h":90}],"bids":[{"bidder":"ix2"}]}],` +
+		`"ext":{"prebid":{"aliases":{"appnexus2":"appnexus","ix2":"indexExchange"}}}}`)
+	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+	r.Header.Add("Referer", "http://nytimes.com/cool.html")
+	d, _ := dummycache.New()
+	hcs := HostCookieSettings{}
+
+	pbs_req, err := ParsePBSRequest(r, d, &hcs)
+	if err != nil {
+		t.Fatalf("Parse simple request failed: %v", err)
+	}
+	assert.Equal(t, pbs_req.CoreBidder("appnexus2"), "appnexus")
+	assert.Equal(t, pbs_req.CoreBidder("appnexus"), "appnexus")
+	assert.Equal(t, pbs_req.CoreBidder("rubicon"), "rubicon")
+
+	// Aliases are bidders of their own, and aliases of indexExchange get a bidder for each ad unit like it does.
+	codes := make([]string, 0, len(pbs_req.Bidders))
+	for _, bidder := range pbs_req.Bidders {
+		codes = append(codes, bidder.BidderCode)
+	}
+	assert.Equal(t, codes, []string{"appnexus", "appnexus2", "ix2", "ix2"})
+	// The aliases' legacy params are renamed like their core bidders' are.
+	assert.Equal(t, string(pbs_req.Bidders[1].AdUnits[0].Params), `{"placementId":2}`)
+	assert.Equal(t, pbs_req.Bidders[3].AdUnitCode, "second")
+
+	assert.Equal(t, (&PBSRequest{}).CoreBidder("appnexus2"), "appnexus2")
+}
+
+func TestParseGPID(t *testing.T) {
+	body := []byte(`{"tid":"abcd","ad_units":[` +
+		`{"code":"first","sizes":[{"w":300,"h":250}],"gpid":"/1111/home#top","pbadslot":"/1111/home","bids":[{"bidder":"appnexus"}]},` +
+		`{"code":"second","sizes":[{"w":300,"h":250}],"pbadslot":"/1111/side","bids":[{"bidder":"appnexus"}]},` +
+		`{"code":"third","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus"}]}]}`)
+	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+	r.Header.Add("Referer", "http://nytimes.com/cool.html")
+	d, _ := dummycache.New()
+	hcs := HostCookieSettings{}
+
+	pbs_req, err := ParsePBSRequest(r, d, &hcs)
+	if err != nil {
+		t.Fatalf("Parse simple request failed: %v", err)
+	}
+	units := pbs_req.Bidders[0].AdUnits
+	assert.Equal(t, len(units), 3)
+	assert.Equal(t, units[0].GPID, "/1111/home#top")
+	assert.Equal(t, units[0].PBAdSlot, "/1111/home")
+	assert.Equal(t, units[1].GPID, "/1111/side")
+	assert.Equal(t, units[2].GPID, "third")
+	assert.Equal(t, units[2].PBAdSlot, "")
+}
+
+func TestFloorResolve(t *testing.T) {
+	var floor *PBSFloor
+	value, currency := floor.Resolve([]MediaType{MEDIA_TYPE_BANNER})
+	assert.Equal(t, value, 0.0)
+	assert.Equal(t, currency, "")
+
+	floor = &PBSFloor{
+		Value:      1,
+		MediaTypes: map[string]float64{"video": 3, "banner": 1.5},
+	}
+	value, currency = floor.Resolve([]MediaType{MEDIA_TYPE_VIDEO})
+	assert.Equal(t, value, 3.0)
+	assert.Equal(t, currency, "USD")
+	value, _ = floor.Resolve([]MediaType{MEDIA_TYPE_VIDEO, MEDIA_TYPE_BANNER})
+	assert.Equal(t, value, 1.5)
+	value, _ = floor.Resolve(nil)
+	assert.Equal(t, value, 1.0)
+}
+
+func TestConvertFloors(t *testing.T) {
+	convert := func(price float64, from string, to string) (float64, error) {
+		if from != "EUR" || to != "USD" {
+			return 0, errors.New("no rate")
+		}
+		return price * 2, nil
+	}
+	euros := &PBSFloor{Value: 1, MediaTypes: map[string]float64{"video": 3}, Currency: "EUR"}
+	yen := &PBSFloor{Value: 100, Currency: "JPY"}
+	req := &PBSRequest{
+		AdUnits: []AdUnit{{Code: "top", Floor: euros}, {Code: "side", Floor: yen}, {Code: "none"}},
+		Bidders: []*PBSBidder{{BidderCode: "appnexus", AdUnits: []PBSAdUnit{{Code: "top", Floor: euros}}}},
+	}
+	errs := req.ConvertFloors(convert)
+	assert.Equal(t, len(errs), 1)
+
+	value, currency := req.Bidders[0].AdUnits[0].Floor.Resolve([]MediaType{MEDIA_TYPE_VIDEO})
+	assert.Equal(t, value, 6.0)
+	assert.Equal(t, currency, "USD")
+	value, currency = req.AdUnits[0].Floor.Resolve(nil)
+	assert.Equal(t, value, 2.0)
+	assert.Equal(t, currency, "USD")
+	value, currency = req.AdUnits[1].Floor.Resolve(nil)
+	assert.Equal(t, value, 100.0)
+	assert.Equal(t, currency, "JPY")
+}
@@ -1,5 +1,11 @@
 package pbs
 
+import (
+	"encoding/json"
+
+	"github.com/prebid/prebid-server/errortypes"
+)
+
 // PBSBid is a bid from the auction. These are produced by Adapters, and target a particular Ad Unit.
 //
 // This JSON format is a contract with both Prebid.js and Prebid-mobile.
@@ -31,6 +37,10 @@ type PBSBid struct {
 	// Adm is the ad markup which should be used to deliver the ad, if this bid is chosen.
 	// If NURL and Adm are both defined, then Adm takes precedence.
 	Adm string `json:"adm,omitempty"`
+	// BURL is a billing notice URL, which should be called when the bid's impression becomes billable.
+	BURL string `json:"burl,omitempty"`
+	// BidResponseID is the bidid from the bidder's OpenRTB response. It is used to resolve the ${AUCTION_BID_ID} macro.
+	BidResponseID string `json:"-"`
 	// Width is the intended width which Adm should be shown, in pixels.
 	Width uint64 `json:"width,omitempty"`
 	// Height is the intended width which Adm should be shown, in pixels.
@@ -38,6 +48,9 @@ type PBSBid struct {
 	// DealId is not used by prebid-server, but may be used by buyers and sellers who make special
 	// deals with each other. We simply pass this information along with the bid.
 	DealId string `json:"deal_id,omitempty"`
+	// Exp is the number of seconds which the bid stays valid after the auction. If the bidder didn't say,
+	// it's the number of seconds the bid was cached for, or zero if it wasn't cached.
+	Exp int64 `json:"exp,omitempty"`
 	// CacheId is an ID in prebid-cache which can be used to fetch this ad's content.
 	// This supports prebid-mobile, which requires that the content be available from a URL.
 	CacheID string `json:"cache_id,omitempty"`
@@ -47,6 +60,40 @@ type PBSBid struct {
 	// ResponseTime is the number of milliseconds it took for the adapter to return a bid.
 	ResponseTime      int               `json:"response_time_ms,omitempty"`
 	AdServerTargeting map[string]string `json:"ad_server_targeting,omitempty"`
+	// Meta describes who is behind this bid. Adapters fill in whatever their bidder tells them,
+	// and the auction makes sure that every bid in the response has one.
+	Meta *PBSBidMeta `json:"meta,omitempty"`
+	// Ext holds the price and currency which the bidder originally returned, before any adjustments.
+	Ext *PBSBidExt `json:"ext,omitempty"`
+}
+
+// PBSBidExt contains the extra data attached to each bid.
+type PBSBidExt struct {
+	// OrigBidCPM is the price which the bidder returned. Publishers use it to reconcile against the bidder's reports.
+	OrigBidCPM float64 `json:"origbidcpm,omitempty"`
+	// OrigBidCur is the currency of OrigBidCPM.
+	OrigBidCur string `json:"origbidcur,omitempty"`
+}
+
+// PBSBidMeta holds the transparency data for a bid. The field names match the ones used by Prebid.js,
+// so that publishers see the same data regardless of whether a bidder ran client or server side.
+type PBSBidMeta struct {
+	// AdvertiserDomains are the domains of the advertisers who are paying for this creative.
+	AdvertiserDomains []string `json:"advertiserDomains,omitempty"`
+	// BrandID identifies the brand being advertised, in the bidder's own ID space.
+	BrandID int `json:"brandId,omitempty"`
+	// NetworkID identifies the network which bought the impression, in the bidder's own ID space.
+	NetworkID int `json:"networkId,omitempty"`
+	// MediaType is the type of creative which was returned. This will be "banner" or "video".
+	MediaType string `json:"mediaType,omitempty"`
+	// DChain is the demand chain object, as defined by the IAB, which describes the path this bid took.
+	DChain json.RawMessage `json:"dchain,omitempty"`
+	// RendererName picks one of the renderers which the mobile SDK declared in sdk.renderers.
+	RendererName string `json:"rendererName,omitempty"`
+	// RendererVersion is the renderer version which the creative was built for.
+	RendererVersion string `json:"rendererVersion,omitempty"`
+	// RendererData is passed to the renderer as-is.
+	RendererData json.RawMessage `json:"rendererData,omitempty"`
 }
 
 // PBSBidSlice attaches the methods of sort.Interface to []PBSBid, ordering them by price.
@@ -73,6 +120,19 @@ type BidderDebug struct {
 	RequestBody  string `json:"request_body,omitempty"`
 	ResponseBody string `json:"response_body,omitempty"`
 	StatusCode   int    `json:"status_code,omitempty"`
+	// Timing is set by adapters which send their requests through adapters.HTTPAdapter.DoRequest.
+	Timing *BidderTiming `json:"timing,omitempty"`
+}
+
+// BidderTiming breaks down how long the call to the bidder took, in milliseconds.
+type BidderTiming struct {
+	DNSMillis     int  `json:"dns_ms"`
+	ConnectMillis int  `json:"connect_ms"`
+	TLSMillis     int  `json:"tls_ms"`
+	TTFBMillis    int  `json:"ttfb_ms"`
+	TotalMillis   int  `json:"total_ms"`
+	ReusedConn    bool `json:"reused_conn"`
+	Retried       bool `json:"retried,omitempty"`
 }
 
 type UsersyncInfo struct {
@@ -82,9 +142,75 @@ type UsersyncInfo struct {
 }
 
 type PBSResponse struct {
-	TID          string       `json:"tid,omitempty"`
-	Status       string       `json:"status,omitempty"`
-	BidderStatus []*PBSBidder `json:"bidder_status,omitempty"`
-	Bids         PBSBidSlice  `json:"bids,omitempty"`
-	BUrl         string       `json:"burl,omitempty"`
+	TID          string          `json:"tid,omitempty"`
+	Status       string          `json:"status,omitempty"`
+	BidderStatus []*PBSBidder    `json:"bidder_status,omitempty"`
+	Bids         PBSBidSlice     `json:"bids,omitempty"`
+	BUrl         string          `json:"burl,omitempty"`
+	Ext          *PBSResponseExt `json:"ext,omitempty"`
+}
+
+// PBSResponseExt holds machine-readable details about partial failures in the auction.
+// Both maps are keyed by bidder code, or "prebid" for problems which aren't any one bidder's.
+type PBSResponseExt struct {
+	Errors   map[string][]ExtResponseMessage `json:"errors,omitempty"`
+	Warnings map[string][]ExtResponseMessage `json:"warnings,omitempty"`
+	// Debug is only set for debug requests.
+	Debug  *PBSResponseDebug     `json:"debug,omitempty"`
+	Prebid *PBSResponseExtPrebid `json:"prebid,omitempty"`
+}
+
+// PBSResponseExtPrebid holds details about the auction itself.
+type PBSResponseExtPrebid struct {
+	// AuctionTimestamp is when the auction started, in milliseconds since the Unix epoch.
+	AuctionTimestamp int64 `json:"auctiontimestamp"`
+}
+
+// PBSResponseDebug explains how the auction interpreted the request.
+type PBSResponseDebug struct {
+	// ResolvedRequest lists the params which each bidder got, after stored configs were loaded
+	// and legacy params were renamed.
+	ResolvedRequest []ResolvedAdUnit `json:"resolvedrequest"`
+}
+
+// ResolvedAdUnit is an ad unit from the request, with the bids which were resolved for it.
+type ResolvedAdUnit struct {
+	Code string        `json:"code"`
+	Bids []ResolvedBid `json:"bids"`
+}
+
+type ResolvedBid struct {
+	Bidder string          `json:"bidder"`
+	BidID  string          `json:"bid_id"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// ExtResponseMessage describes a single error or warning. Code is one of the codes from the errortypes package.
+type ExtResponseMessage struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// AddErrors sorts the bidder's errors into Errors or Warnings, depending on their severity.
+func (resp *PBSResponse) AddErrors(bidderCode string, errs []error) {
+	for _, err := range errs {
+		if resp.Ext == nil {
+			resp.Ext = &PBSResponseExt{}
+		}
+		message := ExtResponseMessage{
+			Code:    errortypes.ReadCode(err),
+			Message: err.Error(),
+		}
+		if errortypes.ReadSeverity(err) == errortypes.SeverityWarning {
+			if resp.Ext.Warnings == nil {
+				resp.Ext.Warnings = make(map[string][]ExtResponseMessage)
+			}
+			resp.Ext.Warnings[bidderCode] = append(resp.Ext.Warnings[bidderCode], message)
+		} else {
+			if resp.Ext.Errors == nil {
+				resp.Ext.Errors = make(map[string][]ExtResponseMessage)
+			}
+			resp.Ext.Errors[bidderCode] = append(resp.Ext.Errors[bidderCode], message)
+		}
+	}
 }
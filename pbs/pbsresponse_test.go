@@ -1,8 +1,11 @@
 package pbs
 
 import (
+	"errors"
 	"sort"
 	"testing"
+
+	"github.com/prebid/prebid-server/errortypes"
 )
 
 func TestSortBids(t *testing.T) {
@@ -86,3 +89,30 @@ func TestSortBidsWithResponseTimes(t *testing.T) {
 		t.Error("Expected bid 3 to be last")
 	}
 }
+
+func TestAddErrors(t *testing.T) {
+	resp := PBSResponse{}
+	resp.AddErrors("appnexus", nil)
+	if resp.Ext != nil {
+		t.Errorf("The ext shouldn't be created when there are no errors")
+	}
+
+	resp.AddErrors("appnexus", []error{
+		&errortypes.Timeout{Message: "Timed out"},
+		&errortypes.Warning{Message: "1 of 2 bids were rejected", WarningCode: errortypes.InvalidBidWarningCode},
+	})
+	resp.AddErrors("rubicon", []error{errors.New("Missing siteId param")})
+
+	if len(resp.Ext.Errors["appnexus"]) != 1 || resp.Ext.Errors["appnexus"][0].Code != errortypes.TimeoutErrorCode {
+		t.Errorf("Expected a timeout error for appnexus. Got %v", resp.Ext.Errors["appnexus"])
+	}
+	if len(resp.Ext.Warnings["appnexus"]) != 1 || resp.Ext.Warnings["appnexus"][0].Code != errortypes.InvalidBidWarningCode {
+		t.Errorf("Expected an invalid bid warning for appnexus. Got %v", resp.Ext.Warnings["appnexus"])
+	}
+	if len(resp.Ext.Errors["rubicon"]) != 1 || resp.Ext.Errors["rubicon"][0].Code != errortypes.UnknownErrorCode {
+		t.Errorf("Expected an unknown error for rubicon. Got %v", resp.Ext.Errors["rubicon"])
+	}
+	if resp.Ext.Errors["rubicon"][0].Message != "Missing siteId param" {
+		t.Errorf("Bad message: %s", resp.Ext.Errors["rubicon"][0].Message)
+	}
+}
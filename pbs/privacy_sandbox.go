@@ -0,0 +1,112 @@
+package pbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// CookieDeprecationHeader carries Chrome's label for browsers in the third-party cookie deprecation test groups.
+const CookieDeprecationHeader = "Sec-Cookie-Deprecation"
+
+// maxCookieDeprecationLength is the longest label which Chrome will send.
+const maxCookieDeprecationLength = 100
+
+// readCookieDeprecation copies the browser's cookie deprecation label into device.ext.cdep, where bidders expect it.
+// A label already in the request wins over the header. It returns the label which the request ends up with.
+func readCookieDeprecation(r *http.Request, device *openrtb.Device) (string, error) {
+	ext := make(map[string]json.RawMessage)
+	if len(device.Ext) > 0 {
+		if err := json.Unmarshal(device.Ext, &ext); err != nil {
+			return "", err
+		}
+	}
+	if existing, ok := ext["cdep"]; ok {
+		var label string
+		json.Unmarshal(existing, &label)
+		return label, nil
+	}
+
+	label := r.Header.Get(CookieDeprecationHeader)
+	if label == "" || len(label) > maxCookieDeprecationLength {
+		return "", nil
+	}
+	encoded, err := json.Marshal(label)
+	if err != nil {
+		return "", err
+	}
+	ext["cdep"] = encoded
+	if device.Ext, err = json.Marshal(ext); err != nil {
+		return "", err
+	}
+	return label, nil
+}
+
+// TopicsHeader carries the Topics API interests which the browser chose to share with this request.
+const TopicsHeader = "Sec-Browsing-Topics"
+
+// topicsDataName identifies the user.data entries which came from the Topics API.
+const topicsDataName = "Sec-Browsing-Topics"
+
+// readTopics adds the Topics API interests from the request header to user.data.
+// Users who opted out of syncs don't get their topics forwarded.
+func readTopics(r *http.Request, user *openrtb.User, cookie *PBSCookie) {
+	if !cookie.AllowSyncs() {
+		return
+	}
+	user.Data = append(user.Data, parseTopics(r.Header.Get(TopicsHeader))...)
+}
+
+// parseTopics converts a Sec-Browsing-Topics header into user.data entries. The header is a structured field list like:
+//
+//	(1 2);v=chrome.1:1:2, (7);v=chrome.1:2:4, ();p=P0000000000
+//
+// where v holds the browser, taxonomy and model versions. Each list becomes one entry, with the IAB segtax for
+// its taxonomy version and the model version as the segclass. Malformed lists and unknown taxonomies are skipped.
+func parseTopics(header string) []openrtb.Data {
+	var data []openrtb.Data
+	for _, member := range strings.Split(header, ",") {
+		member = strings.TrimSpace(member)
+		end := strings.Index(member, ")")
+		if !strings.HasPrefix(member, "(") || end < 0 {
+			continue
+		}
+
+		var segments []openrtb.Segment
+		for _, id := range strings.Fields(member[1:end]) {
+			if topic, err := strconv.Atoi(id); err == nil && topic > 0 {
+				segments = append(segments, openrtb.Segment{ID: id})
+			}
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		var version string
+		for _, param := range strings.Split(member[end+1:], ";") {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "v" {
+				version = strings.Trim(kv[1], `"`)
+			}
+		}
+		// v=<browser>:<taxonomy version>:<model version>
+		versions := strings.Split(version, ":")
+		if len(versions) != 3 {
+			continue
+		}
+		taxonomy, err := strconv.Atoi(versions[1])
+		if err != nil || taxonomy < 1 || taxonomy > 10 {
+			continue
+		}
+
+		data = append(data, openrtb.Data{
+			Name:    topicsDataName,
+			Segment: segments,
+			Ext:     []byte(fmt.Sprintf(`{"segtax":%d,"segclass":%q}`, 599+taxonomy, versions[2])),
+		})
+	}
+	return data
+}
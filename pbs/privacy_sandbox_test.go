@@ -0,0 +1,82 @@
+package pbs
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func TestReadCookieDeprecation(t *testing.T) {
+	r := httptest.NewRequest("POST", "/auction", nil)
+	r.Header.Set(CookieDeprecationHeader, "label_only_1")
+	device := &openrtb.Device{Ext: []byte(`{"atts":1}`)}
+
+	label, err := readCookieDeprecation(r, device)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if label != "label_only_1" {
+		t.Errorf("Bad label: %s", label)
+	}
+	if string(device.Ext) != `{"atts":1,"cdep":"label_only_1"}` {
+		t.Errorf("Bad device.ext: %s", string(device.Ext))
+	}
+}
+
+func TestReadCookieDeprecationKeepsRequestLabel(t *testing.T) {
+	r := httptest.NewRequest("POST", "/auction", nil)
+	r.Header.Set(CookieDeprecationHeader, "from_header")
+	device := &openrtb.Device{Ext: []byte(`{"cdep":"from_request"}`)}
+
+	label, err := readCookieDeprecation(r, device)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if label != "from_request" || string(device.Ext) != `{"cdep":"from_request"}` {
+		t.Errorf("The label in the request should win. Got %s, %s", label, string(device.Ext))
+	}
+}
+
+func TestReadCookieDeprecationNoHeader(t *testing.T) {
+	device := &openrtb.Device{}
+	label, err := readCookieDeprecation(httptest.NewRequest("POST", "/auction", nil), device)
+	if err != nil || label != "" || device.Ext != nil {
+		t.Errorf("Nothing should change without the header. Got %s, %s, %v", label, string(device.Ext), err)
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	data := parseTopics(`(1 2 x);v=chrome.1:1:2, (7);v="chrome.1:2:4", ();p=P0000000000, (3);v=chrome.1:99:1, garbage`)
+	if len(data) != 2 {
+		t.Fatalf("Expected 2 data entries. Got %d", len(data))
+	}
+	if len(data[0].Segment) != 2 || data[0].Segment[0].ID != "1" || data[0].Segment[1].ID != "2" {
+		t.Errorf("Bad segments: %v", data[0].Segment)
+	}
+	if string(data[0].Ext) != `{"segtax":600,"segclass":"2"}` {
+		t.Errorf("Bad ext: %s", string(data[0].Ext))
+	}
+	if string(data[1].Ext) != `{"segtax":601,"segclass":"4"}` || data[1].Segment[0].ID != "7" {
+		t.Errorf("Bad second entry: %v", data[1])
+	}
+}
+
+func TestReadTopicsOptOut(t *testing.T) {
+	r := httptest.NewRequest("POST", "/auction", nil)
+	r.Header.Set(TopicsHeader, "(1);v=chrome.1:1:2")
+
+	user := &openrtb.User{}
+	readTopics(r, user, NewPBSCookie())
+	if len(user.Data) != 1 {
+		t.Errorf("Expected the topics to be added. Got %v", user.Data)
+	}
+
+	optedOut := NewPBSCookie()
+	optedOut.SetPreference(false)
+	user = &openrtb.User{}
+	readTopics(r, user, optedOut)
+	if len(user.Data) != 0 {
+		t.Errorf("Topics shouldn't be forwarded for opted out users. Got %v", user.Data)
+	}
+}
@@ -0,0 +1,55 @@
+package pbs
+
+import "encoding/json"
+
+// SChainVersion is the version of the IAB supply chain spec which these objects implement.
+const SChainVersion = "1.0"
+
+// SupplyChain describes every entity which was involved in selling the impression.
+// For more information, see https://github.com/InteractiveAdvertisingBureau/openrtb/blob/master/supplychainobject.md
+type SupplyChain struct {
+	// Complete is 1 if the chain contains every node back to the owner of the site or app, and 0 otherwise.
+	Complete int               `json:"complete"`
+	Ver      string            `json:"ver"`
+	Nodes    []SupplyChainNode `json:"nodes"`
+	Ext      json.RawMessage   `json:"ext,omitempty"`
+}
+
+// SupplyChainNode is a single entity in the SupplyChain.
+type SupplyChainNode struct {
+	ASI    string `json:"asi"`
+	SID    string `json:"sid"`
+	RID    string `json:"rid,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// HP is 1 if this node is paid for the impression. It should always be 1 for nodes which this server adds.
+	HP  int             `json:"hp"`
+	Ext json.RawMessage `json:"ext,omitempty"`
+}
+
+// NewHostSupplyChain returns the chain for a request which arrived without one. It only contains
+// the host's node, so it's marked incomplete.
+func NewHostSupplyChain(host SupplyChainNode) *SupplyChain {
+	host.HP = 1
+	return &SupplyChain{
+		Complete: 0,
+		Ver:      SChainVersion,
+		Nodes:    []SupplyChainNode{host},
+	}
+}
+
+// SupplyChain returns the supply chain from the request ext, or nil if none was sent.
+func (req *PBSRequest) SupplyChain() *SupplyChain {
+	if req.Ext == nil {
+		return nil
+	}
+	return req.Ext.SChain
+}
+
+// SetSupplyChain replaces the request's supply chain.
+func (req *PBSRequest) SetSupplyChain(chain *SupplyChain) {
+	if req.Ext == nil {
+		req.Ext = &PBSRequestExt{}
+	}
+	req.Ext.SChain = chain
+}
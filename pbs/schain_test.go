@@ -0,0 +1,35 @@
+package pbs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHostSupplyChain(t *testing.T) {
+	chain := NewHostSupplyChain(SupplyChainNode{ASI: "pbshost.com", SID: "1001"})
+	if chain.Complete != 0 || chain.Ver != SChainVersion || len(chain.Nodes) != 1 {
+		t.Fatalf("Expected an incomplete chain with one node. Got %#v", chain)
+	}
+	if chain.Nodes[0].HP != 1 || chain.Nodes[0].SID != "1001" {
+		t.Errorf("The host node should be marked as paid. Got %#v", chain.Nodes[0])
+	}
+}
+
+func TestRequestSupplyChain(t *testing.T) {
+	var req PBSRequest
+	if err := json.Unmarshal([]byte(`{"ext":{"schain":{"complete":1,"ver":"1.0","nodes":[{"asi":"publisher.com","sid":"abc","hp":1}]}}}`), &req); err != nil {
+		t.Fatalf("Bad request: %v", err)
+	}
+	if chain := req.SupplyChain(); chain == nil || chain.Complete != 1 || chain.Nodes[0].ASI != "publisher.com" {
+		t.Errorf("The request's chain should be parsed. Got %#v", chain)
+	}
+
+	var empty PBSRequest
+	if empty.SupplyChain() != nil {
+		t.Errorf("Requests without an ext shouldn't have a chain")
+	}
+	empty.SetSupplyChain(NewHostSupplyChain(SupplyChainNode{ASI: "pbshost.com"}))
+	if empty.SupplyChain() == nil {
+		t.Errorf("The chain should be set")
+	}
+}
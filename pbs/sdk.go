@@ -0,0 +1,64 @@
+package pbs
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+// SDKRenderer describes a renderer which the Prebid Mobile SDK can display ads with.
+type SDKRenderer struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// HasRenderer returns true if the SDK declared a renderer with this name.
+func (sdk *SDK) HasRenderer(name string) bool {
+	if sdk == nil {
+		return false
+	}
+	for _, renderer := range sdk.Renderers {
+		if renderer.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type appExt struct {
+	Prebid struct {
+		Source  string `json:"source"`
+		Version string `json:"version"`
+	} `json:"prebid"`
+}
+
+// normalizeSDK tidies up the SDK fields which Prebid Mobile sent.
+//
+// Newer SDKs identify themselves in app.ext.prebid instead of the sdk object, so those values are used to fill
+// in any blanks. Renderers without a name are dropped, since bidders would have no way to pick them.
+func normalizeSDK(sdk *SDK, app *openrtb.App) {
+	if app != nil && len(app.Ext) > 0 {
+		var ext appExt
+		if err := json.Unmarshal(app.Ext, &ext); err == nil {
+			if sdk.Source == "" {
+				sdk.Source = ext.Prebid.Source
+			}
+			if sdk.Version == "" {
+				sdk.Version = ext.Prebid.Version
+			}
+		}
+	}
+	sdk.Version = strings.TrimSpace(sdk.Version)
+	sdk.Source = strings.TrimSpace(sdk.Source)
+	sdk.Platform = strings.TrimSpace(sdk.Platform)
+
+	renderers := sdk.Renderers[:0]
+	for _, renderer := range sdk.Renderers {
+		if renderer.Name != "" {
+			renderers = append(renderers, renderer)
+		}
+	}
+	sdk.Renderers = renderers
+}
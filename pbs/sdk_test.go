@@ -0,0 +1,30 @@
+package pbs
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+)
+
+func TestNormalizeSDKFromAppExt(t *testing.T) {
+	sdk := &SDK{Platform: " iOS "}
+	normalizeSDK(sdk, &openrtb.App{Ext: []byte(`{"prebid":{"source":"prebid-mobile","version":"2.1.0"}}`)})
+	if sdk.Source != "prebid-mobile" || sdk.Version != "2.1.0" || sdk.Platform != "iOS" {
+		t.Errorf("Bad SDK: %v", sdk)
+	}
+}
+
+func TestNormalizeSDKKeepsExplicitValues(t *testing.T) {
+	sdk := &SDK{
+		Source:    "custom",
+		Version:   "1.0.0",
+		Renderers: []SDKRenderer{{Name: "MraidRenderer", Version: "1.0"}, {Version: "2.0"}},
+	}
+	normalizeSDK(sdk, &openrtb.App{Ext: []byte(`{"prebid":{"source":"prebid-mobile","version":"2.1.0"}}`)})
+	if sdk.Source != "custom" || sdk.Version != "1.0.0" {
+		t.Errorf("The sdk object should win over app.ext.prebid. Got %v", sdk)
+	}
+	if len(sdk.Renderers) != 1 || !sdk.HasRenderer("MraidRenderer") {
+		t.Errorf("Renderers without a name should be dropped. Got %v", sdk.Renderers)
+	}
+}
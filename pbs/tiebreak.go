@@ -0,0 +1,119 @@
+package pbs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+// These are the ways in which a TieBreaker can pick the winner from bids with the same price.
+const (
+	// TieBreakEarliestResponse prefers the bid which came back first. This is the default.
+	TieBreakEarliestResponse = "earliest_response"
+	// TieBreakRandom picks one of the tied bids at random.
+	TieBreakRandom = "random"
+	// TieBreakDealFirst prefers bids with a deal, and then the earliest response.
+	TieBreakDealFirst = "deal_first"
+	// TieBreakBidderPriority prefers bidders which come earlier in the configured priority list,
+	// and then the earliest response. Bidders which aren't listed come after those which are.
+	TieBreakBidderPriority = "bidder_priority"
+)
+
+// TieBreakBid is what a TieBreaker needs to know about a bid.
+type TieBreakBid struct {
+	Price  float64
+	Bidder string
+	DealID string
+	// ResponseTime is how long the bidder took to respond, in milliseconds.
+	ResponseTime int
+}
+
+// TieBreaker picks the winning bid for an ad unit. A nil TieBreaker uses TieBreakEarliestResponse.
+type TieBreaker struct {
+	mode     string
+	priority map[string]int
+}
+
+// NewTieBreaker returns a TieBreaker for the mode. An empty mode is TieBreakEarliestResponse.
+// The bidder priority is only used by TieBreakBidderPriority, and lists bidder codes with the most preferred first.
+func NewTieBreaker(mode string, bidderPriority []string) (*TieBreaker, error) {
+	switch mode {
+	case "":
+		mode = TieBreakEarliestResponse
+	case TieBreakEarliestResponse, TieBreakRandom, TieBreakDealFirst, TieBreakBidderPriority:
+	default:
+		return nil, fmt.Errorf("unknown tie break mode %q", mode)
+	}
+	if mode == TieBreakBidderPriority && len(bidderPriority) == 0 {
+		return nil, fmt.Errorf("tie break mode %q needs a bidder priority list", mode)
+	}
+	priority := make(map[string]int, len(bidderPriority))
+	for i, bidder := range bidderPriority {
+		if _, ok := priority[bidder]; !ok {
+			priority[bidder] = i
+		}
+	}
+	return &TieBreaker{mode: mode, priority: priority}, nil
+}
+
+// Winner returns the index of the winning bid, or -1 if there are none. The winner always has the highest price.
+//
+// If the seed isn't empty, then the same bids and seed always get the same winner, even in TieBreakRandom mode.
+// Auctions in test mode should pass a seed, so that their results can be compared.
+func (t *TieBreaker) Winner(bids []TieBreakBid, seed string) int {
+	if len(bids) == 0 {
+		return -1
+	}
+	var tied []int
+	for i, bid := range bids {
+		if len(tied) == 0 || bid.Price > bids[tied[0]].Price {
+			tied = append(tied[:0], i)
+		} else if bid.Price == bids[tied[0]].Price {
+			tied = append(tied, i)
+		}
+	}
+	if len(tied) == 1 {
+		return tied[0]
+	}
+
+	mode := TieBreakEarliestResponse
+	if t != nil {
+		mode = t.mode
+	}
+	// Bidder codes settle whatever the mode leaves tied, so that the winner doesn't depend on the order of the bids.
+	sort.SliceStable(tied, func(i, j int) bool {
+		a, b := bids[tied[i]], bids[tied[j]]
+		switch mode {
+		case TieBreakDealFirst:
+			if hasDealA, hasDealB := a.DealID != "", b.DealID != ""; hasDealA != hasDealB {
+				return hasDealA
+			}
+		case TieBreakBidderPriority:
+			if rankA, rankB := t.rank(a.Bidder), t.rank(b.Bidder); rankA != rankB {
+				return rankA < rankB
+			}
+		}
+		if mode != TieBreakRandom && a.ResponseTime != b.ResponseTime {
+			return a.ResponseTime < b.ResponseTime
+		}
+		return a.Bidder < b.Bidder
+	})
+
+	if mode != TieBreakRandom {
+		return tied[0]
+	}
+	if seed == "" {
+		return tied[rand.Intn(len(tied))]
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(seed))
+	return tied[int(hash.Sum32()%uint32(len(tied)))]
+}
+
+func (t *TieBreaker) rank(bidder string) int {
+	if rank, ok := t.priority[bidder]; ok {
+		return rank
+	}
+	return len(t.priority)
+}
@@ -0,0 +1,76 @@
+package pbs
+
+import "testing"
+
+func TestNewTieBreaker(t *testing.T) {
+	if _, err := NewTieBreaker("coin_flip", nil); err == nil {
+		t.Error("Unknown modes should be rejected")
+	}
+	if _, err := NewTieBreaker(TieBreakBidderPriority, nil); err == nil {
+		t.Error("Bidder priority mode should need a priority list")
+	}
+	if tieBreaker, err := NewTieBreaker("", nil); err != nil || tieBreaker.mode != TieBreakEarliestResponse {
+		t.Errorf("The default mode should be %s. Got %v, %v", TieBreakEarliestResponse, tieBreaker, err)
+	}
+}
+
+func TestTieBreakerWinner(t *testing.T) {
+	bids := []TieBreakBid{
+		{Price: 1.0, Bidder: "cheap", ResponseTime: 1},
+		{Price: 2.0, Bidder: "rubicon", ResponseTime: 80},
+		{Price: 2.0, Bidder: "appnexus", ResponseTime: 120, DealID: "deal"},
+		{Price: 2.0, Bidder: "pubmatic", ResponseTime: 40},
+		{Price: 2.0, Bidder: "index", ResponseTime: 40},
+	}
+	tests := []struct {
+		mode     string
+		priority []string
+		expected string
+	}{
+		{TieBreakEarliestResponse, nil, "index"},
+		{TieBreakDealFirst, nil, "appnexus"},
+		{TieBreakBidderPriority, []string{"cheap", "rubicon", "appnexus"}, "rubicon"},
+		{TieBreakBidderPriority, []string{"unknown"}, "index"},
+	}
+	for _, test := range tests {
+		tieBreaker, err := NewTieBreaker(test.mode, test.priority)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if winner := tieBreaker.Winner(bids, ""); bids[winner].Bidder != test.expected {
+			t.Errorf("%s %v: expected %s to win. Got %s", test.mode, test.priority, test.expected, bids[winner].Bidder)
+		}
+	}
+
+	var nilTieBreaker *TieBreaker
+	if winner := nilTieBreaker.Winner(bids, ""); bids[winner].Bidder != "index" {
+		t.Errorf("A nil TieBreaker should prefer the earliest response. Got %s", bids[winner].Bidder)
+	}
+	if winner := nilTieBreaker.Winner(nil, ""); winner != -1 {
+		t.Errorf("Expected -1 without any bids. Got %d", winner)
+	}
+}
+
+func TestRandomTieBreakIsSeeded(t *testing.T) {
+	tieBreaker, _ := NewTieBreaker(TieBreakRandom, nil)
+	bids := []TieBreakBid{
+		{Price: 2.0, Bidder: "rubicon"},
+		{Price: 2.0, Bidder: "appnexus"},
+		{Price: 1.0, Bidder: "cheap"},
+	}
+	reversed := []TieBreakBid{bids[2], bids[1], bids[0]}
+	seen := make(map[string]bool)
+	for _, seed := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		winner := bids[tieBreaker.Winner(bids, seed)].Bidder
+		if winner == "cheap" {
+			t.Fatal("Random tie breaks should only pick from the highest bids")
+		}
+		if again := reversed[tieBreaker.Winner(reversed, seed)].Bidder; again != winner {
+			t.Errorf("Seed %s picked %s, and then %s for the same bids in another order", seed, winner, again)
+		}
+		seen[winner] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Expected different seeds to pick different winners. Got %v", seen)
+	}
+}
@@ -0,0 +1,117 @@
+package pbs
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResolveTrackerURL fills in the macros in one of an account's tracker URLs. {{account}} is the account ID,
+// {{bidder}} the bidder code, and {{timestamp}} the time of the auction, in milliseconds since the epoch.
+// Values are query-escaped.
+func ResolveTrackerURL(template string, accountID string, bidder string, timestamp time.Time) string {
+	return strings.NewReplacer(
+		"{{account}}", url.QueryEscape(accountID),
+		"{{bidder}}", url.QueryEscape(bidder),
+		"{{timestamp}}", strconv.FormatInt(timestamp.UnixNano()/int64(time.Millisecond), 10),
+	).Replace(template)
+}
+
+// EventURL returns the tracker URL for the /event endpoint of the Prebid Server at the externalURL. Like an
+// account's tracker URLs, it needs ResolveTrackerURL.
+func EventURL(externalURL string) string {
+	return strings.TrimSuffix(externalURL, "/") + "/event?t=imp&a={{account}}&bidder={{bidder}}&ts={{timestamp}}"
+}
+
+// InjectTracker adds an impression tracker for the URL to the bid's markup, so that it fires when the ad renders.
+// Banners get a hidden pixel, native ads an eventtracker, and VAST an Impression. It returns false if the bid
+// has no markup which a tracker could be added to, like bids whose markup is fetched from their NURL.
+func InjectTracker(bid *PBSBid, trackerURL string) bool {
+	if bid.Adm == "" || trackerURL == "" {
+		return false
+	}
+	switch bid.CreativeMediaType {
+	case "video":
+		return injectVASTTracker(bid, trackerURL)
+	case "native":
+		return injectNativeTracker(bid, trackerURL)
+	}
+	bid.Adm += `<img src="` + html.EscapeString(trackerURL) + `" width="1" height="1" style="display:none" alt="" />`
+	return true
+}
+
+// injectVASTTracker adds an Impression to the first InLine or Wrapper ad. They may have several, so it goes first.
+func injectVASTTracker(bid *PBSBid, trackerURL string) bool {
+	for _, tag := range []string{"<InLine>", "<Wrapper>"} {
+		if i := strings.Index(bid.Adm, tag); i >= 0 {
+			end := i + len(tag)
+			bid.Adm = bid.Adm[:end] + "<Impression><![CDATA[" + trackerURL + "]]></Impression>" + bid.Adm[end:]
+			return true
+		}
+	}
+	return false
+}
+
+// injectNativeTracker adds an image eventtracker for impressions to the native markup, since renderers of
+// version 1.2 of the spec may only fire those. Markup which uses imptrackers, or has no eventtrackers, also gets
+// an imptracker for older renderers. Markup in the {"native": ...} wrapper keeps it.
+func injectNativeTracker(bid *PBSBid, trackerURL string) bool {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(bid.Adm), &wrapper); err != nil {
+		return false
+	}
+	native := wrapper
+	inner, wrapped := wrapper["native"]
+	if wrapped {
+		native = nil
+		if err := json.Unmarshal(inner, &native); err != nil {
+			return false
+		}
+	}
+
+	rawImpTrackers, usesImpTrackers := native["imptrackers"]
+	rawEventTrackers, usesEventTrackers := native["eventtrackers"]
+	if usesImpTrackers || !usesEventTrackers {
+		var impTrackers []string
+		if usesImpTrackers {
+			if err := json.Unmarshal(rawImpTrackers, &impTrackers); err != nil {
+				return false
+			}
+		}
+		var err error
+		if native["imptrackers"], err = json.Marshal(append(impTrackers, trackerURL)); err != nil {
+			return false
+		}
+	}
+
+	var eventTrackers []json.RawMessage
+	if usesEventTrackers {
+		if err := json.Unmarshal(rawEventTrackers, &eventTrackers); err != nil {
+			return false
+		}
+	}
+	tracker, err := json.Marshal(NativeEventTrackerResponse{Event: 1, Method: 1, URL: trackerURL})
+	if err != nil {
+		return false
+	}
+	if native["eventtrackers"], err = json.Marshal(append(eventTrackers, tracker)); err != nil {
+		return false
+	}
+
+	if wrapped {
+		rawNative, err := json.Marshal(native)
+		if err != nil {
+			return false
+		}
+		wrapper["native"] = rawNative
+	}
+	adm, err := json.Marshal(wrapper)
+	if err != nil {
+		return false
+	}
+	bid.Adm = string(adm)
+	return true
+}
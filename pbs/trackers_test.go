@@ -0,0 +1,107 @@
+package pbs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveTrackerURL(t *testing.T) {
+	timestamp := time.Unix(1500000000, 123000000)
+	resolved := ResolveTrackerURL("https://events.example.com/imp?a={{account}}&b={{bidder}}&t={{timestamp}}", "pub 1", "appnexus", timestamp)
+	if resolved != "https://events.example.com/imp?a=pub+1&b=appnexus&t=1500000000123" {
+		t.Errorf("Bad tracker URL: %s", resolved)
+	}
+}
+
+func TestEventURL(t *testing.T) {
+	trackerURL := ResolveTrackerURL(EventURL("https://pbs.example.com/"), "1001", "appnexus", time.Unix(1500000000, 0))
+	if trackerURL != "https://pbs.example.com/event?t=imp&a=1001&bidder=appnexus&ts=1500000000000" {
+		t.Errorf("Unexpected event URL %s", trackerURL)
+	}
+}
+
+func TestInjectBannerTracker(t *testing.T) {
+	bid := &PBSBid{CreativeMediaType: "banner", Adm: "<div>ad</div>"}
+	if !InjectTracker(bid, "https://events.example.com/imp?a=1&b=2") {
+		t.Fatal("Expected a tracker to be added to the banner")
+	}
+	if !strings.HasPrefix(bid.Adm, "<div>ad</div><img ") || !strings.Contains(bid.Adm, `src="https://events.example.com/imp?a=1&amp;b=2"`) {
+		t.Errorf("Bad banner markup: %s", bid.Adm)
+	}
+
+	nurlBid := &PBSBid{CreativeMediaType: "banner", NURL: "https://bidder.example.com/win"}
+	if InjectTracker(nurlBid, "https://events.example.com/imp") {
+		t.Error("Bids without markup can't get trackers")
+	}
+}
+
+func TestInjectVideoTracker(t *testing.T) {
+	bid := &PBSBid{CreativeMediaType: "video", Adm: `<VAST version="3.0"><Ad><InLine><AdSystem>x</AdSystem></InLine></Ad></VAST>`}
+	if !InjectTracker(bid, "https://events.example.com/imp") {
+		t.Fatal("Expected a tracker to be added to the VAST")
+	}
+	if !strings.Contains(bid.Adm, "<InLine><Impression><![CDATA[https://events.example.com/imp]]></Impression><AdSystem>") {
+		t.Errorf("Bad VAST: %s", bid.Adm)
+	}
+
+	if InjectTracker(&PBSBid{CreativeMediaType: "video", Adm: "<VAST></VAST>"}, "https://events.example.com/imp") {
+		t.Error("VAST without an ad can't get trackers")
+	}
+}
+
+func TestInjectNativeTracker(t *testing.T) {
+	bid := &PBSBid{CreativeMediaType: "native", Adm: `{"native":{"link":{"url":"http://example.com"},"imptrackers":["https://bidder.example.com/imp"],"eventtrackers":[]}}`}
+	if !InjectTracker(bid, "https://events.example.com/imp") {
+		t.Fatal("Expected a tracker to be added to the native markup")
+	}
+	var adm struct {
+		Native NativeResponse `json:"native"`
+	}
+	if err := json.Unmarshal([]byte(bid.Adm), &adm); err != nil {
+		t.Fatalf("Bad native markup: %v", err)
+	}
+	if trackers := adm.Native.ImpTrackers; len(trackers) != 2 || trackers[1] != "https://events.example.com/imp" {
+		t.Errorf("Expected the tracker to be added to the imptrackers. Got %v", trackers)
+	}
+	if trackers := adm.Native.EventTrackers; len(trackers) != 1 || trackers[0].Event != 1 || trackers[0].Method != 1 {
+		t.Errorf("Expected an impression image eventtracker. Got %v", trackers)
+	}
+	if adm.Native.Link == nil || adm.Native.Link.URL != "http://example.com" {
+		t.Errorf("The rest of the markup should be kept. Got %s", bid.Adm)
+	}
+
+	// Version 1.2 markup without imptrackers only gets an eventtracker, so the impression isn't counted twice.
+	bid = &PBSBid{CreativeMediaType: "native", Adm: `{"link":{"url":"http://example.com"},"eventtrackers":[{"event":1,"method":1,"url":"https://bidder.example.com/imp"}]}`}
+	if !InjectTracker(bid, "https://events.example.com/imp") {
+		t.Fatal("Expected a tracker to be added to the native markup")
+	}
+	var unwrapped NativeResponse
+	if err := json.Unmarshal([]byte(bid.Adm), &unwrapped); err != nil {
+		t.Fatalf("Bad native markup: %v", err)
+	}
+	if len(unwrapped.ImpTrackers) != 0 {
+		t.Errorf("Markup with only eventtrackers shouldn't get imptrackers. Got %v", unwrapped.ImpTrackers)
+	}
+	if trackers := unwrapped.EventTrackers; len(trackers) != 2 || trackers[1].URL != "https://events.example.com/imp" {
+		t.Errorf("Expected the tracker to be added to the eventtrackers. Got %v", trackers)
+	}
+
+	// Markup with neither gets both.
+	bid = &PBSBid{CreativeMediaType: "native", Adm: `{"link":{"url":"http://example.com"}}`}
+	if !InjectTracker(bid, "https://events.example.com/imp") {
+		t.Fatal("Expected a tracker to be added to the native markup")
+	}
+	unwrapped = NativeResponse{}
+	if err := json.Unmarshal([]byte(bid.Adm), &unwrapped); err != nil {
+		t.Fatalf("Bad native markup: %v", err)
+	}
+	if len(unwrapped.ImpTrackers) != 1 || len(unwrapped.EventTrackers) != 1 || unwrapped.EventTrackers[0].URL != "https://events.example.com/imp" {
+		t.Errorf("Expected an imptracker and an eventtracker. Got %s", bid.Adm)
+	}
+
+	if InjectTracker(&PBSBid{CreativeMediaType: "native", Adm: "<div></div>"}, "https://events.example.com/imp") {
+		t.Error("Invalid native markup can't get trackers")
+	}
+}
@@ -2,7 +2,6 @@ package pbs
 
 import (
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -29,9 +28,11 @@ const DEFAULT_TTL = 14 * 24 * time.Hour
 var customBidderTTLs = map[string]time.Duration{}
 
 const (
-	USERSYNC_OPT_OUT     = "usersync.opt_outs"
-	USERSYNC_BAD_REQUEST = "usersync.bad_requests"
-	USERSYNC_SUCCESS     = "usersync.%s.sets"
+	USERSYNC_OPT_OUT         = "usersync.opt_outs"
+	USERSYNC_NO_COOKIE       = "usersync.no_cookie"
+	USERSYNC_BAD_REQUEST     = "usersync.bad_requests"
+	USERSYNC_ACTIVITY_DENIED = "usersync.activity_denied"
+	USERSYNC_SUCCESS         = "usersync.%s.sets"
 )
 
 // PBSCookie is the cookie used in Prebid Server.
@@ -42,14 +43,140 @@ type PBSCookie struct {
 	uids     map[string]uidWithExpiry
 	optOut   bool
 	birthday *time.Time
+	// settings decide when the IDs which TrySync sets are due for a refresh. They're nil for cookies which
+	// weren't parsed with ParsePBSCookieFromRequest, which use refreshTTL.
+	settings *HostCookieSettings
 }
 
 type HostCookieSettings struct {
-	Domain     string
-	Family     string
-	CookieName string
-	OptOutURL  string
-	OptInURL   string
+	Domain            string
+	Family            string
+	CookieName        string
+	OptOutURL         string
+	OptInURL          string
+	OptOutCookieName  string
+	OptOutCookieValue string
+	// TTL is how long the uids cookie lasts. If zero, it lasts uidsCookieTTL.
+	TTL time.Duration
+	// JavaCompatible writes the uids cookie with a JavaCompatibleEncoder.
+	JavaCompatible bool
+	// UIDs is how long each bidder family's ID lives in the uids cookie. FamilyUIDs overrides it for some
+	// families, keyed by family name. Their zero fields fall back to UIDs.
+	UIDs       UIDPolicy
+	FamilyUIDs map[string]UIDPolicy
+}
+
+// UIDPolicy decides when a bidder family's ID in the uids cookie is refreshed, and when it's dropped.
+type UIDPolicy struct {
+	// Refresh is how long after a sync the ID gets synced again. It's still used until the new one arrives.
+	// If zero, it's DEFAULT_TTL.
+	Refresh time.Duration
+	// MaxAge is how long after a sync the ID is dropped from the cookie, if it hasn't been synced again since.
+	// If zero, IDs are kept until they're replaced.
+	MaxAge time.Duration
+}
+
+// uidPolicy returns the UIDPolicy for the family, with its defaults filled in.
+func (settings *HostCookieSettings) uidPolicy(familyName string) UIDPolicy {
+	var policy UIDPolicy
+	if settings != nil {
+		policy = settings.UIDs
+		if family, ok := settings.FamilyUIDs[familyName]; ok {
+			if family.Refresh > 0 {
+				policy.Refresh = family.Refresh
+			}
+			if family.MaxAge > 0 {
+				policy.MaxAge = family.MaxAge
+			}
+		}
+	}
+	if policy.Refresh <= 0 {
+		policy.Refresh = refreshTTL(familyName)
+	}
+	return policy
+}
+
+// dropStaleUIDs removes the IDs which are older than their family's MaxAge.
+func (settings *HostCookieSettings) dropStaleUIDs(cookie *PBSCookie) {
+	now := time.Now()
+	for familyName, uid := range cookie.uids {
+		policy := settings.uidPolicy(familyName)
+		if policy.MaxAge <= 0 {
+			continue
+		}
+		// IDs from cookies which don't store when they were synced are assumed to have been synced with
+		// the family's current Refresh.
+		synced := uid.Expires.Add(-policy.Refresh)
+		if uid.Synced != nil {
+			synced = *uid.Synced
+		}
+		if now.After(synced.Add(policy.MaxAge)) {
+			delete(cookie.uids, familyName)
+		}
+	}
+}
+
+// SetUIDsCookie writes the uids cookie onto the response, in the format and with the lifetime which the host wants.
+func (settings *HostCookieSettings) SetUIDsCookie(w http.ResponseWriter, cookie *PBSCookie) {
+	if settings == nil {
+		cookie.SetCookieOnResponse(w, "")
+		return
+	}
+	var encoder CookieEncoder = Base64Encoder{}
+	if settings.JavaCompatible {
+		encoder = JavaCompatibleEncoder{}
+	}
+	httpCookie := cookie.toHTTPCookie(encoder, settings.TTL)
+	if settings.Domain != "" {
+		httpCookie.Domain = settings.Domain
+	}
+	http.SetCookie(w, httpCookie)
+}
+
+// IsOptedOut returns true if the request has the host's opt-out cookie.
+func (settings *HostCookieSettings) IsOptedOut(r *http.Request) bool {
+	if settings == nil || settings.OptOutCookieName == "" {
+		return false
+	}
+	cookie, err := r.Cookie(settings.OptOutCookieName)
+	if err != nil {
+		return false
+	}
+	return settings.OptOutCookieValue == "" || cookie.Value == settings.OptOutCookieValue
+}
+
+// optOutCookieTTL is how long the host's opt-out cookie lasts.
+const optOutCookieTTL = 5 * 365 * 24 * time.Hour
+
+// setOptOutCookie sets or clears the host's opt-out cookie, if the host has one.
+func (settings *HostCookieSettings) setOptOutCookie(w http.ResponseWriter, optOut bool) {
+	if settings == nil || settings.OptOutCookieName == "" {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:   settings.OptOutCookieName,
+		Value:  settings.OptOutCookieValue,
+		Domain: settings.Domain,
+		Path:   "/",
+	}
+	if cookie.Value == "" {
+		cookie.Value = "1"
+	}
+	if optOut {
+		cookie.Expires = time.Now().Add(optOutCookieTTL)
+	} else {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// SyncsImpossible returns true if we can tell that no cookie sync will ever work for this browser.
+// This happens if it has the host's opt-out cookie, and has never stored any uids.
+func (settings *HostCookieSettings) SyncsImpossible(r *http.Request) bool {
+	if _, err := r.Cookie(COOKIE_NAME); err == nil {
+		return false
+	}
+	return settings.IsOptedOut(r)
 }
 
 // uidWithExpiry bundles the UID with an Expiration date.
@@ -59,6 +186,9 @@ type uidWithExpiry struct {
 	UID string `json:"uid"`
 	// Expires is the time at which this UID should no longer apply.
 	Expires time.Time `json:"expires"`
+	// Synced is when the UID was set. Cookies written before it was added, or by a JavaCompatibleEncoder,
+	// don't have it.
+	Synced *time.Time `json:"synced,omitempty"`
 }
 
 type UserSyncDeps struct {
@@ -68,32 +198,40 @@ type UserSyncDeps struct {
 	OptInUrl           string
 	HostCookieSettings *HostCookieSettings
 	Metrics            metrics.Registry
+	// SyncAllowed returns false if the account's activity controls deny syncing the bidder family.
+	// If it's nil, every sync is allowed.
+	SyncAllowed func(account string, familyName string) bool
 }
 
 // ParsePBSCookieFromRequest parses the UserSyncMap from an HTTP Request.
-func ParsePBSCookieFromRequest(r *http.Request) *PBSCookie {
+//
+// If the request has the host's opt-out cookie, the uids are never read, and the returned cookie is opted out.
+// IDs which are older than their family's UIDPolicy allows are dropped, and the IDs which TrySync sets are
+// refreshed when their family's UIDPolicy says.
+func ParsePBSCookieFromRequest(r *http.Request, settings *HostCookieSettings) *PBSCookie {
+	if settings.IsOptedOut(r) {
+		pc := NewPBSCookie()
+		pc.SetPreference(false)
+		pc.settings = settings
+		return pc
+	}
+
 	cookie, err := r.Cookie(COOKIE_NAME)
 	if err != nil {
-		return NewPBSCookie()
+		pc := NewPBSCookie()
+		pc.settings = settings
+		return pc
 	}
 
-	return ParsePBSCookie(cookie)
+	pc := ParsePBSCookie(cookie)
+	settings.dropStaleUIDs(pc)
+	pc.settings = settings
+	return pc
 }
 
 // ParsePBSCookie parses the UserSync cookie from a raw HTTP cookie.
 func ParsePBSCookie(cookie *http.Cookie) *PBSCookie {
-	pc := NewPBSCookie()
-
-	j, err := base64.URLEncoding.DecodeString(cookie.Value)
-	if err != nil {
-		// corrupted cookie; we should reset
-		return pc
-	}
-	err = json.Unmarshal(j, pc)
-
-	// The error on Unmarshal here isn't terribly important.
-	// If the cookie has been corrupted, we should reset to an empty one anyway.
-	return pc
+	return Base64Decoder{}.Decode(cookie.Value)
 }
 
 // NewPBSCookie returns an empty PBSCookie
@@ -123,15 +261,24 @@ func (cookie *PBSCookie) SetPreference(allow bool) {
 	}
 }
 
+// uidsCookieTTL is how long the uids cookie lasts, unless the host says otherwise.
+const uidsCookieTTL = 180 * 24 * time.Hour
+
 // Gets an HTTP cookie containing all the data from this UserSyncMap. This is a snapshot--not a live view.
 func (cookie *PBSCookie) ToHTTPCookie() *http.Cookie {
-	j, _ := json.Marshal(cookie)
-	b64 := base64.URLEncoding.EncodeToString(j)
+	return cookie.toHTTPCookie(Base64Encoder{}, uidsCookieTTL)
+}
+
+func (cookie *PBSCookie) toHTTPCookie(encoder CookieEncoder, ttl time.Duration) *http.Cookie {
+	if ttl <= 0 {
+		ttl = uidsCookieTTL
+	}
+	value, _ := encoder.Encode(cookie)
 
 	return &http.Cookie{
 		Name:    COOKIE_NAME,
-		Value:   b64,
-		Expires: time.Now().Add(180 * 24 * time.Hour),
+		Value:   value,
+		Expires: time.Now().Add(ttl),
 	}
 }
 
@@ -185,6 +332,8 @@ func (cookie *PBSCookie) LiveSyncCount() int {
 }
 
 // TrySync tries to set the UID for some family name. It returns an error if the set didn't happen.
+// The UID is due for a refresh when the family's UIDPolicy says, in the host's settings which the cookie was
+// parsed with.
 func (cookie *PBSCookie) TrySync(familyName string, uid string) error {
 	if !cookie.AllowSyncs() {
 		return errors.New("The user has opted out of prebid server PBSCookie syncs.")
@@ -196,9 +345,11 @@ func (cookie *PBSCookie) TrySync(familyName string, uid string) error {
 		return errors.New("audienceNetwork uses a UID of 0 as \"not yet recognized\".")
 	}
 
+	now := time.Now()
 	cookie.uids[familyName] = uidWithExpiry{
 		UID:     uid,
-		Expires: getExpiry(familyName),
+		Expires: now.Add(cookie.settings.uidPolicy(familyName).Refresh),
+		Synced:  &now,
 	}
 
 	return nil
@@ -209,6 +360,7 @@ func (cookie *PBSCookie) TrySync(familyName string, uid string) error {
 // This exists so that PBSCookie (which is public) can have private fields, and the rest of
 // PBS doesn't have to worry about the cookie data storage format.
 type pbsCookieJson struct {
+	Version    int                      `json:"v,omitempty"`
 	LegacyUIDs map[string]string        `json:"uids,omitempty"`
 	UIDs       map[string]uidWithExpiry `json:"tempUIDs,omitempty"`
 	OptOut     bool                     `json:"optout,omitempty"`
@@ -217,20 +369,15 @@ type pbsCookieJson struct {
 
 func (cookie *PBSCookie) MarshalJSON() ([]byte, error) {
 	return json.Marshal(pbsCookieJson{
+		Version:  uidsCookieVersion,
 		UIDs:     cookie.uids,
 		OptOut:   cookie.optOut,
 		Birthday: cookie.birthday,
 	})
 }
 
-// UnmarshalJSON holds some transition code.
-//
-// "Legacy" cookies had UIDs *without* expiration dates, and recognized "0" as a legitimate UID for audienceNetwork.
-// "Current" cookies always include UIDs with expiration dates, and never allow "0" for audienceNetwork.
-//
-// This Unmarshal method interprets both data formats, and does some conversions on legacy data to make it current.
-// If you're seeing this message after March 2018, it's safe to assume that all the legacy cookies have been
-// updated and remove the legacy logic.
+// UnmarshalJSON reads every version of the cookie format, and migrates old ones to the current version.
+// The migrated cookie is written in the current format the next time it gets set on a response.
 func (cookie *PBSCookie) UnmarshalJSON(b []byte) error {
 	var cookieContract pbsCookieJson
 	err := json.Unmarshal(b, &cookieContract)
@@ -247,15 +394,8 @@ func (cookie *PBSCookie) UnmarshalJSON(b []byte) error {
 				cookie.uids = make(map[string]uidWithExpiry, len(cookieContract.LegacyUIDs))
 			}
 
-			// Interpret "legacy" UIDs as having been expired already.
-			// This should cause us to re-sync, since it would be time for a new one.
-			for bidder, uid := range cookieContract.LegacyUIDs {
-				if _, ok := cookie.uids[bidder]; !ok {
-					cookie.uids[bidder] = uidWithExpiry{
-						UID:     uid,
-						Expires: time.Now().Add(-5 * time.Minute),
-					}
-				}
+			if cookieContract.Version == 0 {
+				cookie.migrateVersion0(cookieContract.LegacyUIDs)
 			}
 
 			// Any "0" values from audienceNetwork really meant "no ID available." This happens if they've never
@@ -272,9 +412,25 @@ func (cookie *PBSCookie) UnmarshalJSON(b []byte) error {
 	return err
 }
 
+// migrateVersion0 converts the data from version 0 cookies.
+//
+// "Legacy" cookies had UIDs *without* expiration dates. "Current" cookies always include UIDs with expiration dates.
+func (cookie *PBSCookie) migrateVersion0(legacyUIDs map[string]string) {
+	// Interpret "legacy" UIDs as having been expired already.
+	// This should cause us to re-sync, since it would be time for a new one.
+	for bidder, uid := range legacyUIDs {
+		if _, ok := cookie.uids[bidder]; !ok {
+			cookie.uids[bidder] = uidWithExpiry{
+				UID:     uid,
+				Expires: time.Now().Add(-5 * time.Minute),
+			}
+		}
+	}
+}
+
 func (deps *UserSyncDeps) GetUIDs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	pc := ParsePBSCookieFromRequest(r)
-	pc.SetCookieOnResponse(w, deps.HostCookieSettings.Domain)
+	pc := ParsePBSCookieFromRequest(r, deps.HostCookieSettings)
+	deps.HostCookieSettings.SetUIDsCookie(w, pc)
 	json.NewEncoder(w).Encode(pc)
 	return
 }
@@ -294,7 +450,12 @@ func getRawQueryMap(query string) map[string]string {
 }
 
 func (deps *UserSyncDeps) SetUID(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	pc := ParsePBSCookieFromRequest(r)
+	if deps.HostCookieSettings.SyncsImpossible(r) {
+		metrics.GetOrRegisterMeter(USERSYNC_NO_COOKIE, deps.Metrics).Mark(1)
+		return
+	}
+
+	pc := ParsePBSCookieFromRequest(r, deps.HostCookieSettings)
 	if !pc.AllowSyncs() {
 		w.WriteHeader(http.StatusUnauthorized)
 		metrics.GetOrRegisterMeter(USERSYNC_OPT_OUT, deps.Metrics).Mark(1)
@@ -309,6 +470,12 @@ func (deps *UserSyncDeps) SetUID(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
+	if deps.SyncAllowed != nil && !deps.SyncAllowed(query["account"], bidder) {
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+		metrics.GetOrRegisterMeter(USERSYNC_ACTIVITY_DENIED, deps.Metrics).Mark(1)
+		return
+	}
+
 	uid := query["uid"]
 	var err error = nil
 	if uid == "" {
@@ -321,7 +488,7 @@ func (deps *UserSyncDeps) SetUID(w http.ResponseWriter, r *http.Request, _ httpr
 		metrics.GetOrRegisterMeter(fmt.Sprintf(USERSYNC_SUCCESS, bidder), deps.Metrics).Mark(1)
 	}
 
-	pc.SetCookieOnResponse(w, deps.HostCookieSettings.Domain)
+	deps.HostCookieSettings.SetUIDsCookie(w, pc)
 }
 
 // Struct for parsing json in google's response
@@ -354,8 +521,18 @@ func (deps *UserSyncDeps) VerifyRecaptcha(response string) error {
 	return nil
 }
 
+// OptOut handles the form from static/optout.html. Users who check the "optout" box get opted out.
+// Everyone else gets opted back in.
 func (deps *UserSyncDeps) OptOut(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	optout := r.FormValue("optout")
+	deps.setPreference(w, r, r.FormValue("optout") == "")
+}
+
+// OptIn lets users who opted out start syncing cookies again.
+func (deps *UserSyncDeps) OptIn(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deps.setPreference(w, r, true)
+}
+
+func (deps *UserSyncDeps) setPreference(w http.ResponseWriter, r *http.Request, allow bool) {
 	rr := r.FormValue("g-recaptcha-response")
 
 	if rr == "" {
@@ -372,24 +549,24 @@ func (deps *UserSyncDeps) OptOut(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
-	pc := ParsePBSCookieFromRequest(r)
-	pc.SetPreference(optout == "")
+	pc := ParsePBSCookieFromRequest(r, deps.HostCookieSettings)
+	pc.SetPreference(allow)
 
-	pc.SetCookieOnResponse(w, deps.HostCookieSettings.Domain)
-	if optout == "" {
+	deps.HostCookieSettings.SetUIDsCookie(w, pc)
+	deps.HostCookieSettings.setOptOutCookie(w, !allow)
+	if allow {
 		http.Redirect(w, r, deps.OptInUrl, 301)
 	} else {
 		http.Redirect(w, r, deps.OptOutUrl, 301)
 	}
 }
 
-// getExpiry gets an expiry date for the cookie, assuming it was generated right now.
-func getExpiry(familyName string) time.Time {
-	ttl := DEFAULT_TTL
+// refreshTTL is how long a UID for the family is valid, unless the host's UIDPolicy says otherwise.
+func refreshTTL(familyName string) time.Duration {
 	if customTTL, ok := customBidderTTLs[familyName]; ok {
-		ttl = customTTL
+		return customTTL
 	}
-	return time.Now().Add(ttl)
+	return DEFAULT_TTL
 }
 
 func timestamp() *time.Time {
@@ -0,0 +1,55 @@
+package pbs
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UsersyncPrivacy holds the privacy signals which a usersync URL template may reference.
+// Empty values resolve to empty strings.
+type UsersyncPrivacy struct {
+	GDPR        string
+	GDPRConsent string
+	USPrivacy   string
+	GPP         string
+	GPPSID      string
+}
+
+// UsersyncTemplate describes a bidder's usersync endpoint before any per-request values are known.
+//
+// URL may contain the {{redirect_url}} macro, which is replaced with the query-escaped RedirectURL.
+// Both URL and RedirectURL may contain the privacy macros: {{gdpr}}, {{gdpr_consent}}, {{us_privacy}},
+// {{gpp}} and {{gpp_sid}}.
+type UsersyncTemplate struct {
+	URL         string
+	RedirectURL string
+	Type        string
+	SupportCORS bool
+}
+
+// Resolve builds the UsersyncInfo which should be sent to a client with the given privacy signals.
+func (t *UsersyncTemplate) Resolve(privacy UsersyncPrivacy) *UsersyncInfo {
+	return &UsersyncInfo{
+		URL:         ResolveUsersyncMacros(t.URL, t.RedirectURL, privacy),
+		Type:        t.Type,
+		SupportCORS: t.SupportCORS,
+	}
+}
+
+// ResolveUsersyncMacros fills in the macros in a usersync URL template.
+//
+// Every value is query-escaped. The redirect URL has its own privacy macros resolved first, and is then
+// escaped as a whole so that it survives as a single query parameter of the outer URL.
+func ResolveUsersyncMacros(template string, redirectURL string, privacy UsersyncPrivacy) string {
+	if strings.Contains(template, "{{redirect_url}}") {
+		redirect := ResolveUsersyncMacros(redirectURL, "", privacy)
+		template = strings.Replace(template, "{{redirect_url}}", url.QueryEscape(redirect), -1)
+	}
+	return strings.NewReplacer(
+		"{{gdpr}}", url.QueryEscape(privacy.GDPR),
+		"{{gdpr_consent}}", url.QueryEscape(privacy.GDPRConsent),
+		"{{us_privacy}}", url.QueryEscape(privacy.USPrivacy),
+		"{{gpp}}", url.QueryEscape(privacy.GPP),
+		"{{gpp_sid}}", url.QueryEscape(privacy.GPPSID),
+	).Replace(template)
+}
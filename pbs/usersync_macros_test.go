@@ -0,0 +1,46 @@
+package pbs
+
+import (
+	"testing"
+)
+
+func TestResolveUsersyncMacros(t *testing.T) {
+	privacy := UsersyncPrivacy{
+		GDPR:        "1",
+		GDPRConsent: "BONV8oqONXwgmADACHENAO7pqzAAppY",
+		USPrivacy:   "1YN-",
+		GPP:         "DBACNY~CPXxRfAPXxRfAAfKABENB-CgAAAAAAAAAAYgAAAAAAAA",
+		GPPSID:      "2,6",
+	}
+	template := "//sync.test.com/sync?gdpr={{gdpr}}&consent={{gdpr_consent}}&us_privacy={{us_privacy}}&gpp={{gpp}}&gpp_sid={{gpp_sid}}"
+
+	resolved := ResolveUsersyncMacros(template, "", privacy)
+	expected := "//sync.test.com/sync?gdpr=1&consent=BONV8oqONXwgmADACHENAO7pqzAAppY&us_privacy=1YN-&gpp=DBACNY~CPXxRfAPXxRfAAfKABENB-CgAAAAAAAAAAYgAAAAAAAA&gpp_sid=2%2C6"
+	if resolved != expected {
+		t.Errorf("Bad resolved URL. Expected %s, got %s", expected, resolved)
+	}
+}
+
+func TestResolveUsersyncMacrosEmptyPrivacy(t *testing.T) {
+	resolved := ResolveUsersyncMacros("//sync.test.com/sync?gdpr={{gdpr}}&consent={{gdpr_consent}}", "", UsersyncPrivacy{})
+	if resolved != "//sync.test.com/sync?gdpr=&consent=" {
+		t.Errorf("Missing privacy values should resolve to empty strings. Got %s", resolved)
+	}
+}
+
+func TestResolveUsersyncMacrosRedirect(t *testing.T) {
+	template := &UsersyncTemplate{
+		URL:         "//sync.test.com/sync?gdpr={{gdpr}}&redir={{redirect_url}}",
+		RedirectURL: "http://localhost/setuid?bidder=test&gdpr={{gdpr}}&gdpr_consent={{gdpr_consent}}&uid=$UID",
+		Type:        "redirect",
+	}
+	info := template.Resolve(UsersyncPrivacy{GDPR: "1", GDPRConsent: "a b"})
+
+	expected := "//sync.test.com/sync?gdpr=1&redir=http%3A%2F%2Flocalhost%2Fsetuid%3Fbidder%3Dtest%26gdpr%3D1%26gdpr_consent%3Da%2Bb%26uid%3D%24UID"
+	if info.URL != expected {
+		t.Errorf("Bad resolved URL. Expected %s, got %s", expected, info.URL)
+	}
+	if info.Type != "redirect" {
+		t.Errorf("Usersync type should be preserved. Got %s", info.Type)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/rcrowley/go-metrics"
 )
 
 func TestOptOutCookie(t *testing.T) {
@@ -289,7 +291,7 @@ func writeThenRead(cookie *PBSCookie) *PBSCookie {
 	header := http.Header{}
 	header.Add("Cookie", writtenCookie)
 	request := http.Request{Header: header}
-	return ParsePBSCookieFromRequest(&request)
+	return ParsePBSCookieFromRequest(&request, nil)
 }
 
 func newTempId(uid string) uidWithExpiry {
@@ -298,3 +300,205 @@ func newTempId(uid string) uidWithExpiry {
 		Expires: time.Now().Add(10 * time.Minute),
 	}
 }
+
+func TestSyncsImpossible(t *testing.T) {
+	settings := &HostCookieSettings{
+		OptOutCookieName:  "optout",
+		OptOutCookieValue: "true",
+	}
+
+	req := httptest.NewRequest("GET", "/setuid?bidder=adnxs&uid=123", nil)
+	if settings.SyncsImpossible(req) {
+		t.Errorf("Requests without an opt-out cookie can sync")
+	}
+
+	req.AddCookie(&http.Cookie{Name: "optout", Value: "false"})
+	if settings.SyncsImpossible(req) {
+		t.Errorf("The opt-out cookie value should match the host setting")
+	}
+
+	req = httptest.NewRequest("GET", "/setuid?bidder=adnxs&uid=123", nil)
+	req.AddCookie(&http.Cookie{Name: "optout", Value: "true"})
+	if !settings.SyncsImpossible(req) {
+		t.Errorf("Requests with the opt-out cookie and no uids cookie can't sync")
+	}
+
+	req.AddCookie(NewPBSCookie().ToHTTPCookie())
+	if settings.SyncsImpossible(req) {
+		t.Errorf("Requests with a uids cookie should be handled normally")
+	}
+}
+
+func TestSetUIDNoCookie(t *testing.T) {
+	deps := &UserSyncDeps{
+		HostCookieSettings: &HostCookieSettings{OptOutCookieName: "optout"},
+		Metrics:            metrics.NewRegistry(),
+	}
+	req := httptest.NewRequest("GET", "/setuid?bidder=adnxs&uid=123", nil)
+	req.AddCookie(&http.Cookie{Name: "optout", Value: "1"})
+	rr := httptest.NewRecorder()
+	deps.SetUID(rr, req, nil)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a no-op 200. Got %d", rr.Code)
+	}
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Errorf("No cookie should be set for browsers which can't sync")
+	}
+	if metrics.GetOrRegisterMeter(USERSYNC_NO_COOKIE, deps.Metrics).Count() != 1 {
+		t.Errorf("The no cookie metric should be marked")
+	}
+}
+
+func TestSetUIDActivityDenied(t *testing.T) {
+	deps := &UserSyncDeps{
+		HostCookieSettings: &HostCookieSettings{},
+		Metrics:            metrics.NewRegistry(),
+		SyncAllowed: func(account string, familyName string) bool {
+			return account != "1001" || familyName != "adnxs"
+		},
+	}
+	rr := httptest.NewRecorder()
+	deps.SetUID(rr, httptest.NewRequest("GET", "/setuid?bidder=adnxs&uid=123&account=1001", nil), nil)
+	if rr.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("Expected a 451. Got %d", rr.Code)
+	}
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Errorf("No cookie should be set when the account denies the sync")
+	}
+	if metrics.GetOrRegisterMeter(USERSYNC_ACTIVITY_DENIED, deps.Metrics).Count() != 1 {
+		t.Errorf("The activity denied metric should be marked")
+	}
+
+	rr = httptest.NewRecorder()
+	deps.SetUID(rr, httptest.NewRequest("GET", "/setuid?bidder=adnxs&uid=123&account=1002", nil), nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Set-Cookie") == "" {
+		t.Errorf("Other accounts should be able to sync. Got %d", rr.Code)
+	}
+}
+
+func TestHostOptOutCookieIgnoresUIDs(t *testing.T) {
+	settings := &HostCookieSettings{OptOutCookieName: "optout", OptOutCookieValue: "true"}
+	existing := NewPBSCookie()
+	existing.TrySync("adnxs", "123")
+
+	req := httptest.NewRequest("GET", "/getuids", nil)
+	req.AddCookie(existing.ToHTTPCookie())
+	req.AddCookie(&http.Cookie{Name: "optout", Value: "true"})
+
+	parsed := ParsePBSCookieFromRequest(req, settings)
+	if parsed.AllowSyncs() {
+		t.Errorf("Users with the host opt-out cookie should be opted out")
+	}
+	if parsed.LiveSyncCount() != 0 {
+		t.Errorf("UIDs shouldn't be read for users with the host opt-out cookie")
+	}
+}
+
+func TestSetOptOutCookie(t *testing.T) {
+	settings := &HostCookieSettings{Domain: "prebid.org", OptOutCookieName: "optout"}
+
+	w := httptest.NewRecorder()
+	settings.setOptOutCookie(w, true)
+	cookie := w.Header().Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatalf("The opt-out cookie should be set")
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Add("Cookie", cookie)
+	if !settings.IsOptedOut(req) {
+		t.Errorf("The cookie which was set should opt the user out")
+	}
+
+	w = httptest.NewRecorder()
+	settings.setOptOutCookie(w, false)
+	resp := http.Response{Header: w.Header()}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Opting in should delete the opt-out cookie")
+	}
+}
+
+func TestSetUIDsCookieJavaCompatible(t *testing.T) {
+	settings := &HostCookieSettings{Domain: "prebid.org", TTL: 90 * 24 * time.Hour, JavaCompatible: true}
+	cookie := NewPBSCookie()
+	cookie.TrySync("adnxs", "123")
+
+	w := httptest.NewRecorder()
+	settings.SetUIDsCookie(w, cookie)
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Domain != "prebid.org" {
+		t.Fatalf("Expected one uids cookie on the host's domain. Got %v", cookies)
+	}
+	if ttl := time.Until(cookies[0].Expires); ttl < 89*24*time.Hour || ttl > 91*24*time.Hour {
+		t.Errorf("The cookie should last for the host's TTL. Got %v", ttl)
+	}
+	j, _ := base64.URLEncoding.DecodeString(cookies[0].Value)
+	var contract map[string]json.RawMessage
+	json.Unmarshal(j, &contract)
+	if _, ok := contract["v"]; ok {
+		t.Errorf("Java compatible cookies shouldn't have a version. Got %s", j)
+	}
+	if decoded := ParsePBSCookie(cookies[0]); !decoded.HasLiveSync("adnxs") {
+		t.Errorf("Java compatible cookies should still be readable")
+	}
+}
+
+func TestUIDPolicy(t *testing.T) {
+	day := 24 * time.Hour
+	settings := &HostCookieSettings{
+		UIDs:       UIDPolicy{Refresh: 7 * day, MaxAge: 30 * day},
+		FamilyUIDs: map[string]UIDPolicy{"adnxs": {MaxAge: 60 * day}, "rubicon": {Refresh: 2 * day}},
+	}
+	deps := &UserSyncDeps{HostCookieSettings: settings, Metrics: metrics.NewRegistry()}
+	rr := httptest.NewRecorder()
+	deps.SetUID(rr, httptest.NewRequest("GET", "/setuid?bidder=rubicon&uid=123", nil), nil)
+	synced := ParsePBSCookie((&http.Response{Header: rr.Header()}).Cookies()[0])
+	if ttl := time.Until(synced.uids["rubicon"].Expires); ttl < 47*time.Hour || ttl > 49*time.Hour {
+		t.Errorf("rubicon's ID should be refreshed after its family's 2 days. Got %v", ttl)
+	}
+
+	if synced := synced.uids["rubicon"].Synced; synced == nil || time.Since(*synced) > time.Minute {
+		t.Errorf("The cookie should store when rubicon's ID was synced. Got %v", synced)
+	}
+
+	// IDs without a sync time have their age worked out from when they're due for a refresh.
+	now := time.Now()
+	longAgo := now.Add(-40 * day)
+	recently := now.Add(-10 * day)
+	cookie := NewPBSCookie()
+	cookie.uids["pulsepoint-synced"] = uidWithExpiry{UID: "refreshed late", Expires: now.Add(-25 * day), Synced: &recently}
+	cookie.uids["sovrn"] = uidWithExpiry{UID: "refresh extended", Expires: now.Add(5 * day), Synced: &longAgo}
+	cookie.uids["pubmatic"] = uidWithExpiry{UID: "fresh", Expires: now.Add(5 * day)}
+	cookie.uids["pulsepoint"] = uidWithExpiry{UID: "due", Expires: now.Add(-20 * day)}
+	cookie.uids["appnexus"] = uidWithExpiry{UID: "old", Expires: now.Add(-25 * day)}
+	cookie.uids["adnxs"] = uidWithExpiry{UID: "old", Expires: now.Add(-25 * day)}
+	cookie.uids["rubicon"] = uidWithExpiry{UID: "old", Expires: now.Add(-29 * day)}
+	req := httptest.NewRequest("GET", "/getuids", nil)
+	req.AddCookie(cookie.ToHTTPCookie())
+
+	parsed := ParsePBSCookieFromRequest(req, settings)
+	for family, kept := range map[string]bool{"pubmatic": true, "pulsepoint": true, "appnexus": false, "adnxs": true, "rubicon": false, "pulsepoint-synced": true, "sovrn": false} {
+		if _, ok, _ := parsed.GetUID(family); ok != kept {
+			t.Errorf("Expected %s's ID to be kept: %t", family, kept)
+		}
+	}
+	if _, _, live := parsed.GetUID("pulsepoint"); live {
+		t.Errorf("IDs which are due for a refresh should be synced again")
+	}
+
+	if parsed := ParsePBSCookieFromRequest(req, nil); parsed.LiveSyncCount() != 2 || len(parsed.uids) != 7 {
+		t.Errorf("Without a policy, IDs should be kept until they're replaced. Got %v", parsed.uids)
+	}
+
+	// Cookies parsed from a request refresh the IDs which TrySync sets with the host's policy.
+	parsed.TrySync("rubicon", "456")
+	if ttl := time.Until(parsed.uids["rubicon"].Expires); ttl < 47*time.Hour || ttl > 49*time.Hour {
+		t.Errorf("rubicon's ID should be refreshed after its family's 2 days. Got %v", ttl)
+	}
+	fresh := NewPBSCookie()
+	fresh.TrySync("rubicon", "456")
+	if ttl := time.Until(fresh.uids["rubicon"].Expires); ttl < DEFAULT_TTL-time.Hour {
+		t.Errorf("Cookies without the host's settings should use the default refresh. Got %v", ttl)
+	}
+}
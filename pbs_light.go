@@ -1,24 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	_ "net/http/pprof"
+	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/cloudfoundry/gosigar"
 	"github.com/golang/glog"
 	"github.com/julienschmidt/httprouter"
 	"github.com/mssola/user_agent"
+	"github.com/mxmCherry/openrtb"
 	"github.com/rcrowley/go-metrics"
 	"github.com/rs/cors"
 	"github.com/spf13/viper"
@@ -31,20 +41,40 @@ import (
 
 	"github.com/prebid/prebid-server/adapters"
 	"github.com/prebid/prebid-server/adapters/appnexus"
+	"github.com/prebid/prebid-server/adapters/conversant"
+	"github.com/prebid/prebid-server/adapters/debugbidder"
 	"github.com/prebid/prebid-server/adapters/facebook"
 	"github.com/prebid/prebid-server/adapters/index"
 	"github.com/prebid/prebid-server/adapters/lifestreet"
 	"github.com/prebid/prebid-server/adapters/pubmatic"
 	"github.com/prebid/prebid-server/adapters/pulsepoint"
 	"github.com/prebid/prebid-server/adapters/rubicon"
+	"github.com/prebid/prebid-server/adstxt"
+	"github.com/prebid/prebid-server/analytics"
+	analyticsConf "github.com/prebid/prebid-server/analytics/config"
+	"github.com/prebid/prebid-server/blocklist"
 	"github.com/prebid/prebid-server/cache"
 	"github.com/prebid/prebid-server/cache/dummycache"
 	"github.com/prebid/prebid-server/cache/filecache"
 	"github.com/prebid/prebid-server/cache/postgrescache"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/currency"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/experiments"
+	"github.com/prebid/prebid-server/gdpr"
+	"github.com/prebid/prebid-server/identity"
+	"github.com/prebid/prebid-server/ivt"
+	"github.com/prebid/prebid-server/mirror"
+	"github.com/prebid/prebid-server/nobidcache"
 	"github.com/prebid/prebid-server/pbs"
 	"github.com/prebid/prebid-server/prebid"
 	pbc "github.com/prebid/prebid-server/prebid_cache_client"
+	"github.com/prebid/prebid-server/priceencryption"
+	"github.com/prebid/prebid-server/quarantine"
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/prebid/prebid-server/remotefile"
+	"github.com/prebid/prebid-server/throttle"
 )
 
 type DomainMetrics struct {
@@ -55,19 +85,70 @@ type AccountMetrics struct {
 	RequestMeter      metrics.Meter
 	BidsReceivedMeter metrics.Meter
 	PriceHistogram    metrics.Histogram
+	// CacheErrorMeter counts the auctions whose bids couldn't be written to Prebid Cache.
+	CacheErrorMeter metrics.Meter
+	// ImpressionEventMeter counts the calls to /event for the account's impressions.
+	ImpressionEventMeter metrics.Meter
 	// store account by adapter metrics. Type is map[PBSBidder.BidderCode]
 	AdapterMetrics map[string]*AdapterMetrics
 }
 
 type AdapterMetrics struct {
 	NoCookieMeter     metrics.Meter
+	ThrottledMeter    metrics.Meter
 	ErrorMeter        metrics.Meter
+	BadResponseMeter  metrics.Meter
+	PanicMeter        metrics.Meter
 	NoBidMeter        metrics.Meter
 	TimeoutMeter      metrics.Meter
 	RequestMeter      metrics.Meter
 	RequestTimer      metrics.Timer
 	PriceHistogram    metrics.Histogram
 	BidsReceivedMeter metrics.Meter
+
+	// CookieDeprecationMeter counts requests from browsers in Chrome's cookie deprecation test groups.
+	CookieDeprecationMeter metrics.Meter
+
+	// These break down each HTTP call to the bidder. They're only tracked per adapter, not per account.
+	DNSTimer     metrics.Timer
+	ConnectTimer metrics.Timer
+	TTFBTimer    metrics.Timer
+	RetryMeter   metrics.Meter
+
+	// These count moves to and from the bidder's secondary endpoint. They're only tracked per adapter.
+	FailoverMeter metrics.Meter
+	RecoveryMeter metrics.Meter
+
+	// NoBidCacheMeter counts the ad units which weren't sent to the bidder, because it declined them recently.
+	NoBidCacheMeter metrics.Meter
+
+	// RejectedBidMeters count the bids which were dropped after the bidder returned them, keyed by the
+	// analytics reason. They're only tracked per adapter.
+	RejectedBidMeters map[string]metrics.Meter
+}
+
+// recordCall is an adapters.CallHook which tracks the timing of each HTTP call to the bidder.
+func (a *AdapterMetrics) recordCall(timing *adapters.CallTiming, err error) {
+	if !timing.ReusedConn {
+		a.DNSTimer.Update(timing.DNS)
+		a.ConnectTimer.Update(timing.Connect)
+	}
+	if err == nil {
+		a.TTFBTimer.Update(timing.TTFB)
+	}
+	if timing.Retried {
+		a.RetryMeter.Mark(1)
+	}
+}
+
+// CookieSyncMetrics follow a bidder through the /cookie_sync funnel. Completed syncs are
+// counted by the /setuid endpoint, under usersync.{family}.sets.
+type CookieSyncMetrics struct {
+	RequestedMeter       metrics.Meter
+	PrivacyFilteredMeter metrics.Meter
+	SyncedFilteredMeter  metrics.Meter
+	LimitFilteredMeter   metrics.Meter
+	ReturnedMeter        metrics.Meter
 }
 
 var (
@@ -81,26 +162,79 @@ var (
 	mInvalidMeter        metrics.Meter
 	mRequestTimer        metrics.Timer
 	mCookieSyncMeter     metrics.Meter
-
-	adapterMetrics map[string]*AdapterMetrics
+	mCookieSyncNoCookie  metrics.Meter
+	mCookieDeprecation   metrics.Meter
+	mCOPPAMeter          metrics.Meter
+	mEarlyReturnMeter    metrics.Meter
+	mLateBidsMeter       metrics.Meter
+	mMinWinningCPMMeter  metrics.Meter
+	mIdentityTimer       metrics.Timer
+	mIdentityErrorMeter  metrics.Meter
+	mDebugOverrideMeter  metrics.Meter
+	mOpenRTBRequestMeter metrics.Meter
+	mOpenRTBInvalidMeter metrics.Meter
+	mAMPRequestMeter     metrics.Meter
+	mAMPInvalidMeter     metrics.Meter
+	mVideoRequestMeter   metrics.Meter
+	mVideoInvalidMeter   metrics.Meter
+	mCacheErrorMeter     metrics.Meter
+	mBidderExtTrimMeter  metrics.Meter
+	mImpEventMeter       metrics.Meter
+
+	adapterMetrics    map[string]*AdapterMetrics
+	cookieSyncMetrics map[string]*CookieSyncMetrics
 
 	accountMetrics        map[string]*AccountMetrics // FIXME -- this seems like an unbounded queue
 	accountMetricsRWMutex sync.RWMutex
 
 	hostCookieSettings pbs.HostCookieSettings
+
+	// refreshers keep background data like currency rates up to date. Their freshness is reported by /status.
+	refreshers []*refresher.Refresher
 )
 
 var exchanges map[string]adapters.Adapter
+
+// batchedBidders holds the (lowercase) codes of bidders whose ad units are batched by params. See adapters.CallBatched.
+var batchedBidders map[string]bool
+
+// shadowBidders holds the (lowercase) codes of bidders in shadow mode. Their bids are logged, but never returned.
+var shadowBidders map[string]bool
+var testBidders map[string]bool
+
+// eidLimits holds the EID limits of bidders which have them, by lowercase code.
+var eidLimits map[string]*pbs.EIDLimits
+
+// stripFields holds the request fields which each bidder must not get, by lowercase code.
+var stripFields map[string][]string
+
+// bidderAliases maps the codes of the aliases in the host config onto the bidders they alias.
+// Aliases are also in exchanges, so that they get their own metrics and settings.
+var bidderAliases map[string]string
 var dataCache cache.Cache
 var reqSchema *gojsonschema.Schema
 
+// tieBreaker picks the winner when an ad unit's top bids have the same price. Until serve sets it up,
+// the earliest response wins.
+var tieBreaker *pbs.TieBreaker
+
+// paramsValidator checks bidder params against the schemas in static/bidder-params. Until serve sets it up,
+// params aren't checked.
+var paramsValidator *adapters.ParamsValidator
+
 type bidResult struct {
 	bidder   *pbs.PBSBidder
 	bid_list pbs.PBSBidSlice
+	errs     []error
+	// allBids is every bid from the bidder, before any were rejected.
+	allBids  pbs.PBSBidSlice
+	rejected []analytics.RejectedBid
 }
 
 const schemaDirectory = "./static/bidder-params"
 
+const infoDirectory = "./static/bidder-info"
+
 const defaultPriceGranularity = "med"
 
 // Constant keys for ad server targeting for responses to Prebid Mobile
@@ -108,7 +242,10 @@ const hbpbConstantKey = "hb_pb"
 const hbCreativeLoadMethodConstantKey = "hb_creative_loadtype"
 const hbBidderConstantKey = "hb_bidder"
 const hbCacheIdConstantKey = "hb_cache_id"
+const hbCacheHostConstantKey = "hb_cache_host"
+const hbCachePathConstantKey = "hb_cache_path"
 const hbSizeConstantKey = "hb_size"
+const hbDealConstantKey = "hb_deal"
 
 // hb_creative_loadtype key can be one of `demand_sdk` or `html`
 // default is `html` where the creative is loaded in the primary ad server's webview through AppNexus hosted JS
@@ -123,7 +260,9 @@ func min(x, y int) int {
 	return y
 }
 
-func writeAuctionError(w http.ResponseWriter, s string, err error) {
+// writeAuctionError responds to an /auction request which failed. The status code is written too, and
+// should match the auction object's Status.
+func writeAuctionError(w http.ResponseWriter, status int, s string, err error) {
 	var resp pbs.PBSResponse
 	if err != nil {
 		resp.Status = fmt.Sprintf("%s: %v", s, err)
@@ -133,7 +272,9 @@ func writeAuctionError(w http.ResponseWriter, s string, err error) {
 	b, err := json.Marshal(&resp)
 	if err != nil {
 		glog.Errorf("Failed to marshal auction error JSON: %s", err)
+		w.WriteHeader(status)
 	} else {
+		w.WriteHeader(status)
 		w.Write(b)
 	}
 }
@@ -157,6 +298,8 @@ func getAccountMetrics(id string) *AccountMetrics {
 		am.RequestMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.requests", id), metricsRegistry)
 		am.BidsReceivedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.bids_received", id), metricsRegistry)
 		am.PriceHistogram = metrics.GetOrRegisterHistogram(fmt.Sprintf("account.%s.prices", id), metricsRegistry, metrics.NewExpDecaySample(1028, 0.015))
+		am.CacheErrorMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.cache_errors", id), metricsRegistry)
+		am.ImpressionEventMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.impression_events", id), metricsRegistry)
 		am.AdapterMetrics = makeExchangeMetrics(fmt.Sprintf("account.%s", id))
 		accountMetrics[id] = am
 	}
@@ -166,8 +309,30 @@ func getAccountMetrics(id string) *AccountMetrics {
 }
 
 type cookieSyncRequest struct {
-	UUID    string   `json:"uuid"`
-	Bidders []string `json:"bidders"`
+	UUID        string   `json:"uuid"`
+	Bidders     []string `json:"bidders"`
+	Limit       int      `json:"limit"`
+	GDPR        *int     `json:"gdpr"`
+	GDPRConsent string   `json:"gdpr_consent"`
+	USPrivacy   string   `json:"us_privacy"`
+	GPP         string   `json:"gpp"`
+	GPPSID      string   `json:"gpp_sid"`
+	// Account is the publisher's account ID, whose activity controls decide which bidders may sync.
+	Account string `json:"account"`
+}
+
+// privacy returns the signals which should be substituted into the bidders' usersync URLs.
+func (req *cookieSyncRequest) privacy() pbs.UsersyncPrivacy {
+	privacy := pbs.UsersyncPrivacy{
+		GDPRConsent: req.GDPRConsent,
+		USPrivacy:   req.USPrivacy,
+		GPP:         req.GPP,
+		GPPSID:      req.GPPSID,
+	}
+	if req.GDPR != nil {
+		privacy.GDPR = strconv.Itoa(*req.GDPR)
+	}
+	return privacy
 }
 
 type cookieSyncResponse struct {
@@ -176,11 +341,44 @@ type cookieSyncResponse struct {
 	BidderStatus []*pbs.PBSBidder `json:"bidder_status"`
 }
 
-func cookieSync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+type cookieSyncDeps struct {
+	cfg      *config.CookieSync
+	accounts func(id string) config.Account
+}
+
+// prioritizeBidders orders the bidders by priority group. Bidders keep their requested order within
+// a group, and bidders outside every group go last.
+func prioritizeBidders(bidders []string, groups [][]string) []string {
+	if len(groups) == 0 {
+		return bidders
+	}
+	ranks := make(map[string]int)
+	for i, group := range groups {
+		for _, bidder := range group {
+			if _, ok := ranks[bidder]; !ok {
+				ranks[bidder] = i
+			}
+		}
+	}
+	rank := func(bidder string) int {
+		if r, ok := ranks[bidder]; ok {
+			return r
+		}
+		return len(groups)
+	}
+	prioritized := append([]string(nil), bidders...)
+	sort.SliceStable(prioritized, func(i, j int) bool {
+		return rank(prioritized[i]) < rank(prioritized[j])
+	})
+	return prioritized
+}
+
+func (deps *cookieSyncDeps) cookieSync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	mCookieSyncMeter.Mark(1)
-	userSyncCookie := pbs.ParsePBSCookieFromRequest(r)
-	if !userSyncCookie.AllowSyncs() {
-		http.Error(w, "User has opted out", http.StatusUnauthorized)
+	if hostCookieSettings.SyncsImpossible(r) {
+		mCookieSyncNoCookie.Mark(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"no_cookie","bidder_status":[]}`))
 		return
 	}
 
@@ -188,6 +386,19 @@ func cookieSync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	csReq := &cookieSyncRequest{}
 	err := json.NewDecoder(r.Body).Decode(&csReq)
+
+	userSyncCookie := pbs.ParsePBSCookieFromRequest(r, &hostCookieSettings)
+	if !userSyncCookie.AllowSyncs() {
+		for _, bidder := range csReq.Bidders {
+			if syncMetrics, ok := cookieSyncMetrics[bidder]; ok {
+				syncMetrics.RequestedMeter.Mark(1)
+				syncMetrics.PrivacyFilteredMeter.Mark(1)
+			}
+		}
+		http.Error(w, "User has opted out", http.StatusUnauthorized)
+		return
+	}
+
 	if err != nil {
 		if glog.V(2) {
 			glog.Infof("Failed to parse /cookie_sync request body: %v", err)
@@ -207,16 +418,42 @@ func cookieSync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		csResp.Status = "ok"
 	}
 
-	for _, bidder := range csReq.Bidders {
+	var country string
+	if deps.cfg.CountryHeader != "" {
+		country = r.Header.Get(deps.cfg.CountryHeader)
+	}
+	account := deps.accounts(csReq.Account)
+	families := make(map[string]bool, len(csReq.Bidders))
+	for _, bidder := range prioritizeBidders(csReq.Bidders, deps.cfg.PriorityGroupsFor(country)) {
 		if ex, ok := exchanges[bidder]; ok {
-			if !userSyncCookie.HasLiveSync(ex.FamilyName()) {
-				b := pbs.PBSBidder{
-					BidderCode:   bidder,
-					NoCookie:     true,
-					UsersyncInfo: ex.GetUsersyncInfo(),
-				}
-				csResp.BidderStatus = append(csResp.BidderStatus, &b)
+			syncMetrics := cookieSyncMetrics[bidder]
+			syncMetrics.RequestedMeter.Mark(1)
+			if !account.ActivityAllowed(config.ActivitySyncUser, "", bidder, ex.FamilyName()) {
+				syncMetrics.PrivacyFilteredMeter.Mark(1)
+				continue
+			}
+			if userSyncCookie.HasLiveSync(ex.FamilyName()) {
+				syncMetrics.SyncedFilteredMeter.Mark(1)
+				continue
+			}
+			usersyncInfo := ex.GetUsersyncInfo(csReq.privacy())
+			if usersyncInfo == nil || families[ex.FamilyName()] {
+				// Some bidders, like the debug bidder, have no users to sync. Aliases share their
+				// bidder's family, so each family is only synced once.
+				continue
+			}
+			if csReq.Limit > 0 && len(csResp.BidderStatus) >= csReq.Limit {
+				syncMetrics.LimitFilteredMeter.Mark(1)
+				continue
 			}
+			b := pbs.PBSBidder{
+				BidderCode:   bidder,
+				NoCookie:     true,
+				UsersyncInfo: usersyncInfo,
+			}
+			csResp.BidderStatus = append(csResp.BidderStatus, &b)
+			families[ex.FamilyName()] = true
+			syncMetrics.ReturnedMeter.Mark(1)
 		}
 	}
 
@@ -227,7 +464,21 @@ func cookieSync(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 }
 
 type auctionDeps struct {
-	cfg *config.Configuration
+	cfg         *config.Configuration
+	analytics   analytics.PBSAnalyticsModule
+	experiments *experiments.Experiments
+	throttles   map[string]*throttle.Throttle
+	quarantine  *quarantine.Log
+	noBids      *nobidcache.Cache
+	identity    identity.Resolver
+
+	priceEncrypters   map[string]pbs.PriceEncrypter
+	currencyConverter *currency.Converter
+	ivt               *ivt.Filter
+	mirror            *mirror.Mirror
+	blocklist         *blocklist.Blocklist
+	gdpr              *gdpr.Enforcer
+	registry          *adapters.BidderRegistry
 }
 
 func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -235,6 +486,28 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 
 	mRequestMeter.Mark(1)
 
+	ao := &analytics.AuctionObject{
+		Status: http.StatusOK,
+	}
+	// If bidders are still running when the response is sent, the auction is logged once they finish.
+	// Shadow bidders are never waited for, so they're always collected this way.
+	var lateBids, shadowBids *lateBidCollector
+	defer func() {
+		if lateBids == nil && shadowBids == nil {
+			deps.analytics.LogAuctionObject(ao)
+			return
+		}
+		go func() {
+			if lateBids != nil {
+				ao.LateBids = lateBids.Collect()
+			}
+			if shadowBids != nil {
+				ao.ShadowBids = shadowBids.Collect()
+			}
+			deps.analytics.LogAuctionObject(ao)
+		}()
+	}()
+
 	isSafari := false
 	if ua := user_agent.New(r.Header.Get("User-Agent")); ua != nil {
 		name, _ := ua.Browser()
@@ -244,19 +517,66 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 		}
 	}
 
-	pbs_req, err := pbs.ParsePBSRequest(r, dataCache, &hostCookieSettings)
+	if deps.mirror.Sampled() {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeAuctionError(w, http.StatusBadRequest, "Error reading request", err)
+			mErrorMeter.Mark(1)
+			ao.Status = http.StatusBadRequest
+			ao.Errors = append(ao.Errors, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		deps.mirror.Send(r, body)
+	}
+
+	configCache := measureConfigs(dataCache, deps.cfg.DataCache.Type, "auction")
+	pbs_req, err := pbs.ParsePBSRequest(r, configCache, &hostCookieSettings)
 	if err != nil {
 		if glog.V(2) {
 			glog.Infof("Failed to parse /auction request: %v", err)
 		}
-		writeAuctionError(w, "Error parsing request", err)
+		writeAuctionError(w, http.StatusBadRequest, "Error parsing request", err)
+		mErrorMeter.Mark(1)
+		ao.Status = http.StatusBadRequest
+		ao.Errors = append(ao.Errors, err)
+		return
+	}
+	ao.Request = pbs_req
+
+	if err := validateAliases(pbs_req.Aliases()); err != nil {
+		writeAuctionError(w, http.StatusBadRequest, "Invalid aliases", err)
 		mErrorMeter.Mark(1)
+		ao.Status = http.StatusBadRequest
+		ao.Errors = append(ao.Errors, err)
 		return
 	}
 
+	if pbs_req.App != nil {
+		if configID := deps.cfg.GetAppDefaults(pbs_req.AccountID, pbs_req.App.Bundle).ConfigID; configID != "" {
+			pbs_req.UseDefaultConfig(configCache, configID)
+		}
+	}
+
+	priceGranularity := ""
+	for _, assignment := range deps.experiments.Assign(pbs_req) {
+		assignment.Apply(pbs_req)
+		if assignment.Bucket.PriceGranularity != "" {
+			priceGranularity = assignment.Bucket.PriceGranularity
+		}
+		if ao.Experiments == nil {
+			ao.Experiments = make(map[string]string)
+		}
+		ao.Experiments[assignment.Experiment] = assignment.Bucket.Name
+	}
+	// Bidders get their floors in USD, like the reserves in their params.
+	ao.Errors = append(ao.Errors, pbs_req.ConvertFloors(deps.currencyConverter.Convert)...)
+
 	status := "OK"
 	if pbs_req.App != nil {
 		mAppRequestMeter.Mark(1)
+		metrics.GetOrRegisterMeter(sdkMetricName(pbs_req.SDK), metricsRegistry).Mark(1)
 	} else if pbs_req.Cookie.LiveSyncCount() == 0 {
 		mNoCookieMeter.Mark(1)
 		if isSafari {
@@ -264,42 +584,221 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 		}
 		status = "no_cookie"
 	}
+	if pbs_req.CookieDeprecation != "" {
+		mCookieDeprecation.Mark(1)
+	}
+	// Every bidder gets an anonymized request. See gdpr.Enforcer.Restrictions.
+	if gdpr.COPPAApplies(pbs_req.Regs) {
+		mCOPPAMeter.Mark(1)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(pbs_req.TimeoutMillis))
 	defer cancel()
 
+	// If late bids are captured, bidders get a grace period past tmax. Their bids can't make it into
+	// the response, but analytics can still see what they would have been.
+	bidCtx := ctx
+	if deps.cfg.LateBidGrace > 0 {
+		var cancelBids context.CancelFunc
+		bidCtx, cancelBids = context.WithTimeout(context.Background(), time.Millisecond*time.Duration(pbs_req.TimeoutMillis+int64(deps.cfg.LateBidGrace)))
+		defer func() {
+			if lateBids == nil {
+				cancelBids()
+			} else {
+				lateBids.cancel = cancelBids
+			}
+		}()
+	}
+
 	account, err := dataCache.Accounts().Get(pbs_req.AccountID)
 	if err != nil {
 		if glog.V(2) {
 			glog.Infof("Invalid account id: %v", err)
 		}
-		writeAuctionError(w, "Unknown account id", fmt.Errorf("Unknown account"))
+		writeAuctionError(w, http.StatusBadRequest, "Unknown account id", fmt.Errorf("Unknown account"))
 		mErrorMeter.Mark(1)
+		ao.Status = http.StatusBadRequest
+		ao.Errors = append(ao.Errors, err)
 		return
 	}
 
+	if priceGranularity == "" {
+		priceGranularity = account.PriceGranularity
+	}
+
 	am := getAccountMetrics(pbs_req.AccountID)
 	am.RequestMeter.Mark(1)
+	accountConfig := deps.cfg.GetAccount(pbs_req.AccountID)
+
+	if hasDebugOverride(r, deps.cfg.DebugOverrideToken) {
+		if glog.V(2) {
+			glog.Infof("Debug override for account %s, tid %s", pbs_req.AccountID, pbs_req.Tid)
+		}
+		mDebugOverrideMeter.Mark(1)
+		pbs_req.IsDebug = true
+	} else if accountConfig.DisableDebug {
+		pbs_req.IsDebug = false
+	}
+
+	channel := pbs_req.ChannelName()
+	metrics.GetOrRegisterMeter(fmt.Sprintf("channels.%s.requests", channelMetricName(channel)), metricsRegistry).Mark(1)
+	if !accountConfig.ChannelEnabled(channel) {
+		err := fmt.Errorf("account %s doesn't accept %s requests", pbs_req.AccountID, channel)
+		writeAuctionError(w, http.StatusBadRequest, "Channel disabled", err)
+		mErrorMeter.Mark(1)
+		ao.Status = http.StatusBadRequest
+		ao.Errors = append(ao.Errors, err)
+		return
+	}
+
+	if deps.cfg.DomainCheck.Mode != "" && pbs_req.App == nil {
+		if reason := pbs.CheckDomain(pbs_req.Domain, r.Header.Get("Origin"), accountConfig.Domains); reason != "" {
+			metrics.GetOrRegisterMeter(fmt.Sprintf("domain_check.%s", reason), metricsRegistry).Mark(1)
+			if deps.cfg.DomainCheck.Mode == "reject" {
+				if glog.V(2) {
+					glog.Infof("Rejected request for account %s on %s: %s", pbs_req.AccountID, pbs_req.Url, reason)
+				}
+				err := fmt.Errorf("the page's domain %s failed the %s check", pbs_req.Domain, reason)
+				writeAuctionError(w, http.StatusBadRequest, "Invalid domain", err)
+				mErrorMeter.Mark(1)
+				ao.Status = http.StatusBadRequest
+				ao.Errors = append(ao.Errors, err)
+				return
+			}
+		}
+	}
+
+	deviceIP := pbs_req.Device.IP
+	if deviceIP == "" {
+		deviceIP = pbs_req.Device.IPv6
+	}
+	ivtReason := deps.ivt.Check(deviceIP, pbs_req.Device.UA)
+	if ivtReason != "" {
+		metrics.GetOrRegisterMeter(fmt.Sprintf("invalid_traffic.%s", ivtReason), metricsRegistry).Mark(1)
+	}
+	skipBidders := ivtReason != "" && deps.ivt.Rejects()
+
+	if deps.identity != nil && !skipBidders {
+		start := time.Now()
+		if err := identity.ResolveUser(ctx, deps.identity, pbs_req.User); err != nil {
+			mIdentityErrorMeter.Mark(1)
+			if glog.V(2) {
+				glog.Infof("Failed to resolve identity envelopes: %v", err)
+			}
+		}
+		mIdentityTimer.UpdateSince(start)
+	}
+
+	if pbs_req.SupplyChain() == nil {
+		if host := deps.cfg.GetSChain(pbs_req.AccountID); host.Enabled {
+			pbs_req.SetSupplyChain(pbs.NewHostSupplyChain(pbs.SupplyChainNode{
+				ASI:    host.ASI,
+				SID:    host.SID,
+				Name:   host.Name,
+				Domain: host.Domain,
+			}))
+		}
+	}
 
 	pbs_resp := pbs.PBSResponse{
 		Status:       status,
 		TID:          pbs_req.Tid,
 		BidderStatus: pbs_req.Bidders,
 	}
+	if pbs_req.IsDebug {
+		pbs_resp.Ext = &pbs.PBSResponseExt{
+			Debug: &pbs.PBSResponseDebug{ResolvedRequest: pbs_req.ResolvedAdUnits()},
+		}
+	}
 
-	ch := make(chan bidResult)
+	// The channels are buffered so that bidders can still finish if the auction returns early.
+	ch := make(chan bidResult, len(pbs_req.Bidders))
 	sentBids := 0
+	// Shadow bidders don't hold up the response, so their context lasts until their deadline, even if the
+	// auction returns first.
+	shadowCh := make(chan bidResult, len(pbs_req.Bidders))
+	sentShadowBids := 0
+	shadowDeadline, _ := bidCtx.Deadline()
+	shadowCtx, cancelShadow := context.WithDeadline(context.Background(), shadowDeadline)
+	defer func() {
+		if sentShadowBids == 0 {
+			cancelShadow()
+			return
+		}
+		shadowBids = &lateBidCollector{results: shadowCh, count: sentShadowBids, cancel: cancelShadow}
+	}()
+	pending := make(map[*pbs.PBSBidder]bool, len(pbs_req.Bidders))
+	outcomes := make(map[*pbs.PBSBidder]*bidderOutcome, len(pbs_req.Bidders))
+	bidderControls := pbs_req.BidderControls()
 	for _, bidder := range pbs_req.Bidders {
-		if ex, ok := exchanges[bidder.BidderCode]; ok {
-			ametrics := adapterMetrics[bidder.BidderCode]
-			accountAdapterMetric := am.AdapterMetrics[bidder.BidderCode]
+		if !bidderControls.Allow(bidder.BidderCode) {
+			bidder.Error = "Skipped by ext.prebid.biddercontrols"
+			continue
+		}
+		if skipBidders {
+			bidder.Error = "Skipped because the request looks like invalid traffic"
+			continue
+		}
+		// Request aliases run their core bidder's adapter, with its settings and metrics.
+		coreBidder := pbs_req.CoreBidder(bidder.BidderCode)
+		if ex, ok := exchanges[coreBidder]; ok {
+			ametrics := adapterMetrics[coreBidder]
+			accountAdapterMetric := am.AdapterMetrics[coreBidder]
+			if !accountConfig.ActivityAllowed(config.ActivityFetchBids, channel, bidder.BidderCode, coreBidder) {
+				bidder.Error = "Denied by the account's activity controls"
+				continue
+			}
+			if units, errs := validateAdUnitParams(bidder, coreBidder); len(errs) > 0 {
+				pbs_resp.AddErrors(bidder.BidderCode, errs)
+				bidder.AdUnits = units
+				if len(units) == 0 {
+					bidder.Error = "Invalid params"
+					continue
+				}
+			}
+			if units, skipped := deps.noBids.Filter(pbs_req.AccountID, bidder.BidderCode, bidder.AdUnits); skipped > 0 {
+				ametrics.NoBidCacheMeter.Mark(int64(skipped))
+				bidder.AdUnits = units
+				if len(units) == 0 {
+					bidder.NoBid = true
+					continue
+				}
+			}
+			// Requests which won't be sent shouldn't use up the bidder's QPS, so the throttle comes after
+			// every other reason to skip the bidder.
+			skipsNoCookie := pbs_req.App == nil && ex.SkipNoCookies() && pbs_req.BuyerUID(bidder.BidderCode, ex.FamilyName()) == ""
+			if t, ok := deps.throttles[strings.ToLower(coreBidder)]; ok && !skipsNoCookie && !t.Allow(pbs_req.Tid) {
+				ametrics.ThrottledMeter.Mark(1)
+				accountAdapterMetric.ThrottledMeter.Mark(1)
+				bidder.Error = "Skipped by the bidder's throttle"
+				continue
+			}
 			ametrics.RequestMeter.Mark(1)
 			accountAdapterMetric.RequestMeter.Mark(1)
+			bidder.Test = pbs_req.Test == 1 && testBidders[strings.ToLower(coreBidder)]
+			bidder.EIDLimits = eidLimits[strings.ToLower(coreBidder)]
+			bidder.StripFields = stripFields[strings.ToLower(coreBidder)]
+			blocked := deps.blocklist.Blocked(pbs_req.AccountID, coreBidder)
+			bidder.BlockedAppBundles = blocked.AppBundles
+			bidder.BlockedDomains = blocked.Domains
+			bidder.GPP = deps.registry.SupportsGPP(coreBidder)
+			bidder.GDPR = deps.gdpr.Restrictions(pbs_req.Regs, pbs_req.User, coreBidder)
+			if !accountConfig.ActivityAllowed(config.ActivityTransmitUFPD, channel, bidder.BidderCode, coreBidder) {
+				bidder.GDPR.UserIDs = true
+			}
+			if !accountConfig.ActivityAllowed(config.ActivityTransmitPreciseGeo, channel, bidder.BidderCode, coreBidder) {
+				bidder.GDPR.PreciseGeo = true
+			}
+			if pbs_req.CookieDeprecation != "" {
+				ametrics.CookieDeprecationMeter.Mark(1)
+				accountAdapterMetric.CookieDeprecationMeter.Mark(1)
+			}
 			if pbs_req.App == nil {
-				uid, _, _ := pbs_req.Cookie.GetUID(ex.FamilyName())
-				if uid == "" {
+				if pbs_req.BuyerUID(bidder.BidderCode, ex.FamilyName()) == "" {
 					bidder.NoCookie = true
-					bidder.UsersyncInfo = ex.GetUsersyncInfo()
+					if accountConfig.ActivityAllowed(config.ActivitySyncUser, "", bidder.BidderCode, ex.FamilyName()) {
+						bidder.UsersyncInfo = ex.GetUsersyncInfo(pbs.UsersyncPrivacy{})
+					}
 					ametrics.NoCookieMeter.Mark(1)
 					accountAdapterMetric.NoCookieMeter.Mark(1)
 					if ex.SkipNoCookies() {
@@ -307,36 +806,107 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 					}
 				}
 			}
-			sentBids++
-			go func(bidder *pbs.PBSBidder) {
+			shadow := shadowBidders[strings.ToLower(coreBidder)]
+			results, bidderCtx := ch, bidCtx
+			if shadow {
+				results, bidderCtx = shadowCh, shadowCtx
+				sentShadowBids++
+			} else {
+				sentBids++
+				pending[bidder] = true
+			}
+			outcome := &bidderOutcome{}
+			outcomes[bidder] = outcome
+			go func(bidder *pbs.PBSBidder, bidderCtx context.Context) {
+				if timeout := bidderControls.Timeout(bidder.BidderCode); timeout > 0 {
+					var cancelBidder context.CancelFunc
+					bidderCtx, cancelBidder = context.WithTimeout(bidderCtx, timeout)
+					defer cancelBidder()
+				}
+				bidderCtx = adapters.WithCallHook(bidderCtx, ametrics.recordCall)
 				start := time.Now()
-				bid_list, err := ex.Call(ctx, pbs_req, bidder)
+				bid_list, err := callAdapter(bidderCtx, ex, pbs_req, bidder)
+				if err == nil {
+					deps.noBids.Record(pbs_req.AccountID, bidder.BidderCode, bidder.AdUnits, bid_list)
+				}
+				if !outcome.claim() {
+					// The auction stopped waiting, and counted this call then. Its bids only go to analytics.
+					mLateBidsMeter.Mark(int64(len(bid_list)))
+					results <- bidResult{bidder: bidder, bid_list: bid_list}
+					return
+				}
 				bidder.ResponseTime = int(time.Since(start) / time.Millisecond)
 				ametrics.RequestTimer.UpdateSince(start)
 				accountAdapterMetric.RequestTimer.UpdateSince(start)
+				var errs []error
+				var rejected []analytics.RejectedBid
+				allBids := bid_list
 				if err != nil {
 					switch err {
 					case context.DeadlineExceeded:
 						ametrics.TimeoutMeter.Mark(1)
 						accountAdapterMetric.TimeoutMeter.Mark(1)
 						bidder.Error = "Timed out"
+						errs = append(errs, &errortypes.Timeout{Message: bidder.Error})
 					case context.Canceled:
+						if ctx.Err() == context.Canceled {
+							// The auction returned early, so nobody is waiting for this bidder.
+							bidder.Error = "Auction returned early"
+							break
+						}
 						fallthrough
 					default:
 						ametrics.ErrorMeter.Mark(1)
 						accountAdapterMetric.ErrorMeter.Mark(1)
 						bidder.Error = err.Error()
+						if _, ok := err.(*adapters.PanicError); ok {
+							ametrics.PanicMeter.Mark(1)
+							accountAdapterMetric.PanicMeter.Mark(1)
+						}
+						if badResponse, ok := err.(*errortypes.BadServerResponse); ok {
+							ametrics.BadResponseMeter.Mark(1)
+							accountAdapterMetric.BadResponseMeter.Mark(1)
+							deps.quarantine.Capture(bidder.BidderCode, err, badResponse.Payload)
+						}
 						glog.Warningf("Error from bidder %v. Ignoring all bids: %v", bidder.BidderCode, err)
+						errs = append(errs, err)
 					}
 				} else if bid_list != nil {
-					bid_list = checkForValidBidSize(bid_list, bidder)
+					recordOriginalPrices(bid_list)
+					checked, currencyErrs := checkBidCurrencies(bid_list, deps.cfg.BidderValidations[strings.ToLower(coreBidder)].Currencies, deps.currencyConverter)
+					errs = append(errs, currencyErrs...)
+					rejected = append(rejected, rejectedBids(ametrics, bid_list, checked, analytics.RejectedCurrency)...)
+					bid_list = checked
+					received := len(bid_list)
+					checked = checkForValidBidSize(bid_list, bidder)
+					rejected = append(rejected, rejectedBids(ametrics, bid_list, checked, analytics.RejectedMissingSize)...)
+					bid_list = checked
+					checked = checkNativeBids(bid_list, bidder)
+					rejected = append(rejected, rejectedBids(ametrics, bid_list, checked, analytics.RejectedInvalidNative)...)
+					bid_list = checkBidMeta(checked, accountConfig.RequireAdvertiserDomains)
+					rejected = append(rejected, rejectedBids(ametrics, checked, bid_list, analytics.RejectedAdvertiserDomains)...)
+					checked = checkBlockedDomains(bid_list, blocked)
+					rejected = append(rejected, rejectedBids(ametrics, bid_list, checked, analytics.RejectedBlockedDomain)...)
+					bid_list = checked
+					checkRendererHints(bid_list, pbs_req.SDK)
+					if rejected := received - len(bid_list); rejected > 0 {
+						errs = append(errs, &errortypes.Warning{
+							Message:     fmt.Sprintf("%d of %d bids were rejected for missing sizes, invalid native markup, missing metadata or blocked advertisers", rejected, received),
+							WarningCode: errortypes.InvalidBidWarningCode,
+						})
+					}
 					bidder.NumBids = len(bid_list)
-					am.BidsReceivedMeter.Mark(int64(bidder.NumBids))
+					// Shadow bids never reach the account's auctions, so only the bidder's own metrics count them.
+					if !shadow {
+						am.BidsReceivedMeter.Mark(int64(bidder.NumBids))
+					}
 					accountAdapterMetric.BidsReceivedMeter.Mark(int64(bidder.NumBids))
 					for _, bid := range bid_list {
 						var cpm = int64(bid.Price * 1000)
 						ametrics.PriceHistogram.Update(cpm)
-						am.PriceHistogram.Update(cpm)
+						if !shadow {
+							am.PriceHistogram.Update(cpm)
+						}
 						accountAdapterMetric.PriceHistogram.Update(cpm)
 						bid.ResponseTime = bidder.ResponseTime
 					}
@@ -346,24 +916,88 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 					accountAdapterMetric.NoBidMeter.Mark(1)
 				}
 
-				ch <- bidResult{
+				results <- bidResult{
 					bidder:   bidder,
 					bid_list: bid_list,
+					errs:     errs,
+					allBids:  allBids,
+					rejected: rejected,
 				}
-			}(bidder)
+			}(bidder, bidderCtx)
 
 		} else {
 			bidder.Error = "Unsupported bidder"
 		}
 	}
 
+	sampleLandscape := rand.Float64() < deps.cfg.Analytics.BidLandscapeSampleRate
+	var latch *bidLatch
+	if accountConfig.EarlyReturn.Enabled {
+		latch = newBidLatch(pbs_req.AdUnits, accountConfig.EarlyReturn.MinCPM)
+	}
+	// Bidders which outlive the auction have to be cut off at tmax.
+	var deadline <-chan struct{}
+	if bidCtx != ctx {
+		deadline = ctx.Done()
+	}
+	timedOut := false
+collectBids:
 	for i := 0; i < sentBids; i++ {
-		result := <-ch
+		var result bidResult
+		select {
+		case result = <-ch:
+		case <-deadline:
+			timedOut = true
+			break collectBids
+		}
+		delete(pending, result.bidder)
 
 		for _, bid := range result.bid_list {
 			pbs_resp.Bids = append(pbs_resp.Bids, bid)
 		}
+		if sampleLandscape {
+			ao.BidLandscape = append(ao.BidLandscape, result.allBids...)
+		}
+		ao.RejectedBids = append(ao.RejectedBids, result.rejected...)
+		pbs_resp.AddErrors(result.bidder.BidderCode, result.errs)
+		if latch != nil && latch.Add(result.bid_list) {
+			break
+		}
+	}
+	if len(pending) > 0 {
+		reason := "Skipped because the auction returned early"
+		if timedOut {
+			reason = "Timed out"
+			for bidder := range pending {
+				coreBidder := pbs_req.CoreBidder(bidder.BidderCode)
+				// Bidders which finished just in time, but whose results weren't read, were already counted.
+				if outcomes[bidder].claim() {
+					adapterMetrics[coreBidder].TimeoutMeter.Mark(1)
+					am.AdapterMetrics[coreBidder].TimeoutMeter.Mark(1)
+				}
+				pbs_resp.AddErrors(bidder.BidderCode, []error{&errortypes.Timeout{Message: reason}})
+			}
+		} else {
+			mEarlyReturnMeter.Mark(1)
+			for bidder := range pending {
+				outcomes[bidder].claim()
+			}
+		}
+		pbs_resp.BidderStatus = skipPendingBidders(pbs_req.Bidders, pending, reason)
+		if bidCtx != ctx {
+			lateBids = &lateBidCollector{results: ch, count: len(pending)}
+		}
 	}
+	if len(shadowBidders) > 0 {
+		pbs_resp.BidderStatus = withoutShadowBidders(pbs_resp.BidderStatus, pbs_req)
+	}
+	if accountConfig.MinWinningCPM > 0 {
+		var belowMin []analytics.RejectedBid
+		pbs_resp.Bids, belowMin = enforceMinWinningCPM(pbs_resp.Bids, accountConfig.MinWinningCPM, pbs_req)
+		ao.RejectedBids = append(ao.RejectedBids, belowMin...)
+	}
+	resolveBidMacros(pbs_resp.Bids, pbs_req.Tid, deps.cfg.BidderMacros, deps.priceEncrypters)
+	injectTrackers(pbs_resp.Bids, pbs_req.AccountID, accountConfig.Events, deps.cfg.ExternalURL, pbs_req.Start)
 	if pbs_req.CacheMarkup == 1 {
 		cobjs := make([]*pbc.CacheObject, len(pbs_resp.Bids))
 		for i, bid := range pbs_resp.Bids {
@@ -373,130 +1007,1086 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 				Width:  bid.Width,
 				Height: bid.Height,
 			}
+			bid.Exp = deps.cfg.GetCacheTTL(pbs_req.AccountID, bid.Exp)
 			cobjs[i] = &pbc.CacheObject{
-				Value: bc,
+				Value:      bc,
+				TTLSeconds: bid.Exp,
 			}
 		}
 		err = pbc.Put(ctx, cobjs)
 		if err != nil {
-			writeAuctionError(w, "Prebid cache failed", err)
+			writeAuctionError(w, http.StatusInternalServerError, "Prebid cache failed", err)
 			mErrorMeter.Mark(1)
+			mCacheErrorMeter.Mark(1)
+			am.CacheErrorMeter.Mark(1)
+			ao.Status = http.StatusInternalServerError
+			ao.Errors = append(ao.Errors, err)
 			return
 		}
 		for i, bid := range pbs_resp.Bids {
 			bid.CacheID = cobjs[i].UUID
-			bid.CacheURL = deps.cfg.GetCachedAssetURL(bid.CacheID)
+			bid.CacheURL = deps.cfg.GetAccountCachedAssetURL(pbs_req.AccountID, bid.CacheID)
 			bid.NURL = ""
 			bid.Adm = ""
 		}
 	}
 
-	if pbs_req.SortBids == 1 {
-		sortBidsAddKeywordsMobile(pbs_resp.Bids, pbs_req, account.PriceGranularity)
+	if deps.cfg.DChain.Enabled {
+		attachDemandChains(pbs_resp.Bids, pbs_req, deps.registry, pbs.DemandChainNode{
+			ASI:    deps.cfg.DChain.ASI,
+			Name:   deps.cfg.DChain.Name,
+			Domain: deps.cfg.DChain.Domain,
+		})
+	}
+
+	targetingOnly := pbs_req.Targeting() != nil && pbs_req.Targeting().TargetingOnly
+	if pbs_req.SortBids == 1 || targetingOnly {
+		cacheHost, cachePath := deps.cfg.GetCacheHostAndPath(pbs_req.AccountID)
+		maxKeys := maxTargetingKeys(accountConfig.MaxTargetingKeys, pbs_req.Targeting())
+		pbs_resp.AddErrors("prebid", sortBidsAddKeywordsMobile(pbs_resp.Bids, pbs_req, priceGranularity, cacheHost, cachePath, maxKeys))
+	}
+	if targetingOnly {
+		compactForTargeting(&pbs_resp)
 	}
 
 	if glog.V(2) {
 		glog.Infof("Request for %d ad units on url %s by account %s got %d bids", len(pbs_req.AdUnits), pbs_req.Url, pbs_req.AccountID, len(pbs_resp.Bids))
 	}
 
+	if pbs_resp.Ext == nil {
+		pbs_resp.Ext = &pbs.PBSResponseExt{}
+	}
+	pbs_resp.Ext.Prebid = &pbs.PBSResponseExtPrebid{
+		AuctionTimestamp: pbs_req.Start.UnixNano() / int64(time.Millisecond),
+	}
+	ao.Response = &pbs_resp
+
+	// Wrappers subtract this from their ad server timeout, so it's set as late as possible.
+	w.Header().Set(auctionTimeHeader, strconv.Itoa(pbs_req.Elapsed()))
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
 	enc.Encode(pbs_resp)
 	mRequestTimer.UpdateSince(pbs_req.Start)
 }
 
-// checkForValidBidSize goes through list of bids & find those which are banner mediaType and with height or width not defined
-// determine the num of ad unit sizes that were used in corresponding bid request
-// if num_adunit_sizes == 1, assign the height and/or width to bid's height/width
-// if num_adunit_sizes > 1, reject the bid (remove from list) and return an error
-// return updated bid list object for next steps in auction
-func checkForValidBidSize(bids pbs.PBSBidSlice, bidder *pbs.PBSBidder) pbs.PBSBidSlice {
-	finalValidBids := make([]*pbs.PBSBid, len(bids))
-	finalBidCounter := 0
-bidLoop:
-	for _, bid := range bids {
-		if bid.CreativeMediaType == "banner" && (bid.Height == 0 || bid.Width == 0) {
-			for _, adunit := range bidder.AdUnits {
-				if adunit.BidID == bid.BidID && adunit.Code == bid.AdUnitCode {
-					if len(adunit.Sizes) == 1 {
-						bid.Width, bid.Height = adunit.Sizes[0].W, adunit.Sizes[0].H
-						finalValidBids[finalBidCounter] = bid
-						finalBidCounter = finalBidCounter + 1
-					} else if len(adunit.Sizes) > 1 {
-						glog.Warningf("Bid was rejected for bidder %s because no size was defined", bid.BidderCode)
-					}
-					continue bidLoop
-				}
-			}
-		} else {
-			finalValidBids[finalBidCounter] = bid
-			finalBidCounter = finalBidCounter + 1
-		}
+// openrtbAuctionDeps serves /openrtb2/auction, which takes an OpenRTB 2.5 BidRequest instead of a PBSRequest.
+type openrtbAuctionDeps struct {
+	cfg      *config.Configuration
+	exchange *exchange.Exchange
+}
+
+func (deps *openrtbAuctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mOpenRTBRequestMeter.Mark(1)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		mOpenRTBInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Failed to read the request body: %v", err), http.StatusBadRequest)
+		return
 	}
-	return finalValidBids[:finalBidCounter]
+	var request openrtb.BidRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		mOpenRTBInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := exchange.ValidateDOOH(body); err != nil {
+		mOpenRTBInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if request.Regs, err = gdpr.MoveGPPToExt(body, request.Regs); err != nil {
+		mOpenRTBInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := deps.exchange.Validate(&request); err != nil {
+		mOpenRTBInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	channel := pbs.ChannelPrebidJS
+	if request.App != nil {
+		channel = pbs.ChannelApp
+	}
+	response, err := deps.holdAuction(r, &request, channel)
+	if err != nil {
+		writeHoldAuctionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(response)
 }
 
-// sortBidsAddKeywordsMobile sorts the bids and adds ad server targeting keywords to each bid.
-// The bids are sorted by cpm to find the highest bid.
-// The ad server targeting keywords are added to all bids, with specific keywords for the highest bid.
-func sortBidsAddKeywordsMobile(bids pbs.PBSBidSlice, pbs_req *pbs.PBSRequest, priceGranularitySetting string) {
-	if priceGranularitySetting == "" {
-		priceGranularitySetting = defaultPriceGranularity
+// holdAuction runs a validated request through the exchange, after filling in the device and tmax.
+// The channel is the integration which the endpoint serves. The account's activity controls are checked against it,
+// and the account is the publisher of the request's site or app. Accounts which disabled the channel get a BadInput error.
+func (deps *openrtbAuctionDeps) holdAuction(r *http.Request, request *openrtb.BidRequest, channel string) (*openrtb.BidResponse, error) {
+	accountID := openrtbAccountID(request)
+	account := deps.cfg.GetAccount(accountID)
+	metrics.GetOrRegisterMeter(fmt.Sprintf("channels.%s.requests", channel), metricsRegistry).Mark(1)
+	if !account.ChannelEnabled(channel) {
+		return nil, &errortypes.BadInput{Message: fmt.Sprintf("account %s doesn't accept %s requests", accountID, channel)}
+	}
+	fillDevice(request, r)
+	if gdpr.COPPAApplies(request.Regs) {
+		mCOPPAMeter.Mark(1)
 	}
 
-	// record bids by ad unit code for sorting
-	code_bids := make(map[string]pbs.PBSBidSlice, len(bids))
-	for _, bid := range bids {
-		code_bids[bid.AdUnitCode] = append(code_bids[bid.AdUnitCode], bid)
+	// Like /auction, tmax falls back to the default if it's missing or over 2 seconds.
+	if request.TMax <= 0 || request.TMax > 2000 {
+		request.TMax = int64(deps.cfg.DefaultTimeout)
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(request.TMax)*time.Millisecond)
+	defer cancel()
 
-	// loop through ad units to find top bid
-	for _, unit := range pbs_req.AdUnits {
-		bar := code_bids[unit.Code]
+	response, err := deps.exchange.HoldAuction(ctx, request, exchange.Activities{Account: account, Channel: channel})
+	if err == nil {
+		// Verbose bidders' ext.bidder is trimmed down to what the host and account want in their responses.
+		var trimmed int
+		trimmed, err = exchange.TrimBidderExt(response, deps.cfg.BidderExt, account.BidderExtKeys)
+		mBidderExtTrimMeter.Mark(int64(trimmed))
+	}
+	if err != nil {
+		mErrorMeter.Mark(1)
+		glog.Errorf("OpenRTB auction for request %s failed: %v", request.ID, err)
+	}
+	return response, err
+}
 
-		if len(bar) == 0 {
-			if glog.V(3) {
-				glog.Infof("No bids for ad unit '%s'", unit.Code)
-			}
-			continue
-		}
-		sort.Sort(bar)
+// writeHoldAuctionError responds to a request whose auction couldn't be held.
+func writeHoldAuctionError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*errortypes.BadInput); ok {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Critical error while running the auction: %v", err), http.StatusInternalServerError)
+}
 
-		// after sorting we need to add the ad targeting keywords
-		for i, bid := range bar {
-			priceBucketStringMap := pbs.GetPriceBucketString(bid.Price)
-			roundedCpm := priceBucketStringMap[priceGranularitySetting]
+// openrtbAccountID returns the ID of the publisher of the request's site or app, which is its account.
+func openrtbAccountID(request *openrtb.BidRequest) string {
+	if request.Site != nil && request.Site.Publisher != nil {
+		return request.Site.Publisher.ID
+	}
+	if request.App != nil && request.App.Publisher != nil {
+		return request.App.Publisher.ID
+	}
+	return ""
+}
 
-			hbSize := ""
-			if bid.Width != 0 && bid.Height != 0 {
-				width := strconv.FormatUint(bid.Width, 10)
-				height := strconv.FormatUint(bid.Height, 10)
-				hbSize = width + "x" + height
-			}
+// ampResponse is the shape which amp-ad's Real Time Config expects.
+type ampResponse struct {
+	Targeting map[string]string `json:"targeting"`
+}
 
-			hbPbBidderKey := hbpbConstantKey + "_" + bid.BidderCode
-			hbBidderBidderKey := hbBidderConstantKey + "_" + bid.BidderCode
-			hbCacheIdBidderKey := hbCacheIdConstantKey + "_" + bid.BidderCode
-			hbSizeBidderKey := hbSizeConstantKey + "_" + bid.BidderCode
-			if pbs_req.MaxKeyLength != 0 {
-				hbPbBidderKey = hbPbBidderKey[:min(len(hbPbBidderKey), int(pbs_req.MaxKeyLength))]
-				hbBidderBidderKey = hbBidderBidderKey[:min(len(hbBidderBidderKey), int(pbs_req.MaxKeyLength))]
-				hbCacheIdBidderKey = hbCacheIdBidderKey[:min(len(hbCacheIdBidderKey), int(pbs_req.MaxKeyLength))]
-				hbSizeBidderKey = hbSizeBidderKey[:min(len(hbSizeBidderKey), int(pbs_req.MaxKeyLength))]
-			}
-			pbs_kvs := map[string]string{
-				hbPbBidderKey:      roundedCpm,
-				hbBidderBidderKey:  bid.BidderCode,
-				hbCacheIdBidderKey: bid.CacheID,
-			}
-			if hbSize != "" {
-				pbs_kvs[hbSizeBidderKey] = hbSize
-			}
-			// For the top bid, we want to add the following additional keys
-			if i == 0 {
-				pbs_kvs[hbpbConstantKey] = roundedCpm
+// amp serves /openrtb2/amp. AMP pages can't send a BidRequest, so they name a stored one with tag_id.
+// The query string can override its page URL (curl), slot (the imp's tagid) and size (w and h, or
+// ow and oh, which take precedence). Only the targeting keys are returned.
+func (deps *openrtbAuctionDeps) amp(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mAMPRequestMeter.Mark(1)
+	query := r.URL.Query()
+	tagID := query.Get("tag_id")
+	if tagID == "" {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, "Invalid request: tag_id is required", http.StatusBadRequest)
+		return
+	}
+	stored, err := measureConfigs(dataCache, deps.cfg.DataCache.Type, "amp").Config().Get(tagID)
+	if err == cache.ErrNotFound {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: no AMP config found for tag_id '%s'", tagID), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		mErrorMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Failed to load the AMP config for tag_id '%s': %v", tagID, err), http.StatusInternalServerError)
+		return
+	}
+
+	var request openrtb.BidRequest
+	if err := json.Unmarshal([]byte(stored), &request); err != nil {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: the AMP config for tag_id '%s' is not a BidRequest: %v", tagID, err), http.StatusBadRequest)
+		return
+	}
+	if len(request.Imp) != 1 {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: the AMP config for tag_id '%s' must have exactly one imp", tagID), http.StatusBadRequest)
+		return
+	}
+	// AMP's CORS rules: https://www.ampproject.org/docs/fundamentals/amp-cors-requests
+	sourceOrigin := query.Get("__amp_source_origin")
+	if err := checkAMPOrigin(r, sourceOrigin, &request, deps.cfg.GetAccount(openrtbAccountID(&request))); err != nil {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+		return
+	}
+	if sourceOrigin != "" {
+		w.Header().Set("AMP-Access-Control-Allow-Source-Origin", sourceOrigin)
+		w.Header().Set("Access-Control-Expose-Headers", "AMP-Access-Control-Allow-Source-Origin")
+	}
+
+	overrideAMPRequest(&request, query)
+	if err := requireTargeting(&request); err != nil {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := deps.exchange.Validate(&request); err != nil {
+		mAMPInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response, err := deps.holdAuction(r, &request, pbs.ChannelAMP)
+	if err != nil {
+		writeHoldAuctionError(w, err)
+		return
+	}
+
+	targeting := make(map[string]string)
+	for _, seatBid := range response.SeatBid {
+		for _, bid := range seatBid.Bid {
+			var ext exchange.ExtBid
+			if err := json.Unmarshal(bid.Ext, &ext); err != nil {
+				continue
+			}
+			for key, value := range ext.Prebid.Targeting {
+				targeting[key] = value
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&ampResponse{Targeting: targeting})
+}
+
+// video serves /openrtb2/video, for long-form video players which ask for ad pods instead of imps.
+// Each pod's configid names a stored imp with the bidders' params. The picked bids are cached, so that
+// the ad server can fetch their VAST with hb_cache_id.
+func (deps *openrtbAuctionDeps) video(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mVideoRequestMeter.Mark(1)
+	var videoRequest exchange.VideoRequest
+	if err := json.NewDecoder(r.Body).Decode(&videoRequest); err != nil {
+		mVideoInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := videoRequest.Validate(); err != nil {
+		mVideoInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	storedImps := make(map[string]openrtb.Imp, len(videoRequest.PodConfig.Pods))
+	configs := measureConfigs(dataCache, deps.cfg.DataCache.Type, "video").Config()
+	for _, pod := range videoRequest.PodConfig.Pods {
+		if _, ok := storedImps[pod.ConfigID]; ok {
+			continue
+		}
+		stored, err := configs.Get(pod.ConfigID)
+		if err == cache.ErrNotFound {
+			mVideoInvalidMeter.Mark(1)
+			http.Error(w, fmt.Sprintf("Invalid request: no stored imp found for configid '%s'", pod.ConfigID), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			mErrorMeter.Mark(1)
+			http.Error(w, fmt.Sprintf("Failed to load the stored imp for configid '%s': %v", pod.ConfigID, err), http.StatusInternalServerError)
+			return
+		}
+		var imp openrtb.Imp
+		if err := json.Unmarshal([]byte(stored), &imp); err != nil {
+			mVideoInvalidMeter.Mark(1)
+			http.Error(w, fmt.Sprintf("Invalid request: the stored imp for configid '%s' is not an Imp: %v", pod.ConfigID, err), http.StatusBadRequest)
+			return
+		}
+		storedImps[pod.ConfigID] = imp
+	}
+
+	request, err := videoRequest.BidRequest(storedImps)
+	if err != nil {
+		mErrorMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Failed to expand the pods: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := deps.exchange.Validate(request); err != nil {
+		mVideoInvalidMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response, err := deps.holdAuction(r, request, pbs.ChannelVideo)
+	if err != nil {
+		writeHoldAuctionError(w, err)
+		return
+	}
+	videoResponse, err := videoRequest.Response(response, func(bids []*openrtb.Bid) ([]string, error) {
+		return cacheVideoBids(r.Context(), bids)
+	})
+	if err != nil {
+		mErrorMeter.Mark(1)
+		mCacheErrorMeter.Mark(1)
+		http.Error(w, fmt.Sprintf("Prebid cache failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(videoResponse)
+}
+
+// cacheVideoBids stores the bids' markup in Prebid Cache, and returns their cache IDs.
+func cacheVideoBids(ctx context.Context, bids []*openrtb.Bid) ([]string, error) {
+	cobjs := make([]*pbc.CacheObject, len(bids))
+	for i, bid := range bids {
+		cobjs[i] = &pbc.CacheObject{
+			Value: &pbc.BidCache{
+				Adm:    bid.AdM,
+				NURL:   bid.NURL,
+				Width:  bid.W,
+				Height: bid.H,
+			},
+			TTLSeconds: bid.Exp,
+		}
+	}
+	if err := pbc.Put(ctx, cobjs); err != nil {
+		return nil, err
+	}
+	cacheIDs := make([]string, len(cobjs))
+	for i, cobj := range cobjs {
+		cacheIDs[i] = cobj.UUID
+	}
+	return cacheIDs, nil
+}
+
+// overrideAMPRequest applies the AMP query params to the stored request, which has one imp.
+// ampCacheSuffixes are the hosts of the AMP caches which serve publishers' pages.
+var ampCacheSuffixes = []string{".cdn.ampproject.org", ".bing-amp.com"}
+
+// checkAMPOrigin makes sure that an AMP request comes from one of the publisher's pages. The source origin, from
+// __amp_source_origin, must be on the account's domains, or the stored request's site if the account has none.
+// The Origin header must be an AMP cache or the source origin itself, and pages on the publisher's own origin send
+// AMP-Same-Origin instead.
+func checkAMPOrigin(r *http.Request, sourceOrigin string, request *openrtb.BidRequest, account config.Account) error {
+	publisherDomains := account.Domains
+	if len(publisherDomains) == 0 && request.Site != nil {
+		publisherDomains = []string{request.Site.Domain, request.Site.Page}
+	}
+	onPublisherDomain := func(origin string) bool {
+		domain := pbs.NormalizeDomain(origin)
+		for _, publisherDomain := range publisherDomains {
+			if domain != "" && pbs.NormalizeDomain(publisherDomain) == domain {
+				return true
+			}
+		}
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if sourceOrigin != "" {
+		if !onPublisherDomain(sourceOrigin) {
+			return fmt.Errorf("__amp_source_origin %s isn't on the publisher's domains", sourceOrigin)
+		}
+		if origin == "" && r.Header.Get("AMP-Same-Origin") != "true" {
+			return errors.New("AMP requests need an Origin or AMP-Same-Origin header")
+		}
+	}
+	if origin == "" || origin == sourceOrigin || isAMPCacheOrigin(origin) {
+		return nil
+	}
+	if sourceOrigin == "" && onPublisherDomain(origin) {
+		return nil
+	}
+	return fmt.Errorf("Origin %s isn't an AMP cache or the publisher's page", origin)
+}
+
+func isAMPCacheOrigin(origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(originURL.Hostname())
+	for _, suffix := range ampCacheSuffixes {
+		if host == suffix[1:] || strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func overrideAMPRequest(request *openrtb.BidRequest, query url.Values) {
+	if curl := query.Get("curl"); curl != "" && request.Site != nil {
+		site := *request.Site
+		site.Page = curl
+		request.Site = &site
+	}
+	imp := &request.Imp[0]
+	if slot := query.Get("slot"); slot != "" {
+		imp.TagID = slot
+	}
+	if imp.Banner == nil {
+		return
+	}
+	width, height := ampSize(query, "ow", "oh")
+	if width == 0 || height == 0 {
+		width, height = ampSize(query, "w", "h")
+	}
+	if width > 0 && height > 0 {
+		banner := *imp.Banner
+		banner.Format = []openrtb.Format{{W: width, H: height}}
+		banner.W, banner.H = nil, nil
+		imp.Banner = &banner
+	}
+}
+
+func ampSize(query url.Values, widthParam string, heightParam string) (uint64, uint64) {
+	width, _ := strconv.ParseUint(query.Get(widthParam), 10, 64)
+	height, _ := strconv.ParseUint(query.Get(heightParam), 10, 64)
+	return width, height
+}
+
+// requireTargeting turns on ext.prebid.targeting, since AMP only gets the targeting keys.
+func requireTargeting(request *openrtb.BidRequest) error {
+	ext := make(map[string]json.RawMessage)
+	if len(request.Ext) > 0 {
+		if err := json.Unmarshal(request.Ext, &ext); err != nil {
+			return fmt.Errorf("request.ext is invalid: %v", err)
+		}
+	}
+	prebid := make(map[string]json.RawMessage)
+	if rawPrebid, ok := ext["prebid"]; ok {
+		if err := json.Unmarshal(rawPrebid, &prebid); err != nil {
+			return fmt.Errorf("request.ext.prebid is invalid: %v", err)
+		}
+	}
+	if _, ok := prebid["targeting"]; ok {
+		return nil
+	}
+	prebid["targeting"] = json.RawMessage(`{}`)
+	rawPrebid, err := json.Marshal(prebid)
+	if err != nil {
+		return err
+	}
+	ext["prebid"] = rawPrebid
+	request.Ext, err = json.Marshal(ext)
+	return err
+}
+
+// fillDevice sets the device's user agent and IP from the HTTP request, if the BidRequest didn't have them.
+// IPv6 addresses go in device.ipv6.
+func fillDevice(request *openrtb.BidRequest, r *http.Request) {
+	if request.Device == nil {
+		request.Device = &openrtb.Device{}
+	}
+	if request.Device.UA == "" {
+		request.Device.UA = r.Header.Get("User-Agent")
+	}
+	if request.Device.IP == "" && request.Device.IPv6 == "" {
+		request.Device.IP, request.Device.IPv6 = prebid.GetIPs(r)
+	}
+}
+
+// openrtbBidders returns the bidders which can take part in OpenRTB auctions: the ones which implement adapters.Bidder.
+func openrtbBidders() map[string]adapters.Bidder {
+	bidders := make(map[string]adapters.Bidder)
+	for code, ex := range exchanges {
+		if bidder, ok := ex.(adapters.Bidder); ok {
+			bidders[code] = bidder
+		}
+	}
+	return bidders
+}
+
+// openrtbStripFields returns the fields which each bidder must not get, keyed by bidder code like openrtbBidders.
+func openrtbStripFields() map[string][]string {
+	fields := make(map[string][]string, len(stripFields))
+	for code := range exchanges {
+		if bidderFields, ok := stripFields[strings.ToLower(code)]; ok {
+			fields[code] = bidderFields
+		}
+	}
+	return fields
+}
+
+// bidLatch decides when an auction can return early: once every ad unit has a bid of at least minCPM.
+type bidLatch struct {
+	minCPM  float64
+	waiting map[string]bool
+}
+
+func newBidLatch(adUnits []pbs.AdUnit, minCPM float64) *bidLatch {
+	waiting := make(map[string]bool, len(adUnits))
+	for _, unit := range adUnits {
+		waiting[unit.Code] = true
+	}
+	return &bidLatch{minCPM: minCPM, waiting: waiting}
+}
+
+// Add records a bidder's bids, and returns true if every ad unit has a qualifying bid.
+func (l *bidLatch) Add(bids pbs.PBSBidSlice) bool {
+	for _, bid := range bids {
+		if bid.Price >= l.minCPM {
+			delete(l.waiting, bid.AdUnitCode)
+		}
+	}
+	return len(l.waiting) == 0
+}
+
+// skipPendingBidders replaces the bidders which are still running with a copy that explains why they have no bids.
+// The originals can't be used in the response, because their goroutines are still writing to them.
+func skipPendingBidders(bidders []*pbs.PBSBidder, pending map[*pbs.PBSBidder]bool, reason string) []*pbs.PBSBidder {
+	status := make([]*pbs.PBSBidder, len(bidders))
+	for i, bidder := range bidders {
+		if !pending[bidder] {
+			status[i] = bidder
+			continue
+		}
+		status[i] = &pbs.PBSBidder{
+			BidderCode:   bidder.BidderCode,
+			NoCookie:     bidder.NoCookie,
+			UsersyncInfo: bidder.UsersyncInfo,
+			Error:        reason,
+		}
+	}
+	return status
+}
+
+// withoutShadowBidders removes the bidders in shadow mode, so that clients don't see them at all.
+// The request's aliases of shadow bidders are removed too.
+func withoutShadowBidders(bidders []*pbs.PBSBidder, req *pbs.PBSRequest) []*pbs.PBSBidder {
+	filtered := make([]*pbs.PBSBidder, 0, len(bidders))
+	for _, bidder := range bidders {
+		if !shadowBidders[strings.ToLower(req.CoreBidder(bidder.BidderCode))] {
+			filtered = append(filtered, bidder)
+		}
+	}
+	return filtered
+}
+
+// bidderOutcome makes sure that each bidder call's outcome is counted once. Either the bidder claims it when its
+// call finishes, or the auction claims it when it stops waiting for the bidder.
+type bidderOutcome struct {
+	claimed int32
+}
+
+// claim returns true if the caller is the first to claim the outcome.
+func (o *bidderOutcome) claim() bool {
+	return atomic.CompareAndSwapInt32(&o.claimed, 0, 1)
+}
+
+// lateBidCollector waits for the bidders which were still running when the auction responded.
+type lateBidCollector struct {
+	results <-chan bidResult
+	count   int
+	// cancel releases the bidders' context once they've all finished.
+	cancel context.CancelFunc
+}
+
+// Collect blocks until the remaining bidders have finished, and returns their bids.
+// Bidders are stopped by their context at the end of the grace period, so this doesn't wait forever.
+func (c *lateBidCollector) Collect() pbs.PBSBidSlice {
+	if c.cancel != nil {
+		defer c.cancel()
+	}
+	var bids pbs.PBSBidSlice
+	for i := 0; i < c.count; i++ {
+		result := <-c.results
+		bids = append(bids, result.bid_list...)
+	}
+	return bids
+}
+
+// callAdapter calls the adapter, and turns any panic into an error for that bidder
+// so that one buggy adapter can't take down the whole auction.
+func callAdapter(ctx context.Context, ex adapters.Adapter, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (bids pbs.PBSBidSlice, err error) {
+	defer adapters.RecoverPanic(bidder.BidderCode, func(panicErr error) {
+		bids = nil
+		err = panicErr
+	})
+	if batcher, ok := ex.(adapters.MultiImpAdapter); ok && batchedBidders[strings.ToLower(bidder.BidderCode)] {
+		return adapters.CallBatched(ctx, batcher, req, bidder)
+	}
+	return ex.Call(ctx, req, bidder)
+}
+
+// checkForValidBidSize goes through list of bids & find those which are banner mediaType and with height or width not defined
+// determine the num of ad unit sizes that were used in corresponding bid request
+// if num_adunit_sizes == 1, assign the height and/or width to bid's height/width
+// if num_adunit_sizes > 1, reject the bid (remove from list) and return an error
+// return updated bid list object for next steps in auction
+func checkForValidBidSize(bids pbs.PBSBidSlice, bidder *pbs.PBSBidder) pbs.PBSBidSlice {
+	finalValidBids := make([]*pbs.PBSBid, len(bids))
+	finalBidCounter := 0
+bidLoop:
+	for _, bid := range bids {
+		if bid.CreativeMediaType == "banner" && (bid.Height == 0 || bid.Width == 0) {
+			for _, adunit := range bidder.AdUnits {
+				if adunit.BidID == bid.BidID && adunit.Code == bid.AdUnitCode {
+					if len(adunit.Sizes) == 1 {
+						bid.Width, bid.Height = adunit.Sizes[0].W, adunit.Sizes[0].H
+						finalValidBids[finalBidCounter] = bid
+						finalBidCounter = finalBidCounter + 1
+					} else if len(adunit.Sizes) > 1 {
+						glog.Warningf("Bid was rejected for bidder %s because no size was defined", bid.BidderCode)
+					}
+					continue bidLoop
+				}
+			}
+		} else {
+			finalValidBids[finalBidCounter] = bid
+			finalBidCounter = finalBidCounter + 1
+		}
+	}
+	return finalValidBids[:finalBidCounter]
+}
+
+// validateAliases checks that each of the request's aliases has a code of its own, and aliases a supported bidder.
+// Otherwise an alias could take over a bidder's ad units.
+func validateAliases(aliases map[string]string) error {
+	for alias, core := range aliases {
+		if _, ok := exchanges[alias]; ok {
+			return fmt.Errorf("the alias %s can't reuse a bidder's code", alias)
+		}
+		if _, ok := exchanges[core]; !ok {
+			return fmt.Errorf("the alias %s is for the unsupported bidder %s", alias, core)
+		}
+	}
+	return nil
+}
+
+// validateAdUnitParams returns the bidder's ad units whose params match its schema, and an error for each of the others.
+// Request aliases are checked against the schema of their core bidder.
+func validateAdUnitParams(bidder *pbs.PBSBidder, coreBidder string) ([]pbs.PBSAdUnit, []error) {
+	var errs []error
+	validUnits := make([]pbs.PBSAdUnit, 0, len(bidder.AdUnits))
+	for _, unit := range bidder.AdUnits {
+		if err := paramsValidator.Validate(coreBidder, unit.Params); err != nil {
+			errs = append(errs, &errortypes.BadInput{Message: fmt.Sprintf("ad unit %s: %v", unit.Code, err)})
+			continue
+		}
+		validUnits = append(validUnits, unit)
+	}
+	return validUnits, errs
+}
+
+// checkNativeBids drops the native bids whose adm isn't valid markup for their ad unit's native request.
+func checkNativeBids(bids pbs.PBSBidSlice, bidder *pbs.PBSBidder) pbs.PBSBidSlice {
+	validBids := make(pbs.PBSBidSlice, 0, len(bids))
+bidLoop:
+	for _, bid := range bids {
+		if bid.CreativeMediaType != "native" {
+			validBids = append(validBids, bid)
+			continue
+		}
+		for _, adunit := range bidder.AdUnits {
+			if adunit.BidID == bid.BidID && adunit.Code == bid.AdUnitCode {
+				_, native, err := adunit.NativeRequest()
+				if err == nil {
+					err = pbs.ValidateNativeResponse(bid.Adm, native)
+				}
+				if err != nil {
+					glog.Warningf("Native bid was rejected for bidder %s: %v", bid.BidderCode, err)
+					continue bidLoop
+				}
+				validBids = append(validBids, bid)
+				continue bidLoop
+			}
+		}
+	}
+	return validBids
+}
+
+// recordOriginalPrices saves the price of each bid as the bidder returned it, so that it can still be
+// reconciled against the bidder's reports after we adjust or convert it. Adapters which know the
+// response currency set this themselves. Everything else is in US Dollars.
+func recordOriginalPrices(bids pbs.PBSBidSlice) {
+	for _, bid := range bids {
+		if bid.Ext == nil {
+			bid.Ext = &pbs.PBSBidExt{
+				OrigBidCPM: bid.Price,
+				OrigBidCur: "USD",
+			}
+		}
+	}
+}
+
+// checkBidCurrencies converts every bid into USD, and enforces the currencies which the bidder is allowed
+// to bid in, if the host configured any. Bids in any other currency, or which can't be converted, are rejected.
+func checkBidCurrencies(bids pbs.PBSBidSlice, allowed []string, converter *currency.Converter) (pbs.PBSBidSlice, []error) {
+	var errs []error
+	validBids := make(pbs.PBSBidSlice, 0, len(bids))
+	for _, bid := range bids {
+		cur := bid.Ext.OrigBidCur
+		isAllowed := len(allowed) == 0
+		for _, allowedCur := range allowed {
+			if strings.EqualFold(cur, allowedCur) {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			errs = append(errs, &errortypes.Warning{
+				Message:     fmt.Sprintf("Bid %s was rejected because %s isn't one of the bidder's allowed currencies: %s", bid.BidID, cur, strings.Join(allowed, ", ")),
+				WarningCode: errortypes.BidCurrencyWarningCode,
+			})
+			continue
+		}
+		price, err := converter.Convert(bid.Ext.OrigBidCPM, cur, "USD")
+		if err != nil {
+			errs = append(errs, &errortypes.Warning{
+				Message:     fmt.Sprintf("Bid %s was rejected because its %s price couldn't be converted to USD: %v", bid.BidID, cur, err),
+				WarningCode: errortypes.BidCurrencyWarningCode,
+			})
+			continue
+		}
+		bid.Price = price
+		validBids = append(validBids, bid)
+	}
+	return validBids, errs
+}
+
+// checkBidMeta makes sure that every bid has a Meta object, so that publishers get consistent
+// transparency data across all bidders. If the account requires advertiser domains, then bids
+// which don't declare any will be rejected.
+func checkBidMeta(bids pbs.PBSBidSlice, requireAdvertiserDomains bool) pbs.PBSBidSlice {
+	validBids := make(pbs.PBSBidSlice, 0, len(bids))
+	for _, bid := range bids {
+		if bid.Meta == nil {
+			bid.Meta = &pbs.PBSBidMeta{}
+		}
+		if bid.Meta.MediaType == "" {
+			bid.Meta.MediaType = bid.CreativeMediaType
+		}
+		if requireAdvertiserDomains && len(bid.Meta.AdvertiserDomains) == 0 {
+			glog.Warningf("Bid was rejected for bidder %s because it has no advertiser domains", bid.BidderCode)
+			continue
+		}
+		validBids = append(validBids, bid)
+	}
+	return validBids
+}
+
+// checkBlockedDomains drops the bids from advertisers whose domains are blocked for the bidder or account.
+// It should run after checkBidMeta, which makes sure that every bid has a Meta object.
+func checkBlockedDomains(bids pbs.PBSBidSlice, blocked blocklist.Blocked) pbs.PBSBidSlice {
+	if len(blocked.Domains) == 0 {
+		return bids
+	}
+	validBids := make(pbs.PBSBidSlice, 0, len(bids))
+	for _, bid := range bids {
+		if blocked.BlocksDomain(bid.Meta.AdvertiserDomains) {
+			glog.Warningf("Bid was rejected for bidder %s because its advertiser domain is blocked", bid.BidderCode)
+			continue
+		}
+		validBids = append(validBids, bid)
+	}
+	return validBids
+}
+
+// enforceMinWinningCPM drops the bids on the ad units whose top bid is below the account's minimum CPM, since
+// any of them would win below it. Ad units with a winner above the minimum keep all of their bids. It returns
+// the bids which are left, and the ones which were dropped.
+func enforceMinWinningCPM(bids pbs.PBSBidSlice, minCPM float64, pbs_req *pbs.PBSRequest) (pbs.PBSBidSlice, []analytics.RejectedBid) {
+	topPrices := make(map[string]float64)
+	for _, bid := range bids {
+		if bid.Price > topPrices[bid.AdUnitCode] {
+			topPrices[bid.AdUnitCode] = bid.Price
+		}
+	}
+	belowMin := 0
+	for _, price := range topPrices {
+		if price < minCPM {
+			belowMin++
+		}
+	}
+	if belowMin == 0 {
+		return bids, nil
+	}
+	mMinWinningCPMMeter.Mark(int64(belowMin))
+
+	validBids := make(pbs.PBSBidSlice, 0, len(bids))
+	var rejected []analytics.RejectedBid
+	for _, bid := range bids {
+		if topPrices[bid.AdUnitCode] >= minCPM {
+			validBids = append(validBids, bid)
+			continue
+		}
+		adapterMetrics[pbs_req.CoreBidder(bid.BidderCode)].markRejected(analytics.RejectedBelowMinCPM, 1)
+		rejected = append(rejected, analytics.RejectedBid{Bid: bid, Reason: analytics.RejectedBelowMinCPM})
+	}
+	return validBids, rejected
+}
+
+// rejectedBids returns the bids which a validator dropped, given the bids before and after it ran,
+// and counts them in the core bidder's rejected_bids metrics.
+func rejectedBids(ametrics *AdapterMetrics, before pbs.PBSBidSlice, after pbs.PBSBidSlice, reason string) []analytics.RejectedBid {
+	if len(before) == len(after) {
+		return nil
+	}
+	kept := make(map[*pbs.PBSBid]bool, len(after))
+	for _, bid := range after {
+		kept[bid] = true
+	}
+	var rejected []analytics.RejectedBid
+	for _, bid := range before {
+		if !kept[bid] {
+			rejected = append(rejected, analytics.RejectedBid{Bid: bid, Reason: reason})
+		}
+	}
+	ametrics.markRejected(reason, len(rejected))
+	return rejected
+}
+
+// markRejected counts bids which were dropped for the given reason. It does nothing for bidders
+// without adapter metrics.
+func (a *AdapterMetrics) markRejected(reason string, count int) {
+	if a == nil {
+		return
+	}
+	if meter, ok := a.RejectedBidMeters[reason]; ok {
+		meter.Mark(int64(count))
+	}
+}
+
+// checkRendererHints drops renderer choices which point at renderers the SDK didn't declare, so that
+// the SDK falls back to its default renderer instead of failing to display the ad.
+func checkRendererHints(bids pbs.PBSBidSlice, sdk *pbs.SDK) {
+	for _, bid := range bids {
+		if bid.Meta == nil || bid.Meta.RendererName == "" || sdk.HasRenderer(bid.Meta.RendererName) {
+			continue
+		}
+		glog.Warningf("Bidder %s chose renderer %s, which the SDK doesn't support", bid.BidderCode, bid.Meta.RendererName)
+		bid.Meta.RendererName = ""
+		bid.Meta.RendererVersion = ""
+		bid.Meta.RendererData = nil
+	}
+}
+
+// channelMetricName keeps the channel metrics to the known integrations.
+func channelMetricName(channel string) string {
+	switch channel {
+	case pbs.ChannelPrebidJS, pbs.ChannelAMP, pbs.ChannelApp, pbs.ChannelVideo:
+		return channel
+	}
+	return "other"
+}
+
+// The SDK versions which get their own metrics. Clients can send any version, so anything past these is
+// counted as "other".
+const (
+	maxSDKMetricMajor = 4
+	maxSDKMetricMinor = 30
+)
+
+// sdkMetricName buckets app requests by Prebid Mobile platform and major.minor version.
+// Unrecognized values are grouped together, to keep the number of metrics bounded.
+func sdkMetricName(sdk *pbs.SDK) string {
+	platform := strings.ToLower(sdk.Platform)
+	if platform != "ios" && platform != "android" {
+		platform = "other"
+	}
+	version := "unknown"
+	if v, err := semver.Make(sdk.Version); err == nil {
+		if v.Major <= maxSDKMetricMajor && v.Minor <= maxSDKMetricMinor {
+			version = fmt.Sprintf("%d_%d", v.Major, v.Minor)
+		} else {
+			version = "other"
+		}
+	}
+	return fmt.Sprintf("sdk.%s.%s.requests", platform, version)
+}
+
+// resolveBidMacros fills in the OpenRTB macros in the creatives of bidders which rely on the exchange to do so.
+// This must happen before the bids are cached, so that the cached markup is complete.
+func resolveBidMacros(bids pbs.PBSBidSlice, auctionID string, cfg map[string]config.BidderMacros, encrypters map[string]pbs.PriceEncrypter) {
+	for _, bid := range bids {
+		bidder := strings.ToLower(bid.BidderCode)
+		if !cfg[bidder].Enabled {
+			continue
+		}
+		if err := pbs.ResolveBidMacros(bid, auctionID, encrypters[bidder]); err != nil {
+			glog.Warningf("Failed to encrypt the price macros for bidder %s: %v", bid.BidderCode, err)
+		}
+	}
+}
+
+// injectTrackers adds the account's trackers to the markup of each bid, along with one for this server's /event
+// endpoint if the account has events enabled. This has to happen before the markup is cached.
+func injectTrackers(bids pbs.PBSBidSlice, accountID string, events config.AccountEvents, externalURL string, auctionTime time.Time) {
+	trackerURLs := events.TrackerURLs()
+	if events.Enabled {
+		trackerURLs = append([]string{pbs.EventURL(externalURL)}, trackerURLs...)
+	}
+	if len(trackerURLs) == 0 {
+		return
+	}
+	for _, bid := range bids {
+		for _, trackerURL := range trackerURLs {
+			if !pbs.InjectTracker(bid, pbs.ResolveTrackerURL(trackerURL, accountID, bid.BidderCode, auctionTime)) {
+				glog.V(2).Infof("Couldn't add a tracker to the %s bid from %s", bid.CreativeMediaType, bid.BidderCode)
+				break
+			}
+		}
+	}
+}
+
+// attachDemandChains adds a demand chain to the winning bid of each ad unit, so that verification vendors
+// can audit the path which it took. Bidder-provided chains are extended with the bidder and host nodes.
+func attachDemandChains(bids pbs.PBSBidSlice, pbs_req *pbs.PBSRequest, registry *adapters.BidderRegistry, hostNode pbs.DemandChainNode) {
+	code_bids := make(map[string]pbs.PBSBidSlice, len(bids))
+	for _, bid := range bids {
+		code_bids[bid.AdUnitCode] = append(code_bids[bid.AdUnitCode], bid)
+	}
+
+	for _, unitBids := range code_bids {
+		bid := unitBids[auctionWinner(unitBids, pbs_req)]
+		if bid.Meta == nil {
+			bid.Meta = &pbs.PBSBidMeta{}
+		}
+		bidderNode := pbs.DemandChainNode{
+			ASI:  registry.ASI(pbs_req.CoreBidder(bid.BidderCode)),
+			Name: bid.BidderCode,
+		}
+		dchain, err := pbs.BuildDemandChain(bid.Meta.DChain, bidderNode, hostNode)
+		if err != nil {
+			glog.Warningf("Bidder %s sent an invalid dchain. It will be replaced: %v", bid.BidderCode, err)
+			dchain, err = pbs.BuildDemandChain(nil, bidderNode, hostNode)
+			if err != nil {
+				continue
+			}
+		}
+		bid.Meta.DChain = dchain
+	}
+}
+
+// auctionWinner returns the index of the winning bid, out of bids for the same ad unit. Ties are broken by
+// the tieBreaker, which is seeded by the request's tid in test mode so that test auctions are repeatable.
+func auctionWinner(bids pbs.PBSBidSlice, pbs_req *pbs.PBSRequest) int {
+	candidates := make([]pbs.TieBreakBid, len(bids))
+	for i, bid := range bids {
+		candidates[i] = pbs.TieBreakBid{
+			Price:        bid.Price,
+			Bidder:       bid.BidderCode,
+			DealID:       bid.DealId,
+			ResponseTime: bid.ResponseTime,
+		}
+	}
+	seed := ""
+	if pbs_req.Test == 1 {
+		seed = pbs_req.Tid + ":" + bids[0].AdUnitCode
+	}
+	return tieBreaker.Winner(candidates, seed)
+}
+
+// maxTargetingKeys returns the limit on each ad unit's targeting keys. Requests can lower the account's
+// limit, but not raise it. Zero means no limit.
+func maxTargetingKeys(accountMax int, targeting *pbs.PBSTargeting) int {
+	if targeting == nil || targeting.MaxKeys <= 0 {
+		return accountMax
+	}
+	if accountMax > 0 && accountMax < targeting.MaxKeys {
+		return accountMax
+	}
+	return targeting.MaxKeys
+}
+
+// sortBidsAddKeywordsMobile sorts the bids and adds ad server targeting keywords to each bid.
+// The bids are sorted by cpm to find the highest bid.
+// The ad server targeting keywords are added to all bids, with specific keywords for the highest bid.
+// Cached bids also get the cache host and path, if they're given.
+// If maxKeys is positive, the keys of each ad unit are trimmed to fit, and a warning is returned for each one which was.
+func sortBidsAddKeywordsMobile(bids pbs.PBSBidSlice, pbs_req *pbs.PBSRequest, priceGranularitySetting string, cacheHost string, cachePath string, maxKeys int) []error {
+	if priceGranularitySetting == "" {
+		priceGranularitySetting = defaultPriceGranularity
+	}
+
+	// record bids by ad unit code for sorting
+	code_bids := make(map[string]pbs.PBSBidSlice, len(bids))
+	for _, bid := range bids {
+		code_bids[bid.AdUnitCode] = append(code_bids[bid.AdUnitCode], bid)
+	}
+
+	targeting := pbs_req.Targeting()
+	var warnings []error
+
+	// loop through ad units to find top bid
+	for _, unit := range pbs_req.AdUnits {
+		bar := code_bids[unit.Code]
+
+		if len(bar) == 0 {
+			if glog.V(3) {
+				glog.Infof("No bids for ad unit '%s'", unit.Code)
+			}
+			continue
+		}
+		sort.Sort(bar)
+		// The sort only orders by price and response time, so the winner is moved to the front.
+		if winner := auctionWinner(bar, pbs_req); winner > 0 {
+			winningBid := bar[winner]
+			copy(bar[1:winner+1], bar[:winner])
+			bar[0] = winningBid
+		}
+
+		// after sorting we need to add the ad targeting keywords
+		for i, bid := range bar {
+			isWinner := i == 0 && targeting.WinnerKeys()
+			wantsBidderKeys := targeting.BidderKeys() || (bid.DealId != "" && targeting.DealKeys())
+			if !isWinner && !wantsBidderKeys {
+				continue
+			}
+
+			priceBucketStringMap := pbs.GetPriceBucketString(bid.Price)
+			roundedCpm := priceBucketStringMap[priceGranularitySetting]
+
+			hbSize := ""
+			if bid.Width != 0 && bid.Height != 0 {
+				width := strconv.FormatUint(bid.Width, 10)
+				height := strconv.FormatUint(bid.Height, 10)
+				hbSize = width + "x" + height
+			}
+
+			hbPbBidderKey := hbpbConstantKey + "_" + bid.BidderCode
+			hbBidderBidderKey := hbBidderConstantKey + "_" + bid.BidderCode
+			hbCacheIdBidderKey := hbCacheIdConstantKey + "_" + bid.BidderCode
+			hbSizeBidderKey := hbSizeConstantKey + "_" + bid.BidderCode
+			hbDealBidderKey := hbDealConstantKey + "_" + bid.BidderCode
+			hbCacheHostBidderKey := hbCacheHostConstantKey + "_" + bid.BidderCode
+			hbCachePathBidderKey := hbCachePathConstantKey + "_" + bid.BidderCode
+			if pbs_req.MaxKeyLength != 0 {
+				hbPbBidderKey = hbPbBidderKey[:min(len(hbPbBidderKey), int(pbs_req.MaxKeyLength))]
+				hbBidderBidderKey = hbBidderBidderKey[:min(len(hbBidderBidderKey), int(pbs_req.MaxKeyLength))]
+				hbCacheIdBidderKey = hbCacheIdBidderKey[:min(len(hbCacheIdBidderKey), int(pbs_req.MaxKeyLength))]
+				hbSizeBidderKey = hbSizeBidderKey[:min(len(hbSizeBidderKey), int(pbs_req.MaxKeyLength))]
+				hbDealBidderKey = hbDealBidderKey[:min(len(hbDealBidderKey), int(pbs_req.MaxKeyLength))]
+				hbCacheHostBidderKey = hbCacheHostBidderKey[:min(len(hbCacheHostBidderKey), int(pbs_req.MaxKeyLength))]
+				hbCachePathBidderKey = hbCachePathBidderKey[:min(len(hbCachePathBidderKey), int(pbs_req.MaxKeyLength))]
+			}
+			hasCacheLocation := bid.CacheID != "" && cacheHost != ""
+			pbs_kvs := make(map[string]string)
+			if wantsBidderKeys {
+				pbs_kvs[hbPbBidderKey] = roundedCpm
+				pbs_kvs[hbBidderBidderKey] = bid.BidderCode
+				pbs_kvs[hbCacheIdBidderKey] = bid.CacheID
+				if hasCacheLocation {
+					pbs_kvs[hbCacheHostBidderKey] = cacheHost
+					pbs_kvs[hbCachePathBidderKey] = cachePath
+				}
+				if hbSize != "" {
+					pbs_kvs[hbSizeBidderKey] = hbSize
+				}
+				if bid.DealId != "" && targeting.DealKeys() {
+					pbs_kvs[hbDealBidderKey] = bid.DealId
+				}
+			}
+			// For the top bid, we want to add the following additional keys
+			if isWinner {
+				pbs_kvs[hbpbConstantKey] = roundedCpm
 				pbs_kvs[hbBidderConstantKey] = bid.BidderCode
 				pbs_kvs[hbCacheIdConstantKey] = bid.CacheID
+				if hasCacheLocation {
+					pbs_kvs[hbCacheHostConstantKey] = cacheHost
+					pbs_kvs[hbCachePathConstantKey] = cachePath
+				}
 				if hbSize != "" {
 					pbs_kvs[hbSizeConstantKey] = hbSize
 				}
@@ -508,37 +2098,279 @@ func sortBidsAddKeywordsMobile(bids pbs.PBSBidSlice, pbs_req *pbs.PBSRequest, pr
 			}
 			bid.AdServerTargeting = pbs_kvs
 		}
+		if maxKeys > 0 {
+			if dropped := trimTargetingKeys(bar, targeting.WinnerKeys(), maxKeys); dropped > 0 {
+				warnings = append(warnings, &errortypes.Warning{
+					Message:     fmt.Sprintf("%d targeting keys were dropped from ad unit %s to stay within the limit of %d", dropped, unit.Code, maxKeys),
+					WarningCode: errortypes.TargetingKeysWarningCode,
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// targetingKeyPriority lists the targeting keys from least to most important.
+var targetingKeyPriority = []string{
+	hbCachePathConstantKey,
+	hbCacheHostConstantKey,
+	hbCreativeLoadMethodConstantKey,
+	hbSizeConstantKey,
+	hbCacheIdConstantKey,
+	hbDealConstantKey,
+	hbBidderConstantKey,
+	hbpbConstantKey,
+}
+
+// keyPriority ranks a targeting key by the longest key in targetingKeyPriority which it starts with.
+// Bidder keys may have been cut short by max_key_length, so ones which don't match anything rank lowest.
+func keyPriority(key string) int {
+	priority, matched := -1, 0
+	for i, prefix := range targetingKeyPriority {
+		if strings.HasPrefix(key, prefix) && len(prefix) > matched {
+			priority, matched = i, len(prefix)
+		}
+	}
+	return priority
+}
+
+// trimTargetingKeys drops keys from an ad unit's bids until there are at most maxKeys, and returns how many
+// it dropped. The bids must be sorted, best first. Bidder keys go first, starting with the lowest-ranked bid,
+// and the winner keys go last. Within each bid, keys are dropped in targetingKeyPriority order.
+func trimTargetingKeys(bids pbs.PBSBidSlice, hasWinner bool, maxKeys int) int {
+	total := 0
+	for _, bid := range bids {
+		total += len(bid.AdServerTargeting)
+	}
+	excess := total - maxKeys
+	if excess <= 0 {
+		return 0
+	}
+
+	type targetingKey struct {
+		bid *pbs.PBSBid
+		key string
+	}
+	sortKeys := func(keys []targetingKey) {
+		sort.Slice(keys, func(i, j int) bool {
+			pi, pj := keyPriority(keys[i].key), keyPriority(keys[j].key)
+			return pi < pj || (pi == pj && keys[i].key < keys[j].key)
+		})
+	}
+	isWinnerKey := func(key string) bool {
+		for _, winnerKey := range targetingKeyPriority {
+			if key == winnerKey {
+				return true
+			}
+		}
+		return false
+	}
+
+	var dropOrder, winnerKeys []targetingKey
+	for i := len(bids) - 1; i >= 0; i-- {
+		var bidderKeys []targetingKey
+		for key := range bids[i].AdServerTargeting {
+			if i == 0 && hasWinner && isWinnerKey(key) {
+				winnerKeys = append(winnerKeys, targetingKey{bids[i], key})
+			} else {
+				bidderKeys = append(bidderKeys, targetingKey{bids[i], key})
+			}
+		}
+		sortKeys(bidderKeys)
+		dropOrder = append(dropOrder, bidderKeys...)
+	}
+	sortKeys(winnerKeys)
+	dropOrder = append(dropOrder, winnerKeys...)
+
+	for _, k := range dropOrder[:excess] {
+		delete(k.bid.AdServerTargeting, k.key)
+	}
+	return excess
+}
+
+func status(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// could add more logic here, but doing nothing means 200 OK
+	if len(refreshers) == 0 {
+		return
+	}
+
+	statuses := make([]refresher.Status, 0, len(refreshers))
+	for _, ref := range refreshers {
+		statuses = append(statuses, ref.Status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]refresher.Status{"refreshers": statuses})
+}
+
+type eventDeps struct {
+	getAccount func(id string) config.Account
+}
+
+// event counts an impression of a bid from an account with events enabled. injectTrackers adds the trackers which call it.
+func (deps *eventDeps) event(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	if eventType := query.Get("t"); eventType != "imp" {
+		http.Error(w, fmt.Sprintf("Unsupported event type %q", eventType), http.StatusBadRequest)
+		return
+	}
+	accountID := query.Get("a")
+	if accountID == "" {
+		http.Error(w, "Missing account ID", http.StatusBadRequest)
+		return
+	}
+	// Only configured accounts can have events enabled, which keeps the account meters bounded.
+	if !deps.getAccount(accountID).Events.Enabled {
+		http.Error(w, fmt.Sprintf("Account %s doesn't have events enabled", accountID), http.StatusUnauthorized)
+		return
+	}
+	mImpEventMeter.Mark(1)
+	getAccountMetrics(accountID).ImpressionEventMeter.Mark(1)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type currencyRatesDeps struct {
+	converter *currency.Converter
+}
+
+type currencyRatesResponse struct {
+	Status refresher.Status `json:"status"`
+	Rates  *currency.Rates  `json:"rates"`
+}
+
+// currencyRates is an admin endpoint which dumps the rates that bid prices are being converted with.
+func (deps *currencyRatesDeps) currencyRates(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if deps.converter == nil {
+		http.Error(w, "Currency conversion isn't configured", http.StatusNotFound)
+		return
+	}
+	rates, status := deps.converter.Rates()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&currencyRatesResponse{Status: status, Rates: rates})
+}
+
+type bidderRegistryDeps struct {
+	registry *adapters.BidderRegistry
+}
+
+type bidderRegistryResponse struct {
+	Bidders []adapters.RegisteredBidder `json:"bidders"`
+}
+
+// bidderRegistry is an admin endpoint which dumps every bidder code the host serves, with the alias and
+// capability decisions which were made for it at startup.
+func (deps *bidderRegistryDeps) bidderRegistry(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&bidderRegistryResponse{Bidders: deps.registry.Bidders()})
+}
+
+// debugOverrideHeader carries the host's debug_override_token, to turn on debug output for a single request.
+const debugOverrideHeader = "x-pbs-debug-override"
+
+// auctionTimeHeader tells the page how many milliseconds the auction took.
+const auctionTimeHeader = "x-prebid-auction-time"
+
+// hasDebugOverride returns true if the request sent the host's debug override token.
+func hasDebugOverride(r *http.Request, token string) bool {
+	sent := r.Header.Get(debugOverrideHeader)
+	return token != "" && sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// requireAdminToken only lets requests through if they send the admin token as a bearer token.
+func requireAdminToken(token string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handle(w, r, ps)
 	}
 }
 
-func status(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	// could add more logic here, but doing nothing means 200 OK
+type cachesResponse struct {
+	DataCache  *cache.Stats       `json:"datacache,omitempty"`
+	Refreshers []refresher.Status `json:"refreshers"`
+}
+
+// inspectCaches is an admin endpoint which reports on the in-memory data cache and the background data.
+func inspectCaches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	resp := cachesResponse{Refreshers: make([]refresher.Status, 0, len(refreshers))}
+	if inspector, ok := dataCache.(cache.Inspector); ok {
+		stats := inspector.Stats()
+		resp.DataCache = &stats
+	}
+	for _, ref := range refreshers {
+		resp.Refreshers = append(resp.Refreshers, ref.Status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// invalidateDataCache is an admin endpoint which drops an account or stored config from the data cache,
+// so that a fix in the backing store is picked up without a restart.
+func invalidateDataCache(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	inspector, ok := dataCache.(cache.Inspector)
+	if !ok {
+		http.Error(w, "The data cache doesn't keep anything in memory", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"invalidated": inspector.Invalidate(ps.ByName("id"))})
+}
+
+// refreshNow is an admin endpoint which refetches background data, like currency rates, right away.
+func refreshNow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	for _, ref := range refreshers {
+		if ref.Name() != ps.ByName("name") {
+			continue
+		}
+		if err := ref.Refresh(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to refresh %s: %v", ref.Name(), err), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ref.Status())
+		return
+	}
+	http.Error(w, fmt.Sprintf("Unknown refresher: %q", ps.ByName("name")), http.StatusNotFound)
+}
+
+// compactForTargeting strips the response down to the ad server targeting keys of each bid.
+// Bids which didn't get any keys are dropped entirely.
+func compactForTargeting(resp *pbs.PBSResponse) {
+	compactBids := make(pbs.PBSBidSlice, 0, len(resp.Bids))
+	for _, bid := range resp.Bids {
+		if len(bid.AdServerTargeting) == 0 {
+			continue
+		}
+		compactBids = append(compactBids, &pbs.PBSBid{
+			BidID:             bid.BidID,
+			AdUnitCode:        bid.AdUnitCode,
+			BidderCode:        bid.BidderCode,
+			Price:             bid.Price,
+			CacheID:           bid.CacheID,
+			AdServerTargeting: bid.AdServerTargeting,
+		})
+	}
+	resp.Bids = compactBids
+	resp.BidderStatus = nil
 }
 
-// NewJsonDirectoryServer is used to serve .json files from a directory as a single blob. For example,
-// given a directory containing the files "a.json" and "b.json", this returns a Handle which serves JSON like:
+// NewBidderParamsServer serves the JSON schemas for the bidders' params as a single blob, keyed by bidder code:
 //
 // {
-//   "a": { ... content from the file a.json ... },
-//   "b": { ... content from the file b.json ... }
+//   "appnexus": { ... the schema for appnexus params ... },
+//   "districtm": { ... districtm uses the appnexus schema ... }
 // }
 //
-// This function stores the file contents in memory, and should not be used on large directories.
-// If the root directory, or any of the files in it, cannot be read, then the program will exit.
-func NewJsonDirectoryServer(schemaDirectory string) httprouter.Handle {
-	// Slurp the files into memory first, since they're small and it minimizes request latency.
-	files, err := ioutil.ReadDir(schemaDirectory)
-	if err != nil {
-		glog.Fatalf("Failed to read directory %s: %v", schemaDirectory, err)
-	}
-
-	data := make(map[string]json.RawMessage, len(files))
-	for _, file := range files {
-		bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", schemaDirectory, file.Name()))
-		if err != nil {
-			glog.Fatalf("Failed to read file %s/%s: %v", schemaDirectory, file.Name(), err)
+// These are the same schemas which the validator checks params against. Bidders without a schema are left out.
+// The response is built once, since neither the bidders nor their schemas change while the server runs.
+func NewBidderParamsServer(validator *adapters.ParamsValidator, bidderCodes []string) httprouter.Handle {
+	data := make(map[string]json.RawMessage, len(bidderCodes))
+	for _, code := range bidderCodes {
+		if schema := validator.Schema(code); schema != nil {
+			data[code] = schema
 		}
-		data[file.Name()[0:len(file.Name())-5]] = json.RawMessage(bytes)
 	}
 	response, err := json.Marshal(data)
 	if err != nil {
@@ -551,6 +2383,46 @@ func NewJsonDirectoryServer(schemaDirectory string) httprouter.Handle {
 	}
 }
 
+// NewBiddersInfoEndpoint serves the sorted codes of the bidders which have info at /info/bidders/{bidder}.
+func NewBiddersInfoEndpoint(infos adapters.BidderInfos) httprouter.Handle {
+	codes := make([]string, 0, len(infos))
+	for code := range infos {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	response, err := json.Marshal(codes)
+	if err != nil {
+		glog.Fatalf("Failed to marshal the bidder codes: %v", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+// NewBidderInfoEndpoint serves the maintainer, capabilities and endpoint compression of the bidder named in the path.
+func NewBidderInfoEndpoint(infos adapters.BidderInfos) httprouter.Handle {
+	responses := make(map[string][]byte, len(infos))
+	for code, info := range infos {
+		response, err := json.Marshal(info)
+		if err != nil {
+			glog.Fatalf("Failed to marshal the info for bidder %s: %v", code, err)
+		}
+		responses[code] = response
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		response, ok := responses[ps.ByName("bidderName")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown bidder: %s", ps.ByName("bidderName")), http.StatusNotFound)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
 func serveIndex(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	http.ServeFile(w, r, "static/index.html")
 }
@@ -597,6 +2469,195 @@ func getIP(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 
 }
 
+type dryRunResponse struct {
+	Bidder   string                   `json:"bidder"`
+	Requests []adapters.DryRunRequest `json:"requests"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// dryRun is an admin endpoint which runs a single bidder's adapter against the /auction request in the body,
+// and returns the HTTP requests it would have sent to the bidder. Nothing is sent to the bidder.
+func dryRun(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Add("Content-Type", "application/json")
+
+	bidderCode := r.URL.Query().Get("bidder")
+	ex, ok := exchanges[bidderCode]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported bidder: %q", bidderCode), http.StatusBadRequest)
+		return
+	}
+
+	pbs_req, err := pbs.ParsePBSRequest(r, dataCache, &hostCookieSettings)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var bidder *pbs.PBSBidder
+	for _, b := range pbs_req.Bidders {
+		if b.BidderCode == bidderCode {
+			bidder = b
+		}
+	}
+	if bidder == nil {
+		http.Error(w, fmt.Sprintf("The request has no ad units for %s", bidderCode), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(pbs_req.TimeoutMillis))
+	defer cancel()
+	ctx, recorder := adapters.WithDryRun(ctx)
+
+	resp := dryRunResponse{Bidder: bidderCode}
+	if _, err := callAdapter(ctx, ex, pbs_req, bidder); err != nil {
+		resp.Error = err.Error()
+	}
+	resp.Requests = recorder.Requests()
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(resp)
+}
+
+// monitoringDeps runs synthetic auctions, so that black-box monitors can check the whole /auction
+// pipeline rather than just the process. Every ad unit goes to the debug bidder, so nothing is sent
+// to real bidders or Prebid Cache. The auctions still count in the request metrics.
+type monitoringDeps struct {
+	auction *auctionDeps
+	cfg     config.Monitoring
+}
+
+// newMonitoringDeps runs synthetic auctions with the same dependencies as real ones, except that
+// they aren't sent to analytics.
+func newMonitoringDeps(auction *auctionDeps, cfg config.Monitoring) *monitoringDeps {
+	synthetic := *auction
+	synthetic.analytics = analyticsConf.NewPBSAnalytics(&config.Analytics{})
+	return &monitoringDeps{
+		auction: &synthetic,
+		cfg:     cfg,
+	}
+}
+
+type monitoringResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Bids   int    `json:"bids"`
+	// Stages holds the latency of each part of the auction, in milliseconds.
+	Stages map[string]int64 `json:"stages_ms"`
+}
+
+// cannedAuction returns the body of the synthetic /auction request. If there's a stored config,
+// the ad unit loads its bidders from it, so the data cache gets checked too.
+func (deps *monitoringDeps) cannedAuction() ([]byte, error) {
+	unit := pbs.AdUnit{
+		Code:  "monitoring",
+		Sizes: []openrtb.Format{{W: 300, H: 250}},
+	}
+	if deps.cfg.ConfigID != "" {
+		unit.ConfigID = deps.cfg.ConfigID
+	} else {
+		unit.Bids = []pbs.Bids{{BidderCode: "debug", BidID: "monitoring"}}
+	}
+	return json.Marshal(&pbs.PBSRequest{
+		AccountID:     deps.cfg.AccountID,
+		Tid:           "monitoring",
+		TimeoutMillis: deps.cfg.TimeoutMillis,
+		AdUnits:       []pbs.AdUnit{unit},
+	})
+}
+
+func (deps *monitoringDeps) newRequest(body []byte) *http.Request {
+	req := httptest.NewRequest("POST", "/auction", bytes.NewReader(body))
+	req.Header.Set("Referer", deps.auction.cfg.ExternalURL)
+	return req
+}
+
+// syntheticAuction is an admin endpoint which runs the canned auction, and reports how long each stage took.
+// It returns a 503 if the auction fails or the debug bidder doesn't bid.
+func (deps *monitoringDeps) syntheticAuction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	start := time.Now()
+	resp := monitoringResponse{Status: "ok", Stages: make(map[string]int64)}
+	sinceMillis := func(t time.Time) int64 {
+		return int64(time.Since(t) / time.Millisecond)
+	}
+	fail := func(err error) {
+		resp.Status = "error"
+		resp.Error = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	body, err := deps.cannedAuction()
+	if err == nil {
+		stageStart := time.Now()
+		_, err = pbs.ParsePBSRequest(deps.newRequest(body), dataCache, &hostCookieSettings)
+		resp.Stages["parse"] = sinceMillis(stageStart)
+	}
+	if err != nil {
+		fail(err)
+	} else {
+		stageStart := time.Now()
+		rr := httptest.NewRecorder()
+		deps.auction.auction(rr, deps.newRequest(body), nil)
+		resp.Stages["auction"] = sinceMillis(stageStart)
+
+		var auctionResp pbs.PBSResponse
+		if rr.Code != http.StatusOK {
+			fail(fmt.Errorf("auction returned HTTP status %d: %s", rr.Code, strings.TrimSpace(rr.Body.String())))
+		} else if err := json.Unmarshal(rr.Body.Bytes(), &auctionResp); err != nil {
+			fail(fmt.Errorf("bad auction response: %v", err))
+		} else {
+			for _, bidder := range auctionResp.BidderStatus {
+				resp.Stages["bidder."+bidder.BidderCode] = int64(bidder.ResponseTime)
+			}
+			if resp.Bids = len(auctionResp.Bids); resp.Bids == 0 {
+				fail(fmt.Errorf("the auction had no bids"))
+			}
+		}
+	}
+	resp.Stages["total"] = sinceMillis(start)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// authorizationDeps checks whether publishers have authorized the host and their bidders to sell their inventory.
+type authorizationDeps struct {
+	cfg     *config.Configuration
+	checker *adstxt.Checker
+}
+
+// checkAuthorization is an admin endpoint for onboarding publishers. Given the publisher's domain, account and a
+// comma-separated list of bidders, it reports the gaps in the publisher's ads.txt and the bidders' sellers.json
+// files. Exchanges often drop bids silently when these are missing.
+func (deps *authorizationDeps) checkAuthorization(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	domain := r.URL.Query().Get("domain")
+	bidderCodes := r.URL.Query().Get("bidders")
+	if domain == "" || bidderCodes == "" {
+		http.Error(w, "The domain and bidders query params are required", http.StatusBadRequest)
+		return
+	}
+	if !adstxt.ValidDomain(domain) {
+		http.Error(w, "The domain must be a hostname, like www.publisher.com", http.StatusBadRequest)
+		return
+	}
+
+	host := adstxt.Host{Domain: deps.cfg.SChain.Domain}
+	if schain := deps.cfg.GetSChain(r.URL.Query().Get("account")); schain.Enabled {
+		host.ASI, host.SID = schain.ASI, schain.SID
+	}
+	bidders := make(map[string]adstxt.Bidder)
+	for _, bidderCode := range strings.Split(bidderCodes, ",") {
+		bidderCode = strings.TrimSpace(bidderCode)
+		adapterConfig := deps.cfg.Adapters[strings.ToLower(bidderCode)]
+		bidders[bidderCode] = adstxt.Bidder{
+			AdSystemDomain: adapterConfig.AdSystemDomain,
+			SellersJSONURL: adapterConfig.SellersJSONURL,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deps.checker.Check(r.Context(), domain, host, bidders))
+}
+
 func validate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Add("Content-Type", "text/plain")
 	defer r.Body.Close()
@@ -645,6 +2706,47 @@ func loadPostgresDataCache(cfg *config.Configuration) (cache.Cache, error) {
 
 }
 
+// measuredCache wraps the data cache, to measure how an endpoint's stored config lookups go.
+// Operators watch the missing counts for misconfigured config IDs, and the fetch times for slow backends.
+type measuredCache struct {
+	cache.Cache
+	configs *measuredConfigs
+}
+
+func (c *measuredCache) Config() cache.ConfigService {
+	return c.configs
+}
+
+type measuredConfigs struct {
+	cache.ConfigService
+	prefix string
+}
+
+// Get marks stored_configs.<source>.<endpoint>.found, missing or error, and times the lookup.
+func (c *measuredConfigs) Get(id string) (string, error) {
+	start := time.Now()
+	conf, err := c.ConfigService.Get(id)
+	metrics.GetOrRegisterTimer(c.prefix+".fetch_time", metricsRegistry).UpdateSince(start)
+	outcome := "found"
+	if err == cache.ErrNotFound {
+		outcome = "missing"
+	} else if err != nil {
+		outcome = "error"
+	}
+	metrics.GetOrRegisterMeter(c.prefix+"."+outcome, metricsRegistry).Mark(1)
+	return conf, err
+}
+
+func measureConfigs(dataCache cache.Cache, source string, endpoint string) cache.Cache {
+	return &measuredCache{
+		Cache: dataCache,
+		configs: &measuredConfigs{
+			ConfigService: dataCache.Config(),
+			prefix:        fmt.Sprintf("stored_configs.%s.%s", source, endpoint),
+		},
+	}
+}
+
 func loadDataCache(cfg *config.Configuration) (err error) {
 
 	switch cfg.DataCache.Type {
@@ -672,6 +2774,27 @@ func loadDataCache(cfg *config.Configuration) (err error) {
 	return nil
 }
 
+// warmDataCache loads the configured stored configs into the data cache, and keeps reloading them
+// in the background if an interval is set.
+func warmDataCache(cfg config.DataCache) {
+	if len(cfg.PrewarmConfigIDs) == 0 {
+		return
+	}
+	warm := func() {
+		for id, err := range cache.WarmConfigs(dataCache.Config(), cfg.PrewarmConfigIDs) {
+			glog.Warningf("Failed to pre-warm config '%s': %v", id, err)
+		}
+	}
+	warm()
+	if cfg.PrewarmIntervalSeconds > 0 {
+		go func() {
+			for range time.Tick(time.Duration(cfg.PrewarmIntervalSeconds) * time.Second) {
+				warm()
+			}
+		}()
+	}
+}
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 	viper.SetConfigName("pbs")
@@ -683,15 +2806,59 @@ func init() {
 	viper.SetDefault("admin_port", 6060)
 	viper.SetDefault("default_timeout_ms", 250)
 	viper.SetDefault("datacache.type", "dummy")
+	viper.SetDefault("cache.retry_timeout_ms", 50)
+	viper.SetDefault("bad_response_quarantine.sample_rate", 0.01)
+	viper.SetDefault("bad_response_quarantine.max_bytes", 4096)
+	viper.SetDefault("identity_resolution.timeout_ms", 50)
+	viper.SetDefault("monitoring.account_id", "monitoring")
+	viper.SetDefault("monitoring.timeout_ms", 500)
+	viper.SetDefault("currency_converter.fetch_interval_seconds", 1800)
+	viper.SetDefault("ivt_filter.fetch_interval_seconds", 3600)
+	viper.SetDefault("blocklist.fetch_interval_seconds", 600)
+	viper.SetDefault("gdpr.enabled", true)
+	viper.SetDefault("gdpr.default_value", "0")
+	viper.SetDefault("gdpr.enforce_purposes", []int{1, 2})
+	viper.SetDefault("gdpr.vendor_list.latest_url", "https://vendor-list.consensu.org/v2/vendor-list.json")
+	viper.SetDefault("gdpr.vendor_list.version_url", "https://vendor-list.consensu.org/v2/archives/vendor-list-v%d.json")
+	viper.SetDefault("gdpr.vendor_list.fetch_interval_seconds", 86400)
+	viper.SetDefault("gdpr.vendor_list.on_fetch_failure", "allow")
 	// no metrics configured by default (metrics{host|database|username|password})
 
+	// Every adapter's endpoint, usersync_url and platform_id needs a default, even an empty one, so that
+	// environment variables like PBS_ADAPTERS_APPNEXUS_ENDPOINT can override them.
+	viper.SetDefault("adapters.appnexus.endpoint", "http://ib.adnxs.com/openrtb2")
+	viper.SetDefault("adapters.appnexus.usersync_url", "//ib.adnxs.com/getuid?{{redirect_url}}")
+	viper.SetDefault("adapters.appnexus.platform_id", "")
+	viper.SetDefault("adapters.districtm.endpoint", "http://ib.adnxs.com/openrtb2")
+	viper.SetDefault("adapters.districtm.usersync_url", "//ib.adnxs.com/getuid?{{redirect_url}}")
+	viper.SetDefault("adapters.districtm.platform_id", "")
+	viper.SetDefault("adapters.indexexchange.endpoint", "")
+	viper.SetDefault("adapters.indexexchange.usersync_url", "//ssum-sec.casalemedia.com/usermatchredir?s=184932&cb=https%3A%2F%2Fprebid.adnxs.com%2Fpbs%2Fv1%2Fsetuid%3Fbidder%3DindexExchange%26uid%3D")
+	viper.SetDefault("adapters.indexexchange.platform_id", "")
 	viper.SetDefault("adapters.pubmatic.endpoint", "http://openbid.pubmatic.com/translator?source=prebid-server")
+	viper.SetDefault("adapters.pubmatic.usersync_url", "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect={{redirect_url}}")
+	viper.SetDefault("adapters.pubmatic.platform_id", "")
+	viper.SetDefault("adapters.pulsepoint.endpoint", "http://bid.contextweb.com/header/s/ortb/prebid-s2s")
+	viper.SetDefault("adapters.pulsepoint.usersync_url", "//bh.contextweb.com/rtset?pid=561205&ev=1&rurl={{redirect_url}}")
+	viper.SetDefault("adapters.pulsepoint.platform_id", "")
 	viper.SetDefault("adapters.rubicon.endpoint", "http://staged-by.rubiconproject.com/a/api/exchange.json")
 	viper.SetDefault("adapters.rubicon.usersync_url", "https://pixel.rubiconproject.com/exchange/sync.php?p=prebid")
-	viper.SetDefault("adapters.pulsepoint.endpoint", "http://bid.contextweb.com/header/s/ortb/prebid-s2s")
-	viper.SetDefault("adapters.index.usersync_url", "//ssum-sec.casalemedia.com/usermatchredir?s=184932&cb=https%3A%2F%2Fprebid.adnxs.com%2Fpbs%2Fv1%2Fsetuid%3Fbidder%3DindexExchange%26uid%3D")
+	viper.SetDefault("adapters.rubicon.platform_id", "")
+	viper.SetDefault("adapters.facebook.endpoint", "https://an.facebook.com/placementbid.ortb")
+	viper.SetDefault("adapters.facebook.usersync_url", "")
+	viper.SetDefault("adapters.facebook.platform_id", "")
+	viper.SetDefault("adapters.lifestreet.endpoint", "https://prebid.s2s.lfstmedia.com/adrequest")
+	viper.SetDefault("adapters.lifestreet.usersync_url", "//ads.lfstmedia.com/idsync/137062?synced=1&ttl=1s&rurl={{redirect_url}}")
+	viper.SetDefault("adapters.lifestreet.platform_id", "")
+	viper.SetDefault("adapters.conversant.endpoint", "http://media.msg.dotomi.com/s2s/header/24")
+	viper.SetDefault("adapters.conversant.usersync_url", "http://prebid-match.dotomi.com/prebid/match?rurl={{redirect_url}}")
+	viper.SetDefault("adapters.conversant.platform_id", "")
 	viper.ReadInConfig()
 
+	viper.SetEnvPrefix("PBS")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
 	flag.Parse() // read glog settings from cmd line
 }
 
@@ -707,19 +2874,67 @@ func main() {
 }
 
 func setupExchanges(cfg *config.Configuration) {
+	// The registry comes first, since the adapters' failovers register their meters in it.
+	metricsRegistry = metrics.NewPrefixedRegistry("prebidserver.")
 	exchanges = map[string]adapters.Adapter{
-		"appnexus":      appnexus.NewAppNexusAdapter(adapters.DefaultHTTPAdapterConfig, cfg.ExternalURL),
-		"districtm":     appnexus.NewAppNexusAdapter(adapters.DefaultHTTPAdapterConfig, cfg.ExternalURL),
-		"indexExchange": index.NewIndexAdapter(adapters.DefaultHTTPAdapterConfig, cfg.Adapters["indexexchange"].Endpoint, cfg.Adapters["indexexchange"].UserSyncURL),
-		"pubmatic":      pubmatic.NewPubmaticAdapter(adapters.DefaultHTTPAdapterConfig, cfg.Adapters["pubmatic"].Endpoint, cfg.ExternalURL),
-		"pulsepoint":    pulsepoint.NewPulsePointAdapter(adapters.DefaultHTTPAdapterConfig, cfg.Adapters["pulsepoint"].Endpoint, cfg.ExternalURL),
-		"rubicon": rubicon.NewRubiconAdapter(adapters.DefaultHTTPAdapterConfig, cfg.Adapters["rubicon"].Endpoint,
+		"appnexus":      appnexus.NewAppNexusAdapter(httpAdapterConfig("appnexus", cfg.Adapters["appnexus"]), cfg.Adapters["appnexus"].Endpoint, cfg.Adapters["appnexus"].UserSyncURL, cfg.ExternalURL),
+		"districtm":     appnexus.NewAppNexusAdapter(httpAdapterConfig("districtm", cfg.Adapters["districtm"]), cfg.Adapters["districtm"].Endpoint, cfg.Adapters["districtm"].UserSyncURL, cfg.ExternalURL),
+		"indexExchange": index.NewIndexAdapter(httpAdapterConfig("indexExchange", cfg.Adapters["indexexchange"]), cfg.Adapters["indexexchange"].Endpoint, cfg.Adapters["indexexchange"].UserSyncURL),
+		"pubmatic":      pubmatic.NewPubmaticAdapter(httpAdapterConfig("pubmatic", cfg.Adapters["pubmatic"]), cfg.Adapters["pubmatic"].Endpoint, cfg.Adapters["pubmatic"].UserSyncURL, cfg.ExternalURL),
+		"pulsepoint":    pulsepoint.NewPulsePointAdapter(httpAdapterConfig("pulsepoint", cfg.Adapters["pulsepoint"]), cfg.Adapters["pulsepoint"].Endpoint, cfg.Adapters["pulsepoint"].UserSyncURL, cfg.ExternalURL),
+		"rubicon": rubicon.NewRubiconAdapter(httpAdapterConfig("rubicon", cfg.Adapters["rubicon"]), cfg.Adapters["rubicon"].Endpoint,
 			cfg.Adapters["rubicon"].XAPI.Username, cfg.Adapters["rubicon"].XAPI.Password, cfg.Adapters["rubicon"].XAPI.Tracker, cfg.Adapters["rubicon"].UserSyncURL),
-		"audienceNetwork": facebook.NewFacebookAdapter(adapters.DefaultHTTPAdapterConfig, cfg.Adapters["facebook"].PlatformID, cfg.Adapters["facebook"].UserSyncURL),
-		"lifestreet":      lifestreet.NewLifestreetAdapter(adapters.DefaultHTTPAdapterConfig, cfg.ExternalURL),
+		"audienceNetwork": facebook.NewFacebookAdapter(httpAdapterConfig("audienceNetwork", cfg.Adapters["facebook"]), cfg.Adapters["facebook"].Endpoint, cfg.Adapters["facebook"].PlatformID, cfg.Adapters["facebook"].UserSyncURL),
+		"lifestreet":      lifestreet.NewLifestreetAdapter(httpAdapterConfig("lifestreet", cfg.Adapters["lifestreet"]), cfg.Adapters["lifestreet"].Endpoint, cfg.Adapters["lifestreet"].UserSyncURL, cfg.ExternalURL),
+		"conversant":      conversant.NewConversantAdapter(httpAdapterConfig("conversant", cfg.Adapters["conversant"]), cfg.Adapters["conversant"].Endpoint, cfg.Adapters["conversant"].UserSyncURL, cfg.ExternalURL),
+	}
+	if cfg.DebugBidder.Enabled {
+		exchanges["debug"] = debugbidder.NewDebugBidderAdapter(cfg.DebugBidder.Price, cfg.DebugBidder.MediaTypes)
+	}
+	bidderAliases = make(map[string]string)
+	for alias, adapterConfig := range cfg.Adapters {
+		if adapterConfig.AliasOf == "" {
+			continue
+		}
+		_, ok := exchanges[adapterConfig.AliasOf]
+		if _, taken := exchanges[alias]; taken || !ok || cfg.Adapters[strings.ToLower(adapterConfig.AliasOf)].AliasOf != "" {
+			glog.Errorf("Ignoring adapters.%s.alias_of: aliases can't reuse a bidder's code, and must alias a bidder which isn't an alias", alias)
+			continue
+		}
+		bidderAliases[alias] = adapterConfig.AliasOf
+	}
+	for alias, core := range bidderAliases {
+		exchanges[alias] = exchanges[core]
+	}
+	pbs.AddParamAliases(bidderAliases)
+	batchedBidders = make(map[string]bool)
+	shadowBidders = make(map[string]bool)
+	testBidders = make(map[string]bool)
+	eidLimits = make(map[string]*pbs.EIDLimits)
+	stripFields = make(map[string][]string)
+	for bidder := range exchanges {
+		adapterConfig := cfg.Adapters[strings.ToLower(bidder)]
+		if adapterConfig.BatchImps {
+			batchedBidders[strings.ToLower(bidder)] = true
+		}
+		if adapterConfig.Shadow {
+			shadowBidders[strings.ToLower(bidder)] = true
+		}
+		if adapterConfig.ForwardTest {
+			testBidders[strings.ToLower(bidder)] = true
+		}
+		if limits := adapterConfig.EIDs; limits.MaxSources > 0 || limits.MaxBytes > 0 {
+			eidLimits[strings.ToLower(bidder)] = &pbs.EIDLimits{
+				MaxSources: limits.MaxSources,
+				MaxBytes:   limits.MaxBytes,
+				Priority:   limits.Priority,
+			}
+		}
+		if len(adapterConfig.StripFields) > 0 {
+			stripFields[strings.ToLower(bidder)] = adapterConfig.StripFields
+		}
 	}
 
-	metricsRegistry = metrics.NewPrefixedRegistry("prebidserver.")
 	mRequestMeter = metrics.GetOrRegisterMeter("requests", metricsRegistry)
 	mAppRequestMeter = metrics.GetOrRegisterMeter("app_requests", metricsRegistry)
 	mNoCookieMeter = metrics.GetOrRegisterMeter("no_cookie_requests", metricsRegistry)
@@ -729,10 +2944,63 @@ func setupExchanges(cfg *config.Configuration) {
 	mInvalidMeter = metrics.GetOrRegisterMeter("invalid_requests", metricsRegistry)
 	mRequestTimer = metrics.GetOrRegisterTimer("request_time", metricsRegistry)
 	mCookieSyncMeter = metrics.GetOrRegisterMeter("cookie_sync_requests", metricsRegistry)
+	mCookieSyncNoCookie = metrics.GetOrRegisterMeter("cookie_sync_no_cookie_requests", metricsRegistry)
+	mCookieDeprecation = metrics.GetOrRegisterMeter("cookie_deprecation_requests", metricsRegistry)
+	mCOPPAMeter = metrics.GetOrRegisterMeter("coppa_requests", metricsRegistry)
+	mEarlyReturnMeter = metrics.GetOrRegisterMeter("early_return_requests", metricsRegistry)
+	mLateBidsMeter = metrics.GetOrRegisterMeter("late_bids", metricsRegistry)
+	mMinWinningCPMMeter = metrics.GetOrRegisterMeter("below_min_winning_cpm_ad_units", metricsRegistry)
+	mIdentityTimer = metrics.GetOrRegisterTimer("identity_resolution_time", metricsRegistry)
+	mIdentityErrorMeter = metrics.GetOrRegisterMeter("identity_resolution_errors", metricsRegistry)
+	mDebugOverrideMeter = metrics.GetOrRegisterMeter("debug_override_requests", metricsRegistry)
+	mOpenRTBRequestMeter = metrics.GetOrRegisterMeter("openrtb_requests", metricsRegistry)
+	mOpenRTBInvalidMeter = metrics.GetOrRegisterMeter("openrtb_invalid_requests", metricsRegistry)
+	mAMPRequestMeter = metrics.GetOrRegisterMeter("amp_requests", metricsRegistry)
+	mAMPInvalidMeter = metrics.GetOrRegisterMeter("amp_invalid_requests", metricsRegistry)
+	mVideoRequestMeter = metrics.GetOrRegisterMeter("video_requests", metricsRegistry)
+	mVideoInvalidMeter = metrics.GetOrRegisterMeter("video_invalid_requests", metricsRegistry)
+	mCacheErrorMeter = metrics.GetOrRegisterMeter("cache_errors", metricsRegistry)
+	mBidderExtTrimMeter = metrics.GetOrRegisterMeter("bidder_ext_trimmed_bids", metricsRegistry)
+	mImpEventMeter = metrics.GetOrRegisterMeter("impression_events", metricsRegistry)
 
 	accountMetrics = make(map[string]*AccountMetrics)
 	adapterMetrics = makeExchangeMetrics("adapter")
+	cookieSyncMetrics = makeCookieSyncMetrics()
+
+}
 
+// uidPolicy converts the config's days into a pbs.UIDPolicy.
+func uidPolicy(policy config.UIDPolicy) pbs.UIDPolicy {
+	return pbs.UIDPolicy{
+		Refresh: time.Duration(policy.RefreshDays) * 24 * time.Hour,
+		MaxAge:  time.Duration(policy.MaxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// httpAdapterConfig returns the HTTP settings for a bidder. If it has a secondary endpoint, then its
+// requests fail over to it when the primary is down. The failovers are counted in the bidder's
+// adapter metrics, which get the same meters from the registry.
+func httpAdapterConfig(bidder string, adapterConfig config.Adapter) *adapters.HTTPAdapterConfig {
+	if adapterConfig.Endpoint == "" || adapterConfig.SecondaryEndpoint == "" {
+		return adapters.DefaultHTTPAdapterConfig
+	}
+	failoverMeter := metrics.GetOrRegisterMeter(fmt.Sprintf("adapter.%s.http.failovers", bidder), metricsRegistry)
+	recoveryMeter := metrics.GetOrRegisterMeter(fmt.Sprintf("adapter.%s.http.failover_recoveries", bidder), metricsRegistry)
+	httpConfig := *adapters.DefaultHTTPAdapterConfig
+	httpConfig.Failover = &adapters.EndpointFailover{
+		Primary:   adapterConfig.Endpoint,
+		Secondary: adapterConfig.SecondaryEndpoint,
+		OnChange: func(failedOver bool) {
+			if failedOver {
+				glog.Warningf("Bidder %s failed over to %s", bidder, adapterConfig.SecondaryEndpoint)
+				failoverMeter.Mark(1)
+			} else {
+				glog.Infof("Bidder %s recovered on %s", bidder, adapterConfig.Endpoint)
+				recoveryMeter.Mark(1)
+			}
+		},
+	}
+	return &httpConfig
 }
 
 func makeExchangeMetrics(adapterOrAccount string) map[string]*AdapterMetrics {
@@ -740,7 +3008,11 @@ func makeExchangeMetrics(adapterOrAccount string) map[string]*AdapterMetrics {
 	for exchange := range exchanges {
 		a := AdapterMetrics{}
 		a.NoCookieMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.no_cookie_requests", adapterOrAccount, exchange), metricsRegistry)
+		a.ThrottledMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.throttled_requests", adapterOrAccount, exchange), metricsRegistry)
 		a.ErrorMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.error_requests", adapterOrAccount, exchange), metricsRegistry)
+		a.BadResponseMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.bad_server_responses", adapterOrAccount, exchange), metricsRegistry)
+		a.PanicMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.panics", adapterOrAccount, exchange), metricsRegistry)
+		a.CookieDeprecationMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.cookie_deprecation_requests", adapterOrAccount, exchange), metricsRegistry)
 		a.RequestMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.requests", adapterOrAccount, exchange), metricsRegistry)
 		a.NoBidMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.no_bid_requests", adapterOrAccount, exchange), metricsRegistry)
 		a.TimeoutMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.timeout_requests", adapterOrAccount, exchange), metricsRegistry)
@@ -748,6 +3020,18 @@ func makeExchangeMetrics(adapterOrAccount string) map[string]*AdapterMetrics {
 		a.PriceHistogram = metrics.GetOrRegisterHistogram(fmt.Sprintf("%[1]s.%[2]s.prices", adapterOrAccount, exchange), metricsRegistry, metrics.NewExpDecaySample(1028, 0.015))
 		if adapterOrAccount != "adapter" {
 			a.BidsReceivedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.bids_received", adapterOrAccount, exchange), metricsRegistry)
+		} else {
+			a.DNSTimer = metrics.GetOrRegisterTimer(fmt.Sprintf("%[1]s.%[2]s.http.dns_time", adapterOrAccount, exchange), metricsRegistry)
+			a.ConnectTimer = metrics.GetOrRegisterTimer(fmt.Sprintf("%[1]s.%[2]s.http.connect_time", adapterOrAccount, exchange), metricsRegistry)
+			a.TTFBTimer = metrics.GetOrRegisterTimer(fmt.Sprintf("%[1]s.%[2]s.http.ttfb_time", adapterOrAccount, exchange), metricsRegistry)
+			a.RetryMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.http.retries", adapterOrAccount, exchange), metricsRegistry)
+			a.FailoverMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.http.failovers", adapterOrAccount, exchange), metricsRegistry)
+			a.RecoveryMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.http.failover_recoveries", adapterOrAccount, exchange), metricsRegistry)
+			a.NoBidCacheMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.no_bid_cache_hits", adapterOrAccount, exchange), metricsRegistry)
+			a.RejectedBidMeters = make(map[string]metrics.Meter, len(analytics.RejectionReasons))
+			for _, reason := range analytics.RejectionReasons {
+				a.RejectedBidMeters[reason] = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.rejected_bids.%[3]s", adapterOrAccount, exchange, reason), metricsRegistry)
+			}
 		}
 
 		adapterMetrics[exchange] = &a
@@ -755,13 +3039,184 @@ func makeExchangeMetrics(adapterOrAccount string) map[string]*AdapterMetrics {
 	return adapterMetrics
 }
 
+func makeCookieSyncMetrics() map[string]*CookieSyncMetrics {
+	var syncMetrics = make(map[string]*CookieSyncMetrics)
+	for exchange := range exchanges {
+		c := CookieSyncMetrics{}
+		c.RequestedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("cookie_sync.%s.requested", exchange), metricsRegistry)
+		c.PrivacyFilteredMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("cookie_sync.%s.filtered.privacy", exchange), metricsRegistry)
+		c.SyncedFilteredMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("cookie_sync.%s.filtered.already_synced", exchange), metricsRegistry)
+		c.LimitFilteredMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("cookie_sync.%s.filtered.limit", exchange), metricsRegistry)
+		c.ReturnedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("cookie_sync.%s.returned", exchange), metricsRegistry)
+
+		syncMetrics[exchange] = &c
+	}
+	return syncMetrics
+}
+
+// setupCurrencyConverter starts fetching the currency rates in the background, and registers them with /status.
+// It returns nil if no rates are configured.
+func setupCurrencyConverter(cfg config.CurrencyConverter) (*currency.Converter, error) {
+	if cfg.FetchURL == "" {
+		return nil, nil
+	}
+	fetcher, err := remotefile.New(&http.Client{}, cfg.FetchURL)
+	if err != nil {
+		return nil, err
+	}
+	rates := refresher.New("currency",
+		time.Duration(cfg.FetchIntervalSeconds)*time.Second,
+		time.Duration(cfg.StaleRatesSeconds)*time.Second,
+		fetcher.FetchFunc(10*time.Second, currency.ParseRates),
+		metricsRegistry)
+	if err := rates.Start(nil); err != nil {
+		glog.Errorf("Failed to fetch the initial currency rates: %v", err)
+	}
+	refreshers = append(refreshers, rates)
+	return currency.NewConverter(rates, metricsRegistry), nil
+}
+
+// setupIVTFilter makes the invalid traffic filter. If there's a lists URL, it starts fetching the lists in the
+// background, and registers them with /status. It returns nil if the filter is disabled.
+func setupIVTFilter(cfg config.IVTFilter) (*ivt.Filter, error) {
+	if cfg.Mode == "" || cfg.ListsURL == "" {
+		return ivt.New(cfg)
+	}
+	fetcher, err := remotefile.New(&http.Client{}, cfg.ListsURL)
+	if err != nil {
+		return nil, err
+	}
+	lists := refresher.New("ivt_lists",
+		time.Duration(cfg.FetchIntervalSeconds)*time.Second,
+		0,
+		fetcher.FetchFunc(10*time.Second, ivt.ParseLists),
+		metricsRegistry)
+	if err := lists.Start(nil); err != nil {
+		glog.Errorf("Failed to fetch the initial invalid traffic lists: %v", err)
+	}
+	refreshers = append(refreshers, lists)
+	return ivt.New(cfg, ivt.NewRefreshedProvider(lists))
+}
+
+// setupBlocklist starts fetching the blocklist in the background, and registers it with /status.
+// It returns nil if there's no blocklist URL.
+func setupBlocklist(cfg config.Blocklist) (*blocklist.Blocklist, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	fetcher, err := remotefile.New(&http.Client{}, cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	lists := refresher.New("blocklist",
+		time.Duration(cfg.FetchIntervalSeconds)*time.Second,
+		0,
+		fetcher.FetchFunc(10*time.Second, blocklist.ParseLists),
+		metricsRegistry)
+	if err := lists.Start(nil); err != nil {
+		glog.Errorf("Failed to fetch the initial blocklist: %v", err)
+	}
+	refreshers = append(refreshers, lists)
+	return blocklist.NewRefreshed(lists), nil
+}
+
+// setupVendorLists starts fetching the latest Global Vendor List in the background, and registers it with /status.
+// It returns nil if GDPR enforcement is disabled, or the list isn't configured.
+func setupVendorLists(cfg config.GDPR) (*gdpr.VendorLists, error) {
+	if !cfg.Enabled || cfg.VendorList.VersionURL == "" {
+		return nil, nil
+	}
+	if mode := cfg.VendorList.OnFetchFailure; mode != "allow" && mode != "deny" {
+		return nil, fmt.Errorf("gdpr.vendor_list.on_fetch_failure must be allow or deny. Got %q", mode)
+	}
+	interval := time.Duration(cfg.VendorList.FetchIntervalSeconds) * time.Second
+	fetchVersion := gdpr.NewVersionFetcher(&http.Client{}, cfg.VendorList.VersionURL)
+	if cfg.VendorList.LatestURL == "" {
+		return gdpr.NewVendorLists(nil, fetchVersion, interval), nil
+	}
+	fetcher, err := remotefile.New(&http.Client{}, cfg.VendorList.LatestURL)
+	if err != nil {
+		return nil, err
+	}
+	latest := refresher.New("gvl",
+		interval,
+		0,
+		fetcher.FetchFunc(10*time.Second, gdpr.ParseVendorList),
+		metricsRegistry)
+	if err := latest.Start(nil); err != nil {
+		glog.Errorf("Failed to fetch the initial vendor list: %v", err)
+	}
+	refreshers = append(refreshers, latest)
+	return gdpr.NewVendorLists(latest, fetchVersion, interval), nil
+}
+
 func serve(cfg *config.Configuration) error {
 	if err := loadDataCache(cfg); err != nil {
 		return fmt.Errorf("Prebid Server could not load data cache: %v", err)
 	}
+	warmDataCache(cfg.DataCache)
 
 	setupExchanges(cfg)
 
+	var err error
+	tieBreaker, err = pbs.NewTieBreaker(cfg.TieBreak.Mode, cfg.TieBreak.BidderPriority)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up tie breaks: %v", err)
+	}
+	if err = pbs.ValidateDomainCheckMode(cfg.DomainCheck.Mode); err != nil {
+		return fmt.Errorf("Prebid Server could not set up the domain check: %v", err)
+	}
+	paramsValidator, err = adapters.NewParamsValidator(schemaDirectory)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not load the bidder params schemas: %v", err)
+	}
+	paramsValidator.AddAliases(bidderAliases)
+
+	exps, err := experiments.New(cfg.Experiments, cfg.HostCookie.Family, metricsRegistry)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not load experiments: %v", err)
+	}
+
+	badResponseLog, err := quarantine.New(cfg.Quarantine)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not open the bad response quarantine: %v", err)
+	}
+
+	priceEncrypters, err := priceencryption.NewEncrypters(cfg.BidderMacros)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not load the price encryption keys: %v", err)
+	}
+
+	currencyConverter, err := setupCurrencyConverter(cfg.CurrencyConverter)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up currency conversion: %v", err)
+	}
+
+	ivtFilter, err := setupIVTFilter(cfg.IVTFilter)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up the invalid traffic filter: %v", err)
+	}
+
+	bidderBlocklist, err := setupBlocklist(cfg.Blocklist)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up the blocklist: %v", err)
+	}
+
+	vendorLists, err := setupVendorLists(cfg.GDPR)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up the vendor list: %v", err)
+	}
+
+	throttles, err := throttle.NewThrottles(cfg.BidderThrottles)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up the bidder throttles: %v", err)
+	}
+
+	trafficMirror, err := mirror.New(cfg.Mirror, &http.Client{}, metricsRegistry)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up traffic mirroring: %v", err)
+	}
+
 	if cfg.Metrics.Host != "" {
 		go influxdb.InfluxDB(
 			metricsRegistry,      // metrics registry
@@ -787,9 +3242,65 @@ func serve(cfg *config.Configuration) error {
 	stopSignals := make(chan os.Signal)
 	signal.Notify(stopSignals, syscall.SIGTERM, syscall.SIGINT)
 
+	bidderCodes := make([]string, 0, len(exchanges))
+	coreBidderCodes := make([]string, 0, len(exchanges))
+	for code := range exchanges {
+		bidderCodes = append(bidderCodes, code)
+		if _, ok := bidderAliases[code]; !ok {
+			coreBidderCodes = append(coreBidderCodes, code)
+		}
+	}
+	bidderInfos, err := adapters.ParseBidderInfos(infoDirectory, coreBidderCodes)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not load the bidder info: %v", err)
+	}
+	for alias, core := range bidderAliases {
+		bidderInfos[alias] = bidderInfos[core]
+	}
+	bidderRegistry := adapters.NewBidderRegistry(bidderInfos, bidderAliases)
+	gdprEnforcer, err := gdpr.NewEnforcer(cfg.GDPR, bidderInfos.VendorIDs(), vendorLists)
+	if err != nil {
+		return fmt.Errorf("Prebid Server could not set up GDPR enforcement: %v", err)
+	}
+
 	/* Run admin on different port thats not exposed */
 	adminURI := fmt.Sprintf("%s:%d", cfg.Host, cfg.AdminPort)
-	adminServer := &http.Server{Addr: adminURI}
+	auction := &auctionDeps{
+		cfg:               cfg,
+		analytics:         analyticsConf.NewPBSAnalytics(&cfg.Analytics),
+		experiments:       exps,
+		throttles:         throttles,
+		quarantine:        badResponseLog,
+		noBids:            nobidcache.New(cfg.NoBidCache),
+		identity:          identity.New(cfg.IdentityResolution),
+		priceEncrypters:   priceEncrypters,
+		currencyConverter: currencyConverter,
+		ivt:               ivtFilter,
+		mirror:            trafficMirror,
+		blocklist:         bidderBlocklist,
+		gdpr:              gdprEnforcer,
+		registry:          bidderRegistry,
+	}
+
+	adminRouter := httprouter.New()
+	adminRouter.POST("/dryrun", dryRun)
+	adminRouter.GET("/authorization", (&authorizationDeps{cfg, &adstxt.Checker{Client: &http.Client{Timeout: 10 * time.Second}}}).checkAuthorization)
+	adminRouter.GET("/currency/rates", (&currencyRatesDeps{currencyConverter}).currencyRates)
+	adminRouter.GET("/bidders", (&bidderRegistryDeps{bidderRegistry}).bidderRegistry)
+	if cfg.AdminToken != "" {
+		adminRouter.GET("/caches", requireAdminToken(cfg.AdminToken, inspectCaches))
+		adminRouter.DELETE("/caches/datacache/:id", requireAdminToken(cfg.AdminToken, invalidateDataCache))
+		adminRouter.POST("/caches/refreshers/:name", requireAdminToken(cfg.AdminToken, refreshNow))
+	}
+	if cfg.Monitoring.Enabled {
+		if cfg.DebugBidder.Enabled {
+			adminRouter.GET("/monitoring/auction", newMonitoringDeps(auction, cfg.Monitoring).syntheticAuction)
+		} else {
+			glog.Errorf("The /monitoring/auction endpoint needs the debug bidder. It won't be served.")
+		}
+	}
+	adminRouter.NotFound = http.DefaultServeMux
+	adminServer := &http.Server{Addr: adminURI, Handler: adminRouter}
 	go (func() {
 		fmt.Println("Admin running on: ", adminURI)
 		err := adminServer.ListenAndServe()
@@ -798,36 +3309,59 @@ func serve(cfg *config.Configuration) error {
 	})()
 
 	router := httprouter.New()
-	router.POST("/auction", (&auctionDeps{cfg}).auction)
-	router.GET("/bidders/params", NewJsonDirectoryServer(schemaDirectory))
-	router.POST("/cookie_sync", cookieSync)
+	router.POST("/auction", auction.auction)
+	openrtbAuction := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), openrtbBidders(), tieBreaker, paramsValidator, openrtbStripFields(), gdprEnforcer, bidderRegistry)}
+	router.POST("/openrtb2/auction", openrtbAuction.auction)
+	router.GET("/openrtb2/amp", openrtbAuction.amp)
+	router.POST("/openrtb2/video", openrtbAuction.video)
+	router.GET("/bidders/params", NewBidderParamsServer(paramsValidator, bidderCodes))
+	router.GET("/info/bidders", NewBiddersInfoEndpoint(bidderInfos))
+	router.GET("/info/bidders/:bidderName", NewBidderInfoEndpoint(bidderInfos))
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 	router.POST("/validate", validate)
 	router.GET("/status", status)
+	router.GET("/event", (&eventDeps{cfg.GetAccount}).event)
 	router.GET("/", serveIndex)
 	router.GET("/ip", getIP)
 	router.ServeFiles("/static/*filepath", http.Dir("static"))
 
 	hostCookieSettings = pbs.HostCookieSettings{
-		Domain:     cfg.HostCookie.Domain,
-		Family:     cfg.HostCookie.Family,
-		CookieName: cfg.HostCookie.CookieName,
-		OptOutURL:  cfg.HostCookie.OptOutURL,
-		OptInURL:   cfg.HostCookie.OptInURL,
+		Domain:            cfg.HostCookie.Domain,
+		Family:            cfg.HostCookie.Family,
+		CookieName:        cfg.HostCookie.CookieName,
+		OptOutURL:         cfg.HostCookie.OptOutURL,
+		OptInURL:          cfg.HostCookie.OptInURL,
+		OptOutCookieName:  cfg.HostCookie.OptOutCookie.Name,
+		OptOutCookieValue: cfg.HostCookie.OptOutCookie.Value,
+		TTL:               time.Duration(cfg.HostCookie.TTLDays) * 24 * time.Hour,
+		JavaCompatible:    cfg.HostCookie.JavaCompatible,
+		UIDs:              uidPolicy(cfg.HostCookie.UIDs.Default),
+		FamilyUIDs:        make(map[string]pbs.UIDPolicy, len(cfg.HostCookie.UIDs.Families)),
+	}
+	for family, policy := range cfg.HostCookie.UIDs.Families {
+		hostCookieSettings.FamilyUIDs[family] = uidPolicy(policy)
 	}
 
 	userSyncDeps := &pbs.UserSyncDeps{
 		HostCookieSettings: &hostCookieSettings,
 		ExternalUrl:        cfg.ExternalURL,
 		RecaptchaSecret:    cfg.RecaptchaSecret,
+		OptOutUrl:          cfg.HostCookie.OptOutURL,
+		OptInUrl:           cfg.HostCookie.OptInURL,
 		Metrics:            metricsRegistry,
+		SyncAllowed: func(account string, familyName string) bool {
+			return cfg.GetAccount(account).ActivityAllowed(config.ActivitySyncUser, "", familyName)
+		},
 	}
 
 	router.GET("/getuids", userSyncDeps.GetUIDs)
 	router.GET("/setuid", userSyncDeps.SetUID)
 	router.POST("/optout", userSyncDeps.OptOut)
 	router.GET("/optout", userSyncDeps.OptOut)
+	router.POST("/optin", userSyncDeps.OptIn)
+	router.GET("/optin", userSyncDeps.OptIn)
 
-	pbc.InitPrebidCache(cfg.GetCacheBaseURL())
+	pbc.InitPrebidCache(cfg.GetCacheBaseURL(), time.Duration(cfg.CacheURL.RetryTimeoutMs)*time.Millisecond)
 
 	// Add CORS middleware
 	c := cors.New(cors.Options{AllowCredentials: true})
@@ -860,6 +3394,12 @@ func serve(cfg *config.Configuration) error {
 	if err := adminServer.Shutdown(ctx); err != nil {
 		glog.Errorf("Admin server shutdown: %v", err)
 	}
+	// Buffered analytics would be lost otherwise.
+	if closer, ok := auction.analytics.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			glog.Errorf("Analytics shutdown: %v", err)
+		}
+	}
 
 	return nil
 }
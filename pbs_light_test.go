@@ -2,22 +2,42 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mxmCherry/openrtb"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/adapters/appnexus"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/blocklist"
 	"github.com/prebid/prebid-server/cache/dummycache"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/currency"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/experiments"
+	"github.com/prebid/prebid-server/ivt"
+	"github.com/prebid/prebid-server/mirror"
 	"github.com/prebid/prebid-server/pbs"
+	pbc "github.com/prebid/prebid-server/prebid_cache_client"
+	"github.com/prebid/prebid-server/refresher"
+	"github.com/prebid/prebid-server/throttle"
+	"github.com/rcrowley/go-metrics"
+	"github.com/xeipuuv/gojsonschema"
 	"io/ioutil"
 )
 
-const adapterDirectory = "adapters"
-
 func TestCookieSyncNoCookies(t *testing.T) {
 	cfg, err := config.New()
 	if err != nil {
@@ -25,7 +45,7 @@ func TestCookieSyncNoCookies(t *testing.T) {
 	}
 	setupExchanges(cfg)
 	router := httprouter.New()
-	router.POST("/cookie_sync", cookieSync)
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 
 	csreq := cookieSyncRequest{
 		UUID:    "abcdefg",
@@ -63,6 +83,105 @@ func TestCookieSyncNoCookies(t *testing.T) {
 	}
 }
 
+func TestHostAliases(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	if cfg.Adapters == nil {
+		cfg.Adapters = make(map[string]config.Adapter)
+	}
+	cfg.Adapters["appnexus2"] = config.Adapter{AliasOf: "appnexus", Shadow: true}
+	cfg.Adapters["appnexus3"] = config.Adapter{AliasOf: "appnexus2"}
+	cfg.Adapters["lifestreet"] = config.Adapter{AliasOf: "rubicon"}
+	cfg.Adapters["unknown2"] = config.Adapter{AliasOf: "unknown"}
+	setupExchanges(cfg)
+	defer func() {
+		cfg, _ := config.New()
+		setupExchanges(cfg)
+	}()
+
+	if len(bidderAliases) != 1 || bidderAliases["appnexus2"] != "appnexus" {
+		t.Errorf("Only appnexus2 is a valid alias. Got %v", bidderAliases)
+	}
+	if exchanges["appnexus2"] != exchanges["appnexus"] || exchanges["lifestreet"] == exchanges["rubicon"] {
+		t.Error("Aliases should use their bidder's adapter, and never replace a bidder")
+	}
+	if _, ok := exchanges["appnexus3"]; ok {
+		t.Error("Aliases of aliases aren't supported")
+	}
+	if adapterMetrics["appnexus2"] == nil || cookieSyncMetrics["appnexus2"] == nil {
+		t.Error("Aliases should have their own metrics")
+	}
+	if !shadowBidders["appnexus2"] || shadowBidders["appnexus"] {
+		t.Error("Aliases should have their own settings")
+	}
+
+	// The alias shares the appnexus cookie family, so only one of them is synced.
+	router := httprouter.New()
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
+	req, _ := http.NewRequest("POST", "/cookie_sync", strings.NewReader(`{"uuid":"abcdefg","bidders":["appnexus2","appnexus","audienceNetwork"]}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	csresp := cookieSyncResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &csresp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if len(csresp.BidderStatus) != 2 || csresp.BidderStatus[0].BidderCode != "appnexus2" || csresp.BidderStatus[1].BidderCode != "audienceNetwork" {
+		t.Errorf("Expected syncs for appnexus2 and audienceNetwork. Got %v", csresp.BidderStatus)
+	}
+}
+
+func TestEndpointEnvOverrides(t *testing.T) {
+	os.Setenv("PBS_ADAPTERS_APPNEXUS_ENDPOINT", "http://staging.example.com/openrtb2")
+	os.Setenv("PBS_ADAPTERS_LIFESTREET_USERSYNC_URL", "//staging.example.com/sync?r={{redirect_url}}")
+	defer os.Unsetenv("PBS_ADAPTERS_APPNEXUS_ENDPOINT")
+	defer os.Unsetenv("PBS_ADAPTERS_LIFESTREET_USERSYNC_URL")
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	defer func() {
+		cfg, _ := config.New()
+		setupExchanges(cfg)
+	}()
+
+	if uri := exchanges["appnexus"].(*appnexus.AppNexusAdapter).URI; uri != "http://staging.example.com/openrtb2" {
+		t.Errorf("Expected the appnexus endpoint from the environment. Got %s", uri)
+	}
+	if uri := exchanges["districtm"].(*appnexus.AppNexusAdapter).URI; uri != "http://ib.adnxs.com/openrtb2" {
+		t.Errorf("Expected the default districtm endpoint. Got %s", uri)
+	}
+	if sync := exchanges["lifestreet"].GetUsersyncInfo(pbs.UsersyncPrivacy{}).URL; !strings.HasPrefix(sync, "//staging.example.com/sync?r=") {
+		t.Errorf("Expected the lifestreet usersync URL from the environment. Got %s", sync)
+	}
+	if sync := exchanges["pubmatic"].GetUsersyncInfo(pbs.UsersyncPrivacy{}).URL; !strings.HasPrefix(sync, "//ads.pubmatic.com/AdServer/js/user_sync.html?predirect=") {
+		t.Errorf("Expected the default pubmatic usersync URL. Got %s", sync)
+	}
+}
+
+func TestValidateAliases(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+
+	if err := validateAliases(map[string]string{"appnexus2": "appnexus", "ix2": "indexExchange"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateAliases(nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateAliases(map[string]string{"rubicon": "appnexus"}); err == nil {
+		t.Error("Aliases shouldn't be able to take over a bidder's code")
+	}
+	if err := validateAliases(map[string]string{"appnexus2": "unknown"}); err == nil {
+		t.Error("Aliases of unsupported bidders should be rejected")
+	}
+}
+
 func TestCookieSyncHasCookies(t *testing.T) {
 	cfg, err := config.New()
 	if err != nil {
@@ -70,7 +189,7 @@ func TestCookieSyncHasCookies(t *testing.T) {
 	}
 	setupExchanges(cfg)
 	router := httprouter.New()
-	router.POST("/cookie_sync", cookieSync)
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 
 	csreq := cookieSyncRequest{
 		UUID:    "abcdefg",
@@ -84,7 +203,7 @@ func TestCookieSyncHasCookies(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "/cookie_sync", csbuf)
 
-	pcs := pbs.ParsePBSCookieFromRequest(req)
+	pcs := pbs.ParsePBSCookieFromRequest(req, nil)
 	pcs.TrySync("adnxs", "1234")
 	pcs.TrySync("audienceNetwork", "2345")
 	req.AddCookie(pcs.ToHTTPCookie())
@@ -114,199 +233,1405 @@ func TestCookieSyncHasCookies(t *testing.T) {
 	}
 }
 
-func TestSortBidsAndAddKeywordsForMobile(t *testing.T) {
-	body := []byte(`{
-	   "max_key_length":20,
-	   "user":{
-	      "gender":"F",
-	      "buyeruid":"test_buyeruid",
-	      "yob":2000,
-	      "id":"testid"
-	   },
-	   "prebid_version":"0.21.0-pre",
-	   "sort_bids":1,
-	   "ad_units":[
-	      {
-	         "sizes":[
-	            {
-	               "w":300,
-	               "h":250
-	            }
-	         ],
-	         "config_id":"ad5ffb41-3492-40f3-9c25-ade093eb4e5f",
-	         "code":"test_adunitcode"
-	      }
-	   ],
-	   "cache_markup":1,
-	   "app":{
-	      "bundle":"AppNexus.PrebidMobileDemo",
-	      "ver":"0.0.1"
-	   },
-	   "sdk":{
-	      "version":"0.0.1",
-	      "platform":"iOS",
-	      "source":"prebid-mobile"
-	   },
-	   "device":{
-	      "ifa":"test_device_ifa",
-	      "osv":"9.3.5",
-	      "os":"iOS",
-	      "make":"Apple",
-	      "model":"iPhone6,1"
-	   },
-	   "tid":"abcd",
-	   "account_id":"aecd6ef7-b992-4e99-9bb8-65e2d984e1dd"
-	}
-    `)
-	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
-	d, _ := dummycache.New()
-	hcs := pbs.HostCookieSettings{}
-
-	pbs_req, err := pbs.ParsePBSRequest(r, d, &hcs)
+func TestCookieSyncActivityControls(t *testing.T) {
+	cfg, err := config.New()
 	if err != nil {
-		t.Errorf("Unexpected error on parsing %v", err)
-	}
-
-	bids := make(pbs.PBSBidSlice, 0)
-
-	fb_bid := pbs.PBSBid{
-		BidID:      "test_bidid",
-		AdUnitCode: "test_adunitcode",
-		BidderCode: "audienceNetwork",
-		Price:      2.00,
-		Adm:        "test_adm",
-		Width:      300,
-		Height:     250,
-		CacheID:    "test_cache_id1",
-	}
-	bids = append(bids, &fb_bid)
-	an_bid := pbs.PBSBid{
-		BidID:      "test_bidid2",
-		AdUnitCode: "test_adunitcode",
-		BidderCode: "appnexus",
-		Price:      1.00,
-		Adm:        "test_adm",
-		Width:      320,
-		Height:     50,
-		CacheID:    "test_cache_id2",
-	}
-	bids = append(bids, &an_bid)
-	nosize_bid := pbs.PBSBid{
-		BidID:      "test_bidid2",
-		AdUnitCode: "test_adunitcode",
-		BidderCode: "nosizebidder",
-		Price:      1.00,
-		Adm:        "test_adm",
-		CacheID:    "test_cache_id2",
+		t.Fatalf("Unable to config: %v", err)
 	}
-	bids = append(bids, &nosize_bid)
-	pbs_resp := pbs.PBSResponse{
-		Bids: bids,
+	setupExchanges(cfg)
+	cfg.Accounts = map[string]config.Account{
+		"1001": {Activities: map[string]config.Activity{
+			config.ActivitySyncUser: {Rules: []config.ActivityRule{
+				{Condition: config.ActivityCondition{ComponentNames: []string{"adnxs"}}, Allow: false},
+			}},
+		}},
 	}
-	sortBidsAddKeywordsMobile(pbs_resp.Bids, pbs_req, "")
+	router := httprouter.New()
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 
-	for _, bid := range bids {
-		if bid.AdServerTargeting == nil {
-			t.Errorf("Ad server targeting should not be nil")
-		}
-		if bid.BidderCode == "audienceNetwork" {
-			if bid.AdServerTargeting["hb_creative_loadtype"] != "demand_sdk" {
-				t.Errorf("Facebook bid should have demand_sdk as hb_creative_loadtype in ad server targeting")
-			}
-			if bid.AdServerTargeting["hb_size"] != "300x250" {
-				t.Errorf("hb_size key was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_pb"] != "2.00" {
-				t.Errorf("hb_pb key was not parsed correctly ", bid.AdServerTargeting["hb_pb"])
-			}
-			if bid.AdServerTargeting["hb_cache_id"] != "test_cache_id1" {
-				t.Errorf("hb_cache_id key was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_bidder"] != "audienceNetwork" {
-				t.Errorf("hb_bidder key was not parsed correctly")
-			}
+	for _, test := range []struct {
+		account  string
+		expected string
+	}{
+		{account: "1001", expected: "audienceNetwork"},
+		{account: "1002", expected: "appnexus,audienceNetwork"},
+	} {
+		req, _ := http.NewRequest("POST", "/cookie_sync", strings.NewReader(`{"uuid":"abcdefg","account":"`+test.account+`","bidders":["appnexus","audienceNetwork"]}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		csresp := cookieSyncResponse{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &csresp); err != nil {
+			t.Fatalf("Unmarshal response failed: %v", err)
 		}
-		if bid.BidderCode == "appnexus" {
-			if bid.AdServerTargeting["hb_size_appnexus"] != "320x50" {
-				t.Errorf("hb_size key for appnexus bidder was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_cache_id_appnexus"] != "test_cache_id2" {
-				t.Errorf("hb_cache_id key for appnexus bidder was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_bidder_appnexus"] != "appnexus" {
-				t.Errorf("hb_bidder key for appnexus bidder was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_pb_appnexus"] != "1.00" {
-				t.Errorf("hb_pb key for appnexus bidder was not parsed correctly")
-			}
-			if bid.AdServerTargeting["hb_pb"] != "" {
-				t.Errorf("hb_pb key was parsed for two bidders")
-			}
+		var synced []string
+		for _, status := range csresp.BidderStatus {
+			synced = append(synced, status.BidderCode)
 		}
-		if bid.BidderCode == "nosizebidder" {
-			if _, exists := bid.AdServerTargeting["hb_size_nosizebidder"]; exists {
-				t.Errorf("hb_size key for nosize bidder was not parsed correctly", bid.AdServerTargeting)
-			}
+		if strings.Join(synced, ",") != test.expected {
+			t.Errorf("Account %s: expected syncs for %v. Got %v", test.account, test.expected, synced)
 		}
 	}
 }
 
-func TestBidSizeValidate(t *testing.T) {
+func TestCookieSyncOptedOutWithoutCookies(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	hostCookieSettings = pbs.HostCookieSettings{OptOutCookieName: "optout"}
+	defer func() { hostCookieSettings = pbs.HostCookieSettings{} }()
 
-	bids := make(pbs.PBSBidSlice, 0)
+	router := httprouter.New()
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 
-	//bid_1 will be rejected due to undefined size when adunit has multiple sizes
-	bid_1 := pbs.PBSBid{
-		BidID:      "test_bidid1",
-		AdUnitCode: "test_adunitcode1",
-		BidderCode: "randNetwork",
-		Price:      1.05,
-		Adm:        "test_adm",
-		//Width:             100,
-		//Height:            100,
-		CreativeMediaType: "banner",
+	req, _ := http.NewRequest("POST", "/cookie_sync", bytes.NewBufferString(`{"uuid":"abcdefg","bidders":["appnexus"]}`))
+	req.AddCookie(&http.Cookie{Name: "optout", Value: "1"})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status: %d", rr.Code)
 	}
 
-	bids = append(bids, &bid_1)
+	csresp := cookieSyncResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &csresp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if csresp.Status != "no_cookie" {
+		t.Errorf("Expected status = no_cookie; got %s", csresp.Status)
+	}
+	if len(csresp.BidderStatus) != 0 {
+		t.Errorf("Browsers which can't sync shouldn't get any syncs; got %d", len(csresp.BidderStatus))
+	}
+}
 
-	//bid_2 will be considered a normal ideal banner bid
-	bid_2 := pbs.PBSBid{
-		BidID:             "test_bidid2",
-		AdUnitCode:        "test_adunitcode2",
-		BidderCode:        "randNetwork",
-		Price:             1.05,
-		Adm:               "test_adm",
-		Width:             100,
-		Height:            100,
-		CreativeMediaType: "banner",
+func TestCookieSyncFunnelMetrics(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
 	}
+	setupExchanges(cfg)
+	router := httprouter.New()
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
 
-	bids = append(bids, &bid_2)
+	req, _ := http.NewRequest("POST", "/cookie_sync", bytes.NewBufferString(`{"uuid":"abcdefg","bidders":["appnexus","audienceNetwork","pubmatic"],"limit":1}`))
+	pcs := pbs.ParsePBSCookieFromRequest(req, nil)
+	pcs.TrySync("adnxs", "1234")
+	req.AddCookie(pcs.ToHTTPCookie())
 
-	//bid_3 will have it's dimensions set based on sizes defined in request
-	bid_3 := pbs.PBSBid{
-		BidID:      "test_bidid3",
-		AdUnitCode: "test_adunitcode3",
-		BidderCode: "randNetwork",
-		Price:      1.05,
-		Adm:        "test_adm",
-		//Width:             200,
-		//Height:            200,
-		CreativeMediaType: "banner",
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status: %d", rr.Code)
 	}
 
-	bids = append(bids, &bid_3)
-
-	//bid_4 will be ignored as it's a video creative type
-	bid_4 := pbs.PBSBid{
-		BidID:      "test_bidid_video",
-		AdUnitCode: "test_adunitcode_video",
-		BidderCode: "randNetwork",
-		Price:      1.05,
-		Adm:        "test_adm",
-		//Width:             400,
-		//Height:            400,
-		CreativeMediaType: "video",
+	csresp := cookieSyncResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &csresp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if len(csresp.BidderStatus) != 1 || csresp.BidderStatus[0].BidderCode != "audienceNetwork" {
+		t.Fatalf("Expected only audienceNetwork to be returned within the limit")
+	}
+
+	for _, bidder := range []string{"appnexus", "audienceNetwork", "pubmatic"} {
+		if count := cookieSyncMetrics[bidder].RequestedMeter.Count(); count != 1 {
+			t.Errorf("Expected 1 sync requested for %s. Got %d", bidder, count)
+		}
+	}
+	if count := cookieSyncMetrics["appnexus"].SyncedFilteredMeter.Count(); count != 1 {
+		t.Errorf("Expected the appnexus sync to be filtered as already synced. Got %d", count)
+	}
+	if count := cookieSyncMetrics["audienceNetwork"].ReturnedMeter.Count(); count != 1 {
+		t.Errorf("Expected the audienceNetwork sync to be returned. Got %d", count)
+	}
+	if count := cookieSyncMetrics["pubmatic"].LimitFilteredMeter.Count(); count != 1 {
+		t.Errorf("Expected the pubmatic sync to be filtered by the limit. Got %d", count)
+	}
+}
+
+func TestCookieSyncRegionPriorities(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.CookieSync = config.CookieSync{
+		CountryHeader:  "X-Country",
+		PriorityGroups: [][]string{{"appnexus"}, {"pubmatic"}},
+		Regions: map[string]config.CookieSyncRegion{
+			"eu": {Countries: []string{"DE", "FR"}, PriorityGroups: [][]string{{"pubmatic", "rubicon"}}},
+		},
+	}
+	setupExchanges(cfg)
+	router := httprouter.New()
+	router.POST("/cookie_sync", (&cookieSyncDeps{&cfg.CookieSync, cfg.GetAccount}).cookieSync)
+
+	sync := func(country string) []string {
+		req, _ := http.NewRequest("POST", "/cookie_sync", bytes.NewBufferString(`{"bidders":["rubicon","pubmatic","appnexus"],"limit":2}`))
+		req.Header.Set("X-Country", country)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var csresp cookieSyncResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &csresp); err != nil {
+			t.Fatalf("Unmarshal response failed: %v", err)
+		}
+		var bidders []string
+		for _, status := range csresp.BidderStatus {
+			bidders = append(bidders, status.BidderCode)
+		}
+		return bidders
+	}
+
+	if bidders := sync("US"); len(bidders) != 2 || bidders[0] != "appnexus" || bidders[1] != "pubmatic" {
+		t.Errorf("US users should get the default priorities. Got %v", bidders)
+	}
+	if bidders := sync("de"); len(bidders) != 2 || bidders[0] != "rubicon" || bidders[1] != "pubmatic" {
+		t.Errorf("EU users should get the EU priorities. Got %v", bidders)
+	}
+}
+
+func TestPrioritizeBidders(t *testing.T) {
+	bidders := prioritizeBidders([]string{"a", "b", "c", "d"}, [][]string{{"d"}, {"c", "a"}})
+	if strings.Join(bidders, ",") != "d,a,c,b" {
+		t.Errorf("Bad bidder order: %v", bidders)
+	}
+	if bidders := prioritizeBidders([]string{"b", "a"}, nil); strings.Join(bidders, ",") != "b,a" {
+		t.Errorf("Without priorities, the requested order should be kept. Got %v", bidders)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+
+	body := `{"tid":"abcd","ad_units":[{"code":"first","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus","params":{"placementId":12345}}]}]}`
+	req := httptest.NewRequest("POST", "/dryrun?bidder=appnexus", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "https://www.example.com/page")
+	rr := httptest.NewRecorder()
+	dryRun(rr, req, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status: %d", rr.Code)
+	}
+
+	var resp dryRunResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if resp.Error != "" {
+		t.Errorf("Unexpected error: %s", resp.Error)
+	}
+	if len(resp.Requests) != 1 {
+		t.Fatalf("Expected 1 request. Got %d", len(resp.Requests))
+	}
+	if resp.Requests[0].Method != "POST" || !strings.Contains(resp.Requests[0].Body, "12345") {
+		t.Errorf("Bad request: %v", resp.Requests[0])
+	}
+}
+
+func TestDryRunUnknownBidder(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+
+	rr := httptest.NewRecorder()
+	dryRun(rr, httptest.NewRequest("POST", "/dryrun?bidder=nobody", bytes.NewBufferString("{}")), nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for an unknown bidder. Got %d", rr.Code)
+	}
+}
+
+func TestSyntheticAuction(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.ExternalURL = "http://prebid.example.com"
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	deps := newMonitoringDeps(&auctionDeps{cfg: cfg, experiments: exps}, config.Monitoring{Enabled: true, AccountID: "monitoring", TimeoutMillis: 500})
+	rr := httptest.NewRecorder()
+	deps.syntheticAuction(rr, httptest.NewRequest("GET", "/monitoring/auction", nil), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status: %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp monitoringResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if resp.Status != "ok" || resp.Bids != 1 {
+		t.Errorf("Expected one bid from the debug bidder. Got %#v", resp)
+	}
+	for _, stage := range []string{"parse", "auction", "bidder.debug", "total"} {
+		if _, ok := resp.Stages[stage]; !ok {
+			t.Errorf("Missing latency for the %s stage. Got %v", stage, resp.Stages)
+		}
+	}
+}
+
+// capturingAnalytics remembers the last auction it was given.
+type capturingAnalytics struct {
+	ao *analytics.AuctionObject
+}
+
+func (c *capturingAnalytics) LogAuctionObject(ao *analytics.AuctionObject) {
+	c.ao = ao
+}
+
+func TestBidLandscapeSampling(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	// Bidder keys are off, so the losing bid is dropped from the targeting-only response.
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ext":{"prebid":{"targeting":{"includebidderkeys":false,"targetingonly":true}}},
+		"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"},{"bidder":"debug","bid_id":"2","params":{"price":3}}]}]}`
+	run := func(rate float64) *analytics.AuctionObject {
+		cfg.Analytics.BidLandscapeSampleRate = rate
+		module := &capturingAnalytics{}
+		deps := &auctionDeps{cfg: cfg, analytics: module, experiments: exps}
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com")
+		deps.auction(httptest.NewRecorder(), req, nil)
+		return module.ao
+	}
+
+	ao := run(1)
+	if ao == nil || ao.Response == nil {
+		t.Fatalf("Expected the auction to be logged")
+	}
+	if len(ao.Response.Bids) != 1 {
+		t.Errorf("Only the winner should be in the response. Got %d bids", len(ao.Response.Bids))
+	}
+	if len(ao.BidLandscape) != 2 {
+		t.Errorf("Sampled auctions should log every bid. Got %d", len(ao.BidLandscape))
+	}
+	if ao := run(0); len(ao.BidLandscape) != 0 {
+		t.Errorf("Auctions which aren't sampled shouldn't log the landscape")
+	}
+}
+
+func TestInvalidTrafficRejected(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+	filter, _ := ivt.New(config.IVTFilter{Mode: "reject", BotUserAgents: []string{"HeadlessChrome"}})
+
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	run := func(userAgent string) pbs.PBSResponse {
+		deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps, ivt: filter}
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com")
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := run("Mozilla/5.0 HeadlessChrome/70.0"); len(resp.Bids) != 0 || resp.BidderStatus[0].Error == "" {
+		t.Errorf("Bot traffic shouldn't be sent to any bidders. Got %d bids", len(resp.Bids))
+	}
+	if resp := run("Mozilla/5.0"); len(resp.Bids) != 1 {
+		t.Errorf("Other traffic should get bids. Got %d", len(resp.Bids))
+	}
+}
+
+func TestBlockedDomainsRejected(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+	lists := blocklist.NewStatic(&blocklist.Lists{Accounts: map[string]blocklist.Blocked{"1": {Domains: []string{"prebid.org"}}}})
+
+	run := func(account string) (pbs.PBSResponse, *capturingAnalytics) {
+		module := &capturingAnalytics{}
+		deps := &auctionDeps{cfg: cfg, analytics: module, experiments: exps, blocklist: lists}
+		body := `{"tid":"abcd","account_id":"` + account + `","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com")
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp, module
+	}
+
+	resp, module := run("1")
+	if len(resp.Bids) != 0 {
+		t.Errorf("Bids from blocked advertisers should be rejected. Got %d bids", len(resp.Bids))
+	}
+	if rejected := module.ao.RejectedBids; len(rejected) != 1 || rejected[0].Reason != analytics.RejectedBlockedDomain {
+		t.Errorf("Expected one bid rejected for its advertiser domain. Got %v", rejected)
+	}
+	if resp, _ := run("2"); len(resp.Bids) != 1 {
+		t.Errorf("Other accounts should get the bid. Got %d", len(resp.Bids))
+	}
+}
+
+func TestMinWinningCPM(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.Accounts = map[string]config.Account{"1": {MinWinningCPM: 2}, "2": {MinWinningCPM: 1}}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	run := func(account string) (pbs.PBSResponse, *capturingAnalytics) {
+		module := &capturingAnalytics{}
+		deps := &auctionDeps{cfg: cfg, analytics: module, experiments: exps}
+		body := `{"tid":"abcd","account_id":"` + account + `","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com")
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp, module
+	}
+
+	resp, module := run("1")
+	if len(resp.Bids) != 0 {
+		t.Errorf("A winner below the account's minimum CPM should be dropped. Got %d bids", len(resp.Bids))
+	}
+	if rejected := module.ao.RejectedBids; len(rejected) != 1 || rejected[0].Reason != analytics.RejectedBelowMinCPM {
+		t.Errorf("Expected one bid rejected for the minimum CPM. Got %v", rejected)
+	}
+	if resp, _ := run("2"); len(resp.Bids) != 1 {
+		t.Errorf("Winners above the minimum CPM should be kept. Got %d bids", len(resp.Bids))
+	}
+}
+
+func TestEnforceMinWinningCPM(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	bids := pbs.PBSBidSlice{
+		{AdUnitCode: "top", BidderCode: "appnexus", Price: 2.5},
+		{AdUnitCode: "top", BidderCode: "rubicon2", Price: 0.5},
+		{AdUnitCode: "side", BidderCode: "appnexus", Price: 0.8},
+		{AdUnitCode: "side", BidderCode: "rubicon2", Price: 0.9},
+	}
+	req := &pbs.PBSRequest{Ext: &pbs.PBSRequestExt{Prebid: pbs.PBSRequestExtPrebid{Aliases: map[string]string{"rubicon2": "rubicon"}}}}
+	meter := adapterMetrics["rubicon"].RejectedBidMeters[analytics.RejectedBelowMinCPM]
+	before := meter.Count()
+	kept, rejected := enforceMinWinningCPM(bids, 1, req)
+	if len(kept) != 2 || kept[0].AdUnitCode != "top" || kept[1].AdUnitCode != "top" {
+		t.Errorf("Ad units with a winner above the minimum should keep every bid. Got %v", kept)
+	}
+	if len(rejected) != 2 || rejected[0].Bid.AdUnitCode != "side" || rejected[1].Bid.AdUnitCode != "side" {
+		t.Errorf("Every bid on an ad unit whose winner is below the minimum should be rejected. Got %v", rejected)
+	}
+	if count := meter.Count() - before; count != 1 {
+		t.Errorf("The alias's rejected bid should be counted under its core bidder. Got %d", count)
+	}
+	if _, ok := metricsRegistry.Get("adapter.rubicon2.rejected_bids.below_min_cpm").(metrics.Meter); ok {
+		t.Errorf("Request aliases should not get metrics of their own.")
+	}
+	if kept, rejected := enforceMinWinningCPM(bids, 0.5, req); len(kept) != 4 || rejected != nil {
+		t.Errorf("Nothing should be dropped if every winner meets the minimum. Got %v and %v", kept, rejected)
+	}
+}
+
+func TestDomainCheck(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.DomainCheck.Mode = "reject"
+	cfg.Accounts = map[string]config.Account{"1": {Domains: []string{"example.com"}}}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	run := func(referer string, origin string) pbs.PBSResponse {
+		deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps}
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", referer)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := run("http://www.example.com/news", "http://www.example.com"); len(resp.Bids) != 1 {
+		t.Errorf("Requests from the account's domain should get bids. Got status %q", resp.Status)
+	}
+	if resp := run("http://www.example.com/news", "http://spoofer.net"); len(resp.Bids) != 0 || !strings.HasPrefix(resp.Status, "Invalid domain") {
+		t.Errorf("Requests from another origin should be rejected. Got status %q", resp.Status)
+	}
+	if resp := run("http://other.com/", ""); len(resp.Bids) != 0 || !strings.HasPrefix(resp.Status, "Invalid domain") {
+		t.Errorf("Requests from unregistered domains should be rejected. Got status %q", resp.Status)
+	}
+}
+
+func TestDisabledChannel(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.Accounts = map[string]config.Account{"1": {DisabledChannels: []string{"app"}}}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	run := func(ext string) pbs.PBSResponse {
+		body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]` + ext + `}`
+		deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps}
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com/news")
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := run(""); len(resp.Bids) != 1 {
+		t.Errorf("Prebid.js requests should get bids. Got status %q", resp.Status)
+	}
+	if resp := run(`,"ext":{"prebid":{"channel":{"name":"pbjs"}}}`); len(resp.Bids) != 1 {
+		t.Errorf("Prebid.js requests should get bids. Got status %q", resp.Status)
+	}
+	if resp := run(`,"app":{"bundle":"com.example"}`); len(resp.Bids) != 0 || !strings.HasPrefix(resp.Status, "Channel disabled") {
+		t.Errorf("App requests should be rejected. Got status %q", resp.Status)
+	}
+	if resp := run(`,"app":{"bundle":"com.example"},"ext":{"prebid":{"channel":{"name":"pbjs"}}}`); len(resp.Bids) != 0 || !strings.HasPrefix(resp.Status, "Channel disabled") {
+		t.Errorf("App requests shouldn't escape the rule by naming another channel. Got status %q", resp.Status)
+	}
+}
+
+func TestDebugOverride(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.DebugOverrideToken = "support-secret"
+	cfg.Accounts = map[string]config.Account{"1": {DisableDebug: true}}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"is_debug":true,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	run := func(token string) pbs.PBSResponse {
+		deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps}
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com/news")
+		if token != "" {
+			req.Header.Set(debugOverrideHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad auction response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := run(""); resp.Ext != nil && resp.Ext.Debug != nil {
+		t.Errorf("The account disabled debug output")
+	}
+	if resp := run("wrong"); resp.Ext != nil && resp.Ext.Debug != nil {
+		t.Errorf("The wrong token shouldn't turn on debug output")
+	}
+	if resp := run("support-secret"); resp.Ext == nil || resp.Ext.Debug == nil {
+		t.Errorf("The override token should turn on debug output")
+	}
+}
+
+func TestAuctionTime(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps}
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "http://www.example.com/news")
+	rr := httptest.NewRecorder()
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	deps.auction(rr, req, nil)
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+
+	elapsed, err := strconv.Atoi(rr.Header().Get(auctionTimeHeader))
+	if err != nil || elapsed < 0 || int64(elapsed) > after-before {
+		t.Errorf("Bad %s header: %q", auctionTimeHeader, rr.Header().Get(auctionTimeHeader))
+	}
+	var resp pbs.PBSResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad auction response: %v", err)
+	}
+	if resp.Ext == nil || resp.Ext.Prebid == nil {
+		t.Fatalf("The response should have ext.prebid")
+	}
+	if ts := resp.Ext.Prebid.AuctionTimestamp; ts < before || ts > after {
+		t.Errorf("The auction timestamp %d should be between %d and %d", ts, before, after)
+	}
+}
+
+// echoBidder bids 1.0 on every imp, and remembers the last request it got.
+type echoBidder struct {
+	uri     string
+	request *openrtb.BidRequest
+}
+
+func (b *echoBidder) MakeRequests(request *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	b.request = request
+	return []*adapters.RequestData{{URI: b.uri, Body: []byte(`{}`)}}, nil
+}
+
+func (b *echoBidder) MakeBids(request *openrtb.BidRequest, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	bids := &adapters.BidderResponse{}
+	for _, imp := range request.Imp {
+		bids.Bids = append(bids.Bids, &adapters.TypedBid{Bid: &openrtb.Bid{ID: "bid", ImpID: imp.ID, Price: 1, W: 300, H: 250}})
+	}
+	return bids, nil
+}
+
+func TestOpenRTBAuction(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	bidder := &echoBidder{uri: server.URL}
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": bidder}, nil, nil, nil, nil, nil)}
+
+	run := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openrtb2/auction", bytes.NewBufferString(body))
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		return rr
+	}
+
+	if rr := run(`{"id":"req","site":{},"imp":[]}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "/imp: must contain at least one element") {
+		t.Errorf("Invalid requests should get a 400 which points at the bad field. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := run(`{"id":"req","dooh":{"id":"screen"},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"echo":{"zone":5}}}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("DOOH requests should get a 400. Got %d", rr.Code)
+	}
+	if rr := run(`not json`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Malformed requests should get a 400. Got %d", rr.Code)
+	}
+
+	rr := run(`{"id":"req","site":{"page":"http://example.com"},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"echo":{"zone":5}}}],"ext":{"prebid":{"targeting":{}}}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a 200. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response openrtb.BidResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	if len(response.SeatBid) != 1 || response.SeatBid[0].Seat != "echo" || len(response.SeatBid[0].Bid) != 1 {
+		t.Fatalf("Expected one bid from echo. Got %#v", response.SeatBid)
+	}
+	if !strings.Contains(string(response.SeatBid[0].Bid[0].Ext), `"hb_pb":"1.00"`) {
+		t.Errorf("The winning bid should have targeting. Got %s", response.SeatBid[0].Bid[0].Ext)
+	}
+
+	if bidder.request.Device == nil || bidder.request.Device.UA != "test-agent" || bidder.request.Device.IP != "203.0.113.7" {
+		t.Errorf("The device should be filled in from the HTTP request. Got %#v", bidder.request.Device)
+	}
+	if bidder.request.TMax != int64(cfg.DefaultTimeout) || bidder.request.Ext != nil {
+		t.Errorf("Bidders should get the default tmax, and no request.ext. Got %d, %s", bidder.request.TMax, bidder.request.Ext)
+	}
+	if string(bidder.request.Imp[0].Ext) != `{"bidder":{"zone":5},"gpid":"1"}` {
+		t.Errorf("Bidders should get their own params, and the imp's gpid. Got %s", bidder.request.Imp[0].Ext)
+	}
+}
+
+func TestOpenRTBAuctionCOPPA(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	bidder := &echoBidder{uri: server.URL}
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": bidder}, nil, nil, nil, nil, nil)}
+
+	req := httptest.NewRequest("POST", "/openrtb2/auction", bytes.NewBufferString(`{"id":"req","site":{"page":"http://example.com"},
+		"device":{"ip":"203.0.113.7","ifa":"ifa-1","geo":{"lat":40.71278,"lon":-74.00597}},
+		"user":{"id":"u1","buyeruid":"b1","ext":{"eids":[{"source":"example.com","uids":[{"id":"e1"}]}]}},
+		"regs":{"coppa":1},"imp":[{"id":"1","banner":{"w":300,"h":250},"ext":{"echo":{"zone":5}}}]}`))
+	before := mCOPPAMeter.Count()
+	deps.auction(httptest.NewRecorder(), req, nil)
+
+	if count := mCOPPAMeter.Count() - before; count != 1 {
+		t.Errorf("Expected one COPPA request to be counted. Got %d", count)
+	}
+	if user := bidder.request.User; user.ID != "" || user.BuyerUID != "" || len(user.Ext) != 0 {
+		t.Errorf("Bidders shouldn't get the user's IDs on COPPA requests. Got %#v", user)
+	}
+	if device := bidder.request.Device; device.IFA != "" || device.IP != "203.0.113.0" || device.Geo.Lat != 40.71 || device.Geo.Lon != -74.01 {
+		t.Errorf("Bidders shouldn't get the device's IFA, or its precise IP and location, on COPPA requests. Got %#v", device)
+	}
+}
+
+func TestFillDeviceIPv6(t *testing.T) {
+	req := httptest.NewRequest("POST", "/openrtb2/auction", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+
+	request := &openrtb.BidRequest{}
+	fillDevice(request, req)
+	if request.Device.IP != "" || request.Device.IPv6 != "2001:db8::1" {
+		t.Errorf("IPv6 addresses should go in device.ipv6. Got %q and %q", request.Device.IP, request.Device.IPv6)
+	}
+
+	request = &openrtb.BidRequest{Device: &openrtb.Device{IPv6: "2001:db8::2"}}
+	fillDevice(request, req)
+	if request.Device.IP != "" || request.Device.IPv6 != "2001:db8::2" {
+		t.Errorf("The request's own ipv6 should be kept. Got %q and %q", request.Device.IP, request.Device.IPv6)
+	}
+}
+
+func TestAMPAuction(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	bidder := &echoBidder{uri: server.URL}
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": bidder}, nil, nil, nil, nil, nil)}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+
+	run := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/openrtb2/amp?"+query, nil)
+		req.Header.Set("Origin", "https://example-com.cdn.ampproject.org")
+		rr := httptest.NewRecorder()
+		deps.amp(rr, req, nil)
+		return rr
+	}
+
+	if rr := run(""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Requests without a tag_id should get a 400. Got %d", rr.Code)
+	}
+	if rr := run("tag_id=missing"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Config failures should get a 500. Got %d", rr.Code)
+	}
+
+	dataCache.Config().Set("amp-tag", `{"id":"amp","site":{"page":"http://example.com/stored"},"imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"echo":{}}}]}`)
+	rr := run("tag_id=amp-tag&curl=http%3A%2F%2Fexample.com%2Fstory&slot=%2F1234%2Fstory&ow=320&oh=50&w=300&h=250&__amp_source_origin=https%3A%2F%2Fexample.com")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a 200. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("AMP-Access-Control-Allow-Source-Origin") != "https://example.com" {
+		t.Errorf("Missing the AMP CORS header. Got %v", rr.Header())
+	}
+	var response ampResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	if response.Targeting["hb_pb"] != "1.00" || response.Targeting["hb_bidder"] != "echo" || response.Targeting["hb_pb_echo"] != "1.00" {
+		t.Errorf("Bad targeting: %v", response.Targeting)
+	}
+
+	if rr := run("tag_id=amp-tag&__amp_source_origin=https%3A%2F%2Fevil.com"); rr.Code != http.StatusForbidden || rr.Header().Get("AMP-Access-Control-Allow-Source-Origin") != "" {
+		t.Errorf("Source origins off the publisher's domain should get a 403. Got %d", rr.Code)
+	}
+
+	got := bidder.request
+	if got.Site.Page != "http://example.com/story" || got.Imp[0].TagID != "/1234/story" {
+		t.Errorf("The curl and slot should override the stored request. Got %s and %s", got.Site.Page, got.Imp[0].TagID)
+	}
+	if formats := got.Imp[0].Banner.Format; len(formats) != 1 || formats[0].W != 320 || formats[0].H != 50 {
+		t.Errorf("ow and oh should override the size. Got %v", formats)
+	}
+}
+
+func TestAMPDisabledChannel(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.Accounts = map[string]config.Account{"1001": {DisabledChannels: []string{"amp"}}}
+	setupExchanges(cfg)
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": &echoBidder{}}, nil, nil, nil, nil, nil)}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	dataCache.Config().Set("amp-tag", `{"id":"amp","site":{"page":"http://example.com","publisher":{"id":"1001"}},"imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"echo":{}}}]}`)
+
+	rr := httptest.NewRecorder()
+	deps.amp(rr, httptest.NewRequest("GET", "/openrtb2/amp?tag_id=amp-tag", nil), nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "doesn't accept amp requests") {
+		t.Errorf("AMP requests should be rejected for the account. Got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAMPActivityControls(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.Accounts = map[string]config.Account{"1001": {Activities: map[string]config.Activity{
+		config.ActivityFetchBids: {Rules: []config.ActivityRule{{Condition: config.ActivityCondition{Channels: []string{"amp"}}}}},
+	}}}
+	setupExchanges(cfg)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	bidder := &echoBidder{uri: server.URL}
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": bidder}, nil, nil, nil, nil, nil)}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	dataCache.Config().Set("amp-tag", `{"id":"amp","site":{"page":"http://example.com","publisher":{"id":"1001"}},"imp":[{"id":"1","banner":{"format":[{"w":300,"h":250}]},"ext":{"echo":{}}}]}`)
+
+	rr := httptest.NewRecorder()
+	deps.amp(rr, httptest.NewRequest("GET", "/openrtb2/amp?tag_id=amp-tag", nil), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a 200. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	if bidder.request != nil {
+		t.Errorf("The publisher's account denies fetching bids on AMP, but echo was called")
+	}
+}
+
+func TestCheckAMPOrigin(t *testing.T) {
+	request := &openrtb.BidRequest{Site: &openrtb.Site{Page: "https://www.example.com/story"}}
+	tests := []struct {
+		description  string
+		origin       string
+		sameOrigin   string
+		sourceOrigin string
+		account      config.Account
+		allowed      bool
+	}{
+		{"AMP cache", "https://example-com.cdn.ampproject.org", "", "https://www.example.com", config.Account{}, true},
+		{"Bing's AMP cache", "https://example-com.bing-amp.com", "", "https://www.example.com", config.Account{}, true},
+		{"same origin", "", "true", "https://www.example.com", config.Account{}, true},
+		{"origin is the source", "https://www.example.com", "", "https://www.example.com", config.Account{}, true},
+		{"no source origin", "https://www.example.com", "", "", config.Account{}, true},
+		{"no headers", "", "", "", config.Account{}, true},
+		{"account domain", "https://example-org.cdn.ampproject.org", "", "https://example.org", config.Account{Domains: []string{"example.org"}}, true},
+		{"source off the site", "https://example-com.cdn.ampproject.org", "", "https://evil.com", config.Account{}, false},
+		{"source off the account's domains", "https://example-com.cdn.ampproject.org", "", "https://www.example.com", config.Account{Domains: []string{"example.org"}}, false},
+		{"unknown origin", "https://evil.com", "", "https://www.example.com", config.Account{}, false},
+		{"unknown origin without a source", "https://evil.com", "", "", config.Account{}, false},
+		{"insecure AMP cache", "http://example-com.cdn.ampproject.org", "", "https://www.example.com", config.Account{}, false},
+		{"lookalike AMP cache", "https://evilcdn.ampproject.org.evil.com", "", "https://www.example.com", config.Account{}, false},
+		{"no origin headers", "", "", "https://www.example.com", config.Account{}, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/openrtb2/amp", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.sameOrigin != "" {
+			r.Header.Set("AMP-Same-Origin", test.sameOrigin)
+		}
+		err := checkAMPOrigin(r, test.sourceOrigin, request, test.account)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: expected allowed %t. Got %v", test.description, test.allowed, err)
+		}
+	}
+}
+
+func TestOverrideAMPRequest(t *testing.T) {
+	request := &openrtb.BidRequest{
+		App: &openrtb.App{ID: "app"},
+		Imp: []openrtb.Imp{{ID: "1", Banner: &openrtb.Banner{Format: []openrtb.Format{{W: 300, H: 250}, {W: 300, H: 600}}}}},
+	}
+	overrideAMPRequest(request, url.Values{"w": []string{"728"}, "h": []string{"90"}, "oh": []string{"50"}, "curl": []string{"http://example.com"}})
+	if formats := request.Imp[0].Banner.Format; len(formats) != 1 || formats[0].W != 728 || formats[0].H != 90 {
+		t.Errorf("w and h should be used unless ow and oh are both set. Got %v", formats)
+	}
+	if request.Site != nil {
+		t.Errorf("curl shouldn't add a site to app requests")
+	}
+
+	request.Imp[0].Banner.Format = []openrtb.Format{{W: 300, H: 250}}
+	overrideAMPRequest(request, url.Values{"w": []string{"abc"}, "h": []string{"90"}})
+	if formats := request.Imp[0].Banner.Format; formats[0].W != 300 {
+		t.Errorf("Bad sizes should be ignored. Got %v", formats)
+	}
+}
+
+func TestVideoAuction(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	cacheServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var puts struct {
+			Puts []json.RawMessage `json:"puts"`
+		}
+		json.NewDecoder(r.Body).Decode(&puts)
+		var responses []string
+		for i := range puts.Puts {
+			responses = append(responses, fmt.Sprintf(`{"uuid":"uuid-%d"}`, i))
+		}
+		fmt.Fprintf(w, `{"responses":[%s]}`, strings.Join(responses, ","))
+	}))
+	defer cacheServer.Close()
+	pbc.InitPrebidCache(cacheServer.URL, 0)
+	bidder := &echoBidder{uri: server.URL}
+	deps := &openrtbAuctionDeps{cfg, exchange.New(adapters.NewHTTPAdapter(adapters.DefaultHTTPAdapterConfig), map[string]adapters.Bidder{"echo": bidder}, nil, nil, nil, nil, nil)}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+
+	run := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openrtb2/video", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		deps.video(rr, req, nil)
+		return rr
+	}
+	const body = `{"id":"req","site":{"page":"http://example.com"},"video":{"mimes":["video/mp4"]},
+		"podconfig":{"durationrangesec":[15,30],"pods":[{"podid":1,"adpoddurationsec":60,"configid":"preroll"}]}}`
+
+	if rr := run(`{"id":"req","site":{},"video":{"mimes":["video/mp4"]},"podconfig":{"durationrangesec":[15]}}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Requests without pods should get a 400. Got %d", rr.Code)
+	}
+	if rr := run(body); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Config failures should get a 500. Got %d", rr.Code)
+	}
+
+	dataCache.Config().Set("preroll", `{"ext":{"echo":{"zone":7}}}`)
+	rr := run(body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a 200. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(bidder.request.Imp) != 4 || bidder.request.Imp[0].Video == nil || bidder.request.Imp[0].Video.MaxDuration != 30 {
+		t.Errorf("The pod should be expanded into 4 video imps. Got %#v", bidder.request.Imp)
+	}
+
+	var response exchange.VideoResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	if len(response.AdPods) != 1 || len(response.AdPods[0].Targeting) != 2 {
+		t.Fatalf("Two 30s ads should fill the 60s pod. Got %s", rr.Body.String())
+	}
+	if targeting := response.AdPods[0].Targeting[1]; targeting.HbPbCatDur != "1.00_30s" || targeting.HbBidder != "echo" || targeting.HbCacheID != "uuid-1" {
+		t.Errorf("Bad targeting: %#v", targeting)
+	}
+}
+
+func TestAuctionMirror(t *testing.T) {
+	mirrored := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mirrored <- string(body)
+	}))
+	defer server.Close()
+
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+	trafficMirror, _ := mirror.New(config.Mirror{URL: server.URL, SampleRate: 1}, server.Client(), metrics.NewRegistry())
+
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps, mirror: trafficMirror}
+	req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "http://www.example.com/news")
+	rr := httptest.NewRecorder()
+	deps.auction(rr, req, nil)
+
+	var resp pbs.PBSResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Bids) != 1 {
+		t.Errorf("Mirrored requests should still be auctioned. Got %s", rr.Body.String())
+	}
+	select {
+	case got := <-mirrored:
+		if !strings.Contains(got, `"tid":"abcd"`) {
+			t.Errorf("The mirror should get the request body. Got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The request wasn't mirrored")
+	}
+
+	analytics := &capturingAnalytics{}
+	deps.analytics = analytics
+	rr = httptest.NewRecorder()
+	deps.auction(rr, httptest.NewRequest("POST", "/auction", failingReader{}), nil)
+	if rr.Code != http.StatusBadRequest || analytics.ao == nil || analytics.ao.Status != http.StatusBadRequest {
+		t.Errorf("Unreadable bodies should get a 400. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case got := <-mirrored:
+		t.Errorf("Unreadable bodies shouldn't be mirrored. Got %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// failingReader is a request body which can't be read.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+func TestChannelMetricName(t *testing.T) {
+	if name := channelMetricName(pbs.ChannelApp); name != "app" {
+		t.Errorf("Known channels should keep their names. Got %s", name)
+	}
+	if name := channelMetricName("made-up"); name != "other" {
+		t.Errorf("Unknown channels should be grouped together. Got %s", name)
+	}
+}
+
+func TestSyntheticAuctionFailure(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+
+	// Without a stored config to load, the canned request can't be parsed.
+	deps := newMonitoringDeps(&auctionDeps{cfg: cfg}, config.Monitoring{Enabled: true, ConfigID: "missing"})
+	rr := httptest.NewRecorder()
+	deps.syntheticAuction(rr, httptest.NewRequest("GET", "/monitoring/auction", nil), nil)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Failed synthetic auctions should return a 503. Got %d", rr.Code)
+	}
+}
+
+func TestStatusReportsRefreshers(t *testing.T) {
+	ref := refresher.New("currency", time.Hour, 0, func() (interface{}, error) { return "rates", nil }, metrics.NewRegistry())
+	ref.Start(nil)
+	refreshers = []*refresher.Refresher{ref}
+	defer func() { refreshers = nil }()
+
+	rr := httptest.NewRecorder()
+	status(rr, httptest.NewRequest("GET", "/status", nil), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status: %d", rr.Code)
+	}
+
+	var resp map[string][]refresher.Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal response failed: %v", err)
+	}
+	if len(resp["refreshers"]) != 1 || resp["refreshers"][0].Name != "currency" || resp["refreshers"][0].Stale {
+		t.Errorf("Expected a fresh currency refresher. Got %#v", resp)
+	}
+}
+
+func TestSortBidsAndAddKeywordsForMobile(t *testing.T) {
+	body := []byte(`{
+	   "max_key_length":20,
+	   "user":{
+	      "gender":"F",
+	      "buyeruid":"test_buyeruid",
+	      "yob":2000,
+	      "id":"testid"
+	   },
+	   "prebid_version":"0.21.0-pre",
+	   "sort_bids":1,
+	   "ad_units":[
+	      {
+	         "sizes":[
+	            {
+	               "w":300,
+	               "h":250
+	            }
+	         ],
+	         "config_id":"ad5ffb41-3492-40f3-9c25-ade093eb4e5f",
+	         "code":"test_adunitcode"
+	      }
+	   ],
+	   "cache_markup":1,
+	   "app":{
+	      "bundle":"AppNexus.PrebidMobileDemo",
+	      "ver":"0.0.1"
+	   },
+	   "sdk":{
+	      "version":"0.0.1",
+	      "platform":"iOS",
+	      "source":"prebid-mobile"
+	   },
+	   "device":{
+	      "ifa":"test_device_ifa",
+	      "osv":"9.3.5",
+	      "os":"iOS",
+	      "make":"Apple",
+	      "model":"iPhone6,1"
+	   },
+	   "tid":"abcd",
+	   "account_id":"aecd6ef7-b992-4e99-9bb8-65e2d984e1dd"
+	}
+    `)
+	r := httptest.NewRequest("POST", "/auction", bytes.NewBuffer(body))
+	d, _ := dummycache.New()
+	hcs := pbs.HostCookieSettings{}
+
+	pbs_req, err := pbs.ParsePBSRequest(r, d, &hcs)
+	if err != nil {
+		t.Errorf("Unexpected error on parsing %v", err)
+	}
+
+	bids := make(pbs.PBSBidSlice, 0)
+
+	fb_bid := pbs.PBSBid{
+		BidID:      "test_bidid",
+		AdUnitCode: "test_adunitcode",
+		BidderCode: "audienceNetwork",
+		Price:      2.00,
+		Adm:        "test_adm",
+		Width:      300,
+		Height:     250,
+		CacheID:    "test_cache_id1",
+	}
+	bids = append(bids, &fb_bid)
+	an_bid := pbs.PBSBid{
+		BidID:      "test_bidid2",
+		AdUnitCode: "test_adunitcode",
+		BidderCode: "appnexus",
+		Price:      1.00,
+		Adm:        "test_adm",
+		Width:      320,
+		Height:     50,
+		CacheID:    "test_cache_id2",
+	}
+	bids = append(bids, &an_bid)
+	nosize_bid := pbs.PBSBid{
+		BidID:      "test_bidid2",
+		AdUnitCode: "test_adunitcode",
+		BidderCode: "nosizebidder",
+		Price:      1.00,
+		Adm:        "test_adm",
+		CacheID:    "test_cache_id2",
+	}
+	bids = append(bids, &nosize_bid)
+	pbs_resp := pbs.PBSResponse{
+		Bids: bids,
+	}
+	sortBidsAddKeywordsMobile(pbs_resp.Bids, pbs_req, "", "", "", 0)
+
+	for _, bid := range bids {
+		if bid.AdServerTargeting == nil {
+			t.Errorf("Ad server targeting should not be nil")
+		}
+		if bid.BidderCode == "audienceNetwork" {
+			if bid.AdServerTargeting["hb_creative_loadtype"] != "demand_sdk" {
+				t.Errorf("Facebook bid should have demand_sdk as hb_creative_loadtype in ad server targeting")
+			}
+			if bid.AdServerTargeting["hb_size"] != "300x250" {
+				t.Errorf("hb_size key was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_pb"] != "2.00" {
+				t.Errorf("hb_pb key was not parsed correctly ", bid.AdServerTargeting["hb_pb"])
+			}
+			if bid.AdServerTargeting["hb_cache_id"] != "test_cache_id1" {
+				t.Errorf("hb_cache_id key was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_bidder"] != "audienceNetwork" {
+				t.Errorf("hb_bidder key was not parsed correctly")
+			}
+		}
+		if bid.BidderCode == "appnexus" {
+			if bid.AdServerTargeting["hb_size_appnexus"] != "320x50" {
+				t.Errorf("hb_size key for appnexus bidder was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_cache_id_appnexus"] != "test_cache_id2" {
+				t.Errorf("hb_cache_id key for appnexus bidder was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_bidder_appnexus"] != "appnexus" {
+				t.Errorf("hb_bidder key for appnexus bidder was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_pb_appnexus"] != "1.00" {
+				t.Errorf("hb_pb key for appnexus bidder was not parsed correctly")
+			}
+			if bid.AdServerTargeting["hb_pb"] != "" {
+				t.Errorf("hb_pb key was parsed for two bidders")
+			}
+		}
+		if bid.BidderCode == "nosizebidder" {
+			if _, exists := bid.AdServerTargeting["hb_size_nosizebidder"]; exists {
+				t.Errorf("hb_size key for nosize bidder was not parsed correctly", bid.AdServerTargeting)
+			}
+		}
+	}
+}
+
+func TestTargetingOptions(t *testing.T) {
+	includeBidderKeys := false
+	pbs_req := &pbs.PBSRequest{
+		AdUnits: []pbs.AdUnit{{Code: "unit"}},
+		Ext: &pbs.PBSRequestExt{
+			Prebid: pbs.PBSRequestExtPrebid{
+				Targeting: &pbs.PBSTargeting{
+					IncludeBidderKeys:  &includeBidderKeys,
+					AlwaysIncludeDeals: true,
+					TargetingOnly:      true,
+				},
+			},
+		},
+	}
+	winner := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "appnexus", Price: 3.00, Adm: "winner_adm", CacheID: "winner_cache"}
+	deal := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "rubicon", Price: 2.00, DealId: "deal_1", CacheID: "deal_cache"}
+	loser := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "pubmatic", Price: 1.00}
+	pbs_resp := pbs.PBSResponse{
+		Bids:         pbs.PBSBidSlice{loser, deal, winner},
+		BidderStatus: []*pbs.PBSBidder{{BidderCode: "appnexus"}},
+	}
+
+	sortBidsAddKeywordsMobile(pbs_resp.Bids, pbs_req, "", "", "", 0)
+
+	if winner.AdServerTargeting["hb_bidder"] != "appnexus" {
+		t.Errorf("The winner should get the winning keys. Got %v", winner.AdServerTargeting)
+	}
+	if _, ok := winner.AdServerTargeting["hb_bidder_appnexus"]; ok {
+		t.Errorf("Bidder keys should not be added when includebidderkeys is false")
+	}
+	if deal.AdServerTargeting["hb_deal_rubicon"] != "deal_1" || deal.AdServerTargeting["hb_pb_rubicon"] != "2.00" {
+		t.Errorf("Deal bids should get bidder keys when alwaysincludedeals is true. Got %v", deal.AdServerTargeting)
+	}
+	if loser.AdServerTargeting != nil {
+		t.Errorf("Bids without deals should not get any keys. Got %v", loser.AdServerTargeting)
+	}
+
+	compactForTargeting(&pbs_resp)
+	if len(pbs_resp.Bids) != 2 {
+		t.Fatalf("Bids without targeting keys should be dropped. Got %d bids", len(pbs_resp.Bids))
+	}
+	for _, bid := range pbs_resp.Bids {
+		if bid.Adm != "" {
+			t.Errorf("Ad markup should be removed from targeting-only responses")
+		}
+	}
+	if pbs_resp.BidderStatus != nil {
+		t.Errorf("Bidder status should be removed from targeting-only responses")
+	}
+}
+
+func TestCacheLocationKeys(t *testing.T) {
+	pbs_req := &pbs.PBSRequest{AdUnits: []pbs.AdUnit{{Code: "unit"}}}
+	cached := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "appnexus", Price: 2.00, CacheID: "abc"}
+	uncached := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "rubicon", Price: 1.00}
+
+	sortBidsAddKeywordsMobile(pbs.PBSBidSlice{cached, uncached}, pbs_req, "", "cache.publisher.com", "/pbc", 0)
+
+	if cached.AdServerTargeting["hb_cache_host"] != "cache.publisher.com" || cached.AdServerTargeting["hb_cache_path"] != "/pbc" {
+		t.Errorf("The winner should get the cache location keys. Got %v", cached.AdServerTargeting)
+	}
+	if cached.AdServerTargeting["hb_cache_host_appnexus"] != "cache.publisher.com" {
+		t.Errorf("Cached bids should get the bidder's cache host key. Got %v", cached.AdServerTargeting)
+	}
+	if _, ok := uncached.AdServerTargeting["hb_cache_host_rubicon"]; ok {
+		t.Errorf("Bids which weren't cached shouldn't get cache location keys. Got %v", uncached.AdServerTargeting)
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	defer func() { tieBreaker = nil }()
+	run := func() string {
+		pbs_req := &pbs.PBSRequest{AdUnits: []pbs.AdUnit{{Code: "unit"}}}
+		fast := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "appnexus", Price: 2.00, ResponseTime: 50}
+		deal := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "rubicon", Price: 2.00, ResponseTime: 90, DealId: "deal"}
+		sortBidsAddKeywordsMobile(pbs.PBSBidSlice{deal, fast}, pbs_req, "", "", "", 0)
+		for _, bid := range []*pbs.PBSBid{fast, deal} {
+			if bid.AdServerTargeting["hb_bidder"] != "" {
+				return bid.BidderCode
+			}
+		}
+		return ""
+	}
+
+	if winner := run(); winner != "appnexus" {
+		t.Errorf("The earliest response should win ties by default. Got %s", winner)
+	}
+	tieBreaker, _ = pbs.NewTieBreaker(pbs.TieBreakDealFirst, nil)
+	if winner := run(); winner != "rubicon" {
+		t.Errorf("The deal should win ties in deal_first mode. Got %s", winner)
+	}
+}
+
+func TestMaxTargetingKeys(t *testing.T) {
+	run := func(maxKeys int) (*pbs.PBSBid, *pbs.PBSBid, []error) {
+		pbs_req := &pbs.PBSRequest{AdUnits: []pbs.AdUnit{{Code: "unit"}}}
+		winner := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "appnexus", Price: 2.00}
+		loser := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "rubicon", Price: 1.00}
+		errs := sortBidsAddKeywordsMobile(pbs.PBSBidSlice{loser, winner}, pbs_req, "", "", "", maxKeys)
+		return winner, loser, errs
+	}
+
+	if winner, loser, errs := run(0); len(winner.AdServerTargeting) != 7 || len(loser.AdServerTargeting) != 3 || errs != nil {
+		t.Errorf("Keys shouldn't be trimmed without a limit. Got %v and %v", winner.AdServerTargeting, loser.AdServerTargeting)
+	}
+
+	winner, loser, errs := run(6)
+	if len(loser.AdServerTargeting) != 0 {
+		t.Errorf("The losing bid's keys should be dropped first. Got %v", loser.AdServerTargeting)
+	}
+	if _, ok := winner.AdServerTargeting["hb_cache_id_appnexus"]; ok || len(winner.AdServerTargeting) != 6 {
+		t.Errorf("The winner's least important bidder key should be dropped next. Got %v", winner.AdServerTargeting)
+	}
+	if len(errs) != 1 || errortypes.ReadCode(errs[0]) != errortypes.TargetingKeysWarningCode {
+		t.Errorf("Expected a warning about the dropped keys. Got %v", errs)
+	}
+
+	winner, _, _ = run(3)
+	for _, key := range []string{"hb_pb", "hb_bidder", "hb_cache_id"} {
+		if _, ok := winner.AdServerTargeting[key]; !ok {
+			t.Errorf("The winner key %s should be kept. Got %v", key, winner.AdServerTargeting)
+		}
+	}
+	if len(winner.AdServerTargeting) != 3 {
+		t.Errorf("Winner keys should be dropped last. Got %v", winner.AdServerTargeting)
+	}
+}
+
+func TestMaxTargetingKeysLimit(t *testing.T) {
+	tests := []struct {
+		accountMax int
+		targeting  *pbs.PBSTargeting
+		expected   int
+	}{
+		{accountMax: 10, targeting: nil, expected: 10},
+		{accountMax: 10, targeting: &pbs.PBSTargeting{}, expected: 10},
+		{accountMax: 0, targeting: &pbs.PBSTargeting{MaxKeys: 20}, expected: 20},
+		{accountMax: 10, targeting: &pbs.PBSTargeting{MaxKeys: 5}, expected: 5},
+		{accountMax: 10, targeting: &pbs.PBSTargeting{MaxKeys: 20}, expected: 10},
+	}
+	for _, test := range tests {
+		if maxKeys := maxTargetingKeys(test.accountMax, test.targeting); maxKeys != test.expected {
+			t.Errorf("Expected %d keys for account limit %d and request %v. Got %d", test.expected, test.accountMax, test.targeting, maxKeys)
+		}
+	}
+}
+
+func TestBidSizeValidate(t *testing.T) {
+
+	bids := make(pbs.PBSBidSlice, 0)
+
+	//bid_1 will be rejected due to undefined size when adunit has multiple sizes
+	bid_1 := pbs.PBSBid{
+		BidID:      "test_bidid1",
+		AdUnitCode: "test_adunitcode1",
+		BidderCode: "randNetwork",
+		Price:      1.05,
+		Adm:        "test_adm",
+		//Width:             100,
+		//Height:            100,
+		CreativeMediaType: "banner",
+	}
+
+	bids = append(bids, &bid_1)
+
+	//bid_2 will be considered a normal ideal banner bid
+	bid_2 := pbs.PBSBid{
+		BidID:             "test_bidid2",
+		AdUnitCode:        "test_adunitcode2",
+		BidderCode:        "randNetwork",
+		Price:             1.05,
+		Adm:               "test_adm",
+		Width:             100,
+		Height:            100,
+		CreativeMediaType: "banner",
+	}
+
+	bids = append(bids, &bid_2)
+
+	//bid_3 will have it's dimensions set based on sizes defined in request
+	bid_3 := pbs.PBSBid{
+		BidID:      "test_bidid3",
+		AdUnitCode: "test_adunitcode3",
+		BidderCode: "randNetwork",
+		Price:      1.05,
+		Adm:        "test_adm",
+		//Width:             200,
+		//Height:            200,
+		CreativeMediaType: "banner",
+	}
+
+	bids = append(bids, &bid_3)
+
+	//bid_4 will be ignored as it's a video creative type
+	bid_4 := pbs.PBSBid{
+		BidID:      "test_bidid_video",
+		AdUnitCode: "test_adunitcode_video",
+		BidderCode: "randNetwork",
+		Price:      1.05,
+		Adm:        "test_adm",
+		//Width:             400,
+		//Height:            400,
+		CreativeMediaType: "video",
 	}
 
 	bids = append(bids, &bid_4)
@@ -400,51 +1725,791 @@ func TestBidSizeValidate(t *testing.T) {
 		},
 	}
 
-	bids = checkForValidBidSize(bids, &mybidder)
+	bids = checkForValidBidSize(bids, &mybidder)
+
+	testdata, _ := json.MarshalIndent(bids, "", "   ")
+	if len(bids) != 3 {
+		t.Errorf("Detected returned bid list did not contain only 3 bid objects as expected.\nBelow is the contents of the bid list\n%v", string(testdata))
+	}
+
+	for _, bid := range bids {
+		if bid.BidID == "test_bidid3" {
+			if bid.Width == 0 && bid.Height == 0 {
+				t.Errorf("Detected the Width & Height attributes in test bidID %v were not set to the dimensions used from the mybidder object", bid.BidID)
+			}
+		}
+	}
+}
+
+func TestInjectTrackers(t *testing.T) {
+	bids := pbs.PBSBidSlice{
+		{BidderCode: "appnexus", CreativeMediaType: "banner", Adm: "<div></div>"},
+		{BidderCode: "rubicon", CreativeMediaType: "banner", NURL: "https://rubicon.example.com/win"},
+	}
+	events := config.AccountEvents{
+		ImpressionURL: "https://events.publisher.com/imp?a={{account}}&b={{bidder}}",
+		AnalyticsURL:  "https://analytics.publisher.com/pixel?t={{timestamp}}",
+	}
+	injectTrackers(bids, "1001", events, "https://pbs.example.com", time.Unix(1500000000, 0))
+	if !strings.Contains(bids[0].Adm, "https://events.publisher.com/imp?a=1001&amp;b=appnexus") || !strings.Contains(bids[0].Adm, "https://analytics.publisher.com/pixel?t=1500000000000") {
+		t.Errorf("Expected both trackers in the banner. Got %s", bids[0].Adm)
+	}
+	if bids[1].Adm != "" {
+		t.Errorf("Bids without markup should be left alone. Got %s", bids[1].Adm)
+	}
+
+	bid := &pbs.PBSBid{BidderCode: "appnexus", CreativeMediaType: "banner", Adm: "<div></div>"}
+	injectTrackers(pbs.PBSBidSlice{bid}, "1001", config.AccountEvents{}, "https://pbs.example.com", time.Now())
+	if bid.Adm != "<div></div>" {
+		t.Errorf("Accounts without events shouldn't get trackers. Got %s", bid.Adm)
+	}
+
+	native := &pbs.PBSBid{BidderCode: "appnexus", CreativeMediaType: "native", Adm: `{"link":{"url":"http://example.com"},"eventtrackers":[]}`}
+	injectTrackers(pbs.PBSBidSlice{native}, "1001", config.AccountEvents{Enabled: true}, "https://pbs.example.com", time.Unix(1500000000, 0))
+	var adm pbs.NativeResponse
+	if err := json.Unmarshal([]byte(native.Adm), &adm); err != nil {
+		t.Fatalf("Bad native markup: %v", err)
+	}
+	if len(adm.EventTrackers) != 1 || adm.EventTrackers[0].URL != "https://pbs.example.com/event?t=imp&a=1001&bidder=appnexus&ts=1500000000000" {
+		t.Errorf("Expected an eventtracker for the /event endpoint. Got %s", native.Adm)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+	accounts := map[string]config.Account{
+		"1001": {Events: config.AccountEvents{Enabled: true}},
+		"1002": {},
+	}
+	deps := &eventDeps{func(id string) config.Account { return accounts[id] }}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"t=imp&a=1001&bidder=appnexus&ts=1500000000000", http.StatusNoContent},
+		{"t=win&a=1001", http.StatusBadRequest},
+		{"t=imp", http.StatusBadRequest},
+		{"t=imp&a=1002", http.StatusUnauthorized},
+		{"t=imp&a=unknown", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		deps.event(recorder, httptest.NewRequest("GET", "/event?"+test.query, nil), nil)
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected a %d. Got %d", test.query, test.expected, recorder.Code)
+		}
+	}
+	if count := getAccountMetrics("1001").ImpressionEventMeter.Count(); count != 1 {
+		t.Errorf("Expected one impression event for account 1001. Got %d", count)
+	}
+}
+
+func TestValidateAdUnitParams(t *testing.T) {
+	defer func() { paramsValidator = nil }()
+	// appnexus2 is a request alias, so it gets the appnexus schema.
+	bidder := &pbs.PBSBidder{
+		BidderCode: "appnexus2",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "good", Params: json.RawMessage(`{"placementId":10433394}`)},
+			{Code: "bad", Params: json.RawMessage(`{"placementId":"10433394"}`)},
+		},
+	}
+	if units, errs := validateAdUnitParams(bidder, "appnexus"); len(units) != 2 || len(errs) != 0 {
+		t.Errorf("Params shouldn't be checked without a validator. Got %d units and %v", len(units), errs)
+	}
+
+	var err error
+	paramsValidator, err = adapters.NewParamsValidator(schemaDirectory)
+	if err != nil {
+		t.Fatalf("Failed to load the bidder params schemas: %v", err)
+	}
+	units, errs := validateAdUnitParams(bidder, "appnexus")
+	if len(units) != 1 || units[0].Code != "good" {
+		t.Errorf("Expected only the ad unit with good params. Got %v", units)
+	}
+	if len(errs) != 1 || errortypes.ReadCode(errs[0]) != errortypes.BadInputErrorCode || !strings.Contains(errs[0].Error(), "ad unit bad") {
+		t.Errorf("Expected a BadInput error for the bad ad unit. Got %v", errs)
+	}
+}
+
+func TestCheckNativeBids(t *testing.T) {
+	bidder := &pbs.PBSBidder{
+		BidderCode: "appnexus",
+		AdUnits: []pbs.PBSAdUnit{
+			{
+				Code:   "native_unit",
+				BidID:  "native_bid",
+				Native: json.RawMessage(`{"assets":[{"id":1,"required":1,"title":{"len":90}}]}`),
+			},
+		},
+	}
+	bids := pbs.PBSBidSlice{
+		{
+			BidID:             "native_bid",
+			AdUnitCode:        "native_unit",
+			CreativeMediaType: "native",
+			Adm:               `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}}]}`,
+		},
+		{
+			BidID:             "native_bid",
+			AdUnitCode:        "native_unit",
+			CreativeMediaType: "native",
+			Adm:               `<div>Not native</div>`,
+		},
+		{
+			BidID:             "unknown_bid",
+			AdUnitCode:        "unknown_unit",
+			CreativeMediaType: "native",
+			Adm:               `{"link":{"url":"http://example.com"},"assets":[{"id":1,"title":{"text":"Buy"}}]}`,
+		},
+		{
+			BidID:             "banner_bid",
+			AdUnitCode:        "banner_unit",
+			CreativeMediaType: "banner",
+		},
+	}
+
+	checked := checkNativeBids(bids, bidder)
+	if len(checked) != 2 || checked[0] != bids[0] || checked[1] != bids[3] {
+		t.Errorf("Expected only the valid native bid and the banner bid to be kept. Got %d bids", len(checked))
+	}
+}
 
-	testdata, _ := json.MarshalIndent(bids, "", "   ")
-	if len(bids) != 3 {
-		t.Errorf("Detected returned bid list did not contain only 3 bid objects as expected.\nBelow is the contents of the bid list\n%v", string(testdata))
+func TestCheckBidMeta(t *testing.T) {
+	bids := pbs.PBSBidSlice{
+		{
+			BidID:             "with_domains",
+			CreativeMediaType: "video",
+			Meta: &pbs.PBSBidMeta{
+				AdvertiserDomains: []string{"advertiser.com"},
+			},
+		},
+		{
+			BidID:             "without_meta",
+			CreativeMediaType: "banner",
+		},
 	}
 
+	bids = checkBidMeta(bids, false)
+	if len(bids) != 2 {
+		t.Fatalf("Expected 2 bids when advertiser domains aren't required. Got %d", len(bids))
+	}
 	for _, bid := range bids {
-		if bid.BidID == "test_bidid3" {
-			if bid.Width == 0 && bid.Height == 0 {
-				t.Errorf("Detected the Width & Height attributes in test bidID %v were not set to the dimensions used from the mybidder object", bid.BidID)
-			}
+		if bid.Meta == nil {
+			t.Fatalf("Bid %s should have been given a meta object.", bid.BidID)
+		}
+		if bid.Meta.MediaType != bid.CreativeMediaType {
+			t.Errorf("Bid %s has meta.mediaType %s. Expected %s", bid.BidID, bid.Meta.MediaType, bid.CreativeMediaType)
+		}
+	}
+
+	bids = checkBidMeta(bids, true)
+	if len(bids) != 1 {
+		t.Fatalf("Expected 1 bid when advertiser domains are required. Got %d", len(bids))
+	}
+	if bids[0].BidID != "with_domains" {
+		t.Errorf("The wrong bid was rejected. Remaining bid: %s", bids[0].BidID)
+	}
+}
+
+func TestRejectedBids(t *testing.T) {
+	kept := &pbs.PBSBid{BidID: "kept"}
+	dropped := &pbs.PBSBid{BidID: "dropped"}
+	meter := metrics.NewMeter()
+	ametrics := &AdapterMetrics{RejectedBidMeters: map[string]metrics.Meter{analytics.RejectedAdvertiserDomains: meter}}
+	rejected := rejectedBids(ametrics, pbs.PBSBidSlice{kept, dropped}, pbs.PBSBidSlice{kept}, analytics.RejectedAdvertiserDomains)
+	if len(rejected) != 1 || rejected[0].Bid != dropped || rejected[0].Reason != analytics.RejectedAdvertiserDomains {
+		t.Errorf("Expected the dropped bid to be rejected for missing domains. Got %v", rejected)
+	}
+	if meter.Count() != 1 {
+		t.Errorf("Expected the rejection to be counted in the bidder's meter. Got %d", meter.Count())
+	}
+	if rejected := rejectedBids(nil, pbs.PBSBidSlice{kept, dropped}, pbs.PBSBidSlice{kept}, analytics.RejectedBlockedDomain); len(rejected) != 1 {
+		t.Errorf("Bidders without metrics should still have their bids rejected. Got %v", rejected)
+	}
+	if rejected := rejectedBids(ametrics, pbs.PBSBidSlice{kept}, pbs.PBSBidSlice{kept}, analytics.RejectedMissingSize); rejected != nil {
+		t.Errorf("Nothing should be rejected if every bid was kept. Got %v", rejected)
+	}
+}
+
+func TestRecordOriginalPrices(t *testing.T) {
+	fromAdapter := &pbs.PBSBid{
+		Price: 2.0,
+		Ext:   &pbs.PBSBidExt{OrigBidCPM: 2.0, OrigBidCur: "EUR"},
+	}
+	withoutExt := &pbs.PBSBid{Price: 1.5}
+
+	recordOriginalPrices(pbs.PBSBidSlice{fromAdapter, withoutExt})
+
+	if fromAdapter.Ext.OrigBidCur != "EUR" {
+		t.Errorf("The original currency from the adapter should be kept. Got %s", fromAdapter.Ext.OrigBidCur)
+	}
+	if withoutExt.Ext == nil || withoutExt.Ext.OrigBidCPM != 1.5 || withoutExt.Ext.OrigBidCur != "USD" {
+		t.Errorf("Bids without an ext should default to their price in USD. Got %#v", withoutExt.Ext)
+	}
+}
+
+func TestAttachDemandChains(t *testing.T) {
+	winner := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "appnexus", Price: 2.0}
+	loser := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "rubicon", Price: 1.0}
+	otherUnit := &pbs.PBSBid{
+		AdUnitCode: "other",
+		BidderCode: "rubicon",
+		Price:      0.5,
+		Meta:       &pbs.PBSBidMeta{DChain: json.RawMessage(`{"complete":1,"nodes":[{"asi":"dsp.com"}]}`)},
+	}
+
+	registry := adapters.NewBidderRegistry(adapters.BidderInfos{"appnexus": {ASI: "appnexus.com"}, "rubicon": {ASI: "rubiconproject.com"}}, nil)
+	attachDemandChains(pbs.PBSBidSlice{loser, winner, otherUnit}, &pbs.PBSRequest{}, registry, pbs.DemandChainNode{ASI: "host.com"})
+
+	if loser.Meta != nil && len(loser.Meta.DChain) > 0 {
+		t.Errorf("Losing bids shouldn't get a dchain.")
+	}
+	if winner.Meta == nil || len(winner.Meta.DChain) == 0 {
+		t.Fatalf("The winning bid should get a dchain.")
+	}
+
+	var chain pbs.DemandChain
+	if err := json.Unmarshal(otherUnit.Meta.DChain, &chain); err != nil {
+		t.Fatalf("Failed to unmarshal dchain: %v", err)
+	}
+	if len(chain.Nodes) != 3 || chain.Nodes[0].ASI != "dsp.com" || chain.Nodes[2].ASI != "host.com" {
+		t.Errorf("Bidder-provided nodes should be extended by the bidder and host nodes. Got %#v", chain.Nodes)
+	}
+	if chain.Nodes[1].ASI != "rubiconproject.com" || chain.Nodes[1].Name != "rubicon" {
+		t.Errorf("The bidder node should have the asi from the bidder info. Got %#v", chain.Nodes[1])
+	}
+
+	unknown := &pbs.PBSBid{AdUnitCode: "unit", BidderCode: "debug", Price: 1.0}
+	attachDemandChains(pbs.PBSBidSlice{unknown}, &pbs.PBSRequest{}, registry, pbs.DemandChainNode{ASI: "host.com"})
+	chain = pbs.DemandChain{}
+	if err := json.Unmarshal(unknown.Meta.DChain, &chain); err != nil {
+		t.Fatalf("Failed to unmarshal dchain: %v", err)
+	}
+	if len(chain.Nodes) != 1 || chain.Nodes[0].ASI != "host.com" {
+		t.Errorf("Bidders without an asi should be left out of the chain. Got %#v", chain.Nodes)
+	}
+}
+
+func TestCheckRendererHints(t *testing.T) {
+	sdk := &pbs.SDK{Renderers: []pbs.SDKRenderer{{Name: "MraidRenderer", Version: "1.0"}}}
+	bids := pbs.PBSBidSlice{
+		{BidderCode: "appnexus", Meta: &pbs.PBSBidMeta{RendererName: "MraidRenderer", RendererVersion: "1.0"}},
+		{BidderCode: "rubicon", Meta: &pbs.PBSBidMeta{RendererName: "Unknown", RendererVersion: "2.0"}},
+	}
+	checkRendererHints(bids, sdk)
+	if bids[0].Meta.RendererName != "MraidRenderer" {
+		t.Errorf("Supported renderers should be kept")
+	}
+	if bids[1].Meta.RendererName != "" || bids[1].Meta.RendererVersion != "" {
+		t.Errorf("Unsupported renderers should be dropped. Got %v", bids[1].Meta)
+	}
+}
+
+func TestHTTPAdapterConfigFailover(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	setupExchanges(cfg)
+
+	if httpAdapterConfig("lifestreet", config.Adapter{Endpoint: "http://primary.com"}).Failover != nil {
+		t.Errorf("Bidders without a secondary endpoint shouldn't fail over")
+	}
+	httpConfig := httpAdapterConfig("lifestreet", config.Adapter{Endpoint: "http://primary.com", SecondaryEndpoint: "http://secondary.com"})
+	if httpConfig.Failover == nil {
+		t.Fatalf("Bidders with a secondary endpoint should fail over")
+	}
+	httpConfig.Failover.OnChange(true)
+	httpConfig.Failover.OnChange(false)
+	if adapterMetrics["lifestreet"].FailoverMeter.Count() != 1 || adapterMetrics["lifestreet"].RecoveryMeter.Count() != 1 {
+		t.Errorf("Failovers should be counted in the adapter's metrics")
+	}
+}
+
+func TestSDKMetricName(t *testing.T) {
+	tests := map[string]*pbs.SDK{
+		"sdk.ios.1_2.requests":         {Platform: "iOS", Version: "1.2.3"},
+		"sdk.android.unknown.requests": {Platform: "android", Version: "latest"},
+		"sdk.other.0_0.requests":       {Platform: "web", Version: "0.0.2"},
+		"sdk.android.other.requests":   {Platform: "android", Version: "2.999.0"},
+		"sdk.ios.other.requests":       {Platform: "ios", Version: "123456.0.0"},
+	}
+	for expected, sdk := range tests {
+		if name := sdkMetricName(sdk); name != expected {
+			t.Errorf("Expected %s. Got %s", expected, name)
+		}
+	}
+}
+
+type panickingAdapter struct{}
+
+func (a *panickingAdapter) Name() string                                          { return "panicking" }
+func (a *panickingAdapter) FamilyName() string                                    { return "panicking" }
+func (a *panickingAdapter) SkipNoCookies() bool                                   { return false }
+func (a *panickingAdapter) GetUsersyncInfo(pbs.UsersyncPrivacy) *pbs.UsersyncInfo { return nil }
+
+func (a *panickingAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	var bids pbs.PBSBidSlice
+	return pbs.PBSBidSlice{bids[0]}, nil
+}
+
+func TestCallAdapterRecoversPanics(t *testing.T) {
+	bids, err := callAdapter(context.Background(), &panickingAdapter{}, &pbs.PBSRequest{}, &pbs.PBSBidder{BidderCode: "panicking"})
+	if bids != nil {
+		t.Errorf("A panicking adapter shouldn't return bids")
+	}
+	if _, ok := err.(*adapters.PanicError); !ok {
+		t.Errorf("Expected a PanicError. Got %v", err)
+	}
+}
+
+func TestBidLatch(t *testing.T) {
+	latch := newBidLatch([]pbs.AdUnit{{Code: "first"}, {Code: "second"}}, 0.5)
+	if latch.Add(pbs.PBSBidSlice{{AdUnitCode: "first", Price: 1}, {AdUnitCode: "second", Price: 0.1}}) {
+		t.Errorf("A bid below the minimum CPM shouldn't count")
+	}
+	if latch.Add(nil) {
+		t.Errorf("No bids shouldn't release the latch")
+	}
+	if !latch.Add(pbs.PBSBidSlice{{AdUnitCode: "second", Price: 0.5}}) {
+		t.Errorf("Every ad unit has a qualifying bid, so the auction can return")
+	}
+}
+
+func TestSkipPendingBidders(t *testing.T) {
+	done := &pbs.PBSBidder{BidderCode: "appnexus", NumBids: 1}
+	slow := &pbs.PBSBidder{BidderCode: "rubicon", NoCookie: true}
+	status := skipPendingBidders([]*pbs.PBSBidder{done, slow}, map[*pbs.PBSBidder]bool{slow: true}, "Timed out")
+	if status[0] != done {
+		t.Errorf("Bidders which responded should be reported as-is")
+	}
+	if status[1] == slow || status[1].BidderCode != "rubicon" || !status[1].NoCookie || status[1].Error != "Timed out" {
+		t.Errorf("Pending bidders should be replaced with a copy which explains the missing bids. Got %#v", status[1])
+	}
+}
+
+func TestWithoutShadowBidders(t *testing.T) {
+	shadowBidders = map[string]bool{"lifestreet": true}
+	defer func() { shadowBidders = nil }()
+
+	req := &pbs.PBSRequest{Ext: &pbs.PBSRequestExt{Prebid: pbs.PBSRequestExtPrebid{Aliases: map[string]string{"lifestreet2": "lifestreet"}}}}
+	status := withoutShadowBidders([]*pbs.PBSBidder{{BidderCode: "appnexus"}, {BidderCode: "Lifestreet"}, {BidderCode: "lifestreet2"}}, req)
+	if len(status) != 1 || status[0].BidderCode != "appnexus" {
+		t.Errorf("Shadow bidders and their aliases shouldn't be reported to clients. Got %v", status)
+	}
+}
+
+func TestLateBidCollector(t *testing.T) {
+	ch := make(chan bidResult, 2)
+	ch <- bidResult{bidder: &pbs.PBSBidder{BidderCode: "rubicon"}, bid_list: pbs.PBSBidSlice{{BidderCode: "rubicon", Price: 2}}}
+	ch <- bidResult{bidder: &pbs.PBSBidder{BidderCode: "appnexus"}}
+	cancelled := false
+	collector := &lateBidCollector{results: ch, count: 2, cancel: func() { cancelled = true }}
+
+	bids := collector.Collect()
+	if len(bids) != 1 || bids[0].BidderCode != "rubicon" {
+		t.Errorf("Expected the late rubicon bid. Got %v", bids)
+	}
+	if !cancelled {
+		t.Errorf("The bidders' context should be released once they're all done")
+	}
+}
+
+// slowAdapter answers after the delay, unless its context ends first.
+type slowAdapter struct {
+	adapters.Adapter
+	delay time.Duration
+}
+
+func (a *slowAdapter) Call(ctx context.Context, req *pbs.PBSRequest, bidder *pbs.PBSBidder) (pbs.PBSBidSlice, error) {
+	select {
+	case <-time.After(a.delay):
+		return a.Adapter.Call(ctx, req, bidder)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// notifyingAnalytics sends each auction to the channel once it's logged.
+type notifyingAnalytics struct {
+	logged chan *analytics.AuctionObject
+}
+
+func (n *notifyingAnalytics) LogAuctionObject(ao *analytics.AuctionObject) {
+	n.logged <- ao
+}
+
+func TestLateBidMetrics(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	cfg.LateBidGrace = 1000
+	setupExchanges(cfg)
+	exchanges["debug"] = &slowAdapter{Adapter: exchanges["debug"], delay: 100 * time.Millisecond}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	debugMetrics := adapterMetrics["debug"]
+	timeouts, prices, lateBids := debugMetrics.TimeoutMeter.Count(), debugMetrics.PriceHistogram.Count(), mLateBidsMeter.Count()
+	logged := make(chan *analytics.AuctionObject, 1)
+	deps := &auctionDeps{cfg: cfg, analytics: &notifyingAnalytics{logged: logged}, experiments: exps}
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":20,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "http://www.example.com/news")
+	deps.auction(httptest.NewRecorder(), req, nil)
+
+	var ao *analytics.AuctionObject
+	select {
+	case ao = <-logged:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("The auction wasn't logged")
+	}
+	if len(ao.LateBids) != 1 {
+		t.Errorf("Expected the late bid to be logged. Got %v", ao.LateBids)
+	}
+	if count := debugMetrics.TimeoutMeter.Count() - timeouts; count != 1 {
+		t.Errorf("The timeout should be counted once. Got %d", count)
+	}
+	if count := mLateBidsMeter.Count() - lateBids; count != 1 {
+		t.Errorf("Expected one late bid to be counted. Got %d", count)
+	}
+	if count := debugMetrics.PriceHistogram.Count() - prices; count != 0 {
+		t.Errorf("Late bids shouldn't be in the price histogram. Got %d", count)
+	}
+}
+
+func TestShadowBidders(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	shadowBidders = map[string]bool{"debug": true}
+	defer func() { shadowBidders = nil }()
+	exchanges["debug"] = &slowAdapter{Adapter: exchanges["debug"], delay: 300 * time.Millisecond}
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+
+	am := getAccountMetrics("1")
+	bidsReceived, prices := am.BidsReceivedMeter.Count(), am.PriceHistogram.Count()
+	logged := make(chan *analytics.AuctionObject, 1)
+	deps := &auctionDeps{cfg: cfg, analytics: &notifyingAnalytics{logged: logged}, experiments: exps}
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":1000,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+	req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "http://www.example.com/news")
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	deps.auction(rr, req, nil)
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("The auction shouldn't wait for shadow bidders. It took %v", elapsed)
+	}
+	var resp pbs.PBSResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Bids) != 0 || len(resp.BidderStatus) != 0 {
+		t.Errorf("Shadow bidders shouldn't be in the response. Got %s", rr.Body.String())
+	}
+
+	var ao *analytics.AuctionObject
+	select {
+	case ao = <-logged:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("The auction wasn't logged")
+	}
+	if len(ao.ShadowBids) != 1 {
+		t.Errorf("Expected the shadow bid to be logged. Got %v", ao.ShadowBids)
+	}
+	if am.BidsReceivedMeter.Count() != bidsReceived || am.PriceHistogram.Count() != prices {
+		t.Errorf("Shadow bids shouldn't be in the account's metrics")
+	}
+}
+
+func TestAuctionBidderControls(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+	deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps}
+
+	body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}],` +
+		`"ext":{"prebid":{"biddercontrols":{"skip":["debug"]}}}}`
+	req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+	req.Header.Set("Referer", "http://www.example.com/news")
+	rr := httptest.NewRecorder()
+	deps.auction(rr, req, nil)
+	var resp pbs.PBSResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad response: %s", rr.Body.String())
+	}
+	if len(resp.Bids) != 0 || len(resp.BidderStatus) != 1 || resp.BidderStatus[0].Error != "Skipped by ext.prebid.biddercontrols" {
+		t.Errorf("Bidders left out by the bidder controls should say so in their status. Got %#v", resp.BidderStatus)
+	}
+}
+
+// noCookieSkipper is an adapter which skips users who haven't synced with it.
+type noCookieSkipper struct {
+	adapters.Adapter
+}
+
+func (a *noCookieSkipper) SkipNoCookies() bool { return true }
+
+func TestThrottledBidders(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Unable to config: %v", err)
+	}
+	cfg.DebugBidder = config.DebugBidder{Enabled: true, Price: 1.5}
+	setupExchanges(cfg)
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+	exps, _ := experiments.New(nil, "", metrics.NewRegistry())
+	none := 0
+	throttles, _ := throttle.NewThrottles(map[string]config.Throttle{"debug": {Percent: &none}})
+	deps := &auctionDeps{cfg: cfg, analytics: &capturingAnalytics{}, experiments: exps, throttles: throttles}
+
+	run := func() pbs.PBSResponse {
+		body := `{"tid":"abcd","account_id":"1","timeout_millis":500,"ad_units":[{"code":"top","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"debug","bid_id":"1"}]}]}`
+		req := httptest.NewRequest("POST", "/auction", bytes.NewBufferString(body))
+		req.Header.Set("Referer", "http://www.example.com/news")
+		rr := httptest.NewRecorder()
+		deps.auction(rr, req, nil)
+		var resp pbs.PBSResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad response: %s", rr.Body.String())
+		}
+		return resp
+	}
+
+	resp := run()
+	if len(resp.Bids) != 0 || len(resp.BidderStatus) != 1 || resp.BidderStatus[0].Error != "Skipped by the bidder's throttle" {
+		t.Errorf("Throttled bidders should say so in their status. Got %#v", resp.BidderStatus)
+	}
+
+	exchanges["debug"] = &noCookieSkipper{Adapter: exchanges["debug"]}
+	deps.throttles, _ = throttle.NewThrottles(map[string]config.Throttle{"debug": {MaxQPS: 1}})
+	run()
+	if !deps.throttles["debug"].Allow("efgh") {
+		t.Errorf("Bidders which skip users without cookies shouldn't use up their QPS on them")
+	}
+}
+
+func TestCheckBidCurrencies(t *testing.T) {
+	bids := pbs.PBSBidSlice{
+		{BidID: "usd", Price: 1, Ext: &pbs.PBSBidExt{OrigBidCPM: 1, OrigBidCur: "USD"}},
+		{BidID: "eur", Price: 2, Ext: &pbs.PBSBidExt{OrigBidCPM: 2, OrigBidCur: "EUR"}},
+		{BidID: "gbp", Price: 3, Ext: &pbs.PBSBidExt{OrigBidCPM: 3, OrigBidCur: "GBP"}},
+	}
+
+	// Without an allow list, bids in any currency are still converted into USD.
+	registry := metrics.NewRegistry()
+	rates := refresher.New("currency", time.Hour, 0, func() (interface{}, error) {
+		return currency.ParseRates([]byte(`{"dataAsOf":"2018-03-15","conversions":{"USD":{"EUR":0.8}}}`))
+	}, registry)
+	rates.Start(nil)
+	valid, errs := checkBidCurrencies(bids, nil, currency.NewConverter(rates, registry))
+	if len(valid) != 2 || valid[0].BidID != "usd" || valid[1].BidID != "eur" || valid[1].Price != 2.5 {
+		t.Errorf("The USD bid should pass through, and the EUR bid should be converted. Got %v", valid)
+	}
+	if len(errs) != 1 || errortypes.ReadCode(errs[0]) != errortypes.BidCurrencyWarningCode {
+		t.Errorf("The GBP bid should be rejected, since it can't be converted. Got %v", errs)
+	}
+
+	// Without a converter, EUR bids can't be priced in USD.
+	valid, errs = checkBidCurrencies(bids, []string{"usd", "EUR"}, nil)
+	if len(valid) != 1 || valid[0].BidID != "usd" {
+		t.Errorf("Only the USD bid should be valid. Got %v", valid)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 warnings. Got %v", errs)
+	}
+	for _, err := range errs {
+		if code := errortypes.ReadCode(err); code != errortypes.BidCurrencyWarningCode {
+			t.Errorf("Expected warning code %d. Got %d", errortypes.BidCurrencyWarningCode, code)
 		}
 	}
 }
 
-func TestNewJsonDirectoryServer(t *testing.T) {
+func TestMeasureConfigs(t *testing.T) {
+	cfg, _ := config.New()
+	setupExchanges(cfg)
+	dummyCache, _ := dummycache.New()
+	configCache := measureConfigs(dummyCache, "dummy", "auction")
+
+	configCache.Config().Get("missing")
+	dummyCache.Config().Set("config", `[]`)
+	configCache.Config().Get("config")
+	configCache.Config().Get("config")
+
+	if count := metrics.GetOrRegisterMeter("stored_configs.dummy.auction.found", metricsRegistry).Count(); count != 2 {
+		t.Errorf("Expected 2 found configs. Got %d", count)
+	}
+	if count := metrics.GetOrRegisterMeter("stored_configs.dummy.auction.error", metricsRegistry).Count(); count != 1 {
+		t.Errorf("Expected 1 failed config. Got %d", count)
+	}
+	if count := metrics.GetOrRegisterTimer("stored_configs.dummy.auction.fetch_time", metricsRegistry).Count(); count != 3 {
+		t.Errorf("Expected 3 timed fetches. Got %d", count)
+	}
+}
+
+func TestCurrencyRates(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&currencyRatesDeps{}).currencyRates(rr, httptest.NewRequest("GET", "/currency/rates", nil), nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 without currency conversion. Got %d", rr.Code)
+	}
+
+	registry := metrics.NewRegistry()
+	rates := refresher.New("currency", time.Hour, 0, func() (interface{}, error) {
+		return currency.ParseRates([]byte(`{"dataAsOf":"2018-03-15","conversions":{"USD":{"EUR":0.8}}}`))
+	}, registry)
+	rates.Start(nil)
+	rr = httptest.NewRecorder()
+	(&currencyRatesDeps{currency.NewConverter(rates, registry)}).currencyRates(rr, httptest.NewRequest("GET", "/currency/rates", nil), nil)
+
+	var resp currencyRatesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	if resp.Rates == nil || resp.Rates.Conversions["USD"]["EUR"] != 0.8 || resp.Status.Name != "currency" {
+		t.Errorf("Expected the active rates. Got %s", rr.Body.String())
+	}
+}
+
+func TestBidderRegistryEndpoint(t *testing.T) {
+	registry := adapters.NewBidderRegistry(adapters.BidderInfos{
+		"appnexus": {Capabilities: &adapters.CapabilitiesInfo{Site: &adapters.PlatformInfo{MediaTypes: []string{"banner"}}}, GVLVendorID: 32},
+	}, map[string]string{"appnexus2": "appnexus"})
+	rr := httptest.NewRecorder()
+	(&bidderRegistryDeps{registry}).bidderRegistry(rr, httptest.NewRequest("GET", "/bidders", nil), nil)
+
+	var resp bidderRegistryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	if len(resp.Bidders) != 2 || resp.Bidders[0].Code != "appnexus" || resp.Bidders[1].Core != "appnexus" {
+		t.Errorf("Expected appnexus and its alias. Got %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"media_types":{"site":["banner"]}`) {
+		t.Errorf("The dump should include the resolved media types. Got %s", rr.Body.String())
+	}
+}
 
-	handler := NewJsonDirectoryServer(schemaDirectory)
+func TestNewBidderParamsServer(t *testing.T) {
+	validator, err := adapters.NewParamsValidator(schemaDirectory)
+	if err != nil {
+		t.Fatalf("Failed to load the bidder params schemas: %v", err)
+	}
+	handler := NewBidderParamsServer(validator, []string{"appnexus", "audienceNetwork", "districtm", "indexExchange", "noschema"})
 	recorder := httptest.NewRecorder()
-	request, _ := http.NewRequest("GET", "/whatever", nil)
+	request, _ := http.NewRequest("GET", "/bidders/params", nil)
 	handler(recorder, request, nil)
 
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Bad Content-Type: %s", contentType)
+	}
 	var data map[string]json.RawMessage
-	json.Unmarshal(recorder.Body.Bytes(), &data)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Bad response: %v", err)
+	}
+	for _, code := range []string{"appnexus", "audienceNetwork", "districtm", "indexExchange"} {
+		ensureHasKey(t, data, code)
+	}
+	if _, ok := data["noschema"]; ok {
+		t.Error("Bidders without a schema shouldn't be served")
+	}
+	if _, ok := data["facebook"]; ok {
+		t.Error("Schemas should be keyed by bidder code, not by file name")
+	}
+	if string(data["districtm"]) != string(data["appnexus"]) {
+		t.Error("districtm should be served the appnexus schema")
+	}
+}
 
-	// Make sure that every adapter has a json schema file associated with it
-	adapterFiles, err := ioutil.ReadDir(adapterDirectory)
-	if err != nil {
-		t.Fatalf("Failed to open the adapters directory: %v", err)
+func TestBidderInfoEndpoints(t *testing.T) {
+	infos := adapters.BidderInfos{
+		"appnexus": {
+			Maintainer:   &adapters.MaintainerInfo{Email: "info@prebid.org"},
+			Capabilities: &adapters.CapabilitiesInfo{App: &adapters.PlatformInfo{MediaTypes: []string{"banner", "video"}}},
+		},
+		"rubicon": {
+			Maintainer:   &adapters.MaintainerInfo{Email: "header-bidding@rubiconproject.com"},
+			Capabilities: &adapters.CapabilitiesInfo{Site: &adapters.PlatformInfo{MediaTypes: []string{"banner"}}},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/info/bidders", nil)
+	NewBiddersInfoEndpoint(infos)(recorder, request, nil)
+	if body := recorder.Body.String(); body != `["appnexus","rubicon"]` {
+		t.Errorf("Bad bidder list: %s", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/info/bidders/appnexus", nil)
+	NewBidderInfoEndpoint(infos)(recorder, request, httprouter.Params{{Key: "bidderName", Value: "appnexus"}})
+	var info adapters.BidderInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Bad bidder info: %v", err)
+	}
+	if info.Maintainer.Email != "info@prebid.org" || !info.Supports("app", "video") || info.Capabilities.Site != nil {
+		t.Errorf("Bad bidder info: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/info/bidders/unknown", nil)
+	NewBidderInfoEndpoint(infos)(recorder, request, httprouter.Params{{Key: "bidderName", Value: "unknown"}})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for unknown bidders. Got %d", recorder.Code)
 	}
+}
 
-	for _, adapterFile := range adapterFiles {
-		if adapterFile.IsDir() {
-			ensureHasKey(t, data, adapterFile.Name())
+// Make sure that every registered bidder has a params schema and info
+func TestBiddersHaveParamsAndInfo(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg.DebugBidder.Enabled = true
+	setupExchanges(cfg)
+	validator, err := adapters.NewParamsValidator(schemaDirectory)
+	if err != nil {
+		t.Fatalf("Failed to load the bidder params schemas: %v", err)
+	}
+	bidderCodes := make([]string, 0, len(exchanges))
+	for code := range exchanges {
+		if validator.Schema(code) == nil {
+			t.Errorf("Bidder %s has no params schema", code)
 		}
+		bidderCodes = append(bidderCodes, code)
+	}
+	if _, err := adapters.ParseBidderInfos(infoDirectory, bidderCodes); err != nil {
+		t.Errorf("Failed to load the bidder info: %v", err)
 	}
 }
 
 func TestWriteAuctionError(t *testing.T) {
 	recorder := httptest.NewRecorder()
-	writeAuctionError(recorder, "some error message", nil)
+	writeAuctionError(recorder, http.StatusBadRequest, "some error message", nil)
 	var resp pbs.PBSResponse
 	json.Unmarshal(recorder.Body.Bytes(), &resp)
 
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400. Got %d", recorder.Code)
+	}
 	if len(resp.Bids) != 0 {
 		t.Errorf("Error responses should return no bids.")
 	}
@@ -457,6 +2522,78 @@ func TestWriteAuctionError(t *testing.T) {
 	}
 }
 
+func TestValidateRequestSchema(t *testing.T) {
+	b, err := ioutil.ReadFile("static/pbs_request.json")
+	if err != nil {
+		t.Fatalf("Unable to open pbs_request.json: %v", err)
+	}
+	reqSchema, err = gojsonschema.NewSchema(gojsonschema.NewStringLoader(string(b)))
+	if err != nil {
+		t.Fatalf("Unable to load request schema: %v", err)
+	}
+	defer func() { reqSchema = nil }()
+
+	// /auction accepts ad units with bids, a config_id, both or neither.
+	units := []string{
+		`{"code":"a","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus","bid_id":"1"}]}`,
+		`{"code":"b","sizes":[{"w":300,"h":250}],"config_id":"c1"}`,
+		`{"code":"c","sizes":[{"w":300,"h":250}],"bids":[{"bidder":"appnexus","bid_id":"2"}],"config_id":"c1"}`,
+		`{"code":"d","sizes":[{"w":300,"h":250}]}`,
+	}
+	for _, unit := range units {
+		recorder := httptest.NewRecorder()
+		validate(recorder, httptest.NewRequest("POST", "/validate", strings.NewReader(`{"account_id":"1001","ad_units":[`+unit+`]}`)), nil)
+		if body := recorder.Body.String(); body != "Validation successful\n" {
+			t.Errorf("Expected %s to be valid. Got %s", unit, body)
+		}
+	}
+}
+
+func TestCacheAdminEndpoints(t *testing.T) {
+	var fetches int
+	rates := refresher.New("currency", time.Hour, 0, func() (interface{}, error) {
+		fetches++
+		return fetches, nil
+	}, metrics.NewRegistry())
+	refreshers = []*refresher.Refresher{rates}
+	defer func() { refreshers = nil }()
+	dataCache, _ = dummycache.New()
+	defer func() { dataCache = nil }()
+
+	router := httprouter.New()
+	router.GET("/caches", requireAdminToken("secret", inspectCaches))
+	router.DELETE("/caches/datacache/:id", requireAdminToken("secret", invalidateDataCache))
+	router.POST("/caches/refreshers/:name", requireAdminToken("secret", refreshNow))
+	serve := func(method string, path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("GET", "/caches", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Requests without the token should be rejected. Got status %d", rr.Code)
+	}
+	if rr := serve("GET", "/caches", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Requests with the wrong token should be rejected. Got status %d", rr.Code)
+	}
+	if rr := serve("GET", "/caches", "secret"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"name":"currency"`) {
+		t.Errorf("Expected the refresher statuses. Got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("DELETE", "/caches/datacache/1001", "secret"); rr.Code != http.StatusNotImplemented {
+		t.Errorf("The dummy cache can't be invalidated. Got status %d", rr.Code)
+	}
+	if rr := serve("POST", "/caches/refreshers/currency", "secret"); rr.Code != http.StatusOK || fetches != 1 {
+		t.Errorf("The currency rates should be fetched. Got %d after %d fetches", rr.Code, fetches)
+	}
+	if rr := serve("POST", "/caches/refreshers/unknown", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown refreshers should be a 404. Got status %d", rr.Code)
+	}
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -46,6 +46,33 @@ func GetIP(r *http.Request) string {
 	return ""
 }
 
+// GetIPs is like GetIP, but sorts the address into an IPv4 or IPv6 one, for device.ip and device.ipv6.
+// IPv4-mapped IPv6 addresses (::ffff:a.b.c.d) count as IPv4. Both are empty if the address can't be parsed.
+func GetIPs(r *http.Request) (ipv4 string, ipv6 string) {
+	return SplitIP(GetIP(r))
+}
+
+// SplitIP returns the address as an IPv4 or IPv6 one, in canonical form. IPv6 addresses may be
+// in brackets, with a port, like "[2001:db8::1]:443".
+func SplitIP(address string) (ipv4 string, ipv6 string) {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(address, "[") {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		} else {
+			address = strings.Trim(address, "[]")
+		}
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String(), ""
+	}
+	return "", ip.String()
+}
+
 // GetForwardedIP will return back X-Forwarded-For or X-Real-IP (if set)
 func GetForwardedIP(r *http.Request) string {
 	// first attempt to parse X-Forwarded-For
@@ -63,11 +90,11 @@ func GetForwardedIP(r *http.Request) string {
 func getForwardedFor(r *http.Request) string {
 	if xff := r.Header.Get(xForwardedFor); xff != "" {
 		// X-Forwarded-For: client1, proxy1, proxy2
-		i := strings.Index(xff, ", ")
+		i := strings.Index(xff, ",")
 		if i == -1 {
 			i = len(xff)
 		}
-		return xff[:i]
+		return strings.TrimSpace(xff[:i])
 	}
 	return ""
 }
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"golang.org/x/net/context/ctxhttp"
 )
@@ -13,6 +14,8 @@ import (
 type CacheObject struct {
 	Value *BidCache
 	UUID  string
+	// TTLSeconds is how long the cache should keep the object. If zero, the cache uses its own default.
+	TTLSeconds int64
 }
 
 type BidCache struct {
@@ -24,8 +27,9 @@ type BidCache struct {
 
 // internal protocol objects
 type putObject struct {
-	Type  string  `json:"type"`
-	Value *BidCache `json:"value"`
+	Type       string    `json:"type"`
+	Value      *BidCache `json:"value"`
+	TTLSeconds int64     `json:"ttlseconds,omitempty"`
 }
 
 type putRequest struct {
@@ -40,15 +44,18 @@ type response struct {
 }
 
 var (
-	client  *http.Client
-	baseURL string
-	putURL  string
+	client       *http.Client
+	baseURL      string
+	putURL       string
+	retryTimeout time.Duration
 )
 
-// InitPrebidCache setup the global prebid cache
-func InitPrebidCache(baseurl string) {
+// InitPrebidCache setup the global prebid cache. Puts which get a 5xx are retried once, with at most
+// retrytimeout to finish in. If it's zero, they aren't retried.
+func InitPrebidCache(baseurl string, retrytimeout time.Duration) {
 	baseURL = baseurl
 	putURL = fmt.Sprintf("%s/cache", baseURL)
+	retryTimeout = retrytimeout
 
 	ts := &http.Transport{
 		MaxIdleConns:    10,
@@ -60,12 +67,16 @@ func InitPrebidCache(baseurl string) {
 	}
 }
 
-// Put will send the array of objs and update each with a UUID
+// Put will send the array of objs in a single request, and update each with a UUID
 func Put(ctx context.Context, objs []*CacheObject) error {
+	if len(objs) == 0 {
+		return nil
+	}
 	pr := putRequest{Puts: make([]putObject, len(objs))}
 	for i, obj := range objs {
 		pr.Puts[i].Type = "json"
 		pr.Puts[i].Value = obj.Value
+		pr.Puts[i].TTLSeconds = obj.TTLSeconds
 	}
 	// Don't want to escape the HTML for adm and nurl
 	buf := new(bytes.Buffer)
@@ -76,35 +87,54 @@ func Put(ctx context.Context, objs []*CacheObject) error {
 		return err
 	}
 
-	httpReq, err := http.NewRequest("POST", putURL, buf)
+	body := buf.Bytes()
+	resp, status, err := doPut(ctx, body)
+	if err == nil && status >= 500 && retryTimeout > 0 && ctx.Err() == nil {
+		// The retry has to fit in what's left of the caller's deadline, as well as its own.
+		retryCtx, cancel := context.WithTimeout(ctx, retryTimeout)
+		defer cancel()
+		resp, status, err = doPut(retryCtx, body)
+	}
 	if err != nil {
 		return err
 	}
+	if status != 200 {
+		return fmt.Errorf("HTTP status code %d", status)
+	}
+
+	if len(resp.Responses) != len(objs) {
+		return fmt.Errorf("Put response length didn't match")
+	}
+
+	for i, r := range resp.Responses {
+		objs[i].UUID = r.UUID
+	}
+
+	return nil
+}
+
+// doPut sends the body to Prebid Cache. The response is only decoded if the status is 200.
+func doPut(ctx context.Context, body []byte) (*response, int, error) {
+	httpReq, err := http.NewRequest("POST", putURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
 	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
 	httpReq.Header.Add("Accept", "application/json")
 
 	anResp, err := ctxhttp.Do(ctx, client, httpReq)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	defer anResp.Body.Close()
 
 	if anResp.StatusCode != 200 {
-		return fmt.Errorf("HTTP status code %d", anResp.StatusCode)
+		return nil, anResp.StatusCode, nil
 	}
 
 	var resp response
 	if err := json.NewDecoder(anResp.Body).Decode(&resp); err != nil {
-		return err
-	}
-
-	if len(resp.Responses) != len(objs) {
-		return fmt.Errorf("Put response length didn't match")
+		return nil, anResp.StatusCode, err
 	}
-
-	for i, r := range resp.Responses {
-		objs[i].UUID = r.UUID
-	}
-
-	return nil
+	return &resp, anResp.StatusCode, nil
 }
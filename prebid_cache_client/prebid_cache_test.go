@@ -109,7 +109,7 @@ func TestPrebidClient(t *testing.T) {
 				},
 	}
 
-	InitPrebidCache(server.URL)
+	InitPrebidCache(server.URL, 0)
 
 	ctx := context.TODO()
 	err := Put(ctx, cobj)
@@ -136,3 +136,73 @@ func TestPrebidClient(t *testing.T) {
 		t.Fatalf("pbc put succeeded but should have timed out")
 	}
 }
+
+func TestPutTTL(t *testing.T) {
+	var ttls []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var put struct {
+			Puts []struct {
+				TTLSeconds *int64 `json:"ttlseconds"`
+			} `json:"puts"`
+		}
+		json.NewDecoder(r.Body).Decode(&put)
+		for _, p := range put.Puts {
+			if p.TTLSeconds == nil {
+				ttls = append(ttls, -1)
+			} else {
+				ttls = append(ttls, *p.TTLSeconds)
+			}
+		}
+		w.Write([]byte(`{"responses":[{"uuid":"a"},{"uuid":"b"}]}`))
+	}))
+	defer server.Close()
+	InitPrebidCache(server.URL, 0)
+
+	err := Put(context.Background(), []*CacheObject{
+		{Value: &BidCache{Adm: "<div></div>"}, TTLSeconds: 300},
+		{Value: &BidCache{Adm: "<div></div>"}},
+	})
+	if err != nil {
+		t.Fatalf("pbc put failed: %v", err)
+	}
+	if len(ttls) != 2 || ttls[0] != 300 || ttls[1] != -1 {
+		t.Errorf("Expected a ttlseconds of 300, and then none. Got %v", ttls)
+	}
+}
+
+func TestPutRetry(t *testing.T) {
+	var calls int
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"responses":[{"uuid":"a"}]}`))
+	}))
+	defer server.Close()
+	objs := []*CacheObject{{Value: &BidCache{Adm: "<div></div>"}}}
+
+	InitPrebidCache(server.URL, 0)
+	if err := Put(context.Background(), objs); err == nil || calls != 1 {
+		t.Errorf("Puts shouldn't be retried without a retry timeout. Got %d calls and error %v", calls, err)
+	}
+
+	calls = 0
+	InitPrebidCache(server.URL, 50*time.Millisecond)
+	if err := Put(context.Background(), objs); err != nil || calls != 2 || objs[0].UUID != "a" {
+		t.Errorf("Expected a 5xx to be retried once. Got %d calls, UUID %q and error %v", calls, objs[0].UUID, err)
+	}
+
+	calls = 0
+	status = http.StatusBadRequest
+	if err := Put(context.Background(), objs); err == nil || calls != 1 {
+		t.Errorf("Only 5xx responses should be retried. Got %d calls and error %v", calls, err)
+	}
+
+	calls = 0
+	if err := Put(context.Background(), nil); err != nil || calls != 0 {
+		t.Errorf("Empty puts shouldn't call Prebid Cache. Got %d calls and error %v", calls, err)
+	}
+}
@@ -0,0 +1,130 @@
+package priceencryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbs"
+)
+
+// NewEncrypters makes a pbs.PriceEncrypter for each bidder with price encryption configured.
+// The map is keyed by lowercase bidder code.
+func NewEncrypters(cfg map[string]config.BidderMacros) (map[string]pbs.PriceEncrypter, error) {
+	encrypters := make(map[string]pbs.PriceEncrypter, len(cfg))
+	for bidder, macros := range cfg {
+		if macros.PriceEncryption.Scheme == "" {
+			continue
+		}
+		encrypter, err := New(macros.PriceEncryption)
+		if err != nil {
+			return nil, fmt.Errorf("bidder_macros.%s.price_encryption: %v", bidder, err)
+		}
+		encrypters[strings.ToLower(bidder)] = encrypter
+	}
+	return encrypters, nil
+}
+
+// New makes a pbs.PriceEncrypter for the configured scheme.
+func New(cfg config.PriceEncryption) (pbs.PriceEncrypter, error) {
+	encryptionKey, err := decodeKey(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption_key: %v", err)
+	}
+
+	switch cfg.Scheme {
+	case "hmac-sha1":
+		integrityKey, err := decodeKey(cfg.IntegrityKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integrity_key: %v", err)
+		}
+		return &hmacEncrypter{encryptionKey: encryptionKey, integrityKey: integrityKey}, nil
+	case "aes-gcm":
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption_key: %v", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		return &aesEncrypter{gcm: gcm}, nil
+	}
+	return nil, fmt.Errorf("unknown scheme %q", cfg.Scheme)
+}
+
+func decodeKey(key string) ([]byte, error) {
+	decoded, err := base64.URLEncoding.DecodeString(key)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("the key is empty")
+	}
+	return decoded, nil
+}
+
+// hmacEncrypter implements the DoubleClick-style price encryption scheme.
+//
+// The price is encoded as micros in an 8-byte big-endian integer, and XOR'ed with the first 8 bytes
+// of HMAC-SHA1(encryptionKey, iv). The first 4 bytes of HMAC-SHA1(integrityKey, price || iv) are
+// appended as a signature. The result is web-safe base64 of iv || encrypted price || signature.
+type hmacEncrypter struct {
+	encryptionKey []byte
+	integrityKey  []byte
+}
+
+func (e *hmacEncrypter) EncryptPrice(price float64) (string, error) {
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	return e.encrypt(price, iv), nil
+}
+
+func (e *hmacEncrypter) encrypt(price float64, iv []byte) string {
+	plaintext := make([]byte, 8)
+	binary.BigEndian.PutUint64(plaintext, uint64(price*1000000+0.5))
+
+	pad := hmac.New(sha1.New, e.encryptionKey)
+	pad.Write(iv)
+	encrypted := make([]byte, 8)
+	for i, b := range pad.Sum(nil)[:8] {
+		encrypted[i] = plaintext[i] ^ b
+	}
+
+	signature := hmac.New(sha1.New, e.integrityKey)
+	signature.Write(plaintext)
+	signature.Write(iv)
+
+	result := make([]byte, 0, 28)
+	result = append(result, iv...)
+	result = append(result, encrypted...)
+	result = append(result, signature.Sum(nil)[:4]...)
+	return base64.RawURLEncoding.EncodeToString(result)
+}
+
+// aesEncrypter seals the decimal price with AES-GCM. The result is web-safe base64 of nonce || ciphertext.
+type aesEncrypter struct {
+	gcm cipher.AEAD
+}
+
+func (e *aesEncrypter) EncryptPrice(price float64) (string, error) {
+	nonce := make([]byte, e.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := e.gcm.Seal(nonce, nonce, []byte(strconv.FormatFloat(price, 'f', -1, 64)), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
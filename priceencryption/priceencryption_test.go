@@ -0,0 +1,129 @@
+package priceencryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+var (
+	testEncryptionKey = base64.URLEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testIntegrityKey  = base64.URLEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+// decryptHMAC is the bidder's side of the hmac-sha1 scheme.
+func decryptHMAC(t *testing.T, encoded string) float64 {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("The price isn't web-safe base64: %v", err)
+	}
+	if len(data) != 28 {
+		t.Fatalf("Expected 28 bytes. Got %d", len(data))
+	}
+	iv, encrypted, signature := data[:16], data[16:24], data[24:]
+
+	pad := hmac.New(sha1.New, []byte("0123456789abcdef0123456789abcdef"))
+	pad.Write(iv)
+	plaintext := make([]byte, 8)
+	for i, b := range pad.Sum(nil)[:8] {
+		plaintext[i] = encrypted[i] ^ b
+	}
+
+	mac := hmac.New(sha1.New, []byte("fedcba9876543210fedcba9876543210"))
+	mac.Write(plaintext)
+	mac.Write(iv)
+	if !bytes.Equal(mac.Sum(nil)[:4], signature) {
+		t.Fatalf("Bad integrity signature")
+	}
+	return float64(binary.BigEndian.Uint64(plaintext)) / 1000000
+}
+
+func TestHMACRoundTrip(t *testing.T) {
+	encrypter, err := New(config.PriceEncryption{
+		Scheme:        "hmac-sha1",
+		EncryptionKey: testEncryptionKey,
+		IntegrityKey:  testIntegrityKey,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	encrypted, err := encrypter.EncryptPrice(1.234)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if price := decryptHMAC(t, encrypted); price != 1.234 {
+		t.Errorf("Expected the price to decrypt to 1.234. Got %f", price)
+	}
+
+	again, _ := encrypter.EncryptPrice(1.234)
+	if again == encrypted {
+		t.Errorf("Each encryption should use a fresh initialization vector")
+	}
+}
+
+func TestAESRoundTrip(t *testing.T) {
+	encrypter, err := New(config.PriceEncryption{
+		Scheme:        "aes-gcm",
+		EncryptionKey: testEncryptionKey,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	encrypted, err := encrypter.EncryptPrice(0.5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("The price isn't web-safe base64: %v", err)
+	}
+	gcm := encrypter.(*aesEncrypter).gcm
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if string(plaintext) != "0.5" {
+		t.Errorf("Expected the price to decrypt to 0.5. Got %s", string(plaintext))
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	bad := map[string]config.PriceEncryption{
+		"unknown scheme":    {Scheme: "rot13", EncryptionKey: testEncryptionKey},
+		"missing key":       {Scheme: "aes-gcm"},
+		"bad key length":    {Scheme: "aes-gcm", EncryptionKey: base64.URLEncoding.EncodeToString([]byte("short"))},
+		"missing integrity": {Scheme: "hmac-sha1", EncryptionKey: testEncryptionKey},
+	}
+	for name, cfg := range bad {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestNewEncrypters(t *testing.T) {
+	encrypters, err := NewEncrypters(map[string]config.BidderMacros{
+		"appnexus": {Enabled: true},
+		"pubmatic": {
+			Enabled: true,
+			PriceEncryption: config.PriceEncryption{
+				Scheme:        "aes-gcm",
+				EncryptionKey: testEncryptionKey,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(encrypters) != 1 || encrypters["pubmatic"] == nil {
+		t.Errorf("Expected an encrypter for pubmatic only. Got %v", encrypters)
+	}
+}
@@ -0,0 +1,109 @@
+package quarantine
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+)
+
+// Log keeps sampled copies of the responses which bidders sent back, but we couldn't parse.
+// This lets us diagnose broken integrations without turning on debug for everyone.
+//
+// A nil *Log is valid, and captures nothing.
+type Log struct {
+	mutex      sync.Mutex
+	out        io.Writer
+	sampleRate float64
+	maxBytes   int
+}
+
+type entry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Bidder    string    `json:"bidder"`
+	Error     string    `json:"error"`
+	Payload   string    `json:"payload"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+var scrubbers = []*regexp.Regexp{
+	// Email addresses
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	// IPv4 addresses
+	regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+	// Device IDs, and any other UUIDs
+	regexp.MustCompile(`\b[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\b`),
+}
+
+const redacted = "[REDACTED]"
+
+// New returns a Log which appends to the file in the config, or nil if the quarantine is disabled.
+func New(cfg config.Quarantine) (*Log, error) {
+	if cfg.Filename == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(cfg.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{
+		out:        file,
+		sampleRate: cfg.SampleRate,
+		maxBytes:   cfg.MaxBytes,
+	}, nil
+}
+
+// Capture logs the bidder's payload, if this call is sampled.
+func (l *Log) Capture(bidder string, cause error, payload []byte) {
+	if l == nil || rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	e := entry{
+		Timestamp: time.Now(),
+		Bidder:    bidder,
+		Error:     cause.Error(),
+		Payload:   Scrub(payload),
+	}
+	if l.maxBytes > 0 && len(e.Payload) > l.maxBytes {
+		e.Payload = truncate(e.Payload, l.maxBytes)
+		e.Truncated = true
+	}
+
+	b, err := json.Marshal(&e)
+	if err != nil {
+		glog.Errorf("Failed to marshal quarantined response from %s: %v", bidder, err)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.out.Write(append(b, '\n')); err != nil {
+		glog.Errorf("Failed to write quarantined response from %s: %v", bidder, err)
+	}
+}
+
+// truncate cuts the payload to at most maxBytes, without splitting a UTF-8 character. Invalid payloads are
+// cut at most utf8.UTFMax-1 bytes short.
+func truncate(payload string, maxBytes int) string {
+	end := maxBytes
+	for i := 0; i < utf8.UTFMax-1 && end > 0 && !utf8.RuneStart(payload[end]); i++ {
+		end--
+	}
+	return payload[:end]
+}
+
+// Scrub removes anything from the payload which looks like it could identify a user.
+func Scrub(payload []byte) string {
+	for _, scrubber := range scrubbers {
+		payload = scrubber.ReplaceAll(payload, []byte(redacted))
+	}
+	return string(payload)
+}
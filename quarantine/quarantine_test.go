@@ -0,0 +1,92 @@
+package quarantine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestDisabled(t *testing.T) {
+	l, err := New(config.Quarantine{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if l != nil {
+		t.Fatalf("The quarantine should be disabled without a filename")
+	}
+	// This shouldn't panic
+	l.Capture("appnexus", errors.New("bad json"), []byte("{"))
+}
+
+func TestCapture(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Log{out: &buf, sampleRate: 1, maxBytes: 20}
+	l.Capture("appnexus", errors.New("bad json"), []byte(`{"ip":"192.168.0.1","seatbid":[`))
+
+	var e entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("Failed to unmarshal the quarantined entry: %v", err)
+	}
+	if e.Bidder != "appnexus" || e.Error != "bad json" {
+		t.Errorf("Unexpected entry: %#v", e)
+	}
+	if !e.Truncated || len(e.Payload) != 20 {
+		t.Errorf("The payload should be truncated to 20 bytes. Got %q", e.Payload)
+	}
+	if bytes.Contains([]byte(e.Payload), []byte("192.168")) {
+		t.Errorf("IP addresses should be scrubbed. Got %q", e.Payload)
+	}
+}
+
+func TestCaptureTruncatesAtRuneBoundary(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Log{out: &buf, sampleRate: 1, maxBytes: 11}
+	// "é" is 2 bytes, so the 11th byte is the first half of one.
+	l.Capture("appnexus", errors.New("bad json"), []byte(`{"t":"ééééé"}`))
+
+	var e entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("Failed to unmarshal the quarantined entry: %v", err)
+	}
+	if e.Payload != `{"t":"éé` || !e.Truncated {
+		t.Errorf("The payload should be cut before the split character. Got %q", e.Payload)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		payload  string
+		maxBytes int
+		expected string
+	}{
+		{"abcdef", 3, "abc"},
+		{"a€b", 2, "a"},
+		{"a€b", 4, "a€"},
+		{"\x80\x80\x80\x80\x80", 4, "\x80"},
+	}
+	for _, test := range tests {
+		if truncated := truncate(test.payload, test.maxBytes); truncated != test.expected {
+			t.Errorf("truncate(%q, %d): expected %q. Got %q", test.payload, test.maxBytes, test.expected, truncated)
+		}
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Log{out: &buf, sampleRate: 0}
+	l.Capture("appnexus", errors.New("bad json"), []byte("{"))
+	if buf.Len() != 0 {
+		t.Errorf("Nothing should be captured with a sample rate of 0")
+	}
+}
+
+func TestScrub(t *testing.T) {
+	scrubbed := Scrub([]byte(`{"email":"someone@example.com","ifa":"8D2D3E6A-2B4C-4E1F-9A3B-0C1D2E3F4A5B","ip":"10.0.0.1"}`))
+	expected := `{"email":"[REDACTED]","ifa":"[REDACTED]","ip":"[REDACTED]"}`
+	if scrubbed != expected {
+		t.Errorf("Expected %s. Got %s", expected, scrubbed)
+	}
+}
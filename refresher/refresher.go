@@ -0,0 +1,188 @@
+package refresher
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rcrowley/go-metrics"
+)
+
+// FetchFunc loads the latest version of some remote data, like currency rates or a vendor list.
+type FetchFunc func() (interface{}, error)
+
+// Refresher keeps a copy of some remote data up to date in the background.
+//
+// Readers are never blocked on a fetch. Data which is older than the refresh interval is still
+// served, while a new copy is fetched asynchronously. Once the data gets older than the hard-stale
+// threshold, Get keeps returning it, but with a StaleError so that callers can decide whether
+// it's still safe to use. It's safe for concurrent use.
+type Refresher struct {
+	name      string
+	fetch     FetchFunc
+	interval  time.Duration
+	hardStale time.Duration
+
+	mutex   sync.RWMutex
+	data    interface{}
+	fetched time.Time
+	lastErr error
+
+	refreshing int32
+
+	ageGauge   metrics.Gauge
+	errorMeter metrics.Meter
+}
+
+// StaleError is returned alongside data which is older than the hard-stale threshold.
+type StaleError struct {
+	Name string
+	Age  time.Duration
+}
+
+func (err *StaleError) Error() string {
+	return fmt.Sprintf("%s data is %v old, which is past its hard-stale threshold", err.Name, err.Age)
+}
+
+// ErrNoData is returned by Get if no fetch has ever succeeded.
+var ErrNoData = errors.New("no data has been fetched yet")
+
+// Status describes how fresh a Refresher's data is. It's served by the /status endpoint.
+type Status struct {
+	Name       string `json:"name"`
+	AgeSeconds int64  `json:"age_seconds"`
+	Stale      bool   `json:"stale"`
+	HardStale  bool   `json:"hard_stale"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// New makes a Refresher. A hardStale of 0 means that the data never becomes too old to use.
+//
+// The age of the data is reported to the registry as refresh.{name}.age_seconds, and failed
+// fetches as refresh.{name}.errors.
+func New(name string, interval time.Duration, hardStale time.Duration, fetch FetchFunc, registry metrics.Registry) *Refresher {
+	return &Refresher{
+		name:       name,
+		fetch:      fetch,
+		interval:   interval,
+		hardStale:  hardStale,
+		ageGauge:   metrics.GetOrRegisterGauge(fmt.Sprintf("refresh.%s.age_seconds", name), registry),
+		errorMeter: metrics.GetOrRegisterMeter(fmt.Sprintf("refresh.%s.errors", name), registry),
+	}
+}
+
+// Start fetches the data once, and then keeps refreshing it every interval until done is closed.
+// The background refreshes are started even if the first fetch fails, so the error returned here
+// is only informative.
+func (r *Refresher) Start(done <-chan struct{}) error {
+	err := r.refresh()
+	if r.interval > 0 {
+		go r.loop(done)
+	}
+	return err
+}
+
+func (r *Refresher) loop(done <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.refreshAsync()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Get returns the latest data which was fetched successfully.
+func (r *Refresher) Get() (interface{}, error) {
+	r.mutex.RLock()
+	data, fetched := r.data, r.fetched
+	r.mutex.RUnlock()
+
+	if fetched.IsZero() {
+		r.refreshAsync()
+		return nil, ErrNoData
+	}
+
+	age := time.Since(fetched)
+	r.ageGauge.Update(int64(age / time.Second))
+	if r.interval > 0 && age > r.interval {
+		r.refreshAsync()
+	}
+	if r.hardStale > 0 && age > r.hardStale {
+		return data, &StaleError{Name: r.name, Age: age}
+	}
+	return data, nil
+}
+
+// Name returns the name which the Refresher reports its metrics and status under.
+func (r *Refresher) Name() string {
+	return r.name
+}
+
+// Refresh fetches the data right away, and waits for it. Admins use it after fixing the remote data,
+// rather than waiting for the next interval.
+func (r *Refresher) Refresh() error {
+	return r.refresh()
+}
+
+// Status reports how fresh the data is right now.
+func (r *Refresher) Status() Status {
+	r.mutex.RLock()
+	fetched, lastErr := r.fetched, r.lastErr
+	r.mutex.RUnlock()
+
+	status := Status{Name: r.name}
+	if lastErr != nil {
+		status.LastError = lastErr.Error()
+	}
+	if fetched.IsZero() {
+		status.Stale = true
+		status.HardStale = r.hardStale > 0
+		return status
+	}
+
+	age := time.Since(fetched)
+	status.AgeSeconds = int64(age / time.Second)
+	status.Stale = r.interval > 0 && age > r.interval
+	status.HardStale = r.hardStale > 0 && age > r.hardStale
+	return status
+}
+
+// refreshAsync starts a refresh in the background, unless one is already running.
+func (r *Refresher) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&r.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&r.refreshing, 0)
+		r.refresh()
+	}()
+}
+
+func (r *Refresher) refresh() error {
+	data, err := r.fetch()
+
+	r.mutex.Lock()
+	r.lastErr = err
+	if err == nil {
+		r.data = data
+		r.fetched = time.Now()
+	}
+	fetched := r.fetched
+	r.mutex.Unlock()
+
+	if err != nil {
+		r.errorMeter.Mark(1)
+		glog.Warningf("Failed to refresh %s data: %v", r.name, err)
+	}
+	if !fetched.IsZero() {
+		r.ageGauge.Update(int64(time.Since(fetched) / time.Second))
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package refresher
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestStartFetchesImmediately(t *testing.T) {
+	r := New("test", time.Hour, 0, func() (interface{}, error) { return "rates", nil }, metrics.NewRegistry())
+	if err := r.Start(nil); err != nil {
+		t.Fatalf("Unexpected error on the first fetch: %v", err)
+	}
+
+	data, err := r.Get()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if data != "rates" {
+		t.Errorf("Expected the fetched data. Got %v", data)
+	}
+}
+
+func TestGetWithoutData(t *testing.T) {
+	r := New("test", time.Hour, 0, func() (interface{}, error) { return nil, errors.New("unreachable") }, metrics.NewRegistry())
+	if err := r.Start(nil); err == nil {
+		t.Errorf("The first fetch error should be returned")
+	}
+	if _, err := r.Get(); err != ErrNoData {
+		t.Errorf("Expected ErrNoData. Got %v", err)
+	}
+	if status := r.Status(); !status.Stale || status.LastError != "unreachable" {
+		t.Errorf("Status should report the missing data. Got %#v", status)
+	}
+}
+
+func TestServesStaleDataWhileRefreshing(t *testing.T) {
+	var calls int32
+	fetched := make(chan struct{}, 1)
+	r := New("test", time.Minute, 0, func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			defer func() { fetched <- struct{}{} }()
+			return "new", nil
+		}
+		return "old", nil
+	}, metrics.NewRegistry())
+	r.Start(nil)
+	r.fetched = r.fetched.Add(-2 * time.Minute)
+
+	data, err := r.Get()
+	if err != nil {
+		t.Errorf("Stale data shouldn't be an error: %v", err)
+	}
+	if data != "old" {
+		t.Errorf("Stale data should be served while refreshing. Got %v", data)
+	}
+
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatalf("Stale data should trigger a background refresh")
+	}
+	waitForRefresh(r)
+
+	if data, _ := r.Get(); data != "new" {
+		t.Errorf("Expected the refreshed data. Got %v", data)
+	}
+}
+
+func TestHardStale(t *testing.T) {
+	r := New("currency", time.Minute, time.Hour, func() (interface{}, error) { return "rates", nil }, metrics.NewRegistry())
+	r.Start(nil)
+	r.fetch = func() (interface{}, error) { return nil, errors.New("unreachable") }
+	r.fetched = r.fetched.Add(-2 * time.Hour)
+
+	data, err := r.Get()
+	if data != "rates" {
+		t.Errorf("Hard-stale data should still be returned. Got %v", data)
+	}
+	if _, ok := err.(*StaleError); !ok {
+		t.Errorf("Expected a StaleError. Got %v", err)
+	}
+	waitForRefresh(r)
+
+	status := r.Status()
+	if !status.Stale || !status.HardStale {
+		t.Errorf("Status should report hard-stale data. Got %#v", status)
+	}
+	if status.AgeSeconds < 7200 {
+		t.Errorf("Expected an age of at least 7200 seconds. Got %d", status.AgeSeconds)
+	}
+	if status.LastError != "unreachable" {
+		t.Errorf("Status should report the last fetch error. Got %s", status.LastError)
+	}
+}
+
+func TestAgeMetric(t *testing.T) {
+	registry := metrics.NewRegistry()
+	r := New("gvl", time.Hour, 0, func() (interface{}, error) { return "vendors", nil }, registry)
+	r.Start(nil)
+	r.fetched = r.fetched.Add(-time.Minute)
+	r.Get()
+
+	if age := metrics.GetOrRegisterGauge("refresh.gvl.age_seconds", registry).Value(); age < 60 {
+		t.Errorf("Expected an age of at least 60 seconds. Got %d", age)
+	}
+}
+
+func waitForRefresh(r *Refresher) {
+	for i := 0; i < 100 && atomic.LoadInt32(&r.refreshing) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	var calls int32
+	r := New("test", time.Hour, 0, func() (interface{}, error) { return atomic.AddInt32(&calls, 1), nil }, metrics.NewRegistry())
+	r.Start(nil)
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, _ := r.Get(); data != int32(2) {
+		t.Errorf("Refresh should fetch right away. Got %v", data)
+	}
+	if r.Name() != "test" {
+		t.Errorf("Unexpected name %s", r.Name())
+	}
+}
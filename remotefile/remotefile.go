@@ -0,0 +1,145 @@
+package remotefile
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prebid/prebid-server/refresher"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Fetcher downloads a single remote file, like a category mapping, floors file or vendor list.
+//
+// It remembers the ETag and Last-Modified headers of the last download, so that refreshes of an
+// unchanged file cost a 304 rather than the whole body. It's safe for concurrent use.
+type Fetcher struct {
+	client *http.Client
+	url    string
+
+	mutex        sync.Mutex
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// New makes a Fetcher for the file at location.
+//
+// Besides http(s) URLs, this accepts s3://bucket/key and gs://bucket/object. These are fetched
+// over HTTPS from the storage provider, so the objects must be public or the location must be
+// a pre-signed URL.
+func New(client *http.Client, location string) (*Fetcher, error) {
+	fileURL, err := resolveLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Fetcher{
+		client: client,
+		url:    fileURL,
+	}, nil
+}
+
+func resolveLocation(location string) (string, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote file location %s: %v", location, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return location, nil
+	case "s3":
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", parsed.Host, strings.TrimPrefix(parsed.Path, "/")), nil
+	case "gs":
+		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", parsed.Host, strings.TrimPrefix(parsed.Path, "/")), nil
+	}
+	return "", fmt.Errorf("unsupported remote file location %s", location)
+}
+
+// Fetch returns the current contents of the file. The changed flag is false if the server
+// reported that the file hasn't changed since the last successful Fetch.
+func (f *Fetcher) Fetch(ctx context.Context) (body []byte, changed bool, err error) {
+	httpReq, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f.mutex.Lock()
+	if f.etag != "" {
+		httpReq.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		httpReq.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	f.mutex.Unlock()
+
+	resp, err := ctxhttp.Do(ctx, f.client, httpReq)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		return f.body, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("GET %s returned HTTP status %d", f.url, resp.StatusCode)
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f.mutex.Lock()
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.body = body
+	f.mutex.Unlock()
+
+	return body, true, nil
+}
+
+// FetchFunc adapts the Fetcher so that a refresher.Refresher can keep the parsed file up to date.
+// The file is only parsed again when it has changed. If the latest file couldn't be parsed, then
+// its error is returned until the file changes again.
+func (f *Fetcher) FetchFunc(timeout time.Duration, parse func([]byte) (interface{}, error)) refresher.FetchFunc {
+	var (
+		mutex    sync.Mutex
+		parsed   interface{}
+		parseErr error
+	)
+	return func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		body, changed, err := f.Fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if changed || (parsed == nil && parseErr == nil) {
+			data, err := parse(body)
+			if err != nil {
+				parsed, parseErr = nil, fmt.Errorf("failed to parse %s: %v", f.url, err)
+			} else {
+				parsed, parseErr = data, nil
+			}
+		}
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		return parsed, nil
+	}
+}
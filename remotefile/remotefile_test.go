@@ -0,0 +1,151 @@
+package remotefile
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newFileServer(etag string, body string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestFetchUsesETag(t *testing.T) {
+	requests := 0
+	server := newFileServer(`"v1"`, `{"IAB1":"Arts"}`, &requests)
+	defer server.Close()
+
+	f, err := New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	body, changed, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !changed || string(body) != `{"IAB1":"Arts"}` {
+		t.Errorf("The first fetch should return the file. Got %s", string(body))
+	}
+
+	body, changed, err = f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if changed {
+		t.Errorf("A 304 response should be reported as unchanged")
+	}
+	if string(body) != `{"IAB1":"Arts"}` {
+		t.Errorf("An unchanged file should return the previous body. Got %s", string(body))
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests. Got %d", requests)
+	}
+}
+
+func TestFetchBadStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	f, _ := New(server.Client(), server.URL)
+	if _, _, err := f.Fetch(context.Background()); err == nil {
+		t.Errorf("A 404 should be an error")
+	}
+}
+
+func TestFetchFuncParsesOnlyOnChange(t *testing.T) {
+	requests := 0
+	server := newFileServer(`"v1"`, `{"IAB1":"Arts"}`, &requests)
+	defer server.Close()
+
+	parses := 0
+	f, _ := New(server.Client(), server.URL)
+	fetch := f.FetchFunc(time.Second, func(body []byte) (interface{}, error) {
+		parses++
+		var categories map[string]string
+		err := json.Unmarshal(body, &categories)
+		return categories, err
+	})
+
+	for i := 0; i < 3; i++ {
+		data, err := fetch()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if data.(map[string]string)["IAB1"] != "Arts" {
+			t.Errorf("Bad parsed data: %v", data)
+		}
+	}
+	if parses != 1 {
+		t.Errorf("An unchanged file should only be parsed once. Got %d parses", parses)
+	}
+}
+
+func TestFetchFuncRemembersParseErrors(t *testing.T) {
+	etag, body := `"v1"`, `{"IAB1":"Arts"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	f, _ := New(server.Client(), server.URL)
+	fetch := f.FetchFunc(time.Second, func(body []byte) (interface{}, error) {
+		var categories map[string]string
+		err := json.Unmarshal(body, &categories)
+		return categories, err
+	})
+
+	if _, err := fetch(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	etag, body = `"v2"`, `{"IAB1":`
+	if _, err := fetch(); err == nil {
+		t.Errorf("An invalid file should be an error")
+	}
+	if data, err := fetch(); err == nil {
+		t.Errorf("An invalid file should still be an error after a 304. Got %v", data)
+	}
+	etag, body = `"v3"`, `{"IAB1":"Art"}`
+	if data, err := fetch(); err != nil || data.(map[string]string)["IAB1"] != "Art" {
+		t.Errorf("A fixed file should be parsed again. Got %v and %v", data, err)
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/floors.json": "https://example.com/floors.json",
+		"s3://my-bucket/path/floors.json": "https://my-bucket.s3.amazonaws.com/path/floors.json",
+		"gs://my-bucket/gvl/vendors.json": "https://storage.googleapis.com/my-bucket/gvl/vendors.json",
+	}
+	for location, expected := range tests {
+		resolved, err := resolveLocation(location)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", location, err)
+		}
+		if resolved != expected {
+			t.Errorf("Bad URL for %s. Expected %s, got %s", location, expected, resolved)
+		}
+	}
+
+	if _, err := resolveLocation("ftp://example.com/floors.json"); err == nil {
+		t.Errorf("Unsupported schemes should be an error")
+	}
+}
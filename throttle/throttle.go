@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+// Throttle limits the auctions which get sent to a single bidder. It's safe for concurrent use.
+type Throttle struct {
+	bidder string
+	// percent is nil if every auction is allowed.
+	percent *int
+	maxQPS  int
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	windowCount int
+}
+
+// NewThrottles makes a Throttle for each bidder in the config. The map is keyed by lowercase bidder code.
+// Percentages outside of 0 to 100 are an error.
+func NewThrottles(cfg map[string]config.Throttle) (map[string]*Throttle, error) {
+	throttles := make(map[string]*Throttle, len(cfg))
+	for bidder, settings := range cfg {
+		if settings.Percent != nil && (*settings.Percent < 0 || *settings.Percent > 100) {
+			return nil, fmt.Errorf("bidder_throttles.%s.percent must be between 0 and 100. Got %d", bidder, *settings.Percent)
+		}
+		bidder = strings.ToLower(bidder)
+		throttles[bidder] = New(bidder, settings)
+	}
+	return throttles, nil
+}
+
+// New makes a Throttle for the bidder.
+func New(bidder string, cfg config.Throttle) *Throttle {
+	return &Throttle{
+		bidder:  bidder,
+		percent: cfg.Percent,
+		maxQPS:  cfg.MaxQPS,
+	}
+}
+
+// Allow returns true if the auction with the given transaction ID should be sent to the bidder.
+//
+// Sampling is deterministic, so retries of the same auction get the same answer. Auctions
+// without a transaction ID are sampled randomly.
+func (t *Throttle) Allow(tid string) bool {
+	if t.percent != nil && *t.percent < 100 && t.sample(tid) >= *t.percent {
+		return false
+	}
+	return t.underMaxQPS(time.Now())
+}
+
+func (t *Throttle) sample(tid string) int {
+	if tid == "" {
+		return rand.Intn(100)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(t.bidder))
+	h.Write([]byte{0})
+	h.Write([]byte(tid))
+	return int(h.Sum32() % 100)
+}
+
+// underMaxQPS counts requests in one second windows, and refuses any beyond the limit.
+func (t *Throttle) underMaxQPS(now time.Time) bool {
+	if t.maxQPS <= 0 {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if now.Sub(t.windowStart) >= time.Second {
+		t.windowStart = now
+		t.windowCount = 0
+	}
+	if t.windowCount >= t.maxQPS {
+		return false
+	}
+	t.windowCount++
+	return true
+}
@@ -0,0 +1,83 @@
+package throttle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestNoLimits(t *testing.T) {
+	throttle := New("appnexus", config.Throttle{})
+	for i := 0; i < 100; i++ {
+		if !throttle.Allow(fmt.Sprintf("tid-%d", i)) {
+			t.Fatalf("Throttles without limits should allow everything")
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	throttle := New("appnexus", config.Throttle{Percent: percent(20)})
+	allowed := 0
+	for i := 0; i < 1000; i++ {
+		if throttle.Allow(fmt.Sprintf("tid-%d", i)) {
+			allowed++
+		}
+	}
+	if allowed < 150 || allowed > 250 {
+		t.Errorf("Expected about 200 of 1000 auctions to be allowed. Got %d", allowed)
+	}
+}
+
+func TestPercentIsDeterministic(t *testing.T) {
+	throttle := New("appnexus", config.Throttle{Percent: percent(50)})
+	for i := 0; i < 20; i++ {
+		tid := fmt.Sprintf("tid-%d", i)
+		if throttle.Allow(tid) != throttle.Allow(tid) {
+			t.Errorf("The same auction should always get the same answer")
+		}
+	}
+}
+
+func TestMaxQPS(t *testing.T) {
+	throttle := New("appnexus", config.Throttle{MaxQPS: 2})
+	now := time.Now()
+	if !throttle.underMaxQPS(now) || !throttle.underMaxQPS(now) {
+		t.Errorf("Requests under the limit should be allowed")
+	}
+	if throttle.underMaxQPS(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Requests over the limit should be refused")
+	}
+	if !throttle.underMaxQPS(now.Add(time.Second)) {
+		t.Errorf("The limit should reset every second")
+	}
+}
+
+func TestZeroPercent(t *testing.T) {
+	throttle := New("appnexus", config.Throttle{Percent: percent(0)})
+	for i := 0; i < 100; i++ {
+		if throttle.Allow(fmt.Sprintf("tid-%d", i)) {
+			t.Fatalf("Throttles at 0 percent should allow nothing")
+		}
+	}
+}
+
+func TestNewThrottles(t *testing.T) {
+	throttles, err := NewThrottles(map[string]config.Throttle{"indexExchange": {Percent: percent(10)}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := throttles["indexexchange"]; !ok {
+		t.Errorf("Throttles should be keyed by lowercase bidder code")
+	}
+	for _, bad := range []int{-1, 101} {
+		if _, err := NewThrottles(map[string]config.Throttle{"appnexus": {Percent: percent(bad)}}); err == nil {
+			t.Errorf("Expected an error for %d percent", bad)
+		}
+	}
+}
+
+func percent(p int) *int {
+	return &p
+}